@@ -0,0 +1,212 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backoff holds back requests to hosts that rate limit them, so that
+// concurrent fetches from one repository or registry back off together
+// rather than each finding out for itself.
+package backoff
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// Hosts tracks the hosts that rate limit requests, for every Transport made
+// from it, so that requests going through different clients, such as those
+// of the architectures of one build, back off together.
+//
+// A host that answered 429 Too Many Requests, or 503 with a Retry-After
+// header, has every request to it paused for as long as the header asks, up
+// to the maximum pause. Without one, the pause starts at its minimum and
+// doubles for each rate limited response in a row, up to its maximum, and a
+// response that is not rate limited resets it. The first time a host rate
+// limits requests a warning is logged; the rest is logged at debug level,
+// and summed up by LogSummary.
+type Hosts struct {
+	minPause, maxPause time.Duration
+	now                func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+type host struct {
+	// until is when requests to the host may resume.
+	until time.Time
+	// pause is the last pause chosen without a Retry-After header.
+	pause time.Duration
+	// limited counts the rate limited responses from the host.
+	limited int
+}
+
+// NewHosts returns Hosts that pause requests to a host that rate limits them
+// at least minPause and at most maxPause.
+func NewHosts(minPause, maxPause time.Duration) *Hosts {
+	return &Hosts{
+		minPause: minPause,
+		maxPause: max(minPause, maxPause),
+		now:      time.Now,
+		hosts:    map[string]*host{},
+	}
+}
+
+// Transport wraps inner so that its requests back off from the hosts that
+// rate limit requests through any Transport of h.
+func (h *Hosts) Transport(inner http.RoundTripper) *Transport {
+	return &Transport{inner: inner, hosts: h}
+}
+
+// Transport pauses requests to the hosts that rate limit them, see Hosts.
+//
+// Retrying the rate limited requests is left to the client.
+type Transport struct {
+	inner http.RoundTripper
+	hosts *Hosts
+}
+
+// NewTransport wraps inner so that requests back off from hosts that rate
+// limit them, pausing at least minPause and at most maxPause.
+func NewTransport(inner http.RoundTripper, minPause, maxPause time.Duration) *Transport {
+	return NewHosts(minPause, maxPause).Transport(inner)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if wait := t.hosts.wait(req.URL.Host); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), t.hosts.now())
+	if resp.StatusCode != http.StatusTooManyRequests && (resp.StatusCode != http.StatusServiceUnavailable || !hasRetryAfter) {
+		t.hosts.reset(req.URL.Host)
+		return resp, nil
+	}
+
+	pause, first := t.hosts.limit(req.URL.Host, retryAfter, hasRetryAfter)
+	log := clog.FromContext(ctx)
+	if first {
+		log.Warnf("%s is rate limiting requests, pausing requests to it for %s; further pauses are logged at debug level", req.URL.Host, pause)
+	} else {
+		log.Debugf("%s rate limited %s %s, pausing requests to it for %s", req.URL.Host, req.Method, req.URL.Redacted(), pause)
+	}
+	return resp, nil
+}
+
+// RateLimited returns how many responses each host rate limited, through any
+// Transport sharing the Hosts of t.
+func (t *Transport) RateLimited() map[string]int {
+	return t.hosts.RateLimited()
+}
+
+// wait returns how long requests to name have to wait.
+func (h *Hosts) wait(name string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.hosts[name]; ok {
+		return s.until.Sub(h.now())
+	}
+	return 0
+}
+
+// reset forgets the adaptive pause for name after a response that was not
+// rate limited. A pause the host asked for still stands.
+func (h *Hosts) reset(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.hosts[name]; ok {
+		s.pause = 0
+	}
+}
+
+// limit records a rate limited response from name, returning the pause
+// chosen and whether it is the first from name.
+func (h *Hosts) limit(name string, retryAfter time.Duration, hasRetryAfter bool) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.hosts[name]
+	if !ok {
+		s = &host{}
+		h.hosts[name] = s
+	}
+	s.limited++
+
+	// A host asking for longer than the maximum pause does not get to stall
+	// the build for it; the client's retries decide what happens next.
+	pause := min(retryAfter, h.maxPause)
+	if !hasRetryAfter {
+		s.pause = min(max(2*s.pause, h.minPause), h.maxPause)
+		pause = s.pause
+	}
+	// Concurrent requests may all be rate limited; keep the longest pause.
+	if until := h.now().Add(pause); until.After(s.until) {
+		s.until = until
+	}
+	return pause, s.limited == 1
+}
+
+// RateLimited returns how many responses each host rate limited.
+func (h *Hosts) RateLimited() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	limited := make(map[string]int, len(h.hosts))
+	for name, s := range h.hosts {
+		limited[name] = s.limited
+	}
+	return limited
+}
+
+// LogSummary logs a warning with how many responses each host that rate
+// limited requests rate limited, for the end of a build.
+func (h *Hosts) LogSummary(ctx context.Context) {
+	limited := h.RateLimited()
+	log := clog.FromContext(ctx)
+	for _, name := range slices.Sorted(maps.Keys(limited)) {
+		log.Warnf("%s rate limited %d requests", name, limited[name])
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
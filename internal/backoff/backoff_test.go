@@ -0,0 +1,150 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backoff
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport(t *testing.T) {
+	// The first two requests are rate limited, without a Retry-After.
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	tr := NewTransport(http.DefaultTransport, 50*time.Millisecond, time.Second)
+	client := &http.Client{Transport: tr}
+	get := func() (int, time.Duration) {
+		start := time.Now()
+		resp, err := client.Get(s.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode, time.Since(start)
+	}
+
+	status, _ := get()
+	require.Equal(t, http.StatusTooManyRequests, status)
+
+	// The next request waits for the pause, and is rate limited again, so
+	// the pause doubles.
+	status, took := get()
+	require.Equal(t, http.StatusTooManyRequests, status)
+	require.GreaterOrEqual(t, took, 50*time.Millisecond)
+
+	status, took = get()
+	require.Equal(t, http.StatusOK, status)
+	require.GreaterOrEqual(t, took, 100*time.Millisecond)
+
+	// Once through, requests go straight out again.
+	status, took = get()
+	require.Equal(t, http.StatusOK, status)
+	require.Less(t, took, 100*time.Millisecond)
+
+	require.Equal(t, map[string]int{strings.TrimPrefix(s.URL, "http://"): 2}, tr.RateLimited())
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport, time.Millisecond, 100*time.Millisecond)}
+	resp, err := client.Get(s.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// The host asked for a second, longer than the maximum pause, so the
+	// next request waits for the maximum only.
+	start := time.Now()
+	resp, err = client.Get(s.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestHostsShared(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	hosts := NewHosts(time.Second, time.Second)
+	first := &http.Client{Transport: hosts.Transport(http.DefaultTransport)}
+	second := &http.Client{Transport: hosts.Transport(http.DefaultTransport)}
+
+	resp, err := first.Get(s.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// The other client backs off from the host too.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	require.NoError(t, err)
+	_, err = second.Do(req) //nolint:bodyclose // There is no response.
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.EqualValues(t, 1, requests.Load())
+
+	require.Equal(t, map[string]int{strings.TrimPrefix(s.URL, "http://"): 1}, hosts.RateLimited())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"Wed, 01 Jan 2025 00:00:30 GMT", 30 * time.Second, true},
+		{"Tue, 31 Dec 2024 23:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		got, ok := parseRetryAfter(c.in, now)
+		require.Equal(t, c.ok, ok, c.in)
+		require.Equal(t, c.want, got, c.in)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// archFailures collects the failures of the architectures built concurrently,
+// so that all of them are reported rather than only the first.
+type archFailures struct {
+	mu   sync.Mutex
+	errs []*build.ArchError
+}
+
+// collect returns a function running fn to build arch, which records the
+// error fn returns, if any, instead of returning it.
+func (f *archFailures) collect(arch types.Architecture, fn func() error) func() error {
+	return func() error {
+		if err := fn(); err != nil {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.errs = append(f.errs, build.NewArchError(arch, err))
+		}
+		return nil
+	}
+}
+
+// reportArchErrors writes the failures of a build of more than one
+// architecture as a table to w, returning a short error in place of err.
+// Other errors are returned as they are.
+func reportArchErrors(w io.Writer, err error) error {
+	var merr *build.MultiArchError
+	if !errors.As(err, &merr) || len(merr.Errors) < 2 {
+		return err
+	}
+	if werr := writeArchErrors(w, merr); werr != nil {
+		return err
+	}
+	return fmt.Errorf("%d architectures failed", len(merr.Errors))
+}
+
+// writeArchErrors writes the failures in merr as a table, one per row.
+func writeArchErrors(w io.Writer, merr *build.MultiArchError) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ARCH\tCATEGORY\tERROR")
+	for _, e := range merr.Errors {
+		msg := strings.Join(strings.Fields(strings.ReplaceAll(e.Err.Error(), "\n", "; ")), " ")
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Arch, e.Category, msg)
+	}
+	return tw.Flush()
+}
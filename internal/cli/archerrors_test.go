@@ -0,0 +1,59 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestReportArchErrors(t *testing.T) {
+	var failures archFailures
+	for _, arch := range []string{"x86_64", "aarch64", "riscv64"} {
+		require.NoError(t, failures.collect(types.ParseArchitecture(arch), func() error {
+			switch arch {
+			case "x86_64":
+				return build.CategorizeError(build.ErrorCategoryResolve, errors.New("package foo not found\nrequired by: world"))
+			case "aarch64":
+				return build.CategorizeError(build.ErrorCategoryCheck, errors.New("dangling symlink /usr/bin/bar"))
+			}
+			return nil
+		})())
+	}
+	err := build.NewMultiArchError(failures.errs)
+	require.Error(t, err)
+
+	var out bytes.Buffer
+	require.EqualError(t, reportArchErrors(&out, fmt.Errorf("building: %w", err)), "2 architectures failed")
+	require.Equal(t, `ARCH   CATEGORY  ERROR
+amd64  resolve   package foo not found; required by: world
+arm64  check     dangling symlink /usr/bin/bar
+`, out.String())
+
+	// A single failure, or any other error, is returned as it is.
+	out.Reset()
+	one := build.NewMultiArchError(failures.errs[:1])
+	require.Equal(t, one, reportArchErrors(&out, one))
+	require.Empty(t, out.String())
+	other := errors.New("locking config")
+	require.Equal(t, other, reportArchErrors(&out, other))
+	require.Nil(t, reportArchErrors(&out, nil))
+}
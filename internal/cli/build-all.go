@@ -0,0 +1,433 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/authn/github"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
+
+	"github.com/chainguard-dev/clog"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/paths"
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+func buildAll() *cobra.Command {
+	var outputDir string
+	var repository string
+	var jobs int
+	var downloadJobs int
+	var report string
+	var withVCS bool
+	var buildDate string
+	var archstrs []string
+	var writeSBOM bool
+	var sbomFormats []string
+	var extraKeys []string
+	var extraBuildRepos []string
+	var extraRepos []string
+	var cacheDir string
+	var offline bool
+	var includePaths []string
+	var ignoreSignatures bool
+	var layerCache string
+
+	cmd := &cobra.Command{
+		Use:   "build-all <dir|glob>...",
+		Short: "Build many images from YAML configuration files in one process",
+		Long: `Build many images from YAML configuration files in one process.
+
+Each argument is either a directory, whose *.yaml and *.yml files are built,
+or a glob matching configuration files. The builds share the package index
+and package caches, and layers compressed by one build are reused by the
+others, so a monorepo of images pays for process startup and cache checks
+once. Concurrent fetches of the same index are made once, and downloads draw
+from a pool shared by every build.
+
+Up to --jobs images are built at once. A configuration that includes another
+one being built is only built after it, so their common packages are fetched
+once.
+
+Each image is written to <output-dir>/<name>/image.tar, along with its SBOMs,
+where <name> is the configuration file name without its .apko.yaml, .yaml or
+.yml extension, and is tagged <repository>/<name>:latest. A build that fails
+does not stop the others; every failure is reported at the end.`,
+		Example: `  apko build-all images/ --output-dir out/
+  apko build-all 'images/*.apko.yaml' --report report.json`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			configs, err := expandConfigs(args)
+			if err != nil {
+				return err
+			}
+			switch {
+			case !writeSBOM:
+				sbomFormats = []string{}
+			case !cmd.Flags().Changed("sbom-formats"):
+				// Leave the formats to the configuration, if it sets any.
+				sbomFormats = nil
+			}
+
+			return BuildAllCmd(cmd.Context(), configs, includePaths, outputDir, repository, jobs, report,
+				types.ParseArchitectures(archstrs),
+				build.WithBuildDate(buildDate),
+				build.WithSBOMFormats(sbomFormats),
+				build.WithExtraKeys(extraKeys),
+				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithExtraRepos(extraRepos),
+				build.WithVCS(withVCS),
+				// One cache for every build, so indexes are fetched and
+				// checked once.
+				build.WithCache(cacheDir, offline, apk.NewCache(true)),
+				build.WithIncludePaths(includePaths),
+				build.WithIgnoreSignatures(ignoreSignatures),
+				build.WithLayerCache(layerCache, remote.WithAuthFromKeychain(authn.NewMultiKeychain(authn.DefaultKeychain, github.Keychain))),
+				build.WithTransport(newDownloadPool(http.DefaultTransport, downloadJobs)),
+			)
+		},
+	}
+
+	cmd.Flags().StringVar(&outputDir, "output-dir", ".", "directory to write each image and its SBOMs to, in a subdirectory named after its configuration")
+	cmd.Flags().StringVar(&repository, "repository", "apko.local", "repository the images are tagged in")
+	cmd.Flags().IntVar(&jobs, "jobs", 1, "number of images to build at once")
+	cmd.Flags().IntVar(&downloadJobs, "download-jobs", 16, "number of package and index downloads in flight at once, across every image being built")
+	cmd.Flags().StringVar(&report, "report", "", "path to write a JSON report of every image built or failed")
+	cmd.Flags().BoolVar(&withVCS, "vcs", true, "detect and embed VCS URLs")
+	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image in RFC3339 format")
+	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
+	cmd.Flags().BoolVar(&writeSBOM, "sbom", true, "generate SBOMs")
+	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output (spdx, cyclonedx), instead of those set by sbom.formats in the config")
+	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
+	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append.")
+	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().StringVar(&layerCache, "layer-cache", "", "OCI repository to fetch already compressed layers from, and to push newly compressed layers to, to share them between builders")
+	return cmd
+}
+
+// expandConfigs returns the configuration files named by args: the YAML
+// files in each directory, and the files each glob matches.
+func expandConfigs(args []string) ([]string, error) {
+	var configs []string
+	for _, arg := range args {
+		var matches []string
+		if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+			for _, ext := range []string{"*.yaml", "*.yml"} {
+				m, err := filepath.Glob(filepath.Join(arg, ext))
+				if err != nil {
+					return nil, err
+				}
+				matches = append(matches, m...)
+			}
+		} else {
+			m, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			matches = m
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s matches no configuration files", arg)
+		}
+		configs = append(configs, matches...)
+	}
+	slices.Sort(configs)
+	return slices.Compact(configs), nil
+}
+
+// imageName is the name an image built from config is written and tagged
+// under.
+func imageName(config string) string {
+	name := filepath.Base(config)
+	for _, ext := range []string{".apko.yaml", ".apko.yml", ".yaml", ".yml"} {
+		if n, ok := strings.CutSuffix(name, ext); ok {
+			return n
+		}
+	}
+	return name
+}
+
+// BuildAllCmd builds each of configs with opts, which are shared by every
+// build, so that the caches they configure are too. Up to jobs images are
+// built at once, and a configuration is only built once every other one it
+// includes has been.
+func BuildAllCmd(ctx context.Context, configs, includePaths []string, outputDir, repository string, jobs int, reportPath string, archs []types.Architecture, opts ...build.Option) error {
+	log := clog.FromContext(ctx)
+
+	names := map[string]string{}
+	for _, config := range configs {
+		name := imageName(config)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s would both be built as %s", other, config, name)
+		}
+		names[name] = config
+	}
+
+	order, deps, err := scheduleConfigs(configs, includePaths)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
+	if err != nil {
+		return fmt.Errorf("creating tempdir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		g       errgroup.Group
+		results = make([]build.BatchResult, len(configs))
+		done    = make([]chan struct{}, len(configs))
+		slots   = make(chan struct{}, max(jobs, 1))
+	)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	// Builds are started in dependency order, and each waits for the
+	// builds of the configurations it includes before taking a slot, so
+	// that waiting never holds one up.
+	for _, i := range order {
+		g.Go(func() error {
+			defer close(done[i])
+			config := configs[i]
+			for _, d := range deps[i] {
+				<-done[d]
+				if results[d].Error != "" {
+					results[i] = build.BatchResult{Config: config, Tag: repository + "/" + imageName(config) + ":latest", Error: fmt.Sprintf("not built, as %s failed", configs[d])}
+					return nil
+				}
+			}
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = buildOne(ctx, config, includePaths, filepath.Join(tmp, imageName(config)), outputDir, repository, archs, opts...)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	if reportPath != "" {
+		report := build.BatchReport{Images: results}
+		if err := report.WriteFile(reportPath); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, r := range results {
+		if r.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", r.Config, r.Error))
+		}
+	}
+	log.Infof("built %d of %d images", len(results)-len(errs), len(results))
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("some images failed to build:\n%w", err)
+	}
+	return nil
+}
+
+// buildOne builds config into its directory under outputDir.
+func buildOne(ctx context.Context, config string, includePaths []string, tempDir, outputDir, repository string, archs []types.Architecture, opts ...build.Option) build.BatchResult {
+	log := clog.FromContext(ctx)
+	name := imageName(config)
+	tag := repository + "/" + name + ":latest"
+	dir := filepath.Join(outputDir, name)
+	output := filepath.Join(dir, "image.tar")
+	result := build.BatchResult{Config: config, Tag: tag}
+
+	start := time.Now()
+	idx, err := func() (v1.ImageIndex, error) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(tempDir, 0o755); err != nil {
+			return nil, err
+		}
+		opts := append(slices.Clone(opts),
+			build.WithConfig(config, includePaths),
+			build.WithTags(tag),
+			build.WithSBOM(dir),
+			build.WithTempDir(tempDir),
+		)
+		return buildTo(ctx, tag, output, archs, []string{tag}, dir, opts...)
+	}()
+	result.Seconds = time.Since(start).Seconds()
+	if err != nil {
+		log.Errorf("building %s: %v", config, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	h, err := idx.Digest()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Output, result.Digest = output, h.String()
+	log.Infof("built %s as %s (%s)", config, tag, h)
+	return result
+}
+
+// scheduleConfigs works out, for each of configs, which of the others it
+// includes, directly or through files outside the batch, so that these are
+// built first and their packages are already cached when it is. It returns
+// the indexes of configs in an order in which every configuration comes
+// after those it includes.
+func scheduleConfigs(configs, includePaths []string) ([]int, map[int][]int, error) {
+	index := map[string]int{}
+	for i, config := range configs {
+		abs, err := filepath.Abs(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		index[abs] = i
+	}
+
+	deps := map[int][]int{}
+	for i, config := range configs {
+		seen := map[string]bool{}
+		for p := config; ; {
+			// Configurations that cannot be read or whose include
+			// cannot be found are left to fail in their own build, so
+			// that the failure is reported alongside the others.
+			include, err := configInclude(p)
+			if err != nil || include == "" {
+				break
+			}
+			if p, err = paths.ResolvePath(include, includePaths); err != nil {
+				break
+			}
+			if p, err = filepath.Abs(p); err != nil {
+				return nil, nil, err
+			}
+			if seen[p] {
+				return nil, nil, fmt.Errorf("%s includes itself through %s", config, include)
+			}
+			seen[p] = true
+			if d, ok := index[p]; ok && !slices.Contains(deps[i], d) {
+				if d == i {
+					return nil, nil, fmt.Errorf("%s includes itself", config)
+				}
+				deps[i] = append(deps[i], d)
+			}
+		}
+	}
+
+	// Kahn's algorithm, taking configurations in the order given where
+	// there is a choice.
+	var order []int
+	remaining := map[int]int{}
+	dependents := map[int][]int{}
+	for i := range configs {
+		remaining[i] = len(deps[i])
+		for _, d := range deps[i] {
+			dependents[d] = append(dependents[d], i)
+		}
+	}
+	var ready []int
+	for i := range configs {
+		if remaining[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		order = append(order, i)
+		for _, j := range dependents[i] {
+			if remaining[j]--; remaining[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if len(order) != len(configs) {
+		return nil, nil, errors.New("configurations include one another in a cycle")
+	}
+	return order, deps, nil
+}
+
+// configInclude returns the include of the configuration at path.
+func configInclude(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var ic struct {
+		Include string `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(b, &ic); err != nil {
+		return "", err
+	}
+	return ic.Include, nil
+}
+
+// downloadPool is a transport that caps how many requests are in flight at
+// once across every build sharing it, until their bodies are closed.
+type downloadPool struct {
+	t     http.RoundTripper
+	slots chan struct{}
+}
+
+func newDownloadPool(t http.RoundTripper, size int) *downloadPool {
+	return &downloadPool{t: t, slots: make(chan struct{}, max(size, 1))}
+}
+
+func (p *downloadPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	resp, err := p.t.RoundTrip(req)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	resp.Body = &pooledBody{ReadCloser: resp.Body, release: sync.OnceFunc(func() { <-p.slots })}
+	return resp, nil
+}
+
+type pooledBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *pooledBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
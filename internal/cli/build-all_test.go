@@ -0,0 +1,124 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestBuildAll(t *testing.T) {
+	ctx := context.Background()
+	out := t.TempDir()
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+
+	configs := []string{
+		filepath.Join("testdata", "apko.yaml"),
+		filepath.Join("testdata", "layering.yaml"),
+		filepath.Join("testdata", "missing.yaml"),
+	}
+	archs := types.ParseArchitectures([]string{"amd64"})
+	err := BuildAllCmd(ctx, configs, nil, out, "example.com/test", 2, reportPath, archs,
+		build.WithSBOMFormats([]string{"spdx"}),
+		build.WithCache(t.TempDir(), false, apk.NewCache(true)),
+	)
+	require.ErrorContains(t, err, "missing.yaml")
+
+	b, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	var report build.BatchReport
+	require.NoError(t, json.Unmarshal(b, &report))
+	require.Len(t, report.Images, 3)
+
+	for _, r := range report.Images[:2] {
+		require.Empty(t, r.Error, r.Config)
+		require.NotEmpty(t, r.Digest)
+		summary, err := oci.VerifyTarball(r.Output, "")
+		require.NoError(t, err)
+		require.Equal(t, r.Digest, summary.IndexDigest.String())
+	}
+	require.Equal(t, "example.com/test/apko:latest", report.Images[0].Tag)
+	require.FileExists(t, filepath.Join(out, "apko", "image.tar"))
+	require.FileExists(t, filepath.Join(out, "layering", "sbom-index.spdx.json"))
+	require.NotEmpty(t, report.Images[2].Error)
+}
+
+func TestScheduleConfigs(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"app.yaml":    "include: base.yaml\n",
+		"base.yaml":   "contents:\n  packages: [busybox]\n",
+		"other.yaml":  "include: shared.yaml\n",
+		"shared.yaml": "include: base.yaml\n",
+		"loop-a.yaml": "include: loop-b.yaml\n",
+		"loop-b.yaml": "include: loop-a.yaml\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644))
+	}
+	config := func(name string) string { return filepath.Join(dir, name) }
+
+	// other.yaml includes base.yaml through shared.yaml, which is not
+	// part of the batch.
+	order, deps, err := scheduleConfigs([]string{config("app.yaml"), config("other.yaml"), config("base.yaml")}, []string{dir})
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 0, 1}, order)
+	require.Equal(t, map[int][]int{0: {2}, 1: {2}}, deps)
+
+	_, _, err = scheduleConfigs([]string{config("loop-a.yaml")}, []string{dir})
+	require.ErrorContains(t, err, "includes itself")
+}
+
+func TestDownloadPool(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	pool := newDownloadPool(http.DefaultTransport, 1)
+	client := &http.Client{Transport: pool}
+
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	require.Len(t, pool.slots, 1)
+
+	// The slot is held until the body is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, resp.Body.Close())
+	require.Empty(t, pool.slots)
+
+	resp, err = client.Get(srv.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+}
@@ -23,13 +23,17 @@ import (
 	"path/filepath"
 	"slices"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/authn/github"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/sys/unix"
 
 	"github.com/chainguard-dev/clog"
 
@@ -37,6 +41,8 @@ import (
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/options"
 	"chainguard.dev/apko/pkg/sbom"
 	"chainguard.dev/apko/pkg/tarfs"
 )
@@ -44,6 +50,8 @@ import (
 func buildCmd() *cobra.Command {
 	var withVCS bool
 	var buildDate string
+	var buildDateFromGit bool
+	var fileTimestamp, createdTimestamp string
 	var archstrs []string
 	var writeSBOM bool
 	var sbomPath string
@@ -54,10 +62,50 @@ func buildCmd() *cobra.Command {
 	var extraPackages []string
 	var rawAnnotations []string
 	var cacheDir string
+	var cacheNamespace string
+	var lowerCacheDir string
+	var cacheStoreSpec string
 	var offline bool
 	var lockfile string
+	var baseImageKey string
+	var baseImageIdentity, baseImageIssuer string
+	var fulcioRoots, rekorKey string
 	var includePaths []string
 	var ignoreSignatures bool
+	var rawUIDMaps []string
+	var rawGIDMaps []string
+	var compression string
+	var compressor string
+	var compressionLevel int
+	var compressionThreads int
+	var bestEffortArchs bool
+	var buildReport string
+	var defaultsReport string
+	var limitRate string
+	var dial apk.DialOptions
+	var caTrust string
+	var fetchRetry apk.RetryPolicy
+	var checksumDB string
+	var checksumDBKey string
+	var inputPolicy string
+	var inputAnnotations bool
+	var lockDrift string
+	var pinFile string
+	var pinMaxAge time.Duration
+	var remoteWorkers map[string]string
+	var jobs int
+	var layerCache string
+	var elfDeps string
+	var requireStatic bool
+	var symlinkCheck string
+	var symlinkAllow []string
+	var permissionCheck string
+	var permissionAllow []string
+	var strictPaths bool
+	var provenancePath, provenanceKey string
+	var splitDebug string
+	var builderID, builderVersion string
+	var containerdAddress, containerdNamespace string
 
 	cmd := &cobra.Command{
 		Use:   "build",
@@ -84,9 +132,25 @@ Along the image, apko will generate SBOMs (software bill of materials) describin
 			if err != nil {
 				return fmt.Errorf("parsing annotations from command line: %w", err)
 			}
+			idmap, err := parseIDMap(rawUIDMaps, rawGIDMaps)
+			if err != nil {
+				return err
+			}
+			rateLimit, err := parseLimitRate(limitRate)
+			if err != nil {
+				return err
+			}
+			cacheStore, err := openCacheStore(cmd.Context(), cacheStoreSpec)
+			if err != nil {
+				return err
+			}
 
-			if !writeSBOM {
+			switch {
+			case !writeSBOM:
 				sbomFormats = []string{}
+			case !cmd.Flags().Changed("sbom-formats"):
+				// Leave the formats to the configuration, if it sets any.
+				sbomFormats = nil
 			}
 
 			tmp, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
@@ -95,12 +159,14 @@ Along the image, apko will generate SBOMs (software bill of materials) describin
 			}
 			defer os.RemoveAll(tmp)
 
-			return BuildCmd(cmd.Context(), args[1], args[2], archs,
+			return reportArchErrors(cmd.ErrOrStderr(), BuildCmd(cmd.Context(), args[1], args[2], archs,
 				[]string{args[1]},
 				writeSBOM,
 				sbomPath,
 				build.WithConfig(args[0], includePaths),
 				build.WithBuildDate(buildDate),
+				build.WithBuildDateFromGit(buildDateFromGit),
+				build.WithTimestamps(fileTimestamp, createdTimestamp),
 				build.WithSBOM(sbomPath),
 				build.WithSBOMFormats(sbomFormats),
 				build.WithExtraKeys(extraKeys),
@@ -111,57 +177,152 @@ Along the image, apko will generate SBOMs (software bill of materials) describin
 				build.WithVCS(withVCS),
 				build.WithAnnotations(annotations),
 				build.WithCache(cacheDir, offline, apk.NewCache(true)),
+				build.WithCacheNamespace(cacheNamespace),
+				build.WithLowerCache(lowerCacheDir),
+				build.WithCacheStore(cacheStore),
 				build.WithLockFile(lockfile),
+				build.WithBaseImageKey(baseImageKey),
+				build.WithBaseImageIdentity(baseImageIdentity, baseImageIssuer),
+				build.WithSigstoreRoots(fulcioRoots, rekorKey),
 				build.WithTempDir(tmp),
 				build.WithIncludePaths(includePaths),
 				build.WithIgnoreSignatures(ignoreSignatures),
-			)
+				build.WithIDMap(idmap),
+				build.WithLayerCompression(compression),
+				build.WithCompressor(compressor),
+				build.WithCompressionLevel(compressionLevel),
+				build.WithCompressionThreads(compressionThreads),
+				build.WithBestEffortArchs(bestEffortArchs),
+				build.WithBuildReport(buildReport),
+				build.WithDefaultsReport(defaultsReport),
+				build.WithLimitRate(rateLimit),
+				build.WithDialOptions(dial),
+				build.WithCATrust(caTrust),
+				build.WithFetchRetryPolicy(fetchRetry),
+				build.WithChecksumDB(checksumDB, checksumDBKey),
+				build.WithInputPolicy(inputPolicy),
+				build.WithInputAnnotations(inputAnnotations),
+				build.WithLockDrift(options.CheckMode(lockDrift)),
+				build.WithPinFile(pinFile, pinMaxAge),
+				build.WithRemoteWorkers(parseRemoteWorkers(remoteWorkers)),
+				build.WithConcurrency(jobs),
+				build.WithELFDeps(options.CheckMode(elfDeps)),
+				build.WithRequireStatic(requireStatic),
+				build.WithSplitDebug(splitDebug),
+				build.WithSymlinkCheck(options.CheckMode(symlinkCheck), symlinkAllow),
+				build.WithPermissionCheck(options.CheckMode(permissionCheck), permissionAllow),
+				build.WithStrictPaths(strictPaths),
+				build.WithProvenance(provenancePath, provenanceKey),
+				build.WithLayerCache(layerCache, remote.WithAuthFromKeychain(authn.NewMultiKeychain(authn.DefaultKeychain, github.Keychain))),
+				build.WithBuilder(builderID, builderVersion),
+				build.WithContainerdLoad(containerdAddress, containerdNamespace),
+			))
 		},
 	}
 
 	cmd.Flags().BoolVar(&withVCS, "vcs", true, "detect and embed VCS URLs")
 	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image in RFC3339 format")
+	cmd.Flags().BoolVar(&buildDateFromGit, "build-date-from-git", false, "derive the build date from the last git commit that touched the config file; --build-date and SOURCE_DATE_EPOCH take precedence")
+	cmd.Flags().StringVar(&fileTimestamp, "file-timestamp", "", "modification time of every file in the image layers: an RFC 3339 date, seconds since the epoch, \"build-date\" or \"newest-package\" (default '' means the times the files were installed with)")
+	cmd.Flags().StringVar(&createdTimestamp, "created-timestamp", "", "time the image is recorded as created at, in the same forms as --file-timestamp (default '' means the build date, or the newest package if later)")
 	cmd.Flags().BoolVar(&writeSBOM, "sbom", true, "generate SBOMs")
 	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate SBOMs in dir (defaults to image directory)")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
-	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output")
+	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output (spdx, cyclonedx), instead of those set by sbom.formats in the config")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
 	cmd.Flags().StringSliceVar(&rawAnnotations, "annotations", []string{}, "OCI annotations to add. Separate with colon (key:value)")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().StringVar(&cacheNamespace, "cache-namespace", "", "keep the package and layer caches of this build apart from other namespaces sharing the cache directory")
+	cmd.Flags().StringVar(&lowerCacheDir, "lower-cache-dir", "", "read-only package cache consulted when the cache misses, e.g. one shared by every namespace")
+	cmd.Flags().StringVar(&cacheStoreSpec, "cache-store", "", cacheStoreUsage)
 	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
 	cmd.Flags().StringVar(&lockfile, "lockfile", "", "a path to .lock.json file (e.g. produced by apko lock) that constraints versions of packages to the listed ones (default '' means no additional constraints)")
+	cmd.Flags().StringVar(&baseImageKey, "base-image-key", "", "path to a cosign public key the base image and oci:// keyring entries must be signed with, the signature of the base image being saved in its OCI layout (e.g. by cosign save)")
+	cmd.Flags().StringVar(&baseImageIdentity, "base-image-identity", "", "identity, an email address or URI, the base image and oci:// keyring entries must be signed keyless by")
+	cmd.Flags().StringVar(&baseImageIssuer, "base-image-issuer", "", "OIDC issuer that must vouch for --base-image-identity, e.g. https://token.actions.githubusercontent.com")
+	cmd.Flags().StringVar(&fulcioRoots, "fulcio-roots", "", "path to the PEM certificates of the Fulcio certificate authority keyless signatures are checked against")
+	cmd.Flags().StringVar(&rekorKey, "rekor-key", "", "path to the public key of the Rekor log keyless signatures must be recorded in")
 	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append.")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().StringSliceVar(&rawUIDMaps, "uid-map", []string{}, "remap file owners in the image layers, as container:host:size (e.g. 0:100000:65536)")
+	cmd.Flags().StringSliceVar(&rawGIDMaps, "gid-map", []string{}, "remap file groups in the image layers, as container:host:size (e.g. 0:100000:65536)")
+	cmd.Flags().StringVar(&compression, "compression", build.LayerCompressionGzip, fmt.Sprintf("format layers are compressed in, one of %v", build.LayerCompressions))
+	cmd.Flags().StringVar(&compressor, "compressor", build.CompressorPgzip, fmt.Sprintf("implementation used to compress gzip layers, one of %v", build.Compressors))
+	cmd.Flags().IntVar(&compressionLevel, "compression-level", 0, "layer compression level from 1 (fastest) to 9 (smallest) (default 0 means the compressor's default)")
+	cmd.Flags().IntVar(&compressionThreads, "compression-threads", 0, "number of cores used to compress each layer with pgzip or zstd; GOMAXPROCS/threads layers are compressed at once (default 0 means min(GOMAXPROCS, 8))")
+	cmd.Flags().BoolVar(&bestEffortArchs, "best-effort-archs", false, "skip architectures whose packages cannot be resolved instead of failing the build")
+	cmd.Flags().StringVar(&buildReport, "build-report", "", "path to write a JSON report of which architectures were built, from which repository indexes, or skipped")
+	cmd.Flags().StringVar(&defaultsReport, "defaults-report", "", "path to write a JSON report of the effective image configuration and every default applied to it")
+	cmd.Flags().StringVar(&limitRate, "limit-rate", "", "maximum combined package download rate in bytes per second, with an optional K, M or G suffix (e.g. 500K)")
+	cmd.Flags().StringVar(&dial.Family, "ip-family", apk.IPFamilyDual, fmt.Sprintf("connect to repositories only over %q or %q, failing fast on hosts without such an address (default '' means both)", apk.IPFamilyIPv4, apk.IPFamilyIPv6))
+	cmd.Flags().DurationVar(&dial.FallbackDelay, "happy-eyeballs-delay", 0, "how long to wait on the preferred IP family before also trying the other one; negative tries them one after the other (default 0 means 300ms)")
+	cmd.Flags().DurationVar(&dial.ConnectTimeout, "connect-timeout", 0, "timeout for connecting to a repository (default 0 means 30s)")
+	cmd.Flags().StringVar(&caTrust, "ca-trust", apk.CATrustDefault, fmt.Sprintf("CA certificates to verify repositories against: %q, %q (built into apko) or the path of a PEM file (default '' means Go's default, honoring SSL_CERT_FILE)", apk.CATrustSystem, apk.CATrustBundled))
+	cmd.Flags().IntVar(&fetchRetry.Attempts, "fetch-attempts", 0, "how many times to try fetching an index, key or package, including the first; 1 disables retries (default 0 means 5)")
+	cmd.Flags().DurationVar(&fetchRetry.MinBackoff, "fetch-min-backoff", 0, "wait before retrying a fetch, doubling for each retry after it (default 0 means 1s)")
+	cmd.Flags().DurationVar(&fetchRetry.MaxBackoff, "fetch-max-backoff", 0, "longest wait between retries of a fetch (default 0 means 30s)")
+	cmd.Flags().IntSliceVar(&fetchRetry.RetryOn, "fetch-retry-on", nil, "HTTP statuses to retry fetches on, instead of 429 and 5xx other than 501; connection errors are always retried")
+	cmd.Flags().StringVar(&checksumDB, "checksum-db", "", "URL of a checksum database to cross-check installed package checksums against")
+	cmd.Flags().StringVar(&checksumDBKey, "checksum-db-key", "", "note verifier key the tree heads of --checksum-db are signed with")
+	cmd.Flags().StringVar(&inputPolicy, "input-policy", "", "path to a policy of the in-toto attestations the config, lock file and packages must carry, checked before anything is installed")
+	cmd.Flags().BoolVar(&inputAnnotations, "input-annotations", true, "annotate images with the apko version and the digests of the config and lock files they were built from")
+	cmd.Flags().StringVar(&lockDrift, "lock-drift", "", "when building from a lock file, check it against the current repository indexes: \"warn\" reports drift, \"fail\" also fails if a locked package's checksum changed upstream")
+	cmd.Flags().StringVar(&pinFile, "pin-file", "", "when not building from a lock file, record the packages resolved for the build in this lock file")
+	cmd.Flags().DurationVar(&pinMaxAge, "pin-max-age", 0, "build from the packages in --pin-file while it is younger than this and matches the config, instead of resolving them again (default 0 means always resolve)")
+	cmd.Flags().StringToStringVar(&remoteWorkers, "remote-worker", nil, "(experimental) build an architecture on the apko worker at a URL, e.g. arm64=http://arm-runner:8080")
+	cmd.Flags().IntVar(&jobs, "jobs", 0, "number of architectures to build at once (default 0 means all of them)")
+	cmd.Flags().StringVar(&layerCache, "layer-cache", "", "OCI repository to fetch already compressed layers from, and to push newly compressed layers to, to share them between builders")
+	cmd.Flags().StringVar(&elfDeps, "elf-deps", "", "check that the libraries ELF files in the image link against are in it: \"warn\" reports missing libraries, \"fail\" also fails the build")
+	cmd.Flags().BoolVar(&requireStatic, "require-static", false, "fail the build if any ELF file in the image is dynamically linked")
+	cmd.Flags().StringVar(&splitDebug, "split-debug", "", "strip the debug info from ELF files with a build ID, and write it to this directory as an OCI image layout of images laid out under /usr/lib/debug/.build-id, referring to the images they were split from")
+	cmd.Flags().StringVar(&symlinkCheck, "symlink-check", "", "check for symlinks that point outside the image or to nothing: \"warn\" reports them, \"fail\" also fails the build")
+	cmd.Flags().StringSliceVar(&symlinkAllow, "symlink-allow", []string{}, "patterns of symlinks for --symlink-check to ignore, in which \"**\" matches any number of directories")
+	cmd.Flags().StringVar(&permissionCheck, "permission-check", "", "check for setuid and setgid files and world-writable paths: \"warn\" lists them, \"fail\" also fails the build")
+	cmd.Flags().StringSliceVar(&permissionAllow, "permission-allow", []string{}, "patterns of paths for --permission-check to ignore, in which \"**\" matches any number of directories")
+	cmd.Flags().BoolVar(&strictPaths, "strict-paths", false, "fail the build when a local path copies something that would make it nondeterministic, such as a symlink to the host")
+	cmd.Flags().StringVar(&provenancePath, "provenance", "", "path to write the SLSA provenance of the image index to, as an in-toto statement")
+	cmd.Flags().StringVar(&provenanceKey, "provenance-key", "", "path to a PEM private key to sign the provenance with, as a DSSE envelope")
+	cmd.Flags().StringVar(&builderID, "builder-id", "", "URI identifying the builder running apko, recorded in the SBOMs and image annotations")
+	cmd.Flags().StringVar(&builderVersion, "builder-version", "", "version of the builder named by --builder-id")
+	cmd.Flags().StringVar(&containerdNamespace, "containerd", "", "also load the image into this namespace of the local containerd, e.g. \"default\" for nerdctl or \"k8s.io\" for k3s")
+	cmd.Flags().StringVar(&containerdAddress, "containerd-address", oci.DefaultContainerdAddress, "address of the containerd for --containerd, e.g. /run/k3s/containerd/containerd.sock for k3s")
 	return cmd
 }
 
 func BuildCmd(ctx context.Context, imageRef, output string, archs []types.Architecture, tags []string, wantSBOM bool, sbomPath string, opts ...build.Option) error {
+	_, err := buildTo(ctx, imageRef, output, archs, tags, sbomPath, opts...)
+	return err
+}
+
+// buildTo builds the image and writes it to output, as BuildCmd does,
+// returning its index.
+func buildTo(ctx context.Context, imageRef, output string, archs []types.Architecture, tags []string, sbomPath string, opts ...build.Option) (v1.ImageIndex, error) {
 	log := clog.FromContext(ctx)
 	wd, err := os.MkdirTemp("", "apko-*")
 	if err != nil {
-		return fmt.Errorf("failed to create working directory: %w", err)
+		return nil, fmt.Errorf("failed to create working directory: %w", err)
 	}
 	defer os.RemoveAll(wd)
 
 	// build all of the components in the working directory
 	idx, sboms, err := buildImageComponents(ctx, wd, archs, opts...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if fi, err := os.Stat(output); err == nil && fi.IsDir() {
 		// bundle the parts of the image into a tarball
 		if _, err := layout.Write(output, idx); err != nil {
-			return fmt.Errorf("writing image layout: %w", err)
+			return nil, fmt.Errorf("writing image layout: %w", err)
 		}
 		log.Debugf("Final image layout at: %s", output)
 	} else {
 		// bundle the parts of the image into a tarball
 		if _, err := oci.BuildIndex(output, idx, append([]string{imageRef}, tags...)); err != nil {
-			return fmt.Errorf("bundling image: %w", err)
+			return nil, fmt.Errorf("bundling image: %w", err)
 		}
 		log.Debugf("Final index tgz at: %s", output)
 	}
@@ -170,10 +331,20 @@ func BuildCmd(ctx context.Context, imageRef, output string, archs []types.Archit
 	for _, sbom := range sboms {
 		// because os.Rename fails across partitions, we do our own
 		if err := rename(sbom.Path, filepath.Join(sbomPath, filepath.Base(sbom.Path))); err != nil {
-			return fmt.Errorf("moving sbom: %w", err)
+			return nil, fmt.Errorf("moving sbom: %w", err)
+		}
+	}
+
+	o, _, err := build.NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if o.ContainerdNamespace != "" {
+		if _, err := oci.LoadIndexContainerd(ctx, idx, append([]string{imageRef}, tags...), o.ContainerdAddress, o.ContainerdNamespace); err != nil {
+			return nil, fmt.Errorf("importing index into containerd: %w", err)
 		}
 	}
-	return nil
+	return idx, nil
 }
 
 // buildImage build all of the components of an image in a single working directory.
@@ -187,31 +358,70 @@ func buildImageComponents(ctx context.Context, workDir string, archs []types.Arc
 	if err != nil {
 		return nil, nil, err
 	}
+	written := *ic
+
+	// Every architecture backs off together from the hosts that rate limit
+	// them, which are summed up once the build is over.
+	if o.Backoff == nil {
+		o.Backoff = apk.NewBackoff(o.FetchRetry)
+		opts = append(opts, build.WithBackoff(o.Backoff))
+	}
+	defer o.Backoff.LogSummary(ctx)
+
+	// Build from the packages pinned by an earlier build while they are
+	// fresh, and pin the packages resolved now otherwise.
+	recordPins := false
+	if o.PinFile != "" && o.Lockfile == "" {
+		fresh, err := freshPins(o.PinFile, o.PinMaxAge, o.ImageConfigChecksum, o.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+		if fresh {
+			log.Infof("Building from the packages pinned in %s", o.PinFile)
+			opts = append(slices.Clone(opts), build.WithLockFile(o.PinFile))
+			o.Lockfile = o.PinFile
+		} else {
+			recordPins = true
+		}
+	}
 
 	if ic.Contents.BaseImage != nil && o.Lockfile == "" {
 		return nil, nil, fmt.Errorf("building with base image is supported only with a lockfile")
 	}
 
-	// cases:
-	// - archs set: use those archs
-	// - archs not set, bc.ImageConfiguration.Archs set: use Config archs
-	// - archs not set, bc.ImageConfiguration.Archs not set: use all archs
-	switch {
-	case len(archs) != 0:
-		ic.Archs = archs
-	case len(ic.Archs) != 0:
-		// do nothing
-	default:
-		ic.Archs = types.AllArchs
+	// Archs from the command line win over those of the configuration,
+	// which win over the defaults.
+	if err := ic.ResolveArchs(archs); err != nil {
+		return nil, nil, err
 	}
 	// save the final set we will build
 	log.Debugf("Building images for %d architectures: %+v", len(ic.Archs), ic.Archs)
 
+	// Likewise, SBOM formats that are not set, as opposed to set to none,
+	// come from the config or else the defaults.
+	if o.SBOMFormats == nil {
+		o.SBOMFormats = sbom.DefaultOptions.Formats
+		if ic.SBOM != nil && len(ic.SBOM.Formats) != 0 {
+			o.SBOMFormats = ic.SBOM.Formats
+		}
+		opts = append(opts, build.WithSBOMFormats(o.SBOMFormats))
+	}
+
 	// Probe the VCS URL if it is not set and we are asked to do so.
 	if o.WithVCS && ic.VCSUrl == "" {
 		ic.ProbeVCSUrl(ctx, o.ImageConfigFile)
 	}
 
+	if o.DefaultsReportPath != "" {
+		r, err := build.DescribeDefaults(written, *ic)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := r.WriteFile(o.DefaultsReportPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// The build context options is sometimes copied in the next functions. Ensure
 	// we have the directory defined and created by invoking the function early.
 
@@ -231,27 +441,58 @@ func buildImageComponents(ctx context.Context, workDir string, archs []types.Arc
 	opts = append(opts, build.WithSBOM(imageDir))
 
 	imgs := map[types.Architecture]v1.Image{}
+	debugInfo := map[types.Architecture]v1.Image{}
+	indexes := map[types.Architecture][]apk.IndexDigest{}
+	incidents := map[types.Architecture][]apk.ChecksumIncident{}
+	pins := map[types.Architecture]pkglock.Lock{}
+	pinConfig := ic
+	// The provenance lists the packages of each architecture as pins do.
+	lockPackages := recordPins || o.ProvenancePath != ""
 
 	mtx := sync.Mutex{}
+	var failures archFailures
 
 	// We compute the "build date epoch" of the multi-arch image to be the
 	// maximum "build date epoch" of the per-arch images.  If the user has
 	// explicitly set SOURCE_DATE_EPOCH, that will always trump this
 	// computation.
 	multiArchBDE := o.SourceDateEpoch
+	// Likewise, the multi-arch image is created when the last of the
+	// per-arch images was.
+	var multiArchCreated time.Time
+
+	// In best-effort mode, drop the architectures that cannot be resolved on
+	// their own before locking, so the rest can still be built and indexed.
+	requested := ic.Archs
+	skipped := map[types.Architecture]error{}
+	if o.BestEffortArchs && o.Lockfile == "" {
+		ic.Archs, skipped, err = build.ResolvableArchs(ctx, *ic, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolving architectures: %w", err)
+		}
+		if len(ic.Archs) == 0 {
+			errs := make([]error, 0, len(skipped))
+			for arch, err := range skipped {
+				errs = append(errs, fmt.Errorf("%s: %w", arch, err))
+			}
+			return nil, nil, fmt.Errorf("no architecture could be resolved: %w", errors.Join(errs...))
+		}
+	}
 
 	configs, _, err := build.LockImageConfiguration(ctx, *ic, opts...)
 	if err != nil {
 		return nil, nil, fmt.Errorf("locking config: %w", err)
 	}
 
+	if o.Concurrency > 0 {
+		errg.SetLimit(o.Concurrency)
+	}
 	for arch, ic := range configs {
-		errg.Go(func() error {
-			if arch == "index" {
-				return nil
-			}
-
-			arch := types.ParseArchitecture(arch)
+		if arch == "index" {
+			continue
+		}
+		arch := types.ParseArchitecture(arch)
+		errg.Go(failures.collect(arch, func() error {
 			log := log.With("arch", arch.ToAPK())
 			ctx := clog.WithLogger(ctx, log)
 
@@ -262,6 +503,55 @@ func buildImageComponents(ctx context.Context, workDir string, archs []types.Arc
 			if err != nil {
 				return fmt.Errorf("new build for arch %s: %w", arch, err)
 			}
+
+			if workerURL, ok := o.RemoteWorkers[arch]; ok {
+				log.Infof("building on worker %s", workerURL)
+				// The lock is checked for drift here, where it is.
+				if o.Lockfile != "" && o.LockDrift != options.CheckOff {
+					if _, err := bc.ResolvePackages(ctx); err != nil {
+						return build.CategorizeError(build.ErrorCategoryResolve, err)
+					}
+				}
+				// The image is annotated as the one built here would be.
+				remoteIC := *ic
+				remoteIC.Annotations = bc.ImageConfiguration().Annotations
+				rb, err := buildRemote(ctx, workerURL, arch, remoteIC, o, workDir, imageDir, lockPackages)
+				if err != nil {
+					return build.CategorizeError(build.ErrorCategoryRemote, err)
+				}
+
+				// The packages are pinned as the worker installed them:
+				// resolving them here could pick different ones.
+				var pinned pkglock.Lock
+				if lockPackages {
+					if rb.result.Packages == nil {
+						return build.CategorizeError(build.ErrorCategoryRemote, fmt.Errorf("worker %s did not report the packages it installed for %s", workerURL, arch))
+					}
+					pinned.Contents.Packages = rb.result.Packages
+					if err := lockSources(&pinned, pinConfig, arch); err != nil {
+						return build.CategorizeError(build.ErrorCategoryResolve, fmt.Errorf("pinning packages for %s: %w", arch, err))
+					}
+				}
+
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				imgs[arch] = rb.img
+				indexes[arch] = rb.result.Indexes
+				incidents[arch] = rb.result.ChecksumIncidents
+				if lockPackages {
+					pins[arch] = pinned
+				}
+				if rb.result.BuildDateEpoch.After(multiArchBDE) {
+					multiArchBDE = rb.result.BuildDateEpoch
+				}
+				if rb.result.created().After(multiArchCreated) {
+					multiArchCreated = rb.result.created()
+				}
+				sboms = append(sboms, rb.result.SBOMs...)
+				return nil
+			}
+
 			layers, err := bc.BuildLayers(ctx)
 			if err != nil {
 				return fmt.Errorf("building %q layer: %w", arch, err)
@@ -273,22 +563,38 @@ func buildImageComponents(ctx context.Context, workDir string, archs []types.Arc
 			// explicitly set by the user, that trumps this.
 			// This computation will only affect the timestamp of the image
 			// itself and its SBOMs, since the timestamps on files come from the
-			// APKs.
+			// APKs, unless they are set with --file-timestamp.
 			bde, err := bc.GetBuildDateEpoch()
 			if err != nil {
 				return fmt.Errorf("failed to determine build date epoch: %w", err)
 			}
+			created, err := bc.CreatedTime()
+			if err != nil {
+				return fmt.Errorf("failed to determine created time: %w", err)
+			}
+
+			img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ImageConfiguration(), created, bc.Arch())
+			if err != nil {
+				return build.CategorizeError(build.ErrorCategoryImage, fmt.Errorf("failed to build OCI image for %q: %w", arch, err))
+			}
 
-			img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ImageConfiguration(), bde, bc.Arch())
+			debug, err := bc.DebugInfo()
 			if err != nil {
-				return fmt.Errorf("failed to build OCI image for %q: %w", arch, err)
+				return build.CategorizeError(build.ErrorCategoryImage, fmt.Errorf("building debug info image for %q: %w", arch, err))
 			}
 
 			var outputs []types.SBOM
 			if len(o.SBOMFormats) != 0 {
 				outputs, err = bc.GenerateImageSBOM(ctx, arch, img)
 				if err != nil {
-					return fmt.Errorf("generating sbom for %s: %w", arch, err)
+					return build.CategorizeError(build.ErrorCategoryImage, fmt.Errorf("generating sbom for %s: %w", arch, err))
+				}
+			}
+
+			var pinned pkglock.Lock
+			if lockPackages {
+				if err := pinArch(ctx, &pinned, bc, pinConfig, arch); err != nil {
+					return build.CategorizeError(build.ErrorCategoryResolve, fmt.Errorf("pinning packages for %s: %w", arch, err))
 				}
 			}
 
@@ -296,24 +602,59 @@ func buildImageComponents(ctx context.Context, workDir string, archs []types.Arc
 			defer mtx.Unlock()
 
 			imgs[arch] = img
+			if debug != nil {
+				debugInfo[arch] = debug
+			}
+			indexes[arch] = bc.ResolvedIndexes()
+			incidents[arch] = bc.ChecksumIncidents()
+			if lockPackages {
+				pins[arch] = pinned
+			}
 
 			if bde.After(multiArchBDE) {
 				multiArchBDE = bde
 			}
+			if created.After(multiArchCreated) {
+				multiArchCreated = created
+			}
 
 			if len(o.SBOMFormats) != 0 {
 				sboms = append(sboms, outputs...)
 			}
 
 			return nil
-		})
+		}))
 	}
 	if err := errg.Wait(); err != nil {
 		return nil, nil, err
 	}
+	if err := build.NewMultiArchError(failures.errs); err != nil {
+		return nil, nil, err
+	}
+
+	if o.SplitDebugPath != "" {
+		if err := writeDebugInfo(o.SplitDebugPath, imgs, debugInfo); err != nil {
+			return nil, nil, err
+		}
+		log.Infof("Wrote the debug info of %d architectures to %s", len(debugInfo), o.SplitDebugPath)
+	}
+
+	if recordPins {
+		lock := newLock(o.ImageConfigFile, o.ImageConfigChecksum, pinConfig)
+		if err := writePins(o.PinFile, lock, ic.Archs, pins, o.Now()); err != nil {
+			return nil, nil, err
+		}
+		log.Infof("Pinned the packages of this build in %s", o.PinFile)
+	}
+
+	if o.BuildReportPath != "" {
+		if err := writeBuildReport(o.BuildReportPath, build.LayerCompressor(o), requested, imgs, indexes, incidents, skipped, ic.Labels); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	// generate the index
-	finalDigest, idx, err := oci.GenerateIndex(ctx, *ic, imgs, multiArchBDE)
+	finalDigest, idx, err := oci.GenerateIndex(ctx, *ic, imgs, multiArchCreated)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to generate OCI index: %w", err)
 	}
@@ -332,6 +673,25 @@ func buildImageComponents(ctx context.Context, workDir string, archs []types.Arc
 		return nil, nil, fmt.Errorf("failed to write OCI index: %w", err)
 	}
 
+	if o.ProvenancePath != "" {
+		packages := map[types.Architecture][]pkglock.LockPkg{}
+		for arch, lock := range pins {
+			packages[arch] = lock.Contents.Packages
+		}
+		h, err := idx.Digest()
+		if err != nil {
+			return nil, nil, err
+		}
+		prov, err := build.NewProvenance(o, ic, h, packages)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating provenance: %w", err)
+		}
+		if err := prov.WriteFile(o.ProvenancePath, o.ProvenanceKey, o.Random()); err != nil {
+			return nil, nil, err
+		}
+		log.Infof("Wrote the provenance of the image index to %s", o.ProvenancePath)
+	}
+
 	// the sboms are saved to the same working directory as the image components
 	if len(o.SBOMFormats) != 0 {
 		files, err := build.GenerateIndexSBOM(ctx, *o, *ic, finalDigest, imgs)
@@ -344,6 +704,45 @@ func buildImageComponents(ctx context.Context, workDir string, archs []types.Arc
 	return idx, sboms, nil
 }
 
+// writeBuildReport records the layer compressor and, for each requested
+// architecture, whether an image was built, from which repository indexes,
+// with which package downloads failing checksum verification, with which
+// configured labels and when it should be rebuilt, or why it was skipped.
+func writeBuildReport(path, compressor string, archs []types.Architecture, imgs map[types.Architecture]v1.Image, indexes map[types.Architecture][]apk.IndexDigest, incidents map[types.Architecture][]apk.ChecksumIncident, skipped map[types.Architecture]error, labels map[string]string) error {
+	report := build.BuildReport{Compressor: compressor}
+	for _, arch := range archs {
+		ar := build.ArchReport{Arch: arch.String()}
+		if img, ok := imgs[arch]; ok {
+			h, err := img.Digest()
+			if err != nil {
+				return fmt.Errorf("computing digest for %s: %w", arch, err)
+			}
+			ar.Built, ar.Digest = true, h.String()
+			ar.Indexes = indexes[arch]
+			ar.ChecksumIncidents = incidents[arch]
+			manifest, err := img.Manifest()
+			if err != nil {
+				return fmt.Errorf("reading manifest for %s: %w", arch, err)
+			}
+			ar.RebuildAfter = manifest.Annotations[oci.AnnotationRebuildAfter]
+			if len(labels) != 0 {
+				cfg, err := img.ConfigFile()
+				if err != nil {
+					return fmt.Errorf("reading config for %s: %w", arch, err)
+				}
+				ar.Labels = make(map[string]string, len(labels))
+				for k := range labels {
+					ar.Labels[k] = cfg.Config.Labels[k]
+				}
+			}
+		} else if err, ok := skipped[arch]; ok {
+			ar.Reason = err.Error()
+		}
+		report.Archs = append(report.Archs, ar)
+	}
+	return report.WriteFile(path)
+}
+
 // rename just like os.Rename, but does a copy and delete if the rename fails
 func rename(from, to string) error {
 	err := os.Rename(from, to)
@@ -351,7 +750,7 @@ func rename(from, to string) error {
 		return nil
 	}
 	// we can handle cross-device rename errors
-	if !errors.Is(err, unix.EXDEV) {
+	if !errors.Is(err, syscall.EXDEV) {
 		return err
 	}
 	f1, err := os.Open(from)
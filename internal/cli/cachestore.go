@@ -0,0 +1,67 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	// Registers the "sqlite3" driver that sqlite: cache stores open.
+	_ "github.com/mattn/go-sqlite3"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// sqliteStorePrefix marks a --cache-store value as the path of a SQLite
+// database rather than a directory.
+const sqliteStorePrefix = "sqlite:"
+
+// cacheStoreUsage is the help of the --cache-store flag.
+const cacheStoreUsage = "directory, or sqlite:<file> for a SQLite database, recording which version of each index was fetched last, used offline and while a repository is unreachable"
+
+// openCacheStore returns the store of package cache metadata that spec, a
+// --cache-store value, names: an apk.SQLStore in the SQLite database at
+// the path following sqlite:, and otherwise an apk.FileStore in the
+// directory spec. It returns nil when spec is empty.
+func openCacheStore(ctx context.Context, spec string) (apk.KVStore, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	path, ok := strings.CutPrefix(spec, sqliteStorePrefix)
+	if !ok {
+		store, err := apk.NewFileStore(spec)
+		if err != nil {
+			return nil, fmt.Errorf("opening cache store: %w", err)
+		}
+		return store, nil
+	}
+	if path == "" {
+		return nil, fmt.Errorf("opening cache store: %q has no database path", spec)
+	}
+	// The database is shared by the builds of every architecture, and
+	// SQLite only has one writer at a time: wait for it rather than fail.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("opening cache store: %w", err)
+	}
+	store, err := apk.NewSQLStore(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening cache store %s: %w", path, err)
+	}
+	return store, nil
+}
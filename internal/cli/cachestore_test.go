@@ -0,0 +1,54 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestOpenCacheStore(t *testing.T) {
+	ctx := t.Context()
+
+	store, err := openCacheStore(ctx, "")
+	require.NoError(t, err)
+	require.Nil(t, store)
+
+	dir := filepath.Join(t.TempDir(), "store")
+	store, err = openCacheStore(ctx, dir)
+	require.NoError(t, err)
+	require.IsType(t, &apk.FileStore{}, store)
+	require.DirExists(t, dir)
+
+	db := filepath.Join(t.TempDir(), "cache.db")
+	store, err = openCacheStore(ctx, "sqlite:"+db)
+	require.NoError(t, err)
+	require.IsType(t, &apk.SQLStore{}, store)
+	require.NoError(t, store.Put(ctx, "https://example.com/APKINDEX.tar.gz", []byte(`{"etag":"v1"}`)))
+
+	// The database persists the entries for the next build.
+	reopened, err := openCacheStore(ctx, "sqlite:"+db)
+	require.NoError(t, err)
+	got, err := reopened.Get(ctx, "https://example.com/APKINDEX.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, `{"etag":"v1"}`, string(got))
+
+	_, err = openCacheStore(ctx, "sqlite:")
+	require.Error(t, err)
+}
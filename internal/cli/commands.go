@@ -20,11 +20,17 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/chainguard-dev/clog"
 	"github.com/chainguard-dev/clog/slag"
 	charmlog "github.com/charmbracelet/log"
 	cranecmd "github.com/google/go-containerregistry/cmd/crane/cmd"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"sigs.k8s.io/release-utils/version"
+
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/requestid"
 )
 
 func New() *cobra.Command {
@@ -34,6 +40,8 @@ func New() *cobra.Command {
 		cwd = ""
 	}
 	level := slag.Level(slog.LevelInfo)
+	var requestID string
+	var requestIDHeaders []string
 	cmd := &cobra.Command{
 		Use:               "apko",
 		DisableAutoGenTag: true,
@@ -46,29 +54,70 @@ func New() *cobra.Command {
 				}
 			}
 			slog.SetDefault(slog.New(charmlog.NewWithOptions(os.Stderr, charmlog.Options{ReportTimestamp: true, Level: charmlog.Level(level)})))
+
+			header, hosts, err := requestid.ParseHeaders(requestIDHeaders)
+			if err != nil {
+				return err
+			}
+			if !cmd.Flag("request-id").Changed {
+				if requestID, err = requestid.New(options.Default.Random()); err != nil {
+					return err
+				}
+			}
+			rid := requestid.Config{ID: requestID, Header: header, Hosts: hosts}
+			// Requests made without the command's context, such as by
+			// registry clients, are tagged with the same ID.
+			http.DefaultTransport = requestid.NewTransport(http.DefaultTransport, rid)
+			remote.DefaultTransport = requestid.NewTransport(remote.DefaultTransport, rid)
+			ctx := requestid.WithContext(cmd.Context(), rid)
+			if requestID != "" {
+				ctx = clog.WithLogger(ctx, clog.FromContext(ctx).With("request_id", requestID))
+			}
+			cmd.SetContext(ctx)
 			return nil
 		},
 	}
 	cmd.PersistentFlags().Var(&level, "log-level", "log level (e.g. debug, info, warn, error, fatal, panic)")
+	cmd.PersistentFlags().StringVar(&requestID, "request-id", "", "ID to send with every HTTP request and log with every entry, to join the logs of the build with those of the repositories and registries it fetches from (default is a random ID, explicitly empty disables it)")
+	cmd.PersistentFlags().StringSliceVar(&requestIDHeaders, "request-id-header", nil, "header to send the request ID in (default X-Request-ID), or host=header to use a different one for a host (host= to send none)")
+	cmd.SetGlobalNormalizationFunc(normalizeFlag)
 
-	cmd.AddCommand(cranecmd.NewCmdAuthLogin("apko")) // apko login
+	login := cranecmd.NewCmdAuthLogin("apko") // apko login
+	// The saved credentials are also used for apk repositories on the same host.
+	login.Short = "Log in to a registry or package repository"
+	cmd.AddCommand(login)
 	cmd.AddCommand(buildCmd())
+	cmd.AddCommand(buildAll())
 	cmd.AddCommand(buildMinirootFS())
 	cmd.AddCommand(buildCPIO())
 	cmd.AddCommand(showConfig())
 	cmd.AddCommand(publish())
 	cmd.AddCommand(showPackages())
+	cmd.AddCommand(diffCmd())
+	cmd.AddCommand(planCmd())
 	cmd.AddCommand(dotcmd())
 	cmd.AddCommand(lock())
 	cmd.AddCommand(resolve())
 	cmd.AddCommand(installKeys())
 	cmd.AddCommand(cleanCmd())
+	cmd.AddCommand(prefetchCmd())
+	cmd.AddCommand(workerCmd())
+	cmd.AddCommand(verifyTarball())
 	cmd.AddCommand(version.Version())
+	registerCompletions(cmd)
 
 	cmd.PersistentFlags().StringVarP(&workDir, "workdir", "C", cwd, "working dir (default is current dir where executed)")
 	return cmd
 }
 
+// normalizeFlag accepts the singular --sbom-format for --sbom-formats.
+func normalizeFlag(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == "sbom-format" {
+		name = "sbom-formats"
+	}
+	return pflag.NormalizedName(name)
+}
+
 type userAgentTransport struct{ t http.RoundTripper }
 
 func (u userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
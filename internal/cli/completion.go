@@ -0,0 +1,152 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// configArgCommands are the commands whose first argument is an image
+// configuration file.
+var configArgCommands = []string{
+	"build", "build-cpio", "build-minirootfs", "dot", "lock", "plan",
+	"publish", "resolve", "show-config", "show-packages",
+}
+
+// registerCompletions adds dynamic shell completions to the subcommands of
+// root: configuration files for their first argument, and architectures and
+// package names for the flags that take them.
+func registerCompletions(root *cobra.Command) {
+	for _, cmd := range root.Commands() {
+		switch {
+		case slices.Contains(configArgCommands, cmd.Name()):
+			cmd.ValidArgsFunction = completeFirstArg("yaml", "yml")
+		case cmd.Name() == "prefetch":
+			cmd.ValidArgsFunction = func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+				return []string{"json"}, cobra.ShellCompDirectiveFilterFileExt
+			}
+		case cmd.Name() == "build-all":
+			cmd.ValidArgsFunction = func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+				return []string{"yaml", "yml"}, cobra.ShellCompDirectiveFilterFileExt
+			}
+			_ = cmd.MarkFlagDirname("output-dir")
+		case cmd.Name() == "diff":
+			cmd.ValidArgsFunction = func(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+				if len(args) >= 2 {
+					return nil, cobra.ShellCompDirectiveNoFileComp
+				}
+				return nil, cobra.ShellCompDirectiveDefault
+			}
+		case cmd.Name() == "verify-tarball":
+			cmd.ValidArgsFunction = completeFirstArg("tar")
+			_ = cmd.MarkFlagDirname("sbom-path")
+		}
+		if cmd.Flags().Lookup("arch") != nil {
+			_ = cmd.RegisterFlagCompletionFunc("arch", completeArchs)
+		}
+		if cmd.Flags().Lookup("package-append") != nil {
+			_ = cmd.RegisterFlagCompletionFunc("package-append", completePackages)
+		}
+	}
+}
+
+// completeFirstArg completes files with the given extensions for the first
+// argument only.
+func completeFirstArg(exts ...string) cobra.CompletionFunc {
+	return func(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return exts, cobra.ShellCompDirectiveFilterFileExt
+	}
+}
+
+func completeArchs(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names := []string{"all", "host"}
+	for _, a := range types.AllArchs {
+		names = append(names, a.ToAPK())
+	}
+	return completeListItem(names, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// completePackages completes package names from the repository indexes in
+// the apk cache, so it works offline and only knows the repositories that
+// have been built from before.
+func completePackages(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	dir, _ := cmd.Flags().GetString("cache-dir")
+	if dir == "" {
+		var err error
+		if dir, err = apk.DefaultCacheDir(); err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	return completeListItem(cachedPackageNames(dir), toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// cachedPackageNames returns the names of the packages in the indexes cached
+// under dir, at <dir>/<repository>/<arch>/APKINDEX/<etag>.tar.gz, including
+// those of cache namespaces.
+func cachedPackageNames(dir string) []string {
+	var indexes []string
+	for _, pattern := range []string{
+		filepath.Join(dir, "*", "*", "APKINDEX", "*.tar.gz"),
+		filepath.Join(dir, "namespaces", "*", "*", "*", "APKINDEX", "*.tar.gz"),
+	} {
+		matches, _ := filepath.Glob(pattern)
+		indexes = append(indexes, matches...)
+	}
+
+	var names []string
+	for _, p := range indexes {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		idx, err := apk.IndexFromArchive(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		for _, pkg := range idx.Packages {
+			names = append(names, pkg.Name)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// completeListItem completes the last item of a comma-separated list, as
+// taken by slice flags, from candidates.
+func completeListItem(candidates []string, toComplete string) []string {
+	done, last := "", toComplete
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		done, last = toComplete[:i+1], toComplete[i+1:]
+	}
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, last) {
+			out = append(out, done+c)
+		}
+	}
+	return out
+}
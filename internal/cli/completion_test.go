@@ -0,0 +1,44 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletion(t *testing.T) {
+	index, err := os.ReadFile("testdata/packages/x86_64/APKINDEX.tar.gz")
+	require.NoError(t, err)
+
+	cacheDir := t.TempDir()
+	dir := filepath.Join(cacheDir, "https%3A%2F%2Fexample.com%2Fpackages", "x86_64", "APKINDEX")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ETAG.tar.gz"), index, 0o644))
+
+	require.Equal(t, []string{"pretend-baselayout", "replayout"}, cachedPackageNames(cacheDir))
+	require.Empty(t, cachedPackageNames(t.TempDir()))
+
+	cmd := buildCmd()
+	require.NoError(t, cmd.Flags().Set("cache-dir", cacheDir))
+	got, _ := completePackages(cmd, nil, "foo,pre")
+	require.Equal(t, []string{"foo,pretend-baselayout"}, got)
+
+	got, _ = completeArchs(cmd, nil, "x86")
+	require.Equal(t, []string{"x86", "x86_64"}, got)
+}
@@ -0,0 +1,52 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// writeDebugInfo writes the images of the debug info split from imgs to an
+// OCI image layout at path, each with the image it was split from as its
+// subject, so that registries list it among the image's referrers once
+// pushed.
+func writeDebugInfo(path string, imgs, debug map[types.Architecture]v1.Image) error {
+	lp, err := layout.Write(path, empty.Index)
+	if err != nil {
+		return fmt.Errorf("writing debug info layout: %w", err)
+	}
+	for _, arch := range slices.Sorted(maps.Keys(debug)) {
+		subject, err := partial.Descriptor(imgs[arch])
+		if err != nil {
+			return fmt.Errorf("describing %s image: %w", arch, err)
+		}
+		subject.Platform = arch.ToOCIPlatform()
+		img := mutate.Subject(debug[arch], *subject).(v1.Image)
+		if err := lp.AppendImage(img, layout.WithPlatform(*subject.Platform)); err != nil {
+			return fmt.Errorf("writing %s debug info: %w", arch, err)
+		}
+	}
+	return nil
+}
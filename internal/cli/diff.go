@@ -0,0 +1,179 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/authn/github"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/reader"
+)
+
+func diffCmd() *cobra.Command {
+	var arch string
+	var format string
+	var includePaths []string
+	var extraKeys []string
+	var extraBuildRepos []string
+	var extraRepos []string
+	var cacheDir string
+	var offline bool
+
+	cmd := &cobra.Command{
+		Use:   "diff <old> <new>",
+		Short: "Compare the packages and files of two images",
+		Long: `Compare the packages and files of two images.
+
+Each image is a tarball written by apko build, an OCI layout directory, a
+remote image reference, or an apko configuration file, which is built first.
+
+The packages added, removed, upgraded or downgraded are listed, then the files
+added, removed or modified, and the size of both images.
+`,
+		Example: `  apko diff registry.example.com/base:yesterday registry.example.com/base:today
+  apko diff old/image.tar apko.yaml`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, must be text or json", format)
+			}
+			archs := types.ParseArchitectures([]string{arch})
+			if len(archs) != 1 {
+				return fmt.Errorf("--arch must name one architecture, got %q", arch)
+			}
+			return DiffCmd(cmd.Context(), cmd.OutOrStdout(), args[0], args[1], archs[0], format,
+				build.WithExtraKeys(extraKeys),
+				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithExtraRepos(extraRepos),
+				build.WithCache(cacheDir, offline, apk.NewCache(true)),
+				build.WithIncludePaths(includePaths),
+				build.WithSBOMFormats([]string{}),
+			)
+		},
+	}
+
+	cmd.Flags().StringVar(&arch, "arch", "host", "architecture of the images to compare")
+	cmd.Flags().StringVar(&format, "format", "text", "output format, text or json")
+	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append.")
+	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
+	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	return cmd
+}
+
+// DiffCmd writes to w what changed from the image oldRef to newRef, for
+// arch. opts are used to build the references that are configurations.
+func DiffCmd(ctx context.Context, w io.Writer, oldRef, newRef string, arch types.Architecture, format string, opts ...build.Option) error {
+	wd, err := os.MkdirTemp("", "apko-diff-*")
+	if err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(wd)
+
+	from, err := openForDiff(ctx, oldRef, arch, filepath.Join(wd, "old"), opts)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", oldRef, err)
+	}
+	to, err := openForDiff(ctx, newRef, arch, filepath.Join(wd, "new"), opts)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", newRef, err)
+	}
+
+	d, err := reader.Compare(from, to)
+	if err != nil {
+		return fmt.Errorf("comparing images: %w", err)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	return writeDiff(w, d)
+}
+
+// openForDiff opens ref, building it in workDir first if it is a
+// configuration file.
+func openForDiff(ctx context.Context, ref string, arch types.Architecture, workDir string, opts []build.Option) (*reader.Image, error) {
+	if ext := filepath.Ext(ref); ext == ".yaml" || ext == ".yml" {
+		if err := os.MkdirAll(workDir, 0o755); err != nil {
+			return nil, err
+		}
+		opts = append(opts, build.WithConfig(ref, nil), build.WithTempDir(workDir))
+		idx, _, err := buildImageComponents(ctx, workDir, []types.Architecture{arch}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return reader.FromIndex(idx, arch)
+	}
+	return reader.Open(ref, arch, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.NewMultiKeychain(authn.DefaultKeychain, github.Keychain)))
+}
+
+func writeDiff(w io.Writer, d *reader.Diff) error {
+	var sb strings.Builder
+	if len(d.Packages) != 0 {
+		sb.WriteString("Packages:\n")
+		for _, p := range d.Packages {
+			switch p.Kind {
+			case reader.Added:
+				fmt.Fprintf(&sb, "  %-10s %s %s\n", p.Kind, p.Name, p.NewVersion)
+			case reader.Removed:
+				fmt.Fprintf(&sb, "  %-10s %s %s\n", p.Kind, p.Name, p.OldVersion)
+			default:
+				fmt.Fprintf(&sb, "  %-10s %s %s -> %s\n", p.Kind, p.Name, p.OldVersion, p.NewVersion)
+			}
+		}
+	}
+	if len(d.Files) != 0 {
+		sb.WriteString("Files:\n")
+		for _, f := range d.Files {
+			switch f.Kind {
+			case reader.Added:
+				fmt.Fprintf(&sb, "  %-10s /%s (%s)\n", f.Kind, f.Path, humanSize(uint64(f.New.Size)))
+			case reader.Removed:
+				fmt.Fprintf(&sb, "  %-10s /%s (%s)\n", f.Kind, f.Path, humanSize(uint64(f.Old.Size)))
+			default:
+				fmt.Fprintf(&sb, "  %-10s /%s (%s -> %s)\n", f.Kind, f.Path, humanSize(uint64(f.Old.Size)), humanSize(uint64(f.New.Size)))
+			}
+		}
+	}
+	fmt.Fprintf(&sb, "Layers: %s -> %s (%s)\n", humanSize(uint64(d.OldSize)), humanSize(uint64(d.NewSize)), sizeDelta(d.NewSize-d.OldSize))
+	fmt.Fprintf(&sb, "Files: %s -> %s (%s)\n", humanSize(uint64(d.OldFilesSize)), humanSize(uint64(d.NewFilesSize)), sizeDelta(d.NewFilesSize-d.OldFilesSize))
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// sizeDelta formats n with its sign, in binary units.
+func sizeDelta(n int64) string {
+	if n < 0 {
+		return "-" + humanSize(uint64(-n))
+	}
+	return "+" + humanSize(uint64(n))
+}
@@ -0,0 +1,49 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/reader"
+)
+
+func TestWriteDiff(t *testing.T) {
+	var sb strings.Builder
+	require.NoError(t, writeDiff(&sb, &reader.Diff{
+		Packages: []reader.PackageChange{
+			{Name: "busybox", Kind: reader.Upgraded, OldVersion: "1.37.0-r0", NewVersion: "1.37.0-r1"},
+			{Name: "glibc", Kind: reader.Added, NewVersion: "2.40-r0"},
+		},
+		Files: []reader.FileChange{
+			{Path: "bin/busybox", Kind: reader.Modified, Old: &reader.File{Size: 1024}, New: &reader.File{Size: 2048}},
+			{Path: "etc/motd", Kind: reader.Removed, Old: &reader.File{Size: 7}},
+		},
+		OldSize: 2048, NewSize: 1024,
+		OldFilesSize: 1031, NewFilesSize: 2048,
+	}))
+	require.Equal(t, `Packages:
+  upgraded   busybox 1.37.0-r0 -> 1.37.0-r1
+  added      glibc 2.40-r0
+Files:
+  modified   /bin/busybox (1.0 KiB -> 2.0 KiB)
+  removed    /etc/motd (7 B)
+Layers: 2.0 KiB -> 1.0 KiB (-1.0 KiB)
+Files: 1.0 KiB -> 2.0 KiB (+1017 B)
+`, sb.String())
+}
@@ -102,17 +102,10 @@ func DotCmd(ctx context.Context, configFile string, archs []types.Architecture,
 		return err
 	}
 
-	// cases:
-	// - archs set: use those archs
-	// - archs not set, bc.ImageConfiguration.Archs set: use Config archs
-	// - archs not set, bc.ImageConfiguration.Archs not set: use all archs
-	switch {
-	case len(archs) != 0:
-		ic.Archs = archs
-	case len(ic.Archs) != 0:
-		// do nothing
-	default:
-		ic.Archs = types.AllArchs
+	// Archs from the command line win over those of the configuration,
+	// which win over the defaults.
+	if err := ic.ResolveArchs(archs); err != nil {
+		return err
 	}
 	// save the final set we will build
 	archs = ic.Archs
@@ -0,0 +1,43 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimitRate(t *testing.T) {
+	for s, want := range map[string]int64{
+		"":            0,
+		"512":         512,
+		"2k":          2 << 10,
+		"10M":         10 << 20,
+		"1G":          1 << 30,
+		"8589934591G": 8589934591 << 30,
+	} {
+		got, err := parseLimitRate(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, got, s)
+	}
+
+	for _, s := range []string{"-1", "1T", "K", "8589934592G", "9223372036854775808"} {
+		_, err := parseLimitRate(s)
+		require.Error(t, err, s)
+	}
+	_, err := parseLimitRate("8589934592G")
+	require.ErrorContains(t, err, "too large")
+}
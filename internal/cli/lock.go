@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -70,6 +71,8 @@ func lockInternal(cmdName string, extension string, deprecated string) *cobra.Co
 	var includePaths []string
 	var ignoreSignatures bool
 	var cacheDir string
+	var perArch bool
+	var upgrade []string
 
 	cmd := &cobra.Command{
 		Use: cmdName,
@@ -85,10 +88,12 @@ func lockInternal(cmdName string, extension string, deprecated string) *cobra.Co
 
 			archs := types.ParseArchitectures(archstrs)
 
-			return LockCmd(
+			return lockImage(
 				cmd.Context(),
 				output,
 				archs,
+				perArch,
+				upgrade,
 				[]build.Option{
 					build.WithConfig(args[0], includePaths),
 					build.WithExtraKeys(extraKeys),
@@ -110,11 +115,36 @@ func lockInternal(cmdName string, extension string, deprecated string) *cobra.Co
 	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().BoolVar(&perArch, "per-arch", false, "lock each architecture in a file of its own, named after the output with the architecture before the extension (e.g. apko.lock.amd64.json)")
+	cmd.Flags().StringSliceVar(&upgrade, "upgrade", nil, "upgrade only these packages and their dependencies, keeping every other package at the version in the existing lock file")
 
 	return cmd
 }
 
 func LockCmd(ctx context.Context, output string, archs []types.Architecture, opts []build.Option) error {
+	return lockImage(ctx, output, archs, false, nil, opts)
+}
+
+// LockPerArchCmd is like LockCmd, but locks each architecture on its own, in
+// the file pkglock.ArchFile names next to output. Builds with output as their
+// lock file use these, so that an architecture whose packages resolve
+// differently does not change what the others are built from.
+func LockPerArchCmd(ctx context.Context, output string, archs []types.Architecture, opts []build.Option) error {
+	return lockImage(ctx, output, archs, true, nil, opts)
+}
+
+// LockUpgradeCmd is like LockCmd, but updates the existing lock at output
+// rather than locking from scratch: only the packages in upgrade and their
+// dependencies move to the newest versions that satisfy the configuration,
+// and every other package stays at the version it is locked at.
+func LockUpgradeCmd(ctx context.Context, output string, archs []types.Architecture, perArch bool, upgrade []string, opts []build.Option) error {
+	if len(upgrade) == 0 {
+		return fmt.Errorf("no packages to upgrade")
+	}
+	return lockImage(ctx, output, archs, perArch, upgrade, opts)
+}
+
+func lockImage(ctx context.Context, output string, archs []types.Architecture, perArch bool, upgrade []string, opts []build.Option) error {
 	log := clog.FromContext(ctx)
 	wd, err := os.MkdirTemp("", "apko-*")
 	if err != nil {
@@ -127,17 +157,10 @@ func LockCmd(ctx context.Context, output string, archs []types.Architecture, opt
 	if err != nil {
 		return err
 	}
-	// cases:
-	// - archs set: use those archs
-	// - archs not set, bc.ImageConfiguration.Archs set: use Config archs
-	// - archs not set, bc.ImageConfiguration.Archs not set: use all archs
-	switch {
-	case len(archs) != 0:
-		ic.Archs = archs
-	case len(ic.Archs) != 0:
-		// do nothing
-	default:
-		ic.Archs = types.AllArchs
+	// Archs from the command line win over those of the configuration,
+	// which win over the defaults.
+	if err := ic.ResolveArchs(archs); err != nil {
+		return err
 	}
 	// save the final set we will build
 	archs = ic.Archs
@@ -147,11 +170,144 @@ func LockCmd(ctx context.Context, output string, archs []types.Architecture, opt
 	// we have the directory defined and created by invoking the function early.
 	defer os.RemoveAll(o.TempDir())
 
+	lock := newLock(o.ImageConfigFile, o.ImageConfigChecksum, ic)
+
+	var previous pkglock.Lock
+	if len(upgrade) != 0 && !perArch {
+		if previous, err = pkglock.FromFile(output); err != nil {
+			return fmt.Errorf("reading lock file to upgrade: %w", err)
+		}
+	}
+
+	// TODO: If the archs can't agree on package versions (e.g., arm builds are ahead of x86) then we should fail instead of producing inconsistent locks.
+	for _, arch := range archs {
+		log := log.With("arch", arch.ToAPK())
+		ctx := clog.WithLogger(ctx, log)
+
+		// working directory for this architecture
+		wd := filepath.Join(wd, arch.ToAPK())
+		bopts := append(slices.Clone(opts), build.WithArch(arch))
+		fs := apkfs.DirFS(ctx, wd, apkfs.WithCreateDir())
+		bc, err := build.New(ctx, fs, bopts...)
+		if err != nil {
+			return err
+		}
+
+		if len(upgrade) != 0 {
+			if perArch {
+				if previous, err = pkglock.FromFile(pkglock.ArchFile(output, arch)); err != nil {
+					return fmt.Errorf("reading lock file to upgrade: %w", err)
+				}
+			}
+			pins, err := upgradePins(ctx, bc, previous, arch, upgrade)
+			if err != nil {
+				return err
+			}
+			log.Infof("Upgrading %v, keeping %d locked packages", upgrade, len(pins))
+			fs := apkfs.DirFS(ctx, wd+"-upgrade", apkfs.WithCreateDir())
+			if bc, err = build.New(ctx, fs, append(bopts, build.WithExtraPackages(pins))...); err != nil {
+				return err
+			}
+		}
+
+		if !perArch {
+			if err := lockArch(ctx, &lock, bc, ic, arch); err != nil {
+				return err
+			}
+			continue
+		}
+		archLock := newLock(o.ImageConfigFile, o.ImageConfigChecksum, ic)
+		if err := lockArch(ctx, &archLock, bc, ic, arch); err != nil {
+			return err
+		}
+		if err := archLock.SaveToFile(pkglock.ArchFile(output, arch)); err != nil {
+			return err
+		}
+	}
+	if perArch {
+		return nil
+	}
+	return lock.SaveToFile(output)
+}
+
+// upgradePins returns the packages previous locks for arch, as name=version
+// constraints, other than those in upgrade, the packages they depend on as bc
+// resolves them now, and packages bc no longer needs at all.
+func upgradePins(ctx context.Context, bc *build.Context, previous pkglock.Lock, arch types.Architecture, upgrade []string) ([]string, error) {
+	pkgs, _, err := bc.BuildPackageList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package list for image: %w", err)
+	}
+	upgraded, err := dependencyClosure(pkgs, upgrade)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[string]bool, len(pkgs))
+	for _, pkg := range pkgs {
+		resolved[pkg.Name] = true
+	}
+
+	var pins []string
+	for _, p := range previous.Contents.Packages {
+		if types.ParseArchitecture(p.Architecture) != arch || upgraded[p.Name] || !resolved[p.Name] {
+			continue
+		}
+		pins = append(pins, fmt.Sprintf("%s=%s", p.Name, p.Version))
+	}
+	return pins, nil
+}
+
+// dependencyClosure returns the names of the packages in pkgs that names
+// refer to, directly or through something they provide, and of all the
+// packages in pkgs they depend on.
+func dependencyClosure(pkgs []*apk.RepositoryPackage, names []string) (map[string]bool, error) {
+	providers := make(map[string]*apk.RepositoryPackage, len(pkgs))
+	for _, pkg := range pkgs {
+		providers[pkg.Name] = pkg
+		for _, prov := range pkg.Provides {
+			name := apk.ResolvePackageNameVersionPin(prov).Name
+			if _, ok := providers[name]; !ok {
+				providers[name] = pkg
+			}
+		}
+	}
+
+	closure := map[string]bool{}
+	var queue []*apk.RepositoryPackage
+	for _, name := range names {
+		pkg, ok := providers[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot upgrade %q: it is not in the image", name)
+		}
+		queue = append(queue, pkg)
+	}
+	for len(queue) != 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if closure[pkg.Name] {
+			continue
+		}
+		closure[pkg.Name] = true
+		for _, dep := range pkg.Dependencies {
+			if strings.HasPrefix(dep, "!") {
+				continue
+			}
+			if p, ok := providers[apk.ResolvePackageNameVersionPin(dep).Name]; ok {
+				queue = append(queue, p)
+			}
+		}
+	}
+	return closure, nil
+}
+
+// newLock returns a lock for the configuration ic, read from configFile,
+// with its keyrings but none of its packages and repositories yet.
+func newLock(configFile, checksum string, ic *types.ImageConfiguration) pkglock.Lock {
 	lock := pkglock.Lock{
 		Version: "v1",
 		Config: &pkglock.Config{
-			Name:         o.ImageConfigFile,
-			DeepChecksum: o.ImageConfigChecksum,
+			Name:         configFile,
+			DeepChecksum: checksum,
 		},
 		Contents: pkglock.LockContents{
 			Packages:                make([]pkglock.LockPkg, 0, len(ic.Contents.Packages)),
@@ -168,75 +324,88 @@ func LockCmd(ctx context.Context, output string, archs []types.Architecture, opt
 			URL:  keyring,
 		})
 	}
+	return lock
+}
 
-	// TODO: If the archs can't agree on package versions (e.g., arm builds are ahead of x86) then we should fail instead of producing inconsistent locks.
-	for _, arch := range archs {
-		log := log.With("arch", arch.ToAPK())
-		ctx := clog.WithLogger(ctx, log)
-
-		// working directory for this architecture
-		wd := filepath.Join(wd, arch.ToAPK())
-		bopts := append(slices.Clone(opts), build.WithArch(arch))
-		fs := apkfs.DirFS(ctx, wd, apkfs.WithCreateDir())
-		bc, err := build.New(ctx, fs, bopts...)
-		if err != nil {
-			return err
-		}
+// lockArch adds to lock the packages bc resolves for arch, and the
+// repositories and keyrings of ic for it.
+func lockArch(ctx context.Context, lock *pkglock.Lock, bc *build.Context, ic *types.ImageConfiguration, arch types.Architecture) error {
+	resolvedPkgs, err := bc.ResolveWithBase(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get package list for image: %w", err)
+	}
+	lock.Contents.Packages = append(lock.Contents.Packages, lockPkgs(resolvedPkgs)...)
+	return lockSources(lock, ic, arch)
+}
 
-		resolvedPkgs, err := bc.ResolveWithBase(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to get package list for image: %w", err)
+// lockPkgs returns the lock entries of resolved.
+func lockPkgs(resolved []*apk.APKResolved) []pkglock.LockPkg {
+	pkgs := make([]pkglock.LockPkg, 0, len(resolved))
+	for _, rpkg := range resolved {
+		lockPkg := pkglock.LockPkg{
+			Name:         rpkg.Package.Name,
+			URL:          rpkg.Package.URL(),
+			Architecture: rpkg.Package.Arch,
+			Version:      rpkg.Package.Version,
+			Control: pkglock.LockPkgRangeAndChecksum{
+				Range:    fmt.Sprintf("bytes=%d-%d", rpkg.SignatureSize, rpkg.SignatureSize+rpkg.ControlSize-1),
+				Checksum: "sha1-" + base64.StdEncoding.EncodeToString(rpkg.ControlHash),
+			},
+			Data: pkglock.LockPkgRangeAndChecksum{
+				Range:    fmt.Sprintf("bytes=%d-%d", rpkg.SignatureSize+rpkg.ControlSize, rpkg.SignatureSize+rpkg.ControlSize+rpkg.DataSize-1),
+				Checksum: "sha256-" + base64.StdEncoding.EncodeToString(rpkg.DataHash),
+			},
+			Checksum: rpkg.Package.ChecksumString(),
 		}
 
-		for _, rpkg := range resolvedPkgs {
-			lockPkg := pkglock.LockPkg{
-				Name:         rpkg.Package.Name,
-				URL:          rpkg.Package.URL(),
-				Architecture: rpkg.Package.Arch,
-				Version:      rpkg.Package.Version,
-				Control: pkglock.LockPkgRangeAndChecksum{
-					Range:    fmt.Sprintf("bytes=%d-%d", rpkg.SignatureSize, rpkg.SignatureSize+rpkg.ControlSize-1),
-					Checksum: "sha1-" + base64.StdEncoding.EncodeToString(rpkg.ControlHash),
-				},
-				Data: pkglock.LockPkgRangeAndChecksum{
-					Range:    fmt.Sprintf("bytes=%d-%d", rpkg.SignatureSize+rpkg.ControlSize, rpkg.SignatureSize+rpkg.ControlSize+rpkg.DataSize-1),
-					Checksum: "sha256-" + base64.StdEncoding.EncodeToString(rpkg.DataHash),
-				},
-				Checksum: rpkg.Package.ChecksumString(),
+		if rpkg.SignatureSize != 0 {
+			lockPkg.Signature = pkglock.LockPkgRangeAndChecksum{
+				Range:    fmt.Sprintf("bytes=0-%d", rpkg.SignatureSize-1),
+				Checksum: "sha1-" + base64.StdEncoding.EncodeToString(rpkg.SignatureHash),
 			}
+		}
 
-			if rpkg.SignatureSize != 0 {
-				lockPkg.Signature = pkglock.LockPkgRangeAndChecksum{
-					Range:    fmt.Sprintf("bytes=0-%d", rpkg.SignatureSize-1),
-					Checksum: "sha1-" + base64.StdEncoding.EncodeToString(rpkg.SignatureHash),
-				}
-			}
+		pkgs = append(pkgs, lockPkg)
+	}
+	return pkgs
+}
 
-			lock.Contents.Packages = append(lock.Contents.Packages, lockPkg)
+// lockSources adds the repositories and keyrings of ic for arch to lock.
+func lockSources(lock *pkglock.Lock, ic *types.ImageConfiguration, arch types.Architecture) error {
+	for _, a := range slices.Sorted(maps.Keys(ic.Contents.ArchKeyring)) {
+		if types.ParseArchitecture(a) != arch {
+			continue
 		}
-		for _, repositoryURI := range ic.Contents.BuildRepositories {
-			repoLock, err := repoLock(repositoryURI, arch)
-			if err != nil {
-				return fmt.Errorf("locking build repositories: %w", err)
-			}
-			lock.Contents.BuildRepositories = append(lock.Contents.BuildRepositories, repoLock)
+		for _, keyring := range ic.Contents.ArchKeyring[a] {
+			lock.Contents.Keyrings = append(lock.Contents.Keyrings, pkglock.LockKeyring{
+				Name:         stripURLScheme(keyring),
+				URL:          keyring,
+				Architecture: arch.ToAPK(),
+			})
 		}
-		for _, repositoryURI := range ic.Contents.RuntimeOnlyRepositories {
-			repoLock, err := repoLock(repositoryURI, arch)
-			if err != nil {
-				return fmt.Errorf("locking runtime repositories: %w", err)
-			}
-			lock.Contents.RuntimeOnlyRepositories = append(lock.Contents.RuntimeOnlyRepositories, repoLock)
+	}
+	for _, repositoryURI := range ic.Contents.BuildRepositories {
+		repoLock, err := repoLock(repositoryURI, arch)
+		if err != nil {
+			return fmt.Errorf("locking build repositories: %w", err)
 		}
-		for _, repositoryURI := range ic.Contents.Repositories {
-			repoLock, err := repoLock(repositoryURI, arch)
-			if err != nil {
-				return fmt.Errorf("locking repositories: %w", err)
-			}
-			lock.Contents.Repositories = append(lock.Contents.Repositories, repoLock)
+		lock.Contents.BuildRepositories = append(lock.Contents.BuildRepositories, repoLock)
+	}
+	for _, repositoryURI := range ic.Contents.RuntimeOnlyRepositories {
+		repoLock, err := repoLock(repositoryURI, arch)
+		if err != nil {
+			return fmt.Errorf("locking runtime repositories: %w", err)
 		}
+		lock.Contents.RuntimeOnlyRepositories = append(lock.Contents.RuntimeOnlyRepositories, repoLock)
 	}
-	return lock.SaveToFile(output)
+	for _, repositoryURI := range ic.Contents.Repositories {
+		repoLock, err := repoLock(repositoryURI, arch)
+		if err != nil {
+			return fmt.Errorf("locking repositories: %w", err)
+		}
+		lock.Contents.Repositories = append(lock.Contents.Repositories, repoLock)
+	}
+	return nil
 }
 
 func repoLock(repositoryURI string, arch types.Architecture) (pkglock.LockRepo, error) {
@@ -19,6 +19,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -27,6 +28,7 @@ import (
 	"chainguard.dev/apko/internal/cli"
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
 )
 
 func TestLock(t *testing.T) {
@@ -55,6 +57,130 @@ func TestLock(t *testing.T) {
 	}
 }
 
+func TestLockPerArch(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{build.WithConfig("apko.yaml", []string{"testdata"})}
+	outputPath := filepath.Join(tmp, "apko.lock.json")
+
+	require.NoError(t, cli.LockPerArchCmd(ctx, outputPath, archs, opts))
+	require.NoFileExists(t, outputPath)
+
+	for _, arch := range archs {
+		l, err := pkglock.FromFile(pkglock.ArchFile(outputPath, arch))
+		require.NoError(t, err)
+		require.NotEmpty(t, l.Contents.Packages)
+		for _, p := range l.Contents.Packages {
+			require.Equal(t, arch, types.ParseArchitecture(p.Architecture))
+		}
+	}
+
+	// Building with the lock file uses the lock of each architecture.
+	_, ic, err := build.NewOptions(opts...)
+	require.NoError(t, err)
+	ic.Archs = archs
+	configs, _, err := build.LockImageConfiguration(ctx, *ic, append(opts, build.WithLockFile(outputPath))...)
+	require.NoError(t, err)
+	for _, arch := range archs {
+		require.NotEmpty(t, configs[arch.String()].Contents.Packages)
+	}
+}
+
+func TestLockVersionRange(t *testing.T) {
+	ctx := context.Background()
+	archs := types.ParseArchitectures([]string{"amd64"})
+	testdata, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	// lock locks a configuration asking for replayout within the range.
+	lock := func(t *testing.T, constraint string) (pkglock.Lock, error) {
+		dir := t.TempDir()
+		config := filepath.Join(dir, "apko.yaml")
+		require.NoError(t, os.WriteFile(config, []byte(`contents:
+  keyring:
+    - `+filepath.Join(testdata, "melange.rsa.pub")+`
+  repositories:
+    - `+filepath.Join(testdata, "packages")+`
+  packages:
+    - "`+constraint+`"
+archs:
+- x86_64
+`), 0o644))
+		outputPath := filepath.Join(dir, "apko.lock.json")
+		if err := cli.LockCmd(ctx, outputPath, archs, []build.Option{build.WithConfig(config, nil)}); err != nil {
+			return pkglock.Lock{}, err
+		}
+		return pkglock.FromFile(outputPath)
+	}
+
+	l, err := lock(t, "replayout>=1.0,<2")
+	require.NoError(t, err)
+	var versions []string
+	for _, p := range l.Contents.Packages {
+		if p.Name == "replayout" {
+			versions = append(versions, p.Version)
+		}
+	}
+	require.Equal(t, []string{"1.0.0-r0"}, versions)
+
+	// The only version is outside the range, which both bounds enforce.
+	_, err = lock(t, "replayout>=0.5,<1.0")
+	require.ErrorContains(t, err, "replayout")
+	_, err = lock(t, "replayout>=1.0.1,<2")
+	require.ErrorContains(t, err, "replayout")
+}
+
+func TestLockUpgrade(t *testing.T) {
+	ctx := context.Background()
+
+	golden := filepath.Join("testdata", "apko.lock.json")
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{build.WithConfig("apko.yaml", []string{"testdata"})}
+
+	// staleLock writes the golden lock file with the given packages locked
+	// at a version that is no longer in the repository.
+	staleLock := func(t *testing.T, stale ...string) string {
+		l, err := pkglock.FromFile(golden)
+		require.NoError(t, err)
+		for i, p := range l.Contents.Packages {
+			if slices.Contains(stale, p.Name) {
+				l.Contents.Packages[i].Version = "0.9.0-r0"
+			}
+		}
+		outputPath := filepath.Join(t.TempDir(), "apko.lock.json")
+		require.NoError(t, l.SaveToFile(outputPath))
+		return outputPath
+	}
+
+	t.Run("upgrades dependencies", func(t *testing.T) {
+		outputPath := staleLock(t, "replayout", "pretend-baselayout")
+		require.NoError(t, cli.LockUpgradeCmd(ctx, outputPath, archs, false, []string{"replayout"}, opts))
+
+		want, err := os.ReadFile(golden)
+		require.NoError(t, err)
+		got, err := os.ReadFile(outputPath)
+		require.NoError(t, err)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Mismatched lock files: (-%q +%q):\n%s", golden, outputPath, diff)
+		}
+	})
+
+	t.Run("keeps other packages locked", func(t *testing.T) {
+		// replayout depends on pretend-baselayout, not the other way around,
+		// so upgrading pretend-baselayout leaves replayout at the version
+		// it is locked at, which cannot be installed.
+		outputPath := staleLock(t, "replayout")
+		require.ErrorContains(t, cli.LockUpgradeCmd(ctx, outputPath, archs, false, []string{"pretend-baselayout"}, opts), "replayout=0.9.0-r0")
+	})
+
+	t.Run("unknown package", func(t *testing.T) {
+		outputPath := staleLock(t)
+		require.ErrorContains(t, cli.LockUpgradeCmd(ctx, outputPath, archs, false, []string{"not-installed"}, opts), `cannot upgrade "not-installed"`)
+	})
+}
+
 func TestLockWithBaseImage(t *testing.T) {
 	ctx := context.Background()
 	tmp := t.TempDir()
@@ -1,8 +1,24 @@
 package cli
 
+import (
+	"fmt"
+	"slices"
+)
+
 type publishOpt struct {
 	local bool
 	tags  []string
+
+	reuseReport string
+
+	attachSBOMs      bool
+	attachProvenance bool
+	attachArtifacts  []string
+	signKey          string
+	// referrerAnnotations are set on the manifests of attached artifacts.
+	referrerAnnotations map[string]string
+
+	dryRun bool
 }
 
 // PublishOption is an option for publishing
@@ -16,6 +32,89 @@ func WithLocal(local bool) PublishOption {
 	}
 }
 
+// WithReuseReport sets the path to write a JSON report to of how many of the
+// blobs of each image were already in the destination repository, and how
+// many had to be uploaded.
+func WithReuseReport(path string) PublishOption {
+	return func(p *publishOpt) error {
+		p.reuseReport = path
+		return nil
+	}
+}
+
+// WithAttachSBOMs sets whether to push the SBOMs of the published images
+// and index as OCI artifacts that refer to them.
+func WithAttachSBOMs(attach bool) PublishOption {
+	return func(p *publishOpt) error {
+		p.attachSBOMs = attach
+		return nil
+	}
+}
+
+// WithAttachProvenance sets whether to push the provenance written with
+// --provenance as an OCI artifact that refers to the published index.
+func WithAttachProvenance(attach bool) PublishOption {
+	return func(p *publishOpt) error {
+		p.attachProvenance = attach
+		return nil
+	}
+}
+
+// WithSignKey sets the path to a PEM private key to sign the published index
+// with, pushing the signature as a cosign signature that refers to it. An
+// empty path signs nothing.
+func WithSignKey(path string) PublishOption {
+	return func(p *publishOpt) error {
+		p.signKey = path
+		return nil
+	}
+}
+
+// The build products, other than SBOMs and provenance, that
+// WithAttachArtifacts can attach.
+const (
+	artifactRootFS        = "rootfs"
+	artifactBuildReport   = "build-report"
+	artifactFetchManifest = "fetch-manifest"
+)
+
+var attachableArtifacts = []string{artifactRootFS, artifactBuildReport, artifactFetchManifest}
+
+// WithAttachArtifacts sets the build products to push as OCI artifacts that
+// refer to the published images or index: "rootfs" for the filesystem of
+// each image, "build-report" for the report written with --build-report and
+// "fetch-manifest" for the lock file of the packages fetched.
+func WithAttachArtifacts(kinds ...string) PublishOption {
+	return func(p *publishOpt) error {
+		for _, k := range kinds {
+			if !slices.Contains(attachableArtifacts, k) {
+				return fmt.Errorf("unknown artifact %q, expected one of %v", k, attachableArtifacts)
+			}
+		}
+		p.attachArtifacts = kinds
+		return nil
+	}
+}
+
+// WithReferrerAnnotations sets annotations for the manifests of the SBOMs,
+// provenance and other artifacts attached to the published images and index,
+// e.g. for registry retention or access policies to key off.
+func WithReferrerAnnotations(annotations map[string]string) PublishOption {
+	return func(p *publishOpt) error {
+		p.referrerAnnotations = annotations
+		return nil
+	}
+}
+
+// WithDryRun sets whether to only print the manifests that publishing would
+// push, without writing anything to the registry.
+func WithDryRun(dryRun bool) PublishOption {
+	return func(p *publishOpt) error {
+		p.dryRun = dryRun
+		return nil
+	}
+}
+
 // WithTags tags to use
 func WithTags(tags ...string) PublishOption {
 	return func(p *publishOpt) error {
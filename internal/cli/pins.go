@@ -0,0 +1,90 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
+)
+
+// freshPins reports whether the pin file at path can be built from at now:
+// it exists, records that its packages were resolved less than maxAge ago,
+// and was recorded for the configuration with the given checksum. The time
+// is read from the file rather than its modification time, which copies and
+// checkouts do not keep.
+func freshPins(path string, maxAge time.Duration, checksum string, now time.Time) (bool, error) {
+	if maxAge <= 0 {
+		return false, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("checking pin file: %w", err)
+	}
+	lock, err := pkglock.FromFile(path)
+	if err != nil {
+		return false, fmt.Errorf("reading pin file %s: %w", path, err)
+	}
+	if lock.Config == nil || lock.Config.PinnedAt == nil || now.Sub(*lock.Config.PinnedAt) >= maxAge {
+		return false, nil
+	}
+	return lock.Config.DeepChecksum == checksum, nil
+}
+
+// pinArch adds to lock the packages bc installed for arch, as the build
+// resolved and fetched them, and the repositories and keyrings of ic for
+// it. Builds from a lock file have their packages resolved again.
+func pinArch(ctx context.Context, lock *pkglock.Lock, bc *build.Context, ic *types.ImageConfiguration, arch types.Architecture) error {
+	resolved := bc.ResolvedPackages()
+	if len(resolved) == 0 {
+		return lockArch(ctx, lock, bc, ic, arch)
+	}
+	lock.Contents.Packages = append(lock.Contents.Packages, lockPkgs(resolved)...)
+	return lockSources(lock, ic, arch)
+}
+
+// writePins adds the packages, repositories and keyrings pinned for each of
+// archs to lock, in order, records that they were resolved at now, and
+// saves it to path.
+func writePins(path string, lock pkglock.Lock, archs []types.Architecture, pins map[types.Architecture]pkglock.Lock, now time.Time) error {
+	for _, arch := range archs {
+		pinned, ok := pins[arch]
+		if !ok {
+			continue
+		}
+		lock.Contents.Keyrings = append(lock.Contents.Keyrings, pinned.Contents.Keyrings...)
+		lock.Contents.BuildRepositories = append(lock.Contents.BuildRepositories, pinned.Contents.BuildRepositories...)
+		lock.Contents.RuntimeOnlyRepositories = append(lock.Contents.RuntimeOnlyRepositories, pinned.Contents.RuntimeOnlyRepositories...)
+		lock.Contents.Repositories = append(lock.Contents.Repositories, pinned.Contents.Repositories...)
+		lock.Contents.Packages = append(lock.Contents.Packages, pinned.Contents.Packages...)
+	}
+	if lock.Config != nil {
+		config := *lock.Config
+		now = now.UTC()
+		config.PinnedAt = &now
+		lock.Config = &config
+	}
+	if err := lock.SaveToFile(path); err != nil {
+		return fmt.Errorf("writing pin file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestPins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins.lock.json")
+	now := time.Now()
+
+	fresh, err := freshPins(path, time.Hour, "sha256-abc", now)
+	require.NoError(t, err)
+	require.False(t, fresh, "missing pin file")
+
+	ic := &types.ImageConfiguration{Contents: types.ImageContents{Keyring: []string{"https://example.com/key.rsa.pub"}}}
+	lock := newLock("apko.yaml", "sha256-abc", ic)
+	amd64, arm64 := types.ParseArchitecture("amd64"), types.ParseArchitecture("arm64")
+	pins := map[types.Architecture]pkglock.Lock{
+		arm64: {Contents: pkglock.LockContents{Packages: []pkglock.LockPkg{{Name: "busybox", Version: "1.37.0-r1", Architecture: "aarch64"}}}},
+		amd64: {Contents: pkglock.LockContents{Packages: []pkglock.LockPkg{{Name: "busybox", Version: "1.37.0-r0", Architecture: "x86_64"}}}},
+	}
+	require.NoError(t, writePins(path, lock, []types.Architecture{amd64, arm64}, pins, now))
+
+	got, err := pkglock.FromFile(path)
+	require.NoError(t, err)
+	require.True(t, now.Equal(*got.Config.PinnedAt))
+	require.Len(t, got.Contents.Keyrings, 1)
+	require.Equal(t, []string{"busybox=1.37.0-r0"}, got.Arch2LockedPackages([]types.Architecture{amd64})["amd64"])
+	require.Equal(t, "x86_64", got.Contents.Packages[0].Architecture)
+
+	for _, tc := range []struct {
+		name     string
+		maxAge   time.Duration
+		checksum string
+		at       time.Time
+		want     bool
+	}{
+		{name: "fresh", maxAge: time.Hour, checksum: "sha256-abc", at: now, want: true},
+		{name: "always resolve", maxAge: 0, checksum: "sha256-abc", at: now},
+		{name: "expired", maxAge: time.Hour, checksum: "sha256-abc", at: now.Add(2 * time.Hour)},
+		{name: "config changed", maxAge: time.Hour, checksum: "sha256-def", at: now},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fresh, err := freshPins(path, tc.maxAge, tc.checksum, tc.at)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, fresh)
+		})
+	}
+
+	// Copies and checkouts reset the modification time, which says
+	// nothing about when the packages were resolved.
+	old := now.Add(-2 * time.Hour)
+	require.NoError(t, writePins(path, lock, []types.Architecture{amd64}, pins, old))
+	require.NoError(t, os.Chtimes(path, now, now))
+	fresh, err = freshPins(path, time.Hour, "sha256-abc", now)
+	require.NoError(t, err)
+	require.False(t, fresh, "resolved too long ago")
+
+	// Lock files that do not say when they were resolved are not fresh.
+	lock.Config.PinnedAt = nil
+	require.NoError(t, lock.SaveToFile(path))
+	fresh, err = freshPins(path, time.Hour, "sha256-abc", now)
+	require.NoError(t, err)
+	require.False(t, fresh, "no pin time")
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o644))
+	_, err = freshPins(path, time.Hour, "sha256-abc", now)
+	require.Error(t, err)
+}
+
+func TestArtifactPathsFetchManifest(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "apko.lock.json")
+	amd64, arm64 := types.ParseArchitecture("amd64"), types.ParseArchitecture("arm64")
+	archs := []types.Architecture{amd64, arm64}
+	require.NoError(t, (pkglock.Lock{Version: "v1"}).SaveToFile(pkglock.ArchFile(lockFile, arm64)))
+
+	// The architecture locked on its own attaches its own lock file.
+	artifacts, err := artifactPaths(&options.Options{Lockfile: lockFile}, archs, []string{artifactFetchManifest})
+	require.NoError(t, err)
+	paths := make([]string, 0, len(artifacts))
+	for _, a := range artifacts {
+		paths = append(paths, a.path)
+	}
+	require.Equal(t, []string{lockFile, pkglock.ArchFile(lockFile, arm64)}, paths)
+
+	artifacts, err = artifactPaths(&options.Options{PinFile: "pins.lock.json"}, archs, []string{artifactFetchManifest})
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+	require.Equal(t, "pins.lock.json", artifacts[0].path)
+
+	_, err = artifactPaths(&options.Options{}, archs, []string{artifactFetchManifest})
+	require.ErrorContains(t, err, "--lockfile or --pin-file")
+}
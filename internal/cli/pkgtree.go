@@ -0,0 +1,164 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// pkgEdge links a package in a pkgTree to another, through the dependency
+// or the contents.packages entries it was resolved from.
+type pkgEdge struct {
+	pkg string
+	via []string
+}
+
+// pkgTree is the dependency graph of a resolved package list. The empty name
+// stands for contents.packages, which depends on the requested packages.
+type pkgTree struct {
+	pkgs      map[string]*apk.RepositoryPackage
+	providers map[string]string
+	deps      map[string][]pkgEdge
+	rdeps     map[string][]pkgEdge
+}
+
+// newPkgTree links the packages in pkgs by their dependencies, each to the
+// package in pkgs providing it, and the packages requested by world.
+func newPkgTree(world []string, pkgs []*apk.RepositoryPackage) *pkgTree {
+	t := &pkgTree{
+		pkgs:      make(map[string]*apk.RepositoryPackage, len(pkgs)),
+		providers: make(map[string]string, len(pkgs)),
+		deps:      map[string][]pkgEdge{},
+		rdeps:     map[string][]pkgEdge{},
+	}
+	for _, pkg := range pkgs {
+		t.pkgs[pkg.Name] = pkg
+		t.providers[pkg.Name] = pkg.Name
+	}
+	for _, pkg := range pkgs {
+		for _, p := range pkg.Provides {
+			name := apk.ResolvePackageNameVersionPin(p).Name
+			if _, ok := t.providers[name]; !ok {
+				t.providers[name] = pkg.Name
+			}
+		}
+	}
+
+	t.link("", world)
+	for _, pkg := range pkgs {
+		t.link(pkg.Name, pkg.Dependencies)
+	}
+	return t
+}
+
+// link adds the edges from the package from to the packages providing deps.
+func (t *pkgTree) link(from string, deps []string) {
+	via := map[string][]string{}
+	for _, dep := range deps {
+		if strings.HasPrefix(dep, "!") {
+			continue
+		}
+		to, ok := t.providers[apk.ResolvePackageNameVersionPin(dep).Name]
+		if !ok || to == from {
+			continue
+		}
+		via[to] = append(via[to], dep)
+	}
+	for to, v := range via {
+		t.deps[from] = append(t.deps[from], pkgEdge{pkg: to, via: v})
+		t.rdeps[to] = append(t.rdeps[to], pkgEdge{pkg: from, via: v})
+	}
+	byName := func(a, b pkgEdge) int { return cmp.Compare(a.pkg, b.pkg) }
+	slices.SortFunc(t.deps[from], byName)
+	for to := range via {
+		slices.SortFunc(t.rdeps[to], byName)
+	}
+}
+
+// label describes the package name.
+func (t *pkgTree) label(name string) string {
+	if name == "" {
+		return "contents.packages"
+	}
+	pkg := t.pkgs[name]
+	if repo := pkg.Repository(); repo != nil {
+		return fmt.Sprintf("%s %s %s", pkg.Name, pkg.Version, repo.URI)
+	}
+	return fmt.Sprintf("%s %s", pkg.Name, pkg.Version)
+}
+
+// writeTree writes the packages requested by contents.packages, each followed
+// by the tree of its dependencies. A package already shown is marked (*)
+// rather than shown again.
+func (t *pkgTree) writeTree(w io.Writer) error {
+	return t.write(w, t.deps, "")
+}
+
+// writeWhy writes the packages that depend on the package providing name,
+// each followed by the packages that depend on it in turn, up to
+// contents.packages.
+func (t *pkgTree) writeWhy(w io.Writer, name string) error {
+	pkg, ok := t.providers[apk.ResolvePackageNameVersionPin(name).Name]
+	if !ok {
+		return fmt.Errorf("nothing installed provides %q", name)
+	}
+	if _, err := fmt.Fprintln(w, t.label(pkg)); err != nil {
+		return err
+	}
+	return t.write(w, t.rdeps, pkg)
+}
+
+func (t *pkgTree) write(w io.Writer, edges map[string][]pkgEdge, root string) error {
+	seen := map[string]bool{root: true}
+	var walk func(name, prefix string) error
+	walk = func(name, prefix string) error {
+		children := edges[name]
+		for i, e := range children {
+			branch, indent := "├── ", "│   "
+			if i == len(children)-1 {
+				branch, indent = "└── ", "    "
+			}
+			if root == "" && name == "" {
+				// The requested packages start the tree.
+				branch, indent = "", ""
+			}
+			line := prefix + branch + t.label(e.pkg)
+			if len(e.via) != 1 || e.via[0] != e.pkg {
+				line += fmt.Sprintf(" (via %s)", strings.Join(e.via, ", "))
+			}
+			expand := !seen[e.pkg] && len(edges[e.pkg]) != 0
+			if seen[e.pkg] && len(edges[e.pkg]) != 0 {
+				line += " (*)"
+			}
+			seen[e.pkg] = true
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			if expand {
+				if err := walk(e.pkg, prefix+indent); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(root, "")
+}
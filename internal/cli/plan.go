@@ -0,0 +1,100 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/sbom"
+)
+
+func planCmd() *cobra.Command {
+	var extraKeys []string
+	var extraBuildRepos []string
+	var extraRepos []string
+	var extraPackages []string
+	var archstrs []string
+	var sbomFormats []string
+	var cacheDir string
+	var offline bool
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Print the build plan for a configuration as JSON, without building it",
+		Long: `Print the build plan for a configuration as JSON, without building it.
+
+The plan lists the packages resolved for each architecture, how they would be
+split into layers, the files apko synthesizes, the image's runtime
+configuration and the tags it would be published to. It is meant for reviewing
+a change, or checking it against policy (e.g. with conftest), before building.
+`,
+		Example: `  apko plan <config.yaml> [tag...]`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("creating %s: %w", output, err)
+				}
+				defer f.Close()
+				out = f
+			}
+			return PlanCmd(cmd.Context(), out, types.ParseArchitectures(archstrs),
+				build.WithConfig(args[0], []string{}),
+				build.WithTags(args[1:]...),
+				build.WithSBOMFormats(sbomFormats),
+				build.WithExtraKeys(extraKeys),
+				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithExtraRepos(extraRepos),
+				build.WithExtraPackages(extraPackages),
+				build.WithCache(cacheDir, offline, apk.NewCache(true)),
+			)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
+	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
+	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
+	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the plan to (default is stdout)")
+
+	return cmd
+}
+
+// PlanCmd writes the build plan as indented JSON to w.
+func PlanCmd(ctx context.Context, w io.Writer, archs []types.Architecture, opts ...build.Option) error {
+	plan, err := build.GeneratePlan(ctx, archs, opts...)
+	if err != nil {
+		return fmt.Errorf("generating plan: %w", err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
@@ -0,0 +1,139 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/tarfs"
+)
+
+func prefetchCmd() *cobra.Command {
+	var archstrs []string
+	var cacheDir string
+	var cacheNamespace string
+	var lowerCacheDir string
+	var cacheStoreSpec string
+	var ignoreSignatures bool
+	var jobs int
+
+	cmd := &cobra.Command{
+		Use:   "prefetch <lockfile>...",
+		Short: "Populate the apk cache with everything named in one or more lock files",
+		Long: `Populate the apk cache with everything named in one or more lock files.
+
+The keyrings, repository indexes and packages pinned by each lock file are
+downloaded into the cache directory in parallel, so that later builds (for
+example with --offline) do not need to touch the network.`,
+		Example: `  apko prefetch apko.lock.json
+  apko prefetch --cache-dir /var/cache/apko images/*.lock.json`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cacheStore, err := openCacheStore(cmd.Context(), cacheStoreSpec)
+			if err != nil {
+				return err
+			}
+			return PrefetchCmd(cmd.Context(), args, types.ParseArchitectures(archstrs), jobs,
+				build.WithCache(cacheDir, false, apk.NewCache(true)),
+				build.WithCacheNamespace(cacheNamespace),
+				build.WithLowerCache(lowerCacheDir),
+				build.WithCacheStore(cacheStore),
+				build.WithIgnoreSignatures(ignoreSignatures),
+			)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to prefetch (e.g., x86_64,arm64) -- default is every architecture in the lock file")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().StringVar(&cacheNamespace, "cache-namespace", "", "keep the package and layer caches of this build apart from other namespaces sharing the cache directory")
+	cmd.Flags().StringVar(&lowerCacheDir, "lower-cache-dir", "", "read-only package cache consulted when the cache misses, e.g. one shared by every namespace")
+	cmd.Flags().StringVar(&cacheStoreSpec, "cache-store", "", cacheStoreUsage)
+	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.GOMAXPROCS(0), "maximum number of concurrent package downloads per architecture")
+
+	return cmd
+}
+
+// PrefetchCmd downloads the contents of each of the lock files into the cache
+// configured by opts. When archs is empty, every architecture that appears in
+// a lock file is prefetched.
+func PrefetchCmd(ctx context.Context, lockfiles []string, archs []types.Architecture, jobs int, opts ...build.Option) error {
+	log := clog.FromContext(ctx)
+
+	var g errgroup.Group
+	for _, lockfile := range lockfiles {
+		l, err := pkglock.FromFile(lockfile)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", lockfile, err)
+		}
+
+		for _, arch := range lockArchs(l) {
+			if len(archs) != 0 && !slices.Contains(archs, arch) {
+				continue
+			}
+
+			g.Go(func() error {
+				log := log.With("lockfile", lockfile, "arch", arch.ToAPK())
+				ctx := clog.WithLogger(ctx, log)
+
+				tmp, err := os.MkdirTemp("", "apko-prefetch-*")
+				if err != nil {
+					return fmt.Errorf("creating tempdir: %w", err)
+				}
+				defer os.RemoveAll(tmp)
+
+				bopts := append(slices.Clone(opts),
+					build.WithImageConfiguration(build.PrefetchConfiguration(l, arch)),
+					build.WithArch(arch),
+					build.WithTempDir(tmp),
+				)
+				bc, err := build.New(ctx, tarfs.New(), bopts...)
+				if err != nil {
+					return fmt.Errorf("prefetching %s for %s: %w", lockfile, arch, err)
+				}
+				if err := bc.Prefetch(ctx, l, jobs); err != nil {
+					return fmt.Errorf("prefetching %s for %s: %w", lockfile, arch, err)
+				}
+				return nil
+			})
+		}
+	}
+	return g.Wait()
+}
+
+// lockArchs returns the sorted set of architectures that packages in l are pinned for.
+func lockArchs(l pkglock.Lock) []types.Architecture {
+	var archs []types.Architecture
+	for _, p := range l.Contents.Packages {
+		arch := types.ParseArchitecture(p.Architecture)
+		if !slices.Contains(archs, arch) {
+			archs = append(archs, arch)
+		}
+	}
+	slices.Sort(archs)
+	return archs
+}
@@ -0,0 +1,78 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli_test
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/internal/cli"
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestPrefetch(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	lockfile := filepath.Join("testdata", "apko.lock.json")
+	err := cli.PrefetchCmd(ctx, []string{lockfile}, nil, 2,
+		build.WithCache(cacheDir, false, apk.NewCache(true)),
+	)
+	require.NoError(t, err)
+
+	var cached []string
+	require.NoError(t, filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".dat.tar.gz") {
+			cached = append(cached, path)
+		}
+		return nil
+	}))
+	// pretend-baselayout and replayout, for both x86_64 and aarch64.
+	require.Len(t, cached, 4)
+}
+
+func TestPrefetchArchFilter(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	lockfile := filepath.Join("testdata", "apko.lock.json")
+	err := cli.PrefetchCmd(ctx, []string{lockfile}, types.ParseArchitectures([]string{"x86_64"}), 0,
+		build.WithCache(cacheDir, false, apk.NewCache(true)),
+	)
+	require.NoError(t, err)
+
+	var cached []string
+	require.NoError(t, filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".dat.tar.gz") {
+			require.Contains(t, path, "x86_64")
+			cached = append(cached, path)
+		}
+		return nil
+	}))
+	require.Len(t, cached, 2)
+}
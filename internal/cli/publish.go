@@ -16,31 +16,52 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/authn/github"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/chainguard-dev/clog"
 
+	"chainguard.dev/apko/internal/backoff"
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/options"
 	"chainguard.dev/apko/pkg/sbom"
 )
 
+// The shortest and longest pauses before requests go back to a registry
+// that rate limits them, when it does not say how long to wait.
+const (
+	registryMinBackoff = time.Second
+	registryMaxBackoff = 30 * time.Second
+)
+
 func publish() *cobra.Command {
 	var imageRefs string
 	var buildDate string
+	var buildDateFromGit bool
+	var fileTimestamp, createdTimestamp string
 	var sbomPath string
 	var sbomFormats []string
 	var archstrs []string
@@ -52,10 +73,59 @@ func publish() *cobra.Command {
 	var withVCS bool
 	var writeSBOM bool
 	var local bool
+	var containerdAddress, containerdNamespace string
+	var reuseReport string
+	var attachSBOMs bool
+	var attachProvenance bool
+	var signKey string
+	var attachArtifacts []string
+	var rawReferrerAnnotations []string
 	var cacheDir string
+	var cacheNamespace string
+	var lowerCacheDir string
+	var cacheStoreSpec string
 	var offline bool
 	var lockfile string
+	var baseImageKey string
+	var baseImageIdentity, baseImageIssuer string
+	var fulcioRoots, rekorKey string
 	var ignoreSignatures bool
+	var rawUIDMaps []string
+	var rawGIDMaps []string
+	var compression string
+	var compressor string
+	var compressionLevel int
+	var compressionThreads int
+	var bestEffortArchs bool
+	var buildReport string
+	var defaultsReport string
+	var limitRate string
+	var dial apk.DialOptions
+	var caTrust string
+	var fetchRetry apk.RetryPolicy
+	var checksumDB string
+	var checksumDBKey string
+	var inputPolicy string
+	var inputAnnotations bool
+	var lockDrift string
+	var pinFile string
+	var pinMaxAge time.Duration
+	var remoteWorkers map[string]string
+	var jobs int
+	var layerCache string
+	var elfDeps string
+	var requireStatic bool
+	var symlinkCheck string
+	var symlinkAllow []string
+	var permissionCheck string
+	var permissionAllow []string
+	var strictPaths bool
+	var provenancePath, provenanceKey string
+	var splitDebug string
+	var builderID, builderVersion string
+	var maxUploads int
+	var maxRequestRate float64
+	var dryRun bool
 
 	cmd := &cobra.Command{
 		Use:   "publish <config.yaml> <tag...>",
@@ -70,20 +140,51 @@ in a keychain.`,
 				return fmt.Errorf("requires at least 2 arg(s), 1 config file and at least 1 tag for the image")
 			}
 
-			if !writeSBOM {
+			switch {
+			case !writeSBOM:
 				sbomFormats = []string{}
+			case !cmd.Flags().Changed("sbom-formats"):
+				// Leave the formats to the configuration, if it sets any.
+				sbomFormats = nil
 			}
 			archs := types.ParseArchitectures(archstrs)
 			annotations, err := parseAnnotations(rawAnnotations)
 			if err != nil {
 				return fmt.Errorf("parsing annotations from command line: %w", err)
 			}
+			referrerAnnotations, err := parseAnnotations(rawReferrerAnnotations)
+			if err != nil {
+				return fmt.Errorf("parsing referrer annotations from command line: %w", err)
+			}
+			idmap, err := parseIDMap(rawUIDMaps, rawGIDMaps)
+			if err != nil {
+				return err
+			}
+			rateLimit, err := parseLimitRate(limitRate)
+			if err != nil {
+				return err
+			}
+			cacheStore, err := openCacheStore(cmd.Context(), cacheStoreSpec)
+			if err != nil {
+				return err
+			}
+
+			// Summed up once the image is published.
+			registryHosts := backoff.NewHosts(registryMinBackoff, registryMaxBackoff)
+			defer registryHosts.LogSummary(cmd.Context())
 
 			keychain := authn.NewMultiKeychain(
 				authn.DefaultKeychain,
 				github.Keychain,
 			)
-			remoteOpts := []remote.Option{remote.WithAuthFromKeychain(keychain)}
+			remoteOpts := []remote.Option{
+				remote.WithAuthFromKeychain(keychain),
+				// Back off from a rate limiting registry before queueing for an upload slot.
+				remote.WithTransport(registryHosts.Transport(oci.NewLimitedTransport(remote.DefaultTransport, maxUploads, maxRequestRate))),
+			}
+			if maxUploads > 0 {
+				remoteOpts = append(remoteOpts, remote.WithJobs(maxUploads))
+			}
 
 			pusher, err := remote.NewPusher(remoteOpts...)
 			if err != nil {
@@ -103,11 +204,13 @@ in a keychain.`,
 			}
 			defer os.RemoveAll(tmp)
 
-			if err := PublishCmd(cmd.Context(), imageRefs, archs, remoteOpts,
+			if err := reportArchErrors(cmd.ErrOrStderr(), PublishCmd(cmd.Context(), imageRefs, archs, remoteOpts,
 				sbomPath,
 				[]build.Option{
 					build.WithConfig(args[0], []string{}),
 					build.WithBuildDate(buildDate),
+					build.WithBuildDateFromGit(buildDateFromGit),
+					build.WithTimestamps(fileTimestamp, createdTimestamp),
 					build.WithSBOM(sbomPath),
 					build.WithSBOMFormats(sbomFormats),
 					build.WithExtraKeys(extraKeys),
@@ -118,16 +221,58 @@ in a keychain.`,
 					build.WithVCS(withVCS),
 					build.WithAnnotations(annotations),
 					build.WithCache(cacheDir, offline, apk.NewCache(true)),
+					build.WithCacheNamespace(cacheNamespace),
+					build.WithLowerCache(lowerCacheDir),
+					build.WithCacheStore(cacheStore),
 					build.WithLockFile(lockfile),
+					build.WithBaseImageKey(baseImageKey),
+					build.WithBaseImageIdentity(baseImageIdentity, baseImageIssuer),
+					build.WithSigstoreRoots(fulcioRoots, rekorKey),
 					build.WithTempDir(tmp),
 					build.WithIgnoreSignatures(ignoreSignatures),
+					build.WithIDMap(idmap),
+					build.WithLayerCompression(compression),
+					build.WithCompressor(compressor),
+					build.WithCompressionLevel(compressionLevel),
+					build.WithCompressionThreads(compressionThreads),
+					build.WithBestEffortArchs(bestEffortArchs),
+					build.WithBuildReport(buildReport),
+					build.WithDefaultsReport(defaultsReport),
+					build.WithLimitRate(rateLimit),
+					build.WithDialOptions(dial),
+					build.WithCATrust(caTrust),
+					build.WithFetchRetryPolicy(fetchRetry),
+					build.WithChecksumDB(checksumDB, checksumDBKey),
+					build.WithInputPolicy(inputPolicy),
+					build.WithInputAnnotations(inputAnnotations),
+					build.WithLockDrift(options.CheckMode(lockDrift)),
+					build.WithPinFile(pinFile, pinMaxAge),
+					build.WithRemoteWorkers(parseRemoteWorkers(remoteWorkers)),
+					build.WithConcurrency(jobs),
+					build.WithELFDeps(options.CheckMode(elfDeps)),
+					build.WithRequireStatic(requireStatic),
+					build.WithSplitDebug(splitDebug),
+					build.WithSymlinkCheck(options.CheckMode(symlinkCheck), symlinkAllow),
+					build.WithPermissionCheck(options.CheckMode(permissionCheck), permissionAllow),
+					build.WithStrictPaths(strictPaths),
+					build.WithProvenance(provenancePath, provenanceKey),
+					build.WithLayerCache(layerCache, remoteOpts...),
+					build.WithBuilder(builderID, builderVersion),
+					build.WithContainerdLoad(containerdAddress, containerdNamespace),
 				},
 				[]PublishOption{
 					// these are extra here just for publish; everything before is the same for BuildCmd as PublishCmd
 					WithLocal(local),
+					WithReuseReport(reuseReport),
+					WithAttachSBOMs(attachSBOMs),
+					WithAttachProvenance(attachProvenance),
+					WithSignKey(signKey),
+					WithAttachArtifacts(attachArtifacts...),
+					WithReferrerAnnotations(referrerAnnotations),
+					WithDryRun(dryRun),
 					WithTags(args[1:]...),
 				},
-			); err != nil {
+			)); err != nil {
 				return err
 			}
 			return nil
@@ -136,23 +281,85 @@ in a keychain.`,
 
 	cmd.Flags().BoolVar(&withVCS, "vcs", true, "detect and embed VCS URLs")
 	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image")
+	cmd.Flags().BoolVar(&buildDateFromGit, "build-date-from-git", false, "derive the build date from the last git commit that touched the config file; --build-date and SOURCE_DATE_EPOCH take precedence")
+	cmd.Flags().StringVar(&fileTimestamp, "file-timestamp", "", "modification time of every file in the image layers: an RFC 3339 date, seconds since the epoch, \"build-date\" or \"newest-package\" (default '' means the times the files were installed with)")
+	cmd.Flags().StringVar(&createdTimestamp, "created-timestamp", "", "time the image is recorded as created at, in the same forms as --file-timestamp (default '' means the build date, or the newest package if later)")
 	cmd.Flags().BoolVar(&writeSBOM, "sbom", true, "generate an SBOM")
 	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "path to write the SBOMs")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config.")
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
-	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output")
+	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", sbom.DefaultOptions.Formats, "SBOM formats to output (spdx, cyclonedx), instead of those set by sbom.formats in the config")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
 	cmd.Flags().StringSliceVar(&rawAnnotations, "annotations", []string{}, "OCI annotations to add. Separate with colon (key:value)")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().StringVar(&cacheNamespace, "cache-namespace", "", "keep the package and layer caches of this build apart from other namespaces sharing the cache directory")
+	cmd.Flags().StringVar(&lowerCacheDir, "lower-cache-dir", "", "read-only package cache consulted when the cache misses, e.g. one shared by every namespace")
+	cmd.Flags().StringVar(&cacheStoreSpec, "cache-store", "", cacheStoreUsage)
 	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
 	cmd.Flags().StringVar(&lockfile, "lockfile", "", "a path to .lock.json file (e.g. produced by apko lock) that constraints versions of packages to the listed ones (default '' means no additional constraints)")
+	cmd.Flags().StringVar(&baseImageKey, "base-image-key", "", "path to a cosign public key the base image and oci:// keyring entries must be signed with, the signature of the base image being saved in its OCI layout (e.g. by cosign save)")
+	cmd.Flags().StringVar(&baseImageIdentity, "base-image-identity", "", "identity, an email address or URI, the base image and oci:// keyring entries must be signed keyless by")
+	cmd.Flags().StringVar(&baseImageIssuer, "base-image-issuer", "", "OIDC issuer that must vouch for --base-image-identity, e.g. https://token.actions.githubusercontent.com")
+	cmd.Flags().StringVar(&fulcioRoots, "fulcio-roots", "", "path to the PEM certificates of the Fulcio certificate authority keyless signatures are checked against")
+	cmd.Flags().StringVar(&rekorKey, "rekor-key", "", "path to the public key of the Rekor log keyless signatures must be recorded in")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().StringSliceVar(&rawUIDMaps, "uid-map", []string{}, "remap file owners in the image layers, as container:host:size (e.g. 0:100000:65536)")
+	cmd.Flags().StringSliceVar(&rawGIDMaps, "gid-map", []string{}, "remap file groups in the image layers, as container:host:size (e.g. 0:100000:65536)")
+	cmd.Flags().StringVar(&compression, "compression", build.LayerCompressionGzip, fmt.Sprintf("format layers are compressed in, one of %v", build.LayerCompressions))
+	cmd.Flags().StringVar(&compressor, "compressor", build.CompressorPgzip, fmt.Sprintf("implementation used to compress gzip layers, one of %v", build.Compressors))
+	cmd.Flags().IntVar(&compressionLevel, "compression-level", 0, "layer compression level from 1 (fastest) to 9 (smallest) (default 0 means the compressor's default)")
+	cmd.Flags().IntVar(&compressionThreads, "compression-threads", 0, "number of cores used to compress each layer with pgzip or zstd; GOMAXPROCS/threads layers are compressed at once (default 0 means min(GOMAXPROCS, 8))")
+	cmd.Flags().BoolVar(&bestEffortArchs, "best-effort-archs", false, "skip architectures whose packages cannot be resolved instead of failing the build")
+	cmd.Flags().StringVar(&buildReport, "build-report", "", "path to write a JSON report of which architectures were built, from which repository indexes, or skipped")
+	cmd.Flags().StringVar(&defaultsReport, "defaults-report", "", "path to write a JSON report of the effective image configuration and every default applied to it")
+	cmd.Flags().StringVar(&limitRate, "limit-rate", "", "maximum combined package download rate in bytes per second, with an optional K, M or G suffix (e.g. 500K)")
+	cmd.Flags().StringVar(&dial.Family, "ip-family", apk.IPFamilyDual, fmt.Sprintf("connect to repositories only over %q or %q, failing fast on hosts without such an address (default '' means both)", apk.IPFamilyIPv4, apk.IPFamilyIPv6))
+	cmd.Flags().DurationVar(&dial.FallbackDelay, "happy-eyeballs-delay", 0, "how long to wait on the preferred IP family before also trying the other one; negative tries them one after the other (default 0 means 300ms)")
+	cmd.Flags().DurationVar(&dial.ConnectTimeout, "connect-timeout", 0, "timeout for connecting to a repository (default 0 means 30s)")
+	cmd.Flags().StringVar(&caTrust, "ca-trust", apk.CATrustDefault, fmt.Sprintf("CA certificates to verify repositories against: %q, %q (built into apko) or the path of a PEM file (default '' means Go's default, honoring SSL_CERT_FILE)", apk.CATrustSystem, apk.CATrustBundled))
+	cmd.Flags().IntVar(&fetchRetry.Attempts, "fetch-attempts", 0, "how many times to try fetching an index, key or package, including the first; 1 disables retries (default 0 means 5)")
+	cmd.Flags().DurationVar(&fetchRetry.MinBackoff, "fetch-min-backoff", 0, "wait before retrying a fetch, doubling for each retry after it (default 0 means 1s)")
+	cmd.Flags().DurationVar(&fetchRetry.MaxBackoff, "fetch-max-backoff", 0, "longest wait between retries of a fetch (default 0 means 30s)")
+	cmd.Flags().IntSliceVar(&fetchRetry.RetryOn, "fetch-retry-on", nil, "HTTP statuses to retry fetches on, instead of 429 and 5xx other than 501; connection errors are always retried")
+	cmd.Flags().StringVar(&checksumDB, "checksum-db", "", "URL of a checksum database to cross-check installed package checksums against")
+	cmd.Flags().StringVar(&checksumDBKey, "checksum-db-key", "", "note verifier key the tree heads of --checksum-db are signed with")
+	cmd.Flags().StringVar(&inputPolicy, "input-policy", "", "path to a policy of the in-toto attestations the config, lock file and packages must carry, checked before anything is installed")
+	cmd.Flags().BoolVar(&inputAnnotations, "input-annotations", true, "annotate images with the apko version and the digests of the config and lock files they were built from")
+	cmd.Flags().StringVar(&lockDrift, "lock-drift", "", "when building from a lock file, check it against the current repository indexes: \"warn\" reports drift, \"fail\" also fails if a locked package's checksum changed upstream")
+	cmd.Flags().StringVar(&pinFile, "pin-file", "", "when not building from a lock file, record the packages resolved for the build in this lock file")
+	cmd.Flags().DurationVar(&pinMaxAge, "pin-max-age", 0, "build from the packages in --pin-file while it is younger than this and matches the config, instead of resolving them again (default 0 means always resolve)")
+	cmd.Flags().StringToStringVar(&remoteWorkers, "remote-worker", nil, "(experimental) build an architecture on the apko worker at a URL, e.g. arm64=http://arm-runner:8080")
+	cmd.Flags().IntVar(&jobs, "jobs", 0, "number of architectures to build at once (default 0 means all of them)")
+	cmd.Flags().StringVar(&layerCache, "layer-cache", "", "OCI repository to fetch already compressed layers from, and to push newly compressed layers to, to share them between builders")
+	cmd.Flags().StringVar(&elfDeps, "elf-deps", "", "check that the libraries ELF files in the image link against are in it: \"warn\" reports missing libraries, \"fail\" also fails the build")
+	cmd.Flags().BoolVar(&requireStatic, "require-static", false, "fail the build if any ELF file in the image is dynamically linked")
+	cmd.Flags().StringVar(&splitDebug, "split-debug", "", "strip the debug info from ELF files with a build ID, and write it to this directory as an OCI image layout of images laid out under /usr/lib/debug/.build-id, referring to the images they were split from")
+	cmd.Flags().StringVar(&symlinkCheck, "symlink-check", "", "check for symlinks that point outside the image or to nothing: \"warn\" reports them, \"fail\" also fails the build")
+	cmd.Flags().StringSliceVar(&symlinkAllow, "symlink-allow", []string{}, "patterns of symlinks for --symlink-check to ignore, in which \"**\" matches any number of directories")
+	cmd.Flags().StringVar(&permissionCheck, "permission-check", "", "check for setuid and setgid files and world-writable paths: \"warn\" lists them, \"fail\" also fails the build")
+	cmd.Flags().StringSliceVar(&permissionAllow, "permission-allow", []string{}, "patterns of paths for --permission-check to ignore, in which \"**\" matches any number of directories")
+	cmd.Flags().BoolVar(&strictPaths, "strict-paths", false, "fail the build when a local path copies something that would make it nondeterministic, such as a symlink to the host")
+	cmd.Flags().StringVar(&provenancePath, "provenance", "", "path to write the SLSA provenance of the image index to, as an in-toto statement")
+	cmd.Flags().StringVar(&provenanceKey, "provenance-key", "", "path to a PEM private key to sign the provenance with, as a DSSE envelope")
+	cmd.Flags().StringVar(&builderID, "builder-id", "", "URI identifying the builder running apko, recorded in the SBOMs and image annotations")
+	cmd.Flags().StringVar(&builderVersion, "builder-version", "", "version of the builder named by --builder-id")
 
 	// these are extra here just for publish; everything before is the same for BuildCmd as PublishCmd
 	cmd.Flags().BoolVar(&local, "local", false, "publish image just to local Docker daemon")
+	cmd.Flags().StringVar(&containerdNamespace, "containerd", "", "publish image just to this namespace of the local containerd, e.g. \"default\" for nerdctl or \"k8s.io\" for k3s")
+	cmd.Flags().StringVar(&containerdAddress, "containerd-address", oci.DefaultContainerdAddress, "address of the containerd for --containerd, e.g. /run/k3s/containerd/containerd.sock for k3s")
 	cmd.Flags().StringVar(&imageRefs, "image-refs", "", "path to file where a list of the published image references will be written")
+	cmd.Flags().BoolVar(&attachSBOMs, "attach-sboms", false, "push the SBOMs as OCI artifacts referring to the images and index they describe, listed by the registry's referrers API")
+	cmd.Flags().StringVar(&signKey, "sign-key", "", "path to a PEM private key to sign the index with, pushing the signature as a cosign signature artifact referring to it, listed by the registry's referrers API")
+	cmd.Flags().BoolVar(&attachProvenance, "attach-provenance", false, "push the provenance written with --provenance as an OCI artifact referring to the index, listed by the registry's referrers API")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "build the image and print the index, image and artifact manifests that would be pushed, as JSON, without writing to the registry")
+	cmd.Flags().StringSliceVar(&attachArtifacts, "attach-artifacts", []string{}, fmt.Sprintf("build products to push as OCI artifacts referring to the images and index, of %v", attachableArtifacts))
+	cmd.Flags().StringSliceVar(&rawReferrerAnnotations, "referrer-annotations", []string{}, "OCI annotations to set on the manifests of attached SBOMs, provenance and artifacts. Separate with colon (key:value)")
+	cmd.Flags().StringVar(&reuseReport, "reuse-report", "", "path to write a JSON report of how many bytes of each image were already in the repository and how many were uploaded")
+	cmd.Flags().IntVar(&maxUploads, "max-concurrent-uploads", 0, "maximum number of concurrent requests to the registry across all architectures (default 0 means no limit beyond the per-image default)")
+	cmd.Flags().Float64Var(&maxRequestRate, "max-requests-per-second", 0, "maximum rate of requests to the registry (default 0 means unlimited)")
 
 	return cmd
 }
@@ -168,6 +375,33 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 			return err
 		}
 	}
+	o, ic, err := build.NewOptions(buildOpts...)
+	if err != nil {
+		return err
+	}
+	if err := ic.ResolveArchs(archs); err != nil {
+		return err
+	}
+	if opts.local && o.ContainerdNamespace != "" {
+		return fmt.Errorf("--local and --containerd are mutually exclusive")
+	}
+	if opts.attachProvenance && o.ProvenancePath == "" {
+		return fmt.Errorf("--attach-provenance requires --provenance")
+	}
+	artifacts, err := artifactPaths(o, ic.Archs, opts.attachArtifacts)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		if opts.local || o.ContainerdNamespace != "" {
+			return fmt.Errorf("--dry-run cannot be used with --local or --containerd")
+		}
+		// Layers are pushed to the layer cache as they are compressed.
+		if o.LayerCache != "" {
+			log.Infof("not pushing to layer cache %s in a dry run", o.LayerCache)
+			buildOpts = append(buildOpts, build.WithLayerCacheReadOnly(true))
+		}
+	}
 
 	wd, err := os.MkdirTemp("", "apko-*")
 	if err != nil {
@@ -187,6 +421,16 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 		builtReferences = make([]string, 0)
 	)
 
+	if o.ContainerdNamespace != "" {
+		ref, err := oci.LoadIndexContainerd(ctx, idx, tags, o.ContainerdAddress, o.ContainerdNamespace)
+		if err != nil {
+			return fmt.Errorf("importing index into containerd: %w", err)
+		}
+		log.Infof("using containerd option, exiting early")
+		fmt.Println(ref.String())
+		return nil
+	}
+
 	if local {
 		// TODO: We shouldn't even need to build the index if we're loading a single image.
 		ref, err := oci.LoadIndex(ctx, idx, tags)
@@ -204,6 +448,53 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	if err != nil {
 		return fmt.Errorf("parsing %q as tag: %w", tags[0], err)
 	}
+	if err := reportBlobReuse(ctx, idx, ref.Context(), ropt, opts.reuseReport); err != nil {
+		return err
+	}
+
+	var sig *oci.Signature
+	if opts.signKey != "" {
+		payload, err := oci.SignaturePayload(idx, ref.Context())
+		if err != nil {
+			return err
+		}
+		b, err := build.SignPayload(payload, opts.signKey, o.Random())
+		if err != nil {
+			return fmt.Errorf("signing index: %w", err)
+		}
+		sig = &oci.Signature{Payload: payload, Signature: b}
+	}
+
+	if opts.dryRun {
+		var attachedSBOMs []types.SBOM
+		if opts.attachSBOMs {
+			attachedSBOMs = sboms
+		}
+		var provenance string
+		if opts.attachProvenance {
+			provenance = o.ProvenancePath
+		}
+		previewed := make([]oci.Artifact, 0, len(artifacts))
+		for _, a := range artifacts {
+			previewed = append(previewed, oci.Artifact{Path: a.path, MediaType: a.mt})
+		}
+		rootFS := slices.Contains(opts.attachArtifacts, artifactRootFS)
+		preview, err := oci.PreviewPublish(o, idx, tags, attachedSBOMs, provenance, build.ProvenanceMediaType(o.ProvenanceKey), previewed, rootFS, sig, opts.referrerAnnotations, ref.Context())
+		if err != nil {
+			return fmt.Errorf("previewing publish: %w", err)
+		}
+		b, err := json.MarshalIndent(preview, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling publish preview: %w", err)
+		}
+		if err := moveSBOMs(sboms, sbomPath); err != nil {
+			return err
+		}
+		log.Infof("dry run, not publishing %s", preview.Index.Reference)
+		fmt.Println(string(b))
+		return nil
+	}
+
 	refs, err := oci.PublishImagesFromIndex(ctx, idx, ref.Context(), ropt...)
 	if err != nil {
 		return fmt.Errorf("publishing images from index: %w", err)
@@ -219,6 +510,35 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	}
 	builtReferences = append(builtReferences, finalDigest.String())
 
+	if opts.attachSBOMs {
+		if _, err := oci.AttachSBOMs(ctx, idx, sboms, opts.referrerAnnotations, ref.Context(), ropt...); err != nil {
+			return fmt.Errorf("attaching SBOMs: %w", err)
+		}
+	}
+
+	if opts.attachProvenance {
+		if _, err := oci.AttachProvenance(ctx, idx, o.ProvenancePath, build.ProvenanceMediaType(o.ProvenanceKey), opts.referrerAnnotations, ref.Context(), ropt...); err != nil {
+			return fmt.Errorf("attaching provenance: %w", err)
+		}
+	}
+
+	if sig != nil {
+		if _, err := oci.AttachSignature(ctx, idx, *sig, opts.referrerAnnotations, ref.Context(), ropt...); err != nil {
+			return fmt.Errorf("attaching signature: %w", err)
+		}
+	}
+
+	for _, a := range artifacts {
+		if _, err := oci.AttachArtifact(ctx, idx, a.path, a.mt, opts.referrerAnnotations, ref.Context(), ropt...); err != nil {
+			return fmt.Errorf("attaching %s: %w", a.kind, err)
+		}
+	}
+	if slices.Contains(opts.attachArtifacts, artifactRootFS) {
+		if _, err := oci.AttachRootFS(ctx, o, idx, opts.referrerAnnotations, ref.Context(), ropt...); err != nil {
+			return fmt.Errorf("attaching rootfs: %w", err)
+		}
+	}
+
 	// output any file info requested
 	// If provided, this is the name of the file to write digest referenced into
 	if outputRefs != "" {
@@ -228,14 +548,8 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 		}
 	}
 
-	// copy sboms over to the sbomPath target directory
-	if sbomPath != "" {
-		for _, sbom := range sboms {
-			// because os.Rename fails across partitions, we do our own
-			if err := rename(sbom.Path, filepath.Join(sbomPath, filepath.Base(sbom.Path))); err != nil {
-				return fmt.Errorf("moving sbom: %w", err)
-			}
-		}
+	if err := moveSBOMs(sboms, sbomPath); err != nil {
+		return err
 	}
 
 	// Write the image digest to STDOUT in order to enable command
@@ -245,6 +559,99 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	return nil
 }
 
+// indexArtifact is a build product attached to the published index.
+type indexArtifact struct {
+	kind string
+	path string
+	mt   ggcrtypes.MediaType
+}
+
+// artifactPaths returns the files of the kinds of build products to attach
+// to the index built for archs, checking that the build writes them.
+func artifactPaths(o *options.Options, archs []types.Architecture, kinds []string) ([]indexArtifact, error) {
+	var artifacts []indexArtifact
+	for _, kind := range kinds {
+		switch kind {
+		case artifactBuildReport:
+			if o.BuildReportPath == "" {
+				return nil, fmt.Errorf("--attach-artifacts %s requires --build-report", kind)
+			}
+			artifacts = append(artifacts, indexArtifact{kind, o.BuildReportPath, oci.BuildReportArtifactType})
+		case artifactFetchManifest:
+			// Builds from a lock file fetch what it pins, for each
+			// architecture that has a lock file of its own from that one;
+			// others record what they fetched in the pin file.
+			switch {
+			case o.Lockfile != "":
+				var locks []string
+				for _, arch := range archs {
+					if p := pkglock.ForArch(o.Lockfile, arch); !slices.Contains(locks, p) {
+						locks = append(locks, p)
+						artifacts = append(artifacts, indexArtifact{kind, p, oci.FetchManifestArtifactType})
+					}
+				}
+			case o.PinFile != "":
+				artifacts = append(artifacts, indexArtifact{kind, o.PinFile, oci.FetchManifestArtifactType})
+			default:
+				return nil, fmt.Errorf("--attach-artifacts %s requires --lockfile or --pin-file", kind)
+			}
+		}
+	}
+	return artifacts, nil
+}
+
+// moveSBOMs moves sboms over to the sbomPath target directory, if set.
+func moveSBOMs(sboms []types.SBOM, sbomPath string) error {
+	if sbomPath == "" {
+		return nil
+	}
+	for _, sbom := range sboms {
+		// because os.Rename fails across partitions, we do our own
+		if err := rename(sbom.Path, filepath.Join(sbomPath, filepath.Base(sbom.Path))); err != nil {
+			return fmt.Errorf("moving sbom: %w", err)
+		}
+	}
+	return nil
+}
+
+// reportBlobReuse writes to path how many bytes of each image in idx are
+// already in repo, and how many are about to be uploaded, and also logs them
+// and records the totals on the span in ctx. Measuring them checks every blob
+// in repo, so nothing is measured when path is empty.
+func reportBlobReuse(ctx context.Context, idx v1.ImageIndex, repo name.Repository, ropt []remote.Option, path string) error {
+	if path == "" {
+		return nil
+	}
+	log := clog.FromContext(ctx)
+	reuse, err := oci.MeasureBlobReuse(ctx, idx, repo, ropt...)
+	if err != nil {
+		return fmt.Errorf("measuring layer reuse: %w", err)
+	}
+
+	var reused, uploaded int64
+	for _, r := range reuse {
+		log.Infof("image %s (%s): reusing %d blobs (%s), uploading %d blobs (%s)", r.Image, r.Platform,
+			r.ReusedBlobs, humanSize(uint64(r.ReusedBytes)), r.UploadedBlobs, humanSize(uint64(r.UploadedBytes)))
+		reused += r.ReusedBytes
+		uploaded += r.UploadedBytes
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int64("apko.reused_bytes", reused),
+		attribute.Int64("apko.uploaded_bytes", uploaded),
+	)
+
+	b, err := json.MarshalIndent(struct {
+		Images []oci.BlobReuse `json:"images"`
+	}{reuse}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling reuse report: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing reuse report: %w", err)
+	}
+	return nil
+}
+
 func parseAnnotations(rawAnnotations []string) (map[string]string, error) {
 	annotations := map[string]string{}
 	keyRegex := regexp.MustCompile(`^[a-z0-9-\.]+$`)
@@ -266,3 +673,50 @@ func parseAnnotations(rawAnnotations []string) (map[string]string, error) {
 	}
 	return annotations, nil
 }
+
+func parseIDMap(rawUIDMaps, rawGIDMaps []string) (options.IDMap, error) {
+	var idmap options.IDMap
+	for _, s := range rawUIDMaps {
+		m, err := options.ParseIDMapping(s)
+		if err != nil {
+			return options.IDMap{}, fmt.Errorf("parsing --uid-map: %w", err)
+		}
+		idmap.UIDs = append(idmap.UIDs, m)
+	}
+	for _, s := range rawGIDMaps {
+		m, err := options.ParseIDMapping(s)
+		if err != nil {
+			return options.IDMap{}, fmt.Errorf("parsing --gid-map: %w", err)
+		}
+		idmap.GIDs = append(idmap.GIDs, m)
+	}
+	return idmap, nil
+}
+
+// parseLimitRate parses a --limit-rate value: a number of bytes per second,
+// optionally suffixed with K, M or G (powers of 1024), as in curl.
+func parseLimitRate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	num, mult := s, int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		num = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("parsing --limit-rate: invalid rate %q", s)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("parsing --limit-rate: rate %q is too large", s)
+	}
+	return n * mult, nil
+}
@@ -16,6 +16,12 @@ package cli_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/fs"
@@ -37,6 +43,7 @@ import (
 	"chainguard.dev/apko/internal/cli"
 	"chainguard.dev/apko/internal/tarfs"
 	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/sbom"
 )
@@ -167,7 +174,7 @@ func TestPublishLayering(t *testing.T) {
 
 	// This test will fail if we ever make a change in apko that changes the image.
 	// Sometimes, this is intentional, and we need to change this and bump the version.
-	want := "sha256:72435e8671ef7d79007d93066b54c81b875e567ccd396945d7b9ec083f96cba0"
+	want := "sha256:95ca08f51ff85279d7c0ab39048f22010b1802a8ad6b52cbd0e22305924cf3cd"
 	require.Equal(t, want, digest.String())
 
 	im, err := idx.IndexManifest()
@@ -201,3 +208,187 @@ func TestPublishLayering(t *testing.T) {
 		}
 	}
 }
+
+func TestPublishReuseReport(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := fmt.Sprintf("%s/test/reuse", u.Host)
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+	}
+
+	publish := func(report string) []oci.BlobReuse {
+		publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithReuseReport(report)}
+		require.NoError(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts))
+
+		b, err := os.ReadFile(report)
+		require.NoError(t, err)
+		var got struct {
+			Images []oci.BlobReuse `json:"images"`
+		}
+		require.NoError(t, json.Unmarshal(b, &got))
+		require.Len(t, got.Images, len(archs))
+		return got.Images
+	}
+
+	// Nothing is in the repository the first time around...
+	for _, r := range publish(filepath.Join(tmp, "first.json")) {
+		require.Zero(t, r.ReusedBlobs)
+		require.NotZero(t, r.UploadedBlobs)
+		require.NotZero(t, r.UploadedBytes)
+	}
+	// ...and everything is when publishing the same image again.
+	for _, r := range publish(filepath.Join(tmp, "second.json")) {
+		require.Zero(t, r.UploadedBlobs)
+		require.NotZero(t, r.ReusedBlobs)
+		require.NotZero(t, r.ReusedBytes)
+	}
+}
+
+func TestPublishAttachSBOMs(t *testing.T) {
+	ctx := context.Background()
+
+	// The referrers API of the test registry reports the config media type
+	// of each referrer, not its artifactType, so the fallback tag is used.
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := fmt.Sprintf("%s/test/referrers", u.Host)
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+		build.WithSBOMFormats([]string{"spdx"}),
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "cosign.key")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithAttachSBOMs(true), cli.WithSignKey(keyPath)}
+	require.NoError(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts))
+
+	ref, err := name.ParseReference(dst)
+	require.NoError(t, err)
+	idx, err := remote.Index(ref)
+	require.NoError(t, err)
+	digest, err := idx.Digest()
+	require.NoError(t, err)
+	manifest, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	// The index and each image have their SBOM attached, and the index its
+	// signature.
+	want := map[string][]string{digest.String(): {string(oci.SBOMArtifactTypes["spdx"]), string(oci.SignatureArtifactType)}}
+	for _, m := range manifest.Manifests {
+		want[m.Digest.String()] = []string{string(oci.SBOMArtifactTypes["spdx"])}
+	}
+	for subject, artifactTypes := range want {
+		referrers, err := remote.Referrers(ref.Context().Digest(subject))
+		require.NoError(t, err)
+		rm, err := referrers.IndexManifest()
+		require.NoError(t, err)
+		var got []string
+		for _, m := range rm.Manifests {
+			got = append(got, m.ArtifactType)
+		}
+		require.ElementsMatch(t, artifactTypes, got, subject)
+	}
+}
+
+func TestPublishDryRun(t *testing.T) {
+	ctx := context.Background()
+
+	// The referrers API of the test registry reports the config media type
+	// of each referrer, not its artifactType, so the fallback tag is used.
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := fmt.Sprintf("%s/test/dry-run", u.Host)
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+		build.WithSBOMFormats([]string{"spdx"}),
+	}
+	publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithAttachSBOMs(true), cli.WithDryRun(true)}
+	require.NoError(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts))
+
+	// Nothing was pushed.
+	reg, err := name.NewRegistry(u.Host)
+	require.NoError(t, err)
+	repos, err := remote.Catalog(ctx, reg)
+	require.NoError(t, err)
+	require.Empty(t, repos)
+
+	publishOpts = []cli.PublishOption{cli.WithTags(dst), cli.WithLocal(true), cli.WithDryRun(true)}
+	require.ErrorContains(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts), "--dry-run")
+}
+
+func TestPublishAttachArtifacts(t *testing.T) {
+	ctx := context.Background()
+
+	// The referrers API of the test registry reports the config media type
+	// of each referrer, not its artifactType, so the fallback tag is used.
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := fmt.Sprintf("%s/test/artifacts", u.Host)
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+		build.WithBuildReport(filepath.Join(t.TempDir(), "report.json")),
+	}
+
+	// The build report must be written to be attached.
+	annotations := map[string]string{"com.example.team": "platform"}
+	publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithAttachArtifacts("build-report", "rootfs"), cli.WithReferrerAnnotations(annotations)}
+	require.ErrorContains(t, cli.PublishCmd(ctx, "", archs, nil, "", opts[:2], publishOpts), "--build-report")
+
+	require.NoError(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts))
+
+	ref, err := name.ParseReference(dst)
+	require.NoError(t, err)
+	idx, err := remote.Index(ref)
+	require.NoError(t, err)
+	digest, err := idx.Digest()
+	require.NoError(t, err)
+	manifest, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	want := map[string]string{digest.String(): string(oci.BuildReportArtifactType)}
+	for _, m := range manifest.Manifests {
+		want[m.Digest.String()] = string(oci.RootFSArtifactType)
+	}
+	for subject, artifactType := range want {
+		referrers, err := remote.Referrers(ref.Context().Digest(subject))
+		require.NoError(t, err)
+		rm, err := referrers.IndexManifest()
+		require.NoError(t, err)
+		require.Len(t, rm.Manifests, 1, subject)
+		require.Equal(t, artifactType, rm.Manifests[0].ArtifactType)
+		artifact, err := remote.Image(ref.Context().Digest(rm.Manifests[0].Digest.String()))
+		require.NoError(t, err)
+		am, err := artifact.Manifest()
+		require.NoError(t, err)
+		require.Equal(t, annotations, am.Annotations)
+	}
+
+	require.ErrorContains(t, cli.WithAttachArtifacts("logs")(nil), "unknown artifact")
+}
@@ -15,9 +15,12 @@
 package cli
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"text/template"
 
 	"github.com/spf13/cobra"
@@ -38,6 +41,9 @@ const (
 	formatNameBracketsVersionWithSource    = `{{ .Name }} ({{ .Version }}) {{ .Source }}`
 	formatPkgLock                          = `- {{ .Name }}={{ .Version }}`
 	formatPkgLockWithSource                = `- {{ .Name }}={{ .Version }} # {{ .Source }}`
+	formatSizes                            = `{{ .Name }} {{ .Version }} {{ .HumanSize }} {{ printf "%.1f%%" .Share }}`
+	formatNameSpaceVersionWithOrigin       = `{{ .Name }} {{ .Version }} {{ .Origin }}`
+	formatOrigin                           = `{{ .Origin }} {{ .Name }} {{ .Version }}`
 	showPkgsFormatDefault                  = formatNameSpaceVersion
 )
 
@@ -51,6 +57,9 @@ var (
 		"name-(version)-source": formatNameBracketsVersionWithSource,
 		"packagelock":           formatPkgLock,
 		"packagelock-source":    formatPkgLockWithSource,
+		"sizes":                 formatSizes,
+		"name-version-origin":   formatNameSpaceVersionWithOrigin,
+		"origin":                formatOrigin,
 	}
 )
 
@@ -58,6 +67,30 @@ type pkgInfo struct {
 	Name    string
 	Version string
 	Source  string
+	// Origin is the source package the package was built from.
+	Origin string
+	// Size is the installed size of the package in bytes.
+	Size uint64
+	// Share is the percentage of all installed bytes taken by the package.
+	Share float64
+}
+
+// HumanSize returns Size in binary units, e.g. "1.5 MiB".
+func (p pkgInfo) HumanSize() string {
+	return humanSize(p.Size)
+}
+
+func humanSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 func showPackages() *cobra.Command {
@@ -69,6 +102,10 @@ func showPackages() *cobra.Command {
 	var tmpl string
 	var cacheDir string
 	var offline bool
+	var sizes bool
+	var byOrigin bool
+	var tree bool
+	var why string
 
 	cmd := &cobra.Command{
 		Use:   "show-packages",
@@ -78,7 +115,7 @@ The result is identical to the first stages of a build, but does not actually in
 
 The output is one of several pre-defined formats, or can be customized to any go template, using
 the provided vars. See https://pkg.go.dev/text/template for more information. Available vars are
-.Name, .Version, .Source
+.Name, .Version, .Source, .Origin, .Size, .HumanSize, .Share
 
 The pre-defined formats are:
   name-version:          {{ .Name }} {{ .Version }}
@@ -89,22 +126,52 @@ The pre-defined formats are:
   name-(version)-source: {{ .Name }} ({{ .Version }}) {{ .Source }}
   packagelock:               - {{ .Name }}={{ .Version }}
   packagelock-source:        - {{ .Name }}={{ .Version }} # {{ .Source }}
+  sizes:                 {{ .Name }} {{ .Version }} {{ .HumanSize }} {{ printf "%.1f%%" .Share }}
+  name-version-origin:   {{ .Name }} {{ .Version }} {{ .Origin }}
+  origin:                {{ .Origin }} {{ .Name }} {{ .Version }}
+
+The default format is name-version, sizes with --sizes, or origin with --by-origin.
 
-The default format is name-version.
+With --sizes, packages are sorted by installed size, largest first, and each is
+attributed its share of all installed bytes, followed by the total.
+
+With --by-origin, packages built from the same source package, which change
+together, are listed together, sorted by origin, or by the installed size of
+each origin with --sizes.
+
+With --tree, the packages requested in contents.packages are shown each
+followed by the tree of its dependencies, with the repository each comes from
+and, where it differs from the package name, the dependency it provides, e.g.
+"(via so:libc.so.6)". Packages already shown are marked (*) and not expanded
+again.
+
+With --why, the packages that pull in the given package, or the package that
+provides the given name, are shown as a tree up to contents.packages, which is
+useful to find what to remove to slim an image.
 
 packagelock and packagelock-source are particularly useful for inserting back into a yaml list of packages.
 `,
-		Example: `  apko show-packages <config.yaml>`,
-		Args:    cobra.ExactArgs(1),
+		Example: `  apko show-packages <config.yaml>
+  apko show-packages --tree <config.yaml>
+  apko show-packages --why glibc <config.yaml>`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			archs := types.ParseArchitectures(archstrs)
+			if !cmd.Flags().Changed("format") {
+				switch {
+				case byOrigin:
+					format = "origin"
+				case sizes:
+					format = "sizes"
+				}
+			}
 			if t, ok := showPkgsFormats[format]; ok {
 				tmpl = t
 			} else {
 				// assume it's a template
 				tmpl = format
 			}
-			return ShowPackagesCmd(cmd.Context(), tmpl, archs,
+			return ShowPackagesCmd(cmd.Context(), tmpl, archs, sizes, byOrigin, tree, why,
 				build.WithConfig(args[0], []string{}),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraBuildRepos(extraBuildRepos),
@@ -118,14 +185,18 @@ packagelock and packagelock-source are particularly useful for inserting back in
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
-	cmd.Flags().StringVar(&format, "format", showPkgsFormatDefault, "format for showing packages; if pre-defined from list, will use that, else go template. See https://pkg.go.dev/text/template for more information. Available vars are `.Name`, `.Version`, `.Source`")
+	cmd.Flags().StringVar(&format, "format", showPkgsFormatDefault, "format for showing packages; if pre-defined from list, will use that, else go template. See https://pkg.go.dev/text/template for more information. Available vars are `.Name`, `.Version`, `.Source`, `.Origin`")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
 	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().BoolVar(&sizes, "sizes", false, "sort packages by installed size and show each package's share of the image")
+	cmd.Flags().BoolVar(&byOrigin, "by-origin", false, "group packages by the source package they were built from")
+	cmd.Flags().BoolVar(&tree, "tree", false, "show the requested packages as a tree of their dependencies")
+	cmd.Flags().StringVar(&why, "why", "", "show the packages that pull in the given package, up to contents.packages")
 
 	return cmd
 }
 
-func ShowPackagesCmd(ctx context.Context, format string, archs []types.Architecture, opts ...build.Option) error {
+func ShowPackagesCmd(ctx context.Context, format string, archs []types.Architecture, sizes, byOrigin, tree bool, why string, opts ...build.Option) error {
 	log := clog.FromContext(ctx)
 	wd, err := os.MkdirTemp("", "apko-*")
 	if err != nil {
@@ -138,17 +209,10 @@ func ShowPackagesCmd(ctx context.Context, format string, archs []types.Architect
 		return err
 	}
 
-	// cases:
-	// - archs set: use those archs
-	// - archs not set, bc.ImageConfiguration.Archs set: use Config archs
-	// - archs not set, bc.ImageConfiguration.Archs not set: use all archs
-	switch {
-	case len(archs) != 0:
-		ic.Archs = archs
-	case len(ic.Archs) != 0:
-		// do nothing
-	default:
-		ic.Archs = types.AllArchs
+	// Archs from the command line win over those of the configuration,
+	// which win over the defaults.
+	if err := ic.ResolveArchs(archs); err != nil {
+		return err
 	}
 	// save the final set we will build
 	archs = ic.Archs
@@ -179,16 +243,68 @@ func ShowPackagesCmd(ctx context.Context, format string, archs []types.Architect
 		if len(archs) != 1 {
 			log.Infof("packages for %s", arch)
 		}
+		if tree || why != "" {
+			t := newPkgTree(ic.Contents.Packages, pkgs)
+			if why != "" {
+				err = t.writeWhy(os.Stdout, why)
+			} else {
+				err = t.writeTree(os.Stdout)
+			}
+			if err != nil {
+				return fmt.Errorf("for arch %q: %w", arch, err)
+			}
+			continue
+		}
+		var total uint64
+		for _, pkg := range pkgs {
+			total += pkg.InstalledSize
+		}
+		if sizes {
+			slices.SortStableFunc(pkgs, func(a, b *apk.RepositoryPackage) int {
+				return cmp.Compare(b.InstalledSize, a.InstalledSize)
+			})
+		}
+		if byOrigin {
+			sortByOrigin(pkgs, sizes)
+		}
 		var p pkgInfo
 		for _, pkg := range pkgs {
 			p.Name = pkg.Name
 			p.Version = pkg.Version
 			p.Source = pkg.URL()
+			p.Origin = pkg.SourcePackage()
+			p.Size = pkg.InstalledSize
+			p.Share = 0
+			if total != 0 {
+				p.Share = 100 * float64(pkg.InstalledSize) / float64(total)
+			}
 			if err = tmpl.Execute(os.Stdout, p); err != nil {
 				return fmt.Errorf("failed to execute template: %w", err)
 			}
 			fmt.Println()
 		}
+		if sizes {
+			fmt.Printf("total %s\n", humanSize(total))
+		}
 	}
 	return nil
 }
+
+// sortByOrigin stably sorts pkgs so that those built from the same source
+// package are adjacent, ordered by origin name, or by the installed size of
+// each origin, largest first, if bySize is set.
+func sortByOrigin(pkgs []*apk.RepositoryPackage, bySize bool) {
+	originSize := make(map[string]uint64, len(pkgs))
+	for _, pkg := range pkgs {
+		originSize[pkg.SourcePackage()] += pkg.InstalledSize
+	}
+	slices.SortStableFunc(pkgs, func(a, b *apk.RepositoryPackage) int {
+		oa, ob := a.SourcePackage(), b.SourcePackage()
+		if bySize {
+			if c := cmp.Compare(originSize[ob], originSize[oa]); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(oa, ob)
+	})
+}
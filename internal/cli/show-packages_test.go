@@ -0,0 +1,102 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestSortByOrigin(t *testing.T) {
+	pkg := func(name, origin string, size uint64) *apk.RepositoryPackage {
+		return &apk.RepositoryPackage{Package: &apk.Package{Name: name, Origin: origin, InstalledSize: size}}
+	}
+	names := func(pkgs []*apk.RepositoryPackage) []string {
+		var names []string
+		for _, p := range pkgs {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+	installed := func() []*apk.RepositoryPackage {
+		return []*apk.RepositoryPackage{
+			pkg("libssl3", "openssl", 600),
+			pkg("ca-certificates", "", 200),
+			pkg("glibc", "glibc", 5000),
+			pkg("libcrypto3", "openssl", 4000),
+			pkg("glibc-locale-posix", "glibc", 400),
+		}
+	}
+
+	pkgs := installed()
+	sortByOrigin(pkgs, false)
+	require.Equal(t, []string{"ca-certificates", "glibc", "glibc-locale-posix", "libssl3", "libcrypto3"}, names(pkgs))
+
+	// glibc is 5400 bytes, openssl 4600.
+	pkgs = installed()
+	sortByOrigin(pkgs, true)
+	require.Equal(t, []string{"glibc", "glibc-locale-posix", "libssl3", "libcrypto3", "ca-certificates"}, names(pkgs))
+}
+
+func TestPkgTree(t *testing.T) {
+	pkg := func(name string, deps, provides []string) *apk.RepositoryPackage {
+		return &apk.RepositoryPackage{Package: &apk.Package{Name: name, Version: "1.0-r0", Dependencies: deps, Provides: provides}}
+	}
+	tree := newPkgTree([]string{"curl>=1", "ca-certificates-bundle", "glibc"}, []*apk.RepositoryPackage{
+		pkg("ca-certificates-bundle", nil, nil),
+		pkg("curl", []string{"so:libcurl.so.4", "so:libc.so.6"}, []string{"cmd:curl=1.0-r0"}),
+		pkg("glibc", []string{"!musl"}, []string{"so:libc.so.6=6", "so:libm.so.6=6"}),
+		pkg("libcurl-openssl4", []string{"so:libc.so.6", "so:libm.so.6", "so:libssl.so.3"}, []string{"so:libcurl.so.4=4"}),
+		pkg("libssl3", []string{"so:libc.so.6"}, []string{"so:libssl.so.3=3"}),
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, tree.writeTree(&out))
+	require.Equal(t, `ca-certificates-bundle 1.0-r0
+curl 1.0-r0 (via curl>=1)
+├── glibc 1.0-r0 (via so:libc.so.6)
+└── libcurl-openssl4 1.0-r0 (via so:libcurl.so.4)
+    ├── glibc 1.0-r0 (via so:libc.so.6, so:libm.so.6)
+    └── libssl3 1.0-r0 (via so:libssl.so.3)
+        └── glibc 1.0-r0 (via so:libc.so.6)
+glibc 1.0-r0
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, tree.writeWhy(&out, "so:libssl.so.3"))
+	require.Equal(t, `libssl3 1.0-r0
+└── libcurl-openssl4 1.0-r0 (via so:libssl.so.3)
+    └── curl 1.0-r0 (via so:libcurl.so.4)
+        └── contents.packages (via curl>=1)
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, tree.writeWhy(&out, "glibc"))
+	require.Equal(t, `glibc 1.0-r0
+├── contents.packages (via glibc)
+├── curl 1.0-r0 (via so:libc.so.6)
+│   └── contents.packages (via curl>=1)
+├── libcurl-openssl4 1.0-r0 (via so:libc.so.6, so:libm.so.6)
+│   └── curl 1.0-r0 (via so:libcurl.so.4) (*)
+└── libssl3 1.0-r0 (via so:libc.so.6)
+    └── libcurl-openssl4 1.0-r0 (via so:libssl.so.3) (*)
+`, out.String())
+
+	require.ErrorContains(t, tree.writeWhy(&out, "musl"), `nothing installed provides "musl"`)
+}
@@ -0,0 +1,66 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/build/oci"
+)
+
+func verifyTarball() *cobra.Command {
+	var sbomPath string
+
+	cmd := &cobra.Command{
+		Use:   "verify-tarball <file>",
+		Short: "Verify the digests in an OCI tarball built by apko",
+		Long: `Verify the digests in an OCI tarball built by apko.
+
+Every blob in the tarball must match the digest it is named by, the index,
+manifests, configs and layers must all be present with the digests and sizes
+recorded for them, and each layer must uncompress to the diffID recorded in
+its image's config.
+
+With --sbom-path, the SPDX SBOMs written alongside the tarball are checked
+to only reference images and layers that are in it.`,
+		Example: `  apko verify-tarball image.tar
+  apko verify-tarball image.tar --sbom-path .`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return VerifyTarballCmd(cmd.Context(), cmd.OutOrStdout(), args[0], sbomPath)
+		},
+	}
+
+	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "directory holding the SPDX SBOMs generated with the tarball, to check their references")
+
+	return cmd
+}
+
+func VerifyTarballCmd(_ context.Context, w io.Writer, path, sbomPath string) error {
+	summary, err := oci.VerifyTarball(path, sbomPath)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", path, err)
+	}
+	fmt.Fprintf(w, "%s: index %s, %d images, %d layers", path, summary.IndexDigest, summary.Images, summary.Layers)
+	if sbomPath != "" {
+		fmt.Fprintf(w, ", %d SBOMs", summary.SBOMs)
+	}
+	fmt.Fprintln(w, " verified")
+	return nil
+}
@@ -0,0 +1,536 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/requestid"
+	"chainguard.dev/apko/pkg/tarfs"
+)
+
+// workerBuildPath is where apko worker accepts builds.
+const workerBuildPath = "/v1/build"
+
+// workerTokenEnv names the environment variable holding the token that
+// callers of apko worker present, and that the worker requires when it is
+// not given --token-file.
+const workerTokenEnv = "APKO_WORKER_TOKEN"
+
+// maxWorkerRequest bounds the size of the requests apko worker accepts.
+const maxWorkerRequest = 16 << 20
+
+// workerConfig is how apko worker accepts builds.
+type workerConfig struct {
+	// token must be presented by callers as a bearer token.
+	token string
+	// allowLocal lets configurations refer to the worker's own files.
+	allowLocal bool
+}
+
+// workerRequest asks an apko worker to build the image of one architecture.
+type workerRequest struct {
+	Arch types.Architecture `json:"arch"`
+	// Config is the configuration of the architecture, with its packages
+	// locked by the caller so that every architecture agrees on them.
+	Config types.ImageConfiguration `json:"config"`
+	// SourceDateEpoch is set when the caller's SOURCE_DATE_EPOCH is.
+	SourceDateEpoch  *time.Time    `json:"sourceDateEpoch,omitempty"`
+	SBOMFormats      []string      `json:"sbomFormats,omitempty"`
+	LayerCompression string        `json:"layerCompression,omitempty"`
+	Compressor       string        `json:"compressor,omitempty"`
+	CompressionLevel int           `json:"compressionLevel,omitempty"`
+	FileTimestamp    string        `json:"fileTimestamp,omitempty"`
+	CreatedTimestamp string        `json:"createdTimestamp,omitempty"`
+	IDMap            options.IDMap `json:"idMap,omitempty"`
+	// The checks of the image filesystem, as the options of the same
+	// names ask for them.
+	PermissionCheck options.CheckMode `json:"permissionCheck,omitempty"`
+	PermissionAllow []string          `json:"permissionAllow,omitempty"`
+	ELFDeps         options.CheckMode `json:"elfDeps,omitempty"`
+	RequireStatic   bool              `json:"requireStatic,omitempty"`
+	SymlinkCheck    options.CheckMode `json:"symlinkCheck,omitempty"`
+	SymlinkAllow    []string          `json:"symlinkAllow,omitempty"`
+	StrictPaths     bool              `json:"strictPaths,omitempty"`
+	// RequestID is the caller's, which the worker tags the requests and
+	// logs of the build with instead of its own.
+	RequestID string `json:"requestID,omitempty"`
+	// Pin asks for the packages the worker installed in the result.
+	Pin bool `json:"pin,omitempty"`
+}
+
+// workerResult describes the image an apko worker built. It is the first
+// entry, workerResultName, of the tarball the worker responds with, followed
+// by the image as an OCI layout under workerImageName/ and its SBOMs under
+// sbom/. A layout keeps the manifest as the worker built it, so the image
+// has the same digest as if it was built locally.
+type workerResult struct {
+	BuildDateEpoch    time.Time              `json:"buildDateEpoch"`
+	Created           time.Time              `json:"created"`
+	Indexes           []apk.IndexDigest      `json:"indexes,omitempty"`
+	ChecksumIncidents []apk.ChecksumIncident `json:"checksumIncidents,omitempty"`
+	SBOMs             []types.SBOM           `json:"sboms,omitempty"`
+	// Packages are the packages the worker installed, as a lock file
+	// records them, when the request asks to pin them. It is not omitted
+	// when empty, so that an image without packages can be told from a
+	// worker that did not report them.
+	Packages []pkglock.LockPkg `json:"packages"`
+}
+
+// created returns when the image the worker built was created, which is the
+// build date epoch for workers that do not say.
+func (r *workerResult) created() time.Time {
+	if r.Created.IsZero() {
+		return r.BuildDateEpoch
+	}
+	return r.Created
+}
+
+const (
+	workerResultName = "result.json"
+	workerImageName  = "image"
+)
+
+func workerCmd() *cobra.Command {
+	var listen string
+	var cacheDir string
+	var tokenFile string
+	var tlsCert, tlsKey string
+	var allowLocal bool
+
+	cmd := &cobra.Command{
+		Use:   "worker",
+		Short: "Build images for other apko processes (experimental)",
+		Long: `Build images for other apko processes (experimental).
+
+apko build and apko publish delegate the architectures given with
+--remote-worker to a worker, so that a worker running on native hardware
+builds them rather than emulation. The worker builds the configuration it is
+sent, with the packages locked by the caller, and streams the image and its
+SBOMs back.
+
+Callers must present the token in --token-file, or else in $APKO_WORKER_TOKEN,
+which apko build and apko publish send from their own $APKO_WORKER_TOKEN.
+The worker listens on localhost unless told otherwise; to accept builds from
+other machines, listen on their network and serve TLS with --tls-cert and
+--tls-key so that the token is not sent in the clear.
+
+The configuration must only refer to remote repositories and keys, as the
+worker has no access to the caller's files, and configurations that refer to
+the worker's own files are refused unless --allow-local-sources is given.
+`,
+		Example: `  APKO_WORKER_TOKEN=... apko worker --listen :8443 --tls-cert cert.pem --tls-key key.pem
+  APKO_WORKER_TOKEN=... apko build --remote-worker arm64=https://arm-runner:8443 apko.yaml app:latest app.tar`,
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			token := os.Getenv(workerTokenEnv)
+			if tokenFile != "" {
+				b, err := os.ReadFile(tokenFile)
+				if err != nil {
+					return fmt.Errorf("reading token: %w", err)
+				}
+				token = strings.TrimSpace(string(b))
+			}
+			if token == "" {
+				return fmt.Errorf("a token is required, in --token-file or $%s", workerTokenEnv)
+			}
+			if (tlsCert == "") != (tlsKey == "") {
+				return errors.New("--tls-cert and --tls-key must be given together")
+			}
+
+			cfg := workerConfig{token: token, allowLocal: allowLocal}
+			srv := &http.Server{
+				Addr:              listen,
+				Handler:           newWorkerHandler(cfg, build.WithCache(cacheDir, false, apk.NewCache(true))),
+				ReadHeaderTimeout: 30 * time.Second,
+				BaseContext:       func(net.Listener) context.Context { return ctx },
+			}
+			go func() {
+				<-ctx.Done()
+				_ = srv.Shutdown(context.WithoutCancel(ctx))
+			}()
+			clog.FromContext(ctx).Infof("building images on %s", listen)
+			var err error
+			if tlsCert != "" {
+				err = srv.ListenAndServeTLS(tlsCert, tlsKey)
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "localhost:8080", "address to accept builds on")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "file holding the token callers must present (default '' means $"+workerTokenEnv+")")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "certificate to serve TLS with")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "private key of --tls-cert")
+	cmd.Flags().BoolVar(&allowLocal, "allow-local-sources", false, "build configurations that refer to local repositories, keys, paths or base images on the worker")
+
+	return cmd
+}
+
+// newWorkerHandler returns the handler of apko worker, which builds the
+// images it is asked for with opts, for callers presenting the token of cfg.
+func newWorkerHandler(cfg workerConfig, opts ...build.Option) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+workerBuildPath, func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.token)) != 1 {
+			http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+			return
+		}
+
+		var req workerRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWorkerRequest)).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("decoding request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if local := req.Config.LocalSources(); len(local) != 0 && !cfg.allowLocal {
+			http.Error(w, fmt.Sprintf("configuration refers to local sources %v", local), http.StatusForbidden)
+			return
+		}
+		ctx := r.Context()
+		log := clog.FromContext(ctx).With("arch", req.Arch.ToAPK())
+		if req.RequestID != "" {
+			rid, _ := requestid.FromContext(ctx)
+			rid.ID = req.RequestID
+			ctx = requestid.WithContext(ctx, rid)
+			log = log.With("caller_request_id", req.RequestID)
+		}
+		ctx = clog.WithLogger(ctx, log)
+
+		tmp, err := os.MkdirTemp("", "apko-worker-*")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(tmp)
+
+		log.Infof("building %s", req.Config.Contents.Packages)
+		result, err := workerBuild(ctx, tmp, req, opts...)
+		if err != nil {
+			log.Errorf("build failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-tar")
+		tw := tar.NewWriter(w)
+		b, err := json.Marshal(result)
+		if err != nil {
+			log.Errorf("encoding result: %v", err)
+			return
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: workerResultName, Mode: 0o644, Size: int64(len(b))}); err != nil {
+			log.Errorf("writing result: %v", err)
+			return
+		}
+		if _, err := tw.Write(b); err != nil {
+			log.Errorf("writing result: %v", err)
+			return
+		}
+		var files []string
+		if err := filepath.WalkDir(filepath.Join(tmp, workerImageName), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(tmp, p)
+			files = append(files, filepath.ToSlash(rel))
+			return err
+		}); err != nil {
+			log.Errorf("listing image: %v", err)
+			return
+		}
+		for _, s := range result.SBOMs {
+			files = append(files, path.Join("sbom", s.Path))
+		}
+		for _, name := range files {
+			if err := addTarFile(tw, name, filepath.Join(tmp, filepath.FromSlash(name))); err != nil {
+				log.Errorf("writing %s: %v", name, err)
+				return
+			}
+		}
+		if err := tw.Close(); err != nil {
+			log.Errorf("writing response: %v", err)
+		}
+	})
+	return mux
+}
+
+// workerBuild builds the image req asks for in dir, writing it as an OCI
+// layout to workerImageName and its SBOMs under sbom/.
+func workerBuild(ctx context.Context, dir string, req workerRequest, opts ...build.Option) (*workerResult, error) {
+	sbomDir, workDir := filepath.Join(dir, "sbom"), filepath.Join(dir, "work")
+	for _, d := range []string{sbomDir, workDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	opts = append(opts,
+		build.WithImageConfiguration(req.Config),
+		build.WithArch(req.Arch),
+		build.WithTempDir(workDir),
+		build.WithSBOM(sbomDir),
+		build.WithSBOMFormats(req.SBOMFormats),
+		build.WithLayerCompression(req.LayerCompression),
+		build.WithCompressor(req.Compressor),
+		build.WithCompressionLevel(req.CompressionLevel),
+		build.WithTimestamps(req.FileTimestamp, req.CreatedTimestamp),
+		build.WithIDMap(req.IDMap),
+		build.WithPermissionCheck(req.PermissionCheck, req.PermissionAllow),
+		build.WithELFDeps(req.ELFDeps),
+		build.WithRequireStatic(req.RequireStatic),
+		build.WithSymlinkCheck(req.SymlinkCheck, req.SymlinkAllow),
+		build.WithStrictPaths(req.StrictPaths),
+	)
+	if req.SourceDateEpoch != nil {
+		opts = append(opts, build.WithSourceDateEpoch(*req.SourceDateEpoch))
+	}
+
+	bc, err := build.New(ctx, tarfs.New(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := bc.BuildLayers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("building %q layer: %w", req.Arch, err)
+	}
+	bde, err := bc.GetBuildDateEpoch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine build date epoch: %w", err)
+	}
+	created, err := bc.CreatedTime()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine created time: %w", err)
+	}
+	img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ImageConfiguration(), created, bc.Arch())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OCI image for %q: %w", req.Arch, err)
+	}
+
+	result := &workerResult{BuildDateEpoch: bde, Created: created, Indexes: bc.ResolvedIndexes(), ChecksumIncidents: bc.ChecksumIncidents()}
+	if req.Pin {
+		result.Packages = lockPkgs(bc.ResolvedPackages())
+	}
+	if len(req.SBOMFormats) != 0 {
+		sboms, err := bc.GenerateImageSBOM(ctx, req.Arch, img)
+		if err != nil {
+			return nil, fmt.Errorf("generating sbom for %s: %w", req.Arch, err)
+		}
+		for _, s := range sboms {
+			s.Path = filepath.Base(s.Path)
+			result.SBOMs = append(result.SBOMs, s)
+		}
+	}
+
+	lp, err := layout.Write(filepath.Join(dir, workerImageName), empty.Index)
+	if err != nil {
+		return nil, fmt.Errorf("writing image: %w", err)
+	}
+	if err := lp.AppendImage(img); err != nil {
+		return nil, fmt.Errorf("writing image: %w", err)
+	}
+	return result, nil
+}
+
+// parseRemoteWorkers keys the worker URLs of --remote-worker by architecture.
+func parseRemoteWorkers(flags map[string]string) map[types.Architecture]string {
+	if len(flags) == 0 {
+		return nil
+	}
+	workers := make(map[types.Architecture]string, len(flags))
+	for arch, u := range flags {
+		workers[types.ParseArchitecture(arch)] = strings.TrimRight(u, "/")
+	}
+	return workers
+}
+
+// addTarFile adds the file at path to tw as name.
+func addTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: fi.Size()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// remoteBuild is an image built by an apko worker.
+type remoteBuild struct {
+	img    v1.Image
+	result workerResult
+}
+
+// buildRemote has the worker at workerURL build the image of arch from ic,
+// with the settings of o that affect it; build.New refuses the settings a
+// worker cannot apply. The image is written to dir, and
+// its SBOMs to sbomDir. With pin, the worker reports the packages it
+// installed.
+func buildRemote(ctx context.Context, workerURL string, arch types.Architecture, ic types.ImageConfiguration, o *options.Options, dir, sbomDir string, pin bool) (*remoteBuild, error) {
+	req := workerRequest{
+		Arch:             arch,
+		Config:           ic,
+		SBOMFormats:      o.SBOMFormats,
+		LayerCompression: o.LayerCompression,
+		Compressor:       o.Compressor,
+		CompressionLevel: o.CompressionLevel,
+		FileTimestamp:    o.FileTimestamp,
+		CreatedTimestamp: o.CreatedTimestamp,
+		IDMap:            o.IDMap,
+		PermissionCheck:  o.PermissionCheck,
+		PermissionAllow:  o.PermissionAllow,
+		ELFDeps:          o.ELFDeps,
+		RequireStatic:    o.RequireStatic,
+		SymlinkCheck:     o.SymlinkCheck,
+		SymlinkAllow:     o.SymlinkAllow,
+		StrictPaths:      o.StrictPaths,
+		Pin:              pin,
+	}
+	if rid, ok := requestid.FromContext(ctx); ok {
+		req.RequestID = rid.ID
+	}
+	if _, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
+		req.SourceDateEpoch = &o.SourceDateEpoch
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, workerURL+workerBuildPath, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv(workerTokenEnv); token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return nil, fmt.Errorf("contacting worker %s: %w", workerURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		return nil, fmt.Errorf("worker %s failed to build %s: %s: %s", workerURL, arch, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	rb := &remoteBuild{}
+	imageDir := filepath.Join(dir, fmt.Sprintf("remote-%s", arch.ToAPK()))
+	tr := tar.NewReader(resp.Body)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading response of worker %s: %w", workerURL, err)
+		}
+		var dst string
+		switch {
+		case hdr.Name == workerResultName:
+			if err := json.NewDecoder(tr).Decode(&rb.result); err != nil {
+				return nil, fmt.Errorf("decoding result of worker %s: %w", workerURL, err)
+			}
+			continue
+		case strings.HasPrefix(hdr.Name, workerImageName+"/") && filepath.IsLocal(filepath.FromSlash(hdr.Name)):
+			dst = filepath.Join(imageDir, filepath.FromSlash(strings.TrimPrefix(hdr.Name, workerImageName+"/")))
+		case path.Dir(hdr.Name) == "sbom":
+			dst = filepath.Join(sbomDir, path.Base(hdr.Name))
+		default:
+			continue
+		}
+		if err := writeFileFrom(dst, tr); err != nil {
+			return nil, fmt.Errorf("reading %s from worker %s: %w", hdr.Name, workerURL, err)
+		}
+	}
+
+	idx, err := layout.ImageIndexFromPath(imageDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading image from worker %s: %w", workerURL, err)
+	}
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading image from worker %s: %w", workerURL, err)
+	}
+	if len(m.Manifests) != 1 {
+		return nil, fmt.Errorf("worker %s returned %d images, expected 1", workerURL, len(m.Manifests))
+	}
+	rb.img, err = idx.Image(m.Manifests[0].Digest)
+	if err != nil {
+		return nil, fmt.Errorf("reading image from worker %s: %w", workerURL, err)
+	}
+	for i := range rb.result.SBOMs {
+		rb.result.SBOMs[i].Path = filepath.Join(sbomDir, filepath.Base(rb.result.SBOMs[i].Path))
+	}
+	return rb, nil
+}
+
+// writeFileFrom writes the contents of r to a new file at path.
+func writeFileFrom(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
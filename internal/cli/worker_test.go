@@ -0,0 +1,174 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestBuildRemoteWorker(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	// The worker shares the test's files, so it may read the local
+	// repository of the configuration.
+	t.Setenv(workerTokenEnv, "secret")
+	srv := httptest.NewServer(newWorkerHandler(workerConfig{token: "secret", allowLocal: true}, build.WithCache(t.TempDir(), false, apk.NewCache(true))))
+	defer srv.Close()
+
+	arm64 := types.ParseArchitecture("arm64")
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithSBOMFormats([]string{"spdx"}),
+		build.WithTags("golden:latest"),
+		build.WithAnnotations(map[string]string{
+			"org.opencontainers.image.vendor": "Vendor",
+			"org.opencontainers.image.title":  "Title",
+		}),
+		build.WithRemoteWorkers(map[types.Architecture]string{arm64: srv.URL}),
+	}
+
+	sbomPath := filepath.Join(tmp, "sboms")
+	require.NoError(t, os.MkdirAll(sbomPath, 0o750))
+	require.NoError(t, BuildCmd(ctx, "golden:latest", tmp, archs, []string{}, true, sbomPath, opts...))
+
+	// The image the worker built is the same as if it was built locally.
+	root, err := layout.ImageIndexFromPath(tmp)
+	require.NoError(t, err)
+	gold, err := layout.ImageIndexFromPath(filepath.Join("testdata", "golden"))
+	require.NoError(t, err)
+
+	got, err := root.Digest()
+	require.NoError(t, err)
+	want, err := gold.Digest()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	_, err = os.Stat(filepath.Join(sbomPath, "sbom-aarch64.spdx.json"))
+	require.NoError(t, err)
+}
+
+func TestBuildRemoteWorkerFailure(t *testing.T) {
+	t.Setenv(workerTokenEnv, "secret")
+	srv := httptest.NewServer(newWorkerHandler(workerConfig{token: "secret"}))
+	defer srv.Close()
+
+	_, err := buildRemote(context.Background(), srv.URL, types.ParseArchitecture("arm64"), types.ImageConfiguration{
+		Contents: types.ImageContents{Packages: []string{"does-not-exist"}},
+	}, &options.Options{}, t.TempDir(), t.TempDir(), false)
+	require.ErrorContains(t, err, "failed to build arm64")
+}
+
+func TestBuildRemoteForwardsChecks(t *testing.T) {
+	var got workerRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		http.Error(w, "not building", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	o := &options.Options{
+		IDMap:           options.IDMap{UIDs: []options.IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}},
+		PermissionCheck: options.CheckFail,
+		PermissionAllow: []string{"tmp/**"},
+		ELFDeps:         options.CheckWarn,
+		RequireStatic:   true,
+		SymlinkCheck:    options.CheckFail,
+		SymlinkAllow:    []string{"proc/**"},
+		StrictPaths:     true,
+	}
+	_, err := buildRemote(context.Background(), srv.URL, types.ParseArchitecture("arm64"), types.ImageConfiguration{}, o, t.TempDir(), t.TempDir(), false)
+	require.ErrorContains(t, err, "not building")
+	require.Equal(t, o.IDMap, got.IDMap)
+	require.Equal(t, o.PermissionCheck, got.PermissionCheck)
+	require.Equal(t, o.PermissionAllow, got.PermissionAllow)
+	require.Equal(t, o.ELFDeps, got.ELFDeps)
+	require.True(t, got.RequireStatic)
+	require.Equal(t, o.SymlinkCheck, got.SymlinkCheck)
+	require.Equal(t, o.SymlinkAllow, got.SymlinkAllow)
+	require.True(t, got.StrictPaths)
+}
+
+func TestRemoteWorkersRefuseLocalOptions(t *testing.T) {
+	workers := build.WithRemoteWorkers(map[types.Architecture]string{types.ParseArchitecture("arm64"): "http://localhost:8080"})
+	for name, opt := range map[string]build.Option{
+		"input policy": build.WithInputPolicy("policy.yaml"),
+		"split debug":  build.WithSplitDebug("debug"),
+		"mutator": build.WithFSMutator(func(context.Context, apkfs.FullFS) error {
+			return nil
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := build.NewOptions(workers, opt)
+			require.ErrorContains(t, err, "remote workers cannot build with")
+		})
+	}
+}
+
+func TestWorkerHandlerRefusals(t *testing.T) {
+	srv := httptest.NewServer(newWorkerHandler(workerConfig{token: "secret"}))
+	defer srv.Close()
+
+	post := func(token string, body []byte) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+workerBuildPath, bytes.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	request := func(ic types.ImageConfiguration) []byte {
+		b, err := json.Marshal(workerRequest{Arch: types.ParseArchitecture("arm64"), Config: ic})
+		require.NoError(t, err)
+		return b
+	}
+	remote := types.ImageConfiguration{Contents: types.ImageContents{Packages: []string{"does-not-exist"}}}
+
+	require.Equal(t, http.StatusUnauthorized, post("", request(remote)).StatusCode)
+	require.Equal(t, http.StatusUnauthorized, post("wrong", request(remote)).StatusCode)
+
+	for name, ic := range map[string]types.ImageConfiguration{
+		"repository": {Contents: types.ImageContents{Repositories: []string{"/etc/apk/local"}}},
+		"file url":   {Contents: types.ImageContents{Repositories: []string{"@local file:///srv/packages"}}},
+		"keyring":    {Contents: types.ImageContents{Keyring: []string{"/root/.ssh/id_rsa"}}},
+		"path":       {Paths: []types.PathMutation{{Type: "local", Path: "/etc/shadow", Source: "/etc/shadow"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, http.StatusForbidden, post("secret", request(ic)).StatusCode)
+		})
+	}
+
+	require.Equal(t, http.StatusBadRequest, post("secret", bytes.Repeat([]byte(" "), maxWorkerRequest+1)).StatusCode)
+}
@@ -131,7 +131,70 @@ type LDSOCacheFile struct {
 
 type elfInfo struct {
 	Machine elf.Machine `yaml:"machine"`
-	Sonames []string    `yaml:"sonames"`
+	Class   elf.Class   `yaml:"class"`
+	// Flags is the processor-specific e_flags field of the ELF header,
+	// which records the float ABI on arm and riscv.
+	Flags   uint32   `yaml:"flags"`
+	Sonames []string `yaml:"sonames"`
+}
+
+// ELF e_flags bits that select the glibc library flavour.
+const (
+	efARMABIFloatHard     = 0x400
+	efRISCVFloatABIMask   = 0x6
+	efRISCVFloatABIDouble = 0x4
+	elf32FlagsOffset      = 0x24
+	elf64FlagsOffset      = 0x30
+)
+
+// machineFlags returns the cache entry flags for a library, the way
+// ldconfig computes them, and whether the machine is supported at all.
+// Every library is marked libc6: ldconfig has done so since it dropped
+// libc5 support, and ld.so ignores entries without the flag.
+func machineFlags(ei elfInfo) (uint32, bool) {
+	flags := FlagELF | FlagELFLIBC6
+	switch ei.Machine {
+	case elf.EM_X86_64:
+		if ei.Class == elf.ELFCLASS32 {
+			return flags | FlagX8664LIBX32, true
+		}
+		return flags | FlagX8664LIB64, true
+	case elf.EM_AARCH64:
+		return flags | FlagAARCH64LIB64, true
+	case elf.EM_PPC64:
+		return flags | FlagPOWERPCLIB64, true
+	case elf.EM_S390:
+		if ei.Class == elf.ELFCLASS64 {
+			return flags | FlagS390LIB64, true
+		}
+		return flags, true
+	case elf.EM_RISCV:
+		if ei.Flags&efRISCVFloatABIMask == efRISCVFloatABIDouble {
+			return flags | FlagRISCVFLOATABIDOUBLE, true
+		}
+		return flags | FlagRISCVFLOATABISOFT, true
+	case elf.EM_ARM:
+		if ei.Flags&efARMABIFloatHard != 0 {
+			return flags | FlagARMLIBHF, true
+		}
+		return flags | FlagARMLIBSF, true
+	case elf.EM_386, elf.EM_PPC:
+		return flags, true
+	}
+	return 0, false
+}
+
+// readELFFlags reads e_flags, which debug/elf does not expose.
+func readELFFlags(r io.ReaderAt, f *elf.File) (uint32, error) {
+	off := int64(elf64FlagsOffset)
+	if f.Class == elf.ELFCLASS32 {
+		off = elf32FlagsOffset
+	}
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], off); err != nil {
+		return 0, fmt.Errorf("reading e_flags: %w", err)
+	}
+	return f.ByteOrder.Uint32(buf[:]), nil
 }
 
 func doGetElfInfo(libfReaderAt io.ReaderAt) (elfInfo, error) {
@@ -149,8 +212,14 @@ func doGetElfInfo(libfReaderAt io.ReaderAt) (elfInfo, error) {
 	if err != nil {
 		return info, err
 	}
+	flags, err := readELFFlags(libfReaderAt, elflibf)
+	if err != nil {
+		return info, err
+	}
 	info = elfInfo{
 		Machine: elflibf.Machine,
+		Class:   elflibf.Class,
+		Flags:   flags,
 		Sonames: sonames,
 	}
 	return info, nil
@@ -288,18 +357,10 @@ func ldsoCacheEntriesForDir(fsys fs.FS, libdir string) ([]LDSOCacheEntry, error)
 			continue
 		}
 
-		flags := uint32(0)
-		flags |= FlagELF
-		// FIXME: Shouldn't just assert this
-		flags |= FlagELFLIBC6
-		switch li.elf.Machine {
-		case elf.EM_X86_64:
-			flags |= FlagX8664LIB64
-		case elf.EM_AARCH64:
-			flags |= FlagAARCH64LIB64
-		// FIXME: Add other architectures
-		default:
-			return nil, fmt.Errorf("%s: unknown machine type %v", li.path, li.elf.Machine)
+		flags, ok := machineFlags(li.elf)
+		if !ok {
+			// ldconfig likewise leaves out libraries it cannot classify.
+			continue
 		}
 
 		for _, soname := range li.elf.Sonames {
@@ -352,8 +413,10 @@ func LDSOCacheEntriesForDirs(fsys fs.FS, libdirs []string) ([]LDSOCacheEntry, er
 	}
 
 	// ld expects entries to be reverse-sorted by name. Otherwise
-	// it may report "No such file or directory"
-	sort.Slice(allEntries, func(i, j int) bool {
+	// it may report "No such file or directory". The sort is stable so that
+	// libraries of the same name keep the order of their directories and
+	// the cache comes out the same on every build.
+	sort.SliceStable(allEntries, func(i, j int) bool {
 		return filepath.Base(allEntries[j].Name) < filepath.Base(allEntries[i].Name)
 	})
 
@@ -628,9 +691,13 @@ func ParseLDSOConf(fsys fs.FS, ldsoconf string) ([]string, error) {
 					debugf("Warning: Could not parse config file %s\n", match)
 					continue
 				}
-				libpaths = append(libpaths, incpaths...)
+				for _, libpath := range incpaths {
+					if !slices.Contains(libpaths, libpath) {
+						libpaths = append(libpaths, libpath)
+					}
+				}
 			}
-			return libpaths, nil
+			continue
 		}
 
 		libpath := line
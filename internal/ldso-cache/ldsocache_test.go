@@ -1,11 +1,13 @@
 package ldsocache
 
 import (
+	"debug/elf"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
@@ -129,6 +131,39 @@ func Test_ParseLDSOConf_Glob(t *testing.T) {
 	require.Contains(t, dirs, "/b/libs")
 }
 
+func Test_ParseLDSOConf_IncludeThenDirs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/ld.so.conf":          {Data: []byte("include /etc/ld.so.conf.d/*.conf\n/opt/lib\n/usr/lib\n")},
+		"etc/ld.so.conf.d/a.conf": {Data: []byte("/usr/lib\n")},
+	}
+	dirs, err := ParseLDSOConf(fsys, "etc/ld.so.conf")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/usr/lib", "/opt/lib"}, dirs)
+}
+
+func Test_MachineFlags(t *testing.T) {
+	for _, tc := range []struct {
+		ei   elfInfo
+		want uint32
+		ok   bool
+	}{
+		{elfInfo{Machine: elf.EM_X86_64, Class: elf.ELFCLASS64}, FlagX8664LIB64, true},
+		{elfInfo{Machine: elf.EM_AARCH64, Class: elf.ELFCLASS64}, FlagAARCH64LIB64, true},
+		{elfInfo{Machine: elf.EM_PPC64, Class: elf.ELFCLASS64}, FlagPOWERPCLIB64, true},
+		{elfInfo{Machine: elf.EM_S390, Class: elf.ELFCLASS64}, FlagS390LIB64, true},
+		{elfInfo{Machine: elf.EM_RISCV, Class: elf.ELFCLASS64, Flags: 0x5}, FlagRISCVFLOATABIDOUBLE, true},
+		{elfInfo{Machine: elf.EM_ARM, Class: elf.ELFCLASS32, Flags: 0x5000400}, FlagARMLIBHF, true},
+		{elfInfo{Machine: elf.EM_386, Class: elf.ELFCLASS32}, 0, true},
+		{elfInfo{Machine: elf.EM_SPARCV9, Class: elf.ELFCLASS64}, 0, false},
+	} {
+		flags, ok := machineFlags(tc.ei)
+		require.Equal(t, tc.ok, ok, tc.ei.Machine)
+		if ok {
+			require.Equal(t, FlagELFLIBC6|tc.want, flags, tc.ei.Machine)
+		}
+	}
+}
+
 // Instead of real ELF binaries, our "libraries" are YAML files
 // that are used to populate an elfInfo structure.
 func mockGetElfInfo(r io.ReaderAt) (elfInfo, error) {
@@ -0,0 +1,85 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pathglob matches slash-separated paths against glob patterns in
+// which "**" stands for any number of directories.
+package pathglob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Validate reports whether pattern is well formed.
+func Validate(pattern string) error {
+	for _, seg := range split(pattern) {
+		if seg == "**" {
+			continue
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether name matches pattern. Each segment of pattern is
+// matched against one segment of name as by path.Match, except "**", which
+// matches zero or more segments. Leading slashes are ignored on both, so
+// patterns may be written as absolute paths in the image.
+func Match(pattern, name string) bool {
+	return match(split(pattern), split(name))
+}
+
+// MatchOrParent reports whether name or any of its parent directories
+// matches pattern, so that a pattern naming a directory covers its contents.
+func MatchOrParent(pattern, name string) bool {
+	pat, segs := split(pattern), split(name)
+	for i := len(segs); i > 0; i-- {
+		if match(pat, segs[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func split(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func match(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if match(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], segs[0]); err != nil || !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,51 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathglob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**/src.zip", "usr/lib/jvm/java-17/lib/src.zip", true},
+		{"**/src.zip", "src.zip", true},
+		{"/usr/share/locale/**", "usr/share/locale/de/LC_MESSAGES/foo.mo", true},
+		{"usr/share/locale/**", "usr/share/locale", true},
+		{"usr/lib/jvm/*/demo", "usr/lib/jvm/java-17/demo", true},
+		{"usr/lib/jvm/*/demo", "usr/lib/jvm/java-17/demo/x", false},
+		{"usr/**/*.a", "usr/lib/libc.a", true},
+		{"usr/**/*.a", "usr/lib/libc.so", false},
+		{"etc/*.conf", "etc/a/b.conf", false},
+		{"etc/[", "etc/[", false},
+	} {
+		require.Equal(t, tc.want, Match(tc.pattern, tc.name), "%s ~ %s", tc.pattern, tc.name)
+	}
+}
+
+func TestMatchOrParent(t *testing.T) {
+	require.True(t, MatchOrParent("usr/lib/jvm/*/demo", "usr/lib/jvm/java-17/demo/x/y.java"))
+	require.False(t, MatchOrParent("usr/lib/jvm/*/demo", "usr/lib/jvm/java-17/lib"))
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate("**/src.zip"))
+	require.Error(t, Validate("usr/[a"))
+}
@@ -76,6 +76,9 @@ type APKIndex struct { //nolint:revive
 	Signature   []byte
 	Description string
 	Packages    []*Package
+	// Digest is the digest of the APKINDEX.tar.gz the index was read from,
+	// as "sha256:<hex>", when it was fetched from a repository.
+	Digest string
 }
 
 // Splitting empty string results in single element array with one empty string, which would
@@ -25,7 +25,9 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/chainguard-dev/clog"
 	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/singleflight"
 
@@ -92,6 +94,10 @@ type Cache struct {
 	getFlight  *singleflight.Group
 
 	discoverKeys *flightCache[[]Key]
+
+	// mirrors is the health of the repositories and mirrors the builds
+	// sharing this Cache fetch from.
+	mirrors *mirrorHealth
 }
 
 // NewCache returns a new Cache, which allows us to persist the results of HEAD requests
@@ -110,6 +116,7 @@ func NewCache(etag bool) *Cache {
 		headFlight:   &singleflight.Group{},
 		getFlight:    &singleflight.Group{},
 		discoverKeys: newFlightCache[[]Key](),
+		mirrors:      newMirrorHealth(),
 	}
 
 	if etag {
@@ -140,10 +147,25 @@ func (c *Cache) store(cacheFile string, resp *http.Response) {
 	c.etagCache.Store(cacheFile, resp)
 }
 
+// mirrorHealth returns the mirror health shared through c, or nil when the
+// instance has no shared Cache and so keeps its own.
+func (c *cache) mirrorHealth() *mirrorHealth {
+	if c == nil || c.shared == nil {
+		return nil
+	}
+	return c.shared.mirrors
+}
+
 // cache
 type cache struct {
 	dir     string
 	offline bool
+	// lower is an optional read-only cache directory consulted on misses.
+	lower string
+	// store, if set, records the metadata of the cache.
+	store KVStore
+	// now is the clock entries are recorded in store with.
+	now func() time.Time
 
 	shared *Cache
 }
@@ -156,6 +178,9 @@ func (c *cache) client(wrapped *http.Client, etagRequired bool) *http.Client {
 			cache:        c.shared,
 			wrapped:      wrapped,
 			root:         c.dir,
+			lower:        c.lower,
+			store:        c.store,
+			now:          c.now,
 			offline:      c.offline,
 			etagRequired: etagRequired,
 		},
@@ -166,6 +191,9 @@ type cacheTransport struct {
 	cache        *Cache
 	wrapped      *http.Client
 	root         string
+	lower        string
+	store        KVStore
+	now          func() time.Time
 	offline      bool
 	etagRequired bool
 }
@@ -183,6 +211,13 @@ func (t *cacheTransport) RoundTrip(request *http.Request) (*http.Response, error
 		// Try to open the file in the cache.
 		// If we hit an error, just send the request.
 		f, err := os.Open(cacheFile)
+		if err != nil && t.lower != "" {
+			if lowerFile, lerr := cachePathFromURL(t.lower, *request.URL); lerr == nil {
+				if lf, lerr := os.Open(lowerFile); lerr == nil {
+					f, err = lf, nil
+				}
+			}
+		}
 		if err != nil {
 			if t.offline {
 				return nil, fmt.Errorf("failed to read %q in offline cache: %w", cacheFile, err)
@@ -202,7 +237,18 @@ func (t *cacheTransport) RoundTrip(request *http.Request) (*http.Response, error
 	}
 
 	if t.offline {
-		return t.fetchOffline(cacheFile)
+		if file, ok := t.lookupEntry(ctx, *request.URL); ok {
+			return openCached(file)
+		}
+		resp, err := t.fetchOffline(cacheFile)
+		if err != nil && t.lower != "" {
+			if lowerFile, lerr := cachePathFromURL(t.lower, *request.URL); lerr == nil {
+				if resp, lerr := t.fetchOffline(lowerFile); lerr == nil {
+					return resp, nil
+				}
+			}
+		}
+		return resp, err
 	}
 
 	return t.fetchAndCache(ctx, request, cacheFile)
@@ -244,10 +290,32 @@ func (t *cacheTransport) get(ctx context.Context, request *http.Request, cacheFi
 			return "", err
 		}
 		if _, err := os.Stat(etagFile); err == nil {
+			t.recordEntry(ctx, *request.URL, initialEtag)
+			return etagFile, nil
+		}
+		if t.lower != "" {
+			if lowerFile, err := cachePathFromURL(t.lower, *request.URL); err == nil {
+				if lowerEtagFile, err := cacheFileFromEtag(lowerFile, initialEtag); err == nil {
+					if _, err := os.Stat(lowerEtagFile); err == nil {
+						t.recordEntry(ctx, *request.URL, initialEtag)
+						return lowerEtagFile, nil
+					}
+				}
+			}
+		}
+
+		// Only download the index once, across processes sharing the cache too.
+		unlock, err := lockCacheFile(ctx, t.root, etagFile)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+		// Another process may have downloaded it while we waited.
+		if _, err := os.Stat(etagFile); err == nil {
+			t.recordEntry(ctx, *request.URL, initialEtag)
 			return etagFile, nil
 		}
 
-		// Only download the index once.
 		return t.retrieveAndSaveFile(ctx, request, func(r *http.Response) (string, error) {
 			_, span := otel.Tracer("go-apk").Start(ctx, "callback")
 			defer span.End()
@@ -258,7 +326,11 @@ func (t *cacheTransport) get(ctx context.Context, request *http.Request, cacheFi
 				return "", fmt.Errorf("GET response did not contain an etag, but HEAD returned %q", initialEtag)
 			}
 
-			return cacheFileFromEtag(cacheFile, finalEtag)
+			file, err := cacheFileFromEtag(cacheFile, finalEtag)
+			if err == nil {
+				t.recordEntry(ctx, *request.URL, finalEtag)
+			}
+			return file, err
 		})
 	})
 	if err != nil {
@@ -272,6 +344,14 @@ func (t *cacheTransport) fetchAndCache(ctx context.Context, request *http.Reques
 	initialEtag := request.Header.Get("I-Cant-Believe-Its-Not-If-None-Match")
 	if initialEtag == "" {
 		resp, err := t.head(request, cacheFile)
+		if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+			// Serve what the store says was fetched last rather than fail
+			// while the repository is unreachable.
+			if file, ok := t.lookupEntry(ctx, *request.URL); ok && request.Method != http.MethodHead {
+				clog.FromContext(ctx).Warnf("using cached %s, last fetched before the repository became unreachable", storeKey(*request.URL))
+				return openCached(file)
+			}
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -299,14 +379,20 @@ func (t *cacheTransport) fetchAndCache(ctx context.Context, request *http.Reques
 		return nil, err
 	}
 
-	f, err := os.Open(etagFile)
+	return openCached(etagFile)
+}
+
+// openCached returns a response serving the cached file.
+func openCached(file string) (*http.Response, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		return nil, fmt.Errorf("open(%q): %w", etagFile, err)
+		return nil, fmt.Errorf("open(%q): %w", file, err)
 	}
 
 	fi, err := f.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("stat(%q): %w", etagFile, err)
+		f.Close()
+		return nil, fmt.Errorf("stat(%q): %w", file, err)
 	}
 
 	return &http.Response{
@@ -392,13 +478,20 @@ func cacheFileFromEtag(cacheFile, etag string) (string, error) {
 	return absPath, nil
 }
 
+// etagFromResponse returns the version of the resource resp is for, which
+// is its etag. For servers that send no etag, its Last-Modified time stands
+// in, as If-Modified-Since would use it.
 func etagFromResponse(resp *http.Response) (string, bool) {
-	remoteEtag, ok := resp.Header[http.CanonicalHeaderKey("etag")]
-	if !ok || len(remoteEtag) == 0 || remoteEtag[0] == "" {
+	var etag string
+	if remoteEtag := resp.Header.Get("ETag"); remoteEtag != "" {
+		// When we get etags, they appear to be quoted.
+		etag = strings.Trim(remoteEtag, `"`)
+	} else if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		// Prefixed so that it can't be taken for an etag.
+		etag = "last-modified:" + lastModified
+	} else {
 		return "", false
 	}
-	// When we get etags, they appear to be quoted.
-	etag := strings.Trim(remoteEtag[0], `"`)
 
 	// To ensure these things are safe filenames, base32 encode them.
 	// (Avoiding base64 due to case sensitive filesystems.)
@@ -0,0 +1,201 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestCacheNamespace(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	a, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(dir, true, NewCache(false)), WithCacheNamespace("team-a"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "namespaces", "team-a"), a.cache.dir)
+
+	for _, bad := range []string{"../escape", "a/b", ".hidden"} {
+		_, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(dir, true, nil), WithCacheNamespace(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestLowerCache(t *testing.T) {
+	ctx := context.Background()
+	lower := t.TempDir()
+
+	a, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(t.TempDir(), true, NewCache(false)), WithCacheNamespace("team-a"), WithLowerCache(lower))
+	require.NoError(t, err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://packages.example/os/x86_64/foo-1.0-r0.apk", nil)
+	require.NoError(t, err)
+
+	// Offline and not in either cache.
+	_, err = a.cache.client(nil, false).Do(req)
+	require.Error(t, err)
+
+	lowerFile, err := cachePathFromURL(lower, *req.URL)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(lowerFile), 0o755))
+	require.NoError(t, os.WriteFile(lowerFile, []byte("from lower"), 0o644))
+
+	resp, err := a.cache.client(nil, false).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "from lower", string(b))
+}
+
+func TestCacheLastModified(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+			_, _ = w.Write([]byte("index"))
+		}
+	}))
+	defer srv.Close()
+
+	// Each build has its own Cache, so only the files are shared.
+	for range 2 {
+		a, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(dir, false, NewCache(false)))
+		require.NoError(t, err)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/os/x86_64/APKINDEX.tar.gz", nil)
+		require.NoError(t, err)
+		resp, err := a.cache.client(&http.Client{}, true).Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "index", string(b))
+	}
+	require.EqualValues(t, 1, gets.Load())
+}
+
+func TestCacheSharedDownload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			_, _ = w.Write([]byte("index"))
+		}
+	}))
+	defer srv.Close()
+
+	// Builds with their own Cache stand in for processes sharing the
+	// directory: only the file lock keeps them from downloading twice.
+	var g errgroup.Group
+	for range 4 {
+		g.Go(func() error {
+			a, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(dir, false, NewCache(false)))
+			if err != nil {
+				return err
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/os/x86_64/APKINDEX.tar.gz", nil)
+			if err != nil {
+				return err
+			}
+			resp, err := a.cache.client(&http.Client{}, true).Do(req)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		})
+	}
+	require.NoError(t, g.Wait())
+	require.EqualValues(t, 1, gets.Load())
+}
+
+func TestLockCacheFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cache files are not locked on windows")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+
+	unlock, err := lockCacheFile(ctx, dir, file)
+	require.NoError(t, err)
+
+	// Another holder has to wait until the lock is released.
+	cctx, cancel := context.WithTimeout(ctx, 3*cacheLockPoll)
+	defer cancel()
+	_, err = lockCacheFile(cctx, dir, file)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Other files are not held up.
+	unlockOther, err := lockCacheFile(ctx, dir, filepath.Join(dir, "other"))
+	require.NoError(t, err)
+	unlockOther()
+
+	// A waiter takes the lock once it is released, on a lock file of its
+	// own as the released one is removed.
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockCacheFile(ctx, dir, file)
+		assert.NoError(t, err)
+		locked <- unlock
+	}()
+	time.Sleep(2 * cacheLockPoll)
+	unlock()
+	unlock = <-locked
+	cctx, cancel = context.WithTimeout(ctx, 3*cacheLockPoll)
+	defer cancel()
+	_, err = lockCacheFile(cctx, dir, file)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	unlock()
+
+	// The lock is named by the cached file within the cache, wherever the
+	// cache is.
+	moved := filepath.Join(t.TempDir(), "cache")
+	require.NoError(t, os.Symlink(dir, moved))
+	unlock, err = lockCacheFile(ctx, dir, file)
+	require.NoError(t, err)
+	cctx, cancel = context.WithTimeout(ctx, 3*cacheLockPoll)
+	defer cancel()
+	_, err = lockCacheFile(cctx, moved, filepath.Join(moved, "file"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	unlock()
+
+	// Released locks leave no files behind.
+	entries, err := os.ReadDir(filepath.Join(dir, ".locks"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,96 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheLockPoll is how often a process waiting for another to download a
+// file into the cache checks whether it is done.
+var cacheLockPoll = 50 * time.Millisecond
+
+// lockCacheFile takes a lock on cacheFile that is shared by every process
+// using the cache in root, such as concurrent CI jobs on one runner, so that
+// only one of them downloads the file. The lock is named by the digest of
+// cacheFile relative to root, which names the cached content by its URL and
+// ETag, so processes that mount the cache at different paths share it too.
+// The lock files are kept apart from the cached files, as offline builds list
+// the directories of those. The returned func releases the lock and removes
+// its file.
+func lockCacheFile(ctx context.Context, root, cacheFile string) (func(), error) {
+	dir := filepath.Join(root, ".locks")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating cache lock directory: %w", err)
+	}
+	key, err := filepath.Rel(root, cacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", cacheFile, err)
+	}
+	h := sha256.Sum256([]byte(filepath.ToSlash(key)))
+	path := filepath.Join(dir, hex.EncodeToString(h[:]))
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o664)
+		if err != nil {
+			return nil, fmt.Errorf("opening cache lock: %w", err)
+		}
+		if err := waitLock(ctx, f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", cacheFile, err)
+		}
+		// The holder we waited for removed the file we locked when it was
+		// done, so take the lock on the one now at path.
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err != nil || !os.SameFile(locked, current) {
+			f.Close()
+			continue
+		}
+		return func() {
+			// Removing the file while holding the lock leaves waiters
+			// holding a file no longer at path, which they retry on. A
+			// file that cannot be removed is locked again next time.
+			_ = os.Remove(path)
+			f.Close()
+		}, nil
+	}
+}
+
+// waitLock waits until it takes the lock on f or ctx is done.
+func waitLock(ctx context.Context, f *os.File) error {
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cacheLockPoll):
+		}
+	}
+}
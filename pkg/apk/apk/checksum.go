@@ -0,0 +1,146 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+
+	"chainguard.dev/apko/pkg/apk/expandapk"
+)
+
+// ChecksumIncident records a package download that failed checksum
+// verification, for instance because a CDN edge served a corrupted copy.
+type ChecksumIncident struct {
+	Package string `json:"package"`
+	URL     string `json:"url"`
+	Error   string `json:"error"`
+	// Mirror is the URL a valid copy of the package was then fetched from,
+	// if any of the repository's mirrors served one.
+	Mirror string `json:"mirror,omitempty"`
+}
+
+// ChecksumIncidents returns the package downloads that failed checksum
+// verification, whether or not a mirror then served a valid copy.
+// Credentials are redacted from their URLs.
+func (a *APK) ChecksumIncidents() []ChecksumIncident {
+	a.checksumIncidentsMu.Lock()
+	defer a.checksumIncidentsMu.Unlock()
+	return slices.Clone(a.checksumIncidents)
+}
+
+func (a *APK) recordChecksumIncident(incident ChecksumIncident) {
+	a.checksumIncidentsMu.Lock()
+	defer a.checksumIncidentsMu.Unlock()
+	a.checksumIncidents = append(a.checksumIncidents, incident)
+}
+
+// mirroredPackage is a package fetched from a mirror of its repository.
+type mirroredPackage struct {
+	InstallablePackage
+	url string
+}
+
+func (m mirroredPackage) URL() string {
+	return m.url
+}
+
+// mirrorURLs returns the URLs of u on each mirror of the repository it
+// belongs to, in the configured order.
+func (a *APK) mirrorURLs(u string) []string {
+	repo, rest, ok := matchRepository(a.mirrors, u)
+	if !ok {
+		return nil
+	}
+	urls := make([]string, 0, len(a.mirrors[repo]))
+	for _, m := range a.mirrors[repo] {
+		urls = append(urls, m+rest)
+	}
+	return urls
+}
+
+// fetchAndExpand fetches pkg and expands it into cacheDir. A download that
+// fails checksum verification is retried from the mirrors of the package's
+// repository, in order, and recorded as an incident either way.
+func (a *APK) fetchAndExpand(ctx context.Context, pkg InstallablePackage, cacheDir string) (*expandapk.APKExpanded, error) {
+	log := clog.FromContext(ctx)
+
+	exp, err := a.fetchAndVerify(ctx, pkg, cacheDir)
+	if !errors.Is(err, expandapk.ErrChecksumMismatch) {
+		return exp, err
+	}
+
+	incident := ChecksumIncident{Package: pkg.PackageName(), URL: redact(pkg.URL()), Error: err.Error()}
+	defer func() {
+		a.recordChecksumIncident(incident)
+	}()
+
+	for _, u := range a.mirrorURLs(pkg.URL()) {
+		log.Warnf("%v, retrying from %s", err, redact(u))
+
+		mexp, merr := a.fetchAndVerify(ctx, mirroredPackage{InstallablePackage: pkg, url: u}, cacheDir)
+		if merr == nil {
+			incident.Mirror = redact(u)
+			return mexp, nil
+		}
+		log.Warnf("fetching from mirror %s: %v", redact(u), merr)
+	}
+	return nil, err
+}
+
+// fetchAndVerify fetches and expands pkg, checking its control section
+// against the checksum of the index it was resolved from, if known. The
+// contents are checked against the checksums of the control section as they
+// are expanded.
+func (a *APK) fetchAndVerify(ctx context.Context, pkg InstallablePackage, cacheDir string) (*expandapk.APKExpanded, error) {
+	rc, err := a.FetchPackage(ctx, pkg)
+	if err != nil {
+		return nil, fmt.Errorf("fetching package %q: %w", pkg.PackageName(), err)
+	}
+	defer rc.Close()
+
+	exp, err := expandapk.ExpandApk(ctx, rc, cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("expanding %s: %w", pkg.PackageName(), err)
+	}
+
+	if err := verifyControlHash(pkg, exp); err != nil {
+		return nil, errors.Join(fmt.Errorf("verifying %s: %w", pkg.PackageName(), err), exp.Close())
+	}
+	return exp, nil
+}
+
+func verifyControlHash(pkg InstallablePackage, exp *expandapk.APKExpanded) error {
+	chk := pkg.ChecksumString()
+	if chk == "Q1" || !strings.HasPrefix(chk, "Q1") {
+		// The checksum is unknown, or not a SHA1 of the control section.
+		return nil
+	}
+	want, err := base64.StdEncoding.DecodeString(chk[2:])
+	if err != nil {
+		return fmt.Errorf("decoding checksum %q: %w", chk, err)
+	}
+	if !bytes.Equal(want, exp.ControlHash) {
+		return fmt.Errorf("%w: control section is Q1%s, index has %s", expandapk.ErrChecksumMismatch, base64.StdEncoding.EncodeToString(exp.ControlHash), chk)
+	}
+	return nil
+}
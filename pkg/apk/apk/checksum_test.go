@@ -0,0 +1,72 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/expandapk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestFetchChecksumMismatch(t *testing.T) {
+	// The primary serves another package than the one the index describes,
+	// as a corrupted CDN edge might.
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/hello-0.1.0-r0.apk")
+	}))
+	defer primary.Close()
+
+	mirror := httptest.NewServer(http.StripPrefix("/mirror", http.FileServer(http.Dir(testPrimaryPkgDir))))
+	defer mirror.Close()
+
+	repo := Repository{URI: primary.URL + "/os"}
+	pkg := NewRepositoryPackage(&testPkg, repo.WithIndex(&APKIndex{Packages: []*Package{&testPkg}}))
+
+	t.Run("mirror", func(t *testing.T) {
+		a, err := New(t.Context(), WithFS(apkfs.NewMemFS()), WithMirrors(map[string][]string{
+			primary.URL + "/os/": {mirror.URL + "/mirror/"},
+		}))
+		require.NoError(t, err)
+
+		exp, err := a.fetchAndExpand(t.Context(), pkg, "")
+		require.NoError(t, err)
+		defer exp.Close()
+		require.Equal(t, testPkg.Checksum, exp.ControlHash)
+
+		incidents := a.ChecksumIncidents()
+		require.Len(t, incidents, 1)
+		require.Equal(t, pkg.PackageName(), incidents[0].Package)
+		require.Equal(t, pkg.URL(), incidents[0].URL)
+		require.Contains(t, incidents[0].Error, "checksum mismatch")
+		require.Equal(t, mirror.URL+"/mirror/"+testPkgFilename, incidents[0].Mirror)
+	})
+
+	t.Run("no mirror", func(t *testing.T) {
+		a, err := New(t.Context(), WithFS(apkfs.NewMemFS()))
+		require.NoError(t, err)
+
+		_, err = a.fetchAndExpand(t.Context(), pkg, "")
+		require.ErrorIs(t, err, expandapk.ErrChecksumMismatch)
+
+		incidents := a.ChecksumIncidents()
+		require.Len(t, incidents, 1)
+		require.Empty(t, incidents[0].Mirror)
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MergedConstraints describes a package that was requested more than once.
+type MergedConstraints struct {
+	Name string
+	// Requested are the constraints as they were requested, in order.
+	Requested []string
+	// Effective are the constraints that remain after merging.
+	Effective []string
+}
+
+// MergeConstraints folds together the constraints that name the same package,
+// as when a package is listed directly and again by an included
+// configuration, so that each requirement reaches the resolver once.
+//
+// Exact duplicates, and bare names implied by a versioned constraint, are
+// dropped; of several lower or upper bounds only the tightest is kept; and an
+// exact version replaces every range it satisfies. Constraints that no single
+// version can satisfy, or that pin the package to different repositories, are
+// an error.
+//
+// It returns the merged constraints, in the order the packages were first
+// requested, along with each package that was requested more than once.
+func MergeConstraints(constraints []string) ([]string, []MergedConstraints, error) {
+	var (
+		order  []string
+		groups = map[string][]string{}
+	)
+	for _, c := range constraints {
+		key := c
+		if !strings.HasPrefix(c, "!") {
+			key = ResolvePackageNameVersionPin(c).Name
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], c)
+	}
+
+	merged := make([]string, 0, len(constraints))
+	var merges []MergedConstraints
+	for _, name := range order {
+		requested := groups[name]
+		if len(requested) == 1 {
+			merged = append(merged, requested[0])
+			continue
+		}
+		effective, err := mergeConstraints(name, requested)
+		if err != nil {
+			return nil, nil, err
+		}
+		merged = append(merged, effective...)
+		merges = append(merges, MergedConstraints{Name: name, Requested: requested, Effective: effective})
+	}
+	return merged, merges, nil
+}
+
+// mergeConstraints merges the constraints on the single package name.
+func mergeConstraints(name string, requested []string) ([]string, error) {
+	var unique []string
+	for _, c := range requested {
+		if !slices.Contains(unique, c) {
+			unique = append(unique, c)
+		}
+	}
+	if len(unique) == 1 || strings.HasPrefix(name, "!") {
+		return unique, nil
+	}
+
+	conflict := func() error {
+		return fmt.Errorf("conflicting constraints on %s: %s", name, strings.Join(unique, ", "))
+	}
+
+	type bound struct {
+		constraint string
+		parsed     ParsedConstraint
+		version    Version
+	}
+	var (
+		pin                 string
+		bare                string
+		exact, lower, upper *bound
+		tildes              []string
+		versioned           []bound
+	)
+	for _, c := range unique {
+		p := ResolvePackageNameVersionPin(c)
+		if p.pin != "" {
+			if pin != "" && pin != p.pin {
+				return nil, fmt.Errorf("%s is pinned to both @%s and @%s", name, pin, p.pin)
+			}
+			pin = p.pin
+		}
+		if p.dep == versionAny {
+			if bare == "" {
+				bare = c
+			}
+			continue
+		}
+		v, err := cachedParseVersion(p.Version)
+		if err != nil {
+			return nil, fmt.Errorf("parsing constraint %q: %w", c, err)
+		}
+		b := bound{constraint: c, parsed: p, version: v}
+		versioned = append(versioned, b)
+		switch p.dep {
+		case versionEqual:
+			if exact == nil {
+				exact = &b
+			}
+		case versionGreater, versionGreaterEqual:
+			// The higher bound is the tighter; at equal versions, the strict one.
+			if lower == nil {
+				lower = &b
+			} else if r := CompareVersions(v, lower.version); r == greater || (r == equal && p.dep == versionGreater) {
+				lower = &b
+			}
+		case versionLess, versionLessEqual:
+			if upper == nil {
+				upper = &b
+			} else if r := CompareVersions(v, upper.version); r == less || (r == equal && p.dep == versionLess) {
+				upper = &b
+			}
+		case versionTilde:
+			tildes = append(tildes, c)
+		}
+	}
+
+	var effective []string
+	switch {
+	case exact != nil:
+		// Every other constraint, including other exact ones, must admit it.
+		for _, b := range versioned {
+			if !b.parsed.dep.satisfies(exact.version, b.version) {
+				return nil, conflict()
+			}
+		}
+		effective = []string{exact.constraint}
+	case len(versioned) == 0:
+		effective = []string{bare}
+	default:
+		if lower != nil && upper != nil {
+			c := CompareVersions(lower.version, upper.version)
+			if c == greater || (c == equal && (lower.parsed.dep == versionGreater || upper.parsed.dep == versionLess)) {
+				return nil, conflict()
+			}
+		}
+		if lower != nil {
+			effective = append(effective, lower.constraint)
+		}
+		if upper != nil {
+			effective = append(effective, upper.constraint)
+		}
+		effective = append(effective, tildes...)
+	}
+
+	// A pin on any of the constraints applies to the package as a whole.
+	if pin != "" {
+		for i, c := range effective {
+			if !strings.Contains(c, "@") {
+				effective[i] = c + "@" + pin
+			}
+		}
+	}
+	return effective, nil
+}
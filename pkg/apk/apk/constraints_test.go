@@ -0,0 +1,88 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeConstraints(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		in      []string
+		want    []string
+		wantErr string
+	}{{
+		name: "distinct packages",
+		in:   []string{"foo", "bar>1.0"},
+		want: []string{"foo", "bar>1.0"},
+	}, {
+		name: "exact duplicates",
+		in:   []string{"foo", "bar", "foo"},
+		want: []string{"foo", "bar"},
+	}, {
+		name: "bare name implied by a constraint",
+		in:   []string{"foo", "foo>=1.2"},
+		want: []string{"foo>=1.2"},
+	}, {
+		name: "tightest bounds",
+		in:   []string{"foo>1.0", "foo<3.0", "foo>=1.5", "foo<=2.0", "foo>1.5"},
+		want: []string{"foo>1.5", "foo<=2.0"},
+	}, {
+		name: "exact version within a range",
+		in:   []string{"foo>1.0", "foo=1.2-r0", "foo<2.0"},
+		want: []string{"foo=1.2-r0"},
+	}, {
+		name: "pin applies to every constraint",
+		in:   []string{"foo@local", "foo>1.0"},
+		want: []string{"foo>1.0@local"},
+	}, {
+		name: "conflicts are left alone",
+		in:   []string{"!foo", "!foo", "foo"},
+		want: []string{"!foo", "foo"},
+	}, {
+		name:    "different exact versions",
+		in:      []string{"foo=1.0-r0", "foo=1.1-r0"},
+		wantErr: "conflicting constraints on foo: foo=1.0-r0, foo=1.1-r0",
+	}, {
+		name:    "exact version outside a range",
+		in:      []string{"foo=1.0-r0", "foo>1.1"},
+		wantErr: "conflicting constraints on foo",
+	}, {
+		name:    "empty range",
+		in:      []string{"foo>2.0", "foo<2.0"},
+		wantErr: "conflicting constraints on foo",
+	}, {
+		name:    "different pins",
+		in:      []string{"foo@a", "foo@b"},
+		wantErr: "foo is pinned to both @a and @b",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := MergeConstraints(tt.in)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, merges, err := MergeConstraints([]string{"foo", "bar", "foo>1.0"})
+	require.NoError(t, err)
+	require.Equal(t, []MergedConstraints{{Name: "foo", Requested: []string{"foo", "foo>1.0"}, Effective: []string{"foo>1.0"}}}, merges)
+}
@@ -0,0 +1,129 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IP families the fetcher can be restricted to.
+const (
+	// IPFamilyDual connects over IPv6 or IPv4, racing them as in RFC 8305
+	// ("Happy Eyeballs").
+	IPFamilyDual = ""
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// DialOptions control how the fetcher connects to repositories.
+type DialOptions struct {
+	// Family restricts connections to one IP family. With IPFamilyIPv6,
+	// hosts without an IPv6 address fail right away, naming the addresses
+	// they do have, which validates that a build works on an IPv6-only
+	// network.
+	Family string `json:"family,omitempty"`
+	// FallbackDelay is how long a dual-stack connection attempt waits on the
+	// preferred family before also trying the other one. Zero uses the
+	// net package default of 300ms; a negative delay disables the race, so
+	// the families are tried one after the other.
+	FallbackDelay time.Duration `json:"fallbackDelay,omitempty"`
+	// ConnectTimeout bounds each connection attempt, across all of the
+	// host's addresses. Zero uses the 30s of the default transport.
+	ConnectTimeout time.Duration `json:"connectTimeout,omitempty"`
+}
+
+func (d DialOptions) validate() error {
+	switch d.Family {
+	case IPFamilyDual, IPFamilyIPv4, IPFamilyIPv6:
+	default:
+		return fmt.Errorf("unknown IP family %q, must be %q or %q", d.Family, IPFamilyIPv4, IPFamilyIPv6)
+	}
+	if d.ConnectTimeout < 0 {
+		return fmt.Errorf("connect timeout must not be negative, got %s", d.ConnectTimeout)
+	}
+	return nil
+}
+
+// network returns the network to dial for the family.
+func (d DialOptions) network() string {
+	switch d.Family {
+	case IPFamilyIPv4:
+		return "tcp4"
+	case IPFamilyIPv6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// apply makes t dial as configured.
+func (d DialOptions) apply(t *http.Transport) {
+	timeout := d.ConnectTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{
+		Timeout:       timeout,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: d.FallbackDelay,
+	}
+	t.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, d.network(), addr)
+		if err != nil && d.Family != IPFamilyDual {
+			return nil, d.explain(ctx, addr, err)
+		}
+		return conn, err
+	}
+}
+
+// explain wraps err, from dialing addr in the family, with the addresses the
+// host has in the other family, if it only has those.
+func (d DialOptions) explain(ctx context.Context, addr string, err error) error {
+	host, _, serr := net.SplitHostPort(addr)
+	if serr != nil {
+		return err
+	}
+	addrs, lerr := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if lerr != nil {
+		return err
+	}
+	others := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if (a.IP.To4() == nil) == (d.Family == IPFamilyIPv6) {
+			// The host has an address in the family, it is just not
+			// reachable.
+			return err
+		}
+		others = append(others, a.String())
+	}
+	return fmt.Errorf("%s has no %s address, only %s: %w", host, d.Family, strings.Join(others, ", "), err)
+}
+
+// WithDialOptions controls how repositories are connected to. It needs the
+// transport to be an *http.Transport, as it is unless WithTransport sets
+// another one.
+func WithDialOptions(d DialOptions) Option {
+	return func(o *opts) error {
+		if err := d.validate(); err != nil {
+			return err
+		}
+		o.dial = &d
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestDialOptions(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	get := func(t *testing.T, d DialOptions) error {
+		tr := &http.Transport{}
+		d.apply(tr)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	require.NoError(t, get(t, DialOptions{}))
+	require.NoError(t, get(t, DialOptions{Family: IPFamilyIPv4, FallbackDelay: -1}))
+
+	// The test server only listens on 127.0.0.1.
+	err := get(t, DialOptions{Family: IPFamilyIPv6})
+	require.ErrorContains(t, err, "127.0.0.1 has no ipv6 address, only 127.0.0.1")
+
+	_, err = New(ctx, WithFS(apkfs.NewMemFS()), WithDialOptions(DialOptions{Family: IPFamilyIPv6}))
+	require.NoError(t, err)
+	_, err = New(ctx, WithFS(apkfs.NewMemFS()), WithDialOptions(DialOptions{Family: "ipx"}))
+	require.ErrorContains(t, err, `unknown IP family "ipx"`)
+
+	_, err = New(ctx, WithFS(apkfs.NewMemFS()), WithTransport(http.NewFileTransport(http.Dir("."))), WithDialOptions(DialOptions{Family: IPFamilyIPv4}))
+	require.ErrorContains(t, err, "dial options need an *http.Transport")
+}
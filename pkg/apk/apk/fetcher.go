@@ -0,0 +1,105 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A Fetcher fetches the indexes, packages and keys of repositories whose
+// URLs have a scheme it is registered for with RegisterFetcher, for sites
+// whose artifact stores speak their own protocol rather than HTTP.
+type Fetcher interface {
+	// Fetch returns the contents of the file at u, such as
+	// ipfs://<cid>/x86_64/APKINDEX.tar.gz. If there is none, the error
+	// wraps fs.ErrNotExist, so that a missing index is skipped as it is for
+	// local repositories.
+	Fetch(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+}
+
+// FetcherFunc is a function that is a Fetcher.
+type FetcherFunc func(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+
+// Fetch calls f.
+func (f FetcherFunc) Fetch(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	return f(ctx, u)
+}
+
+var (
+	fetchersMu sync.RWMutex
+	fetchers   = map[string]Fetcher{}
+)
+
+// RegisterFetcher makes f fetch the repositories, packages and keys whose
+// URLs have the given scheme, e.g. "ipfs". The schemes handled here, file,
+// http and https, cannot be registered, and a scheme can only be registered
+// once.
+func RegisterFetcher(scheme string, f Fetcher) error {
+	scheme = strings.ToLower(scheme)
+	switch scheme {
+	case "":
+		return fmt.Errorf("registering fetcher: empty scheme")
+	case "file", "http", "https":
+		return fmt.Errorf("registering fetcher: scheme %s is built in", scheme)
+	}
+	if f == nil {
+		return fmt.Errorf("registering fetcher for %s: nil fetcher", scheme)
+	}
+
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	if _, ok := fetchers[scheme]; ok {
+		return fmt.Errorf("registering fetcher: scheme %s is already registered", scheme)
+	}
+	fetchers[scheme] = f
+	return nil
+}
+
+// fetcherFor returns the Fetcher registered for the scheme of s, with s
+// parsed, or a nil Fetcher if s has no registered scheme.
+func fetcherFor(s string) (Fetcher, *url.URL, error) {
+	scheme, _, ok := strings.Cut(s, "://")
+	if !ok {
+		return nil, nil, nil
+	}
+
+	fetchersMu.RLock()
+	f, ok := fetchers[strings.ToLower(scheme)]
+	fetchersMu.RUnlock()
+	if !ok {
+		return nil, nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", redact(s), err)
+	}
+	return f, u, nil
+}
+
+// fetchAll reads all of the file at u with f.
+func fetchAll(ctx context.Context, f Fetcher, u *url.URL) ([]byte, error) {
+	rc, err := f.Fetch(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
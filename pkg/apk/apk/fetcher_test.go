@@ -0,0 +1,86 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestRegisterFetcher(t *testing.T) {
+	// Serves the files of the test repository by base name, as a site's
+	// artifact store might.
+	var fetched atomic.Int32
+	require.NoError(t, RegisterFetcher("testfetch", FetcherFunc(func(_ context.Context, u *url.URL) (io.ReadCloser, error) {
+		fetched.Add(1)
+		require.Equal(t, "store.example.com", u.Host)
+		return os.Open(filepath.Join(testPrimaryPkgDir, path.Base(u.Path)))
+	})))
+
+	require.ErrorContains(t, RegisterFetcher("testfetch", FetcherFunc(nil)), "already registered")
+	require.ErrorContains(t, RegisterFetcher("HTTPS", FetcherFunc(nil)), "built in")
+	require.ErrorContains(t, RegisterFetcher("", FetcherFunc(nil)), "empty scheme")
+	require.ErrorContains(t, RegisterFetcher("other", nil), "nil fetcher")
+
+	ctx := context.Background()
+	src := apkfs.NewMemFS()
+	require.NoError(t, src.MkdirAll(keysDirPath, 0o755))
+	require.NoError(t, src.WriteFile(archFilePath, []byte(testArch+"\n"), 0o644))
+	for k, v := range testKeys {
+		require.NoError(t, src.WriteFile(filepath.Join(keysDirPath, k), []byte(v), 0o644))
+	}
+	require.NoError(t, src.WriteFile(reposFilePath, []byte("testfetch://store.example.com/alpine/v3.16/main\n"), 0o644))
+	a, err := New(ctx, WithFS(src), WithIgnoreMknodErrors(ignoreMknodErrors))
+	require.NoError(t, err)
+
+	indexes, err := a.GetRepositoryIndexes(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, indexes, 1)
+	require.NotEmpty(t, indexes[0].Packages())
+
+	// The index is fetched once per run.
+	_, err = a.GetRepositoryIndexes(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), fetched.Load())
+
+	var pkg *RepositoryPackage
+	for _, p := range indexes[0].Packages() {
+		if p.Name == "alpine-baselayout" && p.Version == "3.2.0-r23" {
+			pkg = p
+		}
+	}
+	require.NotNil(t, pkg)
+	require.True(t, strings.HasPrefix(pkg.URL(), "testfetch://store.example.com/"), pkg.URL())
+
+	rc, err := a.FetchPackage(ctx, pkg)
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	want, err := os.ReadFile(filepath.Join(testPrimaryPkgDir, pkg.Filename()))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
@@ -0,0 +1,86 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"archive/tar"
+
+	"chainguard.dev/apko/internal/pathglob"
+)
+
+// FileFilter selects which files of a package are installed. Patterns are
+// globs over the paths in the package, in which "**" matches any number of
+// directories. A pattern matching a directory also matches its contents.
+type FileFilter struct {
+	// Include, if not empty, installs only the files matching one of
+	// these patterns. Directories are always created.
+	Include []string
+	// Exclude skips the files matching any of these patterns.
+	Exclude []string
+}
+
+func (f FileFilter) validate() error {
+	for _, p := range append(f.Include, f.Exclude...) {
+		if err := pathglob.Validate(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keep reports whether the entry described by header is installed.
+func (f FileFilter) keep(header *tar.Header) bool {
+	for _, p := range f.Exclude {
+		if pathglob.MatchOrParent(p, header.Name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 || header.Typeflag == tar.TypeDir {
+		return true
+	}
+	for _, p := range f.Include {
+		if pathglob.MatchOrParent(p, header.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileFilter tracks the entries of one package dropped by its FileFilter,
+// so that hard links to them are dropped as well.
+type fileFilter struct {
+	filter  FileFilter
+	dropped map[string]bool
+}
+
+func (a *APK) newFileFilter(pkg *Package) *fileFilter {
+	f, ok := a.fileFilters[pkg.Name]
+	if !ok {
+		return nil
+	}
+	return &fileFilter{filter: f, dropped: map[string]bool{}}
+}
+
+// skip reports whether header is filtered out. A nil fileFilter keeps all.
+func (ff *fileFilter) skip(header *tar.Header) bool {
+	if ff == nil {
+		return false
+	}
+	if !ff.filter.keep(header) || (header.Typeflag == tar.TypeLink && ff.dropped[header.Linkname]) {
+		ff.dropped[header.Name] = true
+		return true
+	}
+	return false
+}
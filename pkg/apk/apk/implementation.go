@@ -48,7 +48,6 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.step.sm/crypto/jose"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/sys/unix"
 	"gopkg.in/ini.v1"
 
 	"chainguard.dev/apko/internal/tarfs"
@@ -56,6 +55,7 @@ import (
 	"chainguard.dev/apko/pkg/apk/expandapk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 	"chainguard.dev/apko/pkg/paths"
+	"chainguard.dev/apko/pkg/requestid"
 
 	"github.com/chainguard-dev/clog"
 )
@@ -76,15 +76,70 @@ type APK struct {
 	ignoreSignatures   bool
 	noSignatureIndexes []string
 	auth               auth.Authenticator
+	fileFilters        map[string]FileFilter
+	// repository URL -> mirror URLs, without trailing slashes
+	mirrors map[string][]string
+	retry   RetryPolicy
+	now     func() time.Time
 
 	// filename to owning package, last write wins
 	installedFiles map[string]*Package
 
+	// the indexes the last ResolveWorld resolved against
+	resolvedIndexes   []IndexDigest
+	resolvedIndexesMu sync.Mutex
+
+	// the package downloads that failed checksum verification
+	checksumIncidents   []ChecksumIncident
+	checksumIncidentsMu sync.Mutex
+
 	// This is a map of arch to apk.APK for every arch in a mult-arch situation.
 	// It's stuffed here to avoid plumbing it across every method, but it's optional.
 	ByArch map[string]*APK
 }
 
+// NewTransport returns the transport an APK made by New with options sends
+// its requests through, before they are retried, sent to mirrors or rate
+// limited: that of WithTransport, connecting as WithDialOptions says and
+// verifying servers as WithCATrust says. Other options are ignored.
+func NewTransport(options ...Option) (http.RoundTripper, error) {
+	opt := defaultOpts()
+	for _, o := range options {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	return opt.configureTransport()
+}
+
+// configureTransport returns o.transport with the dial and CA trust options
+// of o applied.
+func (o *opts) configureTransport() (http.RoundTripper, error) {
+	transport := o.transport
+	if o.dial != nil {
+		t, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("dial options need an *http.Transport, got %T", transport)
+		}
+		t = t.Clone()
+		o.dial.apply(t)
+		transport = t
+	}
+
+	if o.caTrust != CATrustDefault {
+		t, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("CA trust options need an *http.Transport, got %T", transport)
+		}
+		t = t.Clone()
+		if err := applyCATrust(t, o.caTrust); err != nil {
+			return nil, err
+		}
+		transport = t
+	}
+	return transport, nil
+}
+
 func New(ctx context.Context, options ...Option) (*APK, error) {
 	opt := defaultOpts()
 	for _, o := range options {
@@ -93,14 +148,41 @@ func New(ctx context.Context, options ...Option) (*APK, error) {
 		}
 	}
 
+	if opt.cache != nil {
+		if opt.cacheNamespace != "" {
+			opt.cache.dir = filepath.Join(opt.cache.dir, "namespaces", opt.cacheNamespace)
+		}
+		opt.cache.lower = opt.lowerCacheDir
+		opt.cache.store = opt.cacheStore
+		opt.cache.now = opt.now
+	}
+
 	if opt.fs == nil {
 		// This is expensive so we only want to do it if we aren't passed WithFS.
 		opt.fs = apkfs.DirFS(ctx, "/")
 	}
 
-	client := retryablehttp.NewClient()
+	t, err := opt.configureTransport()
+	if err != nil {
+		return nil, err
+	}
+	opt.transport = t
 
-	client.HTTPClient = &http.Client{Transport: opt.transport}
+	client := retryablehttp.NewClient()
+	opt.retry.apply(client)
+
+	// Rate limiting hosts are backed off from after mirrors are applied,
+	// as that is where the requests go.
+	hosts := opt.backoff
+	if hosts == nil {
+		hosts = NewBackoff(opt.retry)
+	}
+	// Requests are tagged with the ID of their context innermost, so that
+	// every retry and mirror of them is too.
+	transport := requestid.NewTransport(opt.transport, requestid.Config{})
+	transport = newMirrorTransport(hosts.Transport(transport), opt.mirrors, opt.cache.mirrorHealth(), opt.now)
+	transport = newRateLimitedTransport(transport, opt.rateLimiter)
+	client.HTTPClient = &http.Client{Transport: transport}
 	client.Logger = clog.FromContext(ctx)
 
 	return &APK{
@@ -115,6 +197,10 @@ func New(ctx context.Context, options ...Option) (*APK, error) {
 		noSignatureIndexes: opt.noSignatureIndexes,
 		installedFiles:     map[string]*Package{},
 		auth:               opt.auth,
+		fileFilters:        opt.fileFilters,
+		mirrors:            trimMirrors(opt.mirrors),
+		retry:              opt.retry,
+		now:                opt.now,
 	}, nil
 }
 
@@ -193,6 +279,12 @@ func (a *APK) SetClient(client *http.Client) {
 	a.client = client
 }
 
+// Client returns the HTTP client packages and indexes are fetched with,
+// which applies the CA trust, dial, retry and mirror options of the APK.
+func (a *APK) Client() *http.Client {
+	return a.client
+}
+
 // ListInitFiles list the files that are installed during the InitDB phase.
 func (a *APK) ListInitFiles() []tar.Header {
 	headers := make([]tar.Header, 0, 20)
@@ -301,7 +393,7 @@ func (a *APK) InitDB(ctx context.Context, buildRepos ...string) error {
 	}
 	for _, e := range initDeviceFiles {
 		perms := uint32(e.perms.Perm())
-		err := a.fs.Mknod(e.path, unix.S_IFCHR|perms, int(unix.Mkdev(e.major, e.minor)))
+		err := a.fs.Mknod(e.path, apkfs.IFCHR|perms, int(apkfs.Mkdev(e.major, e.minor)))
 		if !a.ignoreMknodErrors && err != nil {
 			return fmt.Errorf("failed to create char device %s: %w", e.path, err)
 		}
@@ -521,6 +613,17 @@ func (a *APK) InitKeyring(ctx context.Context, keyFiles, extraKeyFiles []string)
 		eg.Go(func() error {
 			log.Debugf("installing key %v", element)
 
+			if f, asURL, err := fetcherFor(element); err != nil {
+				return err
+			} else if f != nil {
+				data, err := fetchAll(ctx, f, asURL)
+				if err != nil {
+					return fmt.Errorf("failed to fetch apk key: %w", err)
+				}
+				// #nosec G306 -- apk keyring must be publicly readable
+				return a.fs.WriteFile(filepath.Join("etc", "apk", "keys", filepath.Base(element)), data, 0o644)
+			}
+
 			var asURL *url.URL
 			var err error
 			if strings.HasPrefix(element, "https://") || strings.HasPrefix(element, "http://") {
@@ -601,6 +704,7 @@ func (a *APK) ResolveWorld(ctx context.Context) (toInstall []*RepositoryPackage,
 	}
 	// debugging info, if requested
 	log.Debugf("got %d indexes:\n%s", len(indexes), strings.Join(indexNames(indexes), "\n"))
+	a.recordResolvedIndexes(indexes)
 
 	// 2. Get the dependency tree for each package from the world file
 	directPkgs, err := a.GetWorld()
@@ -709,14 +813,8 @@ func (a *APK) FixateWorld(ctx context.Context, sourceDateEpoch *time.Time) ([]In
 	//     d. Update /usr/lib/apk/db/scripts.tar
 	//     d. Update /usr/lib/apk/db/triggers
 	//     e. Update the installed file
-	for _, pkg := range conflicts {
-		isInstalled, err := a.isInstalledPackage(pkg)
-		if err != nil {
-			return nil, fmt.Errorf("error checking if package %s is installed: %w", pkg, err)
-		}
-		if isInstalled {
-			return nil, fmt.Errorf("cannot install due to conflict with %s", pkg)
-		}
+	if err := a.CheckConflicts(conflicts); err != nil {
+		return nil, err
 	}
 	// Cast []*RepositoryPackage into []InstallablePackage.
 	allInstPkgs := make([]InstallablePackage, len(allpkgs))
@@ -734,6 +832,9 @@ func (a *APK) FixateWorld(ctx context.Context, sourceDateEpoch *time.Time) ([]In
 type InstalledDiff struct {
 	Package *Package
 	Diff    []byte
+	// Resolved describes the sections of the package as it was fetched,
+	// for packages resolved from a repository index.
+	Resolved *APKResolved
 }
 
 func (a *APK) InstallPackages(ctx context.Context, sourceDateEpoch *time.Time, allpkgs []InstallablePackage) ([]InstalledDiff, error) {
@@ -851,10 +952,14 @@ func (a *APK) InstallPackages(ctx context.Context, sourceDateEpoch *time.Time, a
 			return nil, fmt.Errorf("unable to update installed file for pkg %s: %w", pkg.Name, err)
 		}
 
-		diffs = append(diffs, InstalledDiff{
+		installed := InstalledDiff{
 			Package: pkg,
 			Diff:    diff,
-		})
+		}
+		if rp, ok := allpkgs[i].(*RepositoryPackage); ok {
+			installed.Resolved = NewAPKResolved(rp, expanded[i])
+		}
+		diffs = append(diffs, installed)
 	}
 
 	// Resolve the APK DB location
@@ -911,7 +1016,7 @@ func (a *APK) fetchAlpineKeys(ctx context.Context, alpineVersions ...string) err
 		if branch == nil {
 			continue
 		}
-		urls = append(urls, branch.KeysFor(a.arch, time.Now())...)
+		urls = append(urls, branch.KeysFor(a.arch, a.now())...)
 	}
 	if len(urls) == 0 {
 		return &NoKeysFoundError{arch: a.arch, releases: alpineVersions}
@@ -1056,12 +1161,14 @@ func (a *APK) DiscoverKeys(ctx context.Context, repository string) ([]Key, error
 
 		if !a.cache.offline {
 			rc := retryablehttp.NewClient()
+			a.retry.apply(rc)
 			rc.HTTPClient = client
 			rc.Logger = clog.FromContext(ctx)
 			client = rc.StandardClient()
 		}
 
-		return a.cache.shared.discoverKeys.Do(repository, func() ([]Key, error) {
+		// Keys are trust roots, so never share them across cache namespaces.
+		return a.cache.shared.discoverKeys.Do(a.cache.dir+"|"+repository, func() ([]Key, error) {
 			return DiscoverKeys(ctx, client, a.auth, repository)
 		})
 	}
@@ -1270,7 +1377,9 @@ type apkCache struct {
 }
 
 func (c *apkCache) get(ctx context.Context, a *APK, pkg InstallablePackage) (*expandapk.APKExpanded, error) {
-	u := pkg.URL()
+	// Key by cache directory too, so cache namespaces stay isolated within
+	// one process.
+	u := a.cache.dir + "|" + pkg.URL()
 	// Do all the expensive things inside the once.
 	once, _ := c.onces.LoadOrStore(u, &sync.Once{})
 	once.(*sync.Once).Do(func() {
@@ -1323,20 +1432,25 @@ func expandPackage(ctx context.Context, a *APK, pkg InstallablePackage) (*expand
 
 		log.Debugf("cache miss (%s): %v", pkg.PackageName(), err)
 
+		if a.cache.lower != "" {
+			lowerDir, err := cacheDirForPackage(a.cache.lower, pkg)
+			if err != nil {
+				return nil, err
+			}
+			if exp, err := a.cachedPackage(ctx, pkg, lowerDir); err == nil {
+				log.Debugf("lower cache hit (%s)", pkg.PackageName())
+				return exp, nil
+			}
+		}
+
 		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
 			return nil, fmt.Errorf("unable to create cache directory %q: %w", cacheDir, err)
 		}
 	}
 
-	rc, err := a.FetchPackage(ctx, pkg)
-	if err != nil {
-		return nil, fmt.Errorf("fetching package %q: %w", pkg.PackageName(), err)
-	}
-	defer rc.Close()
-
-	exp, err := expandapk.ExpandApk(ctx, rc, cacheDir)
+	exp, err := a.fetchAndExpand(ctx, pkg, cacheDir)
 	if err != nil {
-		return nil, fmt.Errorf("expanding %s: %w", pkg.PackageName(), err)
+		return nil, err
 	}
 
 	// If we don't have a cache, we're done.
@@ -1358,6 +1472,10 @@ func packageAsURI(pkg LocatablePackage) (uri.URI, error) {
 }
 
 func packageAsURL(pkg LocatablePackage) (*url.URL, error) {
+	if f, u, err := fetcherFor(pkg.URL()); f != nil || err != nil {
+		return u, err
+	}
+
 	asURI, err := packageAsURI(pkg)
 	if err != nil {
 		return nil, err
@@ -1375,6 +1493,16 @@ func (a *APK) FetchPackage(ctx context.Context, pkg FetchablePackage) (io.ReadCl
 
 	u := pkg.URL()
 
+	if f, asURL, err := fetcherFor(u); err != nil {
+		return nil, err
+	} else if f != nil {
+		rc, err := f.Fetch(ctx, asURL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get package apk at %s: %w", asURL.Redacted(), err)
+		}
+		return rc, nil
+	}
+
 	// Normalize the repo as a URI, so that local paths
 	// are translated into file:// URLs, allowing them to be parsed
 	// into a url.URL{}.
@@ -1419,6 +1547,18 @@ func (a *APK) FetchPackage(ctx context.Context, pkg FetchablePackage) (io.ReadCl
 	}
 }
 
+// CachePackage fetches and expands pkg into the configured cache directory
+// without installing it. Packages that are already cached are not fetched again.
+func (a *APK) CachePackage(ctx context.Context, pkg InstallablePackage) error {
+	if a.cache == nil {
+		return fmt.Errorf("caching %s: no cache directory configured", pkg.PackageName())
+	}
+	if _, err := a.expandPackage(ctx, pkg); err != nil {
+		return fmt.Errorf("caching %s: %w", pkg.PackageName(), err)
+	}
+	return nil
+}
+
 type WriteHeaderer interface {
 	WriteHeader(hdr tar.Header, tfs fs.FS, pkg *Package) (bool, error)
 }
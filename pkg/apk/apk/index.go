@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -27,6 +28,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strings"
@@ -40,6 +42,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"chainguard.dev/apko/pkg/apk/auth"
+	"chainguard.dev/apko/pkg/apk/expandapk"
 	sign "chainguard.dev/apko/pkg/apk/signature"
 )
 
@@ -109,6 +112,26 @@ func (i *indexCache) get(ctx context.Context, repoName, repoURL string, keys map
 	repoBase := fmt.Sprintf("%s/%s", repoURL, arch)
 	repoRef := Repository{URI: repoBase}
 
+	if f, asURL, err := fetcherFor(u); err != nil {
+		return nil, err
+	} else if f != nil {
+		// Like remote indexes over HTTP, these are fetched once per run.
+		once, _ := i.onces.LoadOrStore(u, &sync.Once{})
+		once.(*sync.Once).Do(func() {
+			var idx NamedIndex
+			b, err := fetchAll(ctx, f, asURL)
+			if err != nil {
+				err = fmt.Errorf("fetching %s: %w", asURL.Redacted(), err)
+			} else if parsed, perr := parseRepositoryIndex(ctx, u, keys, arch, b, opts); perr != nil {
+				err = fmt.Errorf("parsing %s: %w", asURL.Redacted(), perr)
+			} else {
+				idx = NewNamedRepositoryWithIndex(repoName, repoRef.WithIndex(parsed))
+			}
+			i.store(u, idx, err)
+		})
+		return i.load(u)
+	}
+
 	if strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://") {
 		asURL, err := url.Parse(u)
 		if err != nil {
@@ -188,20 +211,39 @@ func (i *indexCache) get(ctx context.Context, repoName, repoURL string, keys map
 		defer i.Unlock()
 
 		// We do expect local indexes to change, so we check modtimes.
+		var (
+			mod   time.Time
+			parse func() (*APKIndex, error)
+		)
 		stat, err := os.Stat(u)
-		if err != nil {
+		switch {
+		case err == nil:
+			mod = stat.ModTime()
+			parse = func() (*APKIndex, error) {
+				b, err := os.ReadFile(u)
+				if err != nil {
+					return nil, fmt.Errorf("reading file: %w", err)
+				}
+				return parseRepositoryIndex(ctx, u, keys, arch, b, opts)
+			}
+		case errors.Is(err, fs.ErrNotExist):
+			// Without an index, a directory of packages is indexed as it is.
+			apks, latest, lerr := localPackages(repoBase)
+			if lerr != nil || len(apks) == 0 {
+				return nil, fmt.Errorf("stat: %w", err)
+			}
+			mod = latest
+			parse = func() (*APKIndex, error) {
+				return indexLocalPackages(ctx, u, apks, keys, arch, opts)
+			}
+		default:
 			return nil, fmt.Errorf("stat: %w", err)
 		}
 
-		mod := stat.ModTime()
 		before, ok := i.modtimes[u]
 		if !ok || mod.After(before) {
-			b, err := os.ReadFile(u)
-			if err != nil {
-				return nil, fmt.Errorf("reading file: %w", err)
-			}
 			// If this is the first time or it has changed since the last time...
-			idx, err := parseRepositoryIndex(ctx, u, keys, arch, b, opts)
+			idx, err := parse()
 			if err != nil {
 				i.store(u, nil, err)
 			} else {
@@ -214,6 +256,87 @@ func (i *indexCache) get(ctx context.Context, repoName, repoURL string, keys map
 	}
 }
 
+// localPackages lists the .apk files in dir, along with the latest
+// modification time of dir and those files, so that adding, removing or
+// rebuilding a package is noticed.
+func localPackages(dir string) ([]string, time.Time, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	latest := stat.ModTime()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var apks []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), ".apk") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		apks = append(apks, filepath.Join(dir, e.Name()))
+	}
+	return apks, latest, nil
+}
+
+// indexLocalPackages builds an index from the packages in a local repository
+// that has no APKINDEX. Unless signatures are ignored for the repository,
+// every package must be signed by one of keys.
+func indexLocalPackages(ctx context.Context, u string, apks []string, keys map[string][]byte, arch string, opts *indexOpts) (*APKIndex, error) {
+	ctx, span := otel.Tracer("go-apk").Start(ctx, "indexLocalPackages")
+	defer span.End()
+
+	log := clog.FromContext(ctx)
+	checkSignatures := shouldCheckSignatureForIndex(u, arch, opts)
+
+	index := &APKIndex{}
+	for _, path := range apks {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading package: %w", err)
+		}
+		if checkSignatures {
+			parts, err := expandapk.Split(bytes.NewReader(b))
+			if err != nil {
+				return nil, fmt.Errorf("splitting %s: %w", path, err)
+			}
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("package %s is not signed", path)
+			}
+			sig, err := io.ReadAll(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			control, err := io.ReadAll(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			// The signature covers the control section only.
+			if err := verifySignatures(ctx, append(sig, control...), keys, "package "+path); err != nil {
+				return nil, err
+			}
+		}
+		pkg, err := ParsePackage(ctx, bytes.NewReader(b), uint64(len(b)))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		// Packages are fetched by their canonical file name.
+		if filepath.Base(path) != pkg.Filename() {
+			log.Warnf("skipping %s: expected it to be named %s", path, pkg.Filename())
+			continue
+		}
+		index.Packages = append(index.Packages, pkg)
+	}
+	return index, nil
+}
+
 // IndexURL returns the full URL to the index file for the given repo and arch.
 //
 // `repo` is the URL of the repository including the protocol, e.g.
@@ -256,6 +379,8 @@ func GetRepositoryIndexes(ctx context.Context, repos []string, keys map[string][
 				repoName = parts[0][1:]
 				repoURL = parts[1]
 			}
+			// Local repositories may be given as file:// URLs.
+			repoURL = strings.TrimPrefix(repoURL, "file://")
 
 			index, err := globalIndexCache.get(ctx, repoName, repoURL, keys, arch, opts)
 			if err != nil {
@@ -290,7 +415,7 @@ func shouldCheckSignatureForIndex(index string, arch string, opts *indexOpts) bo
 		return false
 	}
 	for _, ignoredIndex := range opts.noSignatureIndexes {
-		if IndexURL(ignoredIndex, arch) == index {
+		if IndexURL(strings.TrimPrefix(ignoredIndex, "file://"), arch) == index {
 			return false
 		}
 	}
@@ -344,125 +469,136 @@ func parseRepositoryIndex(ctx context.Context, u string, keys map[string][]byte,
 	defer span.End()
 	// validate the signature
 	if shouldCheckSignatureForIndex(u, arch, opts) {
-		if len(keys) == 0 {
-			return nil, fmt.Errorf("no keys provided to verify signature")
+		if err := verifySignatures(ctx, b, keys, "repository index"); err != nil {
+			return nil, err
 		}
-		// check that they key name aren't paths or URLs
-		for keyName := range keys {
-			if strings.Contains(keyName, "/") {
-				return nil, fmt.Errorf("invalid keyname %q", keyName)
-			}
+	}
+	// with a valid signature, convert it to an ApkIndex
+	index, err := IndexFromArchive(io.NopCloser(bytes.NewReader(b)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read convert repository index bytes to index struct: %w", err)
+	}
+	index.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+
+	return index, err
+}
+
+// verifySignatures checks that b, a gzipped tar stream of signatures followed
+// by the gzip streams they sign, as in an APKINDEX archive or the signature
+// and control sections of a package, carries a valid signature by one of
+// keys. what names b in errors.
+func verifySignatures(ctx context.Context, b []byte, keys map[string][]byte, what string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys provided to verify signature")
+	}
+	// check that they key name aren't paths or URLs
+	for keyName := range keys {
+		if strings.Contains(keyName, "/") {
+			return fmt.Errorf("invalid keyname %q", keyName)
 		}
-		buf := bytes.NewReader(b)
-		gzipReader, err := gzip.NewReader(buf)
+	}
+	buf := bytes.NewReader(b)
+	gzipReader, err := gzip.NewReader(buf)
+	if err != nil {
+		return fmt.Errorf("unable to create gzip reader for %s: %w", what, err)
+	}
+	// set multistream to false, so we can read each part separately;
+	// the first part is the signature, the rest is what it signs.
+	gzipReader.Multistream(false)
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+
+	sigs := make([]Signature, 0, len(keys))
+
+	for {
+		// read the signature(s)
+		signatureFile, err := tarReader.Next()
+		// found everything, end of stream
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		// oops something went wrong
 		if err != nil {
-			return nil, fmt.Errorf("unable to create gzip reader for repository index: %w", err)
+			return fmt.Errorf("unexpected error reading from tgz: %w", err)
 		}
-		// set multistream to false, so we can read each part separately;
-		// the first part is the signature, the second is the index, which should be
-		// verified.
-		gzipReader.Multistream(false)
-		defer gzipReader.Close()
-
-		tarReader := tar.NewReader(gzipReader)
-
-		sigs := make([]Signature, 0, len(keys))
-
-		for {
-			// read the signature(s)
-			signatureFile, err := tarReader.Next()
-			// found everything, end of stream
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			// oops something went wrong
-			if err != nil {
-				return nil, fmt.Errorf("unexpected error reading from tgz: %w", err)
-			}
-			matches := signatureFileRegex.FindStringSubmatch(signatureFile.Name)
-			if len(matches) != 3 {
-				return nil, fmt.Errorf("failed to find key name in signature file name: %s", signatureFile.Name)
-			}
-			keyfile := matches[2]
-
-			trimmedKeyFile := strings.TrimSuffix(keyfile, ".rsa.pub")
-			if _, ok := keys[keyfile]; ok {
-				// We found a matching key
-			} else if _, ok := keys[trimmedKeyFile]; ok {
-				// When we download keys from proxy servers - like artifactory, we ignore the 'content-disposition' header
-				// (that would be difficult to cache as well), and the header is responsible for providing key name with
-				// proper extension. Here we accept matching keys without proper extension.
-				keyfile = trimmedKeyFile
-			} else {
-				clog.FromContext(ctx).Warnf("skipping signature %s due to missing keyfile: %s", signatureFile.Name, keyfile)
-				// Ignore this signature if we don't have the key
-				continue
-			}
-			var digestAlgorithm crypto.Hash
-			switch signatureType := matches[1]; signatureType {
-			case "DSA":
-				// Obsolete
-				continue
-			case "RSA":
-				// Current legacy compat
-				digestAlgorithm = crypto.SHA1
-			case "RSA256":
-				// Current best practice
-				digestAlgorithm = crypto.SHA256
-			case "RSA512":
-				// Too big, too slow, not compiled in
-				continue
-			default:
-				return nil, fmt.Errorf("unknown signature format: %s", signatureType)
-			}
-			signature, err := io.ReadAll(tarReader)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read signature from repository index: %w", err)
-			}
-			sigs = append(sigs, Signature{
-				KeyID:           keyfile,
-				Signature:       signature,
-				DigestAlgorithm: digestAlgorithm,
-			})
+		matches := signatureFileRegex.FindStringSubmatch(signatureFile.Name)
+		if len(matches) != 3 {
+			return fmt.Errorf("failed to find key name in signature file name: %s", signatureFile.Name)
 		}
-		if len(sigs) == 0 {
-			return nil, fmt.Errorf("no signature with known key (one of: %v) found in repository index", slices.Collect(maps.Keys(keys)))
+		keyfile := matches[2]
+
+		trimmedKeyFile := strings.TrimSuffix(keyfile, ".rsa.pub")
+		if _, ok := keys[keyfile]; ok {
+			// We found a matching key
+		} else if _, ok := keys[trimmedKeyFile]; ok {
+			// When we download keys from proxy servers - like artifactory, we ignore the 'content-disposition' header
+			// (that would be difficult to cache as well), and the header is responsible for providing key name with
+			// proper extension. Here we accept matching keys without proper extension.
+			keyfile = trimmedKeyFile
+		} else {
+			clog.FromContext(ctx).Warnf("skipping signature %s due to missing keyfile: %s", signatureFile.Name, keyfile)
+			// Ignore this signature if we don't have the key
+			continue
 		}
-		// we now have the signature bytes and name, get the contents of the rest;
-		// this should be everything else in the raw gzip file as is.
-		allBytes := len(b)
-		unreadBytes := buf.Len()
-		readBytes := allBytes - unreadBytes
-		indexData := b[readBytes:]
-		indexDigest := make(map[crypto.Hash][]byte, len(keys))
-		verified := false
-		for _, sig := range sigs {
-			// compute the digest if not already done
-			if _, hasDigest := indexDigest[sig.DigestAlgorithm]; !hasDigest {
-				h := sig.DigestAlgorithm.New()
-				if n, err := h.Write(indexData); err != nil || n != len(indexData) {
-					return nil, fmt.Errorf("unable to hash data: %w", err)
-				}
-				indexDigest[sig.DigestAlgorithm] = h.Sum(nil)
-			}
-			if err := sign.RSAVerifyDigest(indexDigest[sig.DigestAlgorithm], sig.DigestAlgorithm, sig.Signature, keys[sig.KeyID]); err == nil {
-				verified = true
-				break
-			} else {
-				clog.FromContext(ctx).Warnf("failed to verify signature for keyfile %s: %v", sig.KeyID, err)
+		var digestAlgorithm crypto.Hash
+		switch signatureType := matches[1]; signatureType {
+		case "DSA":
+			// Obsolete
+			continue
+		case "RSA":
+			// Current legacy compat
+			digestAlgorithm = crypto.SHA1
+		case "RSA256":
+			// Current best practice
+			digestAlgorithm = crypto.SHA256
+		case "RSA512":
+			// Too big, too slow, not compiled in
+			continue
+		default:
+			return fmt.Errorf("unknown signature format: %s", signatureType)
+		}
+		signature, err := io.ReadAll(tarReader)
+		if err != nil {
+			return fmt.Errorf("failed to read signature from %s: %w", what, err)
+		}
+		sigs = append(sigs, Signature{
+			KeyID:           keyfile,
+			Signature:       signature,
+			DigestAlgorithm: digestAlgorithm,
+		})
+	}
+	if len(sigs) == 0 {
+		return fmt.Errorf("no signature with known key (one of: %v) found in %s", slices.Collect(maps.Keys(keys)), what)
+	}
+	// we now have the signature bytes and name, get the contents of the rest;
+	// this should be everything else in the raw gzip file as is.
+	allBytes := len(b)
+	unreadBytes := buf.Len()
+	readBytes := allBytes - unreadBytes
+	signed := b[readBytes:]
+	digests := make(map[crypto.Hash][]byte, len(keys))
+	verified := false
+	for _, sig := range sigs {
+		// compute the digest if not already done
+		if _, hasDigest := digests[sig.DigestAlgorithm]; !hasDigest {
+			h := sig.DigestAlgorithm.New()
+			if n, err := h.Write(signed); err != nil || n != len(signed) {
+				return fmt.Errorf("unable to hash data: %w", err)
 			}
+			digests[sig.DigestAlgorithm] = h.Sum(nil)
 		}
-		if !verified {
-			return nil, errors.New("signature verification failed for repository index, for all provided keys")
+		if err := sign.RSAVerifyDigest(digests[sig.DigestAlgorithm], sig.DigestAlgorithm, sig.Signature, keys[sig.KeyID]); err == nil {
+			verified = true
+			break
+		} else {
+			clog.FromContext(ctx).Warnf("failed to verify signature for keyfile %s: %v", sig.KeyID, err)
 		}
 	}
-	// with a valid signature, convert it to an ApkIndex
-	index, err := IndexFromArchive(io.NopCloser(bytes.NewReader(b)))
-	if err != nil {
-		return nil, fmt.Errorf("unable to read convert repository index bytes to index struct: %w", err)
+	if !verified {
+		return fmt.Errorf("signature verification failed for %s, for all provided keys", what)
 	}
-
-	return index, err
+	return nil
 }
 
 type indexOpts struct {
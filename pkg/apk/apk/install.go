@@ -195,6 +195,7 @@ func (a *APK) installAPKFiles(ctx context.Context, in io.Reader, pkg *Package) (
 	//  * considered to start the data section of the file.
 	//  * This does not make any sense if the file has v2.0
 	//  * style .PKGINFO
+	filter := a.newFileFilter(pkg)
 	var startedDataSection bool
 	tr := tar.NewReader(in)
 	for {
@@ -213,6 +214,10 @@ func (a *APK) installAPKFiles(ctx context.Context, in io.Reader, pkg *Package) (
 		// whatever it is now, it is in the data section
 		startedDataSection = true
 
+		if filter.skip(header) {
+			continue
+		}
+
 		switch header.Typeflag {
 		case tar.TypeDir:
 			// special case, if the target already exists, and it is a symlink to a directory, we can accept it as is
@@ -318,6 +323,7 @@ func (a *APK) lazilyInstallAPKFiles(ctx context.Context, wh WriteHeaderer, tf *t
 	entries := tf.Entries()
 	files := make([]tar.Header, 0, len(entries))
 
+	filter := a.newFileFilter(pkg)
 	var startedDataSection bool
 	for _, file := range entries {
 		// per https://git.alpinelinux.org/apk-tools/tree/src/extract_v2.c?id=337734941831dae9a6aa441e38611c43a5fd72c0#n120
@@ -331,6 +337,10 @@ func (a *APK) lazilyInstallAPKFiles(ctx context.Context, wh WriteHeaderer, tf *t
 		// whatever it is now, it is in the data section
 		startedDataSection = true
 
+		if filter.skip(&file.Header) {
+			continue
+		}
+
 		installed, err := wh.WriteHeader(file.Header, tf, pkg)
 		if err != nil {
 			return nil, err
@@ -296,6 +296,48 @@ func TestInstallAPKFiles(t *testing.T) {
 			checkDuplicateIDBEntries(t, apk)
 		})
 	})
+	t.Run("file filters", func(t *testing.T) {
+		apk, src, err := testGetTestAPK()
+		require.NoErrorf(t, err, "failed to get test APK")
+		apk.fileFilters = map[string]FileFilter{
+			"openjdk": {Exclude: []string{"**/src.zip", "usr/lib/jvm/*/demo"}},
+		}
+
+		entries := []testDirEntry{
+			{"usr", 0o755, true, nil, nil},
+			{"usr/lib", 0o755, true, nil, nil},
+			{"usr/lib/jvm", 0o755, true, nil, nil},
+			{"usr/lib/jvm/java-17", 0o755, true, nil, nil},
+			{"usr/lib/jvm/java-17/demo", 0o755, true, nil, nil},
+			{"usr/lib/jvm/java-17/lib", 0o755, true, nil, nil},
+
+			{"usr/lib/jvm/java-17/demo/Demo.java", 0o644, false, []byte("demo"), nil},
+			{"usr/lib/jvm/java-17/lib/src.zip", 0o644, false, []byte("sources"), nil},
+			{"usr/lib/jvm/java-17/lib/modules", 0o644, false, []byte("modules"), nil},
+		}
+
+		headers, err := apk.installAPKFiles(context.Background(), testCreateTarForPackage(entries), &Package{Name: "openjdk"})
+		require.NoError(t, err)
+
+		var names []string
+		for _, h := range headers {
+			names = append(names, h.Name)
+		}
+		require.NotContains(t, names, "usr/lib/jvm/java-17/demo")
+		require.NotContains(t, names, "usr/lib/jvm/java-17/demo/Demo.java")
+		require.NotContains(t, names, "usr/lib/jvm/java-17/lib/src.zip")
+		require.Contains(t, names, "usr/lib/jvm/java-17/lib/modules")
+
+		_, err = src.Stat("usr/lib/jvm/java-17/lib/src.zip")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = src.Stat("usr/lib/jvm/java-17/lib/modules")
+		require.NoError(t, err)
+
+		// Other packages are not filtered.
+		headers, err = apk.installAPKFiles(context.Background(), testCreateTarForPackage(entries[:8]), &Package{Name: "other"})
+		require.NoError(t, err)
+		require.Len(t, headers, 8)
+	})
 }
 
 func checkDuplicateIDBEntries(t *testing.T, apk *APK) {
@@ -120,6 +120,21 @@ func (a *APK) isInstalledPackage(pkg string) (bool, error) {
 	return false, nil
 }
 
+// CheckConflicts returns an error if any of the conflicts ResolveWorld
+// returned is already installed.
+func (a *APK) CheckConflicts(conflicts []string) error {
+	for _, pkg := range conflicts {
+		isInstalled, err := a.isInstalledPackage(pkg)
+		if err != nil {
+			return fmt.Errorf("error checking if package %s is installed: %w", pkg, err)
+		}
+		if isInstalled {
+			return fmt.Errorf("cannot install due to conflict with %s", pkg)
+		}
+	}
+	return nil
+}
+
 // updateScriptsTar insert the scripts into the tarball
 func (a *APK) updateScriptsTar(pkg *Package, controlTarGz io.Reader, sourceDateEpoch *time.Time) error {
 	gz, err := gzip.NewReader(controlTarGz)
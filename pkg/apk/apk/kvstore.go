@@ -0,0 +1,198 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// ErrKeyNotFound is returned by KVStore.Get for keys that were never put.
+var ErrKeyNotFound = errors.New("key not found")
+
+// KVStore persists the metadata of the cache: which etag each URL was last
+// fetched with. Without one, offline builds guess from the modification
+// times of the cached files, and online builds fail when a repository is
+// unreachable.
+type KVStore interface {
+	// Get returns the value put under key, or ErrKeyNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores value under key, replacing any previous value.
+	Put(ctx context.Context, key string, value []byte) error
+}
+
+// cacheEntry is what the cache records in its KVStore for each URL, keyed
+// by storeKey. Its contents are found from the etag, under whichever cache
+// directory has them, so the store can be shared by caches in different
+// places.
+type cacheEntry struct {
+	URL     string    `json:"url"`
+	ETag    string    `json:"etag"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// storeKey returns the key the cache entry of u is stored under: the URL
+// without credentials, query or fragment.
+func storeKey(u url.URL) string {
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// FileStore is a KVStore keeping each value in its own file in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore in dir, creating it if need be.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating store directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:]))
+}
+
+// Get implements KVStore.
+func (s *FileStore) Get(_ context.Context, key string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	return b, err
+}
+
+// Put implements KVStore. Values are replaced atomically.
+func (s *FileStore) Put(_ context.Context, key string, value []byte) error {
+	tmp, err := os.CreateTemp(s.dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+// SQLStore is a KVStore in a table of a SQL database, e.g. SQLite, so that
+// the cache metadata can be queried with standard tooling. The database
+// driver is left to the caller to import.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore returns a SQLStore in db, creating its apk_cache table if it
+// does not exist.
+func NewSQLStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS apk_cache (key TEXT PRIMARY KEY, value BLOB NOT NULL)`); err != nil {
+		return nil, fmt.Errorf("creating apk_cache table: %w", err)
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// Get implements KVStore.
+func (s *SQLStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM apk_cache WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	return value, err
+}
+
+// Put implements KVStore.
+func (s *SQLStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO apk_cache (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+// recordEntry notes in the store that u was last fetched with the given
+// etag. Failures only cost the fallback lookup, so they are not returned.
+func (t *cacheTransport) recordEntry(ctx context.Context, u url.URL, etag string) {
+	if t.store == nil {
+		return
+	}
+	now := t.now
+	if now == nil {
+		now = time.Now
+	}
+	key := storeKey(u)
+	b, err := json.Marshal(cacheEntry{URL: key, ETag: etag, Fetched: now().UTC()})
+	if err == nil {
+		err = t.store.Put(ctx, key, b)
+	}
+	if err != nil {
+		clog.FromContext(ctx).Warnf("recording cache metadata for %s: %v", key, err)
+	}
+}
+
+// lookupEntry returns the cached file the store says u was last fetched as,
+// if it is still in the cache or the lower cache.
+func (t *cacheTransport) lookupEntry(ctx context.Context, u url.URL) (string, bool) {
+	if t.store == nil {
+		return "", false
+	}
+	b, err := t.store.Get(ctx, storeKey(u))
+	if err != nil {
+		if !errors.Is(err, ErrKeyNotFound) {
+			clog.FromContext(ctx).Warnf("looking up cache metadata for %s: %v", storeKey(u), err)
+		}
+		return "", false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return "", false
+	}
+	for _, root := range []string{t.root, t.lower} {
+		if root == "" {
+			continue
+		}
+		cacheFile, err := cachePathFromURL(root, u)
+		if err != nil {
+			continue
+		}
+		file, err := cacheFileFromEtag(cacheFile, e.ETag)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			return file, true
+		}
+	}
+	return "", false
+}
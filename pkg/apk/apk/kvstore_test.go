@@ -0,0 +1,213 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// mapDriver is a database/sql driver backed by a map, understanding just
+// the statements SQLStore makes.
+type mapDriver struct {
+	mu   sync.Mutex
+	rows map[string][]byte
+}
+
+func (d *mapDriver) Open(string) (driver.Conn, error) { return mapConn{d}, nil }
+
+type mapConn struct{ d *mapDriver }
+
+func (c mapConn) Prepare(query string) (driver.Stmt, error) { return mapStmt{c.d, query}, nil }
+func (mapConn) Close() error                                { return nil }
+func (mapConn) Begin() (driver.Tx, error)                   { return nil, driver.ErrSkip }
+
+type mapStmt struct {
+	d     *mapDriver
+	query string
+}
+
+func (mapStmt) Close() error  { return nil }
+func (mapStmt) NumInput() int { return -1 }
+
+func (s mapStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if strings.HasPrefix(s.query, "INSERT") {
+		s.d.rows[args[0].(string)] = args[1].([]byte)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s mapStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	v, ok := s.d.rows[args[0].(string)]
+	return &mapRows{value: v, done: !ok}, nil
+}
+
+type mapRows struct {
+	value []byte
+	done  bool
+}
+
+func (*mapRows) Columns() []string { return []string{"value"} }
+func (*mapRows) Close() error      { return nil }
+func (r *mapRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+func init() {
+	sql.Register("apk-map", &mapDriver{rows: map[string][]byte{}})
+}
+
+func TestKVStores(t *testing.T) {
+	ctx := context.Background()
+
+	fileStore, err := NewFileStore(filepath.Join(t.TempDir(), "store"))
+	require.NoError(t, err)
+
+	db, err := sql.Open("apk-map", "")
+	require.NoError(t, err)
+	defer db.Close()
+	sqlStore, err := NewSQLStore(ctx, db)
+	require.NoError(t, err)
+
+	for name, store := range map[string]KVStore{"file": fileStore, "sql": sqlStore} {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Get(ctx, "missing")
+			require.ErrorIs(t, err, ErrKeyNotFound)
+
+			require.NoError(t, store.Put(ctx, "https://example.com/a", []byte("one")))
+			require.NoError(t, store.Put(ctx, "https://example.com/a", []byte("two")))
+			got, err := store.Get(ctx, "https://example.com/a")
+			require.NoError(t, err)
+			require.Equal(t, "two", string(got))
+		})
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("index v1"))
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/os/x86_64/APKINDEX.tar.gz", nil)
+	require.NoError(t, err)
+
+	fetched := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	online, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(dir, false, NewCache(false)), WithCacheStore(store), WithClock(func() time.Time { return fetched }))
+	require.NoError(t, err)
+	resp, err := online.cache.client(&http.Client{}, true).Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	b, err := store.Get(ctx, req.URL.String())
+	require.NoError(t, err)
+	var entry cacheEntry
+	require.NoError(t, json.Unmarshal(b, &entry))
+	require.Equal(t, req.URL.String(), entry.URL)
+	require.Equal(t, fetched, entry.Fetched)
+	cacheFile, err := cachePathFromURL(online.cache.dir, *req.URL)
+	require.NoError(t, err)
+	file, err := cacheFileFromEtag(cacheFile, entry.ETag)
+	require.NoError(t, err)
+	require.FileExists(t, file)
+
+	// A newer file in the cache would win if the offline lookup went by
+	// modification time, but the store knows better.
+	newer := filepath.Join(filepath.Dir(file), "newer.tar.gz")
+	require.NoError(t, os.WriteFile(newer, []byte("something else"), 0o644))
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(newer, future, future))
+
+	offline, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(dir, true, NewCache(false)), WithCacheStore(store))
+	require.NoError(t, err)
+	resp, err = offline.cache.client(nil, true).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "index v1", string(got))
+}
+
+func TestCacheStoreUnreachable(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("index v1"))
+	}))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/os/x86_64/APKINDEX.tar.gz", nil)
+	require.NoError(t, err)
+
+	// The store lives apart from the cache directories, so a cache moved
+	// elsewhere still finds its entries.
+	dir := t.TempDir()
+	a, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(dir, false, NewCache(false)), WithCacheStore(store))
+	require.NoError(t, err)
+	resp, err := a.cache.client(&http.Client{}, true).Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	moved := filepath.Join(t.TempDir(), "moved")
+	require.NoError(t, os.Rename(a.cache.dir, moved))
+
+	srv.Close()
+
+	b, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(moved, false, NewCache(false)), WithCacheStore(store))
+	require.NoError(t, err)
+	resp, err = b.cache.client(&http.Client{}, true).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "index v1", string(got))
+
+	// Without the store, the unreachable repository fails the fetch.
+	c, err := New(ctx, WithFS(apkfs.NewMemFS()), WithCache(moved, false, NewCache(false)))
+	require.NoError(t, err)
+	_, err = c.cache.client(&http.Client{}, true).Do(req)
+	require.Error(t, err)
+}
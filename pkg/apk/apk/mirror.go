@@ -0,0 +1,210 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mirrorHealthTTL is how long a repository or mirror that failed to respond
+// is tried last, after the ones that have not failed recently.
+const mirrorHealthTTL = 5 * time.Minute
+
+// mirrorHealth tracks which repositories and mirrors failed recently. It is
+// kept in the Cache of a build, so that its architectures learn about an
+// outage only once, without one build affecting another.
+type mirrorHealth struct {
+	sync.Mutex
+	// base URL -> time it last failed
+	down map[string]time.Time
+}
+
+func newMirrorHealth() *mirrorHealth {
+	return &mirrorHealth{down: map[string]time.Time{}}
+}
+
+func (h *mirrorHealth) healthy(base string, now time.Time) bool {
+	h.Lock()
+	defer h.Unlock()
+	t, ok := h.down[base]
+	return !ok || now.Sub(t) >= mirrorHealthTTL
+}
+
+func (h *mirrorHealth) markDown(base string, now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	h.down[base] = now
+}
+
+func (h *mirrorHealth) markUp(base string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.down, base)
+}
+
+// mirrorTransport retries requests for a repository against its mirrors, in
+// order, when the repository cannot be reached, responds with a server error,
+// or does not have what was asked for. This covers both index and package
+// fetches, since both are addressed relative to the repository URL.
+type mirrorTransport struct {
+	inner http.RoundTripper
+	// repository URL -> mirror URLs, without trailing slashes
+	mirrors map[string][]string
+	health  *mirrorHealth
+	now     func() time.Time
+}
+
+func newMirrorTransport(inner http.RoundTripper, mirrors map[string][]string, health *mirrorHealth, now func() time.Time) http.RoundTripper {
+	if len(mirrors) == 0 {
+		return inner
+	}
+	if health == nil {
+		health = newMirrorHealth()
+	}
+	return &mirrorTransport{
+		inner:   inner,
+		mirrors: trimMirrors(mirrors),
+		health:  health,
+		now:     now,
+	}
+}
+
+// failover reports whether a response with status is retried against the
+// next mirror. Server errors also mark the server down; a missing file, such
+// as a package a lagging repository does not have yet, does not.
+func failover(status int) (retry, down bool) {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return true, true
+	case status == http.StatusNotFound, status == http.StatusGone, status == http.StatusForbidden:
+		return true, false
+	}
+	return false, false
+}
+
+// trimMirrors strips the trailing slashes from the repository and mirror
+// URLs of mirrors.
+func trimMirrors(mirrors map[string][]string) map[string][]string {
+	if len(mirrors) == 0 {
+		return nil
+	}
+	m := make(map[string][]string, len(mirrors))
+	for repo, urls := range mirrors {
+		trimmed := make([]string, 0, len(urls))
+		for _, u := range urls {
+			trimmed = append(trimmed, strings.TrimRight(u, "/"))
+		}
+		m[strings.TrimRight(repo, "/")] = trimmed
+	}
+	return m
+}
+
+// match returns the longest repository URL that prefixes u, and the rest of
+// u relative to it.
+func (t *mirrorTransport) match(u string) (repo, rest string, ok bool) {
+	return matchRepository(t.mirrors, u)
+}
+
+func matchRepository(mirrors map[string][]string, u string) (repo, rest string, ok bool) {
+	for r := range mirrors {
+		if len(r) <= len(repo) {
+			continue
+		}
+		if u == r || strings.HasPrefix(u, r+"/") {
+			repo, rest, ok = r, u[len(r):], true
+		}
+	}
+	return repo, rest, ok
+}
+
+// candidates orders the repository and its mirrors so those that have not
+// failed recently come first, keeping the configured order otherwise.
+func (t *mirrorTransport) candidates(repo string) []string {
+	all := append([]string{repo}, t.mirrors[repo]...)
+	now := t.now()
+	var up, down []string
+	for _, base := range all {
+		if t.health.healthy(base, now) {
+			up = append(up, base)
+		} else {
+			down = append(down, base)
+		}
+	}
+	return append(up, down...)
+}
+
+func (t *mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	repo, rest, ok := t.match(req.URL.String())
+	if !ok || (req.Body != nil && req.Body != http.NoBody) {
+		return t.inner.RoundTrip(req)
+	}
+
+	bases := t.candidates(repo)
+	var errs []error
+	for i, base := range bases {
+		r, err := mirrorRequest(req, base+rest)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.inner.RoundTrip(r)
+		if err != nil {
+			t.health.markDown(base, t.now())
+			errs = append(errs, fmt.Errorf("%s: %w", r.URL.Redacted(), err))
+			continue
+		}
+		retry, down := failover(resp.StatusCode)
+		if down {
+			t.health.markDown(base, t.now())
+		} else {
+			t.health.markUp(base)
+		}
+		if !retry || i == len(bases)-1 {
+			// Let the caller see the last error response as it would have
+			// without mirrors.
+			return resp, nil
+		}
+		resp.Body.Close()
+		errs = append(errs, fmt.Errorf("%s: unexpected status code %d", r.URL.Redacted(), resp.StatusCode))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// mirrorRequest clones req to target. Credentials are only carried over to
+// the same host; a mirror on another host gets the userinfo from its own URL,
+// if any.
+func mirrorRequest(req *http.Request, target string) (*http.Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parsing mirror URL: %w", err)
+	}
+	r := req.Clone(req.Context())
+	r.URL = u
+	if u.Host != req.URL.Host {
+		r.Host = ""
+		r.Header.Del("Authorization")
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			r.SetBasicAuth(u.User.Username(), pass)
+		}
+	}
+	r.URL.User = nil
+	return r, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorTransport(t *testing.T) {
+	var primaryHits atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		primaryHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	var gotAuth string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer mirror.Close()
+
+	now := time.Now()
+	tr := newMirrorTransport(http.DefaultTransport, map[string][]string{
+		primary.URL + "/os/": {mirror.URL + "/mirror/os"},
+	}, nil, func() time.Time { return now }).(*mirrorTransport)
+
+	get := func(u string) string {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("user", "secret")
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	require.Equal(t, "/mirror/os/x86_64/APKINDEX.tar.gz", get(primary.URL+"/os/x86_64/APKINDEX.tar.gz"))
+	require.Empty(t, gotAuth, "credentials must not leak to another host")
+	require.Equal(t, int32(1), primaryHits.Load())
+
+	// The primary is known to be down, so the mirror is tried first.
+	require.Equal(t, "/mirror/os/x86_64/foo-1.0-r0.apk", get(primary.URL+"/os/x86_64/foo-1.0-r0.apk"))
+	require.Equal(t, int32(1), primaryHits.Load())
+
+	// Once the health entry expires, the primary is tried first again.
+	now = now.Add(mirrorHealthTTL)
+	get(primary.URL + "/os/x86_64/foo-1.0-r0.apk")
+	require.Equal(t, int32(2), primaryHits.Load())
+}
+
+func TestMirrorTransportUnmatched(t *testing.T) {
+	tr := newMirrorTransport(http.DefaultTransport, map[string][]string{
+		"https://packages.example/os": {"https://mirror.example/os"},
+	}, nil, time.Now).(*mirrorTransport)
+
+	_, _, ok := tr.match("https://packages.example/osx/x86_64/APKINDEX.tar.gz")
+	require.False(t, ok)
+
+	repo, rest, ok := tr.match("https://packages.example/os/x86_64/APKINDEX.tar.gz")
+	require.True(t, ok)
+	require.Equal(t, "https://packages.example/os", repo)
+	require.Equal(t, "/x86_64/APKINDEX.tar.gz", rest)
+}
+
+func TestMirrorTransportNotFound(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := path.Base(r.URL.Path); name == "new-1.0-r0.apk" || name == "gone-1.0-r0.apk" {
+			// The primary has not caught up with new-1.0-r0.apk yet.
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "primary")
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) == "gone-1.0-r0.apk" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "mirror")
+	}))
+	defer mirror.Close()
+
+	mirrors := map[string][]string{primary.URL + "/os": {mirror.URL + "/os"}}
+	health := newMirrorHealth()
+	tr := newMirrorTransport(http.DefaultTransport, mirrors, health, time.Now)
+
+	get := func(tr http.RoundTripper, name string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, primary.URL+"/os/x86_64/"+name, nil)
+		require.NoError(t, err)
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	status, body := get(tr, "new-1.0-r0.apk")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "mirror", body)
+
+	// A missing file does not make the primary look down.
+	_, body = get(tr, "old-1.0-r0.apk")
+	require.Equal(t, "primary", body)
+
+	status, _ = get(tr, "gone-1.0-r0.apk")
+	require.Equal(t, http.StatusNotFound, status)
+
+	// Outages are only remembered by the transports sharing the health.
+	health.markDown(primary.URL+"/os", time.Now())
+	_, body = get(tr, "old-1.0-r0.apk")
+	require.Equal(t, "mirror", body)
+	_, body = get(newMirrorTransport(http.DefaultTransport, mirrors, nil, time.Now), "old-1.0-r0.apk")
+	require.Equal(t, "primary", body)
+}
@@ -15,12 +15,16 @@
 package apk
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"time"
 
 	"github.com/hashicorp/go-cleanhttp"
+	"golang.org/x/time/rate"
 
 	"chainguard.dev/apko/pkg/apk/auth"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
@@ -37,10 +41,23 @@ type opts struct {
 	auth               auth.Authenticator
 	ignoreSignatures   bool
 	transport          http.RoundTripper
+	mirrors            map[string][]string
+	rateLimiter        *rate.Limiter
+	backoff            *Backoff
+	cacheNamespace     string
+	lowerCacheDir      string
+	cacheStore         KVStore
+	fileFilters        map[string]FileFilter
+	dial               *DialOptions
+	caTrust            string
+	retry              RetryPolicy
+	now                func() time.Time
 }
 
 type Option func(*opts) error
 
+var cacheNamespaceRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
 // WithExecutor executor to use. Not currently used.
 func WithExecutor(executor Executor) Option {
 	return func(o *opts) error {
@@ -82,6 +99,16 @@ func WithFS(fs apkfs.FullFS) Option {
 	}
 }
 
+// DefaultCacheDir returns the cache directory used when WithCache is given
+// none, under the user's cache directory.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dev.chainguard.go-apk"), nil
+}
+
 // WithCache sets to use a cache directory for downloaded apk files and APKINDEX files.
 // If not provided, will not cache.
 //
@@ -91,11 +118,10 @@ func WithCache(cacheDir string, offline bool, shared *Cache) Option {
 	return func(o *opts) error {
 		var err error
 		if cacheDir == "" {
-			cacheDir, err = os.UserCacheDir()
+			cacheDir, err = DefaultCacheDir()
 			if err != nil {
 				return err
 			}
-			cacheDir = filepath.Join(cacheDir, "dev.chainguard.go-apk")
 		} else {
 			cacheDir, err = filepath.Abs(cacheDir)
 			if err != nil {
@@ -144,11 +170,109 @@ func WithTransport(t http.RoundTripper) Option {
 	}
 }
 
+// WithMirrors sets, for each repository URL, mirror URLs to fall back to in
+// order when the repository cannot be reached.
+func WithMirrors(mirrors map[string][]string) Option {
+	return func(o *opts) error {
+		o.mirrors = mirrors
+		return nil
+	}
+}
+
+// WithRateLimiter throttles downloads to the rate admitted by limiter, see
+// NewRateLimiter. A nil limiter disables throttling.
+func WithRateLimiter(limiter *rate.Limiter) Option {
+	return func(o *opts) error {
+		o.rateLimiter = limiter
+		return nil
+	}
+}
+
+// WithBackoff makes requests back off from the hosts that rate limit them
+// together with those of every APK instance sharing b, see NewBackoff. A nil
+// b has each instance back off on its own.
+func WithBackoff(b *Backoff) Option {
+	return func(o *opts) error {
+		o.backoff = b
+		return nil
+	}
+}
+
+// WithCacheNamespace keeps the cache of this instance under its own
+// namespace within the cache directory, so that builds for different tenants
+// sharing a cache directory never read each other's packages and indexes.
+// It has no effect without WithCache.
+func WithCacheNamespace(namespace string) Option {
+	return func(o *opts) error {
+		if namespace != "" && !cacheNamespaceRegexp.MatchString(namespace) {
+			return fmt.Errorf("invalid cache namespace %q: must match %s", namespace, cacheNamespaceRegexp)
+		}
+		o.cacheNamespace = namespace
+		return nil
+	}
+}
+
+// WithLowerCache adds a read-only cache directory that is consulted when
+// the cache misses. Nothing is ever written to it, so it can be shared by
+// every namespace. It has no effect without WithCache.
+func WithLowerCache(dir string) Option {
+	return func(o *opts) error {
+		if dir == "" {
+			o.lowerCacheDir = ""
+			return nil
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		o.lowerCacheDir = abs
+		return nil
+	}
+}
+
+// WithCacheStore records the metadata of the cache in store, e.g. a
+// SQLStore, which offline builds then use to find the latest cached
+// indexes, and online builds to serve them while a repository is
+// unreachable. It has no effect without WithCache.
+func WithCacheStore(store KVStore) Option {
+	return func(o *opts) error {
+		o.cacheStore = store
+		return nil
+	}
+}
+
 func defaultOpts() *opts {
 	return &opts{
 		arch:              ArchToAPK(runtime.GOARCH),
 		ignoreMknodErrors: false,
 		auth:              auth.DefaultAuthenticators,
 		transport:         cleanhttp.DefaultPooledTransport(),
+		now:               time.Now,
+	}
+}
+
+// WithClock replaces the system clock, e.g. in tests, wherever the current
+// time is read: to select the signing keys valid for a release, to track the
+// health of mirrors and to record when cache entries were fetched.
+func WithClock(now func() time.Time) Option {
+	return func(o *opts) error {
+		if now != nil {
+			o.now = now
+		}
+		return nil
+	}
+}
+
+// WithFileFilters filters the files installed from packages, keyed by
+// package name. Files left out are not recorded as installed either.
+func WithFileFilters(filters map[string]FileFilter) Option {
+	return func(o *opts) error {
+		for name, f := range filters {
+			if err := f.validate(); err != nil {
+				return fmt.Errorf("file filter for %s: %w", name, err)
+			}
+		}
+		o.fileFilters = filters
+		return nil
 	}
 }
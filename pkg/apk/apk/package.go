@@ -152,6 +152,16 @@ func (p *Package) String() string {
 }
 func (p *Package) PackageName() string { return p.Name }
 
+// SourcePackage returns the origin of the package, the source package it was
+// built from along with its other subpackages, or its own name if its origin
+// is not known.
+func (p *Package) SourcePackage() string {
+	if p.Origin == "" {
+		return p.Name
+	}
+	return p.Origin
+}
+
 // Filename returns the package filename as it's named in a repository.
 func (p *Package) Filename() string {
 	// Note: Doesn't use fmt.Sprintf because we call this a lot when we disqualify images.
@@ -0,0 +1,86 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// maxRateBurst bounds how many bytes a single Read may take from the
+// limiter, so throttled downloads progress smoothly instead of in bursts.
+const maxRateBurst = 256 << 10
+
+// NewRateLimiter returns a token bucket that admits bytesPerSecond bytes per
+// second, or nil if bytesPerSecond is not positive. Share one limiter between
+// APK instances to cap their combined download rate.
+func NewRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(min(bytesPerSecond, maxRateBurst)))
+}
+
+// rateLimitedTransport throttles the bodies of responses to a shared byte rate.
+type rateLimitedTransport struct {
+	inner   http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func newRateLimitedTransport(inner http.RoundTripper, limiter *rate.Limiter) http.RoundTripper {
+	if limiter == nil {
+		return inner
+	}
+	return &rateLimitedTransport{inner: inner, limiter: limiter}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, err
+	}
+	resp.Body = &rateLimitedReader{
+		ctx:     req.Context(),
+		rc:      resp.Body,
+		limiter: t.limiter,
+	}
+	return resp, nil
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	rc      io.ReadCloser
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *rateLimitedReader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,56 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitedTransport(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 50_000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	// The first 20,000 bytes fit in the initial burst; the remaining 30,000
+	// take 1.5s at 20,000 bytes per second.
+	tr := newRateLimitedTransport(http.DefaultTransport, NewRateLimiter(20_000))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	start := time.Now()
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, body, got)
+	require.GreaterOrEqual(t, time.Since(start), 1400*time.Millisecond)
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	require.Nil(t, NewRateLimiter(0))
+	require.Equal(t, 100, NewRateLimiter(100).Burst())
+	require.Equal(t, maxRateBurst, NewRateLimiter(10<<20).Burst())
+}
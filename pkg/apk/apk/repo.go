@@ -45,6 +45,14 @@ type NamedIndex interface {
 	Packages() []*RepositoryPackage
 	Source() string
 	Count() int
+	// Digest is the digest of the APKINDEX the index was read from, if known.
+	Digest() string
+}
+
+// IndexDigest identifies the exact snapshot of a repository index.
+type IndexDigest struct {
+	URL    string `json:"url"`
+	Digest string `json:"digest"`
 }
 
 func indexNames(indexes []NamedIndex) []string {
@@ -84,6 +92,13 @@ func (n *namedRepositoryWithIndex) Packages() []*RepositoryPackage {
 	}
 	return n.repo.Packages()
 }
+func (n *namedRepositoryWithIndex) Digest() string {
+	if n.repo == nil {
+		return ""
+	}
+	return n.repo.Digest()
+}
+
 func (n *namedRepositoryWithIndex) Source() string {
 	if n.repo == nil || n.repo.IndexURI() == "" {
 		return ""
@@ -190,6 +205,25 @@ func (a *APK) GetRepositoryIndexes(ctx context.Context, ignoreSignatures bool) (
 	return GetRepositoryIndexes(ctx, repos, keys, arch, opts...)
 }
 
+// ResolvedIndexes returns the repository indexes that the last ResolveWorld
+// resolved the world against, so that the exact snapshots can be attested.
+// Credentials are redacted from their URLs.
+func (a *APK) ResolvedIndexes() []IndexDigest {
+	a.resolvedIndexesMu.Lock()
+	defer a.resolvedIndexesMu.Unlock()
+	return slices.Clone(a.resolvedIndexes)
+}
+
+func (a *APK) recordResolvedIndexes(indexes []NamedIndex) {
+	digests := make([]IndexDigest, 0, len(indexes))
+	for _, idx := range indexes {
+		digests = append(digests, IndexDigest{URL: redact(idx.Source()), Digest: idx.Digest()})
+	}
+	a.resolvedIndexesMu.Lock()
+	defer a.resolvedIndexesMu.Unlock()
+	a.resolvedIndexes = digests
+}
+
 // PkgResolver resolves packages from a list of indexes.
 // It is created with NewPkgResolver and passed a list of indexes.
 // It then can be used to resolve the correct version of a package given
@@ -316,7 +350,7 @@ func (p *PkgResolver) disqualifyProviders(constraint string, dq map[*RepositoryP
 		return
 	}
 
-	conflicting := filterPackages(providers, dq, withVersion(parsed.Version, parsed.dep), withPreferPin(parsed.pin))
+	conflicting := filterPackages(providers, dq, withName(parsed.Name), withVersion(parsed.Version, parsed.dep), withPreferPin(parsed.pin))
 
 	for _, conflict := range conflicting {
 		if _, dqed := dq[conflict.RepositoryPackage]; dqed {
@@ -466,6 +500,10 @@ func (p *PkgResolver) constrain(constraints []string, dq map[*RepositoryPackage]
 					if pp.Name != parsed.Name {
 						continue
 					}
+					if pp.Version == "" {
+						dq[provider.RepositoryPackage] = fmt.Sprintf("%q provides unversioned %q which does not satisfy %q", provider.Filename(), provides, constraint)
+						continue
+					}
 					actualVersion, err := cachedParseVersion(pp.Version)
 					// skip invalid ones
 					if err != nil {
@@ -646,7 +684,7 @@ func (p *PkgResolver) ResolvePackage(pkgName string, dq map[*RepositoryPackage]s
 
 	// pkgsWithVersions contains a map of all versions of the package
 	// get the one that most matches what was requested
-	packages := filterPackages(pkgsWithVersions, dq, withVersion(version, compare), withPreferPin(pin))
+	packages := filterPackages(pkgsWithVersions, dq, withName(name), withVersion(version, compare), withPreferPin(pin))
 	if len(packages) == 0 {
 		return nil, maybedqerror(pkgsWithVersions, dq)
 	}
@@ -673,7 +711,7 @@ func (p *PkgResolver) resolvePackage(pkgName string, dq map[*RepositoryPackage]s
 
 	// pkgsWithVersions contains a map of all versions of the package
 	// get the one that most matches what was requested
-	packages := filterPackages(pkgsWithVersions, dq, withVersion(version, compare), withPreferPin(pin))
+	packages := filterPackages(pkgsWithVersions, dq, withName(name), withVersion(version, compare), withPreferPin(pin))
 	if len(packages) == 0 {
 		return nil, maybedqerror(pkgsWithVersions, dq)
 	}
@@ -778,45 +816,24 @@ func (p *PkgResolver) getPackageDependencies(ctx context.Context, pkg *Repositor
 					continue
 				}
 
-				actualVersion, err := cachedParseVersion(picked.Version)
-				if err != nil {
-					return nil, nil, err
-				}
 				requiredVersion, err := cachedParseVersion(version)
 				if err != nil {
 					return nil, nil, err
 				}
 
-				// See if any virtual packages of packages we've already
-				// selected satisfy this constraint.
-				satisfiedByProvide := false
-				for _, provide := range picked.Provides {
-					prostraint := cachedResolvePackageNameVersionPin(provide)
-					pname, pversion, pcompare := prostraint.Name, prostraint.Version, prostraint.dep
-					if pname != name {
-						continue
-					}
-					// If this virtual package is unversioned, it can't
-					// satisfy a versioned constraint
-					if pversion == "" {
-						continue
-					}
-					prover, err := cachedParseVersion(pversion)
+				// If we selected it through a provide, e.g. so:libssl.so.3=3.1,
+				// it is the version of that provide that has to match; an
+				// unversioned provide has none to.
+				if providedVersion := p.getDepVersionForName(&repositoryPackage{RepositoryPackage: picked}, name); providedVersion != "" {
+					actualVersion, err := cachedParseVersion(providedVersion)
 					if err != nil {
 						return nil, nil, err
 					}
-					if pcompare.satisfies(prover, requiredVersion) {
-						satisfiedByProvide = true
-						break
-					}
-				}
-				if satisfiedByProvide {
-					continue
-				}
 
-				// We do care which version and they match.
-				if compare.satisfies(actualVersion, requiredVersion) {
-					continue
+					// We do care which version and they match.
+					if compare.satisfies(actualVersion, requiredVersion) {
+						continue
+					}
 				}
 
 				// We already selected something to satisfy "name" and it does not match the "version" we need now.
@@ -832,6 +849,7 @@ func (p *PkgResolver) getPackageDependencies(ctx context.Context, pkg *Repositor
 			// get the one that most matches what was requested
 			pkgs := filterPackages(depPkgWithVersions,
 				dq,
+				withName(name),
 				withVersion(version, compare),
 				withAllowPin(allowPin),
 				withInstalledPackage(existing[name]),
@@ -949,6 +967,14 @@ func (p *PkgResolver) comparePackages(compare *RepositoryPackage, name string, e
 		// determine versions
 		iVersionStr := p.getDepVersionForName(a, name)
 		jVersionStr := p.getDepVersionForName(b, name)
+		// Providers with unversioned provides are ordered by their own
+		// versions.
+		if iVersionStr == "" {
+			iVersionStr = a.Version
+		}
+		if jVersionStr == "" {
+			jVersionStr = b.Version
+		}
 		if compare != nil {
 			// matching repository
 			pkgRepo := compare.Repository().URI
@@ -1062,20 +1088,16 @@ func (p *PkgResolver) bestPackage(pkgs []*repositoryPackage, compare *Repository
 // For example, if pkg foo v2.3 provides bar=1.2, and we look for name=bar then it returns
 // 1.2 (from the provides); else it return 2.3 (from the package itself).
 //
-// Note that the calling function might decide to ignore this and use the package
-// version anyways.
+// If the provide has no version, e.g. bar, it returns "": an unversioned provide
+// never satisfies a versioned dependency, whatever the version of the package.
 func (p *PkgResolver) getDepVersionForName(pkg *repositoryPackage, name string) string {
 	if name == "" || name == pkg.Name {
 		return pkg.Version
 	}
 	for _, prov := range pkg.Provides {
 		constraint := cachedResolvePackageNameVersionPin(prov)
-		pName, pVersion := constraint.Name, constraint.Version
-		if pVersion == "" {
-			pVersion = pkg.Version
-		}
-		if pName == name {
-			return pVersion
+		if constraint.Name == name {
+			return constraint.Version
 		}
 	}
 	return ""
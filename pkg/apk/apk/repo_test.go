@@ -16,6 +16,7 @@ package apk
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base32"
 	"fmt"
 	"io/fs"
@@ -30,6 +31,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
@@ -131,6 +133,10 @@ func TestGetRepositoryIndexes(t *testing.T) {
 		indexes, err := a.GetRepositoryIndexes(context.Background(), false)
 		require.NoErrorf(t, err, "unable to get indexes")
 		require.Greater(t, len(indexes), 0, "no indexes found")
+
+		raw, err := os.ReadFile(filepath.Join(testPrimaryPkgDir, indexFilename))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(raw)), indexes[0].Digest())
 	})
 	t.Run("RSA256 signed", func(t *testing.T) {
 		a := prepLayout(t, "", nil)
@@ -306,6 +312,64 @@ func TestGetRepositoryIndexes(t *testing.T) {
 	})
 }
 
+func TestGetRepositoryIndexesLocalPackages(t *testing.T) {
+	const keyName = "alpine-devel@lists.alpinelinux.org-616ae350.rsa.pub"
+	apk, err := os.ReadFile(filepath.Join("testdata", "alpine-316", "alpine-baselayout-3.2.0-r23.apk"))
+	require.NoError(t, err)
+
+	// A repository directory with packages but no APKINDEX.
+	newRepo := func(t *testing.T) string {
+		repo := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(repo, testArch), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(repo, testArch, "alpine-baselayout-3.2.0-r23.apk"), apk, 0o644))
+		return repo
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		repo := newRepo(t)
+		indexes, err := GetRepositoryIndexes(t.Context(), []string{"file://" + repo}, map[string][]byte{keyName: []byte(testKeys[keyName])}, testArch)
+		require.NoError(t, err)
+		require.Len(t, indexes, 1)
+		pkgs := indexes[0].Packages()
+		require.Len(t, pkgs, 1)
+		require.Equal(t, "alpine-baselayout", pkgs[0].Name)
+		require.Equal(t, filepath.Join(repo, testArch, "alpine-baselayout-3.2.0-r23.apk"), pkgs[0].URL())
+	})
+	t.Run("wrong key", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := GetRepositoryIndexes(t.Context(), []string{repo}, map[string][]byte{"test-rsa256.rsa.pub": []byte(testKeys["test-rsa256.rsa.pub"])}, testArch)
+		require.ErrorContains(t, err, "no signature with known key")
+	})
+	t.Run("ignore signatures", func(t *testing.T) {
+		repo := newRepo(t)
+		indexes, err := GetRepositoryIndexes(t.Context(), []string{repo}, nil, testArch, WithIgnoreSignatures(true))
+		require.NoError(t, err)
+		require.Len(t, indexes, 1)
+		require.Len(t, indexes[0].Packages(), 1)
+	})
+	t.Run("picks up new packages", func(t *testing.T) {
+		repo := newRepo(t)
+		indexes, err := GetRepositoryIndexes(t.Context(), []string{repo}, nil, testArch, WithIgnoreSignatures(true))
+		require.NoError(t, err)
+		require.Len(t, indexes[0].Packages(), 1)
+
+		hello, err := os.ReadFile(filepath.Join("testdata", "hello-0.1.0-r0.apk"))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(repo, testArch, "hello-0.1.0-r0.apk"), hello, 0o644))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Join(repo, testArch), later, later))
+
+		indexes, err = GetRepositoryIndexes(t.Context(), []string{repo}, nil, testArch, WithIgnoreSignatures(true))
+		require.NoError(t, err)
+		require.Len(t, indexes[0].Packages(), 2)
+	})
+	t.Run("empty directory", func(t *testing.T) {
+		indexes, err := GetRepositoryIndexes(t.Context(), []string{t.TempDir()}, nil, testArch)
+		require.NoError(t, err)
+		require.Empty(t, indexes)
+	})
+}
+
 func TestIndexAuth_good(t *testing.T) {
 	called := false
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -691,7 +755,9 @@ func TestResolvePackage(t *testing.T) {
 		resolver := NewPkgResolver(context.Background(), testNamedRepositoryFromIndexes(index))
 		pkgs, err := resolver.ResolvePackage("package5>1.0.0", map[*RepositoryPackage]string{})
 		require.NoError(t, err)
-		require.Len(t, pkgs, 6)
+		// package5-special and package5-noconflict provide package5 without
+		// a version, so they do not count, whatever their own versions.
+		require.Len(t, pkgs, 4)
 		// first version should be highest match
 		require.Equal(t, "2.0.0", pkgs[0].Version)
 	})
@@ -706,6 +772,23 @@ func TestResolvePackage(t *testing.T) {
 		// first version should be highest match
 		require.Equal(t, "1", pkgs[0].Version)
 	})
+	t.Run("unversioned provide", func(t *testing.T) {
+		resolver := makeResolver(map[string][]string{
+			"libbar=2.0-r0":     {"so:libbar.so.2"},
+			"libbar-compat=1.5": {"so:libbar.so.2=1.5"},
+		}, nil)
+
+		// The unversioned provide does not get the version of libbar...
+		pkgs, err := resolver.ResolvePackage("so:libbar.so.2>=1.0", map[*RepositoryPackage]string{})
+		require.NoError(t, err)
+		require.Len(t, pkgs, 1)
+		require.Equal(t, "libbar-compat", pkgs[0].Name)
+
+		// ...but satisfies an unversioned constraint.
+		pkgs, err = resolver.ResolvePackage("so:libbar.so.2", map[*RepositoryPackage]string{})
+		require.NoError(t, err)
+		require.Len(t, pkgs, 2)
+	})
 }
 
 // Make sure that all versions exist
@@ -927,6 +1010,112 @@ func TestHigherProvidedVersion(t *testing.T) {
 	}
 }
 
+// Versioned depends on a virtual are checked against the version of the
+// matching provide, not the version of the package or of its other provides.
+func TestVersionedProvides(t *testing.T) {
+	t.Run("selects provide that satisfies constraint", func(t *testing.T) {
+		providers := map[string][]string{
+			"openssl=3.2.0-r0": {"so:libssl.so.3=3.0"},
+			"openssl=3.1.4-r0": {"so:libssl.so.3=3.1"},
+		}
+		dependers := map[string][]string{
+			"app=1.0-r0": {"so:libssl.so.3>=3.1"},
+		}
+
+		resolver := makeResolver(providers, dependers)
+		pkgs, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"app"}, nil)
+		require.NoError(t, err)
+
+		wantPkgs := []string{
+			"openssl-3.1.4-r0.apk",
+			"app-1.0-r0.apk",
+		}
+		require.Len(t, pkgs, len(wantPkgs))
+		for i, pkg := range pkgs {
+			require.Equal(t, wantPkgs[i], pkg.Filename())
+		}
+
+		// openssl-3.2.0 is newer, but its provide is not.
+		pkgs, err = resolver.ResolvePackage("so:libssl.so.3>=3.1", map[*RepositoryPackage]string{})
+		require.NoError(t, err)
+		require.Len(t, pkgs, 1)
+		require.Equal(t, "3.1.4-r0", pkgs[0].Version)
+	})
+	t.Run("unversioned provide does not satisfy constraint", func(t *testing.T) {
+		providers := map[string][]string{
+			"libbar=2.0-r0": {"so:libbar.so.2"},
+		}
+		dependers := map[string][]string{
+			"app=1.0-r0": {"so:libbar.so.2>=1.0"},
+		}
+
+		resolver := makeResolver(providers, dependers)
+		_, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"app"}, nil)
+		require.ErrorContains(t, err, `provides unversioned "so:libbar.so.2"`)
+	})
+	t.Run("package version does not satisfy provide constraint", func(t *testing.T) {
+		providers := map[string][]string{
+			"foo=2.0-r0": {"cmd:foo=1.0-r0", "bar=2.0-r0"},
+		}
+		dependers := map[string][]string{
+			"app=1.0-r0": {"cmd:foo>=1.5-r0"},
+		}
+
+		resolver := makeResolver(providers, dependers)
+		_, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"app"}, nil)
+		require.Error(t, err)
+
+		pkgs, err := resolver.ResolvePackage("cmd:foo>=1.5-r0", map[*RepositoryPackage]string{})
+		require.Error(t, err)
+		require.Empty(t, pkgs)
+
+		pkgs, err = resolver.ResolvePackage("cmd:foo<1.5-r0", map[*RepositoryPackage]string{})
+		require.NoError(t, err)
+		require.Len(t, pkgs, 1)
+		require.Equal(t, "foo", pkgs[0].Name)
+	})
+	t.Run("already selected provide satisfies constraint", func(t *testing.T) {
+		providers := map[string][]string{
+			"libfoo=1.0-r0": {"so:libfoo.so.1=1.4"},
+		}
+		dependers := map[string][]string{
+			"base=1.0-r0": {"so:libfoo.so.1"},
+			"app=1.0-r0":  {"so:libfoo.so.1>=1.2"},
+		}
+
+		resolver := makeResolver(providers, dependers)
+		pkgs, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"base", "app"}, nil)
+		require.NoError(t, err)
+		require.Len(t, pkgs, 3)
+	})
+	t.Run("already selected unversioned provide does not satisfy constraint", func(t *testing.T) {
+		providers := map[string][]string{
+			"libfoo=2.0-r0": {"so:libfoo.so.1"},
+		}
+		dependers := map[string][]string{
+			"base=1.0-r0": {"so:libfoo.so.1"},
+			"app=1.0-r0":  {"so:libfoo.so.1>=1.2"},
+		}
+
+		resolver := makeResolver(providers, dependers)
+		_, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"base", "app"}, nil)
+		require.ErrorContains(t, err, `provides unversioned "so:libfoo.so.1" which does not satisfy "so:libfoo.so.1>=1.2"`)
+	})
+	t.Run("already selected provide does not satisfy constraint", func(t *testing.T) {
+		providers := map[string][]string{
+			"libfoo=2.0-r0": {"so:libfoo.so.1=1.1"},
+		}
+		dependers := map[string][]string{
+			"base=1.0-r0": {"so:libfoo.so.1"},
+			"app=1.0-r0":  {"so:libfoo.so.1>=1.2"},
+		}
+
+		resolver := makeResolver(providers, dependers)
+		_, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"base", "app"}, nil)
+		require.ErrorContains(t, err, `provides "so:libfoo.so.1=1.1" which does not satisfy "so:libfoo.so.1>=1.2"`)
+	})
+}
+
 func TestConstrains(t *testing.T) {
 	providers := map[string][]string{
 		"ld-linux=2.38-r10": {"so:ld-linux-aarch64.so.1=1.0"},
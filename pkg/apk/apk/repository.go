@@ -65,6 +65,12 @@ func (r *RepositoryWithIndex) Count() int {
 	return len(r.index.Packages)
 }
 
+// Digest returns the digest of the APKINDEX this repository was read from,
+// if known.
+func (r *RepositoryWithIndex) Digest() string {
+	return r.index.Digest
+}
+
 // RepoAbbr returns a short name of this repository consisting of the repo name
 // and the architecture.
 func (r *RepositoryWithIndex) RepoAbbr() string {
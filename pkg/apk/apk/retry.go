@@ -0,0 +1,121 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+
+	"chainguard.dev/apko/internal/backoff"
+)
+
+// RetryPolicy controls how repository fetches that fail transiently, such as
+// indexes, keys and packages, are retried. The zero value retries connection
+// errors, 429 and 5xx responses other than 501 up to 4 times, backing off
+// exponentially from 1s to 30s, or as long as a Retry-After header asks.
+type RetryPolicy struct {
+	// Attempts is how many times a request is made, including the first.
+	// Zero uses the default of 5; one disables retries.
+	Attempts int `json:"attempts,omitempty"`
+	// MinBackoff is the wait before the first retry, which doubles for
+	// each retry after it. Zero uses the default of 1s.
+	MinBackoff time.Duration `json:"minBackoff,omitempty"`
+	// MaxBackoff caps the wait between retries. Zero uses the default of
+	// 30s.
+	MaxBackoff time.Duration `json:"maxBackoff,omitempty"`
+	// RetryOn, if set, lists the HTTP statuses that are retried, instead of
+	// 429 and 5xx other than 501. Connection errors are always retried.
+	RetryOn []int `json:"retryOn,omitempty"`
+}
+
+func (p RetryPolicy) validate() error {
+	if p.Attempts < 0 {
+		return fmt.Errorf("retry attempts must not be negative, got %d", p.Attempts)
+	}
+	if p.MinBackoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative, got %s and %s", p.MinBackoff, p.MaxBackoff)
+	}
+	if p.MinBackoff != 0 && p.MaxBackoff != 0 && p.MinBackoff > p.MaxBackoff {
+		return fmt.Errorf("minimum retry backoff %s is longer than the maximum %s", p.MinBackoff, p.MaxBackoff)
+	}
+	for _, status := range p.RetryOn {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid HTTP status %d to retry on", status)
+		}
+	}
+	return nil
+}
+
+// apply makes c retry as p asks.
+func (p RetryPolicy) apply(c *retryablehttp.Client) {
+	if p.Attempts != 0 {
+		c.RetryMax = p.Attempts - 1
+	}
+	if p.MinBackoff != 0 {
+		c.RetryWaitMin = p.MinBackoff
+	}
+	if p.MaxBackoff != 0 {
+		c.RetryWaitMax = p.MaxBackoff
+	}
+	if p.MinBackoff > c.RetryWaitMax {
+		// Only the minimum was set, above the default maximum.
+		c.RetryWaitMax = p.MinBackoff
+	}
+	if len(p.RetryOn) != 0 {
+		c.CheckRetry = p.checkRetry
+	}
+}
+
+// backoffs returns the shortest and longest waits between retries.
+func (p RetryPolicy) backoffs() (time.Duration, time.Duration) {
+	c := retryablehttp.NewClient()
+	p.apply(c)
+	return c.RetryWaitMin, c.RetryWaitMax
+}
+
+// Backoff tracks the hosts that rate limit requests, so that the APK
+// instances sharing it pause their requests to such a host together.
+type Backoff = backoff.Hosts
+
+// NewBackoff returns a Backoff pausing requests to a host that rate limits
+// them between the shortest and longest waits between retries of p. Share
+// one between APK instances, e.g. one per architecture, with WithBackoff.
+func NewBackoff(p RetryPolicy) *Backoff {
+	return backoff.NewHosts(p.backoffs())
+}
+
+func (p RetryPolicy) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if err != nil || ctx.Err() != nil {
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+	return slices.Contains(p.RetryOn, resp.StatusCode), nil
+}
+
+// WithRetryPolicy sets how repository fetches that fail transiently are
+// retried.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *opts) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		o.retry = p
+		return nil
+	}
+}
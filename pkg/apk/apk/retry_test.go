@@ -0,0 +1,91 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	// The server fails every request with status until it has seen fail of
+	// them.
+	serve := func(t *testing.T, status int, fail int32) (*httptest.Server, *atomic.Int32) {
+		var hits atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if hits.Add(1) <= fail {
+				w.WriteHeader(status)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		t.Cleanup(srv.Close)
+		return srv, &hits
+	}
+	get := func(t *testing.T, p RetryPolicy, url string) int {
+		a, err := New(t.Context(), WithFS(apkfs.NewMemFS()), WithRetryPolicy(p))
+		require.NoError(t, err)
+		resp, err := a.client.Get(url)
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	fast := RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	t.Run("default statuses", func(t *testing.T) {
+		srv, hits := serve(t, http.StatusServiceUnavailable, 2)
+		require.Equal(t, http.StatusNoContent, get(t, fast, srv.URL))
+		require.Equal(t, int32(3), hits.Load())
+	})
+
+	t.Run("attempts", func(t *testing.T) {
+		p := fast
+		p.Attempts = 2
+		srv, hits := serve(t, http.StatusBadGateway, 5)
+		require.Zero(t, get(t, p, srv.URL), "retries should be exhausted")
+		require.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("retry on", func(t *testing.T) {
+		p := fast
+		p.RetryOn = []int{http.StatusNotFound}
+		srv, hits := serve(t, http.StatusNotFound, 1)
+		require.Equal(t, http.StatusNoContent, get(t, p, srv.URL))
+		require.Equal(t, int32(2), hits.Load())
+
+		// 503 is no longer retried.
+		srv, hits = serve(t, http.StatusServiceUnavailable, 1)
+		require.Equal(t, http.StatusServiceUnavailable, get(t, p, srv.URL))
+		require.Equal(t, int32(1), hits.Load())
+	})
+
+	for _, p := range []RetryPolicy{
+		{Attempts: -1},
+		{MinBackoff: time.Minute, MaxBackoff: time.Second},
+		{RetryOn: []int{42}},
+	} {
+		_, err := New(t.Context(), WithFS(apkfs.NewMemFS()), WithRetryPolicy(p))
+		require.Error(t, err, "%+v", p)
+	}
+}
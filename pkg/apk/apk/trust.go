@@ -0,0 +1,110 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/x509roots/fallback/bundle"
+)
+
+// CA trust sources the fetcher can verify repositories against. Any other
+// source is the path of a PEM file of CA certificates.
+const (
+	// CATrustDefault is Go's default: the system store, as overridden by
+	// SSL_CERT_FILE and SSL_CERT_DIR.
+	CATrustDefault = ""
+	// CATrustSystem is the system store, failing if it holds no certificate
+	// rather than with an opaque TLS error on the first fetch.
+	CATrustSystem = "system"
+	// CATrustBundled is the Mozilla CA list built into apko, from
+	// golang.org/x/crypto/x509roots/fallback, for builders without a system
+	// store.
+	CATrustBundled = "bundled"
+)
+
+var bundledCAPool = sync.OnceValues(func() (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for root := range bundle.Roots() {
+		cert, err := x509.ParseCertificate(root.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("parsing bundled CA certificate: %w", err)
+		}
+		if root.Constraint == nil {
+			pool.AddCert(cert)
+		} else {
+			pool.AddCertWithConstraint(cert, root.Constraint)
+		}
+	}
+	return pool, nil
+})
+
+// caTrustPool returns the CA certificates of source.
+func caTrustPool(source string) (*x509.CertPool, error) {
+	switch source {
+	case CATrustSystem:
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("loading system CA certificates: %w", err)
+		}
+		if pool.Equal(x509.NewCertPool()) {
+			return nil, fmt.Errorf("no system CA certificates found, use %q or a file of CA certificates instead", CATrustBundled)
+		}
+		return pool, nil
+	case CATrustBundled:
+		return bundledCAPool()
+	default:
+		b, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificates: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no PEM certificates in %s", source)
+		}
+		return pool, nil
+	}
+}
+
+// WithCATrust sets the CA certificates repositories are verified against:
+// CATrustSystem, CATrustBundled or the path of a PEM file. It needs the
+// transport to be an *http.Transport, as it is unless WithTransport sets
+// another one.
+func WithCATrust(source string) Option {
+	return func(o *opts) error {
+		o.caTrust = source
+		return nil
+	}
+}
+
+// applyCATrust makes t verify servers against the CA certificates of source.
+func applyCATrust(t *http.Transport, source string) error {
+	pool, err := caTrustPool(source)
+	if err != nil {
+		return err
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		t.TLSClientConfig = t.TLSClientConfig.Clone()
+	}
+	t.TLSClientConfig.RootCAs = pool
+	return nil
+}
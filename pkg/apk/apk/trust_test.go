@@ -0,0 +1,73 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestCATrust(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// Don't log the handshake the bundled CA list fails.
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o644))
+
+	get := func(t *testing.T, source string) error {
+		tr := &http.Transport{}
+		require.NoError(t, applyCATrust(tr, source))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	require.NoError(t, get(t, ca))
+	// The test server's certificate is not signed by a public CA.
+	require.ErrorContains(t, get(t, CATrustBundled), "certificate signed by unknown authority")
+
+	_, err := caTrustPool(filepath.Join(t.TempDir(), "missing.pem"))
+	require.ErrorContains(t, err, "reading CA certificates")
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate\n"), 0o644))
+	_, err = caTrustPool(empty)
+	require.ErrorContains(t, err, "no PEM certificates")
+
+	_, err = New(ctx, WithFS(apkfs.NewMemFS()), WithCATrust(CATrustBundled))
+	require.NoError(t, err)
+	_, err = New(ctx, WithFS(apkfs.NewMemFS()), WithTransport(http.NewFileTransport(http.Dir("."))), WithCATrust(ca))
+	require.ErrorContains(t, err, "CA trust options need an *http.Transport")
+}
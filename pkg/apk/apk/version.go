@@ -432,6 +432,7 @@ func ResolvePackageNameVersionPin(pkgName string) ParsedConstraint {
 type filterOptions struct {
 	allowPin  string
 	preferPin string
+	name      string
 	version   string
 	installed *RepositoryPackage
 	compare   versionDependency
@@ -449,6 +450,17 @@ func withPreferPin(pin string) filterOption {
 		o.preferPin = pin
 	}
 }
+
+// withName restricts version checks to the given name. A package that was
+// found through one of its provides, e.g. so:libssl.so.3=3.1, is checked against
+// the version of that provide rather than its own version or those of unrelated
+// provides. An unversioned provide never satisfies a versioned constraint, as
+// in constrain.
+func withName(name string) filterOption {
+	return func(o *filterOptions) {
+		o.name = name
+	}
+}
 func withVersion(version string, compare versionDependency) filterOption {
 	return func(o *filterOptions) {
 		o.version = version
@@ -501,24 +513,29 @@ func filterPackages(pkgs []*repositoryPackage, dq map[*RepositoryPackage]string,
 			return nil
 		}
 
-		actualVersion, err := cachedParseVersion(pkg.Version)
-		// skip invalid ones
-		if err != nil {
-			continue
-		}
+		if o.name == "" || o.name == pkg.Name {
+			actualVersion, err := cachedParseVersion(pkg.Version)
+			// skip invalid ones
+			if err != nil {
+				continue
+			}
 
-		if o.compare.satisfies(actualVersion, requiredVersion) {
-			passed = append(passed, pkg)
-			continue
+			if o.compare.satisfies(actualVersion, requiredVersion) {
+				passed = append(passed, pkg)
+				continue
+			}
 		}
 
 		for _, prov := range pkg.Provides {
-			version := cachedResolvePackageNameVersionPin(prov).Version
-			if version == "" {
+			provided := cachedResolvePackageNameVersionPin(prov)
+			if o.name != "" && provided.Name != o.name {
+				continue
+			}
+			if provided.Version == "" {
 				continue
 			}
 
-			actualVersion, err = cachedParseVersion(version)
+			actualVersion, err := cachedParseVersion(provided.Version)
 			// again, we skip invalid ones
 			if err != nil {
 				continue
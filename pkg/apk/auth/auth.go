@@ -3,14 +3,21 @@ package auth
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chainguard-dev/clog"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	"golang.org/x/oauth2"
 	"golang.org/x/time/rate"
 )
@@ -19,10 +26,14 @@ import (
 var DefaultAuthenticators Authenticator = multiAuthenticator{
 	// First, we'll try to use the HTTP_AUTH environment variable if it's set.
 	EnvAuth{},
+	// Then per-host credentials from APKO_HTTP_AUTH_<HOST>(_FILE).
+	EnvHostAuth{},
 	// If both of these envs are set, we'll try to use the k8s token first.
 	NewK8sAuth(os.Getenv("K8S_TOKEN_PATH"), os.Getenv("CHAINGUARD_IDENTITY"), "https://issuer.enforce.dev", "apk.cgr.dev"),
 	// If only the identity env is set, and k8s auth didn't work, we'll try to use exchanged GCP auth.
 	NewChainguardIdentityAuth(os.Getenv("CHAINGUARD_IDENTITY"), "https://issuer.enforce.dev", "apk.cgr.dev"),
+	// Then credentials saved by `apko login` or `docker login`.
+	DockerConfigAuth(),
 	// If nothing has worked yet, we'll try to use chainctl.
 	CGRAuth{},
 }
@@ -78,6 +89,169 @@ func (e EnvAuth) AddAuth(_ context.Context, req *http.Request) error {
 	return nil
 }
 
+// EnvHostAuth adds HTTP basic auth to the request from an environment
+// variable named after the request's host. The name is APKO_HTTP_AUTH_
+// followed by the host, upper-cased, with every character other than a
+// letter or digit replaced by an underscore: credentials for apk.cgr.dev are
+// read from APKO_HTTP_AUTH_APK_CGR_DEV as "user:pass".
+//
+// If that variable is not set, the variable with a _FILE suffix may instead
+// name a file holding "user:pass", such as a mounted Kubernetes secret.
+type EnvHostAuth struct{}
+
+func (e EnvHostAuth) AddAuth(_ context.Context, req *http.Request) error {
+	name := hostAuthEnv(req.URL.Host)
+	creds, ok := os.LookupEnv(name)
+	if !ok {
+		path, ok := os.LookupEnv(name + "_FILE")
+		if !ok {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s_FILE: %w", name, err)
+		}
+		creds = strings.TrimSpace(string(b))
+	}
+	user, pass, ok := strings.Cut(creds, ":")
+	if !ok {
+		return fmt.Errorf("credentials in %s must be of the form user:pass", name)
+	}
+	req.SetBasicAuth(user, pass)
+	return nil
+}
+
+// hostAuthEnv returns the name of the environment variable holding the
+// credentials for host.
+func hostAuthEnv(host string) string {
+	return "APKO_HTTP_AUTH_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, host)
+}
+
+// KeychainAuth returns an Authenticator that adds HTTP basic auth to the
+// request from the credentials kc holds for the request's host. The result
+// is cached per host, and hosts whose credentials cannot be resolved are
+// treated as anonymous.
+func KeychainAuth(kc authn.Keychain) Authenticator {
+	return &keychainAuth{kc: kc, configured: func(string) bool { return true }}
+}
+
+// DockerConfigAuth returns an Authenticator that adds HTTP basic auth to the
+// request from the credentials `apko login` saves in the Docker config file,
+// or in the credential helpers it configures. authn.DefaultKeychain is only
+// consulted for hosts that the config file lists.
+func DockerConfigAuth() Authenticator {
+	return &keychainAuth{kc: authn.DefaultKeychain, configured: dockerConfigured}
+}
+
+type keychainAuth struct {
+	kc         authn.Keychain
+	configured func(host string) bool
+
+	// creds maps a host to its *keychainCreds, nil when it has none.
+	creds sync.Map
+}
+
+type keychainCreds struct{ user, pass string }
+
+func (k *keychainAuth) AddAuth(ctx context.Context, req *http.Request) error {
+	host := req.URL.Host
+	v, ok := k.creds.Load(host)
+	if !ok {
+		v, _ = k.creds.LoadOrStore(host, k.resolve(ctx, host))
+	}
+	if c := v.(*keychainCreds); c != nil {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+	return nil
+}
+
+func (k *keychainAuth) resolve(ctx context.Context, host string) *keychainCreds {
+	if !k.configured(host) {
+		return nil
+	}
+	reg, err := name.NewRegistry(host)
+	if err != nil {
+		// Not a host a keychain can hold credentials for.
+		return nil
+	}
+	a, err := k.kc.Resolve(reg)
+	if err != nil {
+		clog.FromContext(ctx).Debugf("resolving credentials for %s, continuing anonymously: %v", host, err)
+		return nil
+	}
+	cfg, err := a.Authorization()
+	if err != nil {
+		clog.FromContext(ctx).Debugf("reading credentials for %s, continuing anonymously: %v", host, err)
+		return nil
+	}
+	if cfg.Username == "" || cfg.Password == "" {
+		return nil
+	}
+	return &keychainCreds{user: cfg.Username, pass: cfg.Password}
+}
+
+// dockerConfigured reports whether the Docker config file authn.DefaultKeychain
+// reads lists credentials, a credential helper or a credential store for host.
+func dockerConfigured(host string) bool {
+	cf, err := loadDockerConfig()
+	if err != nil || cf == nil {
+		return false
+	}
+	if cf.CredentialsStore != "" || cf.CredentialHelpers[host] != "" {
+		return true
+	}
+	for key := range cf.AuthConfigs {
+		// Keys are hosts, optionally with a scheme and path, as in
+		// "https://index.docker.io/v1/".
+		if _, rest, ok := strings.Cut(key, "://"); ok {
+			key = rest
+		}
+		key, _, _ = strings.Cut(key, "/")
+		if key == host {
+			return true
+		}
+	}
+	return false
+}
+
+// loadDockerConfig loads the config file from the locations
+// authn.DefaultKeychain reads, in the same order. It returns nil if there is
+// none.
+func loadDockerConfig() (*configfile.ConfigFile, error) {
+	exists := func(path string) bool {
+		fi, err := os.Stat(path)
+		return err == nil && !fi.IsDir()
+	}
+	if home, err := os.UserHomeDir(); err == nil && exists(filepath.Join(home, ".docker", "config.json")) ||
+		os.Getenv("DOCKER_CONFIG") != "" && exists(filepath.Join(os.Getenv("DOCKER_CONFIG"), "config.json")) {
+		return config.Load(os.Getenv("DOCKER_CONFIG"))
+	}
+	for _, path := range []string{
+		os.Getenv("REGISTRY_AUTH_FILE"),
+		filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "containers", "auth.json"),
+	} {
+		if path == "" || !exists(path) {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return config.LoadFromReader(f)
+	}
+	return nil, nil
+}
+
 // CGRAuth adds HTTP basic auth to the request if the request URL matches
 // apk.cgr.dev and the `chainctl` command is available.
 //
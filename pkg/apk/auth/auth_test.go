@@ -4,7 +4,12 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
 )
 
 type successAuth struct{}
@@ -70,3 +75,149 @@ func TestMultiAuthenticator(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvHostAuth(t *testing.T) {
+	if got, want := hostAuthEnv("apk.cgr.dev"), "APKO_HTTP_AUTH_APK_CGR_DEV"; got != want {
+		t.Errorf("hostAuthEnv = %q, want %q", got, want)
+	}
+	if got, want := hostAuthEnv("localhost:8080"), "APKO_HTTP_AUTH_LOCALHOST_8080"; got != want {
+		t.Errorf("hostAuthEnv = %q, want %q", got, want)
+	}
+
+	secret := filepath.Join(t.TempDir(), "creds")
+	if err := os.WriteFile(secret, []byte("fileuser:filepass\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APKO_HTTP_AUTH_PACKAGES_EXAMPLE_COM", "user:pa:ss")
+	t.Setenv("APKO_HTTP_AUTH_MIRROR_EXAMPLE_COM_FILE", secret)
+	t.Setenv("APKO_HTTP_AUTH_BAD_EXAMPLE_COM", "nopass")
+
+	for _, tt := range []struct {
+		url, user, pass string
+		expectErr       bool
+	}{
+		{url: "https://packages.example.com/os", user: "user", pass: "pa:ss"},
+		{url: "https://mirror.example.com/os", user: "fileuser", pass: "filepass"},
+		{url: "https://other.example.com/os"},
+		{url: "https://bad.example.com/os", expectErr: true},
+	} {
+		t.Run(tt.url, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			err := EnvHostAuth{}.AddAuth(context.Background(), req)
+			if tt.expectErr != (err != nil) {
+				t.Fatalf("AddAuth() error = %v, expectErr %t", err, tt.expectErr)
+			}
+			user, pass, ok := req.BasicAuth()
+			if ok != (tt.user != "") || user != tt.user || pass != tt.pass {
+				t.Errorf("BasicAuth() = %q, %q, %t; want %q, %q", user, pass, ok, tt.user, tt.pass)
+			}
+		})
+	}
+}
+
+func TestKeychainAuth(t *testing.T) {
+	// As saved by `apko login -u user -p secret packages.example.com`.
+	dir := t.TempDir()
+	config := `{"auths": {"packages.example.com": {"auth": "dXNlcjpzZWNyZXQ="}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	for _, tt := range []struct {
+		url, user, pass string
+	}{
+		{url: "https://packages.example.com/os/x86_64/APKINDEX.tar.gz", user: "user", pass: "secret"},
+		{url: "https://other.example.com/os"},
+	} {
+		t.Run(tt.url, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			if err := DockerConfigAuth().AddAuth(context.Background(), req); err != nil {
+				t.Fatalf("AddAuth() = %v", err)
+			}
+			user, pass, ok := req.BasicAuth()
+			if ok != (tt.user != "") || user != tt.user || pass != tt.pass {
+				t.Errorf("BasicAuth() = %q, %q, %t; want %q, %q", user, pass, ok, tt.user, tt.pass)
+			}
+		})
+	}
+}
+
+type countingKeychain struct {
+	calls map[string]int
+	err   error
+}
+
+func (k *countingKeychain) Resolve(r authn.Resource) (authn.Authenticator, error) {
+	k.calls[r.RegistryStr()]++
+	if k.err != nil {
+		return nil, k.err
+	}
+	return authn.FromConfig(authn.AuthConfig{Username: "user", Password: "secret"}), nil
+}
+
+func TestKeychainAuthCachesPerHost(t *testing.T) {
+	kc := &countingKeychain{calls: map[string]int{}}
+	a := KeychainAuth(kc)
+	for _, u := range []string{
+		"https://packages.example.com/os/x86_64/APKINDEX.tar.gz",
+		"https://packages.example.com/os/x86_64/foo-1.0-r0.apk",
+		"https://other.example.com/os/x86_64/APKINDEX.tar.gz",
+	} {
+		req, _ := http.NewRequest("GET", u, nil)
+		if err := a.AddAuth(context.Background(), req); err != nil {
+			t.Fatalf("AddAuth(%s) = %v", u, err)
+		}
+		if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			t.Errorf("BasicAuth(%s) = %q, %q, %t", u, user, pass, ok)
+		}
+	}
+	if want := map[string]int{"packages.example.com": 1, "other.example.com": 1}; !reflect.DeepEqual(kc.calls, want) {
+		t.Errorf("Resolve calls = %v, want %v", kc.calls, want)
+	}
+}
+
+func TestKeychainAuthResolveError(t *testing.T) {
+	kc := &countingKeychain{calls: map[string]int{}, err: errors.New("credential helper not found")}
+	a := KeychainAuth(kc)
+	for range 2 {
+		req, _ := http.NewRequest("GET", "https://packages.example.com/os", nil)
+		if err := a.AddAuth(context.Background(), req); err != nil {
+			t.Fatalf("AddAuth() = %v, want anonymous", err)
+		}
+		if _, _, ok := req.BasicAuth(); ok {
+			t.Error("BasicAuth() set after resolve error")
+		}
+	}
+	if got := kc.calls["packages.example.com"]; got != 1 {
+		t.Errorf("Resolve calls = %d, want 1", got)
+	}
+}
+
+func TestDockerConfigAuthUnconfiguredHost(t *testing.T) {
+	// A credential helper that cannot run would fail every lookup; apko
+	// must not consult it for hosts the config does not list.
+	dir := t.TempDir()
+	config := `{"auths": {"https://packages.example.com/v1/": {"auth": "dXNlcjpzZWNyZXQ="}}, "credHelpers": {"helper.example.com": "does-not-exist"}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	for _, tt := range []struct {
+		host       string
+		configured bool
+	}{
+		{host: "packages.example.com", configured: true},
+		{host: "helper.example.com", configured: true},
+		{host: "other.example.com"},
+	} {
+		if got := dockerConfigured(tt.host); got != tt.configured {
+			t.Errorf("dockerConfigured(%s) = %t, want %t", tt.host, got, tt.configured)
+		}
+		req, _ := http.NewRequest("GET", "https://"+tt.host+"/os", nil)
+		if err := DockerConfigAuth().AddAuth(context.Background(), req); err != nil {
+			t.Errorf("AddAuth(%s) = %v", tt.host, err)
+		}
+	}
+}
@@ -255,6 +255,10 @@ var _ io.Writer = (*expandApkWriter)(nil)
 
 var errExpandApkWriterMaxStreams = errors.New("expandApkWriter max streams reached")
 
+// ErrChecksumMismatch is returned when the contents of a package do not match
+// the checksums recorded for them.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 func (w *expandApkWriter) Next() error {
 	if w.f != nil {
 		if err := w.CloseFile(); err != nil {
@@ -573,7 +577,7 @@ func checkSums(ctx context.Context, r io.Reader) error {
 		}
 
 		if want, got := checksum, w.Sum(nil); !bytes.Equal(want, got) {
-			return fmt.Errorf("checksum mismatch: %s header was %x, computed %x", header.Name, want, got)
+			return fmt.Errorf("%w: %s header was %x, computed %x", ErrChecksumMismatch, header.Name, want, got)
 		}
 	}
 
@@ -48,6 +48,34 @@ type FullFS interface {
 	Sub(path string) (FullFS, error)
 }
 
+// MknodMode converts the mode passed to Mknod into an fs.FileMode. Character
+// devices, block devices and FIFOs are distinguished by the S_IFMT bits of
+// mode; a mode without a file type is treated as a character device.
+func MknodMode(mode uint32) fs.FileMode {
+	perm := fs.FileMode(mode & 0o777)
+	switch mode & IFMT {
+	case IFBLK:
+		return perm | fs.ModeDevice
+	case IFIFO:
+		return perm | fs.ModeNamedPipe
+	default:
+		return perm | fs.ModeDevice | fs.ModeCharDevice
+	}
+}
+
+// unixFileType is the inverse of MknodMode for the file types it handles.
+func unixFileType(mode fs.FileMode) uint32 {
+	switch {
+	case mode&fs.ModeCharDevice != 0:
+		return IFCHR
+	case mode&fs.ModeDevice != 0:
+		return IFBLK
+	case mode&fs.ModeNamedPipe != 0:
+		return IFIFO
+	}
+	return 0
+}
+
 // File is an interface for a file. It includes Read, Write, Close.
 // This wouldn't be necessary if os.File were an interface, or if fs.File
 // were read/write.
@@ -27,8 +27,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/sys/unix"
 )
 
 const (
@@ -333,9 +331,9 @@ func (m *memFS) Mknod(path string, mode uint32, dev int) error {
 	}
 	anode.children[base] = &node{
 		name:    base,
-		mode:    fs.FileMode(mode) | os.ModeCharDevice | os.ModeDevice,
-		major:   unix.Major(uint64(dev)),
-		minor:   unix.Minor(uint64(dev)),
+		mode:    MknodMode(mode),
+		major:   Major(uint64(dev)),
+		minor:   Minor(uint64(dev)),
 		xattrs:  map[string][]byte{},
 		modTime: anode.modTime,
 	}
@@ -356,10 +354,10 @@ func (m *memFS) Readnod(path string) (dev int, err error) {
 	if !ok {
 		return 0, os.ErrNotExist
 	}
-	if anode.mode&os.ModeDevice != os.ModeDevice || anode.mode&os.ModeCharDevice != os.ModeCharDevice {
+	if anode.mode&(os.ModeDevice|os.ModeNamedPipe) == 0 {
 		return 0, fmt.Errorf("not a device")
 	}
-	return int(unix.Mkdev(anode.major, anode.minor)), nil
+	return int(Mkdev(anode.major, anode.minor)), nil
 }
 
 func (m *memFS) Chmod(path string, perm fs.FileMode) error {
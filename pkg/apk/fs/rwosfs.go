@@ -16,23 +16,25 @@ package fs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/chainguard-dev/clog"
-	"golang.org/x/sys/unix"
 )
 
 type dirFSOpts struct {
 	caseSensitive    bool
 	caseSensitiveSet bool
+	symlinks         bool
+	symlinksSet      bool
 	mkdir            bool
 }
 
@@ -51,6 +53,18 @@ func DirFSWithCaseSensitive(caseSensitive bool) DirFSOption {
 	}
 }
 
+// DirFSWithSymlinks allows you to specify whether symlinks can be created on
+// the underlying filesystem. If they cannot, as on Windows without the
+// privilege to, they are kept in memory and followed there. If you do not
+// specify this, it determines it by testing the underlying filesystem.
+func DirFSWithSymlinks(symlinks bool) DirFSOption {
+	return func(opts *dirFSOpts) error {
+		opts.symlinks = symlinks
+		opts.symlinksSet = true
+		return nil
+	}
+}
+
 // WithCreateDir allows you to specify whether the underlying directory
 // should be created if it does not exist. Default is false.
 func WithCreateDir() DirFSOption {
@@ -123,10 +137,29 @@ func DirFS(ctx context.Context, dir string, opts ...DirFSOption) FullFS {
 	if !caseSensitive {
 		caseMap = map[string]string{}
 	}
+
+	symlinks := true
+	if options.symlinksSet {
+		symlinks = options.symlinks
+	} else {
+		// check if symlinks can be created on disk; on Windows that takes
+		// privileges a build usually does not have.
+		if err := probeSymlinks(dir); err != nil {
+			log.Debug("symlinks are kept in memory", "error", err)
+			symlinks = false
+		}
+	}
+
+	var memLinks map[string]struct{}
+	if !symlinks {
+		memLinks = map[string]struct{}{}
+	}
+
 	f := &dirFS{
 		base:      dir,
 		overrides: m,
 		caseMap:   caseMap,
+		memLinks:  memLinks,
 	}
 	// need to populate the overrides with appropriate info
 	root := os.DirFS(dir)
@@ -154,20 +187,12 @@ func DirFS(ctx context.Context, dir string, opts ...DirFSOption) FullFS {
 			if err == nil {
 				err = f.overrides.Symlink(target, path)
 			}
-		case fs.ModeCharDevice:
+		case fs.ModeCharDevice, fs.ModeDevice, fs.ModeNamedPipe:
 			var dev int
-			sys := fi.Sys()
-			st1, ok1 := sys.(*syscall.Stat_t)
-			st2, ok2 := sys.(*unix.Stat_t)
-			switch {
-			case ok1:
-				dev = int(st1.Rdev)
-			case ok2:
-				dev = int(st2.Rdev)
-			default:
-				return fmt.Errorf("unsupported type %T", sys)
+			dev, err = deviceNumber(fi)
+			if err == nil {
+				err = f.overrides.Mknod(path, unixFileType(mode)|uint32(perm), dev)
 			}
-			err = f.overrides.Mknod(path, uint32(unix.S_IFCHR|mode), dev)
 		default:
 			var memFile File
 			memFile, err = f.overrides.OpenFile(path, os.O_CREATE, perm)
@@ -203,10 +228,32 @@ type dirFS struct {
 	overrides FullFS
 	// caseMap if non-nil, underlying filesystem is case-insensitive, so only one variant of each file
 	// can exist on disk. Maps the case-sensitive to the case-insensitive variant
-	caseMap      map[string]string
+	caseMap map[string]string
+	// memLinks if non-nil, symlinks cannot be created on disk, so they are kept only in memory.
+	// Holds the paths of those symlinks, which are resolved in memory when opened.
+	// Guarded by caseMapMutex too.
+	memLinks     map[string]struct{}
 	caseMapMutex sync.Mutex
 }
 
+// probeSymlinks returns an error if symlinks cannot be created in dir. The
+// probe goes in a directory of its own inside dir, as the filesystem of dir
+// is the one that matters, and is removed again. Like the case sensitivity
+// probe, it is named after the first free index rather than at random, so
+// that DirFS needs no source of randomness.
+func probeSymlinks(dir string) error {
+	for i := 0; ; i++ {
+		tmp := filepath.Join(dir, fmt.Sprintf(".apko-symlink-probe-%d", i))
+		if err := os.Mkdir(tmp, 0o700); errors.Is(err, fs.ErrExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		return os.Symlink("target", filepath.Join(tmp, "link"))
+	}
+}
+
 func (f *dirFS) Readlink(name string) (string, error) {
 	// The underlying filesystem might not support symlinks, and it might be case-insensitive, so just
 	// use the one in memory.
@@ -227,6 +274,10 @@ func (f *dirFS) Open(name string) (fs.File, error) {
 }
 
 func (f *dirFS) open(name string) (*fileImpl, error) {
+	name, err := f.followMemLinks(name)
+	if err != nil {
+		return nil, err
+	}
 	fullpath, err := f.sanitizePath(name)
 	if err != nil {
 		return nil, err
@@ -279,10 +330,11 @@ func (f *dirFS) open(name string) (*fileImpl, error) {
 // This only works if the user reading the file actually has
 // permissions to change the file permissions.
 func (f *dirFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
-	var (
-		file File
-		err  error
-	)
+	name, err := f.followMemLinks(name)
+	if err != nil {
+		return nil, err
+	}
+	var file File
 	if flag&os.O_CREATE == os.O_CREATE {
 		file, err = f.overrides.OpenFile(name, flag, perm)
 		if err != nil {
@@ -318,12 +370,16 @@ func (f *dirFS) Stat(name string) (fs.FileInfo, error) {
 		fi  fs.FileInfo
 		err error
 	)
-	mi, err := f.overrides.Stat(name)
+	resolved, err := f.followMemLinks(name)
 	if err != nil {
 		return nil, err
 	}
-	if f.caseSensitiveOnDisk(name) {
-		fi, err = os.Stat(filepath.Join(f.base, name))
+	mi, err := f.overrides.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if f.caseSensitiveOnDisk(resolved) {
+		fi, err = os.Stat(filepath.Join(f.base, resolved))
 		if err != nil {
 			return nil, err
 		}
@@ -331,6 +387,7 @@ func (f *dirFS) Stat(name string) (fs.FileInfo, error) {
 		fi = mi
 	}
 	return &fileInfo{
+		name: path.Base(name),
 		file: fi,
 		mem:  mi,
 	}, nil
@@ -340,13 +397,13 @@ func (f *dirFS) Lstat(name string) (fs.FileInfo, error) {
 }
 
 func (f *dirFS) Create(name string) (File, error) {
+	name, err := f.followMemLinks(name)
+	if err != nil {
+		return nil, err
+	}
 	// if the underlying filesystem is case-insensitive, check if the file exists and, if so,
 	// do it only in memory.
-	var (
-		file File
-		err  error
-	)
-	file, err = f.overrides.Create(name)
+	file, err := f.overrides.Create(name)
 	if err != nil {
 		return nil, err
 	}
@@ -364,6 +421,10 @@ func (f *dirFS) Create(name string) (File, error) {
 }
 
 func (f *dirFS) Remove(name string) error {
+	name, err := f.followMemDirs(name)
+	if err != nil {
+		return err
+	}
 	if err := f.overrides.Remove(name); err != nil {
 		return err
 	}
@@ -379,6 +440,10 @@ func (f *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
 		onDisk, inMem []fs.DirEntry
 		err           error
 	)
+	name, err = f.followMemLinks(name)
+	if err != nil {
+		return nil, err
+	}
 	if f.caseSensitiveOnDisk(name) {
 		onDisk, err = os.ReadDir(filepath.Join(f.base, name))
 		if err != nil {
@@ -417,15 +482,21 @@ func (f *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return dirEntries, nil
 }
 func (f *dirFS) ReadFile(name string) ([]byte, error) {
+	name, err := f.followMemLinks(name)
+	if err != nil {
+		return nil, err
+	}
 	if f.caseSensitiveOnDisk(name) {
 		return os.ReadFile(filepath.Join(f.base, name))
 	}
 	return f.overrides.ReadFile(name)
 }
 func (f *dirFS) WriteFile(name string, b []byte, mode fs.FileMode) error {
-	var (
-		memContent []byte
-	)
+	name, err := f.followMemLinks(name)
+	if err != nil {
+		return err
+	}
+	var memContent []byte
 	if f.createOnDisk(name) {
 		if err := os.WriteFile(filepath.Join(f.base, name), b, mode); err != nil {
 			return err
@@ -441,6 +512,10 @@ func (f *dirFS) WriteFile(name string, b []byte, mode fs.FileMode) error {
 }
 
 func (f *dirFS) Readnod(name string) (dev int, err error) {
+	name, err = f.followMemLinks(name)
+	if err != nil {
+		return 0, err
+	}
 	if f.caseSensitiveOnDisk(name) {
 		_, err = os.Stat(filepath.Join(f.base, name))
 		if err != nil {
@@ -451,6 +526,14 @@ func (f *dirFS) Readnod(name string) (dev int, err error) {
 }
 
 func (f *dirFS) Link(oldname, newname string) error {
+	oldname, err := f.followMemLinks(oldname)
+	if err != nil {
+		return err
+	}
+	newname, err = f.followMemDirs(newname)
+	if err != nil {
+		return err
+	}
 	// for hardlink, we cannot take target as is, as it might be outside of the base.
 	// So we must sanitize it. It should point to a file that is within the filesystem.
 	target := filepath.Join(f.base, oldname)
@@ -470,7 +553,15 @@ func (f *dirFS) Symlink(oldname, newname string) error {
 	// For symlink, take target as is.
 	// If it is outside of the base, it will be resolved by Readlink.
 	// This enables proper symlink behaviour.
-	if f.createOnDisk(newname) {
+	newname, err := f.followMemDirs(newname)
+	if err != nil {
+		return err
+	}
+	if f.memLinks != nil {
+		f.caseMapMutex.Lock()
+		f.memLinks[strings.TrimPrefix(newname, "/")] = struct{}{}
+		f.caseMapMutex.Unlock()
+	} else if f.createOnDisk(newname) {
 		if err := os.Symlink(oldname, filepath.Join(f.base, newname)); err != nil {
 			return err
 		}
@@ -479,6 +570,10 @@ func (f *dirFS) Symlink(oldname, newname string) error {
 }
 
 func (f *dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	name, err := f.followMemLinks(name)
+	if err != nil {
+		return err
+	}
 	// just in case, because some underlying systems miss this
 	fullPerm := os.ModeDir | perm
 	if f.createOnDisk(name) {
@@ -490,6 +585,10 @@ func (f *dirFS) MkdirAll(name string, perm fs.FileMode) error {
 }
 
 func (f *dirFS) Mkdir(name string, perm fs.FileMode) error {
+	name, err := f.followMemDirs(name)
+	if err != nil {
+		return err
+	}
 	// just in case, because some underlying systems miss this
 	fullPerm := os.ModeDir | perm
 	if f.createOnDisk(name) {
@@ -501,6 +600,10 @@ func (f *dirFS) Mkdir(name string, perm fs.FileMode) error {
 }
 
 func (f *dirFS) Chmod(path string, perm fs.FileMode) error {
+	path, err := f.followMemLinks(path)
+	if err != nil {
+		return err
+	}
 	if f.caseSensitiveOnDisk(path) {
 		// ignore error, as we track it in memory anyways, and disk filesystem might not support it
 		_ = os.Chmod(filepath.Join(f.base, path), perm)
@@ -509,6 +612,10 @@ func (f *dirFS) Chmod(path string, perm fs.FileMode) error {
 }
 
 func (f *dirFS) Chown(path string, uid, gid int) error {
+	path, err := f.followMemLinks(path)
+	if err != nil {
+		return err
+	}
 	if f.caseSensitiveOnDisk(path) {
 		// ignore error, as we track it in memory anyways, and disk filesystem might not support it
 		_ = os.Chown(filepath.Join(f.base, path), uid, gid)
@@ -517,6 +624,13 @@ func (f *dirFS) Chown(path string, uid, gid int) error {
 }
 
 func (f *dirFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	path, err := f.followMemDirs(path)
+	if err != nil {
+		return err
+	}
+	if f.inMemoryLink(path) {
+		return f.overrides.Chtimes(path, atime, mtime)
+	}
 	if err := os.Chtimes(filepath.Join(f.base, path), atime, mtime); err != nil {
 		return fmt.Errorf("unable to change times: %w", err)
 	}
@@ -524,8 +638,12 @@ func (f *dirFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
 }
 
 func (f *dirFS) Mknod(name string, mode uint32, dev int) error {
+	name, err := f.followMemDirs(name)
+	if err != nil {
+		return err
+	}
 	if f.caseSensitiveOnDisk(name) {
-		err := unix.Mknod(filepath.Join(f.base, name), mode, dev)
+		err := mknod(filepath.Join(f.base, name), mode, dev)
 		// what if we could not create it? Just create a regular file there, and memory will override
 		if err != nil {
 			if err := os.WriteFile(filepath.Join(f.base, name), nil, 0); err != nil {
@@ -568,6 +686,9 @@ func sanitizePath(base, p string) (v string, err error) {
 }
 
 func (f *dirFS) caseSensitiveOnDisk(p string) bool {
+	if f.inMemoryLink(p) {
+		return false
+	}
 	if f.caseMap == nil {
 		return true
 	}
@@ -582,6 +703,67 @@ func (f *dirFS) caseSensitiveOnDisk(p string) bool {
 	return result == p
 }
 
+// inMemoryLink reports whether p is a symlink that is kept only in memory.
+func (f *dirFS) inMemoryLink(p string) bool {
+	if f.memLinks == nil {
+		return false
+	}
+	f.caseMapMutex.Lock()
+	defer f.caseMapMutex.Unlock()
+	_, ok := f.memLinks[strings.TrimPrefix(p, "/")]
+	return ok
+}
+
+// followMemLinks resolves every component of name that is a symlink kept only
+// in memory, as the disk knows nothing of those. A directory such as lib ->
+// usr/lib is followed wherever it appears in name, not only at its end.
+func (f *dirFS) followMemLinks(name string) (string, error) {
+	if f.memLinks == nil {
+		return name, nil
+	}
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	resolved := ""
+	for i, depth := 0, 0; i < len(parts); i++ {
+		p := path.Join(resolved, parts[i])
+		if !f.inMemoryLink(p) {
+			resolved = p
+			continue
+		}
+		if depth++; depth > maxLinks {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", name)
+		}
+		target, err := f.overrides.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = ""
+		}
+		// Start over on the target followed by what remains of name; the
+		// target may itself go through symlinks.
+		next := path.Join(append([]string{"/", resolved, target}, parts[i+1:]...)...)
+		parts = strings.Split(strings.TrimPrefix(next, "/"), "/")
+		resolved, i = "", -1
+	}
+	if resolved == "" {
+		return ".", nil
+	}
+	return resolved, nil
+}
+
+// followMemDirs is followMemLinks for the directory of name only, for calls
+// that act on a symlink rather than on what it points to.
+func (f *dirFS) followMemDirs(name string) (string, error) {
+	if f.memLinks == nil {
+		return name, nil
+	}
+	dir, err := f.followMemLinks(path.Dir(path.Clean("/" + name)))
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, path.Base(name)), nil
+}
+
 // createOnDisk given a path p, determine if it should be created on disk, and, if relevant,
 // add it to the caseMap. If the file already exists on disk, also returns true.
 // This func is responsible solely for determining if you _should_ created it on disk.
@@ -607,6 +789,10 @@ func (f *dirFS) createOnDisk(p string) bool {
 func (f *dirFS) removeOnDisk(p string) (removeOnDisk bool) {
 	f.caseMapMutex.Lock()
 	defer f.caseMapMutex.Unlock()
+	if _, ok := f.memLinks[strings.TrimPrefix(p, "/")]; ok {
+		delete(f.memLinks, strings.TrimPrefix(p, "/"))
+		return false
+	}
 	key := strings.ToLower(p)
 	if f.caseMap == nil {
 		removeOnDisk = true
@@ -637,11 +823,17 @@ func (f fileImpl) Close() error {
 }
 
 type fileInfo struct {
+	// name if set is the name stat was called with, which differs from
+	// the file's when a symlink kept in memory was followed.
+	name string
 	file fs.FileInfo
 	mem  fs.FileInfo
 }
 
 func (f *fileInfo) Name() string {
+	if f.name != "" {
+		return f.name
+	}
 	return f.file.Name()
 }
 func (f *fileInfo) Size() int64 {
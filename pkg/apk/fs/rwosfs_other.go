@@ -0,0 +1,35 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// mknod cannot create device nodes on this host. Callers fall back to a
+// placeholder file, and the node is only kept in memory, to be written out
+// when the filesystem is turned into a tarball.
+func mknod(string, uint32, int) error {
+	return errors.ErrUnsupported
+}
+
+// deviceNumber cannot read device numbers on this host.
+func deviceNumber(fi fs.FileInfo) (int, error) {
+	return 0, fmt.Errorf("reading device number of %s: %w", fi.Name(), errors.ErrUnsupported)
+}
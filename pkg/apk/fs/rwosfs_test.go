@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -254,3 +255,112 @@ func TestDirFSConsistentOrdering(t *testing.T) {
 	}
 	// all results should be the same
 }
+
+func TestSymlinksInMemory(t *testing.T) {
+	dir := t.TempDir()
+	fsys := DirFS(t.Context(), dir, DirFSWithSymlinks(false))
+	require.NoError(t, fsys.MkdirAll("usr/lib", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/lib/os-release", []byte("ID=wolfi\n"), 0o644))
+	require.NoError(t, fsys.MkdirAll("etc", 0o755))
+	require.NoError(t, fsys.Symlink("../usr/lib/os-release", "etc/os-release"))
+	require.NoError(t, fsys.Symlink("/etc/os-release", "os-release"))
+
+	// Nothing is written to disk for the symlinks...
+	_, err := os.Lstat(filepath.Join(dir, "etc", "os-release"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// ...but they are there, and followed, in memory.
+	target, err := fsys.Readlink("etc/os-release")
+	require.NoError(t, err)
+	require.Equal(t, "../usr/lib/os-release", target)
+	for _, name := range []string{"etc/os-release", "os-release"} {
+		b, err := fsys.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, "ID=wolfi\n", string(b), name)
+	}
+	fi, err := fsys.Stat("etc/os-release")
+	require.NoError(t, err)
+	require.Equal(t, "os-release", fi.Name())
+	require.Equal(t, int64(len("ID=wolfi\n")), fi.Size())
+	require.NoError(t, fsys.Chtimes("etc/os-release", time.Unix(0, 0), time.Unix(0, 0)))
+
+	require.NoError(t, fsys.Remove("os-release"))
+	_, err = fsys.Lstat("os-release")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestSymlinkedDirsInMemory(t *testing.T) {
+	dir := t.TempDir()
+	fsys := DirFS(t.Context(), dir, DirFSWithSymlinks(false))
+	require.NoError(t, fsys.MkdirAll("usr/lib", 0o755))
+	require.NoError(t, fsys.Symlink("usr/lib", "lib"))
+	require.NoError(t, fsys.Symlink("/lib", "usr/lib64"))
+
+	// Everything under lib and usr/lib64 lands on disk in usr/lib.
+	require.NoError(t, fsys.MkdirAll("lib/apk/db", 0o755))
+	require.NoError(t, fsys.WriteFile("lib/apk/db/installed", []byte("P:foo\n"), 0o644))
+	f, err := fsys.OpenFile("usr/lib64/libfoo.so", os.O_CREATE|os.O_WRONLY, 0o755)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("ELF"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fsys.Chmod("lib/libfoo.so", 0o700))
+	require.NoError(t, fsys.Chown("lib/libfoo.so", os.Getuid(), os.Getgid()))
+
+	b, err := os.ReadFile(filepath.Join(dir, "usr", "lib", "apk", "db", "installed"))
+	require.NoError(t, err)
+	require.Equal(t, "P:foo\n", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "usr", "lib", "libfoo.so"))
+	require.NoError(t, err)
+	require.Equal(t, "ELF", string(b))
+
+	for _, name := range []string{"lib/libfoo.so", "usr/lib/libfoo.so", "usr/lib64/libfoo.so"} {
+		fi, err := fsys.Stat(name)
+		require.NoError(t, err, name)
+		require.Equal(t, fs.FileMode(0o700), fi.Mode().Perm(), name)
+	}
+	entries, err := fsys.ReadDir("lib")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// A loop is reported rather than followed forever.
+	require.NoError(t, fsys.Symlink("loop", "loop"))
+	_, err = fsys.ReadFile("loop/file")
+	require.ErrorContains(t, err, "too many levels of symbolic links")
+}
+
+func TestDirFSSymlinkProbe(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test-dirfs-symlink"), []byte("mine"), 0o644))
+
+	fsys := DirFS(t.Context(), dir)
+	// Probing for symlink support leaves the directory alone...
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	b, err := os.ReadFile(filepath.Join(dir, "test-dirfs-symlink"))
+	require.NoError(t, err)
+	require.Equal(t, "mine", string(b))
+
+	// ...and finds that symlinks can be written to disk.
+	require.NoError(t, fsys.Symlink("test-dirfs-symlink", "link"))
+	fi, err := os.Lstat(filepath.Join(dir, "link"))
+	require.NoError(t, err)
+	require.Equal(t, fs.ModeSymlink, fi.Mode().Type())
+}
+
+func TestDeviceNumbers(t *testing.T) {
+	for _, c := range []struct {
+		major, minor uint32
+		dev          uint64
+	}{
+		{1, 3, 0x103},
+		{5, 1, 0x501},
+		{259, 65536, 0x10010300},
+		{4096, 256, 0x100000100000},
+	} {
+		require.Equal(t, c.dev, Mkdev(c.major, c.minor))
+		require.Equal(t, c.major, Major(c.dev))
+		require.Equal(t, c.minor, Minor(c.dev))
+	}
+}
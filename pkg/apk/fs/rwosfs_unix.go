@@ -0,0 +1,56 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package fs
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates a device node or FIFO at path on disk. dev is a Linux device
+// number, which is converted to the host's encoding.
+func mknod(path string, mode uint32, dev int) error {
+	hostMode := mode &^ IFMT
+	switch mode & IFMT {
+	case IFBLK:
+		hostMode |= unix.S_IFBLK
+	case IFIFO:
+		hostMode |= unix.S_IFIFO
+	default:
+		hostMode |= unix.S_IFCHR
+	}
+	hostDev := unix.Mkdev(Major(uint64(dev)), Minor(uint64(dev)))
+	return unix.Mknod(path, hostMode, int(hostDev))
+}
+
+// deviceNumber returns the Linux device number of the device node fi
+// describes on disk.
+func deviceNumber(fi fs.FileInfo) (int, error) {
+	var rdev uint64
+	switch st := fi.Sys().(type) {
+	case *syscall.Stat_t:
+		rdev = uint64(st.Rdev) //nolint:unconvert // Rdev is narrower on some platforms.
+	case *unix.Stat_t:
+		rdev = uint64(st.Rdev) //nolint:unconvert // Rdev is narrower on some platforms.
+	default:
+		return 0, fmt.Errorf("unsupported type %T", st)
+	}
+	return int(Mkdev(unix.Major(rdev), unix.Minor(rdev))), nil
+}
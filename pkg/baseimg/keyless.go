@@ -0,0 +1,219 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseimg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// The annotations cosign stores the certificate, its chain and the Rekor
+// bundle of a keyless signature under.
+const (
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// The extensions Fulcio records the OIDC issuer of a certificate in: the
+// raw string of the original one, and the DER encoded one replacing it.
+var (
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// rekorBundle is the proof, which cosign stores with a keyless signature,
+// that a Rekor log recorded it.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+// rekorPayload is what the signed entry timestamp signs. Its fields are in
+// the order of their canonical JSON encoding, which is what is signed.
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the Rekor entry of a signature, as far as it is checked
+// here.
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyKeyless checks that sig is a signature of payload made with the
+// certificate in annotations, that the certificate was issued by Fulcio to
+// v.Identity by v.Issuer, and that the signature was recorded in the Rekor
+// log of v.RekorKey while the certificate was valid.
+func (v *Verifier) verifyKeyless(payload, sig []byte, annotations map[string]string) error {
+	certPEM, ok := annotations[cosignCertificateAnnotation]
+	if !ok {
+		return errors.New("signature has no certificate")
+	}
+	certs, err := parseCertificates([]byte(certPEM))
+	if err != nil {
+		return fmt.Errorf("parsing certificate: %w", err)
+	}
+	cert := certs[0]
+
+	integrated, err := v.verifyBundle(annotations[cosignBundleAnnotation], payload, sig, cert)
+	if err != nil {
+		return err
+	}
+
+	intermediates := x509.NewCertPool()
+	if v.Intermediates != nil {
+		intermediates = v.Intermediates.Clone()
+	}
+	if chain, ok := annotations[cosignChainAnnotation]; ok {
+		certs, err := parseCertificates([]byte(chain))
+		if err != nil {
+			return fmt.Errorf("parsing certificate chain: %w", err)
+		}
+		for _, c := range certs {
+			intermediates.AddCert(c)
+		}
+	}
+	// Fulcio certificates only live for minutes: what matters is that the
+	// certificate was valid when the log recorded the signature.
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         v.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   integrated,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("verifying certificate: %w", err)
+	}
+
+	if !slices.Contains(certIdentities(cert), v.Identity) {
+		return fmt.Errorf("certificate is issued to %v, not %s", certIdentities(cert), v.Identity)
+	}
+	issuer, err := certIssuer(cert)
+	if err != nil {
+		return err
+	}
+	if issuer != v.Issuer {
+		return fmt.Errorf("certificate identity is vouched for by %s, not %s", issuer, v.Issuer)
+	}
+
+	return VerifyPayload(cert.PublicKey, payload, sig)
+}
+
+// verifyBundle checks that the Rekor bundle b is signed by v.RekorKey and
+// records sig, of payload, made with cert. It returns when the log recorded
+// it.
+func (v *Verifier) verifyBundle(b string, payload, sig []byte, cert *x509.Certificate) (time.Time, error) {
+	if b == "" {
+		return time.Time{}, errors.New("signature has no Rekor bundle")
+	}
+	var bundle rekorBundle
+	if err := json.Unmarshal([]byte(b), &bundle); err != nil {
+		return time.Time{}, fmt.Errorf("parsing Rekor bundle: %w", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(v.RekorKey)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("encoding Rekor key: %w", err)
+	}
+	if logID := sha256.Sum256(der); bundle.Payload.LogID != hex.EncodeToString(logID[:]) {
+		return time.Time{}, fmt.Errorf("signature is recorded in log %s, not that of the Rekor key", bundle.Payload.LogID)
+	}
+	signed, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := VerifyPayload(v.RekorKey, signed, bundle.SignedEntryTimestamp); err != nil {
+		return time.Time{}, fmt.Errorf("verifying Rekor bundle: %w", err)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding Rekor entry: %w", err)
+	}
+	var entry hashedRekord
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("parsing Rekor entry: %w", err)
+	}
+	h := sha256.Sum256(payload)
+	switch {
+	case entry.Kind != "hashedrekord":
+		return time.Time{}, fmt.Errorf("unsupported Rekor entry kind %q", entry.Kind)
+	case entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(h[:]):
+		return time.Time{}, errors.New("Rekor entry is for another payload")
+	case !bytes.Equal(entry.Spec.Signature.Content, sig):
+		return time.Time{}, errors.New("Rekor entry is for another signature")
+	}
+	block, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+	if block == nil || !bytes.Equal(block.Bytes, cert.Raw) {
+		return time.Time{}, errors.New("Rekor entry is for another certificate")
+	}
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// certIdentities returns the subject alternative names of cert that Fulcio
+// issues certificates to.
+func certIdentities(cert *x509.Certificate) []string {
+	ids := slices.Clone(cert.EmailAddresses)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
+
+// certIssuer returns the OIDC issuer Fulcio recorded in cert.
+func certIssuer(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV2) {
+			var issuer string
+			if _, err := asn1.UnmarshalWithParams(ext.Value, &issuer, "utf8"); err != nil {
+				return "", fmt.Errorf("parsing certificate issuer: %w", err)
+			}
+			return issuer, nil
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV1) {
+			return string(ext.Value), nil
+		}
+	}
+	return "", errors.New("certificate has no OIDC issuer")
+}
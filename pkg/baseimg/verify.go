@@ -0,0 +1,397 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseimg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	ocitypes "github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// The annotation cosign stores the signature of each payload layer under.
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// signatureArtifactType is the artifact type of cosign signatures stored as
+// referrers of what they sign.
+const signatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+// simpleSigning is the payload cosign signs, as far as it is checked here.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// LoadPublicKey reads a PEM encoded public key, as written by cosign
+// generate-key-pair.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading public key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// LoadCertificates reads the PEM encoded certificates of a certificate
+// authority, such as Fulcio's, at path: the self-signed ones are roots, and
+// the others intermediates.
+func LoadCertificates(path string) (roots, intermediates *x509.CertPool, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading certificates: %w", err)
+	}
+	certs, err := parseCertificates(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing certificates %s: %w", path, err)
+	}
+	roots, intermediates = x509.NewCertPool(), x509.NewCertPool()
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil {
+			roots.AddCert(c)
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	if roots.Equal(x509.NewCertPool()) {
+		return nil, nil, fmt.Errorf("%s has no root certificate", path)
+	}
+	return roots, intermediates, nil
+}
+
+// parseCertificates parses the PEM encoded certificates in b.
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+	return certs, nil
+}
+
+// A Verifier checks cosign signatures, made either with the private key of
+// Key or, without a key, with a short-lived Fulcio certificate issued to
+// Identity by Issuer and recorded in a Rekor log.
+type Verifier struct {
+	// Key is the public key signatures must be made with. When it is nil,
+	// signatures are verified keyless.
+	Key crypto.PublicKey
+	// Identity is the subject alternative name, an email address or URI,
+	// that keyless certificates must be issued to.
+	Identity string
+	// Issuer is the OIDC issuer that must have vouched for Identity.
+	Issuer string
+	// Roots and Intermediates are the Fulcio certificate authorities that
+	// keyless certificates must chain to.
+	Roots, Intermediates *x509.CertPool
+	// RekorKey is the public key of the Rekor log that keyless signatures
+	// must be recorded in. The time the log recorded the signature at is
+	// when the certificate must have been valid.
+	RekorKey crypto.PublicKey
+}
+
+// VerifySignature checks that the image index in the OCI layout at imgPath
+// was signed with cosign as v requires, with the signature saved alongside
+// it in the layout, as cosign save does. Every blob of the layout is
+// checked against the digest and size it is referred to by first, as the
+// layout is read without verifying them.
+func (v *Verifier) VerifySignature(imgPath string) error {
+	if err := verifyLayout(imgPath); err != nil {
+		return err
+	}
+	root, err := layout.ImageIndexFromPath(imgPath)
+	if err != nil {
+		return err
+	}
+	rootManifest, err := root.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	var subject v1.Hash
+	for _, m := range rootManifest.Manifests {
+		if m.MediaType == ocitypes.OCIImageIndex {
+			subject = m.Digest
+			break
+		}
+	}
+	if subject == (v1.Hash{}) {
+		return fmt.Errorf("no image index to verify in %s", imgPath)
+	}
+
+	var sigs []v1.Image
+	for _, m := range rootManifest.Manifests {
+		if !m.MediaType.IsImage() {
+			continue
+		}
+		img, err := root.Image(m.Digest)
+		if err != nil {
+			return err
+		}
+		sigs = append(sigs, img)
+	}
+	return v.verifyImages(sigs, subject, imgPath)
+}
+
+// VerifyRemote checks that ref was signed with cosign as v requires, with
+// the signature in its repository: either under the tag cosign signs with,
+// sha256-<hex>.sig, or as a referrer of artifact type
+// application/vnd.dev.cosign.artifact.sig.v1+json, as apko publish
+// attaches it. The registry verifies what it serves against the digests it
+// is asked for.
+func (v *Verifier) VerifyRemote(ctx context.Context, ref name.Digest, opts ...remote.Option) error {
+	opts = append(opts, remote.WithContext(ctx))
+	subject, err := v1.NewHash(ref.DigestStr())
+	if err != nil {
+		return err
+	}
+
+	var sigs []v1.Image
+	tag := ref.Context().Tag(fmt.Sprintf("%s-%s.sig", subject.Algorithm, subject.Hex))
+	img, err := remote.Image(tag, opts...)
+	var terr *transport.Error
+	switch {
+	case errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound:
+	case err != nil:
+		return fmt.Errorf("fetching signature %s: %w", tag, err)
+	default:
+		sigs = append(sigs, img)
+	}
+
+	referrers, err := remote.Referrers(ref, append(opts, remote.WithFilter("artifactType", signatureArtifactType))...)
+	if err != nil {
+		return fmt.Errorf("listing signatures of %s: %w", ref, err)
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, m := range manifest.Manifests {
+		img, err := remote.Image(ref.Context().Digest(m.Digest.String()), opts...)
+		if err != nil {
+			return fmt.Errorf("fetching signature %s: %w", m.Digest, err)
+		}
+		sigs = append(sigs, img)
+	}
+	return v.verifyImages(sigs, subject, ref.Context().String())
+}
+
+// verifyImages checks that one of the payloads of the cosign signature
+// images sigs, found in where, is a valid signature of subject.
+func (v *Verifier) verifyImages(sigs []v1.Image, subject v1.Hash, where string) error {
+	var errs []error
+	for _, img := range sigs {
+		manifest, err := img.Manifest()
+		if err != nil {
+			return err
+		}
+		for _, l := range manifest.Layers {
+			sig, ok := l.Annotations[cosignSignatureAnnotation]
+			if !ok {
+				continue
+			}
+			err := v.verifyLayer(img, l, sig, subject)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no cosign signature for %s in %s", subject, where)
+	}
+	return fmt.Errorf("no valid cosign signature for %s in %s: %w", subject, where, errors.Join(errs...))
+}
+
+// verifyLayer checks the signature sig of the payload in the layer l of
+// img, and that the payload is for subject.
+func (v *Verifier) verifyLayer(img v1.Image, l v1.Descriptor, sig string, subject v1.Hash) error {
+	layer, err := img.LayerByDigest(l.Digest)
+	if err != nil {
+		return err
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	payload, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	rawSig, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if v.Key != nil {
+		err = VerifyPayload(v.Key, payload, rawSig)
+	} else {
+		err = v.verifyKeyless(payload, rawSig, l.Annotations)
+	}
+	if err != nil {
+		return err
+	}
+
+	var ss simpleSigning
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		return fmt.Errorf("parsing signed payload: %w", err)
+	}
+	if got := ss.Critical.Image.DockerManifestDigest; got != subject.String() {
+		return fmt.Errorf("signature is for %s", got)
+	}
+	return nil
+}
+
+// verifyLayout checks every blob reachable from the index of the OCI layout
+// at path against the digest and size of the descriptor referring to it.
+func verifyLayout(path string) error {
+	b, err := os.ReadFile(filepath.Join(path, "index.json"))
+	if err != nil {
+		return err
+	}
+	var index v1.IndexManifest
+	if err := json.Unmarshal(b, &index); err != nil {
+		return fmt.Errorf("parsing index.json of %s: %w", path, err)
+	}
+	seen := map[v1.Hash]bool{}
+	var verify func(d v1.Descriptor) error
+	verify = func(d v1.Descriptor) error {
+		if seen[d.Digest] {
+			return nil
+		}
+		seen[d.Digest] = true
+		if d.Digest.Algorithm != "sha256" {
+			return fmt.Errorf("blob %s: unsupported digest algorithm", d.Digest)
+		}
+		f, err := os.Open(filepath.Join(path, "blobs", d.Digest.Algorithm, d.Digest.Hex))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		var body bytes.Buffer
+		w := io.Writer(h)
+		// Manifests are kept to find the blobs they refer to.
+		if d.MediaType.IsIndex() || d.MediaType.IsImage() {
+			w = io.MultiWriter(h, &body)
+		}
+		n, err := io.Copy(w, f)
+		if err != nil {
+			return fmt.Errorf("reading blob %s: %w", d.Digest, err)
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != d.Digest.Hex || n != d.Size {
+			return fmt.Errorf("blob %s has digest sha256:%s and size %d, expected size %d", d.Digest, got, n, d.Size)
+		}
+		switch {
+		case d.MediaType.IsIndex():
+			var m v1.IndexManifest
+			if err := json.Unmarshal(body.Bytes(), &m); err != nil {
+				return fmt.Errorf("parsing index %s: %w", d.Digest, err)
+			}
+			for _, c := range m.Manifests {
+				if err := verify(c); err != nil {
+					return err
+				}
+			}
+		case d.MediaType.IsImage():
+			var m v1.Manifest
+			if err := json.Unmarshal(body.Bytes(), &m); err != nil {
+				return fmt.Errorf("parsing manifest %s: %w", d.Digest, err)
+			}
+			for _, c := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+				if err := verify(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, d := range index.Manifests {
+		if err := verify(d); err != nil {
+			return fmt.Errorf("verifying layout %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// VerifyPayload checks that sig is a signature of payload made with the
+// private key of pub, as cosign makes them: over the SHA-256 digest of
+// payload for ECDSA and RSA keys, and over payload itself for Ed25519 keys.
+func VerifyPayload(pub crypto.PublicKey, payload, sig []byte) error {
+	h := sha256.Sum256(payload)
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, h[:], sig) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
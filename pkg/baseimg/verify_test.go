@@ -0,0 +1,327 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseimg
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	ocitypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+// A signer signs a cosign payload. It returns the signature, and the other
+// annotations of the layer holding it.
+type signer func(t *testing.T, payload []byte) ([]byte, map[string]string)
+
+// keySigner signs with key.
+func keySigner(key *ecdsa.PrivateKey) signer {
+	return func(t *testing.T, payload []byte) ([]byte, map[string]string) {
+		h := sha256.Sum256(payload)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+		require.NoError(t, err)
+		return sig, nil
+	}
+}
+
+// signatureImage returns a cosign signature image of a payload for digest,
+// signed by sign.
+func signatureImage(t *testing.T, sign signer, digest string) v1.Image {
+	payload := fmt.Appendf(nil, `{"critical":{"identity":{"docker-reference":"example.com/base"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, digest)
+	sig, annotations := sign(t, payload)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[cosignSignatureAnnotation] = base64.StdEncoding.EncodeToString(sig)
+	sigs, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: annotations,
+	})
+	require.NoError(t, err)
+	return mutate.MediaType(sigs, ocitypes.OCIManifestSchema1)
+}
+
+// signedLayout writes an OCI layout with an image index and a cosign
+// signature of it made by sign, over a payload for signedDigest if set.
+func signedLayout(t *testing.T, sign signer, signedDigest string) string {
+	dir := t.TempDir()
+	idx, err := random.Index(16, 1, 1)
+	require.NoError(t, err)
+	digest, err := idx.Digest()
+	require.NoError(t, err)
+	if signedDigest == "" {
+		signedDigest = digest.String()
+	}
+
+	p, err := layout.Write(dir, empty.Index)
+	require.NoError(t, err)
+	require.NoError(t, p.AppendIndex(idx))
+	require.NoError(t, p.AppendImage(signatureImage(t, sign, signedDigest), layout.WithAnnotations(map[string]string{
+		"kind": "dev.cosignproject.cosign/sigs",
+	})))
+	return dir
+}
+
+// writePublicKey writes pub to a PEM file and returns its path.
+func writePublicKey(t *testing.T, pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pub")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644))
+	return path
+}
+
+func TestVerifySignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	pub, err := LoadPublicKey(writePublicKey(t, &key.PublicKey))
+	require.NoError(t, err)
+	v := &Verifier{Key: pub}
+
+	require.NoError(t, v.VerifySignature(signedLayout(t, keySigner(key), "")))
+
+	err = v.VerifySignature(signedLayout(t, keySigner(other), ""))
+	require.ErrorContains(t, err, "invalid signature")
+
+	err = v.VerifySignature(signedLayout(t, keySigner(key), "sha256:"+fmt.Sprintf("%064d", 0)))
+	require.ErrorContains(t, err, "signature is for sha256:0000")
+
+	unsigned := t.TempDir()
+	idx, err := random.Index(16, 1, 1)
+	require.NoError(t, err)
+	p, err := layout.Write(unsigned, empty.Index)
+	require.NoError(t, err)
+	require.NoError(t, p.AppendIndex(idx))
+	require.ErrorContains(t, v.VerifySignature(unsigned), "no cosign signature")
+}
+
+func TestVerifySignatureTamperedBlob(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	v := &Verifier{Key: &key.PublicKey}
+	dir := signedLayout(t, keySigner(key), "")
+	require.NoError(t, v.VerifySignature(dir))
+
+	// The signature only covers the index, whose manifests refer to the
+	// layers by digest: a replaced layer must be caught.
+	p, err := layout.FromPath(dir)
+	require.NoError(t, err)
+	root, err := p.ImageIndex()
+	require.NoError(t, err)
+	m, err := root.IndexManifest()
+	require.NoError(t, err)
+	idx, err := root.ImageIndex(m.Manifests[0].Digest)
+	require.NoError(t, err)
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	img, err := idx.Image(im.Manifests[0].Digest)
+	require.NoError(t, err)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	h, err := layers[0].Digest()
+	require.NoError(t, err)
+	blob := filepath.Join(dir, "blobs", h.Algorithm, h.Hex)
+	b, err := os.ReadFile(blob)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	require.NoError(t, os.WriteFile(blob, b, 0o644))
+
+	require.ErrorContains(t, v.VerifySignature(dir), "blob "+h.String()+" has digest")
+}
+
+// sigstore is a fake Fulcio certificate authority and Rekor log.
+type sigstore struct {
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	rekor *ecdsa.PrivateKey
+}
+
+func newSigstore(t *testing.T) *sigstore {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio"},
+		NotBefore:             time.Now().Add(-48 * time.Hour),
+		NotAfter:              time.Now().Add(48 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	rekor, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &sigstore{ca: ca, caKey: caKey, rekor: rekor}
+}
+
+// verifier returns the verifier of keyless signatures by identity and
+// issuer with the roots of trust of s, loaded from files.
+func (s *sigstore) verifier(t *testing.T, identity, issuer string) *Verifier {
+	rootsPath := filepath.Join(t.TempDir(), "fulcio.pem")
+	require.NoError(t, os.WriteFile(rootsPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Raw}), 0o644))
+	roots, intermediates, err := LoadCertificates(rootsPath)
+	require.NoError(t, err)
+	rekor, err := LoadPublicKey(writePublicKey(t, &s.rekor.PublicKey))
+	require.NoError(t, err)
+	return &Verifier{Identity: identity, Issuer: issuer, Roots: roots, Intermediates: intermediates, RekorKey: rekor}
+}
+
+// signer returns a signer that signs keyless, with a certificate issued to
+// identity by issuer that is only valid for minutes after issued, and has
+// its signatures recorded in the log at logged.
+func (s *sigstore) signer(identity, issuer string, issued, logged time.Time) signer {
+	return func(t *testing.T, payload []byte) ([]byte, map[string]string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		ext, err := asn1.MarshalWithParams(issuer, "utf8")
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber:    big.NewInt(2),
+			NotBefore:       issued,
+			NotAfter:        issued.Add(10 * time.Minute),
+			EmailAddresses:  []string{identity},
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: ext}},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, s.ca, &key.PublicKey, s.caKey)
+		require.NoError(t, err)
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+		h := sha256.Sum256(payload)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+		require.NoError(t, err)
+
+		var entry hashedRekord
+		entry.Kind = "hashedrekord"
+		entry.Spec.Data.Hash.Algorithm = "sha256"
+		entry.Spec.Data.Hash.Value = hex.EncodeToString(h[:])
+		entry.Spec.Signature.Content = sig
+		entry.Spec.Signature.PublicKey.Content = certPEM
+		body, err := json.Marshal(entry)
+		require.NoError(t, err)
+		rekorDER, err := x509.MarshalPKIXPublicKey(&s.rekor.PublicKey)
+		require.NoError(t, err)
+		logID := sha256.Sum256(rekorDER)
+		bundle := rekorBundle{Payload: rekorPayload{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: logged.Unix(),
+			LogID:          hex.EncodeToString(logID[:]),
+			LogIndex:       42,
+		}}
+		signed, err := json.Marshal(bundle.Payload)
+		require.NoError(t, err)
+		sh := sha256.Sum256(signed)
+		bundle.SignedEntryTimestamp, err = ecdsa.SignASN1(rand.Reader, s.rekor, sh[:])
+		require.NoError(t, err)
+		b, err := json.Marshal(bundle)
+		require.NoError(t, err)
+
+		return sig, map[string]string{
+			cosignCertificateAnnotation: string(certPEM),
+			cosignBundleAnnotation:      string(b),
+		}
+	}
+}
+
+func TestVerifySignatureKeyless(t *testing.T) {
+	const identity, issuer = "builder@example.com", "https://accounts.example.com"
+	s := newSigstore(t)
+	v := s.verifier(t, identity, issuer)
+	// The certificate expired long ago, but was valid when logged.
+	logged := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+	require.NoError(t, v.VerifySignature(signedLayout(t, s.signer(identity, issuer, logged, logged), "")))
+
+	err := v.VerifySignature(signedLayout(t, s.signer("someone@example.com", issuer, logged, logged), ""))
+	require.ErrorContains(t, err, "not builder@example.com")
+
+	err = v.VerifySignature(signedLayout(t, s.signer(identity, "https://other.example.com", logged, logged), ""))
+	require.ErrorContains(t, err, "not https://accounts.example.com")
+
+	// Signed by another authority and logged elsewhere.
+	other := newSigstore(t)
+	err = v.VerifySignature(signedLayout(t, other.signer(identity, issuer, logged, logged), ""))
+	require.ErrorContains(t, err, "not that of the Rekor key")
+
+	// Logged after the certificate expired.
+	err = v.VerifySignature(signedLayout(t, s.signer(identity, issuer, logged, logged.Add(time.Hour)), ""))
+	require.ErrorContains(t, err, "certificate has expired")
+
+	err = v.VerifySignature(signedLayout(t, keySigner(s.rekor), ""))
+	require.ErrorContains(t, err, "signature has no certificate")
+}
+
+func TestVerifyRemote(t *testing.T) {
+	ctx := t.Context()
+	srv := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer srv.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(srv.URL, "http://") + "/keys")
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	v := &Verifier{Key: &key.PublicKey}
+
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	h, err := img.Digest()
+	require.NoError(t, err)
+	ref := repo.Digest(h.String())
+	require.NoError(t, remote.Write(ref, img))
+
+	require.ErrorContains(t, v.VerifyRemote(ctx, ref), "no cosign signature")
+
+	// cosign sign stores the signature under a tag derived from the digest.
+	require.NoError(t, remote.Write(repo.Tag(fmt.Sprintf("sha256-%s.sig", h.Hex)), signatureImage(t, keySigner(key), h.String())))
+	require.NoError(t, v.VerifyRemote(ctx, ref))
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	require.ErrorContains(t, (&Verifier{Key: &other.PublicKey}).VerifyRemote(ctx, ref), "invalid signature")
+}
@@ -17,10 +17,16 @@ package build
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"strings"
 
+	"github.com/chainguard-dev/clog"
 	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/errgroup"
 	"k8s.io/apimachinery/pkg/util/sets"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/types"
 )
 
 func (bc *Context) postBuildSetApk(ctx context.Context) error {
@@ -34,6 +40,16 @@ func (bc *Context) postBuildSetApk(ctx context.Context) error {
 		sets.New(bc.ic.Contents.Repositories...).
 			Insert(bc.ic.Contents.RuntimeOnlyRepositories...).
 			Insert(bc.o.ExtraRepos...))
+	if len(runtimeRepos) == 0 {
+		// Images without packages may have no repositories at all, which
+		// SetRepositories rejects. Reset the file to the empty one InitDB
+		// creates instead.
+		// #nosec G306 -- apk repositories must be publicly readable
+		if err := bc.fs.WriteFile(filepath.Join("etc", "apk", "repositories"), []byte("\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to set apk repositories: %w", err)
+		}
+		return nil
+	}
 	if err := bc.apk.SetRepositories(ctx, runtimeRepos); err != nil {
 		return fmt.Errorf("failed to set apk repositories: %w", err)
 	}
@@ -41,6 +57,28 @@ func (bc *Context) postBuildSetApk(ctx context.Context) error {
 	return nil
 }
 
+// mergePackages splits the version ranges of packages into one constraint
+// per bound, merges the constraints on packages that are requested more
+// than once, e.g. directly and by an include, and reports the constraints
+// that take effect for each.
+func mergePackages(ctx context.Context, packages []string) ([]string, error) {
+	packages, err := types.SplitPackageConstraints(packages)
+	if err != nil {
+		return nil, err
+	}
+	merged, merges, err := apk.MergeConstraints(packages)
+	if err != nil {
+		return nil, fmt.Errorf("merging package constraints: %w", err)
+	}
+	if len(merges) == 0 {
+		return packages, nil
+	}
+	for _, m := range merges {
+		clog.FromContext(ctx).Infof("package %s is requested as %s; using %s", m.Name, strings.Join(m.Requested, ", "), strings.Join(m.Effective, ", "))
+	}
+	return merged, nil
+}
+
 func (bc *Context) initializeApk(ctx context.Context) error {
 	ctx, span := otel.Tracer("apko").Start(ctx, "initializeApk")
 	defer span.End()
@@ -64,7 +102,11 @@ func (bc *Context) initializeApk(ctx context.Context) error {
 	var eg errgroup.Group
 
 	eg.Go(func() error {
-		keyring := sets.List(sets.New(bc.ic.Contents.Keyring...).Insert(bc.o.ExtraKeyFiles...))
+		keyring := sets.List(sets.New(bc.ic.Contents.KeyringFor(bc.Arch())...).Insert(bc.o.ExtraKeyFiles...))
+		keyring, err := bc.fetchOCIKeys(ctx, keyring)
+		if err != nil {
+			return fmt.Errorf("failed to initialize apk keyring: %w", err)
+		}
 		if err := bc.apk.InitKeyring(ctx, keyring, nil); err != nil {
 			return fmt.Errorf("failed to initialize apk keyring: %w", err)
 		}
@@ -76,6 +118,10 @@ func (bc *Context) initializeApk(ctx context.Context) error {
 		if bc.baseimg != nil {
 			buildRepos = append(buildRepos, bc.baseimg.APKIndexPath())
 		}
+		if len(buildRepos) == 0 {
+			// InitDB has already created an empty file.
+			return nil
+		}
 		if err := bc.apk.SetRepositories(ctx, buildRepos); err != nil {
 			return fmt.Errorf("failed to initialize apk repositories: %w", err)
 		}
@@ -83,7 +129,10 @@ func (bc *Context) initializeApk(ctx context.Context) error {
 	})
 
 	eg.Go(func() error {
-		packages := sets.List(sets.New(bc.ic.Contents.Packages...).Insert(bc.o.ExtraPackages...))
+		packages, err := mergePackages(ctx, sets.List(sets.New(bc.ic.Contents.Packages...).Insert(bc.o.ExtraPackages...)))
+		if err != nil {
+			return err
+		}
 		// Get all packages from base image and merge them into the desired world.
 		if bc.baseimg != nil {
 			basePkgs := bc.baseimg.InstalledPackages()
@@ -0,0 +1,123 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package build
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// Categories of build errors, by the phase of the build they come from.
+const (
+	ErrorCategoryResolve = "resolve"
+	ErrorCategoryInstall = "install"
+	ErrorCategoryCheck   = "check"
+	ErrorCategoryLayer   = "layer"
+	ErrorCategoryImage   = "image"
+	ErrorCategoryRemote  = "remote"
+	ErrorCategoryOther   = "other"
+)
+
+// categorizedError is an error marked with the category of the phase it
+// comes from. It reads the same as the error it wraps.
+type categorizedError struct {
+	category string
+	err      error
+}
+
+func (e *categorizedError) Error() string { return e.err.Error() }
+
+func (e *categorizedError) Unwrap() error { return e.err }
+
+// CategorizeError marks err as coming from the phase of the build category
+// names, unless it is nil or already categorized.
+func CategorizeError(category string, err error) error {
+	if err == nil || ErrorCategory(err) != ErrorCategoryOther {
+		return err
+	}
+	return &categorizedError{category: category, err: err}
+}
+
+// ErrorCategory returns the category of the phase of the build err comes
+// from, or ErrorCategoryOther if it is not known.
+func ErrorCategory(err error) string {
+	var ce *categorizedError
+	if errors.As(err, &ce) {
+		return ce.category
+	}
+	return ErrorCategoryOther
+}
+
+// ArchError is the failure to build one architecture.
+type ArchError struct {
+	Arch types.Architecture
+	// Category is one of the ErrorCategory constants.
+	Category string
+	Err      error
+}
+
+// NewArchError returns the failure err to build arch, with its category.
+func NewArchError(arch types.Architecture, err error) *ArchError {
+	return &ArchError{Arch: arch, Category: ErrorCategory(err), Err: err}
+}
+
+func (e *ArchError) Error() string {
+	return fmt.Sprintf("%s (%s): %v", e.Arch, e.Category, e.Err)
+}
+
+func (e *ArchError) Unwrap() error { return e.Err }
+
+// MultiArchError holds the failures of every architecture that failed to
+// build, rather than only the first, sorted by architecture.
+type MultiArchError struct {
+	Errors []*ArchError
+}
+
+// NewMultiArchError returns a MultiArchError of errs, or nil if there are
+// none.
+func NewMultiArchError(errs []*ArchError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	errs = slices.Clone(errs)
+	slices.SortFunc(errs, func(a, b *ArchError) int { return strings.Compare(a.Arch.String(), b.Arch.String()) })
+	return &MultiArchError{Errors: errs}
+}
+
+func (e *MultiArchError) Error() string {
+	if len(e.Errors) == 1 {
+		// A single failure reads as it did before failures were
+		// aggregated, naming its architecture.
+		return fmt.Sprintf("for arch %q: %v", e.Errors[0].Arch, e.Errors[0].Err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d architectures failed:", len(e.Errors))
+	for _, err := range e.Errors {
+		b.WriteString("\n")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *MultiArchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
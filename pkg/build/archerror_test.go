@@ -0,0 +1,53 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestMultiArchError(t *testing.T) {
+	require.NoError(t, NewMultiArchError(nil))
+
+	resolve := CategorizeError(ErrorCategoryResolve, fmt.Errorf("solving world: %w", fs.ErrNotExist))
+	require.Equal(t, "solving world: file does not exist", resolve.Error())
+	require.Equal(t, ErrorCategoryResolve, ErrorCategory(fmt.Errorf("building layer: %w", resolve)))
+	// A categorized error keeps its category.
+	require.Equal(t, ErrorCategoryResolve, ErrorCategory(CategorizeError(ErrorCategoryLayer, resolve)))
+	require.Equal(t, ErrorCategoryOther, ErrorCategory(errors.New("boom")))
+	require.NoError(t, CategorizeError(ErrorCategoryCheck, nil))
+
+	err := NewMultiArchError([]*ArchError{
+		NewArchError(types.ParseArchitecture("x86_64"), resolve),
+		NewArchError(types.ParseArchitecture("aarch64"), errors.New("boom")),
+	})
+	require.EqualError(t, err, "2 architectures failed:\namd64 (resolve): solving world: file does not exist\narm64 (other): boom")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	var merr *MultiArchError
+	require.ErrorAs(t, fmt.Errorf("building layers: %w", err), &merr)
+	require.Len(t, merr.Errors, 2)
+	require.Equal(t, "amd64", merr.Errors[0].Arch.String())
+	require.Equal(t, ErrorCategoryResolve, merr.Errors[0].Category)
+
+	// A single failure still names its architecture.
+	require.EqualError(t, NewMultiArchError(merr.Errors[:1]), `for arch "amd64": solving world: file does not exist`)
+}
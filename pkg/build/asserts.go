@@ -0,0 +1,171 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/passwd"
+)
+
+// Assertion types accepted in the asserts configuration.
+const (
+	AssertFileExists   = "file-exists"
+	AssertFileMode     = "file-mode"
+	AssertLinksAgainst = "links-against"
+	AssertUserExists   = "user-exists"
+	AssertEnvSet       = "env-set"
+)
+
+// checkAssertions evaluates the asserts of ic on the finished filesystem,
+// returning an error listing every one that does not hold.
+func checkAssertions(ctx context.Context, fsys fs.FS, ic *types.ImageConfiguration) error {
+	if len(ic.Asserts) == 0 {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	_, span := otel.Tracer("apko").Start(ctx, "checkAssertions")
+	defer span.End()
+
+	var errs []error
+	for i, a := range ic.Asserts {
+		if err := checkAssertion(fsys, ic, a); err != nil {
+			errs = append(errs, fmt.Errorf("asserts[%d] (%s): %w", i, a.Type, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("image assertions failed:\n%w", err)
+	}
+	log.Infof("all %d image assertions hold", len(ic.Asserts))
+	return nil
+}
+
+func checkAssertion(fsys fs.FS, ic *types.ImageConfiguration, a types.Assertion) error {
+	path := strings.TrimPrefix(a.Path, "/")
+	if path == "" {
+		path = "."
+	}
+
+	switch a.Type {
+	case AssertFileExists:
+		if a.Path == "" {
+			return errors.New("path is required")
+		}
+		if _, err := fs.Stat(fsys, path); err != nil {
+			return fmt.Errorf("%s does not exist", a.Path)
+		}
+
+	case AssertFileMode:
+		if a.Path == "" || a.Permissions == nil {
+			return errors.New("path and permissions are required")
+		}
+		fi, err := fs.Stat(fsys, path)
+		if err != nil {
+			return fmt.Errorf("%s does not exist", a.Path)
+		}
+		if got := unixPermissions(fi.Mode()); got != *a.Permissions {
+			return fmt.Errorf("%s has permissions %#o, expected %#o", a.Path, got, *a.Permissions)
+		}
+
+	case AssertLinksAgainst:
+		if a.Path == "" || (len(a.Libraries) == 0 && a.Count == nil) {
+			return errors.New("path and libraries or count are required")
+		}
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", a.Path, err)
+		}
+		f, err := elf.NewFile(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("%s is not an ELF binary: %w", a.Path, err)
+		}
+		needed, err := f.ImportedLibraries()
+		if err != nil {
+			return fmt.Errorf("reading the libraries %s links against: %w", a.Path, err)
+		}
+		var missing []string
+		for _, lib := range a.Libraries {
+			if !slices.Contains(needed, lib) {
+				missing = append(missing, lib)
+			}
+		}
+		if len(missing) != 0 {
+			return fmt.Errorf("%s does not link against %v, only %v", a.Path, missing, needed)
+		}
+		if a.Count != nil && len(needed) != *a.Count {
+			return fmt.Errorf("%s links against %d libraries %v, expected %d", a.Path, len(needed), needed, *a.Count)
+		}
+
+	case AssertUserExists:
+		if a.User == "" {
+			return errors.New("user is required")
+		}
+		uf, err := passwd.ReadUserFile(fsys, "etc/passwd")
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(uf.Entries, func(e passwd.UserEntry) bool { return e.UserName == a.User }) {
+			return fmt.Errorf("user %s does not exist", a.User)
+		}
+
+	case AssertEnvSet:
+		if a.Name == "" {
+			return errors.New("name is required")
+		}
+		v, ok := ic.Environment[a.Name]
+		if !ok {
+			v, ok = oci.DefaultEnvironment[a.Name]
+		}
+		if !ok {
+			return fmt.Errorf("%s is not set", a.Name)
+		}
+		if a.Value != nil && v != *a.Value {
+			return fmt.Errorf("%s is %q, expected %q", a.Name, v, *a.Value)
+		}
+
+	default:
+		return fmt.Errorf("unknown assertion type, must be one of %v", []string{AssertFileExists, AssertFileMode, AssertLinksAgainst, AssertUserExists, AssertEnvSet})
+	}
+	return nil
+}
+
+// unixPermissions converts the permission bits of m, including the setuid,
+// setgid and sticky bits, to their traditional octal form.
+func unixPermissions(m fs.FileMode) uint32 {
+	perm := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+	return perm
+}
@@ -0,0 +1,151 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// testELF returns a minimal 64-bit ELF file whose dynamic section names
+// needed as DT_NEEDED entries.
+func testELF(t *testing.T, needed ...string) []byte {
+	t.Helper()
+	return testELFWithRunPath(t, "", needed...)
+}
+
+// testELFWithRunPath is like testELF, with a DT_RUNPATH entry if runPath is
+// set.
+func testELFWithRunPath(t *testing.T, runPath string, needed ...string) []byte {
+	t.Helper()
+
+	dynstr := []byte{0}
+	var dynamic []elf.Dyn64
+	for _, lib := range needed {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NEEDED), Val: uint64(len(dynstr))})
+		dynstr = append(append(dynstr, lib...), 0)
+	}
+	if runPath != "" {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_RUNPATH), Val: uint64(len(dynstr))})
+		dynstr = append(append(dynstr, runPath...), 0)
+	}
+	dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+	shstrtab := []byte("\x00.dynstr\x00.dynamic\x00.shstrtab\x00")
+
+	var body bytes.Buffer
+	align := func() {
+		for body.Len()%8 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	const headerSize = 64
+	dynstrOff := headerSize + body.Len()
+	body.Write(dynstr)
+	align()
+	dynamicOff := headerSize + body.Len()
+	require.NoError(t, binary.Write(&body, binary.LittleEndian, dynamic))
+	shstrtabOff := headerSize + body.Len()
+	body.Write(shstrtab)
+	align()
+	shOff := headerSize + body.Len()
+
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: uint64(dynstrOff), Size: uint64(len(dynstr)), Addralign: 1},
+		{Name: 9, Type: uint32(elf.SHT_DYNAMIC), Off: uint64(dynamicOff), Size: uint64(16 * len(dynamic)), Link: 1, Addralign: 8, Entsize: 16},
+		{Name: 18, Type: uint32(elf.SHT_STRTAB), Off: uint64(shstrtabOff), Size: uint64(len(shstrtab)), Addralign: 1},
+	}
+	require.NoError(t, binary.Write(&body, binary.LittleEndian, sections))
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(shOff),
+		Ehsize:    headerSize,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  3,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var out bytes.Buffer
+	require.NoError(t, binary.Write(&out, binary.LittleEndian, hdr))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+func TestCheckAssertions(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"etc/passwd":       {Data: []byte("root:x:0:0:root:/root:/bin/sh\nnonroot:x:65532:65532::/home/nonroot:/bin/sh\n")},
+		"usr/bin/app":      {Data: testELF(t, "libc.so.6", "libssl.so.3"), Mode: 0o755},
+		"usr/bin/static":   {Data: testELF(t), Mode: 0o755},
+		"usr/bin/su":       {Data: []byte("#!/bin/sh\n"), Mode: 0o755 | fs.ModeSetuid},
+		"etc/app/app.conf": {Data: []byte("key=value\n"), Mode: 0o644},
+	}
+	ic := &types.ImageConfiguration{Environment: map[string]string{"APP_MODE": "production"}}
+
+	perm := func(p uint32) *uint32 { return &p }
+	count := func(n int) *int { return &n }
+	value := func(v string) *string { return &v }
+
+	for _, c := range []struct {
+		desc    string
+		assert  types.Assertion
+		wantErr string
+	}{
+		{"file exists", types.Assertion{Type: AssertFileExists, Path: "/etc/app/app.conf"}, ""},
+		{"file missing", types.Assertion{Type: AssertFileExists, Path: "/etc/app/other.conf"}, "/etc/app/other.conf does not exist"},
+		{"file mode", types.Assertion{Type: AssertFileMode, Path: "/etc/app/app.conf", Permissions: perm(0o644)}, ""},
+		{"setuid mode", types.Assertion{Type: AssertFileMode, Path: "/usr/bin/su", Permissions: perm(0o4755)}, ""},
+		{"wrong mode", types.Assertion{Type: AssertFileMode, Path: "/usr/bin/su", Permissions: perm(0o755)}, "has permissions 04755, expected 0755"},
+		{"links against", types.Assertion{Type: AssertLinksAgainst, Path: "/usr/bin/app", Libraries: []string{"libssl.so.3"}, Count: count(2)}, ""},
+		{"missing library", types.Assertion{Type: AssertLinksAgainst, Path: "/usr/bin/app", Libraries: []string{"libcrypto.so.3"}}, "does not link against [libcrypto.so.3]"},
+		{"static", types.Assertion{Type: AssertLinksAgainst, Path: "/usr/bin/static", Count: count(0)}, ""},
+		{"not static", types.Assertion{Type: AssertLinksAgainst, Path: "/usr/bin/app", Count: count(0)}, "links against 2 libraries"},
+		{"not elf", types.Assertion{Type: AssertLinksAgainst, Path: "/usr/bin/su", Count: count(0)}, "is not an ELF binary"},
+		{"user exists", types.Assertion{Type: AssertUserExists, User: "nonroot"}, ""},
+		{"user missing", types.Assertion{Type: AssertUserExists, User: "www-data"}, "user www-data does not exist"},
+		{"env set", types.Assertion{Type: AssertEnvSet, Name: "APP_MODE", Value: value("production")}, ""},
+		{"default env", types.Assertion{Type: AssertEnvSet, Name: "PATH"}, ""},
+		{"env wrong", types.Assertion{Type: AssertEnvSet, Name: "APP_MODE", Value: value("debug")}, `APP_MODE is "production", expected "debug"`},
+		{"env unset", types.Assertion{Type: AssertEnvSet, Name: "HOME"}, "HOME is not set"},
+		{"incomplete", types.Assertion{Type: AssertFileMode, Path: "/usr/bin/su"}, "path and permissions are required"},
+		{"unknown", types.Assertion{Type: "file-owner"}, "unknown assertion type"},
+	} {
+		t.Run(c.desc, func(t *testing.T) {
+			ic.Asserts = []types.Assertion{c.assert}
+			err := checkAssertions(ctx, fsys, ic)
+			if c.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, c.wantErr)
+		})
+	}
+}
@@ -15,12 +15,16 @@
 package build
 
 import (
+	"archive/tar"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -33,7 +37,6 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	v1types "github.com/google/go-containerregistry/pkg/v1/types"
 	"go.opentelemetry.io/otel"
-	"gopkg.in/yaml.v3"
 
 	"github.com/chainguard-dev/clog"
 
@@ -47,7 +50,7 @@ import (
 )
 
 // compressionCache stores descriptor information for already-compressed layers,
-// keyed by diffID. This avoids recompressing identical layers.
+// keyed by diffID and compressor. This avoids recompressing identical layers.
 var compressionCache sync.Map // map[string]*v1.Descriptor
 
 // Context contains all of the information necessary to build an
@@ -64,6 +67,39 @@ type Context struct {
 	fs      apkfs.FullFS
 	apk     *apk.APK
 	baseimg *baseimg.BaseImage
+
+	extraLayers []ExtraLayer
+	scanHooks   []ScanHook
+	fsMutators  []FSMutator
+
+	// debugInfo is the layer of the debug files split from the image's ELF
+	// files, with WithSplitDebug.
+	debugInfo *layer
+
+	// resolved are the packages InstallPackages installed from the
+	// repositories, as they were fetched.
+	resolved []*apk.APKResolved
+
+	// inputPolicy is the input policy of WithInputPolicy, with its
+	// attestations verified, once loaded.
+	inputPolicy *thresholdPolicy
+
+	// sbomPrep is the SBOM preparation started by BuildLayers, for
+	// GenerateImageSBOM to finish.
+	sbomPrep *sbomPrep
+
+	// buildDateSet records that a build date was given explicitly, which
+	// takes precedence over one derived from git.
+	buildDateSet bool
+
+	// configFile is the configuration of WithConfig, looked up in
+	// includePaths. It is loaded once all of the options are applied, so
+	// that the remote configurations it includes are fetched as they say.
+	configFile   string
+	includePaths []string
+	// annotations are those of WithAnnotations, which take precedence over
+	// those of the configuration.
+	annotations map[string]string
 }
 
 func (bc *Context) Summarize(ctx context.Context) {
@@ -95,22 +131,11 @@ func (bc *Context) GetBuildDateEpoch() (time.Time, error) {
 }
 
 func (bc *Context) BuildImage(ctx context.Context) error {
-	log := clog.FromContext(ctx)
-
 	ctx, span := otel.Tracer("apko").Start(ctx, "BuildImage")
 	defer span.End()
 
-	if _, err := bc.buildImage(ctx); err != nil {
-		log.Debugf("buildImage failed: %v", err)
-		b, err2 := yaml.Marshal(bc.ic)
-		if err2 != nil {
-			log.Debugf("failed to marshal image configuration: %v", err2)
-		} else {
-			log.Debugf("image configuration:\n%s", string(b))
-		}
-		return err
-	}
-	return nil
+	_, err := bc.buildImage(ctx)
+	return err
 }
 
 // BuildLayer given the context set up, including
@@ -125,7 +150,7 @@ func (bc *Context) BuildLayer(ctx context.Context) (string, v1.Layer, error) {
 	defer span.End()
 
 	// Check if a non-empty layering strategy is supplied
-	if bc.ic.Layering != nil && (bc.ic.Layering.Strategy != "" || bc.ic.Layering.Budget != 0) {
+	if layered(bc.ic.Layering) {
 		return "", nil, fmt.Errorf("cannot use BuildLayer with a layering strategy, use BuildLayers instead")
 	}
 
@@ -140,24 +165,73 @@ func (bc *Context) BuildLayer(ctx context.Context) (string, v1.Layer, error) {
 	return bc.ImageLayoutToLayer(ctx)
 }
 
+// WriteLayerTo is like BuildLayer, but streams the layer to w as it is
+// written, as an uncompressed tarball, rather than keeping it on disk. It
+// returns the digest of the tarball, which is the layer's diffID. Callers
+// embedding apko can then compress and upload multi-gigabyte layers without
+// holding a copy of them.
+func (bc *Context) WriteLayerTo(ctx context.Context, w io.Writer) (v1.Hash, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "WriteLayerTo")
+	defer span.End()
+
+	if layered(bc.ic.Layering) {
+		return v1.Hash{}, fmt.Errorf("cannot use WriteLayerTo with a layering strategy, use BuildLayers instead")
+	}
+
+	if err := bc.BuildImage(ctx); err != nil {
+		return v1.Hash{}, err
+	}
+	if err := bc.postBuildSetApk(ctx); err != nil {
+		return v1.Hash{}, err
+	}
+
+	diffid := sha256.New()
+	buf := pooledBufioWriter(io.MultiWriter(diffid, w))
+	defer bufioPool.Put(buf)
+	if err := bc.WriteArchive(ctx, tar.NewWriter(buf)); err != nil {
+		return v1.Hash{}, err
+	}
+	if err := buf.Flush(); err != nil {
+		return v1.Hash{}, fmt.Errorf("writing layer: %w", err)
+	}
+	return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(diffid.Sum(nil))}, nil
+}
+
 // BuildLayers is like BuildLayer but has the potential to return multiple layers.
+// Any layers added with WithExtraLayers are included.
 func (bc *Context) BuildLayers(ctx context.Context) ([]v1.Layer, error) {
 	ctx, span := otel.Tracer("apko").Start(ctx, "BuildLayers")
 	defer span.End()
 
-	// Use the legacy (single-layer) strategy when:
-	// 1. Layering is nil (original behavior)
-	// 2. Layering is empty (i.e., layering: {})
-	if bc.ic.Layering == nil || (bc.ic.Layering.Strategy == "" && bc.ic.Layering.Budget == 0) {
-		_, layer, err := bc.BuildLayer(ctx)
-		if err != nil {
+	// Catch layerings that cannot be built before installing anything.
+	if layered(bc.ic.Layering) {
+		if err := bc.checkLayering(); err != nil {
 			return nil, err
 		}
+	}
 
-		return []v1.Layer{layer}, nil
+	inst, err := bc.buildImage(ctx)
+	if err != nil {
+		if layered(bc.ic.Layering) {
+			return nil, fmt.Errorf("building filesystem: %w", err)
+		}
+		return nil, err
+	}
+	// The SBOMs are mostly made from the installed packages, so most of the
+	// work can be done while the layers are written and compressed.
+	if bc.WantSBOM() {
+		bc.sbomPrep = bc.prepareImageSBOM(ctx)
+	}
+	layers, err := bc.Layerize(ctx, inst)
+	if err != nil {
+		return nil, CategorizeError(ErrorCategoryLayer, err)
 	}
+	return layers, nil
+}
 
-	return bc.buildLayers(ctx)
+// layered reports whether l asks for more than a single layer.
+func layered(l *types.Layering) bool {
+	return l != nil && (l.Strategy != "" || l.Budget != 0 || len(l.Layers) != 0)
 }
 
 // ImageLayoutToLayer given an already built-out
@@ -170,6 +244,9 @@ func (bc *Context) ImageLayoutToLayer(ctx context.Context) (string, v1.Layer, er
 	if err := bc.checkPaths(ctx); err != nil {
 		return "", nil, err
 	}
+	if err := bc.checkFilesystem(ctx); err != nil {
+		return "", nil, err
+	}
 
 	var (
 		outfile *os.File
@@ -187,9 +264,14 @@ func (bc *Context) ImageLayoutToLayer(ctx context.Context) (string, v1.Layer, er
 	bc.o.TarballPath = outfile.Name()
 	defer outfile.Close()
 
-	lw := newLayerWriter(outfile)
+	mtime, err := bc.fileTimestamp()
+	if err != nil {
+		return "", nil, err
+	}
+
+	lw := newLayerWriter(outfile, compressorFor(&bc.o))
 
-	if err := writeTar(ctx, lw.w, bc.fs); err != nil {
+	if err := writeArchive(ctx, lw.w, bc.fs, bc.o.IDMap, mtime, bc.ic.Contents.Exclude); err != nil {
 		return "", nil, fmt.Errorf("generating tarball: %w", err)
 	}
 
@@ -198,9 +280,41 @@ func (bc *Context) ImageLayoutToLayer(ctx context.Context) (string, v1.Layer, er
 		return "", nil, fmt.Errorf("finalizing layer: %w", err)
 	}
 
+	// Everything is in the one layer.
+	installed, err := bc.apk.GetInstalled()
+	if err != nil {
+		return "", nil, fmt.Errorf("reading installed packages: %w", err)
+	}
+	for _, pkg := range installed {
+		l.packages = append(l.packages, pkg.Name)
+	}
+
 	return outfile.Name(), l, nil
 }
 
+// WriteArchive writes the image filesystem, once built with BuildImage, to
+// aw, for output formats other than OCI layers. It runs the same checks as
+// ImageLayoutToLayer first, and closes aw.
+func (bc *Context) WriteArchive(ctx context.Context, aw ArchiveWriter) error {
+	ctx, span := otel.Tracer("apko").Start(ctx, "WriteArchive")
+	defer span.End()
+
+	if err := bc.checkPaths(ctx); err != nil {
+		return err
+	}
+	if err := bc.checkFilesystem(ctx); err != nil {
+		return err
+	}
+	mtime, err := bc.fileTimestamp()
+	if err != nil {
+		return err
+	}
+	if err := writeArchive(ctx, aw, bc.fs, bc.o.IDMap, mtime, bc.ic.Contents.Exclude); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	return nil
+}
+
 func (bc *Context) checkPaths(ctx context.Context) error {
 	log := clog.FromContext(ctx)
 
@@ -218,6 +332,50 @@ func (bc *Context) checkPaths(ctx context.Context) error {
 	return nil
 }
 
+// transportOptions are the options of the transport repositories and remote
+// configurations are fetched through.
+func (bc *Context) transportOptions() []apk.Option {
+	opts := []apk.Option{apk.WithTransport(bc.o.Transport)}
+	if bc.o.Dial != (apk.DialOptions{}) {
+		opts = append(opts, apk.WithDialOptions(bc.o.Dial))
+	}
+	if bc.o.CATrust != apk.CATrustDefault {
+		opts = append(opts, apk.WithCATrust(bc.o.CATrust))
+	}
+	return opts
+}
+
+// loadConfig loads the configuration of WithConfig, if any, fetching the
+// remote configurations it includes through the transport and with the
+// authenticator of the options, and adds the annotations of WithAnnotations
+// to it.
+func (bc *Context) loadConfig(ctx context.Context) error {
+	if bc.configFile != "" {
+		clog.FromContext(ctx).Debugf("loading config file: %s", bc.configFile)
+		transport, err := apk.NewTransport(bc.transportOptions()...)
+		if err != nil {
+			return err
+		}
+		var ic types.ImageConfiguration
+		hasher := sha256.New()
+		if err := ic.Load(ctx, bc.configFile, bc.includePaths, hasher, //nolint:staticcheck
+			types.WithIncludeClient(&http.Client{Transport: transport}),
+			types.WithIncludeAuthenticator(bc.o.Auth),
+		); err != nil {
+			return fmt.Errorf("failed to load image configuration: %w", err)
+		}
+		bc.ic = ic
+		bc.o.ImageConfigChecksum = "sha256-" + base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+	}
+	if len(bc.annotations) != 0 {
+		if bc.ic.Annotations == nil {
+			bc.ic.Annotations = make(map[string]string)
+		}
+		maps.Copy(bc.ic.Annotations, bc.annotations)
+	}
+	return nil
+}
+
 // NewOptions evaluates the build.Options in the same way as New().
 func NewOptions(opts ...Option) (*options.Options, *types.ImageConfiguration, error) {
 	bc := Context{
@@ -229,6 +387,27 @@ func NewOptions(opts ...Option) (*options.Options, *types.ImageConfiguration, er
 			return nil, nil, err
 		}
 	}
+	if err := bc.loadConfig(context.Background()); err != nil {
+		return nil, nil, err
+	}
+	if err := bc.checkRemoteWorkers(); err != nil {
+		return nil, nil, err
+	}
+	if err := bc.checkRuntimeCaches(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := bc.applyGitBuildDate(); err != nil {
+		return nil, nil, err
+	}
+	if err := bc.applyInputAnnotations(); err != nil {
+		return nil, nil, err
+	}
+	packages, err := mergePackages(context.Background(), bc.ic.Contents.Packages)
+	if err != nil {
+		return nil, nil, err
+	}
+	bc.ic.Contents.Packages = packages
 
 	return &bc.o, &bc.ic, nil
 }
@@ -252,6 +431,27 @@ func New(ctx context.Context, fs apkfs.FullFS, opts ...Option) (*Context, error)
 			return nil, err
 		}
 	}
+	if err := bc.loadConfig(ctx); err != nil {
+		return nil, err
+	}
+	if err := bc.checkRemoteWorkers(); err != nil {
+		return nil, err
+	}
+	if err := bc.checkRuntimeCaches(); err != nil {
+		return nil, err
+	}
+
+	if err := bc.applyGitBuildDate(); err != nil {
+		return nil, err
+	}
+	if err := bc.applyInputAnnotations(); err != nil {
+		return nil, err
+	}
+	packages, err := mergePackages(ctx, bc.ic.Contents.Packages)
+	if err != nil {
+		return nil, err
+	}
+	bc.ic.Contents.Packages = packages
 
 	// SOURCE_DATE_EPOCH will always overwrite the build flag
 	if v, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok && len(strings.TrimSpace(v)) != 0 {
@@ -274,14 +474,22 @@ func New(ctx context.Context, fs apkfs.FullFS, opts ...Option) (*Context, error)
 		bc.o.Arch = types.ParseArchitecture(runtime.GOARCH)
 	}
 
-	apkOpts := []apk.Option{
+	apkOpts := append(bc.transportOptions(),
 		apk.WithFS(bc.fs),
 		apk.WithArch(bc.o.Arch.ToAPK()),
 		apk.WithIgnoreMknodErrors(true),
 		apk.WithIgnoreIndexSignatures(bc.o.IgnoreSignatures),
 		apk.WithAuthenticator(bc.o.Auth),
-		apk.WithTransport(bc.o.Transport),
-	}
+		apk.WithMirrors(bc.ic.Contents.Mirrors),
+		apk.WithRateLimiter(bc.o.RateLimiter),
+		apk.WithBackoff(bc.o.Backoff),
+		apk.WithCacheNamespace(bc.o.CacheNamespace),
+		apk.WithLowerCache(bc.o.LowerCacheDir),
+		apk.WithCacheStore(bc.o.CacheStore),
+		apk.WithFileFilters(fileFilters(bc.ic.Contents.Filters)),
+		apk.WithRetryPolicy(bc.o.FetchRetry),
+		apk.WithClock(bc.o.Now),
+	)
 	// only try to pass the cache dir if one of the following is true:
 	// - the user has explicitly set a cache dir
 	// - the user's system-determined cachedir, as set by os.UserCacheDir(), can be found
@@ -308,6 +516,15 @@ func New(ctx context.Context, fs apkfs.FullFS, opts ...Option) (*Context, error)
 		if err != nil {
 			return nil, fmt.Errorf("baseImage apk path %s: %w", bc.ic.Contents.BaseImage.Image, err)
 		}
+		v, err := bc.signatureVerifier()
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			if err := v.VerifySignature(imgPath); err != nil {
+				return nil, fmt.Errorf("verifying base image %s: %w", bc.ic.Contents.BaseImage.Image, err)
+			}
+		}
 		baseImg, err := baseimg.New(imgPath, apkindexPath, bc.Arch(), bc.o.TempDir())
 		if err != nil {
 			return nil, err
@@ -356,10 +573,24 @@ func (f *notAFile) Close() error {
 // digests and diffids.
 type layer struct {
 	mu           sync.Mutex
+	compressor   compressor
 	uncompressed string
 	compressed   string
 	diffid       *v1.Hash
 	desc         *v1.Descriptor
+	packages     []string
+	annotations  map[string]string
+	comment      string
+}
+
+// Annotations returns the annotations for the layer's manifest descriptor.
+func (l *layer) Annotations() map[string]string {
+	return l.annotations
+}
+
+// Comment describes what went into the layer, for its image history entry.
+func (l *layer) Comment() string {
+	return l.comment
 }
 
 func (l *layer) compress() error {
@@ -385,8 +616,11 @@ func (l *layer) compress() error {
 	defer bufioPool.Put(buf)
 
 	digest := sha256.New()
-	gzw := pooledGzipWriter(io.MultiWriter(digest, buf))
-	defer pgzipPool.Put(gzw)
+	gzw, release, err := l.compressor.writer(io.MultiWriter(digest, buf))
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	if _, err := io.Copy(gzw, in); err != nil {
 		return err
@@ -415,20 +649,25 @@ func (l *layer) compress() error {
 
 	// Store in cache for future use
 	descCopy := *l.desc
-	compressionCache.Store(l.diffid.String(), &descCopy)
+	compressionCache.Store(l.cacheKey(), &descCopy)
 
 	l.compressed = l.uncompressed + ".gz"
 
 	return out.Close()
 }
 
+// cacheKey identifies this layer's compressed form in compressionCache.
+func (l *layer) cacheKey() string {
+	return l.diffid.String() + "/" + l.compressor.key()
+}
+
 func (l *layer) DiffID() (v1.Hash, error) {
 	return *l.diffid, nil
 }
 
 func (l *layer) Digest() (v1.Hash, error) {
 	// Check if we've already compressed a layer with this diffID
-	if cached, ok := compressionCache.Load(l.diffid.String()); ok {
+	if cached, ok := compressionCache.Load(l.cacheKey()); ok {
 		cachedDesc := cached.(*v1.Descriptor)
 		l.desc.Digest = cachedDesc.Digest
 		l.desc.Size = cachedDesc.Size
@@ -456,12 +695,15 @@ func (l *layer) Compressed() (io.ReadCloser, error) {
 }
 
 func (l *layer) Uncompressed() (io.ReadCloser, error) {
+	if l.compressor.impl == compressorEstargz {
+		return l.estargzUncompressed()
+	}
 	return os.Open(l.uncompressed)
 }
 
 func (l *layer) Size() (int64, error) {
 	// Check if we've already compressed a layer with this diffID
-	if cached, ok := compressionCache.Load(l.diffid.String()); ok {
+	if cached, ok := compressionCache.Load(l.cacheKey()); ok {
 		cachedDesc := cached.(*v1.Descriptor)
 		l.desc.Digest = cachedDesc.Digest
 		l.desc.Size = cachedDesc.Size
@@ -504,3 +746,14 @@ func (bc *Context) WantSBOM() bool {
 func (bc *Context) APK() *apk.APK {
 	return bc.apk
 }
+
+func fileFilters(filters map[string]types.PackageFilter) map[string]apk.FileFilter {
+	if len(filters) == 0 {
+		return nil
+	}
+	out := make(map[string]apk.FileFilter, len(filters))
+	for pkg, f := range filters {
+		out[pkg] = apk.FileFilter{Include: f.Include, Exclude: f.Exclude}
+	}
+	return out
+}
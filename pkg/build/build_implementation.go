@@ -26,12 +26,13 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sync"
 
 	"github.com/chainguard-dev/clog"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	v1types "github.com/google/go-containerregistry/pkg/v1/types"
 	gzip "github.com/klauspost/pgzip"
+	"gopkg.in/yaml.v3"
 
 	ldsocache "chainguard.dev/apko/internal/ldso-cache"
 	"chainguard.dev/apko/pkg/apk/apk"
@@ -50,10 +51,14 @@ import (
 // concurrent builds on giant machines, and uses only 1 core on tiny machines.
 var pgzipThreads = min(runtime.GOMAXPROCS(0), 8)
 
+// pgzipBlockSize is the size of the blocks pgzip compresses in parallel. The
+// compressed output depends on it, so changing it changes layer digests.
+const pgzipBlockSize = 1 << 20
+
 var pgzipPool = sync.Pool{
 	New: func() any {
 		zw := gzip.NewWriter(nil)
-		if err := zw.SetConcurrency(1<<20, pgzipThreads); err != nil {
+		if err := zw.SetConcurrency(pgzipBlockSize, pgzipThreads); err != nil {
 			// This should never happen.
 			panic(fmt.Errorf("tried to set pgzip concurrency to %d: %w", pgzipThreads, err))
 		}
@@ -91,7 +96,7 @@ type layerWriter struct {
 // newLayerWriter wraps a file with a gzipping tar writer that computes
 // everything we need to know to implement a v1.Layer, which it will
 // produce when finalize() is called.
-func newLayerWriter(out *os.File) *layerWriter {
+func newLayerWriter(out *os.File, c compressor) *layerWriter {
 	diffid := sha256.New()
 
 	buf := pooledBufioWriter(out)
@@ -115,9 +120,10 @@ func newLayerWriter(out *os.File) *layerWriter {
 			}
 
 			l := &layer{
+				compressor:   c,
 				uncompressed: out.Name(),
 				desc: &v1.Descriptor{
-					MediaType: v1types.OCILayer,
+					MediaType: c.mediaType(),
 				},
 				diffid: &v1.Hash{
 					Algorithm: "sha256",
@@ -125,101 +131,71 @@ func newLayerWriter(out *os.File) *layerWriter {
 				},
 			}
 
+			// eStargz changes the tar, so the diffID is only known once
+			// the layer is converted.
+			if c.impl == compressorEstargz {
+				if err := l.convertToEstargz(); err != nil {
+					return nil, err
+				}
+			}
+
 			return l, nil
 		},
 	}
 }
 
-func (bc *Context) buildImage(ctx context.Context) ([]apk.InstalledDiff, error) {
+// buildImage builds the image filesystem by resolving, then installing the
+// packages.
+func (bc *Context) buildImage(ctx context.Context) (*Installation, error) {
 	log := clog.FromContext(ctx)
 
-	// When using base image for the build, apko adds new layer on top of the base. This means
-	// it will override files from lower layers. We add all installed packages from base to current
-	// installed file so that the final installed file contains all image's packages.
-	if bc.baseimg != nil {
-		basePkgs := bc.baseimg.InstalledPackages()
-		// Index for loop to make golang-ci happy.
-		// See https://stackoverflow.com/questions/62446118/implicit-memory-aliasing-in-for-loop
-		for index := range basePkgs {
-			_, err := bc.apk.AddInstalledPackage(&basePkgs[index].Package, basePkgs[index].Files)
-			if err != nil {
-				return nil, err
-			}
-		}
-	}
-
-	var (
-		pkgs []apk.InstalledDiff
-		err  error
-	)
-	if bc.o.Lockfile != "" {
-		log.Debugf("Using lockfile: %s", bc.o.Lockfile)
-		lock, err := lock.FromFile(bc.o.Lockfile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load lock-file: %w", err)
-		}
-		err = bc.VerifyLockfileConsistency(ctx, lock.Config)
-		if err != nil {
-			return nil, err
-		}
-		allPkgs, err := installablePackagesForArch(lock, bc.Arch())
-		if err != nil {
-			return nil, fmt.Errorf("failed getting packages for install from lockfile %s: %w", bc.o.Lockfile, err)
-		}
-		pkgs, err = bc.apk.InstallPackages(ctx, &bc.o.SourceDateEpoch, allPkgs)
-		if err != nil {
-			return nil, fmt.Errorf("failed installation from lockfile %s: %w", bc.o.Lockfile, err)
-		}
-	} else {
-		pkgs, err = bc.apk.FixateWorld(ctx, &bc.o.SourceDateEpoch)
-		if err != nil {
-			return nil, fmt.Errorf("installing apk packages: %w", err)
+	inst, err := bc.installImage(ctx)
+	if err != nil {
+		log.Debugf("buildImage failed: %v", err)
+		b, err2 := yaml.Marshal(bc.ic)
+		if err2 != nil {
+			log.Debugf("failed to marshal image configuration: %v", err2)
+		} else {
+			log.Debugf("image configuration:\n%s", string(b))
 		}
+		return nil, err
 	}
+	return inst, nil
+}
 
-	// For now adding additional accounts is banned when using base image. On the other hand, we don't want to
-	// wipe out the users set in base.
-	// If one wants to add a support for adding additional users they would need to look into this piece of code.
-	if bc.ic.Contents.BaseImage == nil {
-		if err := mutateAccounts(bc.fs, &bc.ic); err != nil {
-			return nil, fmt.Errorf("failed to mutate accounts: %w", err)
-		}
+func (bc *Context) installImage(ctx context.Context) (*Installation, error) {
+	res, err := bc.ResolvePackages(ctx)
+	if err != nil {
+		return nil, CategorizeError(ErrorCategoryResolve, err)
 	}
-
-	if err := bc.WriteEtcApkoConfig(ctx); err != nil {
-		return nil, fmt.Errorf("failed to install apko config: %w", err)
+	inst, err := bc.InstallPackages(ctx, res)
+	if err != nil {
+		return nil, CategorizeError(ErrorCategoryInstall, err)
 	}
+	return inst, nil
+}
 
-	if err := mutatePaths(bc.fs, &bc.o, &bc.ic); err != nil {
-		return nil, fmt.Errorf("failed to mutate paths: %w", err)
-	}
+// checkFilesystem runs the checks on the finished filesystem that come
+// before its layers are written.
+func (bc *Context) checkFilesystem(ctx context.Context) (err error) {
+	defer func() { err = CategorizeError(ErrorCategoryCheck, err) }()
 
-	if err := bc.s6.WriteSupervisionTree(ctx, bc.ic.Entrypoint.Services); err != nil {
-		return nil, fmt.Errorf("failed to write supervision tree: %w", err)
+	if err := runScanHooks(ctx, bc.fs, bc.scanHooks); err != nil {
+		return err
 	}
-
-	// add busybox symlinks
-	installed, err := bc.apk.GetInstalled()
-	if err != nil {
-		return nil, fmt.Errorf("getting installed packages: %w", err)
+	if err := checkAssertions(ctx, bc.fs, &bc.ic); err != nil {
+		return err
 	}
-
-	if err := installBusyboxLinks(bc.fs, installed); err != nil {
-		return nil, err
+	if err := bc.checkSymlinks(ctx); err != nil {
+		return err
 	}
-
-	// add necessary character devices
-	if err := installCharDevices(bc.fs); err != nil {
-		return nil, err
+	if err := bc.checkPermissions(ctx); err != nil {
+		return err
 	}
-
-	if err := updateCache(ctx, bc.fs); err != nil {
-		return nil, err
+	if err := bc.checkELFDeps(ctx); err != nil {
+		return err
 	}
-
-	log.Debug("finished building filesystem")
-
-	return pkgs, nil
+	return bc.checkStatic(ctx)
 }
 
 func (bc *Context) VerifyLockfileConsistency(ctx context.Context, lockConfig *lock.Config) error {
@@ -245,7 +221,11 @@ func updateCache(ctx context.Context, fsys apkfs.FullFS) error {
 	if err != nil {
 		return fmt.Errorf("parsing /etc/ld.so.conf: %w", err)
 	}
-	libdirs = append(libdirs, dirs...)
+	for _, dir := range dirs {
+		if !slices.Contains(libdirs, dir) {
+			libdirs = append(libdirs, dir)
+		}
+	}
 	cacheFile, err := ldsocache.BuildCacheFileForDirs(fsys, libdirs)
 	if err != nil {
 		return fmt.Errorf("generating ldsocache: %w", err)
@@ -354,3 +334,23 @@ func (bc *Context) ResolveWithBase(ctx context.Context) ([]*apk.APKResolved, err
 func (bc *Context) InstalledPackages() ([]*apk.InstalledPackage, error) {
 	return bc.apk.GetInstalled()
 }
+
+// ResolvedPackages returns the packages the build installed from the
+// repositories, in the order they were installed, with the sizes and hashes
+// of their sections as they were fetched. It is empty until the packages are
+// installed, and for builds from a lock file.
+func (bc *Context) ResolvedPackages() []*apk.APKResolved {
+	return bc.resolved
+}
+
+// ResolvedIndexes returns the repository indexes, with their digests, that the
+// packages were resolved against. It is empty for builds from a lock file.
+func (bc *Context) ResolvedIndexes() []apk.IndexDigest {
+	return bc.apk.ResolvedIndexes()
+}
+
+// ChecksumIncidents returns the package downloads that failed checksum
+// verification during the build, and the mirrors they were retried from.
+func (bc *Context) ChecksumIncidents() []apk.ChecksumIncident {
+	return bc.apk.ChecksumIncidents()
+}
@@ -15,21 +15,62 @@
 package build_test
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/stretchr/testify/require"
 
+	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/apk/fs"
 	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
 )
 
+func TestConfigRemoteInclude(t *testing.T) {
+	const base = "contents:\n  packages: [base]\n"
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, base)
+	}))
+	defer s.Close()
+	config := filepath.Join(t.TempDir(), "apko.yaml")
+	include := fmt.Sprintf("%s/base.yaml@sha256:%x", s.URL, sha256.Sum256([]byte(base)))
+	require.NoError(t, os.WriteFile(config, []byte("include: "+include+"\n"), 0o644))
+
+	_, _, err := build.NewOptions(build.WithConfig(config, nil))
+	require.ErrorContains(t, err, "certificate")
+
+	// The include is fetched with the options given after WithConfig.
+	_, ic, err := build.NewOptions(
+		build.WithConfig(config, nil),
+		build.WithTransport(s.Client().Transport),
+		build.WithAuthenticator(auth.StaticAuth(strings.TrimPrefix(s.URL, "https://"), "user", "pass")),
+		build.WithAnnotations(map[string]string{"foo": "bar"}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"base"}, ic.Contents.Packages)
+	require.Equal(t, "bar", ic.Annotations["foo"])
+}
+
 func TestBuildLayers(t *testing.T) {
 	ctx := context.Background()
 
@@ -50,6 +91,117 @@ func TestBuildLayers(t *testing.T) {
 	require.Len(t, layers, 2)
 }
 
+func TestBuildLayersSBOM(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	bc, err := build.New(ctx, fs.NewMemFS(),
+		build.WithConfig("layering.yaml", []string{"testdata"}),
+		build.WithSBOM(dir),
+		build.WithSBOMFormats([]string{"spdx"}))
+	require.NoError(t, err)
+
+	// BuildLayers starts on the SBOMs, which GenerateImageSBOM finishes.
+	layers, err := bc.BuildLayers(ctx)
+	require.NoError(t, err)
+
+	img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ImageConfiguration(), time.Unix(0, 0), bc.Arch())
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	sboms, err := bc.GenerateImageSBOM(ctx, bc.Arch(), img)
+	require.NoError(t, err)
+	require.Len(t, sboms, 1)
+	require.Equal(t, digest, sboms[0].Digest)
+
+	data, err := os.ReadFile(sboms[0].Path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), digest.String())
+	require.Contains(t, string(data), "replayout")
+}
+
+func TestBuildLayersWithMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	bc, err := build.New(ctx, fs.NewMemFS(), build.WithConfig("layering.yaml", []string{"testdata"}))
+	require.NoError(t, err)
+
+	infos, err := bc.BuildLayersWithMetadata(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+
+	for _, info := range infos {
+		digest, err := info.Layer.Digest()
+		require.NoError(t, err)
+		require.Equal(t, digest, info.Digest)
+
+		diffid, err := info.Layer.DiffID()
+		require.NoError(t, err)
+		require.Equal(t, diffid, info.DiffID)
+
+		size, err := info.Layer.Size()
+		require.NoError(t, err)
+		require.Equal(t, size, info.Size)
+	}
+
+	// The package layer knows what it holds; the top layer holds no package's files.
+	require.NotEmpty(t, infos[0].Packages)
+	require.Empty(t, infos[1].Packages)
+}
+
+func TestBuildLayersWithExtraLayers(t *testing.T) {
+	ctx := context.Background()
+
+	tarOpener := func(name string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 2, Typeflag: tar.TypeReg}); err != nil {
+				return nil, err
+			}
+			if _, err := tw.Write([]byte("hi")); err != nil {
+				return nil, err
+			}
+			if err := tw.Close(); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(&buf), nil
+		}
+	}
+	below, err := build.ExtraLayerFromTar("certs", tarOpener("etc/extra-cert"), true)
+	require.NoError(t, err)
+	above, err := build.ExtraLayerFromTar("app", tarOpener("app/main"), false)
+	require.NoError(t, err)
+
+	bc, err := build.New(ctx, fs.NewMemFS(),
+		build.WithConfig("empty-layering.yaml", []string{"testdata"}),
+		build.WithExtraLayers(below, above),
+	)
+	require.NoError(t, err)
+
+	infos, err := bc.BuildLayersWithMetadata(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 3)
+
+	require.Equal(t, "certs", infos[0].Annotations[build.LayerSourceAnnotation])
+	require.Empty(t, infos[1].Annotations)
+	require.NotEmpty(t, infos[1].Packages)
+	require.Equal(t, "app", infos[2].Annotations[build.LayerSourceAnnotation])
+
+	layers := make([]v1.Layer, 0, len(infos))
+	for _, info := range infos {
+		layers = append(layers, info.Layer)
+	}
+	img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ImageConfiguration(), time.Unix(0, 0), bc.Arch())
+	require.NoError(t, err)
+	m, err := img.Manifest()
+	require.NoError(t, err)
+	require.Len(t, m.Layers, 3)
+	require.Equal(t, "certs", m.Layers[0].Annotations[build.LayerSourceAnnotation])
+	require.Equal(t, "app", m.Layers[2].Annotations[build.LayerSourceAnnotation])
+}
+
 func TestBuildLayersWithEmptyLayering(t *testing.T) {
 	ctx := context.Background()
 
@@ -96,6 +248,129 @@ func TestBuildLayerWithLayeringStrategy(t *testing.T) {
 	require.Contains(t, err.Error(), "cannot use BuildLayer with a layering strategy")
 }
 
+func TestWriteLayerTo(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []build.Option{
+		build.WithConfig("apko.yaml", []string{"testdata"}),
+		build.WithTempDir(t.TempDir()),
+	}
+
+	bc, err := build.New(ctx, fs.NewMemFS(), opts...)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	diffid, err := bc.WriteLayerTo(ctx, &buf)
+	require.NoError(t, err)
+
+	// The stream is the same layer that BuildLayer writes to disk.
+	bc, err = build.New(ctx, fs.NewMemFS(), opts...)
+	require.NoError(t, err)
+	_, layer, err := bc.BuildLayer(ctx)
+	require.NoError(t, err)
+	want, err := layer.DiffID()
+	require.NoError(t, err)
+	require.Equal(t, want, diffid)
+
+	tr := tar.NewReader(&buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.Contains(t, names, "usr/lib/apk/db/installed")
+
+	bc, err = build.New(ctx, fs.NewMemFS(), build.WithConfig("layering.yaml", []string{"testdata"}))
+	require.NoError(t, err)
+	_, err = bc.WriteLayerTo(ctx, io.Discard)
+	require.ErrorContains(t, err, "cannot use WriteLayerTo with a layering strategy")
+}
+
+func TestPhases(t *testing.T) {
+	ctx := context.Background()
+
+	for _, config := range []string{"apko.yaml", "layering.yaml"} {
+		t.Run(config, func(t *testing.T) {
+			opts := []build.Option{
+				build.WithConfig(config, []string{"testdata"}),
+				build.WithTempDir(t.TempDir()),
+				build.WithCache(t.TempDir(), false, apk.NewCache(true)),
+			}
+
+			// Run the phases one at a time, adding a file in between.
+			fsys := fs.NewMemFS()
+			bc, err := build.New(ctx, fsys, opts...)
+			require.NoError(t, err)
+			res, err := bc.ResolvePackages(ctx)
+			require.NoError(t, err)
+			require.NotEmpty(t, res.Packages)
+			require.NoError(t, bc.FetchPackages(ctx, res, 2))
+			inst, err := bc.InstallPackages(ctx, res)
+			require.NoError(t, err)
+			require.Len(t, inst.Packages, len(res.Packages))
+			resolved := bc.ResolvedPackages()
+			require.Len(t, resolved, len(res.Packages))
+			for i, r := range resolved {
+				require.Equal(t, res.Packages[i].PackageName(), r.Package.Name)
+				require.NotEmpty(t, r.DataHash)
+			}
+			require.NoError(t, fsys.WriteFile("etc/injected", []byte("hello\n"), 0o644))
+			layers, err := bc.Layerize(ctx, inst)
+			require.NoError(t, err)
+			img, err := bc.Finalize(ctx, layers)
+			require.NoError(t, err)
+
+			var found bool
+			for _, l := range layers {
+				rc, err := l.Uncompressed()
+				require.NoError(t, err)
+				tr := tar.NewReader(rc)
+				for {
+					hdr, err := tr.Next()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+					found = found || hdr.Name == "etc/injected"
+				}
+				require.NoError(t, rc.Close())
+			}
+			require.True(t, found, "etc/injected is in no layer")
+
+			got, err := img.Layers()
+			require.NoError(t, err)
+			require.Len(t, got, len(layers))
+
+			// Without anything in between, the phases build what BuildLayers does.
+			bc, err = build.New(ctx, fs.NewMemFS(), opts...)
+			require.NoError(t, err)
+			res, err = bc.ResolvePackages(ctx)
+			require.NoError(t, err)
+			inst, err = bc.InstallPackages(ctx, res)
+			require.NoError(t, err)
+			phased, err := bc.Layerize(ctx, inst)
+			require.NoError(t, err)
+
+			bc, err = build.New(ctx, fs.NewMemFS(), opts...)
+			require.NoError(t, err)
+			built, err := bc.BuildLayers(ctx)
+			require.NoError(t, err)
+
+			require.Len(t, phased, len(built))
+			for i := range built {
+				want, err := built[i].DiffID()
+				require.NoError(t, err)
+				got, err := phased[i].DiffID()
+				require.NoError(t, err)
+				require.Equal(t, want, got)
+			}
+		})
+	}
+}
+
 func TestBuildImage(t *testing.T) {
 	ctx := context.Background()
 
@@ -153,6 +428,82 @@ func TestBuildImageFromLockFile(t *testing.T) {
 	require.Equal(t, installed[1].Version, "1.0.0-r0")
 }
 
+func TestBuildImageWithoutPackages(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []build.Option{
+		build.WithConfig("paths-only.yaml", []string{"testdata"}),
+	}
+
+	_, ic, err := build.NewOptions(opts...)
+	require.NoError(t, err)
+
+	configs, missing, err := build.LockImageConfiguration(ctx, *ic, opts...)
+	require.NoError(t, err)
+	require.Empty(t, missing)
+	for _, arch := range []string{"amd64", "arm64", "index"} {
+		require.Contains(t, configs, arch)
+		require.Empty(t, configs[arch].Contents.Packages)
+	}
+
+	fsys := fs.NewMemFS()
+	bc, err := build.New(ctx, fsys, opts...)
+	require.NoError(t, err)
+
+	layers, err := bc.BuildLayers(ctx)
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+
+	installed, err := bc.InstalledPackages()
+	require.NoError(t, err)
+	require.Empty(t, installed)
+
+	for _, p := range []string{"etc/passwd", "etc/group", "app/config", "usr/lib/apk/db/installed"} {
+		_, err := fsys.Stat(p)
+		require.NoError(t, err, p)
+	}
+}
+
+func TestBuildImageWithFSMutators(t *testing.T) {
+	ctx := context.Background()
+
+	var order []string
+	addMotd := func(_ context.Context, fsys fs.FullFS) error {
+		order = append(order, "add")
+		return fsys.WriteFile("etc/motd", []byte("hello\n"), 0o644)
+	}
+	removeConfig := func(_ context.Context, fsys fs.FullFS) error {
+		order = append(order, "remove")
+		// The paths from the configuration are already there.
+		return fsys.Remove("app/config")
+	}
+
+	fsys := fs.NewMemFS()
+	bc, err := build.New(ctx, fsys,
+		build.WithConfig("paths-only.yaml", []string{"testdata"}),
+		build.WithFSMutator(addMotd),
+		build.WithFSMutator(removeConfig),
+	)
+	require.NoError(t, err)
+	_, err = bc.BuildLayers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"add", "remove"}, order)
+
+	b, err := fsys.ReadFile("etc/motd")
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(b))
+	_, err = fsys.Stat("app/config")
+	require.ErrorIs(t, err, iofs.ErrNotExist)
+
+	bc, err = build.New(ctx, fs.NewMemFS(),
+		build.WithConfig("paths-only.yaml", []string{"testdata"}),
+		build.WithFSMutator(func(context.Context, fs.FullFS) error { return errors.New("no locales to strip") }),
+	)
+	require.NoError(t, err)
+	_, err = bc.BuildLayers(ctx)
+	require.ErrorContains(t, err, "running filesystem mutator 0: no locales to strip")
+}
+
 func TestBuildImageFromTooOldResolvedFile(t *testing.T) {
 	ctx := context.Background()
 
@@ -0,0 +1,136 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"chainguard.dev/apko/pkg/vcs"
+)
+
+// Walking the history is not free and a multi-arch build creates several
+// contexts from the same options, so remember the answer per config file.
+var gitBuildDates sync.Map // config path -> time.Time
+
+// applyGitBuildDate sets SourceDateEpoch from the git history of the
+// configuration file, if asked to and no build date was given explicitly.
+func (bc *Context) applyGitBuildDate() error {
+	if !bc.o.BuildDateFromGit || bc.buildDateSet {
+		return nil
+	}
+	if bc.o.ImageConfigFile == "" {
+		return fmt.Errorf("deriving build date from git: no configuration file")
+	}
+
+	if t, ok := gitBuildDates.Load(bc.o.ImageConfigFile); ok {
+		bc.o.SourceDateEpoch = t.(time.Time)
+		return nil
+	}
+	t, err := vcs.LastCommitTime(bc.o.ImageConfigFile)
+	if err != nil {
+		return fmt.Errorf("deriving build date from git: %w", err)
+	}
+	gitBuildDates.Store(bc.o.ImageConfigFile, t)
+	bc.o.SourceDateEpoch = t
+	return nil
+}
+
+// The timestamp sources WithTimestamps accepts, besides an RFC 3339 date or
+// a number of seconds since the epoch.
+const (
+	// TimestampBuildDate is the build date: SOURCE_DATE_EPOCH, the date set
+	// with WithBuildDate or the one derived from git.
+	TimestampBuildDate = "build-date"
+	// TimestampNewestPackage is the build time of the newest package
+	// installed.
+	TimestampNewestPackage = "newest-package"
+)
+
+// parseTimestamp parses a timestamp source that is a date, which is either
+// RFC 3339 or, as in SOURCE_DATE_EPOCH, seconds since the epoch.
+func parseTimestamp(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp %q is not %q, %q, an RFC 3339 date or seconds since the epoch", s, TimestampBuildDate, TimestampNewestPackage)
+	}
+	return t, nil
+}
+
+// validateTimestamp checks that s is a timestamp source, or empty.
+func validateTimestamp(s string) error {
+	switch s {
+	case "", TimestampBuildDate, TimestampNewestPackage:
+		return nil
+	}
+	_, err := parseTimestamp(s)
+	return err
+}
+
+// resolveTimestamp returns the time the timestamp source s stands for.
+func (bc *Context) resolveTimestamp(s string) (time.Time, error) {
+	switch s {
+	case TimestampBuildDate:
+		return bc.o.SourceDateEpoch, nil
+	case TimestampNewestPackage:
+		pl, err := bc.apk.GetInstalled()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to determine installed packages: %w", err)
+		}
+		var newest time.Time
+		for _, p := range pl {
+			if p.BuildTime.After(newest) {
+				newest = p.BuildTime
+			}
+		}
+		if newest.IsZero() {
+			// Without packages, there is nothing newer than the build date.
+			return bc.o.SourceDateEpoch, nil
+		}
+		return newest, nil
+	}
+	return parseTimestamp(s)
+}
+
+// fileTimestamp returns the modification time every entry of the image
+// layers is given, or the zero time if they keep the ones they have.
+func (bc *Context) fileTimestamp() (time.Time, error) {
+	if bc.o.FileTimestamp == "" {
+		return time.Time{}, nil
+	}
+	t, err := bc.resolveTimestamp(bc.o.FileTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("resolving file timestamp: %w", err)
+	}
+	return t, nil
+}
+
+// CreatedTime returns the time the image is recorded as created at: the
+// one set with WithTimestamps, or else the build date epoch.
+func (bc *Context) CreatedTime() (time.Time, error) {
+	if bc.o.CreatedTimestamp == "" {
+		return bc.GetBuildDateEpoch()
+	}
+	t, err := bc.resolveTimestamp(bc.o.CreatedTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("resolving created timestamp: %w", err)
+	}
+	return t, nil
+}
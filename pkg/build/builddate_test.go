@@ -0,0 +1,105 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build_test
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build"
+)
+
+func TestTimestamps(t *testing.T) {
+	ctx := context.Background()
+	files := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	buildDate := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	for _, config := range []string{"apko.yaml", "layering.yaml"} {
+		t.Run(config, func(t *testing.T) {
+			bc, err := build.New(ctx, fs.NewMemFS(),
+				build.WithConfig(config, []string{"testdata"}),
+				build.WithBuildDate(buildDate.Format(time.RFC3339)),
+				build.WithTimestamps(strconv.FormatInt(files.Unix(), 10), build.TimestampBuildDate))
+			require.NoError(t, err)
+
+			layers, err := bc.BuildLayers(ctx)
+			require.NoError(t, err)
+			for _, l := range layers {
+				rc, err := l.Uncompressed()
+				require.NoError(t, err)
+				tr := tar.NewReader(rc)
+				for {
+					hdr, err := tr.Next()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+					require.True(t, files.Equal(hdr.ModTime), "%s: %s", hdr.Name, hdr.ModTime)
+				}
+				require.NoError(t, rc.Close())
+			}
+
+			img, err := bc.Finalize(ctx, layers)
+			require.NoError(t, err)
+			cf, err := img.ConfigFile()
+			require.NoError(t, err)
+			require.True(t, buildDate.Equal(cf.Created.Time), cf.Created.Time)
+		})
+	}
+}
+
+func TestTimestampsNewestPackage(t *testing.T) {
+	ctx := context.Background()
+	buildDate := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	bc, err := build.New(ctx, fs.NewMemFS(),
+		build.WithConfig("apko.yaml", []string{"testdata"}),
+		build.WithBuildDate(buildDate.Format(time.RFC3339)),
+		build.WithTimestamps("", build.TimestampNewestPackage))
+	require.NoError(t, err)
+	_, err = bc.BuildLayers(ctx)
+	require.NoError(t, err)
+
+	installed, err := bc.APK().GetInstalled()
+	require.NoError(t, err)
+	var newest time.Time
+	for _, p := range installed {
+		if p.BuildTime.After(newest) {
+			newest = p.BuildTime
+		}
+	}
+	// The test packages do not record when they were built, so there is
+	// nothing newer than the build date.
+	if newest.IsZero() {
+		newest = buildDate
+	}
+	created, err := bc.CreatedTime()
+	require.NoError(t, err)
+	require.True(t, newest.Equal(created), "%s != %s", newest, created)
+}
+
+func TestTimestampsInvalid(t *testing.T) {
+	_, err := build.New(context.Background(), fs.NewMemFS(), build.WithTimestamps("yesterday", ""))
+	require.ErrorContains(t, err, "file timestamp")
+	_, err = build.New(context.Background(), fs.NewMemFS(), build.WithTimestamps("", "2025-13-01"))
+	require.ErrorContains(t, err, "created timestamp")
+}
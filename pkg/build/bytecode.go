@@ -0,0 +1,136 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/options"
+)
+
+var pythonLibDir = regexp.MustCompile(`^python(3\.[0-9]+)$`)
+
+// precompileBytecode generates the bytecode the image configuration asks for.
+func (bc *Context) precompileBytecode(ctx context.Context) error {
+	if bc.ic.Bytecode == nil {
+		return nil
+	}
+	if bc.ic.Bytecode.Python {
+		if err := compilePython(ctx, bc.fs, &bc.o); err != nil {
+			return fmt.Errorf("precompiling python bytecode: %w", err)
+		}
+	}
+	return nil
+}
+
+// compilePython precompiles the modules of every CPython installed under
+// /usr/lib. The image's interpreter may be for another architecture, so the
+// compilation runs on a host interpreter of the same version. Hash-based
+// invalidation makes the .pyc files depend on the sources alone, not on
+// their timestamps, so the output is reproducible. Bytecode shipped by the
+// packages themselves is left untouched. Temporary files are created through
+// o.
+func compilePython(ctx context.Context, fsys apkfs.FullFS, o *options.Options) error {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "compilePython")
+	defer span.End()
+
+	entries, err := fsys.ReadDir("usr/lib")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		m := pythonLibDir.FindStringSubmatch(e.Name())
+		if m == nil || !e.IsDir() {
+			continue
+		}
+		libdir := path.Join("usr/lib", e.Name())
+		log.Infof("precompiling bytecode in /%s", libdir)
+		if err := compilePythonDir(ctx, fsys, o, libdir, m[1]); err != nil {
+			return fmt.Errorf("/%s: %w", libdir, err)
+		}
+	}
+	return nil
+}
+
+func compilePythonDir(ctx context.Context, fsys apkfs.FullFS, o *options.Options, libdir, version string) error {
+	python, err := hostPython(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := o.MkdirTemp("", "apko-pyc-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Work on a copy of the sources: the rootfs need not be on disk.
+	if err := copyToHost(fsys, libdir, tmp, func(p string) bool {
+		return strings.HasSuffix(p, ".py")
+	}); err != nil {
+		return fmt.Errorf("copying sources: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, python, "-m", "compileall", "-q",
+		"--invalidation-mode", "checked-hash",
+		"-s", tmp, "-p", "/"+libdir, tmp)
+	cmd.Env = append(os.Environ(), "PYTHONHASHSEED=0")
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		// compileall fails when any one module does not compile (the test
+		// suite ships deliberately broken ones); keep what did compile.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("running %s: %w", python, err)
+		}
+		clog.FromContext(ctx).Warnf("some modules in /%s did not compile: %s", libdir, strings.TrimSpace(out.String()))
+	}
+
+	return copyFromHost(fsys, tmp, libdir, false)
+}
+
+// hostPython finds a host interpreter for the given major.minor version, as
+// bytecode is only valid for the version that wrote it.
+func hostPython(ctx context.Context, version string) (string, error) {
+	for _, name := range []string{"python" + version, "python3"} {
+		p, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		out, err := exec.CommandContext(ctx, p, "-c", `import sys; print("%d.%d" % sys.version_info[:2])`).Output()
+		if err == nil && strings.TrimSpace(string(out)) == version {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no python%s found on the host to compile with", version)
+}
@@ -0,0 +1,63 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/binary"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestCompilePython(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("no python3 on the host")
+	}
+	out, err := exec.Command(python, "-c", `import sys; print("%d.%d" % sys.version_info[:2])`).Output()
+	require.NoError(t, err)
+	version := strings.TrimSpace(string(out))
+	libdir := "usr/lib/python" + version
+	tag := "cpython-" + strings.ReplaceAll(version, ".", "")
+
+	compile := func() []byte {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, fsys.MkdirAll(libdir+"/site-packages/pkg", 0o755))
+		require.NoError(t, fsys.WriteFile(libdir+"/site-packages/pkg/mod.py", []byte("X = 1\n"), 0o644))
+		require.NoError(t, fsys.WriteFile(libdir+"/broken.py", []byte("def (\n"), 0o644))
+		require.NoError(t, compilePython(t.Context(), fsys, &options.Options{}))
+
+		_, err := fsys.Stat(libdir + "/__pycache__/broken." + tag + ".pyc")
+		require.Error(t, err)
+
+		pyc, err := fsys.ReadFile(libdir + "/site-packages/pkg/__pycache__/mod." + tag + ".pyc")
+		require.NoError(t, err)
+		// PEP 552: bit 0 marks hash-based, bit 1 checked.
+		require.Equal(t, uint32(0b11), binary.LittleEndian.Uint32(pyc[4:8]))
+		return pyc
+	}
+	require.Equal(t, compile(), compile())
+}
+
+func TestCompilePythonNoInterpreter(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/lib/python3.999", 0o755))
+	require.ErrorContains(t, compilePython(t.Context(), fsys, &options.Options{}), "no python3.999")
+}
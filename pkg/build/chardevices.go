@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"path/filepath"
 
-	"golang.org/x/sys/unix"
-
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 )
 
@@ -29,7 +27,7 @@ func installCharDevices(fsys apkfs.FullFS) error {
 		if err := fsys.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("creating directory %s: %w", dir, err)
 		}
-		if err := fsys.Mknod(dev.path, unix.S_IFCHR, int(unix.Mkdev(dev.major, dev.minor))); err != nil {
+		if err := fsys.Mknod(dev.path, apkfs.IFCHR, int(apkfs.Mkdev(dev.major, dev.minor))); err != nil {
 			return fmt.Errorf("creating character device %s: %w", dev.path, err)
 		}
 	}
@@ -0,0 +1,192 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+	"golang.org/x/sync/errgroup"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/apk/auth"
+)
+
+// checksumDBConcurrency bounds the lookups in flight against the checksum
+// database.
+const checksumDBConcurrency = 8
+
+// checksumDB is a checksum database, consulted independently of the package
+// repositories so that a tampered mirror cannot vouch for its own packages,
+// in the spirit of Go's sumdb.
+//
+// The database is a transparency log of records "<arch> <name> <version>
+// <checksum>", where checksum is the package's control checksum as it
+// appears in an APKINDEX (e.g. "Q1..."). It serves:
+//
+//   - <base>/latest: its tree head, the size and root hash of the log in
+//     the format of tlog.FormatTree, signed as a note with its key.
+//   - <base>/lookup/<arch>/<name>/<version>?n=<size>: the index of the
+//     package's record in the log, the record, and the hashes proving it is
+//     included in the tree of that size, base64 encoded, each on its own
+//     line.
+//
+// Every lookup of a build is proven against the one signed tree head it
+// fetched first. Any status other than 200 fails the lookup.
+type checksumDB struct {
+	base     string
+	verifier note.Verifier
+	client   *http.Client
+	auth     auth.Authenticator
+}
+
+// newChecksumDB returns the checksum database at base, whose tree heads are
+// signed by the note verifier key, fetched with client.
+func newChecksumDB(base, key string, client *http.Client, a auth.Authenticator) (*checksumDB, error) {
+	if key == "" {
+		return nil, fmt.Errorf("checksum database %s has no verifier key", base)
+	}
+	v, err := note.NewVerifier(key)
+	if err != nil {
+		return nil, fmt.Errorf("parsing checksum database key: %w", err)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if a == nil {
+		a = auth.DefaultAuthenticators
+	}
+	return &checksumDB{
+		base:     strings.TrimRight(base, "/"),
+		verifier: v,
+		client:   client,
+		auth:     a,
+	}, nil
+}
+
+func (db *checksumDB) get(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.auth.AddAuth(ctx, req); err != nil {
+		return nil, fmt.Errorf("adding auth to %s: %w", u, err)
+	}
+	resp, err := db.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status code %d", u, resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", u, err)
+	}
+	return b, nil
+}
+
+// latest returns the current tree head of the database, once its signature
+// is verified.
+func (db *checksumDB) latest(ctx context.Context) (tlog.Tree, error) {
+	b, err := db.get(ctx, db.base+"/latest")
+	if err != nil {
+		return tlog.Tree{}, err
+	}
+	n, err := note.Open(b, note.VerifierList(db.verifier))
+	if err != nil {
+		return tlog.Tree{}, fmt.Errorf("verifying tree head: %w", err)
+	}
+	return tlog.ParseTree([]byte(n.Text))
+}
+
+// lookup returns the checksum the database has for a package, once the
+// inclusion of its record in tree is proven.
+func (db *checksumDB) lookup(ctx context.Context, tree tlog.Tree, arch, name, version string) (string, error) {
+	u := fmt.Sprintf("%s/lookup/%s/%s/%s?n=%d", db.base, url.PathEscape(arch), url.PathEscape(name), url.PathEscape(version), tree.N)
+	b, err := db.get(ctx, u)
+	if err != nil {
+		return "", err
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	var lines []string
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if len(lines) < 2 {
+		return "", fmt.Errorf("malformed lookup response from %s", u)
+	}
+	index, err := strconv.ParseInt(lines[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed record index from %s: %w", u, err)
+	}
+	record := lines[1]
+	proof := make(tlog.RecordProof, 0, len(lines)-2)
+	for _, l := range lines[2:] {
+		h, err := tlog.ParseHash(l)
+		if err != nil {
+			return "", fmt.Errorf("malformed proof from %s: %w", u, err)
+		}
+		proof = append(proof, h)
+	}
+	if err := tlog.CheckRecord(proof, tree.N, tree.Hash, index, tlog.RecordHash([]byte(record+"\n"))); err != nil {
+		return "", fmt.Errorf("record %d from %s is not in the signed tree: %w", index, u, err)
+	}
+
+	fields := strings.Fields(record)
+	if len(fields) != 4 || fields[0] != arch || fields[1] != name || fields[2] != version {
+		return "", fmt.Errorf("record %d from %s is for %q, not %s %s %s", index, u, record, arch, name, version)
+	}
+	return fields[3], nil
+}
+
+// verify checks the checksum of every package against the database.
+func (db *checksumDB) verify(ctx context.Context, pkgs []*apk.Package) error {
+	ctx, span := otel.Tracer("apko").Start(ctx, "checksumDB.verify")
+	defer span.End()
+
+	tree, err := db.latest(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching checksum database tree head: %w", err)
+	}
+
+	var g errgroup.Group
+	g.SetLimit(checksumDBConcurrency)
+	for _, pkg := range pkgs {
+		g.Go(func() error {
+			want, err := db.lookup(ctx, tree, pkg.Arch, pkg.Name, pkg.Version)
+			if err != nil {
+				return fmt.Errorf("looking up %s-%s in checksum database: %w", pkg.Name, pkg.Version, err)
+			}
+			if got := pkg.ChecksumString(); got != want {
+				return fmt.Errorf("checksum mismatch for %s-%s: installed %s, checksum database has %s", pkg.Name, pkg.Version, got, want)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,153 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// testChecksumLog is a checksum database serving records from memory.
+type testChecksumLog struct {
+	records []string
+	hashes  []tlog.Hash
+	signer  note.Signer
+	// tamper, if set, rewrites records as they are served.
+	tamper func(string) string
+}
+
+func newTestChecksumLog(t *testing.T, signer note.Signer, records ...string) *testChecksumLog {
+	l := &testChecksumLog{signer: signer}
+	for _, r := range records {
+		hashes, err := tlog.StoredHashes(int64(len(l.records)), []byte(r+"\n"), l.hashReader())
+		require.NoError(t, err)
+		l.records = append(l.records, r)
+		l.hashes = append(l.hashes, hashes...)
+	}
+	return l
+}
+
+func (l *testChecksumLog) hashReader() tlog.HashReader {
+	return tlog.HashReaderFunc(func(indexes []int64) ([]tlog.Hash, error) {
+		out := make([]tlog.Hash, len(indexes))
+		for i, idx := range indexes {
+			out[i] = l.hashes[idx]
+		}
+		return out, nil
+	})
+}
+
+func (l *testChecksumLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int64(len(l.records))
+	if r.URL.Path == "/sumdb/latest" {
+		h, err := tlog.TreeHash(n, l.hashReader())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := note.Sign(&note.Note{Text: string(tlog.FormatTree(tlog.Tree{N: n, Hash: h}))}, l.signer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(b)
+		return
+	}
+	key := strings.ReplaceAll(strings.TrimPrefix(r.URL.Path, "/sumdb/lookup/"), "/", " ")
+	for i, rec := range l.records {
+		if !strings.HasPrefix(rec, key+" ") {
+			continue
+		}
+		proof, err := tlog.ProveRecord(n, int64(i), l.hashReader())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if l.tamper != nil {
+			rec = l.tamper(rec)
+		}
+		fmt.Fprintf(w, "%d\n%s\n", i, rec)
+		for _, h := range proof {
+			fmt.Fprintln(w, h)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func TestChecksumDB(t *testing.T) {
+	good := &apk.Package{Name: "foo", Version: "1.0-r0", Arch: "x86_64", Checksum: []byte("foo")}
+	bad := &apk.Package{Name: "bar", Version: "2.0-r0", Arch: "x86_64", Checksum: []byte("tampered")}
+	unknown := &apk.Package{Name: "baz", Version: "3.0-r0", Arch: "x86_64", Checksum: []byte("baz")}
+
+	skey, vkey, err := note.GenerateKey(rand.Reader, "checksums.example.com")
+	require.NoError(t, err)
+	signer, err := note.NewSigner(skey)
+	require.NoError(t, err)
+
+	records := []string{
+		"x86_64 foo 1.0-r0 " + good.ChecksumString(),
+		"x86_64 bar 2.0-r0 " + (&apk.Package{Checksum: []byte("bar")}).ChecksumString(),
+	}
+	for i := range 5 {
+		records = append(records, "aarch64 pkg"+strconv.Itoa(i)+" 1.0-r0 Q1AAAA")
+	}
+	log := newTestChecksumLog(t, signer, records...)
+	srv := httptest.NewServer(log)
+	defer srv.Close()
+
+	ctx := context.Background()
+	db, err := newChecksumDB(srv.URL+"/sumdb/", vkey, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, db.verify(ctx, []*apk.Package{good}))
+	require.ErrorContains(t, db.verify(ctx, []*apk.Package{good, bad}), "checksum mismatch for bar-2.0-r0")
+	require.ErrorContains(t, db.verify(ctx, []*apk.Package{unknown}), "unexpected status code 404")
+
+	t.Run("tampered record", func(t *testing.T) {
+		log.tamper = func(rec string) string {
+			// Vouch for the tampered package with the proof of the
+			// original record.
+			return strings.Replace(rec, records[1], "x86_64 bar 2.0-r0 "+bad.ChecksumString(), 1)
+		}
+		defer func() { log.tamper = nil }()
+		require.ErrorContains(t, db.verify(ctx, []*apk.Package{bad}), "not in the signed tree")
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, other, err := note.GenerateKey(rand.Reader, "checksums.example.com")
+		require.NoError(t, err)
+		db, err := newChecksumDB(srv.URL+"/sumdb", other, nil, nil)
+		require.NoError(t, err)
+		require.ErrorContains(t, db.verify(ctx, []*apk.Package{good}), "verifying tree head")
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		_, err := newChecksumDB(srv.URL+"/sumdb", "", nil, nil)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	stdgzip "compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	kgzip "github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+const (
+	// CompressorPgzip compresses layers with parallel gzip. This is the default.
+	CompressorPgzip = "pgzip"
+	// CompressorGzip compresses layers with the single-threaded gzip of
+	// klauspost/compress, which is cheaper for small layers and when running
+	// many builds side by side.
+	CompressorGzip = "gzip"
+	// CompressorStdlib compresses layers with the gzip of the Go standard
+	// library.
+	CompressorStdlib = "stdlib"
+	// CompressorDeterministic compresses layers with fixed Huffman codes
+	// and a matcher defined by apko, so that their digests only change with
+	// their contents, and not with the Go or library version. It ignores
+	// the compression level, and compresses less than the others.
+	CompressorDeterministic = "deterministic"
+)
+
+// Compressors lists the supported layer compressor implementations.
+var Compressors = []string{CompressorPgzip, CompressorGzip, CompressorStdlib, CompressorDeterministic}
+
+const (
+	// LayerCompressionGzip emits gzip compressed layers, compressed by the
+	// selected compressor. This is the default.
+	LayerCompressionGzip = "gzip"
+	// LayerCompressionZstd emits zstd compressed layers, which registries
+	// and runtimes supporting them pull and unpack faster.
+	LayerCompressionZstd = "zstd"
+	// LayerCompressionEstargz emits eStargz layers: gzip compressed layers
+	// with a table of contents, from which lazy-pulling runtimes such as
+	// stargz-snapshotter start containers before the layers are fully
+	// downloaded.
+	LayerCompressionEstargz = "estargz"
+)
+
+// LayerCompressions lists the supported layer compression formats.
+var LayerCompressions = []string{LayerCompressionGzip, LayerCompressionZstd, LayerCompressionEstargz}
+
+// The implementations behind LayerCompressionZstd and LayerCompressionEstargz.
+const (
+	compressorZstd    = "zstd"
+	compressorEstargz = "estargz"
+)
+
+// compressor describes how a layer is compressed. Layers with the same diffID
+// but different compressors have different digests.
+type compressor struct {
+	impl  string
+	level int
+	// threads is the number of goroutines pgzip compresses blocks on. It
+	// does not affect the output, so it is not part of key.
+	threads int
+	// namespace keeps compressionCache entries of different cache
+	// namespaces apart. It does not affect the output either.
+	namespace string
+}
+
+func compressorFor(o *options.Options) compressor {
+	c := compressor{impl: o.Compressor, level: o.CompressionLevel, threads: o.CompressionThreads, namespace: o.CacheNamespace}
+	switch o.LayerCompression {
+	case LayerCompressionZstd:
+		c.impl = compressorZstd
+	case LayerCompressionEstargz:
+		c.impl = compressorEstargz
+	}
+	if c.impl == "" {
+		c.impl = CompressorPgzip
+	}
+	return c
+}
+
+// LayerCompressor describes the compression the layers built with o use,
+// such as "pgzip" or "gzip:9", so that it can be recorded next to their
+// digests.
+func LayerCompressor(o *options.Options) string {
+	c := compressorFor(o)
+	if c.level == 0 || c.impl == CompressorDeterministic {
+		return c.impl
+	}
+	return fmt.Sprintf("%s:%d", c.impl, c.level)
+}
+
+// threadsPerLayer returns how many goroutines compress each layer. Only
+// pgzip and zstd compress a layer on more than one.
+func (c compressor) threadsPerLayer() int {
+	switch c.impl {
+	case CompressorPgzip, "", compressorZstd:
+		if c.threads > 0 {
+			return c.threads
+		}
+		return pgzipThreads
+	}
+	return 1
+}
+
+// jobs returns how many layers are compressed at once, so that together
+// they compress on about as many goroutines as there are cores.
+func (c compressor) jobs() int {
+	return max(1, runtime.GOMAXPROCS(0)/c.threadsPerLayer())
+}
+
+// mediaType is the media type of the layers c compresses.
+func (c compressor) mediaType() v1types.MediaType {
+	if c.impl == compressorZstd {
+		return v1types.OCILayerZStd
+	}
+	return v1types.OCILayer
+}
+
+// key identifies the compressed output in compressionCache.
+func (c compressor) key() string {
+	if c.namespace != "" {
+		return fmt.Sprintf("%s:%d@%s", c.impl, c.level, c.namespace)
+	}
+	return fmt.Sprintf("%s:%d", c.impl, c.level)
+}
+
+// reader returns a reader of what the layer compressed by c in r
+// decompresses to.
+func (c compressor) reader(r io.Reader) (io.ReadCloser, error) {
+	if c.impl == compressorZstd {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return kgzip.NewReader(r)
+}
+
+// writer returns a compressing writer for w. The returned release func must
+// be called once the writer has been closed.
+func (c compressor) writer(w io.Writer) (io.WriteCloser, func(), error) {
+	level := c.level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	switch c.impl {
+	case CompressorPgzip, "":
+		threads := c.threadsPerLayer()
+		if c.level == 0 && threads == pgzipThreads {
+			zw := pooledGzipWriter(w)
+			return zw, func() { pgzipPool.Put(zw) }, nil
+		}
+		zw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := zw.SetConcurrency(pgzipBlockSize, threads); err != nil {
+			return nil, nil, err
+		}
+		return zw, func() {}, nil
+	case CompressorGzip:
+		zw, err := kgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zw, func() {}, nil
+	case CompressorStdlib:
+		zw, err := stdgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zw, func() {}, nil
+	case CompressorDeterministic:
+		return newFixedGzipWriter(w), func() {}, nil
+	case compressorZstd:
+		zopts := []zstd.EOption{zstd.WithEncoderConcurrency(c.threadsPerLayer())}
+		if c.level != 0 {
+			zopts = append(zopts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+		}
+		zw, err := zstd.NewWriter(w, zopts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zw, func() {}, nil
+	case compressorEstargz:
+		return nil, nil, fmt.Errorf("estargz layers are built whole, not streamed")
+	default:
+		return nil, nil, fmt.Errorf("unsupported compressor %q", c.impl)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestCompressors(t *testing.T) {
+	for _, c := range []compressor{
+		{impl: CompressorPgzip},
+		{impl: CompressorPgzip, level: 1},
+		{impl: CompressorPgzip, threads: 2},
+		{impl: CompressorGzip},
+		{impl: CompressorGzip, level: 9},
+		{impl: CompressorStdlib},
+		{impl: CompressorStdlib, level: 1},
+		{impl: CompressorDeterministic},
+	} {
+		t.Run(c.key(), func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "layer.tar"))
+			require.NoError(t, err)
+			defer f.Close()
+
+			lw := newLayerWriter(f, c)
+			require.NoError(t, lw.w.WriteHeader(&tar.Header{Name: "hello", Mode: 0o644, Size: 5, Typeflag: tar.TypeReg}))
+			_, err = lw.w.Write([]byte("hello"))
+			require.NoError(t, err)
+			l, err := lw.finalize()
+			require.NoError(t, err)
+
+			rc, err := l.Compressed()
+			require.NoError(t, err)
+			defer rc.Close()
+			zr, err := gzip.NewReader(rc)
+			require.NoError(t, err)
+			tr := tar.NewReader(zr)
+			hdr, err := tr.Next()
+			require.NoError(t, err)
+			require.Equal(t, "hello", hdr.Name)
+			b, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(b))
+		})
+	}
+}
+
+func TestZstdLayers(t *testing.T) {
+	for _, o := range []options.Options{
+		{LayerCompression: LayerCompressionZstd},
+		{LayerCompression: LayerCompressionZstd, CompressionLevel: 9, Compressor: CompressorGzip},
+	} {
+		c := compressorFor(&o)
+		t.Run(c.key(), func(t *testing.T) {
+			require.Equal(t, compressorZstd, c.impl)
+
+			f, err := os.Create(filepath.Join(t.TempDir(), "layer.tar"))
+			require.NoError(t, err)
+			defer f.Close()
+
+			lw := newLayerWriter(f, c)
+			require.NoError(t, lw.w.WriteHeader(&tar.Header{Name: "hello", Mode: 0o644, Size: 5, Typeflag: tar.TypeReg}))
+			_, err = lw.w.Write([]byte("hello"))
+			require.NoError(t, err)
+			l, err := lw.finalize()
+			require.NoError(t, err)
+
+			mt, err := l.MediaType()
+			require.NoError(t, err)
+			require.Equal(t, v1types.OCILayerZStd, mt)
+
+			rc, err := l.Compressed()
+			require.NoError(t, err)
+			defer rc.Close()
+			zr, err := zstd.NewReader(rc)
+			require.NoError(t, err)
+			defer zr.Close()
+			tr := tar.NewReader(zr)
+			hdr, err := tr.Next()
+			require.NoError(t, err)
+			require.Equal(t, "hello", hdr.Name)
+			b, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(b))
+		})
+	}
+
+	gz := compressorFor(&options.Options{})
+	require.Equal(t, v1types.OCILayer, gz.mediaType())
+	require.NotEqual(t, gz.key(), compressorFor(&options.Options{LayerCompression: LayerCompressionZstd}).key())
+}
+
+func TestPgzipThreadsDoNotChangeOutput(t *testing.T) {
+	data := make([]byte, 3*pgzipBlockSize)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+
+	compress := func(c compressor) []byte {
+		var buf bytes.Buffer
+		zw, release, err := c.writer(&buf)
+		require.NoError(t, err)
+		defer release()
+		_, err = zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	require.Equal(t, compress(compressor{impl: CompressorPgzip, threads: 1}), compress(compressor{impl: CompressorPgzip, threads: 4}))
+}
+
+func TestDeterministicCompressor(t *testing.T) {
+	// Repetitive data that matches at every distance and length, followed
+	// by data that hardly matches at all.
+	var data []byte
+	for i := 0; len(data) < 3*fixedBufferSize; i++ {
+		data = fmt.Appendf(data, "line %d of %s\n", i%5000, strings.Repeat("ab", i%300))
+	}
+	for i := range fixedBufferSize {
+		data = append(data, byte(i*i>>3))
+	}
+
+	compress := func(chunk int) []byte {
+		var buf bytes.Buffer
+		zw, release, err := compressor{impl: CompressorDeterministic}.writer(&buf)
+		require.NoError(t, err)
+		defer release()
+		for p := data; len(p) > 0; {
+			n := min(chunk, len(p))
+			_, err := zw.Write(p[:n])
+			require.NoError(t, err)
+			p = p[n:]
+		}
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	out := compress(len(data))
+	zr, err := gzip.NewReader(bytes.NewReader(out))
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+	require.Less(t, len(out), len(data)/2)
+
+	// How the input is split does not change the output.
+	require.Equal(t, out, compress(1000))
+	require.Equal(t, out, compress(fixedMaxMatch+1))
+
+	// Nor does anything else, so that it can be pinned.
+	h := sha256.Sum256(out)
+	require.Equal(t, "c018a9eca3a52040dac31e0fc9ec6eb3f8417602021e1f20eacdbbc5bd26826e", hex.EncodeToString(h[:]))
+}
+
+func TestLayerCompressor(t *testing.T) {
+	require.Equal(t, "pgzip", LayerCompressor(&options.Options{}))
+	require.Equal(t, "stdlib:9", LayerCompressor(&options.Options{Compressor: CompressorStdlib, CompressionLevel: 9}))
+	require.Equal(t, "deterministic", LayerCompressor(&options.Options{Compressor: CompressorDeterministic, CompressionLevel: 9}))
+	require.Equal(t, "zstd", LayerCompressor(&options.Options{LayerCompression: LayerCompressionZstd}))
+}
+
+func TestCompressorCacheKey(t *testing.T) {
+	a := &layer{compressor: compressor{impl: CompressorPgzip}}
+	b := &layer{compressor: compressor{impl: CompressorGzip}}
+	c := &layer{compressor: compressor{impl: CompressorGzip, level: 1}}
+	diffid := v1.Hash{Algorithm: "sha256", Hex: "deadbeef"}
+	for _, l := range []*layer{a, b, c} {
+		l.diffid = &diffid
+	}
+	require.NotEqual(t, a.cacheKey(), b.cacheKey())
+	require.NotEqual(t, b.cacheKey(), c.cacheKey())
+}
+
+func TestCompressionJobs(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	for _, o := range []*options.Options{
+		{},
+		{CompressionThreads: 1},
+		{CompressionThreads: 2 * procs},
+		{LayerCompression: LayerCompressionZstd},
+		{Compressor: CompressorGzip},
+	} {
+		c := compressorFor(o)
+		require.GreaterOrEqual(t, c.jobs(), 1)
+		require.LessOrEqual(t, c.jobs()*c.threadsPerLayer(), max(procs, c.threadsPerLayer()), "%+v", o)
+	}
+	require.Equal(t, procs, compressorFor(&options.Options{Compressor: CompressorGzip}).jobs())
+}
@@ -0,0 +1,168 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"chainguard.dev/apko/pkg/baseimg"
+)
+
+// ociKeyPrefix marks a keyring entry that is an OCI artifact holding the
+// key in its only layer.
+const ociKeyPrefix = "oci://"
+
+// maxKeySize bounds the size of a key fetched from an OCI registry.
+const maxKeySize = 64 << 10
+
+// signatureVerifier returns the verifier of the cosign signatures that the
+// base image and the keys of the keyring in OCI registries must carry, or
+// nil if they need none.
+func (bc *Context) signatureVerifier() (*baseimg.Verifier, error) {
+	keyless := bc.o.BaseImageIdentity != "" || bc.o.BaseImageIssuer != ""
+	switch {
+	case bc.o.BaseImageKey != "" && keyless:
+		return nil, errors.New("base image signatures are verified either with a key or keyless, not both")
+	case bc.o.BaseImageKey != "":
+		pub, err := baseimg.LoadPublicKey(bc.o.BaseImageKey)
+		if err != nil {
+			return nil, err
+		}
+		return &baseimg.Verifier{Key: pub}, nil
+	case !keyless:
+		return nil, nil
+	case bc.o.BaseImageIdentity == "" || bc.o.BaseImageIssuer == "":
+		return nil, errors.New("keyless signatures are verified with both an identity and an issuer")
+	case bc.o.FulcioRoots == "" || bc.o.RekorKey == "":
+		return nil, errors.New("keyless signatures are verified against both Fulcio roots and a Rekor key")
+	}
+	roots, intermediates, err := baseimg.LoadCertificates(bc.o.FulcioRoots)
+	if err != nil {
+		return nil, err
+	}
+	rekor, err := baseimg.LoadPublicKey(bc.o.RekorKey)
+	if err != nil {
+		return nil, err
+	}
+	return &baseimg.Verifier{
+		Identity:      bc.o.BaseImageIdentity,
+		Issuer:        bc.o.BaseImageIssuer,
+		Roots:         roots,
+		Intermediates: intermediates,
+		RekorKey:      rekor,
+	}, nil
+}
+
+// fetchOCIKeys returns keyring with the keys in OCI registries, which must
+// be pinned by digest, replaced by local copies of them, named after the
+// title annotation of their layer. When a verifier is configured, the keys
+// must be signed as it requires.
+func (bc *Context) fetchOCIKeys(ctx context.Context, keyring []string) ([]string, error) {
+	var v *baseimg.Verifier
+	var loaded bool
+	out := make([]string, 0, len(keyring))
+	for _, key := range keyring {
+		ref, ok := strings.CutPrefix(key, ociKeyPrefix)
+		if !ok {
+			out = append(out, key)
+			continue
+		}
+		if !loaded {
+			var err error
+			if v, err = bc.signatureVerifier(); err != nil {
+				return nil, err
+			}
+			loaded = true
+		}
+		path, err := bc.fetchOCIKey(ctx, ref, v)
+		if err != nil {
+			return nil, fmt.Errorf("fetching key %s: %w", key, err)
+		}
+		out = append(out, path)
+	}
+	return out, nil
+}
+
+// fetchOCIKey writes the key in the OCI artifact ref, verified with v
+// unless it is nil, to the temporary directory and returns its path.
+func (bc *Context) fetchOCIKey(ctx context.Context, ref string, v *baseimg.Verifier) (string, error) {
+	d, err := name.NewDigest(ref)
+	if err != nil {
+		return "", fmt.Errorf("key is not pinned to a digest: %w", err)
+	}
+	opts := []remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	if v != nil {
+		if err := v.VerifyRemote(ctx, d, opts...); err != nil {
+			return "", err
+		}
+		clog.FromContext(ctx).Debugf("verified signature of key %s", d)
+	}
+
+	img, err := remote.Image(d, opts...)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return "", err
+	}
+	if len(manifest.Layers) != 1 {
+		return "", fmt.Errorf("artifact has %d layers, expected one holding the key", len(manifest.Layers))
+	}
+	// The key is installed under its name, which the signatures of the
+	// repository indexes refer to it by.
+	keyName := manifest.Layers[0].Annotations["org.opencontainers.image.title"]
+	if keyName == "" || keyName != filepath.Base(keyName) {
+		return "", errors.New("key layer has no file name in its org.opencontainers.image.title annotation")
+	}
+	layer, err := img.LayerByDigest(manifest.Layers[0].Digest)
+	if err != nil {
+		return "", err
+	}
+	// Keys are pushed as they are, not compressed, so the blob is the key.
+	rc, err := layer.Compressed()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxKeySize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxKeySize {
+		return "", fmt.Errorf("key is larger than %d bytes", maxKeySize)
+	}
+
+	dir := filepath.Join(bc.o.TempDir(), "oci-keys", strings.ReplaceAll(d.DigestStr(), ":", "-"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, keyName)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
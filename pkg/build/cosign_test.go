@@ -0,0 +1,108 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestFetchOCIKeys(t *testing.T) {
+	ctx := t.Context()
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/keys")
+	require.NoError(t, err)
+
+	// A key pushed as oras push does, with its file name as the title.
+	const keyData = "-----BEGIN PUBLIC KEY-----\n...\n-----END PUBLIC KEY-----\n"
+	push := func(title string) string {
+		img, err := mutate.Append(empty.Image, mutate.Addendum{
+			Layer:       static.NewLayer([]byte(keyData+title), "application/x-pem-file"),
+			Annotations: map[string]string{"org.opencontainers.image.title": title},
+		})
+		require.NoError(t, err)
+		img = mutate.MediaType(img, v1types.OCIManifestSchema1)
+		h, err := img.Digest()
+		require.NoError(t, err)
+		ref := repo.Digest(h.String())
+		require.NoError(t, remote.Write(ref, img))
+		return ref.String()
+	}
+	signed, unsigned := push("signed.rsa.pub"), push("unsigned.rsa.pub")
+
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	d, err := name.NewDigest(signed)
+	require.NoError(t, err)
+	payload := fmt.Appendf(nil, `{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, repo.String(), d.DigestStr())
+	h := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, signer, h[:])
+	require.NoError(t, err)
+	sigImg, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: map[string]string{"dev.cosignproject.cosign/signature": base64.StdEncoding.EncodeToString(sig)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(repo.Tag(strings.Replace(d.DigestStr(), ":", "-", 1)+".sig"), sigImg))
+
+	der, err := x509.MarshalPKIXPublicKey(&signer.PublicKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644))
+
+	// Without a key or identity to verify with, keys are only fetched.
+	bc := &Context{o: options.Options{TempDirPath: t.TempDir()}}
+	keyring, err := bc.fetchOCIKeys(ctx, []string{"https://example.com/key.rsa.pub", "oci://" + unsigned})
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/key.rsa.pub", keyring[0])
+	require.Equal(t, "unsigned.rsa.pub", filepath.Base(keyring[1]))
+	b, err := os.ReadFile(keyring[1])
+	require.NoError(t, err)
+	require.Equal(t, keyData+"unsigned.rsa.pub", string(b))
+
+	bc = &Context{o: options.Options{TempDirPath: t.TempDir(), BaseImageKey: keyPath}}
+	keyring, err = bc.fetchOCIKeys(ctx, []string{"oci://" + signed})
+	require.NoError(t, err)
+	require.Equal(t, "signed.rsa.pub", filepath.Base(keyring[0]))
+
+	_, err = bc.fetchOCIKeys(ctx, []string{"oci://" + unsigned})
+	require.ErrorContains(t, err, "no cosign signature")
+
+	_, err = bc.fetchOCIKeys(ctx, []string{"oci://" + repo.Tag("latest").String()})
+	require.ErrorContains(t, err, "not pinned to a digest")
+}
@@ -0,0 +1,408 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// debugInfoDir is where the debug files split from the image's ELF files are
+// laid out, by build ID, as gdb and debuginfod look them up.
+const debugInfoDir = "usr/lib/debug/.build-id"
+
+// splitDebugInfo strips the debug sections from the ELF files in the image
+// that have a build ID, and writes the files as they were to a layer laid
+// out under debugInfoDir, which DebugInfo returns as an image.
+func (bc *Context) splitDebugInfo(ctx context.Context) error {
+	if bc.o.SplitDebugPath == "" {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	_, span := otel.Tracer("apko").Start(ctx, "splitDebugInfo")
+	defer span.End()
+
+	// Each file is written to the layer as soon as it is stripped, so that
+	// only one is held in memory at a time.
+	var (
+		out *os.File
+		lw  *layerWriter
+	)
+	dirs, ids := map[string]bool{}, map[string]bool{}
+	write := func(id string, data []byte) error {
+		if ids[id] {
+			// A copy of a file already split.
+			return nil
+		}
+		ids[id] = true
+		if lw == nil {
+			var err error
+			if out, err = os.Create(filepath.Join(bc.o.TempDir(), "debuginfo.tar")); err != nil {
+				return err
+			}
+			lw = newLayerWriter(out, compressorFor(&bc.o))
+		}
+		name := path.Join(debugInfoDir, id[:2], id[2:]+".debug")
+		var parents []string
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			parents = append(parents, dir)
+		}
+		slices.Reverse(parents)
+		for _, dir := range parents {
+			if err := lw.w.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     dir + "/",
+				Mode:     0o755,
+				ModTime:  bc.o.SourceDateEpoch,
+			}); err != nil {
+				return err
+			}
+		}
+		if err := lw.w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(data)),
+			ModTime:  bc.o.SourceDateEpoch,
+		}); err != nil {
+			return err
+		}
+		_, err := lw.w.Write(data)
+		return err
+	}
+	err := stripDebugInfo(bc.fs, write)
+	if out != nil {
+		defer out.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("splitting debug info: %w", err)
+	}
+	log.Infof("split the debug info of %d ELF files", len(ids))
+	if lw == nil {
+		return nil
+	}
+
+	l, err := lw.finalize()
+	if err != nil {
+		return fmt.Errorf("finalizing debug info layer: %w", err)
+	}
+	l.comment = "debug files split from the image's ELF files"
+	bc.debugInfo = l
+	return nil
+}
+
+// DebugInfo returns an image of the debug files split from the ELF files of
+// the image, when built with WithSplitDebug, laid out under
+// /usr/lib/debug/.build-id as gdb and debuginfod look them up. It returns
+// nil if the image has no ELF files with debug info and a build ID.
+func (bc *Context) DebugInfo() (v1.Image, error) {
+	if bc.debugInfo == nil {
+		return nil, nil
+	}
+	bde, err := bc.GetBuildDateEpoch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine build date epoch: %w", err)
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: bc.debugInfo,
+		History: v1.History{
+			Author:    "apko",
+			CreatedBy: "apko",
+			Comment:   bc.debugInfo.comment,
+			Created:   v1.Time{Time: bde},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.DeepCopy()
+	platform := bc.Arch().ToOCIPlatform()
+	cfg.OS, cfg.Architecture, cfg.Variant = platform.OS, platform.Architecture, platform.Variant
+	cfg.Created = v1.Time{Time: bde}
+	return mutate.ConfigFile(img, cfg)
+}
+
+// stripDebugInfo strips the debug sections from the ELF files in fsys that
+// have a build ID, passing each file as it was to write with its build ID.
+// Files that are not writable are made so while they are stripped.
+func stripDebugInfo(fsys apkfs.FullFS, write func(id string, data []byte) error) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p == path.Dir(debugInfoDir) {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() || !isELF(fsys, p) {
+			return nil
+		}
+		data, err := fsys.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		stripped, id := stripELF(data)
+		if stripped == nil {
+			return nil
+		}
+		if err := write(id, data); err != nil {
+			return fmt.Errorf("/%s: %w", p, err)
+		}
+		return overwrite(fsys, p, stripped)
+	})
+}
+
+// overwrite replaces the contents of the file at p with data, lifting its
+// write protection for as long as it takes.
+func overwrite(fsys apkfs.FullFS, p string, data []byte) error {
+	fi, err := fsys.Stat(p)
+	if err != nil {
+		return err
+	}
+	mode := fi.Mode()
+	readOnly := mode.Perm()&0o200 == 0
+	if readOnly {
+		if err := fsys.Chmod(p, mode|0o200); err != nil {
+			return err
+		}
+	}
+	err = writeExisting(fsys, p, data)
+	if readOnly {
+		if cerr := fsys.Chmod(p, mode); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// writeExisting truncates the file at p and writes data to it.
+func writeExisting(fsys apkfs.FullFS, p string, data []byte) error {
+	f, err := fsys.OpenFile(p, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("/%s: %w", p, err)
+	}
+	return f.Close()
+}
+
+// isELF reports whether the file at p starts with the ELF magic number.
+func isELF(fsys apkfs.FullFS, p string) bool {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == elf.ELFMAG
+}
+
+// elfSection is the part of a section header stripELF looks at.
+type elfSection struct {
+	typ                  elf.SectionType
+	flags                elf.SectionFlag
+	off, size, addralign uint64
+	debug                bool
+}
+
+// stripELF returns data without the contents of its debug sections, which
+// are turned into empty SHT_NOBITS sections so that no section index
+// changes, along with the hex build ID of data. It returns a nil slice when
+// data has no build ID or no debug sections, or when it cannot be parsed or
+// is laid out in a way stripELF does not handle, e.g. with debug sections
+// inside a segment; such files are left as they are.
+func stripELF(data []byte) ([]byte, string) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, ""
+	}
+	defer f.Close()
+	id := buildID(f)
+	if id == "" {
+		return nil, ""
+	}
+
+	// Section headers, as debug/elf does not expose their raw offsets.
+	var (
+		is64      = f.Class == elf.ELFCLASS64
+		bo        = f.ByteOrder
+		phoffAt   = 0x1c
+		shoffAt   = 0x20
+		shentsize = 40
+		wordsize  = uint64(4)
+	)
+	if is64 {
+		phoffAt, shoffAt, shentsize, wordsize = 0x20, 0x28, 64, 8
+	}
+	// The program header entry size and count, and the section header entry
+	// size, follow the section header offset, the flags and the header size.
+	counts := shoffAt + int(wordsize) + 6
+	if len(data) < counts+8 || len(f.Sections) == 0 || int(bo.Uint16(data[counts+4:])) != shentsize {
+		return nil, ""
+	}
+	phoff, shoff := uint64(bo.Uint32(data[phoffAt:])), uint64(bo.Uint32(data[shoffAt:]))
+	if is64 {
+		phoff, shoff = bo.Uint64(data[phoffAt:]), bo.Uint64(data[shoffAt:])
+	}
+	phend := phoff + uint64(bo.Uint16(data[counts:]))*uint64(bo.Uint16(data[counts+2:]))
+	if shoff+uint64(len(f.Sections)*shentsize) > uint64(len(data)) {
+		return nil, ""
+	}
+	headers := data[shoff : shoff+uint64(len(f.Sections)*shentsize)]
+
+	sections := make([]elfSection, len(f.Sections))
+	cut := uint64(len(data))
+	for i, s := range f.Sections {
+		h := headers[i*shentsize:]
+		sec := elfSection{typ: s.Type, flags: s.Flags}
+		if is64 {
+			sec.off, sec.size, sec.addralign = bo.Uint64(h[24:]), bo.Uint64(h[32:]), bo.Uint64(h[48:])
+		} else {
+			sec.off, sec.size, sec.addralign = uint64(bo.Uint32(h[16:])), uint64(bo.Uint32(h[20:])), uint64(bo.Uint32(h[32:]))
+		}
+		if sec.typ != elf.SHT_NOBITS && sec.off+sec.size > uint64(len(data)) {
+			return nil, ""
+		}
+		sec.debug = sec.typ != elf.SHT_NOBITS && sec.flags&elf.SHF_ALLOC == 0 &&
+			(strings.HasPrefix(s.Name, ".debug") || strings.HasPrefix(s.Name, ".zdebug"))
+		if sec.debug {
+			cut = min(cut, sec.off)
+		}
+		sections[i] = sec
+	}
+	if cut == uint64(len(data)) {
+		return nil, ""
+	}
+
+	// Everything before the first debug section is kept as it is, which
+	// must include the segments and the sections loaded with them.
+	if phend > cut {
+		return nil, ""
+	}
+	for _, p := range f.Progs {
+		if p.Filesz != 0 && p.Off+p.Filesz > cut {
+			return nil, ""
+		}
+	}
+	for _, s := range sections {
+		if !s.debug && s.typ != elf.SHT_NOBITS && s.size != 0 && s.off < cut && s.off+s.size > cut {
+			return nil, ""
+		}
+	}
+
+	out := slices.Clone(data[:cut])
+	pad := func(align uint64) {
+		for align > 1 && uint64(len(out))%align != 0 {
+			out = append(out, 0)
+		}
+	}
+	for i, s := range sections {
+		switch {
+		case s.debug:
+			sections[i].typ, sections[i].off, sections[i].size = elf.SHT_NOBITS, cut, 0
+		case s.typ != elf.SHT_NOBITS && s.typ != elf.SHT_NULL && s.off >= cut:
+			pad(s.addralign)
+			sections[i].off = uint64(len(out))
+			out = append(out, data[s.off:s.off+s.size]...)
+		}
+	}
+
+	pad(wordsize)
+	newShoff := uint64(len(out))
+	out = append(out, headers...)
+	for i, s := range sections {
+		h := out[newShoff+uint64(i*shentsize):]
+		bo.PutUint32(h[4:], uint32(s.typ))
+		if is64 {
+			bo.PutUint64(h[24:], s.off)
+			bo.PutUint64(h[32:], s.size)
+		} else {
+			bo.PutUint32(h[16:], uint32(s.off))
+			bo.PutUint32(h[20:], uint32(s.size))
+		}
+	}
+	if is64 {
+		bo.PutUint64(out[shoffAt:], newShoff)
+	} else {
+		bo.PutUint32(out[shoffAt:], uint32(newShoff))
+	}
+	return out, id
+}
+
+// buildID returns the hex GNU build ID of f, or "" if it has none.
+func buildID(f *elf.File) string {
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		notes, err := s.Data()
+		if err != nil {
+			continue
+		}
+		if id := gnuBuildID(notes, f.ByteOrder); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// gnuBuildID returns the hex NT_GNU_BUILD_ID in notes, or "".
+func gnuBuildID(notes []byte, bo binary.ByteOrder) string {
+	const ntGNUBuildID = 3
+	align4 := func(n uint32) int { return int((n + 3) &^ 3) }
+	for len(notes) >= 12 {
+		namesz, descsz, typ := bo.Uint32(notes), bo.Uint32(notes[4:]), bo.Uint32(notes[8:])
+		notes = notes[12:]
+		if align4(namesz)+align4(descsz) > len(notes) {
+			return ""
+		}
+		name, desc := notes[:namesz], notes[align4(namesz):align4(namesz)+int(descsz)]
+		if typ == ntGNUBuildID && string(name) == "GNU\x00" && len(desc) >= 2 {
+			return hex.EncodeToString(desc)
+		}
+		notes = notes[align4(namesz)+align4(descsz):]
+	}
+	return ""
+}
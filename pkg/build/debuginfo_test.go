@@ -0,0 +1,162 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// testDebugELF returns an executable with the build ID id, a loaded .text
+// section, and .debug_info and .comment sections after it.
+func testDebugELF(t *testing.T, id []byte) []byte {
+	t.Helper()
+
+	const (
+		headerSize = 64
+		phdrSize   = 56
+	)
+	var note bytes.Buffer
+	require.NoError(t, binary.Write(&note, binary.LittleEndian, []uint32{4, uint32(len(id)), 3}))
+	note.WriteString("GNU\x00")
+	note.Write(id)
+	text := []byte{0xc3, 0xc3, 0xc3, 0xc3}
+	debugInfo := bytes.Repeat([]byte("dwarf"), 100)
+	comment := []byte("GCC: test\x00")
+	shstrtab := []byte("\x00.note.gnu.build-id\x00.text\x00.debug_info\x00.comment\x00.shstrtab\x00")
+
+	body := bytes.NewBuffer(make([]byte, headerSize+phdrSize))
+	offsets := map[string]int{}
+	for _, s := range []struct {
+		name string
+		data []byte
+	}{{"note", note.Bytes()}, {"text", text}, {"debug", debugInfo}, {"comment", comment}, {"shstrtab", shstrtab}} {
+		for body.Len()%8 != 0 {
+			body.WriteByte(0)
+		}
+		offsets[s.name] = body.Len()
+		body.Write(s.data)
+	}
+	for body.Len()%8 != 0 {
+		body.WriteByte(0)
+	}
+	shOff := body.Len()
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_NOTE), Flags: uint64(elf.SHF_ALLOC), Off: uint64(offsets["note"]), Size: uint64(note.Len()), Addralign: 4},
+		{Name: 20, Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR), Off: uint64(offsets["text"]), Size: uint64(len(text)), Addralign: 1},
+		{Name: 26, Type: uint32(elf.SHT_PROGBITS), Off: uint64(offsets["debug"]), Size: uint64(len(debugInfo)), Addralign: 1},
+		{Name: 38, Type: uint32(elf.SHT_PROGBITS), Off: uint64(offsets["comment"]), Size: uint64(len(comment)), Addralign: 1},
+		{Name: 47, Type: uint32(elf.SHT_STRTAB), Off: uint64(offsets["shstrtab"]), Size: uint64(len(shstrtab)), Addralign: 1},
+	}
+	require.NoError(t, binary.Write(body, binary.LittleEndian, sections))
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     headerSize,
+		Shoff:     uint64(shOff),
+		Ehsize:    headerSize,
+		Phentsize: phdrSize,
+		Phnum:     1,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  5,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	load := elf.Prog64{
+		Type:   uint32(elf.PT_LOAD),
+		Flags:  uint32(elf.PF_R | elf.PF_X),
+		Filesz: uint64(offsets["text"] + len(text)),
+		Memsz:  uint64(offsets["text"] + len(text)),
+		Align:  0x1000,
+	}
+
+	out := body.Bytes()
+	var headers bytes.Buffer
+	require.NoError(t, binary.Write(&headers, binary.LittleEndian, hdr))
+	require.NoError(t, binary.Write(&headers, binary.LittleEndian, load))
+	copy(out, headers.Bytes())
+	return out
+}
+
+func TestStripDebugInfo(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/bin", 0o755))
+	app := testDebugELF(t, []byte{0xab, 0xcd, 0xef, 0x01})
+	require.NoError(t, fsys.WriteFile("usr/bin/app", app, 0o755))
+	require.NoError(t, fsys.WriteFile("usr/bin/static", testELF(t), 0o755))
+	require.NoError(t, fsys.WriteFile("usr/bin/script", []byte("#!/bin/sh\n"), 0o755))
+	readOnly := testDebugELF(t, []byte{0x12, 0x34, 0x56, 0x78})
+	require.NoError(t, fsys.WriteFile("usr/bin/read-only", readOnly, 0o555))
+
+	debug := map[string][]byte{}
+	collect := func(id string, data []byte) error {
+		debug[id] = data
+		return nil
+	}
+	require.NoError(t, stripDebugInfo(fsys, collect))
+	require.Equal(t, map[string][]byte{"abcdef01": app, "12345678": readOnly}, debug)
+
+	// Read-only files are stripped and keep their mode.
+	b, err := fsys.ReadFile("usr/bin/read-only")
+	require.NoError(t, err)
+	require.Less(t, len(b), len(readOnly))
+	fi, err := fsys.Stat("usr/bin/read-only")
+	require.NoError(t, err)
+	require.Equal(t, 0o555, int(fi.Mode().Perm()))
+
+	// Without a build ID, the debug info could not be found again.
+	static, err := fsys.ReadFile("usr/bin/static")
+	require.NoError(t, err)
+	require.Equal(t, testELF(t), static)
+
+	stripped, err := fsys.ReadFile("usr/bin/app")
+	require.NoError(t, err)
+	require.Less(t, len(stripped), len(app))
+	fi, err = fsys.Stat("usr/bin/app")
+	require.NoError(t, err)
+	require.Equal(t, 0o755, int(fi.Mode().Perm()))
+
+	orig, err := elf.NewFile(bytes.NewReader(app))
+	require.NoError(t, err)
+	f, err := elf.NewFile(bytes.NewReader(stripped))
+	require.NoError(t, err)
+	require.Len(t, f.Sections, len(orig.Sections))
+	require.Equal(t, "abcdef01", buildID(f))
+	require.Equal(t, elf.SHT_NOBITS, f.Section(".debug_info").Type)
+	for _, name := range []string{".note.gnu.build-id", ".text", ".comment", ".shstrtab"} {
+		want, err := orig.Section(name).Data()
+		require.NoError(t, err)
+		got, err := f.Section(name).Data()
+		require.NoError(t, err, name)
+		require.Equal(t, want, got, name)
+	}
+
+	// Stripping again finds nothing left to strip.
+	clear(debug)
+	require.NoError(t, stripDebugInfo(fsys, collect))
+	require.Empty(t, debug)
+}
@@ -0,0 +1,123 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// DefaultsReport describes the image definition a build actually uses, and
+// every value in it that apko filled in rather than reading from the
+// configuration, so that reviewers see more than the YAML that was written.
+type DefaultsReport struct {
+	Defaults []AppliedDefault `json:"defaults"`
+	// Effective is the image configuration with the defaults applied.
+	Effective types.ImageConfiguration `json:"effective"`
+}
+
+// AppliedDefault is one value apko filled in.
+type AppliedDefault struct {
+	// Field names the configuration field, e.g. "environment.PATH".
+	Field string `json:"field"`
+	Value any    `json:"value"`
+	// Reason says why the value was applied.
+	Reason string `json:"reason"`
+}
+
+// DescribeDefaults compares the configuration as written with the one
+// about to be built, after architecture expansion and VCS detection, and
+// applies the remaining defaults that the build and image config apply
+// later, reporting those that the image configuration's own validation
+// fills in as they differ from before it.
+func DescribeDefaults(written, effective types.ImageConfiguration) (*DefaultsReport, error) {
+	r := &DefaultsReport{}
+	add := func(field string, value any, reason string) {
+		r.Defaults = append(r.Defaults, AppliedDefault{Field: field, Value: value, Reason: reason})
+	}
+
+	switch {
+	case len(written.Archs) == 0 && len(effective.Archs) != 0:
+		add("archs", effective.Archs, "no architectures were configured")
+	case !slices.Equal(written.Archs, effective.Archs):
+		add("archs", effective.Archs, "overridden by the --arch flag")
+	}
+
+	if written.VCSUrl == "" && effective.VCSUrl != "" {
+		add("vcs-url", effective.VCSUrl, "detected from the git checkout of the configuration file")
+	}
+
+	// Validate fills in defaults in place, so it is run on copies of what
+	// it changes.
+	validated := effective
+	validated.Contents.Packages = slices.Clone(effective.Contents.Packages)
+	validated.Accounts.Users = slices.Clone(effective.Accounts.Users)
+	if err := validated.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate configuration: %w", err)
+	}
+	if validated.Entrypoint.Command != effective.Entrypoint.Command {
+		add("entrypoint.command", validated.Entrypoint.Command, "entrypoint type is "+effective.Entrypoint.Type)
+	}
+	for _, pkg := range validated.Contents.Packages[len(effective.Contents.Packages):] {
+		add("contents.packages", pkg, "entrypoint type is "+effective.Entrypoint.Type)
+	}
+	for i, u := range validated.Accounts.Users {
+		if u.HomeDir != effective.Accounts.Users[i].HomeDir {
+			add(fmt.Sprintf("accounts.users[%d].homedir", i), u.HomeDir, "no home directory was configured")
+		}
+	}
+	effective = validated
+
+	effective.Environment = maps.Clone(effective.Environment)
+	if effective.Environment == nil {
+		effective.Environment = map[string]string{}
+	}
+	for _, k := range slices.Sorted(maps.Keys(oci.DefaultEnvironment)) {
+		if _, ok := effective.Environment[k]; !ok {
+			effective.Environment[k] = oci.DefaultEnvironment[k]
+			add("environment."+k, oci.DefaultEnvironment[k], "not set in the environment")
+		}
+	}
+
+	// These are left unset in the image config, and so fall back to the
+	// container runtime's defaults.
+	if effective.Accounts.RunAs == "" {
+		add("accounts.run-as", "root", "no user to run as was configured, so the runtime runs as root")
+	}
+	if effective.WorkDir == "" {
+		add("work-dir", "/", "no working directory was configured, so the runtime starts in /")
+	}
+
+	r.Effective = effective
+	return r, nil
+}
+
+// WriteFile writes the report to path as JSON.
+func (r *DefaultsReport) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling defaults report: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing defaults report: %w", err)
+	}
+	return nil
+}
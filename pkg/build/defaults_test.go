@@ -0,0 +1,102 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestDescribeDefaults(t *testing.T) {
+	written := types.ImageConfiguration{
+		Environment: map[string]string{"PATH": "/bin"},
+		WorkDir:     "/app",
+		Accounts: types.ImageAccounts{
+			RunAs: "nonroot",
+			Users: []types.User{{UserName: "nonroot", UID: 65532}, {UserName: "other", UID: 1000, HomeDir: "/other"}},
+		},
+	}
+	effective := written
+	effective.Archs = types.AllArchs
+	effective.VCSUrl = "https://github.com/example/repo@abc"
+
+	r, err := build.DescribeDefaults(written, effective)
+	require.NoError(t, err)
+
+	fields := map[string]any{}
+	for _, d := range r.Defaults {
+		fields[d.Field] = d.Value
+	}
+	require.Equal(t, map[string]any{
+		"archs":                     types.AllArchs,
+		"vcs-url":                   "https://github.com/example/repo@abc",
+		"environment.SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
+		"accounts.users[0].homedir": "/home/nonroot",
+	}, fields)
+
+	require.Equal(t, "/bin", r.Effective.Environment["PATH"])
+	require.Equal(t, "/home/nonroot", r.Effective.Accounts.Users[0].HomeDir)
+	// The configuration passed in is left alone.
+	require.Len(t, written.Environment, 1)
+	require.Empty(t, written.Accounts.Users[0].HomeDir)
+
+	t.Run("runtime defaults", func(t *testing.T) {
+		ic := types.ImageConfiguration{Archs: []types.Architecture{types.ParseArchitecture("x86_64")}}
+		r, err := build.DescribeDefaults(ic, ic)
+		require.NoError(t, err)
+		fields := map[string]any{}
+		for _, d := range r.Defaults {
+			fields[d.Field] = d.Value
+		}
+		require.Equal(t, "root", fields["accounts.run-as"])
+		require.Equal(t, "/", fields["work-dir"])
+		require.NotContains(t, fields, "archs")
+	})
+
+	t.Run("service bundle", func(t *testing.T) {
+		ic := types.ImageConfiguration{
+			Entrypoint: types.ImageEntrypoint{Type: "service-bundle", Services: map[string]string{"web": "/bin/web"}},
+			Contents:   types.ImageContents{Packages: []string{"web"}},
+		}
+		r, err := build.DescribeDefaults(ic, ic)
+		require.NoError(t, err)
+		fields := map[string]any{}
+		for _, d := range r.Defaults {
+			fields[d.Field] = d.Value
+		}
+		require.Equal(t, "/bin/s6-svscan /sv", fields["entrypoint.command"])
+		require.Equal(t, "s6", fields["contents.packages"])
+		require.Equal(t, []string{"web", "s6"}, r.Effective.Contents.Packages)
+		require.Equal(t, []string{"web"}, ic.Contents.Packages)
+	})
+
+	t.Run("write file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "defaults.json")
+		require.NoError(t, r.WriteFile(path))
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var got build.DefaultsReport
+		require.NoError(t, json.Unmarshal(b, &got))
+		require.Len(t, got.Defaults, len(r.Defaults))
+		require.Equal(t, "/app", got.Effective.WorkDir)
+	})
+}
@@ -0,0 +1,253 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	ldsocache "chainguard.dev/apko/internal/ldso-cache"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// defaultLibDirs are searched by the dynamic linker whatever the
+// configuration.
+var defaultLibDirs = []string{"lib", "usr/lib", "lib64", "usr/lib64"}
+
+// unresolvedELFDep is a library an ELF file needs that is not in the image.
+type unresolvedELFDep struct {
+	file    string
+	library string
+}
+
+func (d unresolvedELFDep) String() string {
+	return fmt.Sprintf("/%s needs %s, which is not in the image", d.file, d.library)
+}
+
+// findUnresolvedELFDeps looks for the DT_NEEDED entries of the ELF files in
+// fsys that are found neither in their run paths nor in the directories the
+// dynamic linker searches.
+func findUnresolvedELFDeps(fsys apkfs.FullFS) ([]unresolvedELFDep, error) {
+	libDirs, err := linkerLibDirs(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var deps []unresolvedELFDep
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		needed, runPaths, err := readDynamic(fsys, p)
+		if err != nil || len(needed) == 0 {
+			// Not an ELF file, or not a dynamically linked one.
+			return nil
+		}
+		for _, lib := range needed {
+			if !resolveLibrary(fsys, lib, slices.Concat(runPaths, libDirs)) {
+				deps = append(deps, unresolvedELFDep{file: p, library: lib})
+			}
+		}
+		return nil
+	})
+	return deps, err
+}
+
+// readDynamic returns the libraries the ELF file at p needs, and the
+// directories in its run paths, with $ORIGIN expanded.
+func readDynamic(fsys apkfs.FullFS, p string) ([]string, []string, error) {
+	r, err := fsys.OpenReaderAt(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return nil, nil, nil
+	}
+
+	needed, err := f.ImportedLibraries()
+	if err != nil {
+		return nil, nil, err
+	}
+	var runPaths []string
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		paths, err := f.DynString(tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rp := range paths {
+			for _, dir := range strings.Split(rp, ":") {
+				dir = strings.NewReplacer("${ORIGIN}", "/"+path.Dir(p), "$ORIGIN", "/"+path.Dir(p)).Replace(dir)
+				runPaths = append(runPaths, strings.TrimPrefix(path.Clean(dir), "/"))
+			}
+		}
+	}
+	return needed, runPaths, nil
+}
+
+// linkerLibDirs returns the directories that the glibc or musl dynamic
+// linker of the image searches for libraries.
+func linkerLibDirs(fsys apkfs.FullFS) ([]string, error) {
+	dirs := slices.Clone(defaultLibDirs)
+	add := func(dir string) {
+		dir = strings.TrimPrefix(path.Clean("/"+dir), "/")
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	if _, err := fsys.Stat("etc/ld.so.conf"); err == nil {
+		conf, err := ldsocache.ParseLDSOConf(fsys, "etc/ld.so.conf")
+		if err != nil {
+			return nil, fmt.Errorf("parsing /etc/ld.so.conf: %w", err)
+		}
+		for _, dir := range conf {
+			add(dir)
+		}
+	}
+
+	muslPaths, err := fs.Glob(fsys, "etc/ld-musl-*.path")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range muslPaths {
+		b, err := fsys.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range strings.FieldsFunc(string(b), func(r rune) bool { return r == ':' || r == '\n' }) {
+			add(dir)
+		}
+	}
+	return dirs, nil
+}
+
+// resolveLibrary reports whether lib is in one of dirs, or, if it is a path,
+// whether it exists.
+func resolveLibrary(fsys apkfs.FullFS, lib string, dirs []string) bool {
+	if strings.Contains(lib, "/") {
+		return existsInRoot(fsys, strings.TrimPrefix(path.Clean(lib), "/"))
+	}
+	for _, dir := range dirs {
+		if existsInRoot(fsys, path.Join(dir, lib)) {
+			return true
+		}
+	}
+	return false
+}
+
+// findDynamicELFs returns the ELF files in fsys that are dynamically linked:
+// those with a program interpreter or with libraries they need.
+func findDynamicELFs(fsys apkfs.FullFS) ([]string, error) {
+	var dynamic []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		r, err := fsys.OpenReaderAt(p)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		f, err := elf.NewFile(r)
+		if err != nil {
+			// Not an ELF file.
+			return nil
+		}
+		defer f.Close()
+		if slices.ContainsFunc(f.Progs, func(prog *elf.Prog) bool { return prog.Type == elf.PT_INTERP }) {
+			dynamic = append(dynamic, p)
+			return nil
+		}
+		if needed, err := f.ImportedLibraries(); err == nil && len(needed) != 0 {
+			dynamic = append(dynamic, p)
+		}
+		return nil
+	})
+	return dynamic, err
+}
+
+// checkStatic fails the build if WithRequireStatic was given and the image
+// holds dynamically linked ELF files.
+func (bc *Context) checkStatic(ctx context.Context) error {
+	if !bc.o.RequireStatic {
+		return nil
+	}
+
+	_, span := otel.Tracer("apko").Start(ctx, "checkStatic")
+	defer span.End()
+
+	dynamic, err := findDynamicELFs(bc.fs)
+	if err != nil {
+		return fmt.Errorf("looking for dynamically linked files: %w", err)
+	}
+	if len(dynamic) == 0 {
+		return nil
+	}
+	errs := make([]error, 0, len(dynamic))
+	for _, p := range dynamic {
+		errs = append(errs, fmt.Errorf("/%s is dynamically linked", p))
+	}
+	return fmt.Errorf("image must only contain statically linked binaries:\n%w", errors.Join(errs...))
+}
+
+// checkELFDeps reports the libraries that ELF files in the image need but
+// that are not in it, failing the build in options.CheckFail mode.
+func (bc *Context) checkELFDeps(ctx context.Context) error {
+	if bc.o.ELFDeps == options.CheckOff {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	_, span := otel.Tracer("apko").Start(ctx, "checkELFDeps")
+	defer span.End()
+
+	deps, err := findUnresolvedELFDeps(bc.fs)
+	if err != nil {
+		return fmt.Errorf("checking ELF dependencies: %w", err)
+	}
+	if bc.o.ELFDeps == options.CheckWarn {
+		for _, d := range deps {
+			log.Warn(d.String())
+		}
+		return nil
+	}
+	errs := make([]error, 0, len(deps))
+	for _, d := range deps {
+		errs = append(errs, errors.New(d.String()))
+	}
+	return errors.Join(errs...)
+}
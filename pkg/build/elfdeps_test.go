@@ -0,0 +1,71 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestFindUnresolvedELFDeps(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	for _, dir := range []string{"lib", "usr/bin", "usr/lib", "usr/local/lib", "opt/app/bin", "opt/app/lib", "etc"} {
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+	}
+	write := func(p string, b []byte) {
+		require.NoError(t, fsys.WriteFile(p, b, 0o755))
+	}
+
+	write("lib/ld-musl-x86_64.so.1", testELF(t))
+	require.NoError(t, fsys.Symlink("/lib/ld-musl-x86_64.so.1", "lib/libc.musl-x86_64.so.1"))
+	write("usr/local/lib/libextra.so.1", testELF(t))
+	write("etc/ld-musl-x86_64.path", []byte("/lib:/usr/local/lib\n/usr/lib"))
+	write("opt/app/lib/libapp.so", testELF(t, "libc.musl-x86_64.so.1"))
+	require.NoError(t, fsys.Symlink("libgone.so.1.0", "usr/lib/libgone.so.1"))
+	write("usr/bin/script", []byte("#!/bin/sh\n"))
+
+	write("usr/bin/tool", testELF(t, "libc.musl-x86_64.so.1", "libextra.so.1", "libmissing.so.2", "libgone.so.1"))
+	write("opt/app/bin/app", testELFWithRunPath(t, "$ORIGIN/../lib", "libapp.so", "/lib/ld-musl-x86_64.so.1"))
+	write("usr/bin/static", testELF(t))
+
+	deps, err := findUnresolvedELFDeps(fsys)
+	require.NoError(t, err)
+	require.Equal(t, []unresolvedELFDep{
+		{file: "usr/bin/tool", library: "libmissing.so.2"},
+		{file: "usr/bin/tool", library: "libgone.so.1"},
+	}, deps)
+	require.Equal(t, "/usr/bin/tool needs libmissing.so.2, which is not in the image", deps[0].String())
+}
+
+func TestFindDynamicELFs(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/bin", 0o755))
+	require.NoError(t, fsys.MkdirAll("usr/lib", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/bin/static", testELF(t), 0o755))
+	require.NoError(t, fsys.WriteFile("usr/bin/script", []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, fsys.WriteFile("usr/lib/libfoo.so.1", testELF(t, "libc.so.6"), 0o755))
+
+	dynamic, err := findDynamicELFs(fsys)
+	require.NoError(t, err)
+	require.Equal(t, []string{"usr/lib/libfoo.so.1"}, dynamic)
+
+	require.NoError(t, fsys.WriteFile("usr/bin/app", testELF(t, "libfoo.so.1"), 0o755))
+	dynamic, err = findDynamicELFs(fsys)
+	require.NoError(t, err)
+	require.Equal(t, []string{"usr/bin/app", "usr/lib/libfoo.so.1"}, dynamic)
+}
@@ -0,0 +1,200 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	stdgzip "compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// convertToEstargz rewrites the uncompressed tar of l as an eStargz blob,
+// whose table of contents lets lazy-pulling runtimes fetch files as they
+// are needed. Unlike other compressions this changes the tar itself, adding
+// the table of contents to it, so the diffID of l is replaced with that of
+// the blob, and its uncompressed form with the blob uncompressed. Only the
+// blob is kept on disk: the tar is removed once estargz has built the blob
+// from it, and the uncompressed form is decompressed as it is read.
+func (l *layer) convertToEstargz() error {
+	in, err := os.Open(l.uncompressed)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	level := l.compressor.level
+	if level == 0 {
+		level = stdgzip.BestCompression
+	}
+	blob, err := estargz.Build(io.NewSectionReader(in, 0, stat.Size()), estargz.WithCompression(newEstargzCompression(level)))
+	if err != nil {
+		return fmt.Errorf("building estargz layer: %w", err)
+	}
+	// The blob is read from the temporary files estargz compressed the tar
+	// into.
+	if err := os.Remove(l.uncompressed); err != nil {
+		blob.Close()
+		return err
+	}
+
+	compressed := l.uncompressed + ".gz"
+	out, err := os.Create(compressed)
+	if err != nil {
+		blob.Close()
+		return err
+	}
+	defer out.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), blob)
+	if err != nil {
+		blob.Close()
+		return fmt.Errorf("writing %s: %w", compressed, err)
+	}
+	if err := blob.Close(); err != nil {
+		return err
+	}
+	uncompressedSize, err := blob.UncompressedSize()
+	if err != nil {
+		return err
+	}
+	diffid, err := v1.NewHash(blob.DiffID().String())
+	if err != nil {
+		return err
+	}
+
+	l.compressed = compressed
+	l.diffid = &diffid
+	l.desc.Digest = v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))}
+	l.desc.Size = size
+	if l.annotations == nil {
+		l.annotations = map[string]string{}
+	}
+	l.annotations[estargz.TOCJSONDigestAnnotation] = blob.TOCDigest().String()
+	l.annotations[estargz.StoreUncompressedSizeAnnotation] = strconv.FormatInt(uncompressedSize, 10)
+
+	descCopy := *l.desc
+	compressionCache.Store(l.cacheKey(), &descCopy)
+	return nil
+}
+
+// estargzUncompressed returns the eStargz blob of l as it decompresses,
+// which is what its diffID is the digest of.
+func (l *layer) estargzUncompressed() (io.ReadCloser, error) {
+	f, err := os.Open(l.compressed)
+	if err != nil {
+		return nil, err
+	}
+	// The blob has a gzip member per chunk, which the reader reads on.
+	zr, err := stdgzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFileReader{zr, f}, nil
+}
+
+type gzipFileReader struct {
+	*stdgzip.Reader
+	f *os.File
+}
+
+func (r *gzipFileReader) Close() error {
+	return errors.Join(r.Reader.Close(), r.f.Close())
+}
+
+// estargzCompression is the gzip compression of estargz, but for the footer,
+// which estargz derives from the output of compress/gzip, expecting it to be
+// exactly FooterSize bytes long and panicking otherwise. Recent versions of
+// compress/gzip write it in more bytes, so the footer is written here
+// instead, as the format describes it.
+type estargzCompression struct {
+	*estargz.GzipCompressor
+	*estargz.GzipDecompressor
+}
+
+func newEstargzCompression(level int) estargzCompression {
+	return estargzCompression{estargz.NewGzipCompressorWithLevel(level), &estargz.GzipDecompressor{}}
+}
+
+// WriteTOCAndFooter writes the table of contents as a tar entry of its own
+// gzip member, followed by the footer pointing at it.
+func (c estargzCompression) WriteTOCAndFooter(w io.Writer, off int64, toc *estargz.JTOC, diffHash hash.Hash) (digest.Digest, error) {
+	tocJSON, err := json.MarshalIndent(toc, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	zw, err := c.Writer(w)
+	if err != nil {
+		return "", err
+	}
+	tocw := io.Writer(zw)
+	if diffHash != nil {
+		tocw = io.MultiWriter(zw, diffHash)
+	}
+	tw := tar.NewWriter(tocw)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: estargz.TOCTarName, Size: int64(len(tocJSON))}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(tocJSON); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	if _, err := w.Write(estargzFooter(off)); err != nil {
+		return "", err
+	}
+	return digest.FromBytes(tocJSON), nil
+}
+
+// estargzFooter returns the footer of an eStargz blob whose table of
+// contents is at tocOffset: an empty gzip member whose extra field holds
+// the offset.
+func estargzFooter(tocOffset int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOffset)
+	var b bytes.Buffer
+	// ID, deflate, FEXTRA, no mtime, no extra flags, unknown OS.
+	b.Write([]byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff})
+	_ = binary.Write(&b, binary.LittleEndian, uint16(4+len(subfield)))
+	b.Write([]byte{'S', 'G'})
+	_ = binary.Write(&b, binary.LittleEndian, uint16(len(subfield)))
+	b.WriteString(subfield)
+	// A final, empty, stored deflate block, and the CRC-32 and size of
+	// nothing.
+	b.Write([]byte{1, 0, 0, 0xff, 0xff})
+	b.Write(make([]byte, 8))
+	return b.Bytes()
+}
@@ -0,0 +1,138 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	stdgzip "compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestEstargzLayers(t *testing.T) {
+	build := func(t *testing.T) *layer {
+		dir := t.TempDir()
+		f, err := os.Create(filepath.Join(dir, "layer.tar"))
+		require.NoError(t, err)
+		defer f.Close()
+
+		lw := newLayerWriter(f, compressorFor(&options.Options{LayerCompression: LayerCompressionEstargz}))
+		require.NoError(t, lw.w.WriteHeader(&tar.Header{Name: "usr/", Mode: 0o755, Typeflag: tar.TypeDir}))
+		for _, name := range []string{"usr/hello", "usr/world"} {
+			require.NoError(t, lw.w.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+			_, err = lw.w.Write([]byte(name))
+			require.NoError(t, err)
+		}
+		l, err := lw.finalize()
+		require.NoError(t, err)
+
+		// Only the blob is kept.
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "layer.tar.gz", entries[0].Name())
+		return l
+	}
+	l := build(t)
+
+	mt, err := l.MediaType()
+	require.NoError(t, err)
+	require.Equal(t, v1types.OCILayer, mt)
+
+	// The digests are those of the eStargz blob and of its uncompressed
+	// form, which has the table of contents in it.
+	compressed := readLayer(t, l.Compressed)
+	digest, err := l.Digest()
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%x", sha256.Sum256(compressed)), digest.Hex)
+	size, err := l.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(len(compressed)), size)
+
+	uncompressed := readLayer(t, l.Uncompressed)
+	diffid, err := l.DiffID()
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%x", sha256.Sum256(uncompressed)), diffid.Hex)
+	require.Equal(t, fmt.Sprint(len(uncompressed)), l.Annotations()[estargz.StoreUncompressedSizeAnnotation])
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(uncompressed))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.Equal(t, []string{estargz.NoPrefetchLandmark, "usr/", "usr/hello", "usr/world", estargz.TOCTarName}, names)
+
+	// Lazy-pulling runtimes find the files through the table of contents,
+	// whose digest is annotated.
+	r, err := estargz.Open(io.NewSectionReader(bytes.NewReader(compressed), 0, int64(len(compressed))))
+	require.NoError(t, err)
+	require.Equal(t, r.TOCDigest().String(), l.Annotations()[estargz.TOCJSONDigestAnnotation])
+	sr, err := r.OpenFile("usr/world")
+	require.NoError(t, err)
+	b := make([]byte, len("usr/world"))
+	_, err = sr.ReadAt(b, 0)
+	require.NoError(t, err)
+	require.Equal(t, "usr/world", string(b))
+
+	// Building the same layer again gives the same digests.
+	again := build(t)
+	againDigest, err := again.Digest()
+	require.NoError(t, err)
+	require.Equal(t, digest, againDigest)
+}
+
+func TestEstargzFooter(t *testing.T) {
+	for _, off := range []int64{0, 1234, 1 << 40} {
+		footer := estargzFooter(off)
+		require.Len(t, footer, estargz.FooterSize)
+
+		// It is an empty gzip member, which estargz finds the table of
+		// contents through.
+		zr, err := stdgzip.NewReader(bytes.NewReader(footer))
+		require.NoError(t, err)
+		b, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		require.Empty(t, b)
+		_, tocOffset, _, err := (&estargz.GzipDecompressor{}).ParseFooter(footer)
+		require.NoError(t, err)
+		require.Equal(t, off, tocOffset)
+	}
+}
+
+func readLayer(t *testing.T, open func() (io.ReadCloser, error)) []byte {
+	t.Helper()
+	rc, err := open()
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return b
+}
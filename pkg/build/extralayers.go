@@ -0,0 +1,95 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// LayerSourceAnnotation is set on the manifest descriptor of every layer
+// added with WithExtraLayers, recording the caller-supplied source. SBOMs
+// carry it through to the layer's package entry.
+const LayerSourceAnnotation = "dev.chainguard.apko.layer.source"
+
+// ExtraLayer is a layer built by the caller rather than by apko.
+type ExtraLayer struct {
+	// Layer is the layer to add.
+	Layer v1.Layer
+	// Source describes where the layer came from, e.g. a build step or a
+	// file name. It is required.
+	Source string
+	// Below places the layer beneath the layers apko builds rather than on
+	// top of them.
+	Below bool
+}
+
+// ExtraLayerFromTar returns an ExtraLayer read from the tarball that opener
+// returns. The tarball may be uncompressed or gzip-compressed.
+func ExtraLayerFromTar(source string, opener tarball.Opener, below bool) (ExtraLayer, error) {
+	l, err := tarball.LayerFromOpener(opener, tarball.WithMediaType(v1types.OCILayer))
+	if err != nil {
+		return ExtraLayer{}, fmt.Errorf("reading layer from %s: %w", source, err)
+	}
+	return ExtraLayer{Layer: l, Source: source, Below: below}, nil
+}
+
+// annotatedLayer attaches descriptor annotations and a history comment to a
+// layer.
+type annotatedLayer struct {
+	v1.Layer
+	annotations map[string]string
+	comment     string
+}
+
+// Annotations returns the annotations for the layer's manifest descriptor.
+func (l *annotatedLayer) Annotations() map[string]string {
+	return l.annotations
+}
+
+// Comment describes the layer in the image history.
+func (l *annotatedLayer) Comment() string {
+	return l.comment
+}
+
+// withExtraLayers returns layers with the context's extra layers added below
+// and above them, in the order they were given.
+func (bc *Context) withExtraLayers(layers []v1.Layer) []v1.Layer {
+	if len(bc.extraLayers) == 0 {
+		return layers
+	}
+
+	var below, above []v1.Layer
+	for _, el := range bc.extraLayers {
+		l := &annotatedLayer{
+			Layer:       el.Layer,
+			annotations: map[string]string{LayerSourceAnnotation: el.Source},
+			comment:     "extra layer " + el.Source,
+		}
+		if el.Below {
+			below = append(below, l)
+		} else {
+			above = append(above, l)
+		}
+	}
+
+	out := make([]v1.Layer, 0, len(below)+len(layers)+len(above))
+	out = append(out, below...)
+	out = append(out, layers...)
+	return append(out, above...)
+}
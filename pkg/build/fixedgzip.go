@@ -0,0 +1,238 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+const (
+	// fixedWindow is the largest distance deflate can refer back.
+	fixedWindow = 1 << 15
+	// fixedMinMatch and fixedMaxMatch bound the length of a match.
+	fixedMinMatch = 4
+	fixedMaxMatch = 258
+	// fixedHashBits sizes the table of positions the matcher looks up.
+	fixedHashBits = 15
+	// fixedBufferSize is how much input is buffered before it is encoded.
+	fixedBufferSize = 1 << 18
+)
+
+var (
+	fixedLengthBase  = [...]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	fixedLengthExtra = [...]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	fixedDistBase    = [...]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	fixedDistExtra   = [...]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+)
+
+// fixedGzipWriter writes gzip streams that depend on nothing but their
+// input. The deflate data uses only the fixed Huffman codes of RFC 1951 and
+// the greedy matcher below, so that, unlike the output of compress/gzip or
+// klauspost/compress, it does not change with the Go or library version, the
+// compression level or the number of CPUs.
+//
+// The matcher only considers a position once fixedMaxMatch bytes follow it,
+// or on Close, so the output does not depend on how writes are split either.
+type fixedGzipWriter struct {
+	w   io.Writer
+	out []byte
+	// acc holds the nbits bits that are not yet in out.
+	acc   uint64
+	nbits uint
+
+	// buf holds up to fixedWindow bytes of history followed by the input
+	// not encoded yet, which starts at buf[pos]. base is the offset of
+	// buf[0] in the stream.
+	buf  []byte
+	pos  int
+	base int64
+	// head maps the hash of fixedMinMatch bytes to one past the stream
+	// offset they were last seen at.
+	head []int64
+
+	crc    uint32
+	size   uint32
+	closed bool
+	err    error
+}
+
+func newFixedGzipWriter(w io.Writer) *fixedGzipWriter {
+	z := &fixedGzipWriter{
+		w:    w,
+		buf:  make([]byte, 0, fixedWindow+fixedBufferSize),
+		head: make([]int64, 1<<fixedHashBits),
+	}
+	// The header leaves the modification time, flags and name unset, and
+	// records the operating system as unknown.
+	z.out = append(z.out, 0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff)
+	// The whole stream is a single block, which is not final.
+	z.bits(0b010, 3)
+	return z
+}
+
+func (z *fixedGzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("write to closed writer")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+	n := len(p)
+	for len(p) > 0 {
+		if len(z.buf) == cap(z.buf) {
+			z.slide()
+		}
+		c := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+c]
+		p = p[c:]
+		if len(z.buf) == cap(z.buf) {
+			z.encode(len(z.buf) - fixedMaxMatch)
+			if err := z.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (z *fixedGzipWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	z.encode(len(z.buf))
+	// End the block, then add an empty final one.
+	z.literal(256)
+	z.bits(0b011, 3)
+	z.literal(256)
+	if z.nbits > 0 {
+		z.bits(0, 8-z.nbits%8)
+	}
+	z.out = binary.LittleEndian.AppendUint32(z.out, z.crc)
+	z.out = binary.LittleEndian.AppendUint32(z.out, z.size)
+	return z.flush()
+}
+
+// slide drops all but the last fixedWindow bytes of history from buf.
+func (z *fixedGzipWriter) slide() {
+	drop := z.pos - fixedWindow
+	if drop <= 0 {
+		return
+	}
+	z.buf = z.buf[:copy(z.buf, z.buf[drop:])]
+	z.pos -= drop
+	z.base += int64(drop)
+}
+
+// encode encodes the input up to buf[end].
+func (z *fixedGzipWriter) encode(end int) {
+	for z.pos < end {
+		p := z.pos
+		if p+fixedMinMatch > len(z.buf) {
+			z.literal(int(z.buf[p]))
+			z.pos++
+			continue
+		}
+		h := binary.LittleEndian.Uint32(z.buf[p:]) * 0x9e3779b1 >> (32 - fixedHashBits)
+		at := z.base + int64(p)
+		prev := z.head[h] - 1
+		z.head[h] = at + 1
+
+		length := 0
+		if prev >= 0 && at-prev <= fixedWindow {
+			q := int(prev - z.base)
+			limit := min(fixedMaxMatch, len(z.buf)-p)
+			for length < limit && z.buf[q+length] == z.buf[p+length] {
+				length++
+			}
+		}
+		if length < fixedMinMatch {
+			z.literal(int(z.buf[p]))
+			z.pos++
+			continue
+		}
+		z.match(length, int(at-prev))
+		z.pos += length
+	}
+}
+
+// literal writes the fixed code of a literal byte or length symbol.
+func (z *fixedGzipWriter) literal(sym int) {
+	switch {
+	case sym < 144:
+		z.code(0x30+sym, 8)
+	case sym < 256:
+		z.code(0x190+sym-144, 9)
+	case sym < 280:
+		z.code(sym-256, 7)
+	default:
+		z.code(0xc0+sym-280, 8)
+	}
+}
+
+// match writes a back reference of length bytes, distance bytes back.
+func (z *fixedGzipWriter) match(length, distance int) {
+	i := len(fixedLengthBase) - 1
+	for fixedLengthBase[i] > length {
+		i--
+	}
+	z.literal(257 + i)
+	z.bits(uint64(length-fixedLengthBase[i]), fixedLengthExtra[i])
+
+	j := len(fixedDistBase) - 1
+	for fixedDistBase[j] > distance {
+		j--
+	}
+	z.code(j, 5)
+	z.bits(uint64(distance-fixedDistBase[j]), fixedDistExtra[j])
+}
+
+// code writes a Huffman code, which deflate packs starting with its most
+// significant bit.
+func (z *fixedGzipWriter) code(c int, n uint) {
+	z.bits(uint64(bits.Reverse16(uint16(c))>>(16-n)), n)
+}
+
+// bits writes the n low bits of v, least significant bit first.
+func (z *fixedGzipWriter) bits(v uint64, n uint) {
+	z.acc |= v << z.nbits
+	z.nbits += n
+	for z.nbits >= 8 {
+		z.out = append(z.out, byte(z.acc))
+		z.acc >>= 8
+		z.nbits -= 8
+	}
+}
+
+func (z *fixedGzipWriter) flush() error {
+	if len(z.out) == 0 {
+		return nil
+	}
+	if _, err := z.w.Write(z.out); err != nil {
+		z.err = err
+		return err
+	}
+	z.out = z.out[:0]
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/release-utils/version"
+)
+
+// Annotations recording the inputs an image was built from.
+const (
+	AnnotationApkoVersion    = "dev.chainguard.apko.version"
+	AnnotationConfigDigest   = "dev.chainguard.apko.config.digest"
+	AnnotationLockDigest     = "dev.chainguard.apko.lock.digest"
+	AnnotationBuilderID      = "dev.chainguard.apko.builder.id"
+	AnnotationBuilderVersion = "dev.chainguard.apko.builder.version"
+)
+
+// applyInputAnnotations records the apko version, the builder and the digests
+// of the configuration and lock files in the image annotations, if asked to.
+// Annotations set explicitly are left alone.
+func (bc *Context) applyInputAnnotations() error {
+	if !bc.o.InputAnnotations {
+		return nil
+	}
+
+	annotations := map[string]string{
+		AnnotationApkoVersion: version.GetVersionInfo().GitVersion,
+	}
+	if sum, ok := strings.CutPrefix(bc.o.ImageConfigChecksum, "sha256-"); ok {
+		b, err := base64.StdEncoding.DecodeString(sum)
+		if err != nil {
+			return fmt.Errorf("decoding config checksum: %w", err)
+		}
+		annotations[AnnotationConfigDigest] = "sha256:" + hex.EncodeToString(b)
+	}
+	if bc.o.BuilderID != "" {
+		annotations[AnnotationBuilderID] = bc.o.BuilderID
+		if bc.o.BuilderVersion != "" {
+			annotations[AnnotationBuilderVersion] = bc.o.BuilderVersion
+		}
+	}
+	if bc.o.Lockfile != "" {
+		digest, err := lockDigest(bc.lockfile())
+		if err != nil {
+			return err
+		}
+		annotations[AnnotationLockDigest] = digest
+	}
+
+	if bc.ic.Annotations == nil {
+		bc.ic.Annotations = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		if _, ok := bc.ic.Annotations[k]; !ok {
+			bc.ic.Annotations[k] = v
+		}
+	}
+	return nil
+}
+
+// lockDigest returns the digest of the lock file at path.
+func lockDigest(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading lockfile %s: %w", path, err)
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b)), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build"
+)
+
+func TestInputAnnotations(t *testing.T) {
+	config := filepath.Join("testdata", "apko.yaml")
+	lockfile := filepath.Join("testdata", "apko.lock.json")
+
+	_, ic, err := build.NewOptions(
+		build.WithConfig(config, []string{}),
+		build.WithLockFile(lockfile),
+	)
+	require.NoError(t, err)
+	require.NotContains(t, ic.Annotations, build.AnnotationApkoVersion)
+
+	_, ic, err = build.NewOptions(
+		build.WithConfig(config, []string{}),
+		build.WithLockFile(lockfile),
+		build.WithAnnotations(map[string]string{build.AnnotationApkoVersion: "pinned"}),
+		build.WithInputAnnotations(true),
+	)
+	require.NoError(t, err)
+
+	lock, err := os.ReadFile(lockfile)
+	require.NoError(t, err)
+	require.Equal(t, "pinned", ic.Annotations[build.AnnotationApkoVersion])
+	require.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(lock)), ic.Annotations[build.AnnotationLockDigest])
+	require.Regexp(t, "^sha256:[0-9a-f]{64}$", ic.Annotations[build.AnnotationConfigDigest])
+}
+
+func TestWithBuilder(t *testing.T) {
+	_, ic, err := build.NewOptions(
+		build.WithBuilder("https://builder.example.com/apko", "v1.2.3"),
+		build.WithInputAnnotations(true),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "https://builder.example.com/apko", ic.Annotations[build.AnnotationBuilderID])
+	require.Equal(t, "v1.2.3", ic.Annotations[build.AnnotationBuilderVersion])
+
+	_, _, err = build.NewOptions(build.WithBuilder("laptop", ""))
+	require.ErrorContains(t, err, "not an absolute URI")
+
+	_, _, err = build.NewOptions(build.WithBuilder("", "v1.2.3"))
+	require.ErrorContains(t, err, "without a builder id")
+}
@@ -0,0 +1,454 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+	"gopkg.in/yaml.v3"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/baseimg"
+)
+
+// Input types an input policy rule applies to.
+const (
+	InputConfig   = "config"
+	InputLock     = "lock"
+	InputPackages = "packages"
+)
+
+// The payload type of DSSE envelopes holding in-toto statements.
+const inTotoPayloadType = "application/vnd.in-toto+json"
+
+// thresholdPolicy is the input policy: it says whose attestations the inputs
+// of a build must carry, the configuration file, the lock file and the
+// packages, as the keys trusted for each type of input and how many of them
+// must have signed a statement about it. It is not an in-toto layout: there
+// are no steps, no chaining of materials to products and no signature on the
+// policy itself, only keys and thresholds per input.
+type thresholdPolicy struct {
+	// Keys are the paths of the PEM encoded public keys of the
+	// functionaries, by name.
+	Keys map[string]string `yaml:"keys"`
+	// Attestations are glob patterns of the files holding the DSSE
+	// envelopes of in-toto statements about the inputs, one envelope or one
+	// per line.
+	Attestations []string `yaml:"attestations"`
+	// Inputs are the rules for each type of input. Inputs without a rule
+	// are not checked.
+	Inputs []thresholdRule `yaml:"inputs"`
+
+	// attestations are the statements of Attestations signed by at least
+	// one of Keys.
+	attestations []attestation
+}
+
+type thresholdRule struct {
+	// Type is one of InputConfig, InputLock or InputPackages.
+	Type string `yaml:"type"`
+	// Keys name the keys trusted to attest to the input.
+	Keys []string `yaml:"keys"`
+	// Threshold is how many of Keys must have attested to the input, 1 if
+	// unset.
+	Threshold int `yaml:"threshold,omitempty"`
+	// PredicateType, if set, is the predicate type the statements must have,
+	// e.g. https://slsa.dev/provenance/v1.
+	PredicateType string `yaml:"predicateType,omitempty"`
+	// Repositories, if set, limit a packages rule to the packages fetched
+	// from these repositories, such as the one melange builds into. Local
+	// repositories are relative to the policy.
+	Repositories []string `yaml:"repositories,omitempty"`
+}
+
+// covers reports whether the packages rule r applies to the package at
+// pkgURL, a local path being relative to wd.
+func (r thresholdRule) covers(pkgURL, wd string) bool {
+	if len(r.Repositories) == 0 {
+		return true
+	}
+	loc := repositoryLocation(pkgURL, wd)
+	return slices.ContainsFunc(r.Repositories, func(repo string) bool {
+		return strings.HasPrefix(loc, repo+"/")
+	})
+}
+
+// repositoryLocation normalizes the location of a repository or package so
+// that those of packages can be matched by prefix against those of
+// repositories: URLs lose any trailing slash, and local paths, including
+// file:// URLs, become clean absolute paths, relative ones resolved against
+// dir.
+func repositoryLocation(s, dir string) string {
+	if rest, ok := strings.CutPrefix(s, "file://"); ok {
+		s = rest
+	} else if strings.Contains(s, "://") {
+		return strings.TrimRight(s, "/")
+	}
+	if !filepath.IsAbs(s) {
+		s = filepath.Join(dir, s)
+	}
+	return strings.TrimRight(filepath.ToSlash(filepath.Clean(s)), "/")
+}
+
+// attestation is an in-toto statement, with the names of the keys that
+// signed it.
+type attestation struct {
+	Type          string `json:"_type"`
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+
+	signers []string
+}
+
+// attests reports whether a is about the artifact with digests, that is,
+// whether one of its subjects has a matching digest in every algorithm of
+// digests.
+func (a attestation) attests(digests map[string]string) bool {
+	for _, s := range a.Subject {
+		match := true
+		for alg, want := range digests {
+			d, ok := s.Digest[alg]
+			match = match && ok && strings.EqualFold(d, want)
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// loadThresholdPolicy reads the policy at path and the attestations and keys
+// it refers to, relative to the directory it is in, keeping the attestations
+// signed by at least one of the keys.
+func loadThresholdPolicy(path string) (*thresholdPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading input policy: %w", err)
+	}
+	var p thresholdPolicy
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parsing input policy %s: %w", path, err)
+	}
+	for i, r := range p.Inputs {
+		switch r.Type {
+		case InputConfig, InputLock, InputPackages:
+		default:
+			return nil, fmt.Errorf("inputs[%d]: unknown input type %q, must be one of %v", i, r.Type, []string{InputConfig, InputLock, InputPackages})
+		}
+		if len(r.Keys) == 0 {
+			return nil, fmt.Errorf("inputs[%d] (%s): keys are required", i, r.Type)
+		}
+		for _, k := range r.Keys {
+			if _, ok := p.Keys[k]; !ok {
+				return nil, fmt.Errorf("inputs[%d] (%s): unknown key %q", i, r.Type, k)
+			}
+		}
+		if r.Threshold > len(r.Keys) {
+			return nil, fmt.Errorf("inputs[%d] (%s): threshold %d is more than the %d keys", i, r.Type, r.Threshold, len(r.Keys))
+		}
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	for i := range p.Inputs {
+		for j, repo := range p.Inputs[i].Repositories {
+			p.Inputs[i].Repositories[j] = repositoryLocation(repo, dir)
+		}
+	}
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	keys := make(map[string]crypto.PublicKey, len(p.Keys))
+	for name, kp := range p.Keys {
+		if keys[name], err = baseimg.LoadPublicKey(resolve(kp)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pattern := range p.Attestations {
+		files, err := filepath.Glob(resolve(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("attestations %q: %w", pattern, err)
+		}
+		for _, f := range files {
+			found, err := readAttestations(f, keys)
+			if err != nil {
+				return nil, fmt.Errorf("reading attestations from %s: %w", f, err)
+			}
+			p.attestations = append(p.attestations, found...)
+		}
+	}
+	return &p, nil
+}
+
+// readAttestations reads the DSSE envelopes in the file at path, returning
+// the in-toto statements signed by at least one of keys.
+func readAttestations(path string, keys map[string]crypto.PublicKey) ([]attestation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var atts []attestation
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var env struct {
+			PayloadType string `json:"payloadType"`
+			Payload     string `json:"payload"`
+			Signatures  []struct {
+				Sig string `json:"sig"`
+			} `json:"signatures"`
+		}
+		if err := dec.Decode(&env); err != nil {
+			return nil, err
+		}
+		if env.PayloadType != inTotoPayloadType {
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(env.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("decoding payload: %w", err)
+		}
+		pae := dssePAE(env.PayloadType, payload)
+
+		var a attestation
+		for name, key := range keys {
+			for _, s := range env.Signatures {
+				sig, err := base64.StdEncoding.DecodeString(s.Sig)
+				if err == nil && baseimg.VerifyPayload(key, pae, sig) == nil {
+					a.signers = append(a.signers, name)
+					break
+				}
+			}
+		}
+		if len(a.signers) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(payload, &a); err != nil {
+			return nil, fmt.Errorf("parsing in-toto statement: %w", err)
+		}
+		if !strings.HasPrefix(a.Type, "https://in-toto.io/Statement/") {
+			return nil, fmt.Errorf("unknown in-toto statement type %q", a.Type)
+		}
+		atts = append(atts, a)
+	}
+	return atts, nil
+}
+
+// dssePAE returns the pre-authentication encoding of a DSSE payload, which
+// is what its signatures sign.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return fmt.Appendf(nil, "DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+}
+
+// checkAttested checks that enough of the keys of r signed attestations
+// about the input what, with digests, returning an error otherwise.
+func checkAttested(r thresholdRule, atts []attestation, what string, digests map[string]string) error {
+	signers := map[string]bool{}
+	for _, a := range atts {
+		if r.PredicateType != "" && a.PredicateType != r.PredicateType {
+			continue
+		}
+		if !a.attests(digests) {
+			continue
+		}
+		for _, s := range a.signers {
+			if slices.Contains(r.Keys, s) {
+				signers[s] = true
+			}
+		}
+	}
+	threshold := max(r.Threshold, 1)
+	if len(signers) < threshold {
+		return fmt.Errorf("%s is attested by %d of the keys %v, %d needed", what, len(signers), r.Keys, threshold)
+	}
+	return nil
+}
+
+// loadInputPolicy returns the input policy given with WithInputPolicy,
+// reading it and verifying the signatures of its attestations the first time
+// only, so that verifyInputs and verifyInstalledInputs check the same
+// statements.
+func (bc *Context) loadInputPolicy() (*thresholdPolicy, error) {
+	if bc.inputPolicy == nil {
+		p, err := loadThresholdPolicy(bc.o.InputPolicy)
+		if err != nil {
+			return nil, err
+		}
+		bc.inputPolicy = p
+	}
+	return bc.inputPolicy, nil
+}
+
+// verifyInputs checks the configuration file, the lock file and the packages
+// of res against the input policy given with WithInputPolicy, before any of
+// them is installed. Packages are checked again by verifyInstalledInputs
+// once they have been fetched.
+func (bc *Context) verifyInputs(ctx context.Context, res *Resolution) error {
+	if bc.o.InputPolicy == "" {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	_, span := otel.Tracer("apko").Start(ctx, "verifyInputs")
+	defer span.End()
+
+	policy, err := bc.loadInputPolicy()
+	if err != nil {
+		return err
+	}
+
+	fileDigests := func(path string) (map[string]string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.Sum256(b)
+		return map[string]string{"sha256": hex.EncodeToString(h[:])}, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	checked := 0
+	for _, r := range policy.Inputs {
+		switch r.Type {
+		case InputConfig, InputLock:
+			path := bc.o.ImageConfigFile
+			if r.Type == InputLock {
+				path = res.Lockfile
+			}
+			if path == "" {
+				errs = append(errs, fmt.Errorf("the policy requires a %s file, but the build has none", r.Type))
+				continue
+			}
+			digests, err := fileDigests(path)
+			if err != nil {
+				return fmt.Errorf("hashing %s file: %w", r.Type, err)
+			}
+			if err := checkAttested(r, policy.attestations, path, digests); err != nil {
+				errs = append(errs, err)
+			}
+			checked++
+
+		case InputPackages:
+			for _, pkg := range res.Packages {
+				if !r.covers(pkg.URL(), wd) {
+					continue
+				}
+				// Packages are identified by their control checksum, the
+				// SHA-1 of their control section, which the APKINDEX and lock
+				// files record before they are fetched.
+				sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pkg.ChecksumString(), "Q1"))
+				if err != nil || !strings.HasPrefix(pkg.ChecksumString(), "Q1") {
+					errs = append(errs, fmt.Errorf("package %s has no control checksum to check attestations against", pkg.PackageName()))
+					continue
+				}
+				if err := checkAttested(r, policy.attestations, "package "+pkg.PackageName(), map[string]string{"sha1": hex.EncodeToString(sum)}); err != nil {
+					errs = append(errs, err)
+				}
+				checked++
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("inputs do not satisfy the input policy %s:\n%w", bc.o.InputPolicy, err)
+	}
+	log.Infof("all %d inputs satisfy the input policy", checked)
+	return nil
+}
+
+// verifyInstalledInputs checks the packages of res that have been installed
+// as pkgs against the packages rules of the input policy given with
+// WithInputPolicy. Now that they have been fetched, each must be attested by
+// the sha256 of its data section, the datahash its control section records,
+// as well as by its control checksum.
+func (bc *Context) verifyInstalledInputs(ctx context.Context, res *Resolution, pkgs []apk.InstalledDiff) error {
+	if bc.o.InputPolicy == "" {
+		return nil
+	}
+
+	_, span := otel.Tracer("apko").Start(ctx, "verifyInstalledInputs")
+	defer span.End()
+
+	policy, err := bc.loadInputPolicy()
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	urls := make(map[string]string, len(res.Packages))
+	for _, pkg := range res.Packages {
+		urls[pkg.PackageName()] = pkg.URL()
+	}
+
+	var errs []error
+	for _, r := range policy.Inputs {
+		if r.Type != InputPackages {
+			continue
+		}
+		for _, p := range pkgs {
+			if !r.covers(urls[p.Package.Name], wd) {
+				continue
+			}
+			if p.Package.DataHash == "" {
+				errs = append(errs, fmt.Errorf("package %s has no data hash to check attestations against", p.Package.Name))
+				continue
+			}
+			digests := map[string]string{
+				"sha1":   hex.EncodeToString(p.Package.Checksum),
+				"sha256": p.Package.DataHash,
+			}
+			if err := checkAttested(r, policy.attestations, "data of package "+p.Package.Name, digests); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("installed packages do not satisfy the input policy %s:\n%w", bc.o.InputPolicy, err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestVerifyInputs(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	keys := map[string]*ecdsa.PrivateKey{}
+	for _, name := range []string{"ci", "release", "other"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644))
+		keys[name] = key
+	}
+
+	config := filepath.Join(dir, "apko.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("contents:\n  packages: [app]\n"), 0o644))
+	configSum := sha256.Sum256([]byte("contents:\n  packages: [app]\n"))
+
+	const (
+		appChecksum   = "Q1AAECAwQFBgcICQoLDA0ODxAREhM="
+		baseChecksum  = "Q1ZGVmZ2hpamtsbW5vcHFyc3R1dnc="
+		localChecksum = "Q1oKGio6SlpqeoqaqrrK2ur7Cxsrc="
+	)
+	appSum := sha256.Sum256([]byte("app data"))
+	appData := hex.EncodeToString(appSum[:])
+	sha1Of := func(q1 string) string {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(q1, "Q1"))
+		require.NoError(t, err)
+		return hex.EncodeToString(b)
+	}
+
+	// envelope returns a DSSE envelope of a statement about the artifact
+	// with digests, signed with the given keys.
+	envelope := func(predicateType string, digests map[string]string, signers ...string) string {
+		statement, err := json.Marshal(map[string]any{
+			"_type":         "https://in-toto.io/Statement/v1",
+			"predicateType": predicateType,
+			"subject":       []map[string]any{{"name": "input", "digest": digests}},
+			"predicate":     map[string]any{},
+		})
+		require.NoError(t, err)
+		var sigs []map[string]string
+		for _, s := range signers {
+			h := sha256.Sum256(dssePAE(inTotoPayloadType, statement))
+			sig, err := ecdsa.SignASN1(rand.Reader, keys[s], h[:])
+			require.NoError(t, err)
+			sigs = append(sigs, map[string]string{"sig": base64.StdEncoding.EncodeToString(sig)})
+		}
+		b, err := json.Marshal(map[string]any{
+			"payloadType": inTotoPayloadType,
+			"payload":     base64.StdEncoding.EncodeToString(statement),
+			"signatures":  sigs,
+		})
+		require.NoError(t, err)
+		return string(b)
+	}
+	const provenance = "https://slsa.dev/provenance/v1"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "inputs.intoto.jsonl"), []byte(strings.Join([]string{
+		envelope(provenance, map[string]string{"sha256": hex.EncodeToString(configSum[:])}, "ci"),
+		envelope(provenance, map[string]string{"sha1": sha1Of(appChecksum), "sha256": appData}, "release", "ci"),
+		// Signed with a key the policy does not know.
+		envelope(provenance, map[string]string{"sha1": sha1Of(baseChecksum)}, "other"),
+		// Only attests the control section.
+		envelope(provenance, map[string]string{"sha1": sha1Of(localChecksum)}, "release"),
+	}, "\n")+"\n"), 0o644))
+
+	res := &Resolution{Packages: []apk.InstallablePackage{
+		installablePackage{name: "app", url: "https://local.example.com/packages/x86_64/app-1.0-r0.apk", checksum: appChecksum},
+		installablePackage{name: "base", url: "https://packages.example.com/os/x86_64/base-1.0-r0.apk", checksum: baseChecksum},
+		// From a local repository, relative to the working directory.
+		installablePackage{name: "local", url: "./packages/x86_64/local-1.0-r0.apk", checksum: localChecksum},
+	}}
+	t.Chdir(dir)
+
+	verify := func(policy string) error {
+		p := filepath.Join(dir, "policy.yaml")
+		require.NoError(t, os.WriteFile(p, []byte(policy), 0o644))
+		bc := &Context{o: options.Options{ImageConfigFile: config, InputPolicy: p}}
+		return bc.verifyInputs(ctx, res)
+	}
+	verifyInstalled := func(policy string, pkgs ...apk.InstalledDiff) error {
+		p := filepath.Join(dir, "policy.yaml")
+		require.NoError(t, os.WriteFile(p, []byte(policy), 0o644))
+		bc := &Context{o: options.Options{ImageConfigFile: config, InputPolicy: p}}
+		return bc.verifyInstalledInputs(ctx, res, pkgs)
+	}
+	installed := func(name, checksum, dataHash string) apk.InstalledDiff {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(checksum, "Q1"))
+		require.NoError(t, err)
+		return apk.InstalledDiff{Package: &apk.Package{Name: name, Checksum: sum, DataHash: dataHash}}
+	}
+	const header = `keys:
+  ci: ci.pub
+  release: release.pub
+attestations:
+  - "*.intoto.jsonl"
+inputs:
+`
+
+	require.NoError(t, verify(header+`
+  - type: config
+    keys: [ci]
+    predicateType: https://slsa.dev/provenance/v1
+  - type: packages
+    keys: [release]
+    repositories: [https://local.example.com/packages]
+`))
+
+	// Both keys signed the app package, but only the CI key the config.
+	require.NoError(t, verify(header+`
+  - type: packages
+    keys: [ci, release]
+    threshold: 2
+    repositories: [https://local.example.com/packages]
+`))
+	err := verify(header + `
+  - type: config
+    keys: [ci, release]
+    threshold: 2
+`)
+	require.ErrorContains(t, err, config+" is attested by 1 of the keys [ci release], 2 needed")
+
+	// The base package is only attested by a key the policy does not trust.
+	err = verify(header + `
+  - type: packages
+    keys: [release]
+`)
+	require.ErrorContains(t, err, "package base is attested by 0 of the keys [release], 1 needed")
+	require.NotContains(t, err.Error(), "package app")
+	require.NotContains(t, err.Error(), "package local")
+
+	// Once fetched, packages must also be attested by their data hash.
+	const appRule = header + `
+  - type: packages
+    keys: [release]
+    repositories: [https://local.example.com/packages/]
+`
+	require.NoError(t, verifyInstalled(appRule, installed("app", appChecksum, appData), installed("base", baseChecksum, appData)))
+	err = verifyInstalled(appRule, installed("app", appChecksum, strings.Repeat("0", 64)))
+	require.ErrorContains(t, err, "data of package app is attested by 0 of the keys [release], 1 needed")
+	require.ErrorContains(t, verifyInstalled(appRule, installed("app", appChecksum, "")), "package app has no data hash")
+
+	// Local repositories in the policy are relative to it, and match the
+	// packages of the same directory however the configuration names it.
+	const localRule = header + `
+  - type: packages
+    keys: [release]
+    repositories: [./packages]
+`
+	require.NoError(t, verify(localRule))
+	err = verifyInstalled(localRule, installed("local", localChecksum, appData))
+	require.ErrorContains(t, err, "data of package local is attested by 0 of the keys [release], 1 needed")
+
+	err = verify(header + `
+  - type: config
+    keys: [ci]
+    predicateType: https://example.com/other
+`)
+	require.ErrorContains(t, err, "is attested by 0 of the keys")
+
+	err = verify(header + `
+  - type: lock
+    keys: [ci]
+`)
+	require.ErrorContains(t, err, "the policy requires a lock file, but the build has none")
+
+	// The policy is loaded, and its attestations verified, once per build:
+	// the installed packages are checked against the same statements.
+	p := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(p, []byte(appRule), 0o644))
+	bc := &Context{o: options.Options{ImageConfigFile: config, InputPolicy: p}}
+	require.NoError(t, bc.verifyInputs(ctx, &Resolution{Packages: res.Packages[:1]}))
+	require.NoError(t, os.WriteFile(p, []byte("keys: {}\n"), 0o644))
+	require.NoError(t, bc.verifyInstalledInputs(ctx, res, []apk.InstalledDiff{installed("app", appChecksum, appData)}))
+
+	require.ErrorContains(t, verify(header+`
+  - type: config
+    keys: [nobody]
+`), `unknown key "nobody"`)
+	require.ErrorContains(t, verify(header+`
+  - type: sources
+    keys: [ci]
+`), `unknown input type "sources"`)
+}
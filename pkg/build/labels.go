@@ -0,0 +1,76 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// labelData is the data available to label templates.
+type labelData struct {
+	// Packages maps the name of each installed package to its version.
+	Packages map[string]string
+	// Repositories are the repositories packages were installed from.
+	Repositories []string
+	// Arch is the architecture being built, in OCI terms.
+	Arch string
+	// LockDigest is the digest of the lock file, if building from one.
+	LockDigest string
+}
+
+// renderLabels evaluates the label templates against the installed packages.
+// The rendered labels replace the templates, as the configuration is shared
+// by the contexts of all architectures.
+func (bc *Context) renderLabels(installed []*apk.InstalledPackage) error {
+	if len(bc.ic.Labels) == 0 {
+		return nil
+	}
+
+	data := labelData{
+		Packages:     make(map[string]string, len(installed)),
+		Repositories: slices.Concat(bc.ic.Contents.Repositories, bc.o.ExtraRepos),
+		Arch:         bc.Arch().String(),
+	}
+	for _, pkg := range installed {
+		data.Packages[pkg.Name] = pkg.Version
+	}
+	if bc.o.Lockfile != "" {
+		digest, err := lockDigest(bc.lockfile())
+		if err != nil {
+			return err
+		}
+		data.LockDigest = digest
+	}
+
+	labels := make(map[string]string, len(bc.ic.Labels))
+	for k, v := range bc.ic.Labels {
+		tmpl, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return fmt.Errorf("parsing label %q: %w", k, err)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return fmt.Errorf("rendering label %q: %w", k, err)
+		}
+		labels[k] = sb.String()
+	}
+	bc.ic.Labels = labels
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestRenderLabels(t *testing.T) {
+	lockfile := filepath.Join("testdata", "apko.lock.json")
+	installed := []*apk.InstalledPackage{
+		{Package: apk.Package{Name: "nginx", Version: "1.27.0-r1"}},
+		{Package: apk.Package{Name: "wolfi-baselayout", Version: "20230201-r7"}},
+	}
+	labels := map[string]string{
+		"org.opencontainers.image.version": "{{ index .Packages \"nginx\" }}",
+		"org.opencontainers.image.source":  "{{ index .Repositories 0 }}",
+		"dev.example.arch":                 "{{ .Arch }}",
+		"dev.example.lock":                 "{{ .LockDigest }}",
+		"dev.example.static":               "fixed",
+	}
+
+	bc := &Context{
+		ic: types.ImageConfiguration{
+			Contents: types.ImageContents{Repositories: []string{"https://packages.wolfi.dev/os"}},
+			Labels:   labels,
+		},
+		o: options.Options{Arch: types.ParseArchitecture("arm64"), Lockfile: lockfile},
+	}
+	require.NoError(t, bc.renderLabels(installed))
+
+	lock, err := os.ReadFile(lockfile)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"org.opencontainers.image.version": "1.27.0-r1",
+		"org.opencontainers.image.source":  "https://packages.wolfi.dev/os",
+		"dev.example.arch":                 "arm64",
+		"dev.example.lock":                 fmt.Sprintf("sha256:%x", sha256.Sum256(lock)),
+		"dev.example.static":               "fixed",
+	}, bc.ic.Labels)
+	// The templates, shared with the other architectures, are left alone.
+	require.Equal(t, "{{ .Arch }}", labels["dev.example.arch"])
+
+	bc = &Context{ic: types.ImageConfiguration{Labels: map[string]string{"bad": "{{ .Nope }}"}}}
+	require.ErrorContains(t, bc.renderLabels(installed), `rendering label "bad"`)
+}
@@ -0,0 +1,94 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+)
+
+// LayerInfo describes a layer produced by BuildLayersWithMetadata.
+type LayerInfo struct {
+	// Layer is the layer itself.
+	Layer v1.Layer
+	// DiffID is the digest of the uncompressed layer.
+	DiffID v1.Hash
+	// Digest is the digest of the compressed layer.
+	Digest v1.Hash
+	// Size is the size of the compressed layer in bytes.
+	Size int64
+	// MediaType is the media type of the compressed layer.
+	MediaType v1types.MediaType
+	// Packages are the names of the packages whose files are in this layer.
+	// The top layer of a multi-layer build holds only files that no package
+	// owns, so it has none.
+	Packages []string
+	// Annotations are the annotations to attach to the layer's descriptor in
+	// the image manifest.
+	Annotations map[string]string
+}
+
+// BuildLayersWithMetadata is like BuildLayers but also returns what callers
+// need to publish or cache the layers without re-reading them.
+func (bc *Context) BuildLayersWithMetadata(ctx context.Context) ([]LayerInfo, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "BuildLayersWithMetadata")
+	defer span.End()
+
+	layers, err := bc.BuildLayers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]LayerInfo, 0, len(layers))
+	for i, l := range layers {
+		info, err := describeLayer(l)
+		if err != nil {
+			return nil, fmt.Errorf("describing layer[%d]: %w", i, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func describeLayer(l v1.Layer) (LayerInfo, error) {
+	info := LayerInfo{Layer: l}
+
+	var err error
+	if info.DiffID, err = l.DiffID(); err != nil {
+		return LayerInfo{}, fmt.Errorf("computing diffid: %w", err)
+	}
+	if info.Digest, err = l.Digest(); err != nil {
+		return LayerInfo{}, fmt.Errorf("computing digest: %w", err)
+	}
+	if info.Size, err = l.Size(); err != nil {
+		return LayerInfo{}, fmt.Errorf("computing size: %w", err)
+	}
+	if info.MediaType, err = l.MediaType(); err != nil {
+		return LayerInfo{}, fmt.Errorf("getting media type: %w", err)
+	}
+
+	if bl, ok := l.(*layer); ok {
+		info.Packages = bl.packages
+	}
+	if al, ok := l.(interface{ Annotations() map[string]string }); ok {
+		info.Annotations = maps.Clone(al.Annotations())
+	}
+	return info, nil
+}
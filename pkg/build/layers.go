@@ -22,33 +22,43 @@ import (
 	"fmt"
 	"io"
 	"maps"
-	"os"
 	"path"
 	"slices"
+	"strings"
+	"time"
 
+	"chainguard.dev/apko/internal/pathglob"
 	"chainguard.dev/apko/pkg/apk/apk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
 
 	"github.com/chainguard-dev/clog"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
-func (bc *Context) buildLayers(ctx context.Context) ([]v1.Layer, error) {
-	log := clog.FromContext(ctx)
-
-	if strategy := bc.ic.Layering.Strategy; strategy != "origin" {
-		return nil, fmt.Errorf("unrecognized layering strategy %q", strategy)
+// checkLayering returns an error if the configuration's layering cannot be
+// built.
+func (bc *Context) checkLayering() error {
+	// A layering with only path layers groups packages by origin too.
+	if strategy := bc.ic.Layering.Strategy; strategy != "origin" && strategy != "frequency" && (strategy != "" || len(bc.ic.Layering.Layers) == 0) {
+		return fmt.Errorf("unrecognized layering strategy %q", strategy)
+	}
+	if err := validatePathLayers(bc.ic.Layering.Layers); err != nil {
+		return err
 	}
 
 	if bc.ic.Contents.BaseImage != nil {
-		return nil, fmt.Errorf("layering with %q is unsupported", "baseimage")
+		return fmt.Errorf("layering with %q is unsupported", "baseimage")
 	}
+	return nil
+}
 
-	// Build a single fs.FS, the normal way (this writes to bc.fs).
-	diffs, err := bc.buildImage(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("building filesystem: %w", err)
-	}
+// splitInstallation partitions the filesystem of inst into layers, grouping
+// its packages as the layering asks.
+func (bc *Context) splitInstallation(ctx context.Context, inst *Installation) ([]v1.Layer, error) {
+	log := clog.FromContext(ctx)
+	diffs := inst.Packages
 
 	pkgs := make([]*apk.Package, 0, len(diffs))
 	pkgToDiff := map[*apk.Package][]byte{}
@@ -68,9 +78,12 @@ func (bc *Context) buildLayers(ctx context.Context) ([]v1.Layer, error) {
 	if err := bc.postBuildSetApk(ctx); err != nil {
 		return nil, err
 	}
+	if err := bc.checkFilesystem(ctx); err != nil {
+		return nil, err
+	}
 
 	// Use our layering strategy to partition packages into a set of Budget groups.
-	groups, err := groupByOriginAndSize(pkgs, bc.ic.Layering.Budget)
+	groups, err := groupPackages(pkgs, bc.ic.Layering)
 	if err != nil {
 		return nil, fmt.Errorf("grouping packages: %w", err)
 	}
@@ -84,8 +97,42 @@ func (bc *Context) buildLayers(ctx context.Context) ([]v1.Layer, error) {
 		}
 	}
 
+	for _, pl := range bc.ic.Layering.Layers {
+		log.Infof("  layer %q: %v", pl.Name, pl.Paths)
+	}
+
 	// Then partition that single fs.FS into multiple layers based on our layering strategy.
-	return splitLayers(ctx, bc.fs, groups, pkgToDiff, bc.o.TempDir())
+	mtime, err := bc.fileTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	return splitLayers(ctx, bc.fs, groups, bc.ic.Layering.Layers, bc.ic.Contents.Exclude, pkgToDiff, mtime, &bc.o)
+}
+
+// LayerNameAnnotation is set on the manifest descriptor of every layer
+// defined by paths in the layering configuration, recording its name.
+const LayerNameAnnotation = "dev.chainguard.apko.layer.name"
+
+func validatePathLayers(layers []types.PathLayer) error {
+	names := map[string]bool{}
+	for _, pl := range layers {
+		if pl.Name == "" {
+			return fmt.Errorf("layer with paths %v has no name", pl.Paths)
+		}
+		if names[pl.Name] {
+			return fmt.Errorf("duplicate layer name %q", pl.Name)
+		}
+		names[pl.Name] = true
+		if len(pl.Paths) == 0 {
+			return fmt.Errorf("layer %q has no paths", pl.Name)
+		}
+		for _, p := range pl.Paths {
+			if err := pathglob.Validate(p); err != nil {
+				return fmt.Errorf("layer %q: %w", pl.Name, err)
+			}
+		}
+	}
+	return nil
 }
 
 func replacesGroup(rep string, g *group) (bool, error) {
@@ -116,11 +163,46 @@ func replacesGroup(rep string, g *group) (bool, error) {
 	return false, nil
 }
 
+// groupPackages partitions pkgs into at most l.Budget groups using the
+// layering strategy of l.
+func groupPackages(pkgs []*apk.Package, l *types.Layering) ([]*group, error) {
+	if l.Strategy == "frequency" {
+		return groupByChangeFrequency(pkgs, l.Budget)
+	}
+	return groupByOriginAndSize(pkgs, l.Budget)
+}
+
 func groupByOriginAndSize(pkgs []*apk.Package, budget int) ([]*group, error) {
-	// First, we're going to group packages by their origin.
+	groups, err := groupByOrigin(pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Then we'll sort by the size and take the top $budget, merging the remainders.
+	sortBySize(groups)
+
+	if len(groups) > budget {
+		cutoff := max(budget-1, 0) // Even if budget == 0, we want 1 group.
+
+		remainder := groups[cutoff:]
+		groups = groups[:cutoff]
+
+		groups = append(groups, merge(remainder...))
+	}
+
+	sortPackages(groups)
+
+	return groups, nil
+}
+
+// groupByOrigin groups pkgs by their origin, merging the groups of packages
+// that replace each other, and sizes each group.
+func groupByOrigin(pkgs []*apk.Package) ([]*group, error) {
+	// First, we're going to group packages by their origin. Packages without
+	// one are grouped on their own rather than with each other.
 	byOrigin := map[string]*group{}
 	for _, pkg := range pkgs {
-		origin := pkg.Origin
+		origin := pkg.SourcePackage()
 		if _, ok := byOrigin[origin]; !ok {
 			byOrigin[origin] = &group{}
 		}
@@ -184,14 +266,14 @@ func groupByOriginAndSize(pkgs []*apk.Package, budget int) ([]*group, error) {
 			// Update our maps so we can test identity above.
 			for _, pkg := range merged.pkgs {
 				byPackage[pkg.Name] = merged
-				byOrigin[pkg.Origin] = merged
+				byOrigin[pkg.SourcePackage()] = merged
 			}
 		}
 	}
 
 	// Now we need to pick the best groups to keep.
 	// First pass we'll set the size of each group to the sum of the installed size of all its packages.
-	groups := make([]*group, 0, budget)
+	groups := make([]*group, 0, len(byOrigin))
 	seen := map[*group]struct{}{}
 	for v := range maps.Values(byOrigin) {
 		if _, ok := seen[v]; ok {
@@ -207,30 +289,182 @@ func groupByOriginAndSize(pkgs []*apk.Package, budget int) ([]*group, error) {
 		}
 	}
 
-	// Then we'll sort by the size and take the top $budget, merging the remainders.
+	return groups, nil
+}
+
+func sortBySize(groups []*group) {
 	slices.SortFunc(groups, func(a, b *group) int {
 		return cmp.Or(
 			cmp.Compare(b.size, a.size),             // Descending size.
 			cmp.Compare(a.tiebreaker, b.tiebreaker)) // In the rare case where we have identical sizes.
 	})
+}
 
-	if len(groups) > budget {
-		cutoff := max(budget-1, 0) // Even if budget == 0, we want 1 group.
+// Sort packages too just so they're in a consistent order.
+func sortPackages(groups []*group) {
+	for _, g := range groups {
+		slices.SortFunc(g.pkgs, func(a, b *apk.Package) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+	}
+}
 
-		remainder := groups[cutoff:]
-		groups = groups[:cutoff]
+// baseOrigins are the origins that change least often in practice: the C
+// library, the shell and the files every image carries. The frequency
+// strategy always places them in the bottom tier, whatever depends on them.
+var baseOrigins = map[string]bool{
+	"alpine-baselayout": true,
+	"busybox":           true,
+	"ca-certificates":   true,
+	"glibc":             true,
+	"musl":              true,
+	"tzdata":            true,
+	"wolfi-baselayout":  true,
+}
 
-		groups = append(groups, merge(remainder...))
+func isBase(pkg *apk.Package) bool {
+	return baseOrigins[pkg.Origin]
+}
+
+// The tiers of the frequency strategy, from the bottom layer up.
+const (
+	tierBase = iota
+	tierRuntime
+	tierApp
+	numTiers
+)
+
+// groupByChangeFrequency groups pkgs by origin like groupByOriginAndSize, but
+// orders the groups into tiers by how often they are expected to change, so
+// a rebuild only invalidates the layers above the packages that changed:
+//
+//   - base: the baseOrigins, and groups that at least half the other groups
+//     depend on, directly or not.
+//   - runtime: groups that some other group depends on.
+//   - app: groups that nothing else depends on.
+//
+// Every tier is given an overflow layer of its own, so a small package that
+// changes never lands in the same layer as one from a lower tier. The rest of
+// the budget goes to the largest groups, wherever they are.
+func groupByChangeFrequency(pkgs []*apk.Package, budget int) ([]*group, error) {
+	groups, err := groupByOrigin(pkgs)
+	if err != nil {
+		return nil, err
 	}
+	sortBySize(groups)
+
+	dependents := countDependents(groups)
 
-	// Sort packages too just so they're in a consistent order.
+	var tiers [numTiers][]*group
 	for _, g := range groups {
-		slices.SortFunc(g.pkgs, func(a, b *apk.Package) int {
-			return cmp.Compare(a.Name, b.Name)
-		})
+		n := dependents[g]
+		tier := tierApp
+		switch {
+		case n > 0 && 2*n >= len(groups)-1, slices.ContainsFunc(g.pkgs, isBase):
+			tier = tierBase
+		case n > 0:
+			tier = tierRuntime
+		}
+		tiers[tier] = append(tiers[tier], g)
 	}
 
-	return groups, nil
+	var nonEmpty [][]*group
+	for _, t := range tiers {
+		if len(t) != 0 {
+			nonEmpty = append(nonEmpty, t)
+		}
+	}
+
+	// Even if budget == 0, we want 1 group. When the budget cannot fit every
+	// tier, the top tiers are merged downwards.
+	slots := max(budget, 1)
+	for len(nonEmpty) > slots {
+		last := len(nonEmpty) - 1
+		nonEmpty[last-1] = append(nonEmpty[last-1], nonEmpty[last]...)
+		nonEmpty = nonEmpty[:last]
+	}
+
+	// Keep the largest groups as layers of their own, as long as one slot
+	// remains for the overflow of each tier.
+	keep := map[*group]bool{}
+	for _, g := range groups[:min(slots-len(nonEmpty), len(groups))] {
+		keep[g] = true
+	}
+
+	out := make([]*group, 0, slots)
+	for _, t := range nonEmpty {
+		sortBySize(t)
+
+		var remainder []*group
+		for _, g := range t {
+			if keep[g] {
+				out = append(out, g)
+			} else {
+				remainder = append(remainder, g)
+			}
+		}
+		if len(remainder) != 0 {
+			out = append(out, merge(remainder...))
+		}
+	}
+
+	sortPackages(out)
+
+	return out, nil
+}
+
+// countDependents returns, for each group, how many of the other groups
+// depend on one of its packages, directly or transitively.
+func countDependents(groups []*group) map[*group]int {
+	// Map everything the packages provide to the group providing it.
+	providers := map[string]*group{}
+	for _, g := range groups {
+		for _, pkg := range g.pkgs {
+			providers[pkg.Name] = g
+			for _, p := range pkg.Provides {
+				providers[apk.ResolvePackageNameVersionPin(p).Name] = g
+			}
+		}
+	}
+
+	// Reverse edges: the groups directly depending on each group.
+	dependedOnBy := map[*group]map[*group]bool{}
+	for _, g := range groups {
+		for _, pkg := range g.pkgs {
+			for _, dep := range pkg.Dependencies {
+				if strings.HasPrefix(dep, "!") {
+					// Conflicts are not dependencies.
+					continue
+				}
+				p, ok := providers[apk.ResolvePackageNameVersionPin(dep).Name]
+				if !ok || p == g {
+					continue
+				}
+				if dependedOnBy[p] == nil {
+					dependedOnBy[p] = map[*group]bool{}
+				}
+				dependedOnBy[p][g] = true
+			}
+		}
+	}
+
+	counts := make(map[*group]int, len(groups))
+	for _, g := range groups {
+		seen := map[*group]bool{g: true}
+		queue := []*group{g}
+		for len(queue) != 0 {
+			next := queue[0]
+			queue = queue[1:]
+			for d := range dependedOnBy[next] {
+				if !seen[d] {
+					seen[d] = true
+					queue = append(queue, d)
+				}
+			}
+		}
+		counts[g] = len(seen) - 1
+	}
+	return counts
 }
 
 type group struct {
@@ -253,7 +487,10 @@ func merge(groups ...*group) *group {
 	return merged
 }
 
-func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToDiff map[*apk.Package][]byte, tmpdir string) ([]v1.Layer, error) {
+func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pathLayers []types.PathLayer, exclude []string, pkgToDiff map[*apk.Package][]byte, mtime time.Time, o *options.Options) ([]v1.Layer, error) {
+	tmpdir := o.TempDir()
+	c := compressorFor(o)
+
 	buf := make([]byte, 1<<20)
 
 	// We'll create a writer for each layer and a map to quickly access the writer given a package or group.
@@ -261,13 +498,13 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 	groupToWriter := map[*group]*layerWriter{}
 
 	for _, g := range groups {
-		f, err := os.CreateTemp(tmpdir, "layer-*.tar.gz")
+		f, err := o.CreateTemp(tmpdir, "layer-*.tar.gz")
 		if err != nil {
 			return nil, err
 		}
 		defer f.Close()
 
-		w := newLayerWriter(f)
+		w := newLayerWriter(f, c)
 		groupToWriter[g] = w
 
 		for _, pkg := range g.pkgs {
@@ -275,14 +512,36 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 		}
 	}
 
+	// Layers defined by paths take the files they match from any package.
+	pathWriters := make([]*layerWriter, 0, len(pathLayers))
+	for range pathLayers {
+		f, err := o.CreateTemp(tmpdir, "layer-*.tar.gz")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		pathWriters = append(pathWriters, newLayerWriter(f, c))
+	}
+	pathWriter := func(name string) *layerWriter {
+		for i, pl := range pathLayers {
+			for _, p := range pl.Paths {
+				if pathglob.MatchOrParent(p, name) {
+					return pathWriters[i]
+				}
+			}
+		}
+		return nil
+	}
+
 	// The top layer holds anything that doesn't belong to a package.
-	f, err := os.CreateTemp(tmpdir, "layer-*.tar.gz")
+	f, err := o.CreateTemp(tmpdir, "layer-*.tar.gz")
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	top := newLayerWriter(f)
+	top := newLayerWriter(f, c)
 
 	// In a tar file, it is customary to include directories before files in those directories.
 	// In order to know which directories we need to include, we maintain a directory stack for each layer.
@@ -291,7 +550,7 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 	// any missing directory entries to the layer before we write the actual file entry.
 	stack := []*file{}
 
-	for f, err := range walkFS(ctx, fsys) {
+	for f, err := range walkFS(ctx, fsys, o.IDMap, mtime, exclude) {
 		if err != nil {
 			return nil, err
 		}
@@ -316,7 +575,10 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 
 		// However, if a file implements an extension interface that tells us what package owns it,
 		// we can use that to determine which layer it belongs to (if any).
-		if pkger, ok := f.info.(interface {
+		// Files matching a layer defined by paths go there regardless.
+		if pw := pathWriter(f.path); pw != nil {
+			w = pw
+		} else if pkger, ok := f.info.(interface {
 			Package() *apk.Package
 		}); ok {
 			if pkg := pkger.Package(); pkg != nil {
@@ -415,7 +677,7 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 	}
 
 	// Once we're done walking the FS, we need to finalize each layer...
-	layers := make([]v1.Layer, 0, len(groups)+1)
+	layers := make([]v1.Layer, 0, len(groups)+len(pathLayers)+1)
 	for i, g := range groups {
 		w := groupToWriter[g]
 
@@ -423,6 +685,26 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 		if err != nil {
 			return nil, fmt.Errorf("finalizing group[%d] layer: %w", i, err)
 		}
+		versions := make([]string, 0, len(g.pkgs))
+		for _, pkg := range g.pkgs {
+			l.packages = append(l.packages, pkg.Name)
+			versions = append(versions, pkg.Name+"="+pkg.Version)
+		}
+		l.comment = "packages: " + strings.Join(versions, " ")
+		layers = append(layers, l)
+	}
+
+	// ...then the layers defined by paths...
+	for i, pl := range pathLayers {
+		l, err := pathWriters[i].finalize()
+		if err != nil {
+			return nil, fmt.Errorf("finalizing layer %q: %w", pl.Name, err)
+		}
+		if l.annotations == nil {
+			l.annotations = map[string]string{}
+		}
+		l.annotations[LayerNameAnnotation] = pl.Name
+		l.comment = fmt.Sprintf("layer %s: paths %s", pl.Name, strings.Join(pl.Paths, " "))
 		layers = append(layers, l)
 	}
 
@@ -431,9 +713,16 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 	if err != nil {
 		return nil, fmt.Errorf("finalizing top layer: %w", err)
 	}
+	topLayer.comment = "files not owned by any package"
 
 	layers = append(layers, topLayer)
 
+	// Compress the layers concurrently up front. Otherwise they are
+	// compressed one after another as the image is assembled.
+	if err := compressLayers(ctx, o, layers); err != nil {
+		return nil, fmt.Errorf("compressing layers: %w", err)
+	}
+
 	return layers, nil
 }
 
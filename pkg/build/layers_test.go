@@ -22,9 +22,14 @@ import (
 	"os"
 	"slices"
 	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 
 	"chainguard.dev/apko/pkg/apk/apk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
 )
 
 func size(pkgs ...*apk.Package) uint64 {
@@ -47,6 +52,9 @@ func TestGroupByOriginAndSize(t *testing.T) {
 	newcrypt1 := &apk.Package{Name: "libcrypt1", Origin: "glibc", Version: "2.38-r16", InstalledSize: 23508}
 
 	repxcrypt := &apk.Package{Name: "libxcrypt", Origin: "libxcrypt", InstalledSize: 235761, Replaces: []string{"libcrypt1"}}
+
+	noOriginA := &apk.Package{Name: "a", InstalledSize: 10}
+	noOriginB := &apk.Package{Name: "b", InstalledSize: 20}
 	for _, tc := range []struct {
 		pkgs   []*apk.Package
 		budget int
@@ -95,6 +103,15 @@ func TestGroupByOriginAndSize(t *testing.T) {
 			{pkgs: []*apk.Package{glibc, posix, newcrypt1, repxcrypt}, size: size(glibc, newcrypt1, posix, repxcrypt), tiebreaker: "libxcrypt"},
 			{pkgs: []*apk.Package{crane}, size: size(crane), tiebreaker: "crane"},
 		},
+	}, {
+		// packages without an origin are not grouped with each other
+		pkgs:   []*apk.Package{crane, noOriginA, noOriginB},
+		budget: 3,
+		want: []*group{
+			{pkgs: []*apk.Package{crane}, size: size(crane), tiebreaker: "crane"},
+			{pkgs: []*apk.Package{noOriginB}, size: size(noOriginB), tiebreaker: "b"},
+			{pkgs: []*apk.Package{noOriginA}, size: size(noOriginA), tiebreaker: "a"},
+		},
 	}, {
 		// should be 3 groups but budget constricts that to 2
 		pkgs:   []*apk.Package{crane, glibc, posix, newcrypt1, libxcrypt},
@@ -128,6 +145,66 @@ func TestGroupByOriginAndSize(t *testing.T) {
 	}
 }
 
+func TestGroupByChangeFrequency(t *testing.T) {
+	glibc := &apk.Package{Name: "glibc", Origin: "glibc", InstalledSize: 6000, Provides: []string{"so:libc.so.6=6"}}
+	busybox := &apk.Package{Name: "busybox", Origin: "busybox", InstalledSize: 900, Dependencies: []string{"so:libc.so.6"}}
+	libcrypto := &apk.Package{Name: "libcrypto3", Origin: "openssl", InstalledSize: 5000, Dependencies: []string{"so:libc.so.6"}, Provides: []string{"so:libcrypto.so.3=3"}}
+	python := &apk.Package{Name: "python-3.12", Origin: "python-3.12", InstalledSize: 40000, Dependencies: []string{"so:libc.so.6", "so:libcrypto.so.3"}}
+	myapp := &apk.Package{Name: "myapp", Origin: "myapp", InstalledSize: 300, Dependencies: []string{"python-3.12>=3.12.1", "!busybox"}}
+	mytool := &apk.Package{Name: "mytool", Origin: "mytool", InstalledSize: 200, Dependencies: []string{"so:libc.so.6"}}
+
+	pkgs := []*apk.Package{myapp, mytool, python, libcrypto, busybox, glibc}
+	for _, tc := range []struct {
+		budget int
+		want   []*group
+	}{{
+		// Every group fits: glibc and busybox at the bottom, then what they
+		// are depended on by, then the leaves.
+		budget: 10,
+		want: []*group{
+			{pkgs: []*apk.Package{glibc}, size: size(glibc), tiebreaker: "glibc"},
+			{pkgs: []*apk.Package{busybox}, size: size(busybox), tiebreaker: "busybox"},
+			{pkgs: []*apk.Package{python}, size: size(python), tiebreaker: "python-3.12"},
+			{pkgs: []*apk.Package{libcrypto}, size: size(libcrypto), tiebreaker: "libcrypto3"},
+			{pkgs: []*apk.Package{myapp}, size: size(myapp), tiebreaker: "myapp"},
+			{pkgs: []*apk.Package{mytool}, size: size(mytool), tiebreaker: "mytool"},
+		},
+	}, {
+		// Each tier keeps an overflow layer, leaving one slot for the largest group.
+		budget: 4,
+		want: []*group{
+			{pkgs: []*apk.Package{busybox, glibc}, size: size(glibc, busybox), tiebreaker: "glibc"},
+			{pkgs: []*apk.Package{python}, size: size(python), tiebreaker: "python-3.12"},
+			{pkgs: []*apk.Package{libcrypto}, size: size(libcrypto), tiebreaker: "libcrypto3"},
+			{pkgs: []*apk.Package{myapp, mytool}, size: size(myapp, mytool), tiebreaker: "mytool"},
+		},
+	}, {
+		// The app tier is merged down when the budget cannot fit every tier.
+		budget: 2,
+		want: []*group{
+			{pkgs: []*apk.Package{busybox, glibc}, size: size(glibc, busybox), tiebreaker: "glibc"},
+			{pkgs: []*apk.Package{libcrypto, myapp, mytool, python}, size: size(libcrypto, myapp, mytool, python), tiebreaker: "python-3.12"},
+		},
+	}, {
+		// reasonable default if budget is unspecified
+		want: []*group{
+			{pkgs: []*apk.Package{busybox, glibc, libcrypto, myapp, mytool, python}, size: size(pkgs...), tiebreaker: "python-3.12"},
+		},
+	}} {
+		got, err := groupByChangeFrequency(pkgs, tc.budget)
+		if err != nil {
+			t.Fatalf("groupByChangeFrequency(%d): %v", tc.budget, err)
+		}
+		if err := compareGroups(got, tc.want); err != nil {
+			t.Errorf("groupByChangeFrequency(%d) mismatch: %v", tc.budget, err)
+
+			for i, g := range got {
+				t.Logf("got[%d]: %v", i, g.pkgs)
+			}
+		}
+	}
+}
+
 func compareGroups(a, b []*group) error {
 	if len(a) != len(b) {
 		return fmt.Errorf("len(a) = %d; len(b) = %d", len(a), len(b))
@@ -280,7 +357,7 @@ func TestSplitLayersDirectoryCreation(t *testing.T) {
 
 	// Call splitLayers to create the layers
 	ctx := context.Background()
-	layers, err := splitLayers(ctx, fsys, groups, pkgToDiff, tmpDir)
+	layers, err := splitLayers(ctx, fsys, groups, nil, nil, pkgToDiff, time.Time{}, &options.Options{TempDirPath: tmpDir})
 	if err != nil {
 		t.Fatalf("splitLayers failed: %v", err)
 	}
@@ -348,3 +425,66 @@ func TestSplitLayersDirectoryCreation(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitLayersPathLayers(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	for _, dir := range []string{"etc", "usr/share/locale/de/LC_MESSAGES"} {
+		if err := fsys.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"etc/hosts", "usr/share/locale/de/LC_MESSAGES/foo.mo"} {
+		if err := fsys.WriteFile(f, []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pathLayers := []types.PathLayer{{Name: "locales", Paths: []string{"usr/share/locale"}}}
+	layers, err := splitLayers(context.Background(), fsys, nil, pathLayers, nil, nil, time.Time{}, &options.Options{TempDirPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("splitLayers failed: %v", err)
+	}
+	if len(layers) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(layers))
+	}
+
+	files := func(l v1.Layer) []string {
+		rc, err := l.Uncompressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		var names []string
+		tr := tar.NewReader(rc)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return names
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				names = append(names, hdr.Name)
+			}
+		}
+	}
+	if got := files(layers[0]); !slices.Equal(got, []string{"usr/share/locale/de/LC_MESSAGES/foo.mo"}) {
+		t.Errorf("locales layer has %v", got)
+	}
+	if got := files(layers[1]); !slices.Equal(got, []string{"etc/hosts"}) {
+		t.Errorf("top layer has %v", got)
+	}
+	if got := layers[0].(*layer).Annotations()[LayerNameAnnotation]; got != "locales" {
+		t.Errorf("locales layer annotation = %q", got)
+	}
+	if got := layers[0].(*layer).Comment(); got != "layer locales: paths usr/share/locale" {
+		t.Errorf("locales layer comment = %q", got)
+	}
+	if got := layers[1].(*layer).Comment(); got != "files not owned by any package" {
+		t.Errorf("top layer comment = %q", got)
+	}
+	if err := validatePathLayers([]types.PathLayer{{Name: "a", Paths: []string{"x"}}, {Name: "a", Paths: []string{"y"}}}); err == nil {
+		t.Error("duplicate layer names were accepted")
+	}
+}
@@ -27,6 +27,7 @@ import (
 
 	"chainguard.dev/apko/pkg/build/types"
 	pkglock "chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/options"
 )
 
 // LockImageConfiguration returns a map of locked image configurations for each architecture,
@@ -35,15 +36,11 @@ import (
 // architecture that could not be locked. Using the "index" architecture is equivalent to what
 // this used to return prior to supporting per-arch locked configs.
 func LockImageConfiguration(ctx context.Context, ic types.ImageConfiguration, opts ...Option) (map[string]*types.ImageConfiguration, map[string][]string, error) {
-	o, input, err := NewOptions(append(opts, WithImageConfiguration(ic))...)
+	o, input, err := lockInput(ic, opts...)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	input.Contents.BuildRepositories = sets.List(sets.New(input.Contents.BuildRepositories...).Insert(o.ExtraBuildRepos...))
-	input.Contents.Repositories = sets.List(sets.New(input.Contents.Repositories...).Insert(o.ExtraRepos...))
-	input.Contents.Keyring = sets.List(sets.New(input.Contents.Keyring...).Insert(o.ExtraKeyFiles...))
-
 	mc, err := NewMultiArch(ctx, input.Archs, append(opts, WithImageConfiguration(*input))...)
 	if err != nil {
 		return nil, nil, err
@@ -64,16 +61,23 @@ func LockImageConfiguration(ctx context.Context, ic types.ImageConfiguration, op
 			return nil, missing, err
 		}
 	} else {
-		l, err := pkglock.FromFile(o.Lockfile)
-		if err != nil {
-			return nil, nil, err
-		}
-		for _, bc := range mc.Contexts {
-			if err := bc.VerifyLockfileConsistency(ctx, l.Config); err != nil {
+		pls = make(map[string][]string, len(input.Archs))
+		for _, arch := range input.Archs {
+			l, err := pkglock.FromFile(pkglock.ForArch(o.Lockfile, arch))
+			if err != nil {
 				return nil, nil, err
 			}
+			for _, bc := range mc.Contexts {
+				if err := bc.VerifyLockfileConsistency(ctx, l.Config); err != nil {
+					return nil, nil, err
+				}
+			}
+			locked := l.Arch2LockedPackages([]types.Architecture{arch})
+			if _, ok := locked[arch.String()]; !ok {
+				return nil, nil, fmt.Errorf("lock file %s locks no packages for %s", pkglock.ForArch(o.Lockfile, arch), arch)
+			}
+			maps.Copy(pls, locked)
 		}
-		pls = l.Arch2LockedPackages(input.Archs)
 	}
 
 	ics := make(map[string]*types.ImageConfiguration, len(mc.Contexts)+1)
@@ -98,6 +102,26 @@ func LockImageConfiguration(ctx context.Context, ic types.ImageConfiguration, op
 	return ics, missing, nil
 }
 
+// lockfile returns the lock file bc builds from, which may lock its
+// architecture on its own.
+func (bc *Context) lockfile() string {
+	return pkglock.ForArch(bc.o.Lockfile, bc.Arch())
+}
+
+// lockInput evaluates opts with ic and folds the extra repositories and keys
+// from the options into the returned configuration.
+func lockInput(ic types.ImageConfiguration, opts ...Option) (*options.Options, *types.ImageConfiguration, error) {
+	o, input, err := NewOptions(append(opts, WithImageConfiguration(ic))...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	input.Contents.BuildRepositories = sets.List(sets.New(input.Contents.BuildRepositories...).Insert(o.ExtraBuildRepos...))
+	input.Contents.Repositories = sets.List(sets.New(input.Contents.Repositories...).Insert(o.ExtraRepos...))
+	input.Contents.Keyring = sets.List(sets.New(input.Contents.Keyring...).Insert(o.ExtraKeyFiles...))
+	return o, input, nil
+}
+
 func resolvePackageList(ctx context.Context, mc *MultiArch) ([]resolved, error) {
 	archs := make([]resolved, 0, len(mc.Contexts))
 
@@ -146,11 +170,15 @@ type resolved struct {
 
 // unify returns (locked packages (per arch), missing packages (per arch), error)
 func unify(originals []string, inputs []resolved) (map[string][]string, map[string][]string, error) {
-	if len(originals) == 0 || len(inputs) == 0 {
-		// If there are no original packages, then we can't really do anything.
+	if len(inputs) == 0 {
+		// If there are no resolved architectures, then we can't really do anything.
 		// This used to return nil but multi-arch unification assumes we always
 		// have an "index" entry, even if it's empty, so we return this now.
 		// Mostly this is to satisfy some tests that have no package inputs.
+		//
+		// Note that an empty list of original packages is fine: images built
+		// purely from paths and accounts still need a (possibly empty) locked
+		// configuration for every architecture.
 		return map[string][]string{"index": {}}, nil, nil
 	}
 	originalPackages := resolved{
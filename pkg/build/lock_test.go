@@ -33,6 +33,22 @@ func TestUnify(t *testing.T) {
 	}{{
 		name: "empty",
 		want: map[string][]string{"index": {}},
+	}, {
+		name: "no packages",
+		inputs: []resolved{{
+			arch:     "amd64",
+			packages: sets.New[string](),
+			versions: map[string]string{},
+		}, {
+			arch:     "arm64",
+			packages: sets.New[string](),
+			versions: map[string]string{},
+		}},
+		want: map[string][]string{
+			"amd64": {},
+			"arm64": {},
+			"index": {},
+		},
 	}, {
 		name:      "no inputs",
 		originals: []string{"foo", "bar", "baz"},
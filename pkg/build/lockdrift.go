@@ -0,0 +1,138 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// lockDriftKind tells apart the two ways a lock can disagree with the
+// repositories it was resolved against.
+type lockDriftKind int
+
+const (
+	// driftStale means the repositories no longer serve the locked version,
+	// typically because it was superseded and withdrawn. The lock is out of
+	// date and needs regenerating, but nothing was tampered with.
+	driftStale lockDriftKind = iota
+	// driftRewritten means the repositories serve the locked version with a
+	// different checksum: the repository rewrote history under the lock.
+	driftRewritten
+)
+
+type lockDrift struct {
+	kind     lockDriftKind
+	pkg      lock.LockPkg
+	current  string   // checksum now served, for driftRewritten
+	versions []string // versions now served, for driftStale
+}
+
+func (d lockDrift) String() string {
+	switch d.kind {
+	case driftRewritten:
+		return fmt.Sprintf("repository rewrote history: %s-%s is locked with checksum %s but is now served with checksum %s", d.pkg.Name, d.pkg.Version, d.pkg.Checksum, d.current)
+	default:
+		if len(d.versions) == 0 {
+			return fmt.Sprintf("stale lock: %s-%s is locked but %s is no longer in the repositories", d.pkg.Name, d.pkg.Version, d.pkg.Name)
+		}
+		return fmt.Sprintf("stale lock: %s-%s is locked but the repositories now serve %v", d.pkg.Name, d.pkg.Version, d.versions)
+	}
+}
+
+// findLockDrift compares the packages locked for arch with the packages the
+// indexes now serve. A locked package is in sync if any index serves its
+// version with its checksum, so that mirrors that lag behind do not count.
+func findLockDrift(l lock.Lock, arch string, indexes []apk.NamedIndex) []lockDrift {
+	served := map[string][]*apk.Package{}
+	for _, idx := range indexes {
+		for _, p := range idx.Packages() {
+			if p.Arch == arch {
+				served[p.Name] = append(served[p.Name], p.Package)
+			}
+		}
+	}
+
+	var drifts []lockDrift
+	for _, lp := range l.Contents.Packages {
+		if lp.Architecture != arch {
+			continue
+		}
+		var (
+			current  string
+			versions []string
+			inSync   bool
+		)
+		for _, p := range served[lp.Name] {
+			if p.Version != lp.Version {
+				if !slices.Contains(versions, p.Version) {
+					versions = append(versions, p.Version)
+				}
+				continue
+			}
+			if p.ChecksumString() == lp.Checksum {
+				inSync = true
+				break
+			}
+			current = p.ChecksumString()
+		}
+		switch {
+		case inSync:
+		case current != "":
+			drifts = append(drifts, lockDrift{kind: driftRewritten, pkg: lp, current: current})
+		default:
+			slices.Sort(versions)
+			drifts = append(drifts, lockDrift{kind: driftStale, pkg: lp, versions: versions})
+		}
+	}
+	return drifts
+}
+
+// checkLockDrift reports the packages of the lock that no longer match what
+// the repositories serve. Stale locks are only ever warned about; packages
+// whose checksum changed upstream fail the build in options.CheckFail mode.
+func (bc *Context) checkLockDrift(ctx context.Context, l lock.Lock) error {
+	if bc.o.LockDrift == options.CheckOff {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "checkLockDrift")
+	defer span.End()
+
+	indexes, err := bc.apk.GetRepositoryIndexes(ctx, bc.o.IgnoreSignatures)
+	if err != nil {
+		return fmt.Errorf("fetching repository indexes to check the lock against: %w", err)
+	}
+
+	var errs []error
+	for _, d := range findLockDrift(l, bc.Arch().ToAPK(), indexes) {
+		if d.kind == driftRewritten && bc.o.LockDrift == options.CheckFail {
+			errs = append(errs, errors.New(d.String()))
+			continue
+		}
+		log.Warn(d.String())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/lock"
+)
+
+func TestFindLockDrift(t *testing.T) {
+	pkg := func(name, version, sum string) *apk.Package {
+		return &apk.Package{Name: name, Version: version, Arch: "x86_64", Checksum: []byte(sum)}
+	}
+	locked := func(p *apk.Package) lock.LockPkg {
+		return lock.LockPkg{Name: p.Name, Version: p.Version, Architecture: p.Arch, Checksum: p.ChecksumString()}
+	}
+
+	same := pkg("same", "1.0-r0", "same")
+	rewritten := pkg("rewritten", "1.0-r0", "original")
+	stale := pkg("stale", "1.0-r0", "stale")
+	gone := pkg("gone", "1.0-r0", "gone")
+	mirrored := pkg("mirrored", "1.0-r0", "mirrored")
+
+	repo := &apk.Repository{URI: "https://example.com/os/x86_64"}
+	index := apk.NewNamedRepositoryWithIndex("main", repo.WithIndex(&apk.APKIndex{
+		Packages: []*apk.Package{
+			same,
+			pkg("rewritten", "1.0-r0", "rebuilt"),
+			pkg("stale", "1.0-r1", "stale"),
+			pkg("stale", "1.1-r0", "stale"),
+			pkg("mirrored", "1.0-r0", "other"),
+		},
+	}))
+	mirror := apk.NewNamedRepositoryWithIndex("mirror", repo.WithIndex(&apk.APKIndex{
+		Packages: []*apk.Package{mirrored},
+	}))
+
+	l := lock.Lock{}
+	for _, p := range []*apk.Package{same, rewritten, stale, gone, mirrored} {
+		l.Contents.Packages = append(l.Contents.Packages, locked(p))
+	}
+	// Packages for other architectures are not compared.
+	l.Contents.Packages = append(l.Contents.Packages, lock.LockPkg{Name: "other", Version: "1.0-r0", Architecture: "aarch64"})
+
+	drifts := findLockDrift(l, "x86_64", []apk.NamedIndex{index, mirror})
+	require.Len(t, drifts, 3)
+
+	require.Equal(t, driftRewritten, drifts[0].kind)
+	require.Equal(t, "rewritten", drifts[0].pkg.Name)
+	require.Equal(t, pkg("", "", "rebuilt").ChecksumString(), drifts[0].current)
+	require.Contains(t, drifts[0].String(), "repository rewrote history")
+
+	require.Equal(t, driftStale, drifts[1].kind)
+	require.Equal(t, []string{"1.0-r1", "1.1-r0"}, drifts[1].versions)
+	require.Contains(t, drifts[1].String(), "stale lock")
+
+	require.Equal(t, driftStale, drifts[2].kind)
+	require.Equal(t, "gone", drifts[2].pkg.Name)
+	require.Contains(t, drifts[2].String(), "no longer in the repositories")
+}
@@ -16,7 +16,6 @@ package build
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"slices"
 	"sync"
@@ -70,7 +69,7 @@ func (m *MultiArch) BuildLayers(ctx context.Context) (map[types.Architecture]v1.
 		mu sync.Mutex
 	)
 	layers := map[types.Architecture]v1.Layer{}
-	errs := []error{}
+	var errs []*ArchError
 	for arch, bc := range m.Contexts {
 		g.Go(func() error {
 			_, layer, err := bc.BuildLayer(ctx)
@@ -79,7 +78,7 @@ func (m *MultiArch) BuildLayers(ctx context.Context) (map[types.Architecture]v1.
 			defer mu.Unlock()
 
 			if err != nil {
-				errs = append(errs, fmt.Errorf("for arch %q: %w", arch, err))
+				errs = append(errs, NewArchError(arch, err))
 				return nil
 			}
 
@@ -93,7 +92,7 @@ func (m *MultiArch) BuildLayers(ctx context.Context) (map[types.Architecture]v1.
 		return nil, err
 	}
 
-	if err := errors.Join(errs...); err != nil {
+	if err := NewMultiArchError(errs); err != nil {
 		return nil, fmt.Errorf("building layers: %w", err)
 	}
 
@@ -106,7 +105,7 @@ func (m *MultiArch) BuildPackageLists(ctx context.Context) (map[types.Architectu
 		mu sync.Mutex
 	)
 	toInstalls := map[types.Architecture][]*apk.RepositoryPackage{}
-	errs := []error{}
+	var errs []*ArchError
 	for arch, bc := range m.Contexts {
 		g.Go(func() error {
 			toInstall, _, err := bc.apk.ResolveWorld(ctx)
@@ -115,7 +114,7 @@ func (m *MultiArch) BuildPackageLists(ctx context.Context) (map[types.Architectu
 			defer mu.Unlock()
 
 			if err != nil {
-				errs = append(errs, fmt.Errorf("for arch %q: %w", arch, err))
+				errs = append(errs, NewArchError(arch, CategorizeError(ErrorCategoryResolve, err)))
 				return nil
 			}
 
@@ -129,9 +128,9 @@ func (m *MultiArch) BuildPackageLists(ctx context.Context) (map[types.Architectu
 		return nil, err
 	}
 
-	if err := errors.Join(errs...); err != nil {
+	if err := NewMultiArchError(errs); err != nil {
 		return nil, fmt.Errorf("resolving apk packages: %w", err)
 	}
 
-	return toInstalls, errors.Join(errs...)
+	return toInstalls, nil
 }
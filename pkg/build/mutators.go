@@ -0,0 +1,46 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// FSMutator changes the image filesystem after the packages are installed
+// and before the layers are written, e.g. to add files, or to strip locales
+// or documentation. An error fails the build.
+type FSMutator func(ctx context.Context, fsys apkfs.FullFS) error
+
+// runFSMutators runs each of mutators over fsys in turn.
+func runFSMutators(ctx context.Context, fsys apkfs.FullFS, mutators []FSMutator) error {
+	if len(mutators) == 0 {
+		return nil
+	}
+
+	ctx, span := otel.Tracer("apko").Start(ctx, "runFSMutators")
+	defer span.End()
+
+	for i, m := range mutators {
+		if err := m(ctx, fsys); err != nil {
+			return fmt.Errorf("running filesystem mutator %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+// The artifact types of the build products other than images and SBOMs
+// that can be attached to what apko publishes.
+const (
+	// RootFSArtifactType is a gzipped tarball of the filesystem of an image,
+	// its layers flattened.
+	RootFSArtifactType ggcrtypes.MediaType = "application/vnd.dev.chainguard.apko.rootfs.v1.tar+gzip"
+	// BuildReportArtifactType is the JSON build report of an index.
+	BuildReportArtifactType ggcrtypes.MediaType = "application/vnd.dev.chainguard.apko.build-report.v1+json"
+	// FetchManifestArtifactType is the lock file of the packages fetched to
+	// build an index, as written with --pin-file.
+	FetchManifestArtifactType ggcrtypes.MediaType = "application/vnd.dev.chainguard.apko.fetch-manifest.v1+json"
+)
+
+// Artifact is a file attached to an index as an OCI artifact, see
+// AttachArtifact.
+type Artifact struct {
+	// Path is the file.
+	Path string
+	// MediaType is the artifact type of the file.
+	MediaType ggcrtypes.MediaType
+}
+
+// AttachArtifact pushes the file at path to repo as an OCI artifact of type
+// mt whose subject is idx, with annotations, as AttachProvenance does for the
+// provenance. It returns the digest of the artifact.
+func AttachArtifact(ctx context.Context, idx v1.ImageIndex, path string, mt ggcrtypes.MediaType, annotations map[string]string, repo name.Repository, remoteOpts ...remote.Option) (name.Digest, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "AttachArtifact")
+	defer span.End()
+
+	artifact, subject, err := indexArtifact(idx, path, path, mt, annotations)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return pushArtifact(ctx, artifact, mt, subject, repo, remoteOpts...)
+}
+
+// AttachRootFS pushes the filesystem of each image in idx to repo, as a
+// gzipped tarball of its flattened layers, in an OCI artifact whose subject
+// is the image and that carries annotations. The tarballs are spooled to
+// temporary files created through o. It returns the digests of the
+// artifacts.
+func AttachRootFS(ctx context.Context, o *options.Options, idx v1.ImageIndex, annotations map[string]string, repo name.Repository, remoteOpts ...remote.Option) ([]name.Digest, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "AttachRootFS")
+	defer span.End()
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	digests := make([]name.Digest, 0, len(manifest.Manifests))
+	for _, m := range manifest.Manifests {
+		img, err := idx.Image(m.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image for %v from index: %w", m, err)
+		}
+		dig, err := attachRootFS(ctx, o, img, v1.Descriptor{MediaType: m.MediaType, Size: m.Size, Digest: m.Digest}, annotations, repo, remoteOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("attaching rootfs to %s: %w", m.Digest, err)
+		}
+		digests = append(digests, dig)
+	}
+	return digests, nil
+}
+
+// attachRootFS pushes the filesystem of img, described by subject, to repo.
+func attachRootFS(ctx context.Context, o *options.Options, img v1.Image, subject v1.Descriptor, annotations map[string]string, repo name.Repository, remoteOpts ...remote.Option) (name.Digest, error) {
+	artifact, cleanup, err := rootFSArtifact(o, img, subject, annotations)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	defer cleanup()
+	return pushArtifact(ctx, artifact, RootFSArtifactType, subject, repo, remoteOpts...)
+}
+
+// rootFSArtifact returns the artifact holding the filesystem of img,
+// described by subject, with annotations. The tarball is spooled to a
+// temporary file created through o, as it can be large, which cleanup
+// removes once the artifact is no longer needed.
+func rootFSArtifact(o *options.Options, img v1.Image, subject v1.Descriptor, annotations map[string]string) (artifact v1.Image, cleanup func(), err error) {
+	f, err := o.CreateTemp("", "apko-rootfs-*.tar.gz")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+	defer f.Close()
+
+	rc := mutate.Extract(img)
+	defer rc.Close()
+	zw := gzip.NewWriter(f)
+	if _, err := io.Copy(zw, rc); err != nil {
+		return nil, nil, fmt.Errorf("flattening image: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	l, err := tarball.LayerFromFile(f.Name(), tarball.WithMediaType(RootFSArtifactType))
+	if err != nil {
+		return nil, nil, err
+	}
+	artifact, err = layerArtifact(l, RootFSArtifactType, subject, annotations)
+	if err != nil {
+		return nil, nil, err
+	}
+	return artifact, cleanup, nil
+}
+
+// pushArtifact pushes artifact, of type mt, to repo by digest.
+func pushArtifact(ctx context.Context, artifact v1.Image, mt ggcrtypes.MediaType, subject v1.Descriptor, repo name.Repository, remoteOpts ...remote.Option) (name.Digest, error) {
+	h, err := artifact.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	dig := repo.Digest(h.String())
+	clog.FromContext(ctx).Infof("attaching %s to %s as %s", mt, subject.Digest, dig)
+	if err := writeReferrer(ctx, dig, artifact, mt, subject, remoteOpts...); err != nil {
+		return name.Digest{}, fmt.Errorf("attaching %s to %s: %w", mt, subject.Digest, err)
+	}
+	return dig, nil
+}
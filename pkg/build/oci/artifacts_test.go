@@ -0,0 +1,132 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestAttachArtifacts(t *testing.T) {
+	ctx := context.Background()
+	s := newRegistry(t, true)
+
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/test")
+	require.NoError(t, err)
+
+	idx, err := random.Index(256, 2, 2)
+	require.NoError(t, err)
+	manifest, err := idx.IndexManifest()
+	require.NoError(t, err)
+	indexDigest, err := idx.Digest()
+	require.NoError(t, err)
+
+	report := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, os.WriteFile(report, []byte(`{"archs":[]}`), 0o644))
+	annotations := map[string]string{"com.example.data-classification": "internal"}
+	dig, err := AttachArtifact(ctx, idx, report, BuildReportArtifactType, annotations, repo)
+	require.NoError(t, err)
+
+	referrers, err := remote.Referrers(repo.Digest(indexDigest.String()))
+	require.NoError(t, err)
+	rm, err := referrers.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, rm.Manifests, 1)
+	require.Equal(t, dig.DigestStr(), rm.Manifests[0].Digest.String())
+	require.Equal(t, string(BuildReportArtifactType), rm.Manifests[0].ArtifactType)
+	requireAnnotations(t, dig, annotations)
+
+	digests, err := AttachRootFS(ctx, &options.Options{}, idx, annotations, repo)
+	require.NoError(t, err)
+	require.Len(t, digests, 2)
+	for i, m := range manifest.Manifests {
+		referrers, err := remote.Referrers(repo.Digest(m.Digest.String()))
+		require.NoError(t, err)
+		rm, err := referrers.IndexManifest()
+		require.NoError(t, err)
+		require.Len(t, rm.Manifests, 1)
+		require.Equal(t, digests[i].DigestStr(), rm.Manifests[0].Digest.String())
+		require.Equal(t, string(RootFSArtifactType), rm.Manifests[0].ArtifactType)
+		requireAnnotations(t, digests[i], annotations)
+
+		// The artifact holds the files of every layer of the image.
+		img, err := idx.Image(m.Digest)
+		require.NoError(t, err)
+		want := map[string]bool{}
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		for _, l := range layers {
+			for _, name := range tarNames(t, l.Uncompressed) {
+				want[name] = true
+			}
+		}
+
+		artifact, err := remote.Image(digests[i])
+		require.NoError(t, err)
+		layers, err = artifact.Layers()
+		require.NoError(t, err)
+		require.Len(t, layers, 1)
+		got := map[string]bool{}
+		for _, name := range tarNames(t, func() (io.ReadCloser, error) {
+			rc, err := layers[0].Compressed()
+			if err != nil {
+				return nil, err
+			}
+			return gzip.NewReader(rc)
+		}) {
+			got[name] = true
+		}
+		require.Equal(t, want, got)
+	}
+}
+
+// requireAnnotations checks that the manifest at dig has annotations.
+func requireAnnotations(t *testing.T, dig name.Digest, annotations map[string]string) {
+	img, err := remote.Image(dig)
+	require.NoError(t, err)
+	m, err := img.Manifest()
+	require.NoError(t, err)
+	require.Equal(t, annotations, m.Annotations)
+}
+
+// tarNames returns the names of the entries of the tarball open returns.
+func tarNames(t *testing.T, open func() (io.ReadCloser, error)) []string {
+	rc, err := open()
+	require.NoError(t, err)
+	defer rc.Close()
+	var names []string
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+}
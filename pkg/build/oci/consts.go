@@ -18,3 +18,7 @@ const (
 	LocalDomain = "apko.local"
 	LocalRepo   = "cache"
 )
+
+// AnnotationRebuildAfter records when an image with rebuild-after configured
+// should be rebuilt, in RFC 3339 format.
+const AnnotationRebuildAfter = "dev.chainguard.apko.rebuild-after"
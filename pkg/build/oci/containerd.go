@@ -0,0 +1,515 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	diffapi "github.com/containerd/containerd/api/services/diff/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	ctrtypes "github.com/containerd/containerd/api/types"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// DefaultContainerdAddress is the socket of a containerd installed on its
+// own, as nerdctl uses. k3s runs its own at /run/k3s/containerd/containerd.sock.
+const DefaultContainerdAddress = "/run/containerd/containerd.sock"
+
+// DefaultContainerdSnapshotter is the snapshotter LoadIndexContainerd unpacks
+// the image for, containerd's and k3s's default.
+const DefaultContainerdSnapshotter = "overlayfs"
+
+// containerdWriteChunk is how much of a blob is sent to containerd per
+// message, well under its 16MiB message limit.
+const containerdWriteChunk = 1 << 20
+
+// LoadIndexContainerd imports idx into the namespace of the containerd
+// listening at address, with each of tags as an image name, through
+// containerd's content, images, snapshots and diff services. Blobs are
+// streamed from idx rather than written to disk, and the image of the
+// host's architecture is unpacked into DefaultContainerdSnapshotter, as
+// `ctr images import` does. Unlike LoadIndex, every architecture is kept, so
+// the image has the digest it would have in a registry.
+func LoadIndexContainerd(ctx context.Context, idx v1.ImageIndex, tags []string, address, namespace string) (name.Digest, error) {
+	ts := make([]name.Tag, 0, len(tags))
+	for _, tag := range tags {
+		t, err := name.NewTag(tag)
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("parsing tag %q: %w", tag, err)
+		}
+		ts = append(ts, t)
+	}
+	if len(ts) == 0 {
+		return name.Digest{}, errors.New("no tags to import into containerd")
+	}
+	h, err := idx.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	mt, err := idx.MediaType()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	size, err := idx.Size()
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	blobs := &containerdBlobs{seen: map[v1.Hash]int{}}
+	if err := blobs.index(idx); err != nil {
+		return name.Digest{}, err
+	}
+	unpack, err := blobs.unpackTarget(idx)
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	conn, err := grpc.NewClient("unix://"+address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("connecting to containerd at %s: %w", address, err)
+	}
+	defer conn.Close()
+	c := &containerdClient{
+		content:   contentapi.NewContentClient(conn),
+		images:    imagesapi.NewImagesClient(conn),
+		leases:    leasesapi.NewLeasesClient(conn),
+		snapshots: snapshotsapi.NewSnapshotsClient(conn),
+		diff:      diffapi.NewDiffClient(conn),
+	}
+
+	log := clog.FromContext(ctx)
+	log.Infof("importing %s into containerd namespace %s at %s", strings.Join(tags, ", "), namespace, address)
+	ctx = metadata.AppendToOutgoingContext(ctx, "containerd-namespace", namespace)
+
+	// Hold a lease on everything written until the images refer to it, so
+	// containerd's garbage collector leaves it be.
+	lease, err := c.leases.Create(ctx, &leasesapi.CreateRequest{
+		ID:     fmt.Sprintf("apko-%s-%d", h.Hex[:12], time.Now().UnixNano()),
+		Labels: map[string]string{"containerd.io/gc.expire": time.Now().Add(time.Hour).Format(time.RFC3339)},
+	})
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("creating containerd lease: %w", err)
+	}
+	defer func() {
+		if _, err := c.leases.Delete(context.WithoutCancel(ctx), &leasesapi.DeleteRequest{ID: lease.Lease.ID}); err != nil {
+			log.Warnf("deleting containerd lease %s: %v", lease.Lease.ID, err)
+		}
+	}()
+	ctx = metadata.AppendToOutgoingContext(ctx, "containerd-lease", lease.Lease.ID)
+
+	for _, b := range blobs.list {
+		if err := c.writeBlob(ctx, b); err != nil {
+			return name.Digest{}, fmt.Errorf("writing %s to containerd: %w", b.digest, err)
+		}
+	}
+	if unpack != nil {
+		if err := c.unpack(ctx, unpack); err != nil {
+			return name.Digest{}, fmt.Errorf("unpacking %s: %w", unpack.manifest, err)
+		}
+	} else {
+		log.Warnf("no linux/%s image to unpack into containerd, it will be unpacked when first run", runtime.GOARCH)
+	}
+
+	target := &ctrtypes.Descriptor{MediaType: string(mt), Digest: h.String(), Size: size}
+	for _, t := range ts {
+		if err := c.setImage(ctx, &imagesapi.Image{Name: containerdImageName(t), Target: target}); err != nil {
+			return name.Digest{}, fmt.Errorf("creating containerd image %s: %w", containerdImageName(t), err)
+		}
+	}
+
+	return ts[0].Context().Digest(h.String()), nil
+}
+
+// containerdImageName returns the name containerd clients give t: unlike
+// go-containerregistry, they call Docker Hub docker.io.
+func containerdImageName(t name.Tag) string {
+	registry := t.RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+	return fmt.Sprintf("%s/%s:%s", registry, t.RepositoryStr(), t.TagStr())
+}
+
+// containerdBlob is a blob to write to containerd's content store, with the
+// labels that keep what it refers to from being garbage collected.
+type containerdBlob struct {
+	digest    v1.Hash
+	mediaType string
+	size      int64
+	open      func() (io.ReadCloser, error)
+	labels    map[string]string
+}
+
+// containerdBlobs lists the blobs of an index, each once, children before
+// the manifests that refer to them.
+type containerdBlobs struct {
+	list []*containerdBlob
+	seen map[v1.Hash]int
+}
+
+func (c *containerdBlobs) add(b *containerdBlob) {
+	if _, ok := c.seen[b.digest]; ok {
+		return
+	}
+	c.seen[b.digest] = len(c.list)
+	c.list = append(c.list, b)
+}
+
+func (c *containerdBlobs) get(h v1.Hash) *containerdBlob {
+	return c.list[c.seen[h]]
+}
+
+func rawBlob(h v1.Hash, mt string, b []byte, labels map[string]string) *containerdBlob {
+	return &containerdBlob{
+		digest:    h,
+		mediaType: mt,
+		size:      int64(len(b)),
+		open:      func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil },
+		labels:    labels,
+	}
+}
+
+func (c *containerdBlobs) index(idx v1.ImageIndex) error {
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{}
+	for i, desc := range im.Manifests {
+		labels[fmt.Sprintf("containerd.io/gc.ref.content.m.%d", i)] = desc.Digest.String()
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := c.index(child); err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := c.image(img); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported media type %s of %s", desc.MediaType, desc.Digest)
+		}
+	}
+	raw, err := idx.RawManifest()
+	if err != nil {
+		return err
+	}
+	h, err := idx.Digest()
+	if err != nil {
+		return err
+	}
+	mt, err := idx.MediaType()
+	if err != nil {
+		return err
+	}
+	c.add(rawBlob(h, string(mt), raw, labels))
+	return nil
+}
+
+func (c *containerdBlobs) image(img v1.Image) error {
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	cfg, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	c.add(rawBlob(m.Config.Digest, string(m.Config.MediaType), cfg, map[string]string{}))
+	labels := map[string]string{"containerd.io/gc.ref.content.config": m.Config.Digest.String()}
+	for i, desc := range m.Layers {
+		labels[fmt.Sprintf("containerd.io/gc.ref.content.l.%d", i)] = desc.Digest.String()
+		l, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return err
+		}
+		c.add(&containerdBlob{
+			digest:    desc.Digest,
+			mediaType: string(desc.MediaType),
+			size:      desc.Size,
+			open:      l.Compressed,
+			labels:    map[string]string{},
+		})
+	}
+	raw, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	h, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	mt, err := img.MediaType()
+	if err != nil {
+		return err
+	}
+	c.add(rawBlob(h, string(mt), raw, labels))
+	return nil
+}
+
+// containerdUnpack is an image to unpack into a snapshotter: its layers, in
+// order, and the chain IDs of the snapshots they make.
+type containerdUnpack struct {
+	manifest v1.Hash
+	layers   []*containerdBlob
+	chainIDs []string
+}
+
+// unpackTarget returns the image of idx for the host's architecture, if any,
+// and labels its blobs with the snapshots unpacking it makes, so that they
+// are kept as long as the image is.
+func (c *containerdBlobs) unpackTarget(idx v1.ImageIndex) (*containerdUnpack, error) {
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range im.Manifests {
+		if !desc.MediaType.IsImage() || desc.Platform == nil ||
+			desc.Platform.OS != "linux" || desc.Platform.Architecture != runtime.GOARCH {
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		m, err := img.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.RootFS.DiffIDs) != len(m.Layers) {
+			return nil, fmt.Errorf("%s has %d layers but %d diff IDs", desc.Digest, len(m.Layers), len(cfg.RootFS.DiffIDs))
+		}
+		u := &containerdUnpack{manifest: desc.Digest}
+		var chainID v1.Hash
+		for i, l := range m.Layers {
+			diffID := cfg.RootFS.DiffIDs[i]
+			if i == 0 {
+				chainID = diffID
+			} else {
+				sum := sha256.Sum256([]byte(chainID.String() + " " + diffID.String()))
+				chainID = v1.Hash{Algorithm: "sha256", Hex: fmt.Sprintf("%x", sum)}
+			}
+			b := c.get(l.Digest)
+			b.labels["containerd.io/uncompressed"] = diffID.String()
+			u.layers = append(u.layers, b)
+			u.chainIDs = append(u.chainIDs, chainID.String())
+		}
+		if len(u.chainIDs) != 0 {
+			c.get(m.Config.Digest).labels["containerd.io/gc.ref.snapshot."+DefaultContainerdSnapshotter] = u.chainIDs[len(u.chainIDs)-1]
+		}
+		return u, nil
+	}
+	return nil, nil
+}
+
+type containerdClient struct {
+	content   contentapi.ContentClient
+	images    imagesapi.ImagesClient
+	leases    leasesapi.LeasesClient
+	snapshots snapshotsapi.SnapshotsClient
+	diff      diffapi.DiffClient
+}
+
+// writeBlob writes b to the content store, resuming an earlier partial write
+// of it. When containerd already has b, only its labels are updated.
+func (c *containerdClient) writeBlob(ctx context.Context, b *containerdBlob) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.content.Write(ctx)
+	if err != nil {
+		return err
+	}
+	send := func(req *contentapi.WriteContentRequest) (*contentapi.WriteContentResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		return stream.Recv()
+	}
+
+	resp, err := send(&contentapi.WriteContentRequest{
+		Action:   contentapi.WriteAction_STAT,
+		Ref:      "apko-" + b.digest.String(),
+		Total:    b.size,
+		Expected: b.digest.String(),
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return c.label(ctx, b)
+	} else if err != nil {
+		return err
+	}
+
+	rc, err := b.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	offset := resp.Offset
+	if offset > 0 && offset <= b.size {
+		if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+			return err
+		}
+	} else {
+		offset = 0
+	}
+	buf := make([]byte, containerdWriteChunk)
+	for {
+		n, err := io.ReadFull(rc, buf)
+		if n > 0 {
+			if _, err := send(&contentapi.WriteContentRequest{
+				Action: contentapi.WriteAction_WRITE,
+				Offset: offset,
+				Data:   buf[:n],
+			}); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	resp, err = send(&contentapi.WriteContentRequest{
+		Action:   contentapi.WriteAction_COMMIT,
+		Offset:   offset,
+		Total:    b.size,
+		Expected: b.digest.String(),
+		Labels:   b.labels,
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return c.label(ctx, b)
+	} else if err != nil {
+		return err
+	}
+	if resp.Digest != b.digest.String() {
+		return fmt.Errorf("containerd committed %s", resp.Digest)
+	}
+	return stream.CloseSend()
+}
+
+// label adds the labels of b to the copy containerd already has.
+func (c *containerdClient) label(ctx context.Context, b *containerdBlob) error {
+	if len(b.labels) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(b.labels))
+	for k := range b.labels {
+		paths = append(paths, "labels."+k)
+	}
+	_, err := c.content.Update(ctx, &contentapi.UpdateRequest{
+		Info:       &contentapi.Info{Digest: b.digest.String(), Labels: b.labels},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+	})
+	return err
+}
+
+// unpack applies the layers of u to DefaultContainerdSnapshotter, one
+// snapshot per layer, skipping those it already has.
+func (c *containerdClient) unpack(ctx context.Context, u *containerdUnpack) error {
+	parent := ""
+	for i, l := range u.layers {
+		chainID := u.chainIDs[i]
+		if _, err := c.snapshots.Stat(ctx, &snapshotsapi.StatSnapshotRequest{Snapshotter: DefaultContainerdSnapshotter, Key: chainID}); err == nil {
+			parent = chainID
+			continue
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		key := fmt.Sprintf("extract-%d-%s", time.Now().UnixNano(), chainID)
+		prep, err := c.snapshots.Prepare(ctx, &snapshotsapi.PrepareSnapshotRequest{
+			Snapshotter: DefaultContainerdSnapshotter,
+			Key:         key,
+			Parent:      parent,
+			Labels:      map[string]string{"containerd.io/snapshot.ref": chainID},
+		})
+		if err != nil {
+			return fmt.Errorf("preparing snapshot %s: %w", chainID, err)
+		}
+		if _, err := c.diff.Apply(ctx, &diffapi.ApplyRequest{
+			Diff:   &ctrtypes.Descriptor{MediaType: l.mediaType, Digest: l.digest.String(), Size: l.size},
+			Mounts: prep.Mounts,
+		}); err != nil {
+			c.removeSnapshot(ctx, key)
+			return fmt.Errorf("applying layer %s: %w", l.digest, err)
+		}
+		if _, err := c.snapshots.Commit(ctx, &snapshotsapi.CommitSnapshotRequest{
+			Snapshotter: DefaultContainerdSnapshotter,
+			Name:        chainID,
+			Key:         key,
+			Labels:      map[string]string{"containerd.io/snapshot.ref": chainID},
+		}); err != nil && status.Code(err) != codes.AlreadyExists {
+			c.removeSnapshot(ctx, key)
+			return fmt.Errorf("committing snapshot %s: %w", chainID, err)
+		} else if err != nil {
+			// Another client unpacked the same layer meanwhile.
+			c.removeSnapshot(ctx, key)
+		}
+		parent = chainID
+	}
+	return nil
+}
+
+func (c *containerdClient) removeSnapshot(ctx context.Context, key string) {
+	if _, err := c.snapshots.Remove(context.WithoutCancel(ctx), &snapshotsapi.RemoveSnapshotRequest{Snapshotter: DefaultContainerdSnapshotter, Key: key}); err != nil {
+		clog.FromContext(ctx).Warnf("removing snapshot %s: %v", key, err)
+	}
+}
+
+// setImage creates img, or points it at its new target if it exists.
+func (c *containerdClient) setImage(ctx context.Context, img *imagesapi.Image) error {
+	_, err := c.images.Create(ctx, &imagesapi.CreateImageRequest{Image: img})
+	if status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+	_, err = c.images.Update(ctx, &imagesapi.UpdateImageRequest{
+		Image:      img,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"target"}},
+	})
+	return err
+}
@@ -0,0 +1,336 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	diffapi "github.com/containerd/containerd/api/services/diff/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	ctrtypes "github.com/containerd/containerd/api/types"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeContainerd implements the parts of containerd's services that
+// LoadIndexContainerd uses, in memory.
+type fakeContainerd struct {
+	mu         sync.Mutex
+	namespaces map[string]bool
+	blobs      map[string][]byte
+	labels     map[string]map[string]string
+	images     map[string]string
+	snapshots  map[string]string
+	applied    []string
+	leases     map[string]bool
+}
+
+func newFakeContainerd(t *testing.T) (*fakeContainerd, string) {
+	t.Helper()
+	// Unix socket paths are short, shorter than t.TempDir() can be.
+	dir, err := os.MkdirTemp("", "ctrd")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	address := filepath.Join(dir, "containerd.sock")
+	lis, err := net.Listen("unix", address)
+	require.NoError(t, err)
+
+	f := &fakeContainerd{
+		namespaces: map[string]bool{},
+		blobs:      map[string][]byte{},
+		labels:     map[string]map[string]string{},
+		images:     map[string]string{},
+		snapshots:  map[string]string{},
+		leases:     map[string]bool{},
+	}
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			f.namespace(ctx)
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			f.namespace(ss.Context())
+			return handler(srv, ss)
+		}),
+	)
+	contentapi.RegisterContentServer(s, fakeContent{f: f})
+	imagesapi.RegisterImagesServer(s, fakeImages{f: f})
+	leasesapi.RegisterLeasesServer(s, fakeLeases{f: f})
+	snapshotsapi.RegisterSnapshotsServer(s, fakeSnapshots{f: f})
+	diffapi.RegisterDiffServer(s, fakeDiff{f: f})
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+	return f, address
+}
+
+func (f *fakeContainerd) namespace(ctx context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ns := range md.Get("containerd-namespace") {
+		f.namespaces[ns] = true
+	}
+}
+
+type fakeContent struct {
+	contentapi.UnimplementedContentServer
+	f *fakeContainerd
+}
+
+func (c fakeContent) Write(stream contentapi.Content_WriteServer) error {
+	f := c.f
+	var data []byte
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		f.mu.Lock()
+		_, exists := f.blobs[req.Expected]
+		f.mu.Unlock()
+		resp := &contentapi.WriteContentResponse{Action: req.Action}
+		switch req.Action {
+		case contentapi.WriteAction_STAT:
+			if exists {
+				return status.Error(codes.AlreadyExists, req.Expected)
+			}
+		case contentapi.WriteAction_WRITE:
+			if req.Offset != int64(len(data)) {
+				return status.Errorf(codes.InvalidArgument, "write at %d of %d bytes", req.Offset, len(data))
+			}
+			data = append(data, req.Data...)
+			resp.Offset = int64(len(data))
+		case contentapi.WriteAction_COMMIT:
+			dig := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+			if dig != req.Expected || int64(len(data)) != req.Total {
+				return status.Errorf(codes.FailedPrecondition, "got %s of %d bytes, want %s of %d", dig, len(data), req.Expected, req.Total)
+			}
+			f.mu.Lock()
+			f.blobs[dig] = data
+			f.labels[dig] = maps.Clone(req.Labels)
+			f.mu.Unlock()
+			resp.Digest = dig
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (c fakeContent) Update(_ context.Context, req *contentapi.UpdateRequest) (*contentapi.UpdateResponse, error) {
+	f := c.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.labels[req.Info.Digest] == nil {
+		f.labels[req.Info.Digest] = map[string]string{}
+	}
+	for _, p := range req.UpdateMask.Paths {
+		k := strings.TrimPrefix(p, "labels.")
+		f.labels[req.Info.Digest][k] = req.Info.Labels[k]
+	}
+	return &contentapi.UpdateResponse{Info: req.Info}, nil
+}
+
+type fakeImages struct {
+	imagesapi.UnimplementedImagesServer
+	f *fakeContainerd
+}
+
+func (i fakeImages) Create(_ context.Context, req *imagesapi.CreateImageRequest) (*imagesapi.CreateImageResponse, error) {
+	f := i.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.images[req.Image.Name]; ok {
+		return nil, status.Error(codes.AlreadyExists, req.Image.Name)
+	}
+	if _, ok := f.blobs[req.Image.Target.Digest]; !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "missing %s", req.Image.Target.Digest)
+	}
+	f.images[req.Image.Name] = req.Image.Target.Digest
+	return &imagesapi.CreateImageResponse{Image: req.Image}, nil
+}
+
+func (i fakeImages) Update(_ context.Context, req *imagesapi.UpdateImageRequest) (*imagesapi.UpdateImageResponse, error) {
+	f := i.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[req.Image.Name] = req.Image.Target.Digest
+	return &imagesapi.UpdateImageResponse{Image: req.Image}, nil
+}
+
+type fakeLeases struct {
+	leasesapi.UnimplementedLeasesServer
+	f *fakeContainerd
+}
+
+func (l fakeLeases) Create(_ context.Context, req *leasesapi.CreateRequest) (*leasesapi.CreateResponse, error) {
+	l.f.mu.Lock()
+	defer l.f.mu.Unlock()
+	l.f.leases[req.ID] = true
+	return &leasesapi.CreateResponse{Lease: &leasesapi.Lease{ID: req.ID, Labels: req.Labels}}, nil
+}
+
+func (l fakeLeases) Delete(_ context.Context, req *leasesapi.DeleteRequest) (*emptypb.Empty, error) {
+	l.f.mu.Lock()
+	defer l.f.mu.Unlock()
+	delete(l.f.leases, req.ID)
+	return &emptypb.Empty{}, nil
+}
+
+type fakeSnapshots struct {
+	snapshotsapi.UnimplementedSnapshotsServer
+	f *fakeContainerd
+}
+
+func (s fakeSnapshots) Stat(_ context.Context, req *snapshotsapi.StatSnapshotRequest) (*snapshotsapi.StatSnapshotResponse, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	if _, ok := s.f.snapshots[req.Key]; !ok {
+		return nil, status.Error(codes.NotFound, req.Key)
+	}
+	return &snapshotsapi.StatSnapshotResponse{}, nil
+}
+
+func (s fakeSnapshots) Prepare(_ context.Context, req *snapshotsapi.PrepareSnapshotRequest) (*snapshotsapi.PrepareSnapshotResponse, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	if _, ok := s.f.snapshots[req.Parent]; req.Parent != "" && !ok {
+		return nil, status.Errorf(codes.NotFound, "parent %s", req.Parent)
+	}
+	s.f.snapshots[req.Key] = req.Parent
+	return &snapshotsapi.PrepareSnapshotResponse{Mounts: []*ctrtypes.Mount{{Type: "bind", Source: req.Key}}}, nil
+}
+
+func (s fakeSnapshots) Commit(_ context.Context, req *snapshotsapi.CommitSnapshotRequest) (*emptypb.Empty, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	s.f.snapshots[req.Name] = s.f.snapshots[req.Key]
+	delete(s.f.snapshots, req.Key)
+	return &emptypb.Empty{}, nil
+}
+
+type fakeDiff struct {
+	diffapi.UnimplementedDiffServer
+	f *fakeContainerd
+}
+
+func (d fakeDiff) Apply(_ context.Context, req *diffapi.ApplyRequest) (*diffapi.ApplyResponse, error) {
+	d.f.mu.Lock()
+	defer d.f.mu.Unlock()
+	if _, ok := d.f.blobs[req.Diff.Digest]; !ok {
+		return nil, status.Errorf(codes.NotFound, "missing %s", req.Diff.Digest)
+	}
+	d.f.applied = append(d.f.applied, req.Diff.Digest)
+	return &diffapi.ApplyResponse{Applied: req.Diff}, nil
+}
+
+func TestLoadIndexContainerd(t *testing.T) {
+	host, err := random.Image(64, 2)
+	require.NoError(t, err)
+	other, err := random.Image(64, 1)
+	require.NoError(t, err)
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: host, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: runtime.GOARCH}}},
+		mutate.IndexAddendum{Add: other, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "s390x"}}},
+	)
+	h, err := idx.Digest()
+	require.NoError(t, err)
+	f, address := newFakeContainerd(t)
+
+	dig, err := LoadIndexContainerd(t.Context(), idx, []string{"example:latest", "registry.local/example:v1"}, address, "k8s.io")
+	require.NoError(t, err)
+	require.Equal(t, "index.docker.io/library/example@"+h.String(), dig.String())
+	require.Equal(t, map[string]bool{"k8s.io": true}, f.namespaces)
+	require.Equal(t, map[string]string{
+		"docker.io/library/example:latest": h.String(),
+		"registry.local/example:v1":        h.String(),
+	}, f.images)
+	require.Empty(t, f.leases, "lease released")
+
+	// Every blob is there, and the index keeps its manifests.
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"containerd.io/gc.ref.content.m.0": im.Manifests[0].Digest.String(),
+		"containerd.io/gc.ref.content.m.1": im.Manifests[1].Digest.String(),
+	}, f.labels[h.String()])
+	for _, img := range []v1.Image{host, other} {
+		m, err := img.Manifest()
+		require.NoError(t, err)
+		d, err := img.Digest()
+		require.NoError(t, err)
+		require.Contains(t, f.blobs, d.String())
+		require.Contains(t, f.blobs, m.Config.Digest.String())
+		for _, l := range m.Layers {
+			require.Contains(t, f.blobs, l.Digest.String())
+		}
+	}
+
+	// The host's image is unpacked, one snapshot per layer.
+	m, err := host.Manifest()
+	require.NoError(t, err)
+	cfg, err := host.ConfigFile()
+	require.NoError(t, err)
+	d0, d1 := cfg.RootFS.DiffIDs[0].String(), cfg.RootFS.DiffIDs[1].String()
+	top := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(d0+" "+d1)))
+	require.Equal(t, map[string]string{d0: "", top: d0}, f.snapshots)
+	require.Equal(t, []string{m.Layers[0].Digest.String(), m.Layers[1].Digest.String()}, f.applied)
+	require.Equal(t, top, f.labels[m.Config.Digest.String()]["containerd.io/gc.ref.snapshot."+DefaultContainerdSnapshotter])
+	require.Equal(t, d1, f.labels[m.Layers[1].Digest.String()]["containerd.io/uncompressed"])
+
+	// Loading a new image under the same name reuses what containerd has.
+	f.labels = map[string]map[string]string{}
+	idx2 := mutate.AppendManifests(idx, mutate.IndexAddendum{Add: other, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "ppc64le"}}})
+	h2, err := idx2.Digest()
+	require.NoError(t, err)
+	_, err = LoadIndexContainerd(t.Context(), idx2, []string{"example:latest"}, address, "k8s.io")
+	require.NoError(t, err)
+	require.Equal(t, h2.String(), f.images["docker.io/library/example:latest"])
+	require.Len(t, f.applied, 2, "layers not applied again")
+	require.Equal(t, top, f.labels[m.Config.Digest.String()]["containerd.io/gc.ref.snapshot."+DefaultContainerdSnapshotter], "labels of existing blobs updated")
+}
+
+func TestLoadIndexContainerdError(t *testing.T) {
+	idx, err := random.Index(64, 1, 1)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	_, err = LoadIndexContainerd(t.Context(), idx, []string{"example:latest"}, filepath.Join(dir, "missing.sock"), "default")
+	require.ErrorContains(t, err, "creating containerd lease")
+}
@@ -37,6 +37,13 @@ import (
 	"chainguard.dev/apko/pkg/options"
 )
 
+// DefaultEnvironment holds the environment variables set in every image
+// that does not set them itself.
+var DefaultEnvironment = map[string]string{
+	"PATH":          "/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+	"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
+}
+
 func BuildImageFromLayer(ctx context.Context, baseImage v1.Image, layer v1.Layer, oic types.ImageConfiguration, created time.Time, arch types.Architecture) (v1.Image, error) {
 	return BuildImageFromLayers(ctx, baseImage, []v1.Layer{layer}, oic, created, arch)
 }
@@ -76,11 +83,26 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 		log.Infof("layer digest: %v", digest)
 		log.Infof("layer diffID: %v", diffid)
 
+		// Layers that carry their own descriptor annotations, like the
+		// extra layers a build was given, keep them in the manifest.
+		var layerAnnotations map[string]string
+		if al, ok := layer.(interface{ Annotations() map[string]string }); ok {
+			layerAnnotations = al.Annotations()
+		}
+
+		// Layers that say what went into them, like those of a multi-layer
+		// build, are described by that in the history instead.
+		layerComment := comment
+		if cl, ok := layer.(interface{ Comment() string }); ok && cl.Comment() != "" {
+			layerComment = cl.Comment()
+		}
+
 		adds = append(adds, mutate.Addendum{
-			Layer: layer,
+			Layer:       layer,
+			Annotations: layerAnnotations,
 			History: v1.History{
 				Author:    "apko",
-				Comment:   comment,
+				Comment:   layerComment,
 				CreatedBy: "apko",
 				Created:   v1.Time{Time: created}, // TODO: Consider per-layer creation time?
 			},
@@ -107,6 +129,9 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 		}
 	}
 	annotations["org.opencontainers.image.created"] = created.Format(time.RFC3339)
+	if err := annotateRebuildAfter(annotations, *ic, created); err != nil {
+		return nil, err
+	}
 
 	v1Image = mutate.Annotations(v1Image, annotations).(v1.Image)
 
@@ -121,38 +146,81 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 	cfg.Architecture = platform.Architecture
 	cfg.Variant = platform.Variant
 	cfg.Created = v1.Time{Time: created}
-	cfg.Config.Labels = make(map[string]string)
 	cfg.OS = "linux"
-	cfg.Config.Labels = annotations
+	// The annotations are applied lazily, so the labels get their own map.
+	cfg.Config.Labels = maps.Clone(annotations)
+	maps.Copy(cfg.Config.Labels, ic.Labels)
+
+	if err := applyImageConfiguration(&cfg.Config, *ic); err != nil {
+		return nil, err
+	}
+
+	img, err := mutate.ConfigFile(v1Image, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to update oci config file: %w", err)
+	}
+
+	return img, nil
+}
 
+// ContainerConfig returns the runtime configuration (entrypoint, command,
+// environment, user and so on) that images built from ic carry.
+func ContainerConfig(ic types.ImageConfiguration) (v1.Config, error) {
+	var c v1.Config
+	if err := applyImageConfiguration(&c, ic); err != nil {
+		return v1.Config{}, err
+	}
+	return c, nil
+}
+
+// annotateRebuildAfter records in annotations when an image created at
+// created should be rebuilt, if ic configures rebuild-after.
+func annotateRebuildAfter(annotations map[string]string, ic types.ImageConfiguration, created time.Time) error {
+	if ic.RebuildAfter == "" {
+		return nil
+	}
+	d, err := types.ParseRebuildAfter(ic.RebuildAfter)
+	if err != nil {
+		return err
+	}
+	annotations[AnnotationRebuildAfter] = created.Add(d).Format(time.RFC3339)
+	return nil
+}
+
+func applyImageConfiguration(c *v1.Config, ic types.ImageConfiguration) error {
 	// NOTE: Need to allow empty Entrypoints. The runtime will override to `/bin/sh -c` and handle quoting
 	switch {
 	case ic.Entrypoint.ShellFragment != "":
-		cfg.Config.Entrypoint = []string{"/bin/sh", "-c", ic.Entrypoint.ShellFragment}
+		c.Entrypoint = []string{"/bin/sh", "-c", ic.Entrypoint.ShellFragment}
 	case ic.Entrypoint.Command != "":
 		splitcmd, err := shlex.Split(ic.Entrypoint.Command)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse entrypoint command: %w", err)
+			return fmt.Errorf("unable to parse entrypoint command: %w", err)
 		}
-		cfg.Config.Entrypoint = splitcmd
+		c.Entrypoint = splitcmd
+	case len(ic.Entrypoint.Exec) != 0:
+		c.Entrypoint = ic.Entrypoint.Exec
 	}
 
-	if ic.Cmd != "" {
+	switch {
+	case ic.Cmd != "":
 		splitcmd, err := shlex.Split(ic.Cmd)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse cmd: %w", err)
+			return fmt.Errorf("unable to parse cmd: %w", err)
 		}
-		cfg.Config.Cmd = splitcmd
+		c.Cmd = splitcmd
+	case len(ic.CmdExec) != 0:
+		c.Cmd = ic.CmdExec
 	}
 
 	if ic.WorkDir != "" {
-		cfg.Config.WorkingDir = ic.WorkDir
+		c.WorkingDir = ic.WorkDir
 	}
 
 	if ic.Volumes != nil {
-		cfg.Config.Volumes = make(map[string]struct{})
+		c.Volumes = make(map[string]struct{})
 		for _, v := range ic.Volumes {
-			cfg.Config.Volumes[v] = struct{}{}
+			c.Volumes[v] = struct{}{}
 		}
 	}
 
@@ -161,10 +229,7 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 	if env == nil {
 		env = map[string]string{}
 	}
-	for k, v := range map[string]string{
-		"PATH":          "/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
-		"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
-	} {
+	for k, v := range DefaultEnvironment {
 		if _, found := env[k]; !found {
 			env[k] = v
 		}
@@ -174,22 +239,17 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
 	}
 	sort.Strings(envs)
-	cfg.Config.Env = envs
+	c.Env = envs
 
 	if ic.Accounts.RunAs != "" {
-		cfg.Config.User = ic.Accounts.RunAs
+		c.User = ic.Accounts.RunAs
 	}
 
 	if ic.StopSignal != "" {
-		cfg.Config.StopSignal = ic.StopSignal
+		c.StopSignal = ic.StopSignal
 	}
 
-	img, err := mutate.ConfigFile(v1Image, cfg)
-	if err != nil {
-		return nil, fmt.Errorf("unable to update oci config file: %w", err)
-	}
-
-	return img, nil
+	return nil
 }
 
 func BuildImageTarballFromLayer(ctx context.Context, imageRef string, layer v1.Layer, outputTarGZ string, ic types.ImageConfiguration, opts options.Options) error {
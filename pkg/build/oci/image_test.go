@@ -155,6 +155,36 @@ func TestBuildImageFromLayer(t *testing.T) {
 				},
 			},
 		},
+	}, {
+		desc: "exec form commands",
+		cfg: types.ImageConfiguration{
+			Entrypoint:  types.ImageEntrypoint{Exec: []string{"/bin/sh", "-c", `echo "$HOME" # not a comment`}},
+			CmdExec:     []string{"it's", ""},
+			Environment: map[string]string{},
+		},
+		want: &v1.ConfigFile{
+			Author: "github.com/chainguard-dev/apko",
+			History: []v1.History{{
+				Created:   v1now,
+				Author:    "apko",
+				CreatedBy: "apko",
+				Comment:   "This is an apko single-layer image",
+			}},
+			Created: v1now,
+			OS:      "linux",
+			RootFS:  v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{diffID}},
+			Config: v1.Config{
+				Entrypoint: []string{"/bin/sh", "-c", `echo "$HOME" # not a comment`},
+				Cmd:        []string{"it's", ""},
+				Env: []string{
+					"PATH=/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+					"SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt",
+				},
+				Labels: map[string]string{
+					"org.opencontainers.image.created": now.Format(time.RFC3339),
+				},
+			},
+		},
 	}} {
 		t.Run(c.desc, func(t *testing.T) {
 			ctx := context.Background()
@@ -169,3 +199,50 @@ func TestBuildImageFromLayer(t *testing.T) {
 		})
 	}
 }
+
+// commentedLayer is a layer that describes itself in the image history.
+type commentedLayer struct {
+	v1.Layer
+	comment string
+}
+
+func (l commentedLayer) Comment() string { return l.comment }
+
+func TestBuildImageFromLayersHistory(t *testing.T) {
+	layers := []v1.Layer{
+		commentedLayer{static.NewLayer([]byte("glibc"), ggcrtypes.OCILayer), "packages: glibc=2.40-r1"},
+		static.NewLayer([]byte("top"), ggcrtypes.OCILayer),
+	}
+	img, err := BuildImageFromLayers(context.Background(), empty.Image, layers, types.ImageConfiguration{}, time.Now(), types.ParseArchitecture(""))
+	require.NoError(t, err)
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Len(t, cfg.History, 2)
+	require.Equal(t, "packages: glibc=2.40-r1", cfg.History[0].Comment)
+	require.Empty(t, cfg.History[1].Comment)
+}
+
+func TestBuildImageFromLayersRebuildAfter(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	layers := []v1.Layer{static.NewLayer([]byte("top"), ggcrtypes.OCILayer)}
+	ic := types.ImageConfiguration{RebuildAfter: "30d"}
+
+	img, err := BuildImageFromLayers(ctx, empty.Image, layers, ic, created, types.ParseArchitecture("amd64"))
+	require.NoError(t, err)
+	manifest, err := img.Manifest()
+	require.NoError(t, err)
+	require.Equal(t, "2025-03-31T12:00:00Z", manifest.Annotations[AnnotationRebuildAfter])
+
+	_, idx, err := GenerateIndex(ctx, ic, map[types.Architecture]v1.Image{types.ParseArchitecture("amd64"): img}, created)
+	require.NoError(t, err)
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Equal(t, "2025-03-31T12:00:00Z", im.Annotations[AnnotationRebuildAfter])
+
+	img, err = BuildImageFromLayers(ctx, empty.Image, layers, types.ImageConfiguration{}, created, types.ParseArchitecture("amd64"))
+	require.NoError(t, err)
+	manifest, err = img.Manifest()
+	require.NoError(t, err)
+	require.NotContains(t, manifest.Annotations, AnnotationRebuildAfter)
+}
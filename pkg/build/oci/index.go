@@ -68,6 +68,9 @@ func generateIndexWithMediaType(mediaType ggcrtypes.MediaType, ic types.ImageCon
 			}
 		}
 		annCopy["org.opencontainers.image.created"] = created.Format(time.RFC3339)
+		if err := annotateRebuildAfter(annCopy, ic, created); err != nil {
+			return name.Digest{}, nil, err
+		}
 	}
 
 	idx := mutate.IndexMediaType(
@@ -0,0 +1,184 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// PublishPreview is what publishing an index would push to a registry.
+type PublishPreview struct {
+	// Index is the index, pushed under each of Tags.
+	Index ManifestPreview `json:"index"`
+	// Tags are the tags the index would be pushed under.
+	Tags []string `json:"tags"`
+	// Images are the images in the index, pushed by digest.
+	Images []ManifestPreview `json:"images"`
+	// Referrers are the SBOM, provenance, signature and other artifacts
+	// that would be attached to the index and its images.
+	Referrers []ManifestPreview `json:"referrers,omitempty"`
+}
+
+// ManifestPreview is a manifest that would be pushed.
+type ManifestPreview struct {
+	// Reference is where the manifest would be pushed, by digest.
+	Reference string `json:"reference"`
+	// Platform is the platform of an image in the index.
+	Platform *v1.Platform `json:"platform,omitempty"`
+	// Subject is the digest of the manifest an artifact refers to.
+	Subject string `json:"subject,omitempty"`
+	// Manifest is the manifest itself, as it would be pushed.
+	Manifest json.RawMessage `json:"manifest"`
+}
+
+// PreviewPublish returns what publishing idx to repo under tags would push,
+// along with sboms, unless provenance is empty the provenance at that path of
+// media type mt, artifacts, if rootFS is set the filesystem of each image and
+// unless it is nil sig, as PublishImagesFromIndex, PublishIndex, AttachSBOMs,
+// AttachProvenance, AttachArtifact, AttachRootFS and AttachSignature would
+// push them with annotations. Nothing is written, except to temporary files
+// created through o.
+func PreviewPublish(o *options.Options, idx v1.ImageIndex, tags []string, sboms []types.SBOM, provenance, mt string, artifacts []Artifact, rootFS bool, sig *Signature, annotations map[string]string, repo name.Repository) (*PublishPreview, error) {
+	index, err := manifestPreview(idx, repo, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("index: %w", err)
+	}
+	p := &PublishPreview{Index: index, Tags: tags}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	for _, m := range manifest.Manifests {
+		img, err := idx.Image(m.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image for %v from index: %w", m, err)
+		}
+		mp, err := manifestPreview(img, repo, m.Platform, "")
+		if err != nil {
+			return nil, fmt.Errorf("image %s: %w", m.Digest, err)
+		}
+		p.Images = append(p.Images, mp)
+	}
+
+	subjects, err := subjectDescriptors(idx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sboms {
+		subject, ok := subjects[s.Digest]
+		if !ok {
+			return nil, fmt.Errorf("%s SBOM %s describes %s, which is not in the index", s.Format, s.Path, s.Digest)
+		}
+		artifact, err := sbomArtifact(s, subject, annotations)
+		if err != nil {
+			return nil, err
+		}
+		mp, err := manifestPreview(artifact, repo, nil, subject.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("%s SBOM: %w", s.Format, err)
+		}
+		p.Referrers = append(p.Referrers, mp)
+	}
+
+	if provenance != "" {
+		artifact, subject, err := indexArtifact(idx, "provenance", provenance, ggcrtypes.MediaType(mt), annotations)
+		if err != nil {
+			return nil, err
+		}
+		mp, err := manifestPreview(artifact, repo, nil, subject.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("provenance: %w", err)
+		}
+		p.Referrers = append(p.Referrers, mp)
+	}
+
+	for _, a := range artifacts {
+		artifact, subject, err := indexArtifact(idx, a.Path, a.Path, a.MediaType, annotations)
+		if err != nil {
+			return nil, err
+		}
+		mp, err := manifestPreview(artifact, repo, nil, subject.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Path, err)
+		}
+		p.Referrers = append(p.Referrers, mp)
+	}
+
+	if sig != nil {
+		artifact, subject, err := signatureArtifact(idx, *sig, annotations)
+		if err != nil {
+			return nil, err
+		}
+		mp, err := manifestPreview(artifact, repo, nil, subject.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("signature: %w", err)
+		}
+		p.Referrers = append(p.Referrers, mp)
+	}
+
+	if rootFS {
+		for _, m := range manifest.Manifests {
+			mp, err := rootFSPreview(o, idx, m, annotations, repo)
+			if err != nil {
+				return nil, fmt.Errorf("rootfs of %s: %w", m.Digest, err)
+			}
+			p.Referrers = append(p.Referrers, mp)
+		}
+	}
+
+	return p, nil
+}
+
+// rootFSPreview describes the rootfs artifact of the image m of idx.
+func rootFSPreview(o *options.Options, idx v1.ImageIndex, m v1.Descriptor, annotations map[string]string, repo name.Repository) (ManifestPreview, error) {
+	img, err := idx.Image(m.Digest)
+	if err != nil {
+		return ManifestPreview{}, err
+	}
+	artifact, cleanup, err := rootFSArtifact(o, img, v1.Descriptor{MediaType: m.MediaType, Size: m.Size, Digest: m.Digest}, annotations)
+	if err != nil {
+		return ManifestPreview{}, err
+	}
+	defer cleanup()
+	return manifestPreview(artifact, repo, nil, m.Digest.String())
+}
+
+// manifestPreview describes the manifest of m, as it would be pushed to repo.
+func manifestPreview(m partial.WithRawManifest, repo name.Repository, platform *v1.Platform, subject string) (ManifestPreview, error) {
+	raw, err := m.RawManifest()
+	if err != nil {
+		return ManifestPreview{}, err
+	}
+	h, err := partial.Digest(m)
+	if err != nil {
+		return ManifestPreview{}, err
+	}
+	return ManifestPreview{
+		Reference: repo.Digest(h.String()).String(),
+		Platform:  platform,
+		Subject:   subject,
+		Manifest:  raw,
+	}, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestPreviewPublish(t *testing.T) {
+	ctx := context.Background()
+	s := newRegistry(t, true)
+
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/test")
+	require.NoError(t, err)
+	tag := repo.Tag("latest").String()
+
+	idx, err := random.Index(256, 1, 2)
+	require.NoError(t, err)
+	manifest, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	sbomPath := filepath.Join(dir, "sbom-x86_64.spdx.json")
+	require.NoError(t, os.WriteFile(sbomPath, []byte(`{"sbom":"x86_64"}`), 0o644))
+	sboms := []types.SBOM{{Path: sbomPath, Format: "spdx", Digest: manifest.Manifests[0].Digest}}
+	provenance := filepath.Join(dir, "provenance.json")
+	require.NoError(t, os.WriteFile(provenance, []byte(`{"_type":"https://in-toto.io/Statement/v1"}`), 0o644))
+	const mt = "application/vnd.in-toto+json"
+	report := filepath.Join(dir, "report.json")
+	require.NoError(t, os.WriteFile(report, []byte(`{"archs":[]}`), 0o644))
+	artifacts := []Artifact{{Path: report, MediaType: BuildReportArtifactType}}
+	annotations := map[string]string{"com.example.team": "platform"}
+	payload, err := SignaturePayload(idx, repo)
+	require.NoError(t, err)
+	sig := Signature{Payload: payload, Signature: []byte("signature")}
+
+	p, err := PreviewPublish(&options.Options{}, idx, []string{tag}, sboms, provenance, mt, artifacts, true, &sig, annotations, repo)
+	require.NoError(t, err)
+
+	// Nothing was pushed.
+	_, err = remote.Head(repo.Tag("latest"))
+	require.Error(t, err)
+
+	// What was previewed is what publishing pushes.
+	imgs, err := PublishImagesFromIndex(ctx, idx, repo)
+	require.NoError(t, err)
+	require.Len(t, p.Images, len(imgs))
+	for i, img := range imgs {
+		require.Equal(t, img.String(), p.Images[i].Reference)
+		require.Equal(t, manifest.Manifests[i].Platform, p.Images[i].Platform)
+		desc, err := remote.Get(img)
+		require.NoError(t, err)
+		require.JSONEq(t, string(desc.Manifest), string(p.Images[i].Manifest))
+	}
+
+	dig, err := PublishIndex(ctx, idx, []string{tag})
+	require.NoError(t, err)
+	require.Equal(t, dig.String(), p.Index.Reference)
+	require.Equal(t, []string{tag}, p.Tags)
+
+	sbomDigests, err := AttachSBOMs(ctx, idx, sboms, annotations, repo)
+	require.NoError(t, err)
+	provDigest, err := AttachProvenance(ctx, idx, provenance, mt, annotations, repo)
+	require.NoError(t, err)
+	reportDigest, err := AttachArtifact(ctx, idx, report, BuildReportArtifactType, annotations, repo)
+	require.NoError(t, err)
+	sigDigest, err := AttachSignature(ctx, idx, sig, annotations, repo)
+	require.NoError(t, err)
+	rootFSDigests, err := AttachRootFS(ctx, &options.Options{}, idx, annotations, repo)
+	require.NoError(t, err)
+
+	require.Len(t, p.Referrers, 6)
+	require.Equal(t, sbomDigests[0].String(), p.Referrers[0].Reference)
+	require.Equal(t, manifest.Manifests[0].Digest.String(), p.Referrers[0].Subject)
+	require.Equal(t, provDigest.String(), p.Referrers[1].Reference)
+	require.Equal(t, dig.DigestStr(), p.Referrers[1].Subject)
+	require.Equal(t, reportDigest.String(), p.Referrers[2].Reference)
+	require.Equal(t, dig.DigestStr(), p.Referrers[2].Subject)
+	require.Equal(t, sigDigest.String(), p.Referrers[3].Reference)
+	require.Equal(t, dig.DigestStr(), p.Referrers[3].Subject)
+	for i, m := range manifest.Manifests {
+		require.Equal(t, rootFSDigests[i].String(), p.Referrers[4+i].Reference)
+		require.Equal(t, m.Digest.String(), p.Referrers[4+i].Subject)
+	}
+	for _, r := range p.Referrers {
+		var m v1.Manifest
+		require.NoError(t, json.Unmarshal(r.Manifest, &m))
+		require.Equal(t, annotations, m.Annotations)
+	}
+}
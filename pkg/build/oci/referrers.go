@@ -0,0 +1,417 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// SBOMArtifactTypes are the artifact types of the SBOMs AttachSBOMs pushes,
+// by format.
+var SBOMArtifactTypes = map[string]ggcrtypes.MediaType{
+	"spdx":      "application/spdx+json",
+	"cyclonedx": "application/vnd.cyclonedx+json",
+}
+
+// AttachSBOMs pushes each of sboms to repo as an OCI artifact whose subject
+// is the image in idx, or idx itself, that the SBOM describes, so that it is
+// listed by the referrers API for that digest. On registries without the
+// referrers API, the referrers fallback tag of the subject is updated instead.
+// The artifact manifests carry annotations, which may be nil. It returns the
+// digests of the artifacts.
+func AttachSBOMs(ctx context.Context, idx v1.ImageIndex, sboms []types.SBOM, annotations map[string]string, repo name.Repository, remoteOpts ...remote.Option) ([]name.Digest, error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "AttachSBOMs")
+	defer span.End()
+
+	subjects, err := subjectDescriptors(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make([]name.Digest, 0, len(sboms))
+	for _, s := range sboms {
+		subject, ok := subjects[s.Digest]
+		if !ok {
+			return nil, fmt.Errorf("%s SBOM %s describes %s, which is not in the index", s.Format, s.Path, s.Digest)
+		}
+		artifact, err := sbomArtifact(s, subject, annotations)
+		if err != nil {
+			return nil, err
+		}
+		h, err := artifact.Digest()
+		if err != nil {
+			return nil, err
+		}
+		dig := repo.Digest(h.String())
+		log.Infof("attaching %s SBOM to %s as %s", s.Format, subject.Digest, dig)
+		if err := writeReferrer(ctx, dig, artifact, SBOMArtifactTypes[s.Format], subject, remoteOpts...); err != nil {
+			return nil, fmt.Errorf("attaching %s SBOM to %s: %w", s.Format, subject.Digest, err)
+		}
+		digests = append(digests, dig)
+	}
+	return digests, nil
+}
+
+// SignatureArtifactType is the artifact type of the signatures
+// AttachSignature pushes, that of cosign signatures stored as referrers.
+const SignatureArtifactType ggcrtypes.MediaType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+// simpleSigningMediaType is the media type of the cosign payload a signature
+// signs.
+const simpleSigningMediaType ggcrtypes.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+// cosignSignatureAnnotation holds the signature of the payload layer.
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// Signature is a signature of the Payload SignaturePayload returns for an
+// index.
+type Signature struct {
+	Payload   []byte
+	Signature []byte
+}
+
+// SignaturePayload returns the cosign simple signing payload that a
+// signature of idx, pushed to repo, signs.
+func SignaturePayload(idx v1.ImageIndex, repo name.Repository) ([]byte, error) {
+	h, err := idx.Digest()
+	if err != nil {
+		return nil, err
+	}
+	var p struct {
+		Critical struct {
+			Identity struct {
+				DockerReference string `json:"docker-reference"`
+			} `json:"identity"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+		Optional map[string]any `json:"optional"`
+	}
+	p.Critical.Identity.DockerReference = repo.Name()
+	p.Critical.Image.DockerManifestDigest = h.String()
+	p.Critical.Type = "cosign container image signature"
+	return json.Marshal(p)
+}
+
+// AttachSignature pushes sig to repo as a cosign signature of idx: an
+// artifact whose subject is idx, with annotations, as AttachSBOMs does for
+// SBOMs, so that cosign verify finds it through the referrers API. It
+// returns the digest of the artifact.
+func AttachSignature(ctx context.Context, idx v1.ImageIndex, sig Signature, annotations map[string]string, repo name.Repository, remoteOpts ...remote.Option) (name.Digest, error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "AttachSignature")
+	defer span.End()
+
+	artifact, subject, err := signatureArtifact(idx, sig, annotations)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	ah, err := artifact.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	dig := repo.Digest(ah.String())
+	log.Infof("attaching signature to %s as %s", subject.Digest, dig)
+	if err := writeReferrer(ctx, dig, artifact, SignatureArtifactType, subject, remoteOpts...); err != nil {
+		return name.Digest{}, fmt.Errorf("attaching signature to %s: %w", subject.Digest, err)
+	}
+	return dig, nil
+}
+
+// signatureArtifact returns the artifact manifest of sig, with annotations,
+// along with its subject, idx.
+func signatureArtifact(idx v1.ImageIndex, sig Signature, annotations map[string]string) (v1.Image, v1.Descriptor, error) {
+	subjects, err := subjectDescriptors(idx)
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	h, err := idx.Digest()
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	subject := subjects[h]
+	l := artifactLayer{
+		Layer:       static.NewLayer(sig.Payload, simpleSigningMediaType),
+		annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig.Signature)},
+	}
+	artifact, err := newArtifact(SignatureArtifactType, []artifactLayer{l}, subject, annotations)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("signature: %w", err)
+	}
+	return artifact, subject, nil
+}
+
+// subjectDescriptors returns the descriptors of idx and of the manifests in
+// it, by digest.
+func subjectDescriptors(idx v1.ImageIndex) (map[v1.Hash]v1.Descriptor, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	h, err := idx.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := idx.Size()
+	if err != nil {
+		return nil, err
+	}
+	mt, err := idx.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	subjects := map[v1.Hash]v1.Descriptor{h: {MediaType: mt, Size: size, Digest: h}}
+	for _, m := range manifest.Manifests {
+		subjects[m.Digest] = v1.Descriptor{MediaType: m.MediaType, Size: m.Size, Digest: m.Digest}
+	}
+	return subjects, nil
+}
+
+// AttachProvenance pushes the provenance at path, of media type mt, to repo as
+// an OCI artifact whose subject is idx, with annotations, as AttachSBOMs does
+// for SBOMs. It returns the digest of the artifact.
+func AttachProvenance(ctx context.Context, idx v1.ImageIndex, path, mt string, annotations map[string]string, repo name.Repository, remoteOpts ...remote.Option) (name.Digest, error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "AttachProvenance")
+	defer span.End()
+
+	artifact, subject, err := indexArtifact(idx, "provenance", path, ggcrtypes.MediaType(mt), annotations)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	ah, err := artifact.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	dig := repo.Digest(ah.String())
+	log.Infof("attaching provenance to %s as %s", subject.Digest, dig)
+	if err := writeReferrer(ctx, dig, artifact, ggcrtypes.MediaType(mt), subject, remoteOpts...); err != nil {
+		return name.Digest{}, fmt.Errorf("attaching provenance to %s: %w", subject.Digest, err)
+	}
+	return dig, nil
+}
+
+// indexArtifact returns an artifact manifest holding the file at path, of
+// media type mt, with annotations, along with its subject, idx. what names
+// the file in errors.
+func indexArtifact(idx v1.ImageIndex, what, path string, mt ggcrtypes.MediaType, annotations map[string]string) (v1.Image, v1.Descriptor, error) {
+	subjects, err := subjectDescriptors(idx)
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	h, err := idx.Digest()
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	subject := subjects[h]
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("reading %s: %w", what, err)
+	}
+	artifact, err := referrerArtifact(b, mt, subject, annotations)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("%s: %w", what, err)
+	}
+	return artifact, subject, nil
+}
+
+// sbomArtifact returns an artifact manifest holding the SBOM s, with subject
+// as its subject and annotations.
+func sbomArtifact(s types.SBOM, subject v1.Descriptor, annotations map[string]string) (v1.Image, error) {
+	mt, ok := SBOMArtifactTypes[s.Format]
+	if !ok {
+		return nil, fmt.Errorf("no artifact type for %s SBOMs", s.Format)
+	}
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading SBOM: %w", err)
+	}
+	img, err := referrerArtifact(b, mt, subject, annotations)
+	if err != nil {
+		return nil, fmt.Errorf("%s SBOM: %w", s.Format, err)
+	}
+	return img, nil
+}
+
+// referrerArtifact returns an artifact manifest holding b, of media type mt,
+// with subject as its subject and annotations, if any.
+func referrerArtifact(b []byte, mt ggcrtypes.MediaType, subject v1.Descriptor, annotations map[string]string) (v1.Image, error) {
+	return layerArtifact(static.NewLayer(b, mt), mt, subject, annotations)
+}
+
+// layerArtifact is like referrerArtifact, for contents already in a layer.
+func layerArtifact(l v1.Layer, mt ggcrtypes.MediaType, subject v1.Descriptor, annotations map[string]string) (v1.Image, error) {
+	return newArtifact(mt, []artifactLayer{{Layer: l}}, subject, annotations)
+}
+
+// EmptyConfigMediaType is the media type of the OCI empty descriptor, the
+// config of the artifacts apko attaches, whose type is given by the
+// artifactType of their manifest instead.
+const EmptyConfigMediaType ggcrtypes.MediaType = "application/vnd.oci.empty.v1+json"
+
+// emptyConfig is the content of the OCI empty descriptor.
+var emptyConfig = []byte("{}")
+
+// artifactLayer is a layer of an artifact, with the annotations of its
+// descriptor.
+type artifactLayer struct {
+	v1.Layer
+	annotations map[string]string
+}
+
+// artifact is an OCI 1.1 artifact manifest: layers with artifactType set and
+// the empty descriptor as config.
+type artifact struct {
+	raw    []byte
+	layers map[v1.Hash]v1.Layer
+}
+
+var _ partial.CompressedImageCore = (*artifact)(nil)
+
+// artifactManifest is an image manifest with the artifactType field, which
+// v1.Manifest lacks.
+type artifactManifest struct {
+	SchemaVersion int64               `json:"schemaVersion"`
+	MediaType     ggcrtypes.MediaType `json:"mediaType"`
+	ArtifactType  string              `json:"artifactType"`
+	Config        v1.Descriptor       `json:"config"`
+	Layers        []v1.Descriptor     `json:"layers"`
+	Subject       *v1.Descriptor      `json:"subject,omitempty"`
+	Annotations   map[string]string   `json:"annotations,omitempty"`
+}
+
+// newArtifact returns an artifact of type mt holding layers, with subject as
+// its subject and annotations, if any.
+func newArtifact(mt ggcrtypes.MediaType, layers []artifactLayer, subject v1.Descriptor, annotations map[string]string) (v1.Image, error) {
+	cfg, size, err := v1.SHA256(bytes.NewReader(emptyConfig))
+	if err != nil {
+		return nil, err
+	}
+	m := artifactManifest{
+		SchemaVersion: 2,
+		MediaType:     ggcrtypes.OCIManifestSchema1,
+		ArtifactType:  string(mt),
+		Config:        v1.Descriptor{MediaType: EmptyConfigMediaType, Size: size, Digest: cfg},
+		Subject:       &subject,
+		Annotations:   annotations,
+	}
+	a := &artifact{layers: map[v1.Hash]v1.Layer{}}
+	for _, l := range layers {
+		desc, err := partial.Descriptor(l.Layer)
+		if err != nil {
+			return nil, err
+		}
+		desc.Annotations = l.annotations
+		m.Layers = append(m.Layers, *desc)
+		a.layers[desc.Digest] = l.Layer
+	}
+	if a.raw, err = json.Marshal(m); err != nil {
+		return nil, err
+	}
+	return partial.CompressedToImage(a)
+}
+
+func (a *artifact) RawConfigFile() ([]byte, error) { return emptyConfig, nil }
+
+func (a *artifact) MediaType() (ggcrtypes.MediaType, error) {
+	return ggcrtypes.OCIManifestSchema1, nil
+}
+
+func (a *artifact) RawManifest() ([]byte, error) { return a.raw, nil }
+
+func (a *artifact) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	l, ok := a.layers[h]
+	if !ok {
+		return nil, fmt.Errorf("artifact has no layer %s", h)
+	}
+	return l, nil
+}
+
+// writeReferrer pushes artifact, of artifact type mt, to dig. On registries
+// without the referrers API, go-containerregistry lists it under the fallback
+// tag of subject with the media type of its config as its artifact type,
+// which for an artifact is that of the empty descriptor, so the entry is
+// corrected to mt.
+func writeReferrer(ctx context.Context, dig name.Digest, artifact v1.Image, mt ggcrtypes.MediaType, subject v1.Descriptor, remoteOpts ...remote.Option) error {
+	remoteOpts = append(remoteOpts, remote.WithContext(ctx))
+	if err := remote.Write(dig, artifact, remoteOpts...); err != nil {
+		return err
+	}
+
+	tag := dig.Context().Tag(strings.Replace(subject.Digest.String(), ":", "-", 1))
+	desc, err := remote.Get(tag, remoteOpts...)
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		// The registry has the referrers API.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading referrers fallback tag %s: %w", tag, err)
+	}
+	if desc.MediaType != ggcrtypes.OCIImageIndex {
+		return nil
+	}
+	var im v1.IndexManifest
+	if err := json.Unmarshal(desc.Manifest, &im); err != nil {
+		return fmt.Errorf("parsing referrers fallback tag %s: %w", tag, err)
+	}
+	h, err := artifact.Digest()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(im.Manifests, func(d v1.Descriptor) bool { return d.Digest == h })
+	if i < 0 || im.Manifests[i].ArtifactType == string(mt) {
+		return nil
+	}
+	im.Manifests[i].ArtifactType = string(mt)
+	raw, err := json.Marshal(im)
+	if err != nil {
+		return err
+	}
+	if err := remote.Put(tag, rawIndex(raw), remoteOpts...); err != nil {
+		return fmt.Errorf("updating referrers fallback tag %s: %w", tag, err)
+	}
+	return nil
+}
+
+// rawIndex is a serialized OCI image index, pushed as is.
+type rawIndex []byte
+
+func (r rawIndex) RawManifest() ([]byte, error) { return r, nil }
+
+func (r rawIndex) MediaType() (ggcrtypes.MediaType, error) { return ggcrtypes.OCIImageIndex, nil }
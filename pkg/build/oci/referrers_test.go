@@ -0,0 +1,236 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestAttachSBOMs(t *testing.T) {
+	for _, referrers := range []bool{true, false} {
+		t.Run(map[bool]string{true: "referrers API", false: "fallback tag"}[referrers], func(t *testing.T) {
+			ctx := context.Background()
+			s := newRegistry(t, referrers)
+
+			repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/test")
+			require.NoError(t, err)
+
+			idx, err := random.Index(256, 1, 2)
+			require.NoError(t, err)
+			manifest, err := idx.IndexManifest()
+			require.NoError(t, err)
+			indexDigest, err := idx.Digest()
+			require.NoError(t, err)
+
+			dir := t.TempDir()
+			sbom := func(file, format string, digest v1.Hash) types.SBOM {
+				p := filepath.Join(dir, file)
+				require.NoError(t, os.WriteFile(p, []byte(`{"sbom":"`+file+`"}`), 0o644))
+				return types.SBOM{Path: p, Format: format, Digest: digest}
+			}
+			sboms := []types.SBOM{
+				sbom("sbom-x86_64.spdx.json", "spdx", manifest.Manifests[0].Digest),
+				sbom("sbom-aarch64.cdx.json", "cyclonedx", manifest.Manifests[1].Digest),
+				sbom("sbom-index.spdx.json", "spdx", indexDigest),
+			}
+
+			digests, err := AttachSBOMs(ctx, idx, sboms, nil, repo)
+			require.NoError(t, err)
+			require.Len(t, digests, 3)
+
+			for i, sbom := range sboms {
+				referrers, err := remote.Referrers(repo.Digest(sbom.Digest.String()))
+				require.NoError(t, err)
+				rm, err := referrers.IndexManifest()
+				require.NoError(t, err)
+				require.Len(t, rm.Manifests, 1)
+				require.Equal(t, digests[i].DigestStr(), rm.Manifests[0].Digest.String())
+				require.Equal(t, string(SBOMArtifactTypes[sbom.Format]), rm.Manifests[0].ArtifactType)
+				requireArtifact(t, digests[i], SBOMArtifactTypes[sbom.Format])
+
+				artifact, err := remote.Image(digests[i])
+				require.NoError(t, err)
+				layers, err := artifact.Layers()
+				require.NoError(t, err)
+				require.Len(t, layers, 1)
+				rc, err := layers[0].Uncompressed()
+				require.NoError(t, err)
+				got, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				rc.Close()
+				want, err := os.ReadFile(sbom.Path)
+				require.NoError(t, err)
+				require.Equal(t, want, got)
+			}
+		})
+	}
+}
+
+// requireArtifact checks that the manifest at dig is an OCI 1.1 artifact of
+// type mt, with the empty descriptor as its config.
+func requireArtifact(t *testing.T, dig name.Digest, mt ggcrtypes.MediaType) {
+	desc, err := remote.Get(dig)
+	require.NoError(t, err)
+	var m struct {
+		ArtifactType string        `json:"artifactType"`
+		Config       v1.Descriptor `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal(desc.Manifest, &m))
+	require.Equal(t, string(mt), m.ArtifactType)
+	require.Equal(t, EmptyConfigMediaType, m.Config.MediaType)
+	require.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", m.Config.Digest.String())
+
+	img, err := remote.Image(dig)
+	require.NoError(t, err)
+	cfg, err := img.RawConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(cfg))
+}
+
+func TestAttachSignature(t *testing.T) {
+	for _, referrers := range []bool{true, false} {
+		t.Run(map[bool]string{true: "referrers API", false: "fallback tag"}[referrers], func(t *testing.T) {
+			s := newRegistry(t, referrers)
+			repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/test")
+			require.NoError(t, err)
+			idx, err := random.Index(256, 1, 1)
+			require.NoError(t, err)
+			h, err := idx.Digest()
+			require.NoError(t, err)
+
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			require.NoError(t, err)
+			payload, err := SignaturePayload(idx, repo)
+			require.NoError(t, err)
+			sum := sha256.Sum256(payload)
+			raw, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+			require.NoError(t, err)
+
+			dig, err := AttachSignature(t.Context(), idx, Signature{Payload: payload, Signature: raw}, nil, repo)
+			require.NoError(t, err)
+			referrers, err := remote.Referrers(repo.Digest(h.String()))
+			require.NoError(t, err)
+			rm, err := referrers.IndexManifest()
+			require.NoError(t, err)
+			require.Len(t, rm.Manifests, 1)
+			require.Equal(t, dig.DigestStr(), rm.Manifests[0].Digest.String())
+			require.Equal(t, string(SignatureArtifactType), rm.Manifests[0].ArtifactType)
+			requireArtifact(t, dig, SignatureArtifactType)
+
+			// The payload names the index and carries its signature, as cosign
+			// verify expects.
+			artifact, err := remote.Image(dig)
+			require.NoError(t, err)
+			m, err := artifact.Manifest()
+			require.NoError(t, err)
+			require.Len(t, m.Layers, 1)
+			require.Equal(t, simpleSigningMediaType, m.Layers[0].MediaType)
+			got, err := base64.StdEncoding.DecodeString(m.Layers[0].Annotations[cosignSignatureAnnotation])
+			require.NoError(t, err)
+			l, err := artifact.LayerByDigest(m.Layers[0].Digest)
+			require.NoError(t, err)
+			rc, err := l.Uncompressed()
+			require.NoError(t, err)
+			signed, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			rc.Close()
+			signedSum := sha256.Sum256(signed)
+			require.True(t, ecdsa.VerifyASN1(&key.PublicKey, signedSum[:], got))
+			var ss struct {
+				Critical struct {
+					Identity struct {
+						DockerReference string `json:"docker-reference"`
+					} `json:"identity"`
+					Image struct {
+						DockerManifestDigest string `json:"docker-manifest-digest"`
+					} `json:"image"`
+				} `json:"critical"`
+			}
+			require.NoError(t, json.Unmarshal(signed, &ss))
+			require.Equal(t, repo.Name(), ss.Critical.Identity.DockerReference)
+			require.Equal(t, h.String(), ss.Critical.Image.DockerManifestDigest)
+		})
+	}
+}
+
+// newRegistry starts a registry, with the referrers API if referrers is set.
+// The referrers API of go-containerregistry's registry reports the config
+// media type of each referrer as its artifact type, so it is corrected to the
+// artifactType of the manifest, as the distribution spec has it.
+func newRegistry(t *testing.T, referrers bool) *httptest.Server {
+	h := registry.New(registry.WithReferrersSupport(referrers))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, _, ok := strings.Cut(r.URL.Path, "/referrers/")
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		var im v1.IndexManifest
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &im) != nil {
+			w.WriteHeader(rec.Code)
+			_, _ = w.Write(rec.Body.Bytes())
+			return
+		}
+		for i, d := range im.Manifests {
+			mrec := httptest.NewRecorder()
+			h.ServeHTTP(mrec, httptest.NewRequest(http.MethodGet, repo+"/manifests/"+d.Digest.String(), nil))
+			var m struct {
+				ArtifactType string `json:"artifactType"`
+			}
+			if json.Unmarshal(mrec.Body.Bytes(), &m) == nil && m.ArtifactType != "" {
+				im.Manifests[i].ArtifactType = m.ArtifactType
+			}
+		}
+		w.Header().Set("Content-Type", string(ggcrtypes.OCIImageIndex))
+		_ = json.NewEncoder(w).Encode(im)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAttachSBOMsUnknownSubject(t *testing.T) {
+	idx, err := random.Index(256, 1, 1)
+	require.NoError(t, err)
+	repo, err := name.NewRepository("example.com/test")
+	require.NoError(t, err)
+
+	_, err = AttachSBOMs(context.Background(), idx, []types.SBOM{{Path: "sbom.spdx.json", Format: "spdx", Digest: v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}}}, nil, repo)
+	require.ErrorContains(t, err, "which is not in the index")
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/errgroup"
+)
+
+// BlobReuse counts the blobs of an image, its layers and config, that were
+// already in the repository it is published to, and those that have to be
+// uploaded. How much is reused measures how well the layering of successive
+// images lines up.
+type BlobReuse struct {
+	// Image is the digest of the image.
+	Image         string `json:"image"`
+	Platform      string `json:"platform,omitempty"`
+	ReusedBlobs   int    `json:"reusedBlobs"`
+	ReusedBytes   int64  `json:"reusedBytes"`
+	UploadedBlobs int    `json:"uploadedBlobs"`
+	UploadedBytes int64  `json:"uploadedBytes"`
+}
+
+// blobChecks is how many blobs MeasureBlobReuse checks at once.
+const blobChecks = 8
+
+// MeasureBlobReuse checks which blobs of each image in idx already exist in
+// repo, before the images are published there. A blob shared by several
+// images counts for each of them.
+func MeasureBlobReuse(ctx context.Context, idx v1.ImageIndex, repo name.Repository, remoteOpts ...remote.Option) ([]BlobReuse, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "MeasureBlobReuse")
+	defer span.End()
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+
+	type blob struct {
+		digest v1.Hash
+		size   int64
+	}
+	blobs := make([][]blob, len(manifest.Manifests))
+	for i, m := range manifest.Manifests {
+		img, err := idx.Image(m.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image for %v from index: %w", m, err)
+		}
+		mf, err := img.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manifest of %v: %w", m.Digest, err)
+		}
+		blobs[i] = append(blobs[i], blob{mf.Config.Digest, mf.Config.Size})
+		for _, l := range mf.Layers {
+			blobs[i] = append(blobs[i], blob{l.Digest, l.Size})
+		}
+	}
+
+	puller, err := remote.NewPuller(remoteOpts...)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu     sync.Mutex
+		exists = map[v1.Hash]bool{}
+		g      errgroup.Group
+	)
+	g.SetLimit(blobChecks)
+	for _, bs := range blobs {
+		for _, b := range bs {
+			mu.Lock()
+			_, seen := exists[b.digest]
+			exists[b.digest] = false
+			mu.Unlock()
+			if seen {
+				continue
+			}
+			g.Go(func() error {
+				l, err := puller.Layer(ctx, repo.Digest(b.digest.String()))
+				if err != nil {
+					return err
+				}
+				ok, err := partial.Exists(l)
+				if err != nil {
+					return fmt.Errorf("checking for blob %s: %w", b.digest, err)
+				}
+				mu.Lock()
+				exists[b.digest] = ok
+				mu.Unlock()
+				return nil
+			})
+		}
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	reuse := make([]BlobReuse, len(manifest.Manifests))
+	for i, m := range manifest.Manifests {
+		reuse[i].Image = m.Digest.String()
+		if m.Platform != nil {
+			reuse[i].Platform = m.Platform.String()
+		}
+		for _, b := range blobs[i] {
+			if exists[b.digest] {
+				reuse[i].ReusedBlobs++
+				reuse[i].ReusedBytes += b.size
+			} else {
+				reuse[i].UploadedBlobs++
+				reuse[i].UploadedBytes += b.size
+			}
+		}
+	}
+	return reuse, nil
+}
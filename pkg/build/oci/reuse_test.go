@@ -0,0 +1,69 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasureBlobReuse(t *testing.T) {
+	ctx := context.Background()
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/test")
+	require.NoError(t, err)
+
+	idx, err := random.Index(256, 2, 2)
+	require.NoError(t, err)
+	manifest, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	// Publish the first image ahead of the index.
+	published, err := idx.Image(manifest.Manifests[0].Digest)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(repo.Digest(manifest.Manifests[0].Digest.String()), published))
+
+	reuse, err := MeasureBlobReuse(ctx, idx, repo)
+	require.NoError(t, err)
+	require.Len(t, reuse, 2)
+
+	for i, r := range reuse {
+		img, err := idx.Image(manifest.Manifests[i].Digest)
+		require.NoError(t, err)
+		mf, err := img.Manifest()
+		require.NoError(t, err)
+		size := mf.Config.Size
+		for _, l := range mf.Layers {
+			size += l.Size
+		}
+
+		require.Equal(t, manifest.Manifests[i].Digest.String(), r.Image)
+		if i == 0 {
+			require.Equal(t, BlobReuse{Image: r.Image, Platform: r.Platform, ReusedBlobs: 3, ReusedBytes: size}, r)
+		} else {
+			require.Equal(t, BlobReuse{Image: r.Image, Platform: r.Platform, UploadedBlobs: 3, UploadedBytes: size}, r)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"math"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// limitedTransport caps the number of in-flight requests and the rate at which
+// new requests are started against a registry.
+type limitedTransport struct {
+	inner   http.RoundTripper
+	limiter *rate.Limiter
+	sem     chan struct{}
+}
+
+// NewLimitedTransport wraps inner so that at most maxInFlight requests are
+// outstanding at once and at most requestsPerSecond requests are started per
+// second. A zero value disables the corresponding limit.
+//
+// Publishing pushes every architecture's blobs concurrently, so this is the
+// only place that sees the total load we put on a registry.
+func NewLimitedTransport(inner http.RoundTripper, maxInFlight int, requestsPerSecond float64) http.RoundTripper {
+	if maxInFlight <= 0 && requestsPerSecond <= 0 {
+		return inner
+	}
+
+	t := &limitedTransport{inner: inner}
+	if maxInFlight > 0 {
+		t.sem = make(chan struct{}, maxInFlight)
+	}
+	if requestsPerSecond > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), max(1, int(math.Ceil(requestsPerSecond))))
+	}
+	return t
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if t.sem != nil {
+		select {
+		case t.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-t.sem }()
+	}
+
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.inner.RoundTrip(req)
+}
@@ -0,0 +1,339 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	purl "github.com/package-url/packageurl-go"
+)
+
+// maxMetadataSize bounds the JSON documents read into memory while verifying.
+const maxMetadataSize = 16 << 20
+
+// TarballSummary describes a tarball that passed VerifyTarball.
+type TarballSummary struct {
+	// IndexDigest is the digest of the image index.
+	IndexDigest v1.Hash
+	// Images is the number of images in the index.
+	Images int
+	// Layers is the number of distinct layers across the images.
+	Layers int
+	// SBOMs is the number of SBOMs whose references were checked.
+	SBOMs int
+}
+
+// tarEntry is what VerifyTarball records of each file in the tarball.
+type tarEntry struct {
+	digest v1.Hash
+	size   int64
+	// diffID is the digest of the uncompressed contents of gzipped blobs.
+	diffID *v1.Hash
+	// contents is kept for the small JSON documents.
+	contents []byte
+}
+
+// VerifyTarball checks the integrity of a tarball written by BuildIndex: that
+// every blob matches the digest it is named by, that the index, manifests,
+// configs and layers it references are all present with the sizes and
+// digests recorded for them, and that each layer uncompresses to the diffID in
+// its image's config.
+//
+// The SPDX SBOMs in sbomDir, if it is set, must only reference images and
+// layers that are in the tarball.
+func VerifyTarball(path, sbomDir string) (*TarballSummary, error) {
+	entries, err := readTarball(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for name, e := range entries {
+		want := ""
+		switch {
+		case strings.HasPrefix(name, "sha256:"):
+			want = name
+		case strings.HasSuffix(name, ".tar.gz"):
+			want = "sha256:" + strings.TrimSuffix(name, ".tar.gz")
+		}
+		if want != "" && e.digest.String() != want {
+			errs = append(errs, fmt.Errorf("%s: content has digest %s", name, e.digest))
+		}
+	}
+
+	index, ok := entries["index.json"]
+	if !ok {
+		return nil, errors.Join(append(errs, errors.New("index.json is missing"))...)
+	}
+	im, err := v1.ParseIndexManifest(bytes.NewReader(index.contents))
+	if err != nil {
+		return nil, errors.Join(append(errs, fmt.Errorf("parsing index.json: %w", err))...)
+	}
+	if len(im.Manifests) == 0 {
+		errs = append(errs, errors.New("index.json lists no images"))
+	}
+
+	summary := &TarballSummary{IndexDigest: index.digest, Images: len(im.Manifests)}
+	known := map[v1.Hash]bool{index.digest: true}
+	layers := map[v1.Hash]bool{}
+	for _, desc := range im.Manifests {
+		known[desc.Digest] = true
+		errs = append(errs, verifyImage(entries, desc, known, layers)...)
+	}
+	summary.Layers = len(layers)
+
+	errs = append(errs, verifyDockerManifest(entries)...)
+
+	if sbomDir != "" {
+		n, sbomErrs := verifySBOMReferences(sbomDir, known)
+		summary.SBOMs = n
+		errs = append(errs, sbomErrs...)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// readTarball digests every file in the tarball in a single pass.
+func readTarball(path string) (map[string]*tarEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := map[string]*tarEntry{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		e, err := readEntry(hdr, tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from %s: %w", hdr.Name, path, err)
+		}
+		entries[hdr.Name] = e
+	}
+	return entries, nil
+}
+
+func readEntry(hdr *tar.Header, r io.Reader) (*tarEntry, error) {
+	h := sha256.New()
+	e := &tarEntry{}
+	r = io.TeeReader(r, h)
+
+	switch {
+	case strings.HasSuffix(hdr.Name, ".tar.gz"):
+		// Layers are named .tar.gz whatever they are compressed with, so
+		// the decompressor is chosen by the magic bytes of the blob.
+		zr, err := decompress(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		uh := sha256.New()
+		if _, err := io.Copy(uh, zr); err != nil {
+			return nil, fmt.Errorf("decompressing: %w", err)
+		}
+		e.diffID = &v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(uh.Sum(nil))}
+	case hdr.Size <= maxMetadataSize:
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		e.contents = b
+	}
+	// Drain whatever the decompressor did not read, e.g. trailing padding.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	e.size = hdr.Size
+	e.digest = v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))}
+	return e, nil
+}
+
+// decompress returns the contents of the gzip or zstd compressed layer r.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, errors.New("layer is neither gzip nor zstd compressed")
+	}
+}
+
+// blob returns the entry for the blob desc describes, checking its size.
+func blob(entries map[string]*tarEntry, name string, desc v1.Descriptor) (*tarEntry, error) {
+	e, ok := entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing", desc.Digest)
+	}
+	if e.digest != desc.Digest {
+		return nil, fmt.Errorf("%s: content has digest %s", desc.Digest, e.digest)
+	}
+	if e.size != desc.Size {
+		return nil, fmt.Errorf("%s: size is %d, but %d is recorded", desc.Digest, e.size, desc.Size)
+	}
+	return e, nil
+}
+
+func verifyImage(entries map[string]*tarEntry, desc v1.Descriptor, known, layers map[v1.Hash]bool) []error {
+	me, err := blob(entries, desc.Digest.String(), desc)
+	if err != nil {
+		return []error{fmt.Errorf("manifest %w", err)}
+	}
+	m, err := v1.ParseManifest(bytes.NewReader(me.contents))
+	if err != nil {
+		return []error{fmt.Errorf("parsing manifest %s: %w", desc.Digest, err)}
+	}
+
+	ce, err := blob(entries, m.Config.Digest.String(), m.Config)
+	if err != nil {
+		return []error{fmt.Errorf("config of %s: %w", desc.Digest, err)}
+	}
+	cfg, err := v1.ParseConfigFile(bytes.NewReader(ce.contents))
+	if err != nil {
+		return []error{fmt.Errorf("parsing config of %s: %w", desc.Digest, err)}
+	}
+	if len(cfg.RootFS.DiffIDs) != len(m.Layers) {
+		return []error{fmt.Errorf("image %s has %d layers but %d diffIDs", desc.Digest, len(m.Layers), len(cfg.RootFS.DiffIDs))}
+	}
+
+	var errs []error
+	for i, l := range m.Layers {
+		known[l.Digest] = true
+		layers[l.Digest] = true
+		le, err := blob(entries, l.Digest.Hex+".tar.gz", l)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layer of %s: %w", desc.Digest, err))
+			continue
+		}
+		if le.diffID == nil || *le.diffID != cfg.RootFS.DiffIDs[i] {
+			errs = append(errs, fmt.Errorf("layer %s of %s: uncompressed digest %v does not match diffID %s", l.Digest, desc.Digest, le.diffID, cfg.RootFS.DiffIDs[i]))
+		}
+	}
+	return errs
+}
+
+// verifyDockerManifest checks that the files the docker-style manifest.json
+// points at are present.
+func verifyDockerManifest(entries map[string]*tarEntry) []error {
+	me, ok := entries["manifest.json"]
+	if !ok {
+		return []error{errors.New("manifest.json is missing")}
+	}
+	var m tarball.Manifest
+	if err := json.Unmarshal(me.contents, &m); err != nil {
+		return []error{fmt.Errorf("parsing manifest.json: %w", err)}
+	}
+	var errs []error
+	for _, d := range m {
+		for _, name := range append([]string{d.Config}, d.Layers...) {
+			if _, ok := entries[name]; !ok {
+				errs = append(errs, fmt.Errorf("manifest.json references missing file %s", name))
+			}
+		}
+	}
+	return errs
+}
+
+// verifySBOMReferences checks that the OCI package URLs in the SPDX SBOMs in
+// dir name digests in known. It returns the number of SBOMs checked.
+func verifySBOMReferences(dir string, known map[v1.Hash]bool) (int, []error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.spdx.json"))
+	if err != nil {
+		return 0, []error{err}
+	}
+	if len(paths) == 0 {
+		return 0, []error{fmt.Errorf("no SPDX SBOMs found in %s", dir)}
+	}
+
+	var errs []error
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var doc struct {
+			Packages []struct {
+				ExternalRefs []struct {
+					Type    string `json:"referenceType"`
+					Locator string `json:"referenceLocator"`
+				} `json:"externalRefs"`
+			} `json:"packages"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			errs = append(errs, fmt.Errorf("parsing %s: %w", p, err))
+			continue
+		}
+		for _, pkg := range doc.Packages {
+			for _, ref := range pkg.ExternalRefs {
+				if ref.Type != "purl" || !strings.HasPrefix(ref.Locator, "pkg:oci/") {
+					continue
+				}
+				u, err := purl.FromString(ref.Locator)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: parsing %s: %w", p, ref.Locator, err))
+					continue
+				}
+				h, err := v1.NewHash(u.Version)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s has no digest: %w", p, ref.Locator, err))
+					continue
+				}
+				if !known[h] {
+					errs = append(errs, fmt.Errorf("%s references %s, which is not in the tarball", filepath.Base(p), h))
+				}
+			}
+		}
+	}
+	return len(paths), errs
+}
@@ -0,0 +1,188 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+func buildTestTarball(t *testing.T) (string, v1.ImageIndex) {
+	t.Helper()
+	return buildTestTarballOf(t, func() v1.Image {
+		img, err := random.Image(1024, 2)
+		require.NoError(t, err)
+		return img
+	})
+}
+
+// buildTestTarballOf writes an index of an amd64 and an arm64 image made by
+// image.
+func buildTestTarballOf(t *testing.T, image func() v1.Image) (string, v1.ImageIndex) {
+	t.Helper()
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		img := image()
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+	idx := mutate.AppendManifests(empty.Index, adds...)
+
+	out := filepath.Join(t.TempDir(), "image.tar")
+	_, err := BuildIndex(out, idx, []string{"example.com/test:latest"})
+	require.NoError(t, err)
+	return out, idx
+}
+
+// rewriteTarball copies the tarball at path, passing the contents of each
+// file through edit. Files for which edit returns nil are dropped.
+func rewriteTarball(t *testing.T, path string, edit func(name string, b []byte) []byte) string {
+	t.Helper()
+	in, err := os.Open(path)
+	require.NoError(t, err)
+	defer in.Close()
+
+	outPath := filepath.Join(t.TempDir(), "tampered.tar")
+	out, err := os.Create(outPath)
+	require.NoError(t, err)
+	defer out.Close()
+
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(out)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if b = edit(hdr.Name, b); b == nil {
+			continue
+		}
+		hdr.Size = int64(len(b))
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(b)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return outPath
+}
+
+func TestVerifyTarball(t *testing.T) {
+	path, idx := buildTestTarball(t)
+	h, err := idx.Digest()
+	require.NoError(t, err)
+
+	summary, err := VerifyTarball(path, "")
+	require.NoError(t, err)
+	require.Equal(t, h, summary.IndexDigest)
+	require.Equal(t, 2, summary.Images)
+	require.Equal(t, 4, summary.Layers)
+
+	t.Run("zstd layers", func(t *testing.T) {
+		path, _ := buildTestTarballOf(t, func() v1.Image {
+			l, err := random.Layer(1024, types.OCILayer)
+			require.NoError(t, err)
+			rc, err := l.Uncompressed()
+			require.NoError(t, err)
+			defer rc.Close()
+			b, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			zl, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(b)), nil
+			}, tarball.WithCompression(compression.ZStd))
+			require.NoError(t, err)
+			img, err := mutate.AppendLayers(empty.Image, zl)
+			require.NoError(t, err)
+			return img
+		})
+		summary, err := VerifyTarball(path, "")
+		require.NoError(t, err)
+		require.Equal(t, 2, summary.Layers)
+	})
+
+	t.Run("tampered config", func(t *testing.T) {
+		tampered := rewriteTarball(t, path, func(name string, b []byte) []byte {
+			if strings.HasPrefix(name, "sha256:") && strings.Contains(string(b), "rootfs") {
+				return append(b, ' ')
+			}
+			return b
+		})
+		_, err := VerifyTarball(tampered, "")
+		require.ErrorContains(t, err, "content has digest")
+	})
+
+	t.Run("missing manifest", func(t *testing.T) {
+		im, err := idx.IndexManifest()
+		require.NoError(t, err)
+		missing := im.Manifests[1].Digest.String()
+		tampered := rewriteTarball(t, path, func(name string, b []byte) []byte {
+			if name == missing {
+				return nil
+			}
+			return b
+		})
+		_, err = VerifyTarball(tampered, "")
+		require.ErrorContains(t, err, missing+" is missing")
+	})
+
+	t.Run("empty index", func(t *testing.T) {
+		tampered := rewriteTarball(t, path, func(name string, b []byte) []byte {
+			if name == "index.json" {
+				return []byte(`{"schemaVersion": 2}`)
+			}
+			return b
+		})
+		_, err := VerifyTarball(tampered, "")
+		require.ErrorContains(t, err, "lists no images")
+	})
+
+	t.Run("sbom references", func(t *testing.T) {
+		im, err := idx.IndexManifest()
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		sbom := `{"packages": [{"externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:oci/test@%s?arch=amd64"}]}]}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "sbom-x86_64.spdx.json"), fmt.Appendf(nil, sbom, im.Manifests[0].Digest), 0o644))
+
+		summary, err := VerifyTarball(path, dir)
+		require.NoError(t, err)
+		require.Equal(t, 1, summary.SBOMs)
+
+		bogus := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "sbom-aarch64.spdx.json"), fmt.Appendf(nil, sbom, bogus), 0o644))
+		_, err = VerifyTarball(path, dir)
+		require.ErrorContains(t, err, "not in the tarball")
+	})
+}
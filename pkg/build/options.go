@@ -15,43 +15,38 @@
 package build
 
 import (
-	"context"
-	sha2562 "crypto/sha256"
-	"encoding/base64"
 	"fmt"
+	"io"
 	"maps"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
+	"chainguard.dev/apko/internal/pathglob"
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/auth"
+	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
 
-	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
 // Option is an option for the build context.
 type Option func(*Context) error
 
 // WithConfig sets the image configuration for the build context.
-// The image configuration is parsed from given config file.
+// The image configuration is parsed from given config file once all of the
+// options are applied.
 // TODO(jason): Remove this.
 func WithConfig(configFile string, includePaths []string) Option {
 	return func(bc *Context) error {
-		ctx := context.Background()
-		log := clog.FromContext(ctx)
-		log.Debugf("loading config file: %s", configFile)
-
-		var ic types.ImageConfiguration
-		hasher := sha2562.New()
-		if err := ic.Load(ctx, configFile, includePaths, hasher); err != nil { //nolint:staticcheck
-			return fmt.Errorf("failed to load image configuration: %w", err)
-		}
-
-		bc.ic = ic
+		bc.configFile = configFile
+		bc.includePaths = includePaths
 		bc.o.ImageConfigFile = configFile
-		bc.o.ImageConfigChecksum = "sha256-" + base64.StdEncoding.EncodeToString(hasher.Sum(nil))
-
 		return nil
 	}
 }
@@ -90,7 +85,38 @@ func WithBuildDate(s string) Option {
 		}
 
 		bc.o.SourceDateEpoch = t
+		bc.buildDateSet = true
+
+		return nil
+	}
+}
 
+// WithBuildDateFromGit derives the build date from the committer time of the
+// last git commit that touched the configuration file. An explicit build date
+// (WithBuildDate or WithSourceDateEpoch) takes precedence, and the
+// SOURCE_DATE_EPOCH environment variable takes precedence over both.
+func WithBuildDateFromGit(enabled bool) Option {
+	return func(bc *Context) error {
+		bc.o.BuildDateFromGit = enabled
+		return nil
+	}
+}
+
+// WithTimestamps sets the modification time of the files in the image
+// layers, and the time the image is recorded as created at, separately.
+// Each is an RFC 3339 date, seconds since the epoch, TimestampBuildDate or
+// TimestampNewestPackage. By default, files keep the times they were
+// installed with and the image is created at the build date epoch.
+func WithTimestamps(files, created string) Option {
+	return func(bc *Context) error {
+		if err := validateTimestamp(files); err != nil {
+			return fmt.Errorf("file timestamp: %w", err)
+		}
+		if err := validateTimestamp(created); err != nil {
+			return fmt.Errorf("created timestamp: %w", err)
+		}
+		bc.o.FileTimestamp = files
+		bc.o.CreatedTimestamp = created
 		return nil
 	}
 }
@@ -99,6 +125,7 @@ func WithBuildDate(s string) Option {
 func WithSourceDateEpoch(t time.Time) Option {
 	return func(bc *Context) error {
 		bc.o.SourceDateEpoch = t
+		bc.buildDateSet = true
 		return nil
 	}
 }
@@ -157,6 +184,7 @@ func WithIncludePaths(includePaths []string) Option {
 func WithImageConfiguration(ic types.ImageConfiguration) Option {
 	return func(bc *Context) error {
 		bc.ic = ic
+		bc.configFile = ""
 		return nil
 	}
 }
@@ -181,10 +209,10 @@ func WithVCS(enable bool) Option {
 // Commandline annotations take precedence.
 func WithAnnotations(annotations map[string]string) Option {
 	return func(bc *Context) error {
-		if bc.ic.Annotations == nil {
-			bc.ic.Annotations = make(map[string]string)
+		if bc.annotations == nil {
+			bc.annotations = make(map[string]string)
 		}
-		maps.Copy(bc.ic.Annotations, annotations)
+		maps.Copy(bc.annotations, annotations)
 		return nil
 	}
 }
@@ -199,6 +227,37 @@ func WithCache(cacheDir string, offline bool, shared *apk.Cache) Option {
 	}
 }
 
+// WithCacheNamespace isolates this build's package and layer caches from
+// those of other namespaces, for builders shared by several tenants.
+func WithCacheNamespace(namespace string) Option {
+	return func(bc *Context) error {
+		bc.o.CacheNamespace = namespace
+		return nil
+	}
+}
+
+// WithLowerCache sets a read-only package cache, shared across namespaces,
+// that is consulted when the build's own cache misses.
+func WithLowerCache(dir string) Option {
+	return func(bc *Context) error {
+		bc.o.LowerCacheDir = dir
+		return nil
+	}
+}
+
+// WithCacheStore records the metadata of the package cache, such as which
+// etag each index was last fetched with, in store rather than leaving it to
+// be inferred from the cached files.
+func WithCacheStore(store apk.KVStore) Option {
+	return func(bc *Context) error {
+		bc.o.CacheStore = store
+		return nil
+	}
+}
+
+// WithLockFile pins the packages to those in lockFile. An architecture
+// locked on its own, in the lock file lock.ArchFile names next to it, is
+// pinned to the packages of that file instead.
 func WithLockFile(lockFile string) Option {
 	return func(bc *Context) error {
 		bc.o.Lockfile = lockFile
@@ -213,6 +272,25 @@ func WithTempDir(tmp string) Option {
 	}
 }
 
+// WithClock replaces the system clock wherever the build reads the current
+// time, so that tests of code using the library can be deterministic.
+func WithClock(now func() time.Time) Option {
+	return func(bc *Context) error {
+		bc.o.Clock = now
+		return nil
+	}
+}
+
+// WithRand replaces the randomness the build uses, for temporary file names
+// and signatures, so that tests of code using the library can be
+// deterministic. r need not be safe for concurrent use.
+func WithRand(r io.Reader) Option {
+	return func(bc *Context) error {
+		bc.o.Rand = r
+		return nil
+	}
+}
+
 func WithAuthenticator(a auth.Authenticator) Option {
 	return func(bc *Context) error {
 		bc.o.Auth = a
@@ -229,6 +307,505 @@ func WithIgnoreSignatures(ignore bool) Option {
 	}
 }
 
+// WithIDMap remaps the ownership of every file written to the image layers,
+// e.g. to pre-shift a filesystem for a user-namespaced runtime.
+func WithIDMap(idmap options.IDMap) Option {
+	return func(bc *Context) error {
+		bc.o.IDMap = idmap
+		return nil
+	}
+}
+
+// WithLayerCompression selects the format layers are compressed in, one of
+// LayerCompressions. The empty string selects the default, gzip.
+func WithLayerCompression(format string) Option {
+	return func(bc *Context) error {
+		if format != "" && !slices.Contains(LayerCompressions, format) {
+			return fmt.Errorf("unsupported layer compression %q, must be one of %v", format, LayerCompressions)
+		}
+		bc.o.LayerCompression = format
+		return nil
+	}
+}
+
+// WithCompressor selects the implementation used to compress gzip layers,
+// one of Compressors. The empty string selects the default.
+func WithCompressor(impl string) Option {
+	return func(bc *Context) error {
+		if impl != "" && !slices.Contains(Compressors, impl) {
+			return fmt.Errorf("unsupported compressor %q, must be one of %v", impl, Compressors)
+		}
+		bc.o.Compressor = impl
+		return nil
+	}
+}
+
+// WithCompressionLevel sets the level layers are compressed at, from 1
+// (fastest) to 9 (smallest). Zero uses the compressor's default.
+func WithCompressionLevel(level int) Option {
+	return func(bc *Context) error {
+		if level < 0 || level > 9 {
+			return fmt.Errorf("compression level %d out of range [1, 9]", level)
+		}
+		bc.o.CompressionLevel = level
+		return nil
+	}
+}
+
+// WithCompressionThreads sets how many goroutines compress each layer in
+// parallel. Zero keeps the default, which leaves room for concurrent builds.
+func WithCompressionThreads(threads int) Option {
+	return func(bc *Context) error {
+		if threads < 0 {
+			return fmt.Errorf("compression threads must not be negative, got %d", threads)
+		}
+		bc.o.CompressionThreads = threads
+		return nil
+	}
+}
+
+// WithExtraLayers adds caller-built layers to the image returned by
+// BuildLayers, below or above the layers apko builds.
+func WithExtraLayers(layers ...ExtraLayer) Option {
+	return func(bc *Context) error {
+		for _, l := range layers {
+			if l.Layer == nil {
+				return fmt.Errorf("extra layer from %q has no layer", l.Source)
+			}
+			if l.Source == "" {
+				return fmt.Errorf("extra layer has no source")
+			}
+		}
+		bc.extraLayers = append(bc.extraLayers, layers...)
+		return nil
+	}
+}
+
+// WithFSMutator runs m over the image filesystem once the packages are
+// installed and the configuration applied, before the caches are generated
+// and the layers written. Mutators run in the order they are given.
+func WithFSMutator(m FSMutator) Option {
+	return func(bc *Context) error {
+		if m == nil {
+			return fmt.Errorf("filesystem mutator is nil")
+		}
+		bc.fsMutators = append(bc.fsMutators, m)
+		return nil
+	}
+}
+
+// WithScanHooks runs scanners over the finished image filesystem before its
+// layers are written, failing the build on the findings of hooks that ask
+// for it.
+func WithScanHooks(hooks ...ScanHook) Option {
+	return func(bc *Context) error {
+		for _, h := range hooks {
+			if h.Name == "" {
+				return fmt.Errorf("scan hook has no name")
+			}
+			if h.Scanner == nil {
+				return fmt.Errorf("scan hook %q has no scanner", h.Name)
+			}
+		}
+		bc.scanHooks = append(bc.scanHooks, hooks...)
+		return nil
+	}
+}
+
+// WithBestEffortArchs makes multi-arch builds skip, rather than fail on,
+// architectures whose packages cannot be resolved. Skipped architectures are
+// left out of the image index.
+func WithBestEffortArchs(bestEffort bool) Option {
+	return func(bc *Context) error {
+		bc.o.BestEffortArchs = bestEffort
+		return nil
+	}
+}
+
+// WithBuildReport sets the path a JSON BuildReport is written to.
+func WithBuildReport(path string) Option {
+	return func(bc *Context) error {
+		bc.o.BuildReportPath = path
+		return nil
+	}
+}
+
+// WithDefaultsReport sets the path a JSON DefaultsReport is written to.
+func WithDefaultsReport(path string) Option {
+	return func(bc *Context) error {
+		bc.o.DefaultsReportPath = path
+		return nil
+	}
+}
+
+// WithLimitRate caps the combined rate at which packages and indexes are
+// downloaded, in bytes per second. Zero means unlimited.
+func WithLimitRate(bytesPerSecond int64) Option {
+	// Created once so that every architecture built with this option draws
+	// from the same bucket.
+	limiter := apk.NewRateLimiter(bytesPerSecond)
+	return func(bc *Context) error {
+		if bytesPerSecond < 0 {
+			return fmt.Errorf("limit rate must not be negative, got %d", bytesPerSecond)
+		}
+		bc.o.LimitRate = bytesPerSecond
+		bc.o.RateLimiter = limiter
+		return nil
+	}
+}
+
+// WithBackoff shares b between the architectures of a build, so that they
+// back off together from the hosts that rate limit them.
+func WithBackoff(b *apk.Backoff) Option {
+	return func(bc *Context) error {
+		bc.o.Backoff = b
+		return nil
+	}
+}
+
+// WithChecksumDB cross-checks the checksum of every installed package against
+// the checksum database at url, whose tree heads are signed by the note
+// verifier key, failing the build on any mismatch, missing entry or
+// unproven record. An empty url disables the check.
+func WithChecksumDB(url, key string) Option {
+	return func(bc *Context) error {
+		if url != "" && key == "" {
+			return fmt.Errorf("checksum database %s needs a verifier key", url)
+		}
+		bc.o.ChecksumDB = url
+		bc.o.ChecksumDBKey = key
+		return nil
+	}
+}
+
+// WithInputPolicy requires the configuration file, the lock file and the
+// packages of the build to carry the in-toto attestations that the input
+// policy at path asks for, signed by as many of the keys it trusts for each
+// as its threshold says, failing the build otherwise. The policy is a list of
+// keys and thresholds, not an in-toto layout. Packages are checked by their control checksum before they are
+// fetched, and by their data hash as well once they are. An empty path
+// disables the check.
+func WithInputPolicy(path string) Option {
+	return func(bc *Context) error {
+		bc.o.InputPolicy = path
+		return nil
+	}
+}
+
+// WithInputAnnotations annotates the image with the apko version and the
+// digests of the configuration and lock files it was built from.
+func WithInputAnnotations(enable bool) Option {
+	return func(bc *Context) error {
+		bc.o.InputAnnotations = enable
+		return nil
+	}
+}
+
+// WithBuilder sets the identity of the builder running apko, as a URI and
+// an optional version, so that its artifacts can be told apart from those of
+// other builders.
+func WithBuilder(id, version string) Option {
+	return func(bc *Context) error {
+		if id == "" {
+			if version != "" {
+				return fmt.Errorf("builder version %q given without a builder id", version)
+			}
+			return nil
+		}
+		u, err := url.Parse(id)
+		if err != nil {
+			return fmt.Errorf("parsing builder id: %w", err)
+		}
+		if !u.IsAbs() {
+			return fmt.Errorf("builder id %q is not an absolute URI", id)
+		}
+		bc.o.BuilderID = id
+		bc.o.BuilderVersion = version
+		return nil
+	}
+}
+
+// WithBaseImageKey requires the base image, if any, to be signed with cosign
+// using the private key of the public key at path. The signature must be in
+// the base image's OCI layout, as cosign save puts it. Keys of the keyring
+// in OCI registries must be signed the same way, with the signature in
+// their repository.
+func WithBaseImageKey(path string) Option {
+	return func(bc *Context) error {
+		bc.o.BaseImageKey = path
+		return nil
+	}
+}
+
+// WithBaseImageIdentity requires the base image, if any, and the keys of
+// the keyring in OCI registries to be signed keyless with cosign, by
+// identity as vouched for by the OIDC issuer. The signatures are checked
+// against the roots of trust of WithSigstoreRoots.
+func WithBaseImageIdentity(identity, issuer string) Option {
+	return func(bc *Context) error {
+		bc.o.BaseImageIdentity = identity
+		bc.o.BaseImageIssuer = issuer
+		return nil
+	}
+}
+
+// WithSigstoreRoots sets the roots of trust of keyless signatures: the PEM
+// encoded certificates of the Fulcio certificate authority at fulcioRoots,
+// and the public key of the Rekor log at rekorKey.
+func WithSigstoreRoots(fulcioRoots, rekorKey string) Option {
+	return func(bc *Context) error {
+		bc.o.FulcioRoots = fulcioRoots
+		bc.o.RekorKey = rekorKey
+		return nil
+	}
+}
+
+// WithPinFile records the packages resolved for a build from floating
+// package specs in the lock file path. While that file is younger than
+// maxAge, builds use its pins rather than resolving the packages again; a
+// maxAge of zero always resolves them.
+func WithPinFile(path string, maxAge time.Duration) Option {
+	return func(bc *Context) error {
+		if maxAge < 0 {
+			return fmt.Errorf("pin max age must not be negative, got %s", maxAge)
+		}
+		bc.o.PinFile = path
+		bc.o.PinMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithLockDrift checks, when building from a lock file, that the
+// repositories still serve the locked packages with the locked checksums.
+// Stale packages are only warned about, even in options.CheckFail mode.
+func WithLockDrift(mode options.CheckMode) Option {
+	return func(bc *Context) error {
+		if err := mode.Validate(); err != nil {
+			return fmt.Errorf("lock drift: %w", err)
+		}
+		bc.o.LockDrift = mode
+		return nil
+	}
+}
+
+// WithELFDeps checks that the libraries needed by the ELF files in the image
+// can be found in it.
+func WithELFDeps(mode options.CheckMode) Option {
+	return func(bc *Context) error {
+		if err := mode.Validate(); err != nil {
+			return fmt.Errorf("ELF dependency check: %w", err)
+		}
+		bc.o.ELFDeps = mode
+		return nil
+	}
+}
+
+// WithRequireStatic fails the build if any ELF file in the image uses the
+// dynamic loader, for images meant to hold only static binaries.
+func WithRequireStatic(require bool) Option {
+	return func(bc *Context) error {
+		bc.o.RequireStatic = require
+		return nil
+	}
+}
+
+// WithSymlinkCheck reports the symlinks in the image that point outside it or
+// to nothing. Symlinks whose paths match one of the allow patterns, in
+// which "**" matches any number of directories, are not reported.
+func WithSymlinkCheck(mode options.CheckMode, allow []string) Option {
+	return func(bc *Context) error {
+		if err := mode.Validate(); err != nil {
+			return fmt.Errorf("symlink check: %w", err)
+		}
+		for _, p := range allow {
+			if err := pathglob.Validate(p); err != nil {
+				return err
+			}
+		}
+		bc.o.SymlinkCheck = mode
+		bc.o.SymlinkAllow = allow
+		return nil
+	}
+}
+
+// WithPermissionCheck reports the setuid and setgid files and the
+// world-writable paths in the image. Paths that match one of the allow
+// patterns, in which "**" matches any number of directories, are skipped.
+func WithPermissionCheck(mode options.CheckMode, allow []string) Option {
+	return func(bc *Context) error {
+		if err := mode.Validate(); err != nil {
+			return fmt.Errorf("permission check: %w", err)
+		}
+		for _, p := range allow {
+			if err := pathglob.Validate(p); err != nil {
+				return err
+			}
+		}
+		bc.o.PermissionCheck = mode
+		bc.o.PermissionAllow = allow
+		return nil
+	}
+}
+
+// WithStrictPaths fails the build when a local path mutation copies
+// something that would make the build nondeterministic, such as a symlink
+// whose target is absolute or outside the tree being copied.
+func WithStrictPaths(strict bool) Option {
+	return func(bc *Context) error {
+		bc.o.StrictPaths = strict
+		return nil
+	}
+}
+
+// WithProvenance writes the SLSA provenance of the image index to path,
+// signed with the PEM private key at keyPath if it is not empty.
+func WithProvenance(path, keyPath string) Option {
+	return func(bc *Context) error {
+		bc.o.ProvenancePath = path
+		bc.o.ProvenanceKey = keyPath
+		return nil
+	}
+}
+
+// WithLayerCache shares compressed layers between builders through the OCI
+// repository repo: layers found there are not compressed again, and those
+// that are not are pushed there. ropt configure access to the repository.
+func WithLayerCache(repo string, ropt ...remote.Option) Option {
+	return func(bc *Context) error {
+		if repo != "" {
+			if _, err := name.NewRepository(repo); err != nil {
+				return fmt.Errorf("parsing layer cache repository: %w", err)
+			}
+		}
+		bc.o.LayerCache = repo
+		bc.o.LayerCacheOptions = ropt
+		return nil
+	}
+}
+
+// WithLayerCacheReadOnly only fetches layers from the layer cache, without
+// pushing those compressed here to it.
+func WithLayerCacheReadOnly(readOnly bool) Option {
+	return func(bc *Context) error {
+		bc.o.LayerCacheReadOnly = readOnly
+		return nil
+	}
+}
+
+// WithContainerdLoad loads the built image into namespace of the containerd
+// listening at address, with oci.LoadIndexContainerd: apko build does so as
+// well as writing its output, and apko publish instead of pushing to a
+// registry. An empty address is oci.DefaultContainerdAddress, and an empty
+// namespace loads nothing.
+func WithContainerdLoad(address, namespace string) Option {
+	return func(bc *Context) error {
+		if address == "" {
+			address = oci.DefaultContainerdAddress
+		}
+		bc.o.ContainerdAddress = address
+		bc.o.ContainerdNamespace = namespace
+		return nil
+	}
+}
+
+// WithDialOptions controls how repositories are connected to, e.g. only over
+// IPv6, or without racing IPv4 and IPv6. It cannot be combined with
+// WithTransport.
+func WithDialOptions(d apk.DialOptions) Option {
+	return func(bc *Context) error {
+		bc.o.Dial = d
+		return nil
+	}
+}
+
+// WithCATrust sets where the CA certificates repositories are verified
+// against come from: apk.CATrustSystem, apk.CATrustBundled or the path of a
+// PEM file. It cannot be combined with WithTransport.
+func WithCATrust(source string) Option {
+	return func(bc *Context) error {
+		bc.o.CATrust = source
+		return nil
+	}
+}
+
+// WithFetchRetryPolicy sets how fetches of indexes, keys and packages that
+// fail transiently, e.g. with a 503 or a timeout, are retried.
+func WithFetchRetryPolicy(p apk.RetryPolicy) Option {
+	return func(bc *Context) error {
+		bc.o.FetchRetry = p
+		return nil
+	}
+}
+
+// WithRemoteWorkers has the images of some architectures built by the
+// `apko worker` at the URL workers maps them to. This is experimental.
+func WithRemoteWorkers(workers map[types.Architecture]string) Option {
+	return func(bc *Context) error {
+		for arch, u := range workers {
+			if _, err := url.Parse(u); err != nil {
+				return fmt.Errorf("parsing worker URL for %s: %w", arch, err)
+			}
+		}
+		bc.o.RemoteWorkers = workers
+		return nil
+	}
+}
+
+// checkRemoteWorkers fails if bc has remote workers along with options that
+// only take effect on this host, which would be skipped for the
+// architectures the workers build.
+func (bc *Context) checkRemoteWorkers() error {
+	if len(bc.o.RemoteWorkers) == 0 {
+		return nil
+	}
+	var local []string
+	if bc.o.InputPolicy != "" {
+		local = append(local, "an input policy")
+	}
+	if bc.o.SplitDebugPath != "" {
+		local = append(local, "split debug info")
+	}
+	if bc.o.BaseImageKey != "" || bc.o.BaseImageIdentity != "" {
+		local = append(local, "signature verification")
+	}
+	if len(bc.fsMutators) != 0 {
+		local = append(local, "filesystem mutators")
+	}
+	if len(bc.extraLayers) != 0 {
+		local = append(local, "extra layers")
+	}
+	if len(bc.scanHooks) != 0 {
+		local = append(local, "scan hooks")
+	}
+	if len(local) != 0 {
+		return fmt.Errorf("remote workers cannot build with %s", strings.Join(local, ", "))
+	}
+	return nil
+}
+
+// WithConcurrency caps how many architectures of a multi-arch build are built
+// at once. Zero, the default, builds all of them at once.
+func WithConcurrency(n int) Option {
+	return func(bc *Context) error {
+		if n < 0 {
+			return fmt.Errorf("concurrency must not be negative, got %d", n)
+		}
+		bc.o.Concurrency = n
+		return nil
+	}
+}
+
+// WithSplitDebug strips the debug info from the ELF files of the image that
+// have a build ID. The files as they were are laid out by build ID in a
+// companion image, returned by DebugInfo, which apko build and publish write
+// to path as an OCI image layout.
+func WithSplitDebug(path string) Option {
+	return func(bc *Context) error {
+		bc.o.SplitDebugPath = path
+		return nil
+	}
+}
+
 // WithTransport allows explicitly setting the inner HTTP transport.
 func WithTransport(t http.RoundTripper) Option {
 	return func(bc *Context) error {
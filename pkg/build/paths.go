@@ -17,8 +17,12 @@ package build
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
 	"path/filepath"
+	"strings"
+	"text/template"
 
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 
@@ -29,11 +33,15 @@ import (
 type PathMutator func(apkfs.FullFS, *options.Options, types.PathMutation) error
 
 var pathMutators = map[string]PathMutator{
-	"directory":   mutateDirectory,
-	"empty-file":  mutateEmptyFile,
-	"hardlink":    mutateHardLink,
-	"symlink":     mutateSymLink,
-	"permissions": mutatePermissions,
+	"directory":    mutateDirectory,
+	"empty-file":   mutateEmptyFile,
+	"hardlink":     mutateHardLink,
+	"local":        mutateLocal,
+	"symlink":      mutateSymLink,
+	"permissions":  mutatePermissions,
+	"char-device":  mutateNode(apkfs.IFCHR),
+	"block-device": mutateNode(apkfs.IFBLK),
+	"fifo":         mutateNode(apkfs.IFIFO),
 }
 
 func mutatePermissions(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
@@ -55,7 +63,7 @@ func mutatePermissionsDirect(fsys apkfs.FullFS, path string, perms, uid, gid uin
 func mutateDirectory(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
 	perms := fs.FileMode(mut.Permissions)
 
-	if err := fsys.MkdirAll(mut.Path, perms); err != nil {
+	if err := mkdirAll(fsys, o, mut.Path, perms); err != nil {
 		return err
 	}
 
@@ -73,14 +81,38 @@ func mutateDirectory(fsys apkfs.FullFS, o *options.Options, mut types.PathMutati
 	return nil
 }
 
-func ensureParentDirectory(fsys apkfs.FullFS, path string) error {
-	return fsys.MkdirAll(filepath.Dir(path), 0755)
+// ensureParentDirectory creates the missing parent directories of path.
+func ensureParentDirectory(fsys apkfs.FullFS, o *options.Options, path string) error {
+	return mkdirAll(fsys, o, filepath.Dir(path), 0755)
+}
+
+// mkdirAll is like MkdirAll, but dates the directories it creates by the
+// source date epoch, so that they do not depend on when the image was built.
+// Directories that already exist are left as they are.
+func mkdirAll(fsys apkfs.FullFS, o *options.Options, dir string, perms fs.FileMode) error {
+	dir = filepath.Clean(dir)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if info, err := fsys.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%q exists and is not a directory", dir)
+		}
+		return nil
+	}
+	if err := mkdirAll(fsys, o, filepath.Dir(dir), perms); err != nil {
+		return err
+	}
+	if err := fsys.Mkdir(dir, perms); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return fsys.Chtimes(dir, o.SourceDateEpoch, o.SourceDateEpoch)
 }
 
 func mutateEmptyFile(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
 	target := mut.Path
 
-	if err := ensureParentDirectory(fsys, target); err != nil {
+	if err := ensureParentDirectory(fsys, o, target); err != nil {
 		return fmt.Errorf("ensuring parent directory for %q: %w", target, err)
 	}
 
@@ -88,16 +120,18 @@ func mutateEmptyFile(fsys apkfs.FullFS, o *options.Options, mut types.PathMutati
 	if err != nil {
 		return fmt.Errorf("creating file %q: %w", target, err)
 	}
-	defer file.Close()
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("closing %q: %w", target, err)
+	}
 
-	return nil
+	return fsys.Chtimes(target, o.SourceDateEpoch, o.SourceDateEpoch)
 }
 
 func mutateHardLink(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
 	source := mut.Source
 	target := mut.Path
 
-	if err := ensureParentDirectory(fsys, target); err != nil {
+	if err := ensureParentDirectory(fsys, o, target); err != nil {
 		return fmt.Errorf("ensuring parent directory for %q: %w", target, err)
 	}
 
@@ -118,7 +152,7 @@ func mutateHardLink(fsys apkfs.FullFS, o *options.Options, mut types.PathMutatio
 func mutateSymLink(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
 	target := mut.Path
 
-	if err := ensureParentDirectory(fsys, target); err != nil {
+	if err := ensureParentDirectory(fsys, o, target); err != nil {
 		return fmt.Errorf("ensuring parent directory for %q: %w", target, err)
 	}
 
@@ -129,6 +163,290 @@ func mutateSymLink(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation
 	return nil
 }
 
+// mutateNode returns a mutator that creates a device node or FIFO of the
+// given S_IFMT type, using the mutation's major and minor numbers.
+func mutateNode(typ uint32) PathMutator {
+	return func(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
+		target := mut.Path
+
+		if err := ensureParentDirectory(fsys, o, target); err != nil {
+			return fmt.Errorf("ensuring parent directory for %q: %w", target, err)
+		}
+
+		dev := int(apkfs.Mkdev(mut.Major, mut.Minor))
+		if err := fsys.Mknod(target, typ|mut.Permissions&0o777, dev); err != nil {
+			return fmt.Errorf("creating %s %q: %w", mut.Type, target, err)
+		}
+
+		return fsys.Chtimes(target, o.SourceDateEpoch, o.SourceDateEpoch)
+	}
+}
+
+// mutateLocal copies files or directory trees from the build context into the
+// image. The source is resolved relative to the working directory or one of
+// the include paths and may be a glob; see localDestination for how the target
+// path is derived for each match. Every copied entry is owned by the
+// mutation's UID/GID and stamped with the source date epoch; regular files
+// take the mutation's permissions if set and keep their own otherwise.
+func mutateLocal(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
+	// Sources are looked up in the same directories they are confined to,
+	// so that a relative source means the same file wherever apko runs.
+	dirs := localDirs(o)
+	sources, err := resolveLocal(mut.Source, dirs)
+	if err != nil {
+		return fmt.Errorf("resolving local source %q: %w", mut.Source, err)
+	}
+
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(mut.Path)
+	if err != nil {
+		return fmt.Errorf("parsing path template %q: %w", mut.Path, err)
+	}
+
+	roots, err := localRoots(dirs)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]string, len(sources))
+	for _, src := range sources {
+		target, err := localDestination(tmpl, mut, src)
+		if err != nil {
+			return err
+		}
+		if prev, ok := seen[target]; ok {
+			return fmt.Errorf("%q and %q both map to %q", prev, src, target)
+		}
+		seen[target] = src
+
+		resolved, err := confineLocal(roots, src)
+		if err != nil {
+			return err
+		}
+		if err := copyLocalTree(fsys, o, mut, resolved, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localDirs returns the directories local sources are relative to and must
+// be in: that of the configuration file, or the working directory when the
+// configuration was not loaded from one, and the include paths.
+func localDirs(o *options.Options) []string {
+	dir := "."
+	if o.ImageConfigFile != "" {
+		if fi, err := os.Stat(o.ImageConfigFile); err == nil && !fi.IsDir() {
+			dir = filepath.Dir(o.ImageConfigFile)
+		}
+	}
+	return append([]string{dir}, o.IncludePaths...)
+}
+
+// resolveLocal returns the files the local source, a path or a glob, names.
+// An absolute source is used as it is; a relative one is looked up in each
+// of dirs in turn, and the first that has it is used. Glob matches are in
+// lexical order.
+func resolveLocal(source string, dirs []string) ([]string, error) {
+	if filepath.IsAbs(source) {
+		dirs = []string{""}
+	}
+	for _, dir := range dirs {
+		p := filepath.Join(dir, source)
+		if !isGlob(source) {
+			if _, err := os.Lstat(p); err == nil {
+				return []string{p}, nil
+			}
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", source, err)
+		}
+		if len(matches) != 0 {
+			return matches, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// localRoots returns dirs made absolute, with their symlinks resolved.
+func localRoots(dirs []string) ([]string, error) {
+	roots := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		abs, err := filepath.Abs(d)
+		if err != nil {
+			return nil, err
+		}
+		if r, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = r
+		}
+		roots = append(roots, abs)
+	}
+	return roots, nil
+}
+
+// confineLocal resolves the symlinks of the local source src, so that a
+// source that is itself a link is copied as what it links to, and checks
+// that the result is within one of roots, so that a configuration cannot
+// copy arbitrary files of the host into the image.
+func confineLocal(roots []string, src string) (string, error) {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("resolving local source %q: %w", src, err)
+	}
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("local source %q is outside the directory of the configuration and the include paths", src)
+}
+
+// localPathData is the data available to templated local destinations.
+type localPathData struct {
+	// Name is the base name of the source, e.g. "libfoo.so.1".
+	Name string
+	// Stem is Name without its final extension, e.g. "libfoo.so".
+	Stem string
+	// Ext is the final extension of Name including the dot, e.g. ".1".
+	Ext string
+}
+
+// localDestination returns where src is copied to. A path containing a
+// template is rendered with the source's name. Otherwise, a path ending in a
+// slash, or any path used with a glob source, names the directory the source
+// is copied into; a plain path names the copy itself.
+func localDestination(tmpl *template.Template, mut types.PathMutation, src string) (string, error) {
+	name := filepath.Base(src)
+	if strings.Contains(mut.Path, "{{") {
+		ext := filepath.Ext(name)
+		var b strings.Builder
+		if err := tmpl.Execute(&b, localPathData{Name: name, Stem: strings.TrimSuffix(name, ext), Ext: ext}); err != nil {
+			return "", fmt.Errorf("rendering path template %q for %q: %w", mut.Path, src, err)
+		}
+		return b.String(), nil
+	}
+	if isGlob(mut.Source) || strings.HasSuffix(mut.Path, "/") {
+		return filepath.Join(mut.Path, name), nil
+	}
+	return mut.Path, nil
+}
+
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+func copyLocalTree(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation, src, dest string) error {
+	if err := ensureParentDirectory(fsys, o, dest); err != nil {
+		return fmt.Errorf("ensuring parent directory for %q: %w", dest, err)
+	}
+
+	// WalkDir visits entries in lexical order, so the result does not depend
+	// on the order the host filesystem happens to return them in.
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if fi, err := fsys.Stat(target); err == nil && fi.IsDir() {
+				// Directories already in the image, such as /usr/bin, keep
+				// their own mode, owner and date.
+				return nil
+			}
+			if err := fsys.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("creating directory %q: %w", target, err)
+			}
+			if err := fsys.Chmod(target, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("chmod %q: %w", target, err)
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("reading link %q: %w", path, err)
+			}
+			if o.StrictPaths && hostLink(src, path, link) {
+				return &NondeterministicPathError{Path: path, Reason: fmt.Sprintf("it links to %q, outside %q, which depends on the host it is copied from", link, src)}
+			}
+			if err := fsys.Symlink(link, target); err != nil {
+				return fmt.Errorf("symlinking %q -> %q: %w", link, target, err)
+			}
+		case d.Type().IsRegular():
+			perms := info.Mode().Perm()
+			if mut.Permissions != 0 {
+				perms = fs.FileMode(mut.Permissions)
+			}
+			if err := copyLocalFile(fsys, path, target, perms); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported file type %s for %q", d.Type(), path)
+		}
+
+		if err := fsys.Chown(target, int(mut.UID), int(mut.GID)); err != nil {
+			return fmt.Errorf("chown %q: %w", target, err)
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			if err := fsys.Chtimes(target, o.SourceDateEpoch, o.SourceDateEpoch); err != nil {
+				return fmt.Errorf("chtimes %q: %w", target, err)
+			}
+		}
+		return nil
+	})
+}
+
+// hostLink reports whether the symlink at path, copied from the tree at src,
+// links to target outside that tree, that is, to something on the host the
+// build does not copy and so cannot keep stable.
+func hostLink(src, path, target string) bool {
+	if filepath.IsAbs(target) {
+		return true
+	}
+	root := src
+	if path == src {
+		root = filepath.Dir(src)
+	}
+	rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(path), target))
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func copyLocalFile(fsys apkfs.FullFS, src, target string, perms fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening %q: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := fsys.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perms)
+	if err != nil {
+		return fmt.Errorf("creating file %q: %w", target, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %q to %q: %w", src, target, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("closing %q: %w", target, err)
+	}
+	return fsys.Chmod(target, perms)
+}
+
 func mutatePaths(fsys apkfs.FullFS, o *options.Options, ic *types.ImageConfiguration) error {
 	for _, mut := range ic.Paths {
 		pm, ok := pathMutators[mut.Type]
@@ -143,7 +461,8 @@ func mutatePaths(fsys apkfs.FullFS, o *options.Options, ic *types.ImageConfigura
 			return fmt.Errorf("mutating path %q: %w", mut.Path, err)
 		}
 
-		if mut.Type != "permissions" {
+		// local mutations apply ownership and permissions per copied entry.
+		if mut.Type != "permissions" && mut.Type != "local" {
 			if err := mutatePermissions(fsys, o, mut); err != nil {
 				return fmt.Errorf("%s mutation on %s: %w", mut.Type, mut.Path, err)
 			}
@@ -164,3 +483,17 @@ type PathMutationFileConflictError struct {
 func (e *PathMutationFileConflictError) Error() string {
 	return fmt.Sprintf("file %q already exists", e.Path)
 }
+
+// NondeterministicPathError is returned, with WithStrictPaths, when a path
+// mutation copies something into the image that would make the build
+// nondeterministic.
+type NondeterministicPathError struct {
+	// The path on the host of what was copied.
+	Path string
+	// Why it makes the build nondeterministic.
+	Reason string
+}
+
+func (e *NondeterministicPathError) Error() string {
+	return fmt.Sprintf("copying %q makes the build nondeterministic: %s", e.Path, e.Reason)
+}
@@ -0,0 +1,258 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestMutateLocal(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "server"), []byte("binary"), 0o700))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "static", "css"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "static", "css", "site.css"), []byte("body{}"), 0o644))
+	require.NoError(t, os.Symlink("css/site.css", filepath.Join(src, "static", "site.css")))
+
+	epoch := time.Unix(1700000000, 0).UTC()
+	o := &options.Options{SourceDateEpoch: epoch, IncludePaths: []string{src}}
+
+	t.Run("file", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{
+				Path:        "/usr/bin/server",
+				Type:        "local",
+				Source:      filepath.Join(src, "server"),
+				UID:         65532,
+				GID:         65532,
+				Permissions: 0o755,
+			}},
+		}))
+
+		b, err := fsys.ReadFile("/usr/bin/server")
+		require.NoError(t, err)
+		require.Equal(t, "binary", string(b))
+
+		fi, err := fsys.Stat("/usr/bin/server")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o755), fi.Mode().Perm())
+		require.True(t, fi.ModTime().Equal(epoch))
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{
+				Path:   "/srv/static",
+				Type:   "local",
+				Source: filepath.Join(src, "static"),
+			}},
+		}))
+
+		b, err := fsys.ReadFile("/srv/static/css/site.css")
+		require.NoError(t, err)
+		require.Equal(t, "body{}", string(b))
+
+		fi, err := fsys.Stat("/srv/static/css/site.css")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o644), fi.Mode().Perm())
+		require.True(t, fi.ModTime().Equal(epoch))
+
+		link, err := fsys.Readlink("/srv/static/site.css")
+		require.NoError(t, err)
+		require.Equal(t, "css/site.css", link)
+	})
+
+	t.Run("glob", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{
+				Path:   "/usr/share/css",
+				Type:   "local",
+				Source: filepath.Join(src, "static", "css", "*.css"),
+			}},
+		}))
+
+		b, err := fsys.ReadFile("/usr/share/css/site.css")
+		require.NoError(t, err)
+		require.Equal(t, "body{}", string(b))
+	})
+
+	t.Run("templated destination", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{
+				Path:   "/etc/app/{{ .Stem }}.d/{{ .Name }}",
+				Type:   "local",
+				Source: filepath.Join(src, "static", "*", "*.css"),
+			}},
+		}))
+
+		b, err := fsys.ReadFile("/etc/app/site.d/site.css")
+		require.NoError(t, err)
+		require.Equal(t, "body{}", string(b))
+	})
+
+	t.Run("glob collision", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.ErrorContains(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{
+				Path:   "/etc/app/config{{ .Ext }}",
+				Type:   "local",
+				Source: filepath.Join(src, "*"),
+			}},
+		}), "both map to")
+	})
+
+	t.Run("missing source", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.Error(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{
+				Path:   "/nope",
+				Type:   "local",
+				Source: filepath.Join(src, "does-not-exist"),
+			}},
+		}))
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		strict := &options.Options{SourceDateEpoch: epoch, StrictPaths: true, IncludePaths: []string{src}}
+		copyStatic := &types.ImageConfiguration{
+			Paths: []types.PathMutation{{
+				Path:   "/srv/static",
+				Type:   "local",
+				Source: filepath.Join(src, "static"),
+			}},
+		}
+		require.NoError(t, mutatePaths(apkfs.NewMemFS(), strict, copyStatic))
+
+		for _, target := range []string{"/etc/hostname", "../../server"} {
+			host := t.TempDir()
+			require.NoError(t, os.Symlink(target, filepath.Join(host, "link")))
+			strict := &options.Options{SourceDateEpoch: epoch, StrictPaths: true, IncludePaths: []string{host}}
+			err := mutatePaths(apkfs.NewMemFS(), strict, &types.ImageConfiguration{
+				Paths: []types.PathMutation{{Path: "/srv/", Type: "local", Source: host}},
+			})
+			var nerr *NondeterministicPathError
+			require.ErrorAs(t, err, &nerr)
+			require.Equal(t, filepath.Join(host, "link"), nerr.Path)
+		}
+	})
+
+	t.Run("relative to the configuration", func(t *testing.T) {
+		// The working directory has a server of its own, which must not be
+		// picked over the one next to the configuration.
+		t.Chdir(t.TempDir())
+		require.NoError(t, os.WriteFile("server", []byte("elsewhere"), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(src, "apko.yaml"), nil, 0o644))
+		defer os.Remove(filepath.Join(src, "apko.yaml"))
+
+		fsys := apkfs.NewMemFS()
+		conf := &options.Options{SourceDateEpoch: epoch, ImageConfigFile: filepath.Join(src, "apko.yaml")}
+		require.NoError(t, mutatePaths(fsys, conf, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{Path: "/usr/bin/", Type: "local", Source: "server"}, {Path: "/srv/", Type: "local", Source: "static/css/*.css"}},
+		}))
+		b, err := fsys.ReadFile("/usr/bin/server")
+		require.NoError(t, err)
+		require.Equal(t, "binary", string(b))
+		_, err = fsys.Stat("/srv/site.css")
+		require.NoError(t, err)
+	})
+
+	t.Run("outside", func(t *testing.T) {
+		outside := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(outside, "id_rsa"), []byte("secret"), 0o600))
+		require.NoError(t, os.Symlink(filepath.Join(outside, "id_rsa"), filepath.Join(src, "key")))
+		defer os.Remove(filepath.Join(src, "key"))
+
+		for _, source := range []string{filepath.Join(outside, "id_rsa"), filepath.Join(src, "key")} {
+			err := mutatePaths(apkfs.NewMemFS(), o, &types.ImageConfiguration{
+				Paths: []types.PathMutation{{Path: "/key", Type: "local", Source: source}},
+			})
+			require.ErrorContains(t, err, "outside", source)
+		}
+	})
+
+	t.Run("linked source", func(t *testing.T) {
+		require.NoError(t, os.Symlink("static", filepath.Join(src, "assets")))
+		defer os.Remove(filepath.Join(src, "assets"))
+
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{Path: "/srv/", Type: "local", Source: filepath.Join(src, "assets")}},
+		}))
+		fi, err := fsys.Lstat("/srv/assets")
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+		b, err := fsys.ReadFile("/srv/assets/css/site.css")
+		require.NoError(t, err)
+		require.Equal(t, "body{}", string(b))
+	})
+
+	t.Run("existing directory", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, fsys.MkdirAll("/srv/static", 0o700))
+		before, err := fsys.Stat("/srv/static")
+		require.NoError(t, err)
+
+		require.NoError(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+			Paths: []types.PathMutation{{Path: "/srv/static", Type: "local", Source: filepath.Join(src, "static"), UID: 65532}},
+		}))
+		after, err := fsys.Stat("/srv/static")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o700), after.Mode().Perm())
+		require.Equal(t, before.ModTime(), after.ModTime())
+		_, err = fsys.ReadFile("/srv/static/css/site.css")
+		require.NoError(t, err)
+	})
+}
+
+func TestMutatePathsTimestamps(t *testing.T) {
+	epoch := time.Unix(1700000000, 0).UTC()
+	o := &options.Options{SourceDateEpoch: epoch}
+
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("/etc", 0o755))
+	before, err := fsys.Stat("/etc")
+	require.NoError(t, err)
+
+	require.NoError(t, mutatePaths(fsys, o, &types.ImageConfiguration{
+		Paths: []types.PathMutation{
+			{Path: "/var/lib/app/data", Type: "directory", Permissions: 0o750, UID: 65532, GID: 65532},
+			{Path: "/etc/app/ready", Type: "empty-file", Permissions: 0o644},
+			{Path: "/run/app/fifo", Type: "fifo", Permissions: 0o600},
+		},
+	}))
+
+	for _, p := range []string{"/var", "/var/lib", "/var/lib/app", "/var/lib/app/data", "/etc/app", "/etc/app/ready", "/run", "/run/app", "/run/app/fifo"} {
+		fi, err := fsys.Stat(p)
+		require.NoError(t, err, p)
+		require.True(t, fi.ModTime().Equal(epoch), "%s is dated %v", p, fi.ModTime())
+	}
+
+	// Directories that already existed keep their dates.
+	after, err := fsys.Stat("/etc")
+	require.NoError(t, err)
+	require.Equal(t, before.ModTime(), after.ModTime())
+}
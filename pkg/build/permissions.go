@@ -0,0 +1,111 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/internal/pathglob"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// riskyPath is a path in the image whose permissions let users other than
+// its owner raise their privileges or change what it holds.
+type riskyPath struct {
+	path     string
+	mode     fs.FileMode
+	problems []string
+}
+
+func (r riskyPath) String() string {
+	return fmt.Sprintf("/%s (%#o) is %s", r.path, unixPermissions(r.mode), strings.Join(r.problems, " and "))
+}
+
+// findRiskyPaths reports the setuid and setgid files in fsys, and the
+// world-writable files and directories, except directories with the sticky
+// bit set, such as /tmp, where users cannot remove each other's files. Paths
+// that match one of the allow patterns are skipped.
+func findRiskyPaths(fsys apkfs.FullFS, allow []string) ([]riskyPath, error) {
+	var risky []riskyPath
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() && !d.IsDir() {
+			return nil
+		}
+		if slices.ContainsFunc(allow, func(pattern string) bool { return pathglob.Match(pattern, p) }) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		m := fi.Mode()
+		var problems []string
+		if !d.IsDir() && m&fs.ModeSetuid != 0 {
+			problems = append(problems, "setuid")
+		}
+		if !d.IsDir() && m&fs.ModeSetgid != 0 {
+			problems = append(problems, "setgid")
+		}
+		if m.Perm()&0o002 != 0 && (!d.IsDir() || m&fs.ModeSticky == 0) {
+			problems = append(problems, "world-writable")
+		}
+		if len(problems) != 0 {
+			risky = append(risky, riskyPath{path: p, mode: m, problems: problems})
+		}
+		return nil
+	})
+	return risky, err
+}
+
+// checkPermissions reports the setuid and setgid files and the
+// world-writable paths in the image, failing the build in
+// options.CheckFail mode.
+func (bc *Context) checkPermissions(ctx context.Context) error {
+	if bc.o.PermissionCheck == options.CheckOff {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	_, span := otel.Tracer("apko").Start(ctx, "checkPermissions")
+	defer span.End()
+
+	risky, err := findRiskyPaths(bc.fs, bc.o.PermissionAllow)
+	if err != nil {
+		return fmt.Errorf("checking permissions: %w", err)
+	}
+	if bc.o.PermissionCheck == options.CheckWarn {
+		for _, r := range risky {
+			log.Warn(r.String())
+		}
+		return nil
+	}
+	errs := make([]error, 0, len(risky))
+	for _, r := range risky {
+		errs = append(errs, errors.New(r.String()))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,63 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestFindRiskyPaths(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	for dir, perm := range map[string]fs.FileMode{
+		"usr/bin":   0o755,
+		"var/spool": 0o777,
+		"tmp":       0o777 | fs.ModeSticky,
+	} {
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		require.NoError(t, fsys.Chmod(dir, perm))
+	}
+	for name, perm := range map[string]fs.FileMode{
+		"usr/bin/su":      0o755 | fs.ModeSetuid,
+		"usr/bin/wall":    0o755 | fs.ModeSetgid,
+		"usr/bin/sudo":    0o755 | fs.ModeSetuid | fs.ModeSetgid,
+		"usr/bin/ls":      0o755,
+		"var/spool/queue": 0o666,
+		"tmp/scratch":     0o666,
+	} {
+		require.NoError(t, fsys.WriteFile(name, []byte("#!"), 0o644))
+		require.NoError(t, fsys.Chmod(name, perm))
+	}
+	require.NoError(t, fsys.Symlink("/usr/bin/su", "usr/bin/su-link"))
+
+	risky, err := findRiskyPaths(fsys, []string{"/tmp/**"})
+	require.NoError(t, err)
+
+	got := map[string]string{}
+	for _, r := range risky {
+		got[r.path] = r.String()
+	}
+	require.Equal(t, map[string]string{
+		"usr/bin/su":      "/usr/bin/su (04755) is setuid",
+		"usr/bin/wall":    "/usr/bin/wall (02755) is setgid",
+		"usr/bin/sudo":    "/usr/bin/sudo (06755) is setuid and setgid",
+		"var/spool":       "/var/spool (0777) is world-writable",
+		"var/spool/queue": "/var/spool/queue (0666) is world-writable",
+	}, got)
+}
@@ -0,0 +1,310 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/errgroup"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/lock"
+)
+
+// The phases of a build, which BuildLayers runs one after the other, can
+// also be run one at a time, to do more in between, such as adding files to
+// the filesystem the Context was created with before it is layered:
+//
+//	res, err := bc.ResolvePackages(ctx)
+//	...
+//	if err := bc.FetchPackages(ctx, res, 8); err != nil {
+//		...
+//	}
+//	inst, err := bc.InstallPackages(ctx, res)
+//	...
+//	layers, err := bc.Layerize(ctx, inst)
+//	...
+//	img, err := bc.Finalize(ctx, layers)
+//
+// Each phase runs once, in this order, on a Context from New. FetchPackages
+// is optional: InstallPackages downloads whatever is not in the cache.
+
+// Resolution is the outcome of ResolvePackages.
+type Resolution struct {
+	// Packages are the packages to install, in the order they are
+	// installed.
+	Packages []apk.InstallablePackage
+	// Lockfile is the lock file the packages were read from, if the build
+	// is from one.
+	Lockfile string
+
+	// conflicts are the packages that cannot be installed alongside
+	// Packages.
+	conflicts []string
+}
+
+// Installation is the outcome of InstallPackages.
+type Installation struct {
+	// Packages are the installed packages, in the order they were
+	// installed, each with the lines it added to the installed database.
+	Packages []apk.InstalledDiff
+}
+
+// ResolvePackages determines the packages to install: those the lock file
+// pins for the architecture when building from one, and otherwise those
+// that satisfy the configuration's packages, along with their dependencies.
+func (bc *Context) ResolvePackages(ctx context.Context) (*Resolution, error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "ResolvePackages")
+	defer span.End()
+
+	if bc.o.Lockfile == "" {
+		pkgs, conflicts, err := bc.apk.ResolveWorld(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("installing apk packages: error getting package dependencies: %w", err)
+		}
+		res := &Resolution{
+			Packages:  make([]apk.InstallablePackage, 0, len(pkgs)),
+			conflicts: conflicts,
+		}
+		for _, pkg := range pkgs {
+			res.Packages = append(res.Packages, pkg)
+		}
+		return res, nil
+	}
+
+	lockfile := bc.lockfile()
+	log.Debugf("Using lockfile: %s", lockfile)
+	l, err := lock.FromFile(lockfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lock-file: %w", err)
+	}
+	if err := bc.VerifyLockfileConsistency(ctx, l.Config); err != nil {
+		return nil, err
+	}
+	if err := bc.checkLockDrift(ctx, l); err != nil {
+		return nil, fmt.Errorf("lock-file %s has drifted from the repositories: %w", lockfile, err)
+	}
+	pkgs, err := installablePackagesForArch(l, bc.Arch())
+	if err != nil {
+		return nil, fmt.Errorf("failed getting packages for install from lockfile %s: %w", lockfile, err)
+	}
+	return &Resolution{Packages: pkgs, Lockfile: lockfile}, nil
+}
+
+// FetchPackages downloads the packages of res to the cache, at most jobs at
+// a time, or without a limit if jobs <= 0, so that InstallPackages does not
+// have to. It needs a cache directory.
+func (bc *Context) FetchPackages(ctx context.Context, res *Resolution, jobs int) error {
+	ctx, span := otel.Tracer("apko").Start(ctx, "FetchPackages")
+	defer span.End()
+
+	var g errgroup.Group
+	if jobs > 0 {
+		g.SetLimit(jobs)
+	}
+	for _, pkg := range res.Packages {
+		g.Go(func() error {
+			return bc.apk.CachePackage(ctx, pkg)
+		})
+	}
+	return g.Wait()
+}
+
+// InstallPackages installs the packages of res, then completes the image
+// filesystem from the configuration: accounts, paths, the s6 supervision
+// tree, busybox links and so on. The filesystem can still be changed
+// before Layerize writes it to layers.
+func (bc *Context) InstallPackages(ctx context.Context, res *Resolution) (*Installation, error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "InstallPackages")
+	defer span.End()
+
+	// When using base image for the build, apko adds new layer on top of the base. This means
+	// it will override files from lower layers. We add all installed packages from base to current
+	// installed file so that the final installed file contains all image's packages.
+	if bc.baseimg != nil {
+		basePkgs := bc.baseimg.InstalledPackages()
+		// Index for loop to make golang-ci happy.
+		// See https://stackoverflow.com/questions/62446118/implicit-memory-aliasing-in-for-loop
+		for index := range basePkgs {
+			_, err := bc.apk.AddInstalledPackage(&basePkgs[index].Package, basePkgs[index].Files)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := bc.verifyInputs(ctx, res); err != nil {
+		return nil, err
+	}
+	if err := bc.apk.CheckConflicts(res.conflicts); err != nil {
+		return nil, fmt.Errorf("installing apk packages: %w", err)
+	}
+	pkgs, err := bc.apk.InstallPackages(ctx, &bc.o.SourceDateEpoch, res.Packages)
+	if err != nil {
+		if res.Lockfile != "" {
+			return nil, fmt.Errorf("failed installation from lockfile %s: %w", res.Lockfile, err)
+		}
+		return nil, fmt.Errorf("installing apk packages: %w", err)
+	}
+	for _, p := range pkgs {
+		if p.Resolved != nil {
+			bc.resolved = append(bc.resolved, p.Resolved)
+		}
+	}
+	if err := bc.verifyInstalledInputs(ctx, res, pkgs); err != nil {
+		return nil, err
+	}
+
+	if bc.o.ChecksumDB != "" {
+		installed := make([]*apk.Package, 0, len(pkgs))
+		for _, p := range pkgs {
+			installed = append(installed, p.Package)
+		}
+		db, err := newChecksumDB(bc.o.ChecksumDB, bc.o.ChecksumDBKey, bc.apk.Client(), bc.o.Auth)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.verify(ctx, installed); err != nil {
+			return nil, err
+		}
+	}
+
+	// For now adding additional accounts is banned when using base image. On the other hand, we don't want to
+	// wipe out the users set in base.
+	// If one wants to add a support for adding additional users they would need to look into this piece of code.
+	if bc.ic.Contents.BaseImage == nil {
+		if err := mutateAccounts(bc.fs, &bc.ic); err != nil {
+			return nil, fmt.Errorf("failed to mutate accounts: %w", err)
+		}
+	}
+
+	if err := bc.WriteEtcApkoConfig(ctx); err != nil {
+		return nil, fmt.Errorf("failed to install apko config: %w", err)
+	}
+
+	if err := mutatePaths(bc.fs, &bc.o, &bc.ic); err != nil {
+		return nil, fmt.Errorf("failed to mutate paths: %w", err)
+	}
+
+	if err := bc.s6.WriteSupervisionTree(ctx, bc.ic.Entrypoint.Services); err != nil {
+		return nil, fmt.Errorf("failed to write supervision tree: %w", err)
+	}
+
+	// add busybox symlinks
+	installed, err := bc.apk.GetInstalled()
+	if err != nil {
+		return nil, fmt.Errorf("getting installed packages: %w", err)
+	}
+
+	if err := installBusyboxLinks(bc.fs, installed); err != nil {
+		return nil, err
+	}
+
+	if err := bc.renderLabels(installed); err != nil {
+		return nil, err
+	}
+
+	// add necessary character devices
+	if err := installCharDevices(bc.fs); err != nil {
+		return nil, err
+	}
+
+	if err := runFSMutators(ctx, bc.fs, bc.fsMutators); err != nil {
+		return nil, err
+	}
+
+	if err := updateCache(ctx, bc.fs); err != nil {
+		return nil, err
+	}
+
+	if err := bc.precompileBytecode(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := bc.generateRuntimeCaches(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := bc.splitDebugInfo(ctx); err != nil {
+		return nil, err
+	}
+
+	log.Debug("finished building filesystem")
+
+	return &Installation{Packages: pkgs}, nil
+}
+
+// Layerize writes the filesystem InstallPackages built to layers, split as
+// the configuration's layering asks, and returns them with any layers
+// added with WithExtraLayers.
+func (bc *Context) Layerize(ctx context.Context, inst *Installation) ([]v1.Layer, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "Layerize")
+	defer span.End()
+
+	// Use the legacy (single-layer) strategy when:
+	// 1. Layering is nil (original behavior)
+	// 2. Layering is empty (i.e., layering: {})
+	if !layered(bc.ic.Layering) {
+		if err := bc.postBuildSetApk(ctx); err != nil {
+			return nil, err
+		}
+		_, layer, err := bc.ImageLayoutToLayer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// The layer is otherwise compressed lazily, as the image is
+		// written, but the layer cache has to be consulted first.
+		if bc.o.LayerCache != "" {
+			if err := compressLayers(ctx, &bc.o, []v1.Layer{layer}); err != nil {
+				return nil, fmt.Errorf("compressing layer: %w", err)
+			}
+		}
+
+		return bc.withExtraLayers([]v1.Layer{layer}), nil
+	}
+
+	if err := bc.checkLayering(); err != nil {
+		return nil, err
+	}
+	layers, err := bc.splitInstallation(ctx, inst)
+	if err != nil {
+		return nil, err
+	}
+	return bc.withExtraLayers(layers), nil
+}
+
+// Finalize builds the image of layers, with the configuration's entrypoint,
+// environment, annotations and so on, dated by CreatedTime.
+func (bc *Context) Finalize(ctx context.Context, layers []v1.Layer) (v1.Image, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "Finalize")
+	defer span.End()
+
+	created, err := bc.CreatedTime()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine created time: %w", err)
+	}
+	img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ic, created, bc.Arch())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OCI image for %q: %w", bc.Arch(), err)
+	}
+	return img, nil
+}
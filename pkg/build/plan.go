@@ -0,0 +1,258 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// Plan describes what a build would produce, without running it. It is meant
+// to be reviewed, or checked by policy tools, before the build is applied.
+type Plan struct {
+	// Tags are the destinations the image would be published or written to.
+	Tags []string `json:"tags,omitempty"`
+	// Config is the runtime configuration every image would carry.
+	Config v1.Config `json:"config"`
+	// Annotations are applied to the image manifests.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Repositories are the package repositories used, with credentials
+	// redacted.
+	Repositories []string `json:"repositories,omitempty"`
+	// SBOMFormats are the SBOM formats that would be generated.
+	SBOMFormats []string `json:"sbomFormats,omitempty"`
+	// Files are the files apko synthesizes on top of the packages.
+	Files []PlannedFile `json:"files"`
+	// Archs has the per-architecture part of the plan, sorted by architecture.
+	Archs []ArchPlan `json:"archs"`
+}
+
+// ArchPlan is the plan for one architecture.
+type ArchPlan struct {
+	Arch     string           `json:"arch"`
+	Packages []PlannedPackage `json:"packages"`
+	Layers   []PlannedLayer   `json:"layers"`
+}
+
+// PlannedPackage is a package that would be installed.
+type PlannedPackage struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Origin        string `json:"origin,omitempty"`
+	Source        string `json:"source"`
+	Checksum      string `json:"checksum"`
+	InstalledSize uint64 `json:"installedSize"`
+}
+
+// PlannedLayer is a layer of the image, bottom first.
+type PlannedLayer struct {
+	// Packages are the names of the packages whose files land in the layer.
+	Packages []string `json:"packages,omitempty"`
+	// Origins are the source packages of Packages, for layers of packages
+	// grouped by origin.
+	Origins []string `json:"origins,omitempty"`
+	// Source says where the layer comes from: "packages" for a layer of
+	// package contents, "paths:<name>" for a layer defined by paths, "apko"
+	// for the layer holding everything else, or the source of a layer given
+	// with WithExtraLayers.
+	Source string `json:"source"`
+}
+
+// PlannedFile is a file apko writes itself rather than taking from a package.
+type PlannedFile struct {
+	Path string `json:"path"`
+	// Reason is what the file comes from, e.g. "accounts" or "paths".
+	Reason string `json:"reason"`
+}
+
+// GeneratePlan resolves the packages for each architecture and describes the
+// resulting build without installing anything. Architectures default the same
+// way as for a build: archs if set, else those in the configuration, else all.
+func GeneratePlan(ctx context.Context, archs []types.Architecture, opts ...Option) (*Plan, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "GeneratePlan")
+	defer span.End()
+
+	o, ic, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Archs from the command line win over those of the configuration,
+	// which win over the defaults.
+	if err := ic.ResolveArchs(archs); err != nil {
+		return nil, err
+	}
+
+	cfg, err := oci.ContainerConfig(*ic)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Tags:        o.Tags,
+		Config:      cfg,
+		Annotations: ic.Annotations,
+		SBOMFormats: o.SBOMFormats,
+		Files:       plannedFiles(ic),
+	}
+	for _, repo := range slices.Concat(ic.Contents.BuildRepositories, ic.Contents.Repositories, o.ExtraBuildRepos, o.ExtraRepos) {
+		plan.Repositories = append(plan.Repositories, redactRepository(repo))
+	}
+
+	mc, err := NewMultiArch(ctx, ic.Archs, append(opts, WithImageConfiguration(*ic))...)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := mc.BuildPackageLists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("resolving packages: %w", err)
+	}
+
+	for arch, pkgs := range lists {
+		ap := ArchPlan{Arch: arch.String()}
+		resolved := make([]*apk.Package, 0, len(pkgs))
+		for _, pkg := range pkgs {
+			ap.Packages = append(ap.Packages, PlannedPackage{
+				Name:          pkg.Name,
+				Version:       pkg.Version,
+				Origin:        pkg.Origin,
+				Source:        pkg.URL(),
+				Checksum:      pkg.ChecksumString(),
+				InstalledSize: pkg.InstalledSize,
+			})
+			resolved = append(resolved, pkg.Package)
+		}
+		ap.Layers, err = plannedLayers(mc.Contexts[arch], resolved)
+		if err != nil {
+			return nil, err
+		}
+		plan.Archs = append(plan.Archs, ap)
+	}
+	slices.SortFunc(plan.Archs, func(a, b ArchPlan) int {
+		return strings.Compare(a.Arch, b.Arch)
+	})
+
+	return plan, nil
+}
+
+// plannedLayers mirrors the layering BuildLayers would do.
+func plannedLayers(bc *Context, pkgs []*apk.Package) ([]PlannedLayer, error) {
+	var layers []PlannedLayer
+	for _, el := range bc.extraLayers {
+		if el.Below {
+			layers = append(layers, PlannedLayer{Source: el.Source})
+		}
+	}
+
+	if l := bc.ic.Layering; !layered(l) {
+		layers = append(layers, PlannedLayer{Packages: packageNames(pkgs), Source: "apko"})
+	} else {
+		groups, err := groupPackages(pkgs, l)
+		if err != nil {
+			return nil, fmt.Errorf("grouping packages: %w", err)
+		}
+		for _, g := range groups {
+			layers = append(layers, PlannedLayer{Packages: packageNames(g.pkgs), Origins: packageOrigins(g.pkgs), Source: "packages"})
+		}
+		for _, pl := range l.Layers {
+			layers = append(layers, PlannedLayer{Source: "paths:" + pl.Name})
+		}
+		layers = append(layers, PlannedLayer{Source: "apko"})
+	}
+
+	for _, el := range bc.extraLayers {
+		if !el.Below {
+			layers = append(layers, PlannedLayer{Source: el.Source})
+		}
+	}
+	return layers, nil
+}
+
+// plannedFiles lists the files apko writes into every image built from ic.
+func plannedFiles(ic *types.ImageConfiguration) []PlannedFile {
+	files := []PlannedFile{
+		{Path: "/etc/apk/arch", Reason: "apk"},
+		{Path: "/etc/apk/repositories", Reason: "apk"},
+		{Path: "/etc/apk/world", Reason: "apk"},
+		{Path: "/usr/lib/apk/db/installed", Reason: "apk"},
+		{Path: "/usr/lib/apk/db/lock", Reason: "apk"},
+		{Path: "/usr/lib/apk/db/triggers", Reason: "apk"},
+	}
+	for _, key := range ic.Contents.Keyring {
+		if u, err := url.Parse(key); err == nil {
+			key = u.Path
+		}
+		files = append(files, PlannedFile{Path: path.Join("/etc/apk/keys", path.Base(key)), Reason: "keyring"})
+	}
+	files = append(files, PlannedFile{Path: "/etc/apko.json", Reason: "apko"})
+	if len(ic.Accounts.Groups) != 0 {
+		files = append(files, PlannedFile{Path: "/etc/group", Reason: "accounts"})
+	}
+	if len(ic.Accounts.Users) != 0 {
+		files = append(files, PlannedFile{Path: "/etc/passwd", Reason: "accounts"})
+	}
+	for _, dev := range []string{"/dev/console", "/dev/null", "/dev/random", "/dev/urandom", "/dev/zero"} {
+		files = append(files, PlannedFile{Path: dev, Reason: "devices"})
+	}
+	for _, mut := range ic.Paths {
+		files = append(files, PlannedFile{Path: path.Join("/", mut.Path), Reason: "paths:" + mut.Type})
+	}
+	return files
+}
+
+func packageNames(pkgs []*apk.Package) []string {
+	names := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+	return names
+}
+
+// packageOrigins returns the sorted source packages of pkgs.
+func packageOrigins(pkgs []*apk.Package) []string {
+	origins := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		origins = append(origins, pkg.SourcePackage())
+	}
+	slices.Sort(origins)
+	return slices.Compact(origins)
+}
+
+// redactRepository redacts credentials from a repository line, which may be
+// prefixed with an @tag.
+func redactRepository(repo string) string {
+	tag, rawURL, ok := strings.Cut(repo, " ")
+	if !ok {
+		tag, rawURL = "", repo
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return repo
+	}
+	if tag != "" {
+		return tag + " " + u.Redacted()
+	}
+	return u.Redacted()
+}
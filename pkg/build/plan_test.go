@@ -0,0 +1,51 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build"
+)
+
+func TestGeneratePlan(t *testing.T) {
+	ctx := context.Background()
+
+	plan, err := build.GeneratePlan(ctx, nil,
+		build.WithConfig("apko.yaml", []string{"testdata"}),
+		build.WithTags("registry.example/image:latest"),
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"registry.example/image:latest"}, plan.Tags)
+	require.Equal(t, []string{"/bin/sh", "-l"}, plan.Config.Entrypoint)
+	require.Contains(t, plan.Files, build.PlannedFile{Path: "/etc/apk/keys/melange.rsa.pub", Reason: "keyring"})
+
+	require.Len(t, plan.Archs, 2)
+	require.Equal(t, "amd64", plan.Archs[0].Arch)
+	require.Equal(t, "arm64", plan.Archs[1].Arch)
+	for _, ap := range plan.Archs {
+		var names []string
+		for _, pkg := range ap.Packages {
+			names = append(names, pkg.Name)
+			require.NotEmpty(t, pkg.Checksum)
+		}
+		require.ElementsMatch(t, []string{"pretend-baselayout", "replayout"}, names)
+		require.Equal(t, []build.PlannedLayer{{Packages: names, Source: "apko"}}, ap.Layers)
+	}
+}
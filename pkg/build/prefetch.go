@@ -0,0 +1,89 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/errgroup"
+
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/lock"
+)
+
+// PrefetchConfiguration returns the image configuration needed to fetch
+// everything the lock pins for arch: the keys that verify that architecture's
+// repositories and its build-time repositories. It has no packages of its own.
+func PrefetchConfiguration(l lock.Lock, arch types.Architecture) types.ImageConfiguration {
+	ic := types.ImageConfiguration{
+		Archs: []types.Architecture{arch},
+	}
+	for _, k := range l.Contents.Keyrings {
+		if k.Architecture == "" || k.Architecture == arch.ToAPK() {
+			ic.Contents.Keyring = append(ic.Contents.Keyring, k.URL)
+		}
+	}
+	for _, r := range l.Contents.BuildRepositories {
+		if r.Architecture == arch.ToAPK() {
+			ic.Contents.BuildRepositories = append(ic.Contents.BuildRepositories, repositoryFromIndexURL(r))
+		}
+	}
+	for _, r := range l.Contents.Repositories {
+		if r.Architecture == arch.ToAPK() {
+			ic.Contents.Repositories = append(ic.Contents.Repositories, repositoryFromIndexURL(r))
+		}
+	}
+	return ic
+}
+
+// repositoryFromIndexURL strips the "/<arch>/APKINDEX.tar.gz" suffix that
+// `apko lock` appends to the repository URL.
+func repositoryFromIndexURL(r lock.LockRepo) string {
+	return strings.TrimSuffix(r.URL, "/"+r.Architecture+"/APKINDEX.tar.gz")
+}
+
+// Prefetch populates the cache with the repository indexes and packages that
+// the lock pins for this context's architecture, without installing anything.
+// At most jobs packages are fetched concurrently; jobs <= 0 means no limit.
+func (bc *Context) Prefetch(ctx context.Context, l lock.Lock, jobs int) error {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "Prefetch")
+	defer span.End()
+
+	if _, err := bc.apk.GetRepositoryIndexes(ctx, bc.o.IgnoreSignatures); err != nil {
+		return fmt.Errorf("fetching repository indexes: %w", err)
+	}
+
+	pkgs, err := installablePackagesForArch(l, bc.Arch())
+	if err != nil {
+		return err
+	}
+	log.Infof("prefetching %d packages", len(pkgs))
+
+	var g errgroup.Group
+	if jobs > 0 {
+		g.SetLimit(jobs)
+	}
+	for _, pkg := range pkgs {
+		g.Go(func() error {
+			return bc.apk.CachePackage(ctx, pkg)
+		})
+	}
+	return g.Wait()
+}
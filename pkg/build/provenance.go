@@ -0,0 +1,339 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"sigs.k8s.io/release-utils/version"
+
+	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// The types of the provenance statements of images.
+const (
+	InTotoStatementType = "https://in-toto.io/Statement/v1"
+	SLSAProvenanceType  = "https://slsa.dev/provenance/v1"
+	// ProvenanceBuildType is the build type of apko builds, whose external
+	// parameters are ProvenanceParameters.
+	ProvenanceBuildType = "https://chainguard.dev/apko/build/v1"
+	// DefaultBuilderID identifies the builder when WithBuilder is not used.
+	DefaultBuilderID = "https://github.com/chainguard-dev/apko"
+)
+
+// The media type of DSSE envelopes, as signed provenance is written.
+const dsseEnvelopeType = "application/vnd.dsse.envelope.v1+json"
+
+// ProvenanceStatement is an in-toto statement of the SLSA provenance of an
+// image index.
+type ProvenanceStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     SLSAProvenance       `json:"predicate"`
+}
+
+// ResourceDescriptor describes an artifact: the image, or an input of its
+// build.
+type ResourceDescriptor struct {
+	Name        string            `json:"name,omitempty"`
+	URI         string            `json:"uri,omitempty"`
+	Digest      map[string]string `json:"digest,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SLSAProvenance is a SLSA v1 provenance predicate.
+type SLSAProvenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition is what was built and from what.
+type BuildDefinition struct {
+	BuildType          string               `json:"buildType"`
+	ExternalParameters ProvenanceParameters `json:"externalParameters"`
+	// ResolvedDependencies are the configuration, the lock files and the
+	// packages installed.
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// ProvenanceParameters are the parameters apko was invoked with.
+type ProvenanceParameters struct {
+	Config                 string   `json:"config,omitempty"`
+	Lockfile               string   `json:"lockfile,omitempty"`
+	Archs                  []string `json:"archs"`
+	Tags                   []string `json:"tags,omitempty"`
+	SourceDateEpoch        string   `json:"sourceDateEpoch,omitempty"`
+	ExtraKeys              []string `json:"extraKeys,omitempty"`
+	ExtraBuildRepositories []string `json:"extraBuildRepositories,omitempty"`
+	ExtraRepositories      []string `json:"extraRepositories,omitempty"`
+	ExtraPackages          []string `json:"extraPackages,omitempty"`
+	IgnoreSignatures       bool     `json:"ignoreSignatures,omitempty"`
+}
+
+// RunDetails is who ran the build.
+type RunDetails struct {
+	Builder ProvenanceBuilder `json:"builder"`
+}
+
+// ProvenanceBuilder identifies the builder, with the versions of apko and of
+// the builder itself.
+type ProvenanceBuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// NewProvenance returns the provenance of the image index with digest, built
+// with o and ic from packages, the packages locked for each architecture. The
+// statement only depends on its inputs, so that it is reproducible: it does
+// not record when the build ran.
+func NewProvenance(o *options.Options, ic *types.ImageConfiguration, digest v1.Hash, packages map[types.Architecture][]pkglock.LockPkg) (*ProvenanceStatement, error) {
+	subject := ResourceDescriptor{Digest: map[string]string{digest.Algorithm: digest.Hex}}
+	var subjects []ResourceDescriptor
+	for _, tag := range o.Tags {
+		s := subject
+		s.Name = tag
+		subjects = append(subjects, s)
+	}
+	if len(subjects) == 0 {
+		subjects = []ResourceDescriptor{subject}
+	}
+
+	params := ProvenanceParameters{
+		Config:                 o.ImageConfigFile,
+		Lockfile:               o.Lockfile,
+		Tags:                   o.Tags,
+		ExtraKeys:              o.ExtraKeyFiles,
+		ExtraBuildRepositories: redactURLs(o.ExtraBuildRepos),
+		ExtraRepositories:      redactURLs(o.ExtraRepos),
+		ExtraPackages:          o.ExtraPackages,
+		IgnoreSignatures:       o.IgnoreSignatures,
+	}
+	for _, arch := range ic.Archs {
+		params.Archs = append(params.Archs, arch.ToAPK())
+	}
+	if !o.SourceDateEpoch.IsZero() {
+		params.SourceDateEpoch = o.SourceDateEpoch.UTC().Format("2006-01-02T15:04:05Z")
+	}
+
+	var deps []ResourceDescriptor
+	if sum, ok := strings.CutPrefix(o.ImageConfigChecksum, "sha256-"); ok {
+		b, err := base64.StdEncoding.DecodeString(sum)
+		if err != nil {
+			return nil, fmt.Errorf("decoding config checksum: %w", err)
+		}
+		deps = append(deps, ResourceDescriptor{Name: "config", URI: o.ImageConfigFile, Digest: map[string]string{"sha256": hex.EncodeToString(b)}})
+	}
+	if o.Lockfile != "" {
+		var locks []string
+		for _, arch := range ic.Archs {
+			if p := pkglock.ForArch(o.Lockfile, arch); !slices.Contains(locks, p) {
+				locks = append(locks, p)
+			}
+		}
+		for _, p := range locks {
+			d, err := lockDigest(p)
+			if err != nil {
+				return nil, err
+			}
+			deps = append(deps, ResourceDescriptor{Name: "lock", URI: p, Digest: map[string]string{"sha256": strings.TrimPrefix(d, "sha256:")}})
+		}
+	}
+	for _, arch := range slices.Sorted(maps.Keys(packages)) {
+		for _, pkg := range packages[arch] {
+			// Packages are identified by their control checksum, the SHA-1
+			// that APKINDEX and lock files record, as input policies match
+			// them, and by the SHA-256 of their data section when the lock
+			// records it.
+			sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pkg.Checksum, "Q1"))
+			if err != nil || !strings.HasPrefix(pkg.Checksum, "Q1") {
+				return nil, fmt.Errorf("package %s has no control checksum", pkg.Name)
+			}
+			digest := map[string]string{"sha1": hex.EncodeToString(sum)}
+			if data, ok := strings.CutPrefix(pkg.Data.Checksum, "sha256-"); ok {
+				sum, err := base64.StdEncoding.DecodeString(data)
+				if err != nil {
+					return nil, fmt.Errorf("decoding data checksum of package %s: %w", pkg.Name, err)
+				}
+				digest["sha256"] = hex.EncodeToString(sum)
+			}
+			deps = append(deps, ResourceDescriptor{
+				Name:   pkg.Name,
+				URI:    redactURL(pkg.URL),
+				Digest: digest,
+				Annotations: map[string]string{
+					"arch":    pkg.Architecture,
+					"version": pkg.Version,
+				},
+			})
+		}
+	}
+
+	builder := ProvenanceBuilder{
+		ID:      DefaultBuilderID,
+		Version: map[string]string{"apko": version.GetVersionInfo().GitVersion},
+	}
+	if o.BuilderID != "" {
+		builder.ID = o.BuilderID
+		if o.BuilderVersion != "" {
+			builder.Version[o.BuilderID] = o.BuilderVersion
+		}
+	}
+
+	return &ProvenanceStatement{
+		Type:          InTotoStatementType,
+		Subject:       subjects,
+		PredicateType: SLSAProvenanceType,
+		Predicate: SLSAProvenance{
+			BuildDefinition: BuildDefinition{
+				BuildType:            ProvenanceBuildType,
+				ExternalParameters:   params,
+				ResolvedDependencies: deps,
+			},
+			RunDetails: RunDetails{Builder: builder},
+		},
+	}, nil
+}
+
+// WriteFile writes the statement to path, or, if keyPath is set, a DSSE
+// envelope of it signed with the PEM encoded private key at keyPath, drawing
+// the randomness of the signature from entropy.
+func (p *ProvenanceStatement) WriteFile(path, keyPath string, entropy io.Reader) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if keyPath != "" {
+		if b, err = signStatement(b, keyPath, entropy); err != nil {
+			return fmt.Errorf("signing provenance: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing provenance: %w", err)
+	}
+	return nil
+}
+
+// ProvenanceMediaType is the media type of the provenance written with
+// keyPath, a DSSE envelope if it is set and an in-toto statement otherwise.
+func ProvenanceMediaType(keyPath string) string {
+	if keyPath != "" {
+		return dsseEnvelopeType
+	}
+	return inTotoPayloadType
+}
+
+// signStatement returns a DSSE envelope of the in-toto statement, signed with
+// the key at keyPath.
+func signStatement(statement []byte, keyPath string, entropy io.Reader) ([]byte, error) {
+	sig, err := SignPayload(dssePAE(inTotoPayloadType, statement), keyPath, entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	type signature struct {
+		Sig string `json:"sig"`
+	}
+	return json.Marshal(struct {
+		PayloadType string      `json:"payloadType"`
+		Payload     string      `json:"payload"`
+		Signatures  []signature `json:"signatures"`
+	}{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  []signature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+}
+
+// SignPayload signs payload with the PEM encoded private key at keyPath, as
+// cosign does: Ed25519 keys sign it as is, others its SHA-256 digest. The
+// randomness of the signature is drawn from entropy.
+func SignPayload(payload []byte, keyPath string, entropy io.Reader) ([]byte, error) {
+	signer, err := loadSigner(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(entropy, payload, crypto.Hash(0))
+	}
+	h := sha256.Sum256(payload)
+	return signer.Sign(entropy, h[:], crypto.SHA256)
+}
+
+// loadSigner reads the PEM encoded ECDSA, RSA or Ed25519 private key at path.
+func loadSigner(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key %s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return signer, nil
+}
+
+// redactURL removes the credentials from a URL, which repository and package
+// URLs may carry.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+func redactURLs(urls []string) []string {
+	var redacted []string
+	for _, u := range urls {
+		redacted = append(redacted, redactURL(u))
+	}
+	return redacted
+}
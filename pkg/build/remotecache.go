@@ -0,0 +1,234 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/errgroup"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+// LayerCacheKeyAnnotation records, on the manifest of a layer cache entry,
+// the diffID and compressor the entry holds the compressed layer for.
+const LayerCacheKeyAnnotation = "dev.chainguard.apko.layer-cache.key"
+
+// registryLayerCache keeps compressed layers in an OCI repository, so that
+// builders without a shared filesystem can share them. Each entry is a
+// single-layer image tagged by a hash of the layer's compressionCache key:
+// its config records the layer's diffID and its one blob is the layer,
+// compressed.
+type registryLayerCache struct {
+	repo     name.Repository
+	opts     []remote.Option
+	readOnly bool
+}
+
+// layerCacheFor returns the registry layer cache configured in o, or nil
+// when there is none.
+func layerCacheFor(o *options.Options) (*registryLayerCache, error) {
+	if o.LayerCache == "" {
+		return nil, nil
+	}
+	repo, err := name.NewRepository(o.LayerCache)
+	if err != nil {
+		return nil, fmt.Errorf("parsing layer cache repository: %w", err)
+	}
+	return &registryLayerCache{repo: repo, opts: o.LayerCacheOptions, readOnly: o.LayerCacheReadOnly}, nil
+}
+
+func (c *registryLayerCache) tag(l *layer) name.Tag {
+	return c.repo.Tag(fmt.Sprintf("layer-%x", sha256.Sum256([]byte(l.cacheKey()))))
+}
+
+// restore fetches the compressed form of l from the cache, and reports
+// whether the cache had it.
+func (c *registryLayerCache) restore(ctx context.Context, l *layer) (bool, error) {
+	tag := c.tag(l)
+	img, err := remote.Image(tag, append(c.opts, remote.WithContext(ctx))...)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return false, err
+	}
+	if len(layers) != 1 {
+		return false, fmt.Errorf("%s has %d layers, expected 1", tag, len(layers))
+	}
+	cached := layers[0]
+	diffID, err := cached.DiffID()
+	if err != nil {
+		return false, err
+	}
+	if diffID != *l.diffid {
+		return false, fmt.Errorf("%s holds layer %s, expected %s", tag, diffID, l.diffid)
+	}
+	digest, err := cached.Digest()
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.compressed != "" {
+		return true, nil
+	}
+
+	// The remote layer verifies its digest as it is read, and what it
+	// decompresses to is checked against the diffID of l, as the cache
+	// entry's config could claim any diffID.
+	rc, err := cached.Compressed()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	out, err := os.Create(l.uncompressed + ".gz")
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+	size, err := fetchVerified(out, rc, l)
+	if err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return false, fmt.Errorf("fetching %s from %s: %w", digest, tag, err)
+	}
+	if err := out.Close(); err != nil {
+		return false, err
+	}
+
+	l.desc.Digest = digest
+	l.desc.Size = size
+	descCopy := *l.desc
+	compressionCache.Store(l.cacheKey(), &descCopy)
+	l.compressed = out.Name()
+	return true, nil
+}
+
+// fetchVerified copies the compressed layer in rc to w, failing if it does
+// not decompress to the diffID of l. It returns the number of bytes copied.
+func fetchVerified(w io.Writer, rc io.Reader, l *layer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tee := io.TeeReader(rc, cw)
+	zr, err := l.compressor.reader(tee)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, zr); err != nil {
+		return 0, fmt.Errorf("decompressing: %w", err)
+	}
+	// Copy whatever the decompressor left unread.
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return 0, err
+	}
+	if got := fmt.Sprintf("%x", h.Sum(nil)); got != l.diffid.Hex {
+		return 0, fmt.Errorf("layer decompresses to sha256:%s, expected %s", got, l.diffid)
+	}
+	return cw.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// save adds l to the cache, compressing it if that has not happened yet.
+func (c *registryLayerCache) save(ctx context.Context, l *layer) error {
+	img, err := mutate.AppendLayers(mutate.MediaType(empty.Image, v1types.OCIManifestSchema1), l)
+	if err != nil {
+		return err
+	}
+	img = mutate.ConfigMediaType(img, v1types.OCIConfigJSON)
+	img = mutate.Annotations(img, map[string]string{LayerCacheKeyAnnotation: l.cacheKey()}).(v1.Image)
+	return remote.Write(c.tag(l), img, append(c.opts, remote.WithContext(ctx))...)
+}
+
+// compressLayers compresses layers concurrently. With a registry layer cache,
+// layers it has are fetched instead, and those it does not are added to it
+// unless it is read-only.
+// The cache is best effort: failing to use it only logs a warning.
+func compressLayers(ctx context.Context, o *options.Options, layers []v1.Layer) error {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "compressLayers")
+	defer span.End()
+
+	cache, err := layerCacheFor(o)
+	if err != nil {
+		return err
+	}
+
+	// Each layer is compressed on several goroutines already, so only as
+	// many are compressed at once as keep the cores busy.
+	var g errgroup.Group
+	g.SetLimit(compressorFor(o).jobs())
+	for _, l := range layers {
+		g.Go(func() error {
+			ll, ok := l.(*layer)
+			if cache == nil || !ok {
+				_, err := l.Digest()
+				return err
+			}
+
+			hit, err := cache.restore(ctx, ll)
+			if err != nil {
+				log.Warnf("fetching layer %s from the layer cache: %v", ll.diffid, err)
+			}
+			if hit {
+				log.Debugf("layer %s restored from the layer cache", ll.diffid)
+				return nil
+			}
+			if _, err := ll.Digest(); err != nil {
+				return err
+			}
+			if cache.readOnly {
+				return nil
+			}
+			if err := cache.save(ctx, ll); err != nil {
+				log.Warnf("adding layer %s to the layer cache: %v", ll.diffid, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
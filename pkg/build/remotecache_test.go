@@ -0,0 +1,146 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/options"
+)
+
+func TestRegistryLayerCache(t *testing.T) {
+	ctx := context.Background()
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	o := &options.Options{LayerCache: u.Host + "/cache", Compressor: CompressorGzip}
+
+	newLayer := func() *layer {
+		f, err := os.Create(filepath.Join(t.TempDir(), "layer.tar"))
+		require.NoError(t, err)
+		defer f.Close()
+		lw := newLayerWriter(f, compressorFor(o))
+		require.NoError(t, lw.w.WriteHeader(&tar.Header{Name: "hello", Mode: 0o644, Size: 5, Typeflag: tar.TypeReg}))
+		_, err = lw.w.Write([]byte("hello"))
+		require.NoError(t, err)
+		l, err := lw.finalize()
+		require.NoError(t, err)
+		return l
+	}
+
+	// The first build compresses the layer and fills the cache.
+	first := newLayer()
+	require.NoError(t, compressLayers(ctx, o, []v1.Layer{first}))
+	require.Equal(t, first.uncompressed+".gz", first.compressed)
+
+	cache, err := layerCacheFor(o)
+	require.NoError(t, err)
+	img, err := remote.Image(cache.tag(first))
+	require.NoError(t, err)
+	m, err := img.Manifest()
+	require.NoError(t, err)
+	require.Equal(t, first.cacheKey(), m.Annotations[LayerCacheKeyAnnotation])
+	require.Len(t, m.Layers, 1)
+	require.Equal(t, first.desc.Digest, m.Layers[0].Digest)
+
+	// A later build on another machine, where the in-process cache is
+	// empty, fetches it instead.
+	compressionCache.Delete(first.cacheKey())
+	second := newLayer()
+	hit, err := cache.restore(ctx, second)
+	require.NoError(t, err)
+	require.True(t, hit)
+	require.Equal(t, first.desc.Digest, second.desc.Digest)
+	require.Equal(t, first.desc.Size, second.desc.Size)
+
+	want, err := os.ReadFile(first.compressed)
+	require.NoError(t, err)
+	got, err := os.ReadFile(second.compressed)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	// Layers compressed differently are cached apart.
+	o.CompressionLevel = 1
+	other := newLayer()
+	hit, err = cache.restore(ctx, other)
+	require.NoError(t, err)
+	require.False(t, hit)
+
+	// A read-only cache compresses the layers it misses without pushing
+	// them to it.
+	o.LayerCacheReadOnly = true
+	require.NoError(t, compressLayers(ctx, o, []v1.Layer{other}))
+	require.Equal(t, other.uncompressed+".gz", other.compressed)
+	_, err = remote.Image(cache.tag(other))
+	require.ErrorContains(t, err, "MANIFEST_UNKNOWN")
+	o.LayerCacheReadOnly = false
+
+	// An entry whose blob does not decompress to the diffID its config
+	// claims is not used, and the layer is compressed here instead.
+	o.CompressionLevel = 2
+	poisoned := newLayer()
+	bad, err := random.Layer(64, v1types.OCILayer)
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteLayer(cache.repo, bad))
+	cfg, err := json.Marshal(v1.ConfigFile{RootFS: v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{*poisoned.diffid}}})
+	require.NoError(t, err)
+	cfgLayer := static.NewLayer(cfg, v1types.OCIConfigJSON)
+	require.NoError(t, remote.WriteLayer(cache.repo, cfgLayer))
+	cfgDesc, err := partial.Descriptor(cfgLayer)
+	require.NoError(t, err)
+	badDesc, err := partial.Descriptor(bad)
+	require.NoError(t, err)
+	manifest, err := json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     v1types.OCIManifestSchema1,
+		Config:        *cfgDesc,
+		Layers:        []v1.Descriptor{*badDesc},
+	})
+	require.NoError(t, err)
+	require.NoError(t, remote.Put(cache.tag(poisoned), rawManifest(manifest)))
+
+	hit, err = cache.restore(ctx, poisoned)
+	require.ErrorContains(t, err, "layer decompresses to")
+	require.False(t, hit)
+	require.Empty(t, poisoned.compressed)
+	require.NoError(t, compressLayers(ctx, o, []v1.Layer{poisoned}))
+	require.Equal(t, poisoned.uncompressed+".gz", poisoned.compressed)
+	badDigest, err := bad.Digest()
+	require.NoError(t, err)
+	require.NotEqual(t, badDigest, poisoned.desc.Digest)
+}
+
+// rawManifest is a manifest pushed as it is.
+type rawManifest []byte
+
+func (m rawManifest) RawManifest() ([]byte, error) { return m, nil }
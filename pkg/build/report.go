@@ -0,0 +1,154 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/chainguard-dev/clog"
+	"golang.org/x/sync/errgroup"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/tarfs"
+)
+
+// BuildReport summarizes the outcome of a multi-architecture build.
+type BuildReport struct {
+	// Compressor is the compression the layers were built with, as
+	// described by LayerCompressor, since changing it changes the digests.
+	Compressor string       `json:"compressor,omitempty"`
+	Archs      []ArchReport `json:"archs"`
+}
+
+// ArchReport is the outcome of building one architecture.
+type ArchReport struct {
+	Arch string `json:"arch"`
+	// Built is true if an image was produced for the architecture.
+	Built bool `json:"built"`
+	// Digest is the digest of the architecture's image, if it was built.
+	Digest string `json:"digest,omitempty"`
+	// Reason explains why the architecture was skipped.
+	Reason string `json:"reason,omitempty"`
+	// Indexes are the repository indexes the packages were resolved
+	// against, so that auditors can check that the snapshots used were not
+	// tampered with.
+	Indexes []apk.IndexDigest `json:"indexes,omitempty"`
+	// ChecksumIncidents are the package downloads that failed checksum
+	// verification, and the mirrors a valid copy was fetched from instead.
+	ChecksumIncidents []apk.ChecksumIncident `json:"checksumIncidents,omitempty"`
+	// Labels are the labels set from the configuration, as rendered for the
+	// architecture.
+	Labels map[string]string `json:"labels,omitempty"`
+	// RebuildAfter is when the image should be rebuilt, if the
+	// configuration sets rebuild-after.
+	RebuildAfter string `json:"rebuildAfter,omitempty"`
+}
+
+// WriteFile writes the report to path as JSON.
+func (r *BuildReport) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling build report: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing build report: %w", err)
+	}
+	return nil
+}
+
+// BatchReport summarizes the outcome of building many configurations at once.
+type BatchReport struct {
+	Images []BatchResult `json:"images"`
+}
+
+// BatchResult is the outcome of building one configuration of a batch.
+type BatchResult struct {
+	Config string `json:"config"`
+	// Tag is the tag the image was built with.
+	Tag string `json:"tag"`
+	// Output is where the image was written.
+	Output string `json:"output,omitempty"`
+	// Digest is the digest of the image index, if it was built.
+	Digest string `json:"digest,omitempty"`
+	// Error explains why the build failed.
+	Error string `json:"error,omitempty"`
+	// Seconds is how long the build took.
+	Seconds float64 `json:"seconds"`
+}
+
+// WriteFile writes the report to path as JSON.
+func (r *BatchReport) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling batch report: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing batch report: %w", err)
+	}
+	return nil
+}
+
+// ResolvableArchs resolves the packages of ic separately for each of its
+// architectures. It returns the architectures that resolve on their own,
+// sorted rather than in the order of ic, along with the resolution error for
+// each one that does not.
+//
+// This backs best-effort builds: a package that is unavailable on one
+// architecture would otherwise be disqualified on every architecture by the
+// cross-architecture solver, failing the whole build.
+func ResolvableArchs(ctx context.Context, ic types.ImageConfiguration, opts ...Option) ([]types.Architecture, map[types.Architecture]error, error) {
+	_, input, err := lockInput(ic, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		g        errgroup.Group
+		mu       sync.Mutex
+		ok       []types.Architecture
+		failures = map[types.Architecture]error{}
+	)
+	for _, arch := range input.Archs {
+		g.Go(func() error {
+			bc, err := New(ctx, tarfs.New(), append(slices.Clone(opts), WithImageConfiguration(*input), WithArch(arch))...)
+			if err != nil {
+				return err
+			}
+			_, _, rerr := bc.apk.ResolveWorld(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if rerr != nil {
+				failures[arch] = rerr
+				clog.FromContext(ctx).Warnf("skipping %s: %v", arch, rerr)
+			} else {
+				ok = append(ok, arch)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	slices.Sort(ok)
+	return ok, failures, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestResolvableArchs(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []build.Option{build.WithConfig("apko.yaml", []string{"testdata"})}
+	_, ic, err := build.NewOptions(opts...)
+	require.NoError(t, err)
+
+	// The testdata repository has no armv7 index.
+	ic.Archs = []types.Architecture{types.ParseArchitecture("x86_64"), types.ParseArchitecture("aarch64"), types.ParseArchitecture("armv7")}
+
+	ok, skipped, err := build.ResolvableArchs(ctx, *ic, opts...)
+	require.NoError(t, err)
+	require.Equal(t, []types.Architecture{types.ParseArchitecture("x86_64"), types.ParseArchitecture("aarch64")}, ok)
+	require.Len(t, skipped, 1)
+	require.Contains(t, skipped, types.ParseArchitecture("armv7"))
+}
+
+func TestBuildReportWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	want := build.BuildReport{Archs: []build.ArchReport{
+		{Arch: "amd64", Built: true, Digest: "sha256:abc", Indexes: []apk.IndexDigest{
+			{URL: "https://packages.wolfi.dev/os/x86_64/APKINDEX.tar.gz", Digest: "sha256:def"},
+		}},
+		{Arch: "arm/v7", Reason: "package foo not found"},
+	}}
+	require.NoError(t, want.WriteFile(path))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var got build.BuildReport
+	require.NoError(t, json.Unmarshal(b, &got))
+	require.Equal(t, want, got)
+}
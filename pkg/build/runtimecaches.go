@@ -0,0 +1,250 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// A runtimeCacheGenerator regenerates a cache that packages would otherwise
+// build in a trigger, from the files installed in the rootfs. Generators run
+// the usual tool on the host against a copy of its inputs, and do nothing if
+// those inputs are not installed. Their temporary files are created through
+// o.
+type runtimeCacheGenerator func(ctx context.Context, fsys apkfs.FullFS, arch types.Architecture, o *options.Options) error
+
+var runtimeCaches = map[string]runtimeCacheGenerator{
+	"glib-schemas": compileGlibSchemas,
+	"fontconfig":   generateFontconfigCache,
+}
+
+// RuntimeCaches lists the names accepted in the runtime-caches configuration.
+func RuntimeCaches() []string {
+	names := make([]string, 0, len(runtimeCaches))
+	for name := range runtimeCaches {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// runtimeCache returns the generator of the runtime cache name.
+func runtimeCache(name string) (runtimeCacheGenerator, error) {
+	generate, ok := runtimeCaches[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown runtime cache %q, must be one of %v", name, RuntimeCaches())
+	}
+	return generate, nil
+}
+
+// checkRuntimeCaches fails if the image configuration asks for a runtime
+// cache that is not known, before anything is installed.
+func (bc *Context) checkRuntimeCaches() error {
+	for _, name := range bc.ic.RuntimeCaches {
+		if _, err := runtimeCache(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateRuntimeCaches generates the caches the image configuration asks for.
+func (bc *Context) generateRuntimeCaches(ctx context.Context) error {
+	for _, name := range bc.ic.RuntimeCaches {
+		generate, err := runtimeCache(name)
+		if err != nil {
+			return err
+		}
+		ctx, span := otel.Tracer("apko").Start(ctx, "generateRuntimeCache:"+name)
+		err = generate(ctx, bc.fs, bc.Arch(), &bc.o)
+		span.End()
+		if err != nil {
+			return fmt.Errorf("generating %s cache: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// compileGlibSchemas compiles the GSettings schemas, as the
+// glib-compile-schemas trigger does. GVDB files are readable in either byte
+// order, so a host compiler serves every architecture.
+func compileGlibSchemas(ctx context.Context, fsys apkfs.FullFS, _ types.Architecture, o *options.Options) error {
+	const dir = "usr/share/glib-2.0/schemas"
+	if _, err := fsys.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	tmp, err := o.MkdirTemp("", "apko-glib-schemas-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "schemas")
+	if err := copyToHost(fsys, dir, src, func(p string) bool {
+		return strings.HasSuffix(p, ".xml") || strings.HasSuffix(p, ".override")
+	}); err != nil {
+		return err
+	}
+	out := filepath.Join(tmp, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		return err
+	}
+	if err := runHostTool(ctx, nil, "glib-compile-schemas", "--targetdir", out, src); err != nil {
+		return err
+	}
+	return copyFromHost(fsys, out, dir, true)
+}
+
+// generateFontconfigCache builds the fontconfig cache, as fc-cache does in
+// the fontconfig trigger. Cache files are specific to the word size and byte
+// order of the machine that writes them, so the host must match the target.
+// Directory times are pinned to the build date so that the cache, which
+// records them, is reproducible.
+func generateFontconfigCache(ctx context.Context, fsys apkfs.FullFS, arch types.Architecture, o *options.Options) error {
+	if _, err := fsys.Stat("etc/fonts/fonts.conf"); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if host := types.ParseArchitecture(runtime.GOARCH); arch != host {
+		return fmt.Errorf("fontconfig caches can only be generated for the host architecture %s, not %s", host, arch)
+	}
+
+	tmp, err := o.MkdirTemp("", "apko-fontconfig-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, dir := range []string{"etc/fonts", "usr/share/fonts"} {
+		if err := copyToHost(fsys, dir, filepath.Join(tmp, dir), nil); err != nil {
+			return err
+		}
+	}
+	epoch := o.SourceDateEpoch
+	if err := filepath.WalkDir(tmp, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(p, epoch, epoch)
+	}); err != nil {
+		return err
+	}
+
+	env := []string{fmt.Sprintf("SOURCE_DATE_EPOCH=%d", epoch.Unix())}
+	if err := runHostTool(ctx, env, "fc-cache", "--sysroot", tmp, "--system-only", "--force"); err != nil {
+		return err
+	}
+	const cacheDir = "var/cache/fontconfig"
+	return copyFromHost(fsys, filepath.Join(tmp, cacheDir), cacheDir, true)
+}
+
+// runHostTool runs a tool from the host's PATH.
+func runHostTool(ctx context.Context, env []string, name string, args ...string) error {
+	p, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("%s is needed on the host: %w", name, err)
+	}
+	cmd := exec.CommandContext(ctx, p, args...)
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w: %s", name, err, strings.TrimSpace(out.String()))
+	}
+	clog.FromContext(ctx).Debugf("%s: %s", name, out.String())
+	return nil
+}
+
+// copyToHost copies the regular files under dir in fsys, for which keep
+// returns true, into the host directory dst. A nil keep copies every file.
+// Symlinks to regular files are copied as the files they point at, since
+// their targets may lie outside dir.
+func copyToHost(fsys apkfs.FullFS, dir, dst string, keep func(string) bool) error {
+	return fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == dir {
+				return nil
+			}
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(p, dir)))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if keep != nil && !keep(p) {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			fi, err := fsys.Stat(p)
+			if err != nil || !fi.Mode().IsRegular() {
+				return nil
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		b, err := fsys.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0o644)
+	})
+}
+
+// copyFromHost copies the regular files under the host directory src into
+// dir in fsys. Files already in fsys are kept unless overwrite is set.
+func copyFromHost(fsys apkfs.FullFS, src, dir string, overwrite bool) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(dir, filepath.ToSlash(rel))
+		if _, err := fsys.Stat(target); err == nil && !overwrite {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if err := fsys.MkdirAll(path.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return fsys.WriteFile(target, b, 0o644)
+	})
+}
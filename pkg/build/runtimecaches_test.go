@@ -0,0 +1,72 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+)
+
+const testSchema = `<schemalist>
+  <schema id="org.example.test" path="/org/example/test/">
+    <key name="greeting" type="s">
+      <default>'hello'</default>
+    </key>
+  </schema>
+</schemalist>
+`
+
+func TestCompileGlibSchemas(t *testing.T) {
+	if _, err := exec.LookPath("glib-compile-schemas"); err != nil {
+		t.Skip("no glib-compile-schemas on the host")
+	}
+
+	compile := func() []byte {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, fsys.MkdirAll("usr/share/glib-2.0/schemas", 0o755))
+		require.NoError(t, fsys.WriteFile("usr/share/glib-2.0/schemas/org.example.test.gschema.xml", []byte(testSchema), 0o644))
+		require.NoError(t, compileGlibSchemas(t.Context(), fsys, types.ParseArchitecture("amd64"), &options.Options{SourceDateEpoch: time.Unix(0, 0)}))
+
+		b, err := fsys.ReadFile("usr/share/glib-2.0/schemas/gschemas.compiled")
+		require.NoError(t, err)
+		return b
+	}
+	require.Equal(t, compile(), compile())
+}
+
+func TestGenerateRuntimeCaches(t *testing.T) {
+	// Nothing to do when the inputs are not installed.
+	bc := &Context{fs: apkfs.NewMemFS()}
+	bc.ic.RuntimeCaches = RuntimeCaches()
+	require.NoError(t, bc.checkRuntimeCaches())
+	require.NoError(t, bc.generateRuntimeCaches(t.Context()))
+
+	bc.ic.RuntimeCaches = []string{"ldconfig"}
+	require.ErrorContains(t, bc.checkRuntimeCaches(), `unknown runtime cache "ldconfig"`)
+}
+
+func TestNewOptionsRuntimeCaches(t *testing.T) {
+	// An unknown cache fails when the configuration is applied, rather
+	// than once its packages are installed.
+	_, _, err := NewOptions(WithImageConfiguration(types.ImageConfiguration{RuntimeCaches: []string{"ldconfig"}}))
+	require.ErrorContains(t, err, `unknown runtime cache "ldconfig"`)
+}
@@ -63,6 +63,8 @@ func newSBOM(ctx context.Context, fsys apkfs.FullFS, o options.Options, ic types
 	sopt.Formats = o.SBOMFormats
 	sopt.ImageInfo.VCSUrl = ic.VCSUrl
 	sopt.ImageInfo.ImageMediaType = ggcrtypes.OCIManifestSchema1
+	sopt.Builder = soptions.Builder{ID: o.BuilderID, Version: o.BuilderVersion}
+	sopt.FileFilters = ic.Contents.Filters
 
 	sopt.OutputDir = o.TempDir()
 	if o.SBOMPath != "" {
@@ -72,36 +74,55 @@ func newSBOM(ctx context.Context, fsys apkfs.FullFS, o options.Options, ic types
 	return sopt
 }
 
-func (bc *Context) GenerateImageSBOM(ctx context.Context, arch types.Architecture, img v1.Image) ([]types.SBOM, error) {
-	log := clog.FromContext(ctx).With("arch", arch.ToAPK())
-	ctx = clog.WithLogger(ctx, log)
-
-	_, span := otel.Tracer("apko").Start(ctx, "GenerateImageSBOM")
-	defer span.End()
+// sbomPrep is the part of generating the image SBOMs that needs neither the
+// image layers nor its digest.
+type sbomPrep struct {
+	done       chan struct{}
+	s          soptions.Options
+	generators map[string]generator.Generator
+	err        error
+}
 
-	if !bc.WantSBOM() {
-		log.Warnf("skipping SBOM generation")
-		return nil, nil
+// prepareImageSBOM gathers the SBOM inputs and starts the generators that
+// implement generator.Preparer in the background, for GenerateImageSBOM to
+// finish once the image is assembled.
+func (bc *Context) prepareImageSBOM(ctx context.Context) *sbomPrep {
+	p := &sbomPrep{done: make(chan struct{})}
+	p.s, p.err = bc.sbomInputs(ctx)
+	if p.err != nil {
+		close(p.done)
+		return p
 	}
+	p.generators = generator.Generators(bc.fs)
+	go func() {
+		defer close(p.done)
+		for _, format := range p.s.Formats {
+			gen, ok := p.generators[format].(generator.Preparer)
+			if !ok {
+				continue
+			}
+			if err := gen.Prepare(ctx, &p.s); err != nil {
+				p.err = fmt.Errorf("preparing %s sbom: %w", format, err)
+				return
+			}
+		}
+	}()
+	return p
+}
 
+// sbomInputs reads what goes into the SBOMs, other than the image layers
+// and digest, from the image filesystem.
+func (bc *Context) sbomInputs(ctx context.Context) (soptions.Options, error) {
 	bde, err := bc.GetBuildDateEpoch()
 	if err != nil {
-		return nil, fmt.Errorf("computing build date epoch: %w", err)
-	}
-
-	m, err := img.Manifest()
-	if err != nil {
-		return nil, fmt.Errorf("getting %s manifest: %w", arch, err)
+		return soptions.Options{}, fmt.Errorf("computing build date epoch: %w", err)
 	}
 
 	s := newSBOM(ctx, bc.fs, bc.o, bc.ic, bde)
-	log.Debug("Generating image SBOM")
-
-	s.ImageInfo.Layers = m.Layers
 
 	info, err := fetchFSReleaseData(bc.fs)
 	if err != nil {
-		return nil, fmt.Errorf("reading release data: %w", err)
+		return soptions.Options{}, fmt.Errorf("reading release data: %w", err)
 	}
 
 	s.OS.Name = info.Name
@@ -110,10 +131,50 @@ func (bc *Context) GenerateImageSBOM(ctx context.Context, arch types.Architectur
 
 	pkgs, err := bc.apk.GetInstalled()
 	if err != nil {
-		return nil, fmt.Errorf("reading apk package index: %w", err)
+		return soptions.Options{}, fmt.Errorf("reading apk package index: %w", err)
 	}
 
 	s.Packages = pkgs
+	return s, nil
+}
+
+// GenerateImageSBOM writes the SBOMs of img. It finishes the work started by
+// BuildLayers, if any, and otherwise does all of it.
+func (bc *Context) GenerateImageSBOM(ctx context.Context, arch types.Architecture, img v1.Image) ([]types.SBOM, error) {
+	log := clog.FromContext(ctx).With("arch", arch.ToAPK())
+	ctx = clog.WithLogger(ctx, log)
+
+	_, span := otel.Tracer("apko").Start(ctx, "GenerateImageSBOM")
+	defer span.End()
+
+	if !bc.WantSBOM() {
+		log.Warnf("skipping SBOM generation")
+		return nil, nil
+	}
+
+	p := bc.sbomPrep
+	bc.sbomPrep = nil
+	if p == nil {
+		p = bc.prepareImageSBOM(ctx)
+	}
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	s, generators := p.s, p.generators
+
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("getting %s manifest: %w", arch, err)
+	}
+
+	log.Debug("Generating image SBOM")
+
+	s.ImageInfo.Layers = m.Layers
 
 	// Get the image digest
 	h, err := img.Digest()
@@ -124,8 +185,11 @@ func (bc *Context) GenerateImageSBOM(ctx context.Context, arch types.Architectur
 	s.ImageInfo.ImageDigest = h.String()
 	s.ImageInfo.Arch = arch
 
+	if err := generator.Enrich(ctx, &s); err != nil {
+		return nil, err
+	}
+
 	var sboms = make([]types.SBOM, 0)
-	generators := generator.Generators(bc.fs)
 	for _, format := range s.Formats {
 		gen, ok := generators[format]
 		if !ok {
@@ -238,6 +302,10 @@ func GenerateIndexSBOM(ctx context.Context, o options.Options, ic types.ImageCon
 		return archs[i].String() < archs[j].String()
 	})
 
+	if err := generator.Enrich(ctx, &s); err != nil {
+		return nil, err
+	}
+
 	generators := generator.Generators(nil)
 	var sboms = make([]types.SBOM, 0, len(generators))
 	for _, format := range s.Formats {
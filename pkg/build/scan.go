@@ -0,0 +1,115 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+)
+
+// Scanner inspects the finished image filesystem before its layers are
+// written, e.g. to look for malware.
+type Scanner interface {
+	// Scan returns what it finds objectionable in fsys. An error means
+	// the scan itself failed, and always fails the build.
+	Scan(ctx context.Context, fsys fs.FS) ([]Finding, error)
+}
+
+// Finding is something a Scanner reports about a file.
+type Finding struct {
+	// Path is the absolute path of the file in the image.
+	Path string
+	// Description says what was found.
+	Description string
+}
+
+func (f Finding) String() string {
+	return f.Path + ": " + f.Description
+}
+
+// FileScanner is a Scanner that streams each regular file in the filesystem
+// through a function, for scanners that look at one file at a time.
+type FileScanner func(ctx context.Context, path string, r io.Reader) ([]Finding, error)
+
+// Scan implements Scanner.
+func (f FileScanner) Scan(ctx context.Context, fsys fs.FS) ([]Finding, error) {
+	var findings []Finding
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		r, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		found, err := f(ctx, "/"+path, r)
+		if err != nil {
+			return fmt.Errorf("scanning /%s: %w", path, err)
+		}
+		findings = append(findings, found...)
+		return nil
+	})
+	return findings, err
+}
+
+// ScanHook is a Scanner run on every image built, along with what to do
+// about its findings.
+type ScanHook struct {
+	// Name identifies the scanner in logs and errors. It is required.
+	Name string
+	// Scanner is the scanner to run.
+	Scanner Scanner
+	// FailOnFindings fails the build if the scanner finds anything.
+	// Otherwise findings are logged as warnings.
+	FailOnFindings bool
+}
+
+// runScanHooks runs each of hooks over fsys in turn.
+func runScanHooks(ctx context.Context, fsys fs.FS, hooks []ScanHook) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "runScanHooks")
+	defer span.End()
+
+	var errs []error
+	for _, h := range hooks {
+		findings, err := h.Scanner.Scan(ctx, fsys)
+		if err != nil {
+			return fmt.Errorf("running scanner %s: %w", h.Name, err)
+		}
+		for _, f := range findings {
+			if h.FailOnFindings {
+				errs = append(errs, fmt.Errorf("scanner %s: %s", h.Name, f))
+				continue
+			}
+			log.Warnf("scanner %s: %s", h.Name, f)
+		}
+		log.Debugf("scanner %s reported %d findings", h.Name, len(findings))
+	}
+	return errors.Join(errs...)
+}
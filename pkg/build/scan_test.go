@@ -0,0 +1,62 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanHooks(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"usr/bin/clean":   {Data: []byte("hello"), Mode: 0o755},
+		"usr/bin/dropper": {Data: []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE"), Mode: 0o755},
+		"usr/bin/link":    {Data: []byte("dropper"), Mode: fs.ModeSymlink},
+		"etc":             {Mode: fs.ModeDir | 0o755},
+	}
+
+	var scanned []string
+	eicar := FileScanner(func(_ context.Context, path string, r io.Reader) ([]Finding, error) {
+		scanned = append(scanned, path)
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(b, []byte("EICAR")) {
+			return []Finding{{Path: path, Description: "EICAR test signature"}}, nil
+		}
+		return nil, nil
+	})
+
+	require.NoError(t, runScanHooks(ctx, fsys, []ScanHook{{Name: "eicar", Scanner: eicar}}))
+	require.Equal(t, []string{"/usr/bin/clean", "/usr/bin/dropper"}, scanned)
+
+	err := runScanHooks(ctx, fsys, []ScanHook{{Name: "eicar", Scanner: eicar, FailOnFindings: true}})
+	require.EqualError(t, err, "scanner eicar: /usr/bin/dropper: EICAR test signature")
+
+	broken := FileScanner(func(context.Context, string, io.Reader) ([]Finding, error) {
+		return nil, errors.New("signatures out of date")
+	})
+	err = runScanHooks(ctx, fsys, []ScanHook{{Name: "broken", Scanner: broken}})
+	require.ErrorContains(t, err, "signatures out of date")
+}
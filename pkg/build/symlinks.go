@@ -0,0 +1,181 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/internal/pathglob"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/options"
+)
+
+// maxSymlinks is how many symlinks resolveInRoot follows before giving up,
+// as the Linux kernel does.
+const maxSymlinks = 40
+
+var errSymlinkLoop = errors.New("too many levels of symbolic links")
+
+// resolveInRoot resolves p one component at a time, following symlinks
+// within fsys, so that absolute targets stay inside the image rather than
+// being looked up on the host. It returns the path p refers to.
+func resolveInRoot(fsys apkfs.FullFS, p string) (string, error) {
+	var (
+		resolved []string
+		todo     = strings.Split(p, "/")
+		links    int
+	)
+	for len(todo) > 0 {
+		c := todo[0]
+		todo = todo[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+
+		cur := path.Join(append(resolved, c)...)
+		target, err := fsys.Readlink(cur)
+		if err != nil {
+			// Not a symlink, or not there at all.
+			if _, err := fsys.Lstat(cur); err != nil {
+				return "", err
+			}
+			resolved = append(resolved, c)
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", errSymlinkLoop
+		}
+		if path.IsAbs(target) {
+			resolved = nil
+		}
+		todo = append(strings.Split(target, "/"), todo...)
+	}
+	if len(resolved) == 0 {
+		return ".", nil
+	}
+	return path.Join(resolved...), nil
+}
+
+// existsInRoot reports whether p exists, following symlinks within fsys.
+func existsInRoot(fsys apkfs.FullFS, p string) bool {
+	_, err := resolveInRoot(fsys, p)
+	return err == nil
+}
+
+// escapesRoot reports whether the relative symlink target, followed from
+// dir, climbs above the root of the image.
+func escapesRoot(dir, target string) bool {
+	depth := 0
+	for _, c := range slices.Concat(strings.Split(dir, "/"), strings.Split(target, "/")) {
+		switch c {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+// badSymlink is a symlink in the image that does not lead anywhere useful.
+type badSymlink struct {
+	path    string
+	target  string
+	problem string
+}
+
+func (s badSymlink) String() string {
+	return fmt.Sprintf("symlink /%s -> %s %s", s.path, s.target, s.problem)
+}
+
+// findBadSymlinks reports the symlinks in fsys whose targets climb out of
+// the root, do not exist, or loop, except those whose paths match one of
+// the allow patterns.
+func findBadSymlinks(fsys apkfs.FullFS, allow []string) ([]badSymlink, error) {
+	var bad []badSymlink
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		if slices.ContainsFunc(allow, func(pattern string) bool { return pathglob.Match(pattern, p) }) {
+			return nil
+		}
+		target, err := fsys.Readlink(p)
+		if err != nil {
+			return err
+		}
+		if !path.IsAbs(target) && escapesRoot(path.Dir(p), target) {
+			bad = append(bad, badSymlink{path: p, target: target, problem: "points outside the image"})
+			return nil
+		}
+		switch _, err := resolveInRoot(fsys, p); {
+		case errors.Is(err, errSymlinkLoop):
+			bad = append(bad, badSymlink{path: p, target: target, problem: "is part of a loop"})
+		case err != nil:
+			bad = append(bad, badSymlink{path: p, target: target, problem: "points to nothing"})
+		}
+		return nil
+	})
+	return bad, err
+}
+
+// checkSymlinks reports the symlinks in the image that point outside it or
+// to nothing, failing the build in options.CheckFail mode.
+func (bc *Context) checkSymlinks(ctx context.Context) error {
+	if bc.o.SymlinkCheck == options.CheckOff {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	_, span := otel.Tracer("apko").Start(ctx, "checkSymlinks")
+	defer span.End()
+
+	bad, err := findBadSymlinks(bc.fs, bc.o.SymlinkAllow)
+	if err != nil {
+		return fmt.Errorf("checking symlinks: %w", err)
+	}
+	if bc.o.SymlinkCheck == options.CheckWarn {
+		for _, s := range bad {
+			log.Warn(s.String())
+		}
+		return nil
+	}
+	errs := make([]error, 0, len(bad))
+	for _, s := range bad {
+		errs = append(errs, errors.New(s.String()))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestFindBadSymlinks(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	for _, dir := range []string{"usr/bin", "usr/lib", "etc/alternatives", "proc"} {
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+	}
+	require.NoError(t, fsys.WriteFile("usr/bin/python3.12", []byte("#!"), 0o755))
+	for target, link := range map[string]string{
+		"usr/lib":                  "lib",
+		"/usr/bin/python3.12":      "etc/alternatives/python",
+		"/etc/alternatives/python": "usr/bin/python",
+		"/lib/../bin/python3.12":   "usr/bin/python3",
+		"/etc/alternatives/java":   "usr/bin/java",
+		"../../../../etc/passwd":   "usr/bin/passwd-escape",
+		"loop-b":                   "usr/bin/loop-a",
+		"loop-a":                   "usr/bin/loop-b",
+		"/proc/self/mounts":        "etc/mtab",
+		"../bin/python3.12":        "usr/lib/python-relative",
+		"/lib/libmissing.so.1.2.3": "usr/lib/libmissing.so.1",
+	} {
+		require.NoError(t, fsys.Symlink(target, link))
+	}
+
+	bad, err := findBadSymlinks(fsys, []string{"/etc/mtab"})
+	require.NoError(t, err)
+
+	got := map[string]string{}
+	for _, s := range bad {
+		got[s.path] = s.problem
+	}
+	require.Equal(t, map[string]string{
+		"usr/bin/java":            "points to nothing",
+		"usr/bin/loop-a":          "is part of a loop",
+		"usr/bin/loop-b":          "is part of a loop",
+		"usr/bin/passwd-escape":   "points outside the image",
+		"usr/lib/libmissing.so.1": "points to nothing",
+	}, got)
+}
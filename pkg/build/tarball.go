@@ -22,29 +22,45 @@ import (
 	"io/fs"
 	"iter"
 	"os"
+	"slices"
+	"time"
 
 	"go.opentelemetry.io/otel"
-	"golang.org/x/sys/unix"
 
+	"chainguard.dev/apko/internal/pathglob"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/options"
 	"chainguard.dev/apko/pkg/passwd"
 )
 
 const xattrTarPAXRecordsPrefix = "SCHILY.xattr."
 
-// writeTar writes a tarball to the provided io.Writer from the provided fs.FS.
-// The etc/passwd and etc/group file provide username and group name mappings for the tar.
-func writeTar(ctx context.Context, tw *tar.Writer, fsys apkfs.FullFS) error { //nolint:gocyclo
-	ctx, span := otel.Tracer("go-apk").Start(ctx, "writeTar")
+// ArchiveWriter writes the entries of an image filesystem to an archive.
+// *tar.Writer is one; writers for other formats translate each header.
+type ArchiveWriter interface {
+	// WriteHeader begins a new entry. The contents of regular files are
+	// then written with Write.
+	WriteHeader(hdr *tar.Header) error
+	io.Writer
+	// Close finishes the archive.
+	Close() error
+}
+
+// writeArchive writes the contents of the provided fs.FS to aw, and closes it.
+// The etc/passwd and etc/group file provide username and group name mappings for the archive.
+// Paths matching one of the exclude patterns are left out. Unless mtime is
+// zero, it is the modification time of every entry.
+func writeArchive(ctx context.Context, aw ArchiveWriter, fsys apkfs.FullFS, idmap options.IDMap, mtime time.Time, exclude []string) error { //nolint:gocyclo
+	ctx, span := otel.Tracer("go-apk").Start(ctx, "writeArchive")
 	defer span.End()
 
 	buf := make([]byte, 1<<20)
 
-	for f, err := range walkFS(ctx, fsys) {
+	for f, err := range walkFS(ctx, fsys, idmap, mtime, exclude) {
 		if err != nil {
 			return err
 		}
-		if err := tw.WriteHeader(f.header); err != nil {
+		if err := aw.WriteHeader(f.header); err != nil {
 			return err
 		}
 
@@ -55,14 +71,14 @@ func writeTar(ctx context.Context, tw *tar.Writer, fsys apkfs.FullFS) error { //
 			}
 			defer data.Close()
 
-			if _, err := io.CopyBuffer(tw, data, buf); err != nil {
+			if _, err := io.CopyBuffer(aw, data, buf); err != nil {
 				return err
 			}
 		}
 	}
 
-	if err := tw.Close(); err != nil {
-		return fmt.Errorf("closing tar writer: %w", err)
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("closing archive writer: %w", err)
 	}
 	return nil
 }
@@ -78,7 +94,17 @@ type file struct {
 	header *tar.Header
 }
 
-func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
+// excluded reports whether name matches one of the exclude patterns, in
+// which "**" matches any number of directories; a pattern matching a
+// directory also matches its contents.
+func excluded(exclude []string, name string) bool {
+	return slices.ContainsFunc(exclude, func(p string) bool { return pathglob.MatchOrParent(p, name) })
+}
+
+// walkFS yields the entries of fsys, but for those matching one of the
+// exclude patterns and the hard links to them. Unless mtime is zero, the
+// entries are given it as their modification time.
+func walkFS(ctx context.Context, fsys apkfs.FullFS, idmap options.IDMap, mtime time.Time, exclude []string) iter.Seq2[*file, error] {
 	return func(yield func(*file, error) bool) {
 		usersFile, _ := passwd.ReadUserFile(fsys, "etc/passwd")
 		groupsFile, _ := passwd.ReadGroupFile(fsys, "etc/group")
@@ -104,6 +130,13 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 				return err
 			}
 
+			if excluded(exclude, path) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
 			info, err := d.Info()
 			if err != nil {
 				return err
@@ -120,14 +153,18 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 			if err != nil {
 				return err
 			}
+			if header.Typeflag == tar.TypeLink && excluded(exclude, header.Linkname) {
+				return nil
+			}
 
-			if info.Mode()&os.ModeCharDevice == os.ModeCharDevice {
+			// Both character and block devices carry ModeDevice.
+			if info.Mode()&os.ModeDevice == os.ModeDevice {
 				dev, err := fsys.Readnod(path)
 				if err != nil {
 					return err
 				}
-				header.Devmajor = int64(unix.Major(uint64(dev)))
-				header.Devminor = int64(unix.Minor(uint64(dev)))
+				header.Devmajor = int64(apkfs.Major(uint64(dev)))
+				header.Devminor = int64(apkfs.Minor(uint64(dev)))
 			}
 
 			// tar.FileInfoHeader sets Name to the base name of the file,
@@ -143,6 +180,9 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 			header.Name = path
 
 			header.ModTime = info.ModTime()
+			if !mtime.IsZero() {
+				header.ModTime = mtime
+			}
 
 			if name, ok := users[header.Uid]; ok {
 				header.Uname = name
@@ -151,6 +191,10 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 				header.Gname = name
 			}
 
+			// Names are looked up above by the IDs the image itself sees.
+			header.Uid = idmap.UID(header.Uid)
+			header.Gid = idmap.GID(header.Gid)
+
 			if link != "" {
 				header.Typeflag = tar.TypeSymlink
 			}
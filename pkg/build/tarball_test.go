@@ -4,11 +4,16 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/tarfs"
 )
 
 func TestWriteTar(t *testing.T) {
@@ -29,7 +34,7 @@ func TestWriteTar(t *testing.T) {
 	err = m.SetXattr(file, "user.file", []byte("bar"))
 	require.NoError(t, err, "error setting xattr on %s", file)
 	tw := tar.NewWriter(&buf)
-	err = writeTar(context.Background(), tw, m)
+	err = writeArchive(context.Background(), tw, m, options.IDMap{}, time.Time{}, nil)
 	require.NoError(t, err, "error writing tar")
 	err = tw.Close()
 	require.NoError(t, err, "error closing tar writer")
@@ -46,3 +51,118 @@ func TestWriteTar(t *testing.T) {
 	require.Equal(t, file, hdr.Name, "tar file header name mismatch")
 	require.Equal(t, "bar", hdr.PAXRecords[xattrTarPAXRecordsPrefix+"user.file"], "tar header for file xattr mismatch")
 }
+
+func TestWriteTarDeviceNodes(t *testing.T) {
+	m := fs.NewMemFS()
+	require.NoError(t, mutatePaths(m, &options.Options{}, &types.ImageConfiguration{
+		Paths: []types.PathMutation{
+			{Path: "dev/null", Type: "char-device", Major: 1, Minor: 3, Permissions: 0o666},
+			{Path: "dev/loop0", Type: "block-device", Major: 7, Minor: 0, Permissions: 0o660},
+			{Path: "run/initctl", Type: "fifo", Permissions: 0o600},
+		},
+	}))
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, writeArchive(context.Background(), tw, m, options.IDMap{}, time.Time{}, nil))
+	require.NoError(t, tw.Close())
+
+	got := map[string]*tar.Header{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got[hdr.Name] = hdr
+	}
+
+	require.Equal(t, byte(tar.TypeChar), got["dev/null"].Typeflag)
+	require.Equal(t, int64(1), got["dev/null"].Devmajor)
+	require.Equal(t, int64(3), got["dev/null"].Devminor)
+	require.Equal(t, int64(0o666), got["dev/null"].Mode&0o777)
+
+	require.Equal(t, byte(tar.TypeBlock), got["dev/loop0"].Typeflag)
+	require.Equal(t, int64(7), got["dev/loop0"].Devmajor)
+	require.Equal(t, int64(0), got["dev/loop0"].Devminor)
+
+	require.Equal(t, byte(tar.TypeFifo), got["run/initctl"].Typeflag)
+	require.Equal(t, int64(0o600), got["run/initctl"].Mode&0o777)
+}
+
+func TestWriteTarIDMap(t *testing.T) {
+	m := fs.NewMemFS()
+	require.NoError(t, m.MkdirAll("home/nonroot", 0o755))
+	require.NoError(t, m.Chown("home/nonroot", 65532, 65532))
+	require.NoError(t, m.WriteFile("etc-file", []byte("x"), 0o644))
+	require.NoError(t, m.WriteFile("unmapped", []byte("x"), 0o644))
+	require.NoError(t, m.Chown("unmapped", 70000, 70000))
+
+	idmap := options.IDMap{
+		UIDs: []options.IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		GIDs: []options.IDMapping{{ContainerID: 0, HostID: 200000, Size: 65536}},
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, writeArchive(context.Background(), tw, m, idmap, time.Time{}, nil))
+	require.NoError(t, tw.Close())
+
+	got := map[string]*tar.Header{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got[hdr.Name] = hdr
+	}
+
+	require.Equal(t, 100000, got["etc-file"].Uid)
+	require.Equal(t, 200000, got["etc-file"].Gid)
+	require.Equal(t, 165532, got["home/nonroot"].Uid)
+	require.Equal(t, 265532, got["home/nonroot"].Gid)
+	require.Equal(t, 70000, got["unmapped"].Uid)
+	require.Equal(t, 70000, got["unmapped"].Gid)
+}
+
+func TestWriteTarExclude(t *testing.T) {
+	m := tarfs.New()
+	for _, dir := range []string{"usr/bin", "usr/share/man/man1", "usr/share/doc/foo"} {
+		require.NoError(t, m.MkdirAll(dir, 0o755))
+	}
+	require.NoError(t, m.WriteFile("usr/bin/foo", []byte("foo"), 0o755))
+	require.NoError(t, m.WriteFile("usr/share/man/man1/foo.1", []byte("foo(1)"), 0o644))
+	require.NoError(t, m.WriteFile("usr/share/doc/foo/README", []byte("readme"), 0o644))
+	require.NoError(t, m.WriteFile("usr/share/doc/foo/LICENSE", []byte("license"), 0o644))
+	// A hard link to an excluded file is left out with it.
+	_, err := m.WriteHeader(tar.Header{Typeflag: tar.TypeLink, Name: "usr/bin/README", Linkname: "usr/share/doc/foo/README"}, nil, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, writeArchive(context.Background(), tw, m, options.IDMap{}, time.Time{}, []string{"usr/share/man/**", "/usr/share/doc/*/README"}))
+	require.NoError(t, tw.Close())
+
+	var got []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, hdr.Name)
+	}
+	require.Equal(t, []string{
+		"usr",
+		"usr/bin",
+		"usr/bin/foo",
+		"usr/share",
+		"usr/share/doc",
+		"usr/share/doc/foo",
+		"usr/share/doc/foo/LICENSE",
+	}, got)
+}
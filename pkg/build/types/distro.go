@@ -0,0 +1,96 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed distros.yaml
+var distrosYAML []byte
+
+// distroProfile is an entry of distros.yaml.
+type distroProfile struct {
+	DefaultVersion string   `yaml:"default-version"`
+	Release        string   `yaml:"release"`
+	Repositories   []string `yaml:"repositories"`
+	Keyring        []string `yaml:"keyring"`
+}
+
+var distroProfiles = sync.OnceValues(func() (map[string]distroProfile, error) {
+	profiles := map[string]distroProfile{}
+	if err := yaml.Unmarshal(distrosYAML, &profiles); err != nil {
+		return nil, fmt.Errorf("parsing distro profiles: %w", err)
+	}
+	return profiles, nil
+})
+
+// Distros returns the names of the known distro profiles. A profile taking a
+// version is listed by its name alone.
+func Distros() []string {
+	profiles, err := distroProfiles()
+	if err != nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(profiles))
+}
+
+// DistroContents returns the repositories and keys of the distro profile
+// named distro, such as "wolfi" or "alpine:3.22".
+func DistroContents(distro string) (ImageContents, error) {
+	profiles, err := distroProfiles()
+	if err != nil {
+		return ImageContents{}, err
+	}
+
+	name, version, versioned := strings.Cut(distro, ":")
+	profile, ok := profiles[distro]
+	if ok {
+		version, versioned = "", false
+	} else if profile, ok = profiles[name]; !ok {
+		return ImageContents{}, fmt.Errorf("unknown distro %q, known distros are: %s", distro, strings.Join(Distros(), ", "))
+	}
+
+	if versioned && !strings.Contains(profile.Release+strings.Join(profile.Repositories, "")+strings.Join(profile.Keyring, ""), "${") {
+		return ImageContents{}, fmt.Errorf("distro %q does not take a version", name)
+	}
+	if !versioned {
+		version = profile.DefaultVersion
+	}
+	release := strings.ReplaceAll(profile.Release, "${version}", version)
+	if profile.Release == "" {
+		release = version
+	}
+	expand := func(urls []string) []string {
+		expanded := make([]string, 0, len(urls))
+		for _, u := range urls {
+			u = strings.ReplaceAll(u, "${release}", release)
+			expanded = append(expanded, strings.ReplaceAll(u, "${version}", version))
+		}
+		return expanded
+	}
+
+	return ImageContents{
+		Repositories: expand(profile.Repositories),
+		Keyring:      expand(profile.Keyring),
+	}, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// loadEnvironmentFiles reads the environment files of ic, in order, into
+// its environment. Variables set inline take precedence over those from the
+// files, and later files take precedence over earlier ones.
+func (ic *ImageConfiguration) loadEnvironmentFiles(includePaths []string, configHasher hash.Hash) error {
+	if len(ic.EnvironmentFiles) == 0 {
+		return nil
+	}
+
+	env := map[string]string{}
+	for _, f := range ic.EnvironmentFiles {
+		data, err := ic.readLocal(f, includePaths)
+		if err != nil {
+			return fmt.Errorf("failed to read environment file: %w", err)
+		}
+		configHasher.Write(data)
+		if err := parseEnvFile(data, env); err != nil {
+			return fmt.Errorf("parsing environment file %s: %w", f, err)
+		}
+	}
+
+	if ic.Environment == nil {
+		ic.Environment = map[string]string{}
+	}
+	for k, v := range env {
+		if _, ok := ic.Environment[k]; !ok {
+			ic.Environment[k] = v
+		}
+	}
+	return nil
+}
+
+// parseEnvFile parses data in dotenv format into env: one KEY=VALUE per
+// line, optionally preceded by "export", with blank lines and lines starting
+// with # ignored. Values may be single-quoted, taken literally, or
+// double-quoted, with Go escape sequences.
+func parseEnvFile(data []byte, env map[string]string) error {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || strings.ContainsAny(k, " \t") {
+			return fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		v = strings.TrimSpace(v)
+		switch {
+		case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+			v = v[1 : len(v)-1]
+		case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+			unquoted, err := strconv.Unquote(v)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			v = unquoted
+		default:
+			// Unquoted values may carry a trailing comment.
+			if i := strings.Index(v, " #"); i >= 0 {
+				v = strings.TrimSpace(v[:i])
+			}
+		}
+		env[k] = v
+	}
+	return s.Err()
+}
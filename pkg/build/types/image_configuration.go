@@ -20,15 +20,21 @@ import (
 	"hash"
 	"maps"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/shlex"
 	"gopkg.in/yaml.v3"
 
 	"github.com/chainguard-dev/clog"
 
+	"chainguard.dev/apko/internal/pathglob"
 	"chainguard.dev/apko/pkg/paths"
 	"chainguard.dev/apko/pkg/vcs"
 )
@@ -49,8 +55,11 @@ func (ic *ImageConfiguration) ProbeVCSUrl(ctx context.Context, imageConfigPath s
 	}
 }
 
-// Parse a configuration blob into an ImageConfiguration struct.
-func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, includePaths []string, configHasher hash.Hash) error {
+// Parse a configuration blob into an ImageConfiguration struct. A remote
+// configuration, fetched by URL or OCI reference, may not refer to local files.
+// chain holds the configurations that led to this one, this one last, so that
+// an include cycle is reported rather than followed forever.
+func (ic *ImageConfiguration) parse(ctx context.Context, f *includeFetcher, configData []byte, includePaths []string, configHasher hash.Hash, remote bool, chain []string) error {
 	log := clog.FromContext(ctx)
 	configHasher.Write(configData)
 	dec := yaml.NewDecoder(strings.NewReader(string(configData)))
@@ -59,13 +68,39 @@ func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, incl
 		return fmt.Errorf("failed to parse image configuration: %w", err)
 	}
 
+	if remote && len(ic.EnvironmentFiles) != 0 {
+		return fmt.Errorf("remote configuration cannot read local environment files")
+	}
+	if local := ic.LocalSources(); remote && len(local) != 0 {
+		return fmt.Errorf("remote configuration cannot refer to local files: %s", strings.Join(local, ", "))
+	}
+	if err := ic.loadEnvironmentFiles(includePaths, configHasher); err != nil {
+		return err
+	}
+
 	if ic.Include != "" {
 		log.Infof("including %s for configuration", ic.Include)
 
 		included := &ImageConfiguration{}
 
-		if err := included.Load(ctx, ic.Include, includePaths, configHasher); err != nil {
-			return fmt.Errorf("failed to read include file: %w", err)
+		switch {
+		case isRemoteInclude(ic.Include):
+			if err := checkIncludeCycle(chain, ic.Include); err != nil {
+				return err
+			}
+			data, err := f.read(ctx, ic.Include)
+			if err != nil {
+				return fmt.Errorf("failed to read include: %w", err)
+			}
+			if err := included.parse(ctx, f, data, includePaths, configHasher, true, slices.Concat(chain, []string{ic.Include})); err != nil {
+				return fmt.Errorf("failed to parse include %s: %w", ic.Include, err)
+			}
+		case remote:
+			return fmt.Errorf("remote configuration cannot include local file %s", ic.Include)
+		default:
+			if err := included.load(ctx, f, ic.Include, includePaths, configHasher, chain); err != nil {
+				return fmt.Errorf("failed to read include file: %w", err)
+			}
 		}
 
 		if err := included.MergeInto(ic); err != nil {
@@ -73,6 +108,38 @@ func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, incl
 		}
 	}
 
+	if ic.Contents.Distro != "" {
+		distro, err := DistroContents(ic.Contents.Distro)
+		if err != nil {
+			return err
+		}
+		// An included configuration may use the same profile.
+		distro.Repositories = slices.DeleteFunc(distro.Repositories, func(repo string) bool {
+			return slices.Contains(ic.Contents.Repositories, repo)
+		})
+		distro.Keyring = slices.DeleteFunc(distro.Keyring, func(key string) bool {
+			return slices.Contains(ic.Contents.Keyring, key)
+		})
+		if err := distro.MergeInto(&ic.Contents); err != nil {
+			return fmt.Errorf("failed to merge distro %s: %w", ic.Contents.Distro, err)
+		}
+	}
+
+	// "host" among the archs builds for the architecture apko runs on.
+	if slices.Contains(ic.Archs, "host") {
+		host := ParseArchitecture(runtime.GOARCH)
+		archs := make([]Architecture, 0, len(ic.Archs))
+		for _, a := range ic.Archs {
+			if a == "host" {
+				a = host
+			}
+			if !slices.Contains(archs, a) {
+				archs = append(archs, a)
+			}
+		}
+		ic.Archs = archs
+	}
+
 	ic.Contents.BuildRepositories = trimRepos(ic.Contents.BuildRepositories)
 	ic.Contents.RuntimeOnlyRepositories = trimRepos(ic.Contents.RuntimeOnlyRepositories)
 	ic.Contents.Repositories = trimRepos(ic.Contents.Repositories)
@@ -82,6 +149,7 @@ func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, incl
 	if ic.Contents.BaseImage != nil {
 		if !cmp.Equal((ImageEntrypoint{}), ic.Entrypoint) ||
 			ic.Cmd != "" ||
+			len(ic.CmdExec) != 0 ||
 			ic.StopSignal != "" ||
 			ic.WorkDir != "" ||
 			!cmp.Equal((ImageAccounts{}), ic.Accounts) ||
@@ -104,13 +172,57 @@ func trimRepos(repos []string) []string {
 	return result
 }
 
+// LocalSources returns the repositories, keys, local paths and base image
+// of ic that are files of the host building it rather than fetched over the
+// network, as they are written in the configuration.
+func (ic *ImageConfiguration) LocalSources() []string {
+	var local []string
+	for _, repo := range slices.Concat(ic.Contents.BuildRepositories, ic.Contents.RuntimeOnlyRepositories, ic.Contents.Repositories) {
+		if !isRemoteSource(repo) {
+			local = append(local, repo)
+		}
+	}
+	keys := slices.Clone(ic.Contents.Keyring)
+	for _, arch := range slices.Sorted(maps.Keys(ic.Contents.ArchKeyring)) {
+		keys = append(keys, ic.Contents.ArchKeyring[arch]...)
+	}
+	for _, key := range keys {
+		if !isRemoteSource(key) {
+			local = append(local, key)
+		}
+	}
+	for _, p := range ic.Paths {
+		if p.Type == "local" {
+			local = append(local, p.Source)
+		}
+	}
+	if ic.Contents.BaseImage != nil {
+		local = append(local, ic.Contents.BaseImage.Image, ic.Contents.BaseImage.APKIndex)
+	}
+	return local
+}
+
+// isRemoteSource reports whether the repository repo, which may be tagged
+// as in "@tag https://...", is fetched by URL rather than read from a
+// directory.
+func isRemoteSource(repo string) bool {
+	if strings.HasPrefix(repo, "@") {
+		if fields := strings.Fields(repo); len(fields) > 1 {
+			repo = fields[1]
+		}
+	}
+	scheme, _, ok := strings.Cut(repo, "://")
+	return ok && scheme != "" && scheme != "file"
+}
+
 // Merge this configuration into the target, with the target taking precedence.
 func (ic *ImageConfiguration) MergeInto(target *ImageConfiguration) error {
 	if reflect.ValueOf(target.Entrypoint).IsZero() {
 		target.Entrypoint = ic.Entrypoint
 	}
-	if target.Cmd == "" {
+	if target.Cmd == "" && len(target.CmdExec) == 0 {
 		target.Cmd = ic.Cmd
+		target.CmdExec = ic.CmdExec
 	}
 	if target.StopSignal == "" {
 		target.StopSignal = ic.StopSignal
@@ -121,9 +233,21 @@ func (ic *ImageConfiguration) MergeInto(target *ImageConfiguration) error {
 	if target.Layering == nil {
 		target.Layering = ic.Layering
 	}
+	if target.Bytecode == nil {
+		target.Bytecode = ic.Bytecode
+	}
+	if target.SBOM == nil {
+		target.SBOM = ic.SBOM
+	}
+	if len(target.RuntimeCaches) == 0 {
+		target.RuntimeCaches = ic.RuntimeCaches
+	}
 	if len(target.Archs) == 0 {
 		target.Archs = ic.Archs
 	}
+	if target.RebuildAfter == "" {
+		target.RebuildAfter = ic.RebuildAfter
+	}
 	if err := ic.Accounts.MergeInto(&target.Accounts); err != nil {
 		return err
 	}
@@ -146,8 +270,18 @@ func (ic *ImageConfiguration) MergeInto(target *ImageConfiguration) error {
 			}
 		}
 	}
+	if target.Labels == nil && ic.Labels != nil {
+		target.Labels = maps.Clone(ic.Labels)
+	} else {
+		for k, v := range ic.Labels {
+			if _, ok := target.Labels[k]; !ok {
+				target.Labels[k] = v
+			}
+		}
+	}
 
 	target.Volumes = slices.Concat(ic.Volumes, target.Volumes)
+	target.Asserts = slices.Concat(ic.Asserts, target.Asserts)
 
 	// Update the contents.
 	return ic.Contents.MergeInto(&target.Contents)
@@ -167,7 +301,32 @@ func (i *ImageContents) MergeInto(target *ImageContents) error {
 	target.BuildRepositories = slices.Concat(i.BuildRepositories, target.BuildRepositories)
 	target.RuntimeOnlyRepositories = slices.Concat(i.RuntimeOnlyRepositories, target.RuntimeOnlyRepositories)
 	target.Repositories = slices.Concat(i.Repositories, target.Repositories)
+	for repo, urls := range i.Mirrors {
+		if _, ok := target.Mirrors[repo]; ok {
+			continue
+		}
+		if target.Mirrors == nil {
+			target.Mirrors = map[string][]string{}
+		}
+		target.Mirrors[repo] = urls
+	}
+	for arch, keys := range i.ArchKeyring {
+		if target.ArchKeyring == nil {
+			target.ArchKeyring = map[string][]string{}
+		}
+		target.ArchKeyring[arch] = slices.Concat(keys, target.ArchKeyring[arch])
+	}
 	target.Packages = slices.Concat(i.Packages, target.Packages)
+	for pkg, filter := range i.Filters {
+		if _, ok := target.Filters[pkg]; ok {
+			continue
+		}
+		if target.Filters == nil {
+			target.Filters = map[string]PackageFilter{}
+		}
+		target.Filters[pkg] = filter
+	}
+	target.Exclude = slices.Concat(i.Exclude, target.Exclude)
 	if target.BaseImage == nil {
 		target.BaseImage = i.BaseImage
 	}
@@ -185,19 +344,53 @@ func (ic *ImageConfiguration) readLocal(imageconfigPath string, includePaths []s
 // Load - loads an image configuration given a configuration file path.
 // Populates configHasher with the configuration data loaded from the imageConfigPath and the other referenced files.
 // You can pass any dummy hasher (like fnv.New32()), if you don't care about the hash of the configuration.
+// opts configure how the remote configurations it includes are fetched.
 //
 // Deprecated: This will be removed in a future release.
-func (ic *ImageConfiguration) Load(ctx context.Context, imageConfigPath string, includePaths []string, configHasher hash.Hash) error {
-	data, err := ic.readLocal(imageConfigPath, includePaths)
+func (ic *ImageConfiguration) Load(ctx context.Context, imageConfigPath string, includePaths []string, configHasher hash.Hash, opts ...LoadOption) error {
+	return ic.load(ctx, newIncludeFetcher(opts...), imageConfigPath, includePaths, configHasher, nil)
+}
+
+// load reads the configuration at imageConfigPath, included by the
+// configurations in chain.
+func (ic *ImageConfiguration) load(ctx context.Context, f *includeFetcher, imageConfigPath string, includePaths []string, configHasher hash.Hash, chain []string) error {
+	resolvedPath, err := paths.ResolvePath(imageConfigPath, includePaths)
+	if err != nil {
+		return err
+	}
+	resolvedPath, err = filepath.Abs(resolvedPath)
 	if err != nil {
 		return err
 	}
+	if err := checkIncludeCycle(chain, resolvedPath); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return err
+	}
+
+	return ic.parse(ctx, f, data, includePaths, configHasher, false, slices.Concat(chain, []string{resolvedPath}))
+}
 
-	return ic.parse(ctx, data, includePaths, configHasher)
+// checkIncludeCycle fails if include is already among the configurations of
+// chain.
+func checkIncludeCycle(chain []string, include string) error {
+	if slices.Contains(chain, include) {
+		return fmt.Errorf("include cycle: %s", strings.Join(append(slices.Clone(chain), include), " -> "))
+	}
+	return nil
 }
 
 // Do preflight checks and mutations on an image configuration.
 func (ic *ImageConfiguration) Validate() error {
+	if err := validateCommand("entrypoint.command", ic.Entrypoint.Command, "entrypoint.exec", ic.Entrypoint.Exec); err != nil {
+		return err
+	}
+	if err := validateCommand("cmd", ic.Cmd, "cmd-exec", ic.CmdExec); err != nil {
+		return err
+	}
+
 	if ic.Entrypoint.Type == "service-bundle" {
 		if err := ic.ValidateServiceBundle(); err != nil {
 			return err
@@ -223,13 +416,73 @@ func (ic *ImageConfiguration) Validate() error {
 			return fmt.Errorf("configured group %v has no configured group name", g)
 		}
 	}
+
+	// Ranges are kept as written, and only split for the resolver.
+	if _, err := ic.Contents.PackageConstraints(); err != nil {
+		return err
+	}
+
+	for arch := range ic.Contents.ArchKeyring {
+		if !slices.Contains(AllArchs, ParseArchitecture(arch)) {
+			return fmt.Errorf("arch_keyring has keys for unknown architecture %q", arch)
+		}
+	}
+
+	for _, p := range ic.Contents.Exclude {
+		if err := pathglob.Validate(p); err != nil {
+			return fmt.Errorf("contents.exclude: %w", err)
+		}
+	}
+
+	if ic.RebuildAfter != "" {
+		if _, err := ParseRebuildAfter(ic.RebuildAfter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCommand checks that the command is given either as the string
+// cmd or in the exec form args, and that cmd splits into arguments, so that
+// unbalanced quotes fail when the configuration is read rather than when the
+// image is built.
+func validateCommand(cmdField, cmd, argsField string, args []string) error {
+	if cmd != "" && len(args) != 0 {
+		return fmt.Errorf("%s and %s cannot be combined", cmdField, argsField)
+	}
+	if _, err := shlex.Split(cmd); err != nil {
+		return fmt.Errorf("splitting %s %q: %w", cmdField, cmd, err)
+	}
 	return nil
 }
 
+// ParseRebuildAfter parses a rebuild-after period: a positive number of
+// days such as "30d", or a duration such as "12h".
+func ParseRebuildAfter(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rebuild-after %q: expected a number of days", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid rebuild-after %q: %w", s, err)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid rebuild-after %q: must be positive", s)
+	}
+	return d, nil
+}
+
 // Do preflight checks and mutations on an image configured to manage
 // a service bundle.
 func (ic *ImageConfiguration) ValidateServiceBundle() error {
 	ic.Entrypoint.Command = "/bin/s6-svscan /sv"
+	ic.Entrypoint.Exec = nil
 
 	// It's harmless to have a duplicate entry in /etc/apk/world,
 	// apk will fix it up when the fixate op happens.
@@ -247,17 +500,26 @@ func (ic *ImageConfiguration) Summarize(ctx context.Context) {
 	log.Infof("    runtime repositories: %v", ic.Contents.RuntimeOnlyRepositories)
 	log.Infof("    repositories: %v", ic.Contents.Repositories)
 	log.Infof("    keyring:      %v", ic.Contents.Keyring)
+	if len(ic.Contents.ArchKeyring) != 0 {
+		log.Infof("    arch keyring: %v", ic.Contents.ArchKeyring)
+	}
 	log.Infof("    packages:     %v", ic.Contents.Packages)
-	if ic.Entrypoint.Type != "" || ic.Entrypoint.Command != "" || len(ic.Entrypoint.Services) != 0 {
+	if ic.Entrypoint.Type != "" || ic.Entrypoint.Command != "" || len(ic.Entrypoint.Exec) != 0 || len(ic.Entrypoint.Services) != 0 {
 		log.Infof("  entrypoint:")
 		log.Infof("    type:    %s", ic.Entrypoint.Type)
 		log.Infof("    command:     %s", ic.Entrypoint.Command)
+		if len(ic.Entrypoint.Exec) != 0 {
+			log.Infof("    exec:    %q", ic.Entrypoint.Exec)
+		}
 		log.Infof("    service: %v", ic.Entrypoint.Services)
 		log.Infof("    shell fragment: %v", ic.Entrypoint.ShellFragment)
 	}
 	if ic.Cmd != "" {
 		log.Infof("  cmd: %s", ic.Cmd)
 	}
+	if len(ic.CmdExec) != 0 {
+		log.Infof("  cmd-exec: %q", ic.CmdExec)
+	}
 	if ic.StopSignal != "" {
 		log.Infof("  stop signal: %s", ic.StopSignal)
 	}
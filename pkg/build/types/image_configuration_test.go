@@ -3,11 +3,25 @@ package types_test
 import (
 	"context"
 	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
 	"github.com/stretchr/testify/require"
 
+	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/build/types"
 )
 
@@ -240,3 +254,231 @@ func TestMergeInto(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvironmentFiles(t *testing.T) {
+	ctx := context.Background()
+
+	ic := types.ImageConfiguration{}
+	require.NoError(t, ic.Load(ctx, filepath.Join("envfile", "envfile.apko.yaml"), []string{"testdata"}, sha256.New()))
+	require.Equal(t, map[string]string{
+		"LANG":      "C.UTF-8",
+		"TZ":        "Europe/London",
+		"GREETING":  "hello\tworld",
+		"LITERAL":   `$HOME\n`,
+		"JAVA_HOME": "/usr/lib/jvm/default-jvm",
+	}, ic.Environment)
+
+	ic = types.ImageConfiguration{}
+	require.ErrorContains(t, ic.Load(ctx, filepath.Join("envfile", "bad.apko.yaml"), []string{"testdata"}, sha256.New()), "line 1: expected KEY=VALUE")
+}
+
+func TestHostArch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "host.apko.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("archs: [host]\n"), 0o644))
+
+	ic := types.ImageConfiguration{}
+	require.NoError(t, ic.Load(ctx, path, nil, sha256.New()))
+	require.Equal(t, []types.Architecture{types.ParseArchitecture(runtime.GOARCH)}, ic.Archs)
+
+	// Alongside other architectures, host stands for the one apko runs on.
+	host := types.ParseArchitecture(runtime.GOARCH)
+	other := types.ParseArchitecture("s390x")
+	if host == other {
+		other = types.ParseArchitecture("riscv64")
+	}
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("archs: [%s, host, %s]\n", other, host)), 0o644))
+	ic = types.ImageConfiguration{}
+	require.NoError(t, ic.Load(ctx, path, nil, sha256.New()))
+	require.Equal(t, []types.Architecture{other, host}, ic.Archs)
+}
+
+func TestDistro(t *testing.T) {
+	ctx := context.Background()
+	load := func(config string) (types.ImageConfiguration, error) {
+		path := filepath.Join(t.TempDir(), "distro.apko.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+		ic := types.ImageConfiguration{}
+		return ic, ic.Load(ctx, path, nil, sha256.New())
+	}
+
+	ic, err := load("contents:\n  distro: wolfi\n  repositories: [https://packages.wolfi.dev/os, ./local]\n")
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://packages.wolfi.dev/os", "./local"}, ic.Contents.Repositories)
+	require.Equal(t, []string{"https://packages.wolfi.dev/os/wolfi-signing.rsa.pub"}, ic.Contents.Keyring)
+
+	ic, err = load("contents:\n  distro: alpine:3.20\n")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://dl-cdn.alpinelinux.org/alpine/v3.20/main",
+		"https://dl-cdn.alpinelinux.org/alpine/v3.20/community",
+	}, ic.Contents.Repositories)
+
+	contents, err := types.DistroContents("alpine:edge")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://dl-cdn.alpinelinux.org/alpine/edge/main",
+		"https://dl-cdn.alpinelinux.org/alpine/edge/community",
+	}, contents.Repositories)
+
+	_, err = load("contents:\n  distro: wolfi:1\n")
+	require.ErrorContains(t, err, `distro "wolfi" does not take a version`)
+	_, err = load("contents:\n  distro: debian\n")
+	require.ErrorContains(t, err, `unknown distro "debian", known distros are: alpine, alpine:edge, wolfi`)
+}
+
+func TestRemoteInclude(t *testing.T) {
+	ctx := context.Background()
+	configs := map[string]string{
+		"/base.yaml":     "contents:\n  packages: [base]\nenvironment:\n  LANG: C.UTF-8\naccounts:\n  run-as: \"65532\"\n",
+		"/local.yaml":    "include: base.yaml\n",
+		"/envfile.yaml":  "environment-file: [base.env]\n",
+		"/repo.yaml":     "contents:\n  repositories: [./packages]\n",
+		"/filerepo.yaml": "contents:\n  repositories: [\"@local file:///srv/packages\"]\n",
+		"/keyring.yaml":  "contents:\n  keyring: [/etc/apk/keys/local.rsa.pub]\n",
+		"/paths.yaml":    "paths:\n  - path: /etc/secret\n    type: local\n    source: /etc/shadow\n",
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, ok := configs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, config)
+	}))
+	defer s.Close()
+
+	load := func(include string, opts ...types.LoadOption) (types.ImageConfiguration, error) {
+		path := filepath.Join(t.TempDir(), "apko.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("include: "+include+"\ncontents:\n  packages: [app]\n"), 0o644))
+		ic := types.ImageConfiguration{}
+		return ic, ic.Load(ctx, path, nil, sha256.New(), opts...)
+	}
+	check := func(t *testing.T, ic types.ImageConfiguration) {
+		require.Equal(t, []string{"base", "app"}, ic.Contents.Packages)
+		require.Equal(t, map[string]string{"LANG": "C.UTF-8"}, ic.Environment)
+		require.Equal(t, "65532", ic.Accounts.RunAs)
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(configs["/base.yaml"])))
+	pinned := func(path string) string {
+		return fmt.Sprintf("%s%s@sha256:%x", s.URL, path, sha256.Sum256([]byte(configs[path])))
+	}
+
+	t.Run("url", func(t *testing.T) {
+		ic, err := load(s.URL + "/base.yaml@sha256:" + sum)
+		require.NoError(t, err)
+		check(t, ic)
+
+		_, err = load(s.URL + "/base.yaml")
+		require.ErrorContains(t, err, "is not pinned to a digest")
+
+		_, err = load(s.URL + "/base.yaml@sha256:" + strings.Repeat("0", 64))
+		require.ErrorContains(t, err, "has digest sha256:"+sum)
+
+		_, err = load(s.URL + "/missing.yaml@sha256:" + sum)
+		require.ErrorContains(t, err, "404 Not Found")
+	})
+
+	t.Run("client and authenticator", func(t *testing.T) {
+		s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, configs["/base.yaml"])
+		}))
+		defer s.Close()
+		include := s.URL + "/base.yaml@sha256:" + sum
+
+		_, err := load(include)
+		require.ErrorContains(t, err, "certificate")
+
+		_, err = load(include, types.WithIncludeClient(s.Client()))
+		require.ErrorContains(t, err, "401 Unauthorized")
+
+		ic, err := load(include, types.WithIncludeClient(s.Client()),
+			types.WithIncludeAuthenticator(auth.StaticAuth(strings.TrimPrefix(s.URL, "https://"), "user", "pass")))
+		require.NoError(t, err)
+		check(t, ic)
+	})
+
+	t.Run("local references", func(t *testing.T) {
+		_, err := load(pinned("/local.yaml"))
+		require.ErrorContains(t, err, "remote configuration cannot include local file base.yaml")
+
+		_, err = load(pinned("/envfile.yaml"))
+		require.ErrorContains(t, err, "remote configuration cannot read local environment files")
+
+		for path, local := range map[string]string{
+			"/repo.yaml":     "./packages",
+			"/filerepo.yaml": "@local file:///srv/packages",
+			"/keyring.yaml":  "/etc/apk/keys/local.rsa.pub",
+			"/paths.yaml":    "/etc/shadow",
+		} {
+			_, err = load(pinned(path))
+			require.ErrorContains(t, err, "remote configuration cannot refer to local files: "+local, path)
+		}
+	})
+
+	t.Run("oci", func(t *testing.T) {
+		reg := httptest.NewServer(registry.New())
+		defer reg.Close()
+		ref, err := name.ParseReference(strings.TrimPrefix(reg.URL, "http://") + "/configs/base:v1")
+		require.NoError(t, err)
+		img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer([]byte(configs["/base.yaml"]), "application/yaml")})
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+		h, err := img.Digest()
+		require.NoError(t, err)
+
+		_, err = load("oci://" + ref.String())
+		require.ErrorContains(t, err, "is not pinned to a digest")
+
+		ic, err := load("oci://" + ref.Context().Digest(h.String()).String())
+		require.NoError(t, err)
+		check(t, ic)
+	})
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("include: b.yaml\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("include: a.yaml\n"), 0o644))
+	t.Chdir(dir)
+
+	ic := types.ImageConfiguration{}
+	err := ic.Load(context.Background(), "a.yaml", nil, sha256.New())
+	a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+	require.ErrorContains(t, err, "include cycle: "+a+" -> "+b+" -> "+a)
+}
+
+func TestParseRebuildAfter(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"90m": 90 * time.Minute,
+	} {
+		got, err := types.ParseRebuildAfter(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got, in)
+	}
+	for in, msg := range map[string]string{
+		"d":     "expected a number of days",
+		"1.5d":  "expected a number of days",
+		"0d":    "must be positive",
+		"-12h":  "must be positive",
+		"month": "invalid duration",
+	} {
+		_, err := types.ParseRebuildAfter(in)
+		require.ErrorContains(t, err, msg, in)
+	}
+
+	ic := types.ImageConfiguration{RebuildAfter: "soon"}
+	require.ErrorContains(t, ic.Validate(), `invalid rebuild-after "soon"`)
+
+	target := types.ImageConfiguration{}
+	require.NoError(t, (&types.ImageConfiguration{RebuildAfter: "30d"}).MergeInto(&target))
+	require.Equal(t, "30d", target.RebuildAfter)
+	require.NoError(t, (&types.ImageConfiguration{RebuildAfter: "7d"}).MergeInto(&target))
+	require.Equal(t, "30d", target.RebuildAfter)
+}
@@ -0,0 +1,177 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"chainguard.dev/apko/pkg/apk/auth"
+)
+
+// ociIncludePrefix marks an include that is an OCI artifact holding the
+// configuration in its only layer.
+const ociIncludePrefix = "oci://"
+
+// maxIncludeSize bounds the size of a remote configuration.
+const maxIncludeSize = 1 << 20
+
+// includeTimeout bounds how long fetching a remote configuration takes.
+const includeTimeout = time.Minute
+
+// LoadOption configures how Load fetches remote configurations.
+type LoadOption func(*includeFetcher)
+
+// WithIncludeClient fetches remote configurations by URL with client, and
+// by OCI reference through its transport.
+func WithIncludeClient(client *http.Client) LoadOption {
+	return func(f *includeFetcher) {
+		if client != nil {
+			f.client = client
+		}
+	}
+}
+
+// WithIncludeAuthenticator adds the credentials of a to the requests for
+// remote configurations by URL.
+func WithIncludeAuthenticator(a auth.Authenticator) LoadOption {
+	return func(f *includeFetcher) {
+		if a != nil {
+			f.auth = a
+		}
+	}
+}
+
+// includeFetcher fetches remote configurations.
+type includeFetcher struct {
+	client *http.Client
+	auth   auth.Authenticator
+}
+
+func newIncludeFetcher(opts ...LoadOption) *includeFetcher {
+	f := &includeFetcher{client: http.DefaultClient, auth: auth.DefaultAuthenticators}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// isRemoteInclude reports whether include names a configuration by URL or
+// OCI reference rather than by local path.
+func isRemoteInclude(include string) bool {
+	return strings.HasPrefix(include, "https://") ||
+		strings.HasPrefix(include, "http://") ||
+		strings.HasPrefix(include, ociIncludePrefix)
+}
+
+// read fetches the configuration named by include, which must be pinned: a
+// URL with an "@sha256:<hex>" suffix, the digest of the configuration, and
+// an OCI reference by the digest of its manifest.
+func (f *includeFetcher) read(ctx context.Context, include string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, includeTimeout)
+	defer cancel()
+
+	if ref, ok := strings.CutPrefix(include, ociIncludePrefix); ok {
+		r, err := name.ParseReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("parsing include %s: %w", include, err)
+		}
+		if _, ok := r.(name.Digest); !ok {
+			return nil, fmt.Errorf("include %s is not pinned to a digest", include)
+		}
+		return f.readOCI(ctx, r)
+	}
+
+	u, want, pinned := strings.Cut(include, "@sha256:")
+	if !pinned {
+		return nil, fmt.Errorf("include %s is not pinned to a digest", include)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.auth.AddAuth(ctx, req); err != nil {
+		return nil, fmt.Errorf("adding auth to %s: %w", u, err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	data, err := readIncludeBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", u, err)
+	}
+	if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != want {
+		return nil, fmt.Errorf("include %s has digest sha256:%x", include, got)
+	}
+	return data, nil
+}
+
+// readOCI returns the only layer of the OCI artifact at ref, which the
+// registry verifies against its digest.
+func (f *includeFetcher) readOCI(ctx context.Context, ref name.Reference) ([]byte, error) {
+	opts := []remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	if f.client.Transport != nil {
+		opts = append(opts, remote.WithTransport(f.client.Transport))
+	}
+	img, err := remote.Image(ref, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching include %s: %w", ref, err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != 1 {
+		return nil, fmt.Errorf("include %s has %d layers, expected one holding the configuration", ref, len(layers))
+	}
+	// Configurations are pushed as they are, not compressed, so the blob
+	// is the configuration.
+	rc, err := layers[0].Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := readIncludeBody(rc)
+	if err != nil {
+		return nil, fmt.Errorf("reading include %s: %w", ref, err)
+	}
+	return data, nil
+}
+
+func readIncludeBody(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxIncludeSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxIncludeSize {
+		return nil, fmt.Errorf("configuration is larger than %d bytes", maxIncludeSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// constraintOperators are the version comparisons apk understands. "~" and
+// "=~" match the versions the given one is a prefix of, e.g. "=~1.25"
+// matches 1.25.3-r0 but not 1.26.0-r0.
+var constraintOperators = []string{"=", "<", ">", "<=", ">=", "~", "=~"}
+
+// PackageConstraints returns the constraints in Packages, with ranges, such as
+// "nginx>=1.25,<1.27", split into one constraint per bound. It fails on
+// constraints with an unknown comparison or an unparseable version, which
+// apk would otherwise take as the bare package name.
+func (i ImageContents) PackageConstraints() ([]string, error) {
+	return SplitPackageConstraints(i.Packages)
+}
+
+// SplitPackageConstraints returns packages with ranges split into one
+// constraint per bound, as apk resolves them, as PackageConstraints does.
+func SplitPackageConstraints(packages []string) ([]string, error) {
+	constraints := make([]string, 0, len(packages))
+	for _, p := range packages {
+		split, err := splitConstraint(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package constraint %q: %w", p, err)
+		}
+		constraints = append(constraints, split...)
+	}
+	return constraints, nil
+}
+
+// splitConstraint splits a constraint into one constraint per bound, each
+// carrying the repository pin of the whole.
+func splitConstraint(c string) ([]string, error) {
+	nameEnd := strings.IndexAny(c, "=<>~")
+	if nameEnd < 0 {
+		return []string{c}, nil
+	}
+	name, bounds, pin := c[:nameEnd], c[nameEnd:], ""
+	if at := strings.LastIndex(bounds, "@"); at >= 0 {
+		bounds, pin = bounds[:at], bounds[at:]
+	}
+	if name == "" || strings.HasPrefix(name, "@") {
+		return nil, fmt.Errorf("missing package name")
+	}
+
+	var split []string
+	for _, bound := range strings.Split(bounds, ",") {
+		opEnd := strings.IndexFunc(bound, func(r rune) bool { return !strings.ContainsRune("=<>~", r) })
+		if opEnd < 0 {
+			opEnd = len(bound)
+		}
+		op, version := bound[:opEnd], bound[opEnd:]
+		if !slices.Contains(constraintOperators, op) {
+			return nil, fmt.Errorf("unknown comparison %q, expected one of %s", op, strings.Join(constraintOperators, " "))
+		}
+		if version == "" {
+			return nil, fmt.Errorf("missing version after %q", op)
+		}
+		// Shared library versions are not package versions.
+		if !strings.HasPrefix(name, "so:") {
+			if _, err := apk.ParseVersion(version); err != nil {
+				return nil, err
+			}
+		}
+		split = append(split, name+op+version+pin)
+	}
+	return split, nil
+}
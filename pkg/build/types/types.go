@@ -15,13 +15,18 @@
 package types
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"net/url"
+	"os"
+	"path/filepath"
 	"runtime"
 	"slices"
 	"strings"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"gopkg.in/yaml.v3"
 )
 
 func processRepositoryURLs(repositories []string) error {
@@ -82,7 +87,8 @@ type PathMutation struct {
 	Path string `json:"path,omitempty"`
 	// The type of mutation to perform
 	//
-	// This can be one of: directory, empty-file, hardlink, symlink, permissions
+	// This can be one of: directory, empty-file, hardlink, symlink, permissions, local,
+	// char-device, block-device, fifo
 	Type string `json:"type,omitempty"`
 	// The mutation's desired user ID
 	UID uint32 `json:"uid,omitempty"`
@@ -90,10 +96,38 @@ type PathMutation struct {
 	GID uint32 `json:"gid,omitempty"`
 	// The permission bits for the path
 	Permissions uint32 `json:"permissions,omitempty"`
-	// The source path to mutate
+	// The source path to mutate. For local mutations this is a file or
+	// directory in the build context to copy into the image.
 	Source string `json:"source,omitempty"`
 	// Toggle whether to mutate recursively
 	Recursive bool `json:"recursive,omitempty"`
+	// The major device number, for char-device and block-device mutations
+	Major uint32 `json:"major,omitempty"`
+	// The minor device number, for char-device and block-device mutations
+	Minor uint32 `json:"minor,omitempty"`
+}
+
+type Assertion struct {
+	// The kind of check to make
+	//
+	// This can be one of: file-exists, file-mode, links-against, user-exists, env-set
+	Type string `json:"type" yaml:"type"`
+	// The path of the file, for file-exists, file-mode and links-against
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// The permission bits the file must have, for file-mode
+	Permissions *uint32 `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+	// The libraries the ELF binary must link against, for links-against
+	Libraries []string `json:"libraries,omitempty" yaml:"libraries,omitempty"`
+	// The number of libraries the ELF binary must link against, for
+	// links-against. Zero requires a statically linked binary.
+	Count *int `json:"count,omitempty" yaml:"count,omitempty"`
+	// The name of the user, for user-exists
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// The name of the environment variable, for env-set
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// The value the environment variable must have, for env-set. When
+	// unset, any value will do.
+	Value *string `json:"value,omitempty" yaml:"value,omitempty"`
 }
 
 type BaseImageDescriptor struct {
@@ -105,6 +139,9 @@ type BaseImageDescriptor struct {
 }
 
 type ImageContents struct {
+	// Optional: A distro profile, such as "wolfi" or "alpine:3.22", whose
+	// canonical repositories and keys are used ahead of those listed here
+	Distro string `json:"distro,omitempty" yaml:"distro,omitempty"`
 	// A list of apk repositories to use for pulling packages at build time,
 	// which are not installed into /etc/apk/repositories in the image (to
 	// install packages at runtime)
@@ -116,45 +153,139 @@ type ImageContents struct {
 	// initial construction of the image, and also at runtime by seeding them
 	// into /etc/apk/repositories in the resulting image.
 	Repositories []string `json:"repositories,omitempty" yaml:"repositories,omitempty"`
-	// A list of public keys used to verify the desired repositories
+	// Optional: Mirror URLs for repositories, keyed by repository URL. When
+	// a repository cannot be reached, its mirrors are tried in order.
+	Mirrors map[string][]string `json:"mirrors,omitempty" yaml:"mirrors,omitempty"`
+	// A list of public keys used to verify the desired repositories: paths,
+	// URLs, or oci:// references, pinned by digest, to OCI artifacts holding
+	// the key in a layer titled with its file name
 	Keyring []string `json:"keyring,omitempty" yaml:"keyring,omitempty"`
+	// Optional: Public keys used, in addition to Keyring, only to verify the
+	// repositories of one architecture, keyed by architecture
+	ArchKeyring map[string][]string `json:"arch_keyring,omitempty" yaml:"arch_keyring,omitempty"`
 	// A list of packages to include in the image
 	Packages []string `json:"packages,omitempty" yaml:"packages,omitempty"`
+	// Optional: Filters on the files installed from packages, keyed by
+	// package name, to slim an image without repackaging
+	Filters map[string]PackageFilter `json:"filters,omitempty" yaml:"filters,omitempty"`
+	// Optional: Paths left out of the layers of the image, as glob patterns
+	// such as usr/share/man/**, in which "**" matches any number of
+	// directories; a pattern matching a directory also matches its contents
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
 	// Optional: Base image to build on top of. Warning: Experimental.
 	BaseImage *BaseImageDescriptor `json:"baseimage,omitempty" yaml:"baseimage,omitempty" apko:"experimental"`
 }
 
+// PackageFilter selects the files of a package that are installed. Patterns
+// are globs over the paths in the package, in which "**" matches any number
+// of directories; a pattern matching a directory also matches its contents.
+type PackageFilter struct {
+	// Optional: Install only the files matching one of these patterns
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	// Optional: Do not install the files matching any of these patterns
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+}
+
+// KeyringFor returns the keys that verify the repositories for arch: the
+// shared keyring followed by the keys scoped to arch.
+func (i ImageContents) KeyringFor(arch Architecture) []string {
+	keyring := slices.Clone(i.Keyring)
+	for _, a := range slices.Sorted(maps.Keys(i.ArchKeyring)) {
+		if ParseArchitecture(a) == ParseArchitecture(arch.String()) {
+			keyring = append(keyring, i.ArchKeyring[a]...)
+		}
+	}
+	return keyring
+}
+
 // MarshalYAML implements yaml.Marshaler for ImageContents, redacting URLs in
 // the ImageContents struct fields.
 func (i ImageContents) MarshalYAML() (any, error) {
 	type redactedImageContents ImageContents
 	ri := redactedImageContents(i)
 
+	// The lists are redacted in copies, leaving i as it is.
+	ri.BuildRepositories = slices.Clone(ri.BuildRepositories)
 	if err := processRepositoryURLs(ri.BuildRepositories); err != nil {
 		return nil, err
 	}
 
+	ri.RuntimeOnlyRepositories = slices.Clone(ri.RuntimeOnlyRepositories)
+	if err := processRepositoryURLs(ri.RuntimeOnlyRepositories); err != nil {
+		return nil, err
+	}
+
+	ri.Repositories = slices.Clone(ri.Repositories)
 	if err := processRepositoryURLs(ri.Repositories); err != nil {
 		return nil, err
 	}
 
-	for idx, key := range ri.Keyring {
-		rawURL := key
-		parsed, err := url.Parse(rawURL)
-		if err != nil {
-			return nil, fmt.Errorf("parsing public key URL: %w", err)
+	if len(ri.Mirrors) != 0 {
+		mirrors := make(map[string][]string, len(ri.Mirrors))
+		for repo, urls := range ri.Mirrors {
+			redacted := slices.Clone(urls)
+			if err := processRepositoryURLs(redacted); err != nil {
+				return nil, err
+			}
+			parsed, err := url.Parse(repo)
+			if err != nil {
+				return nil, fmt.Errorf("parsing repository URL: %w", err)
+			}
+			mirrors[parsed.Redacted()] = redacted
+		}
+		ri.Mirrors = mirrors
+	}
+
+	keyring, err := redactKeys(ri.Keyring)
+	if err != nil {
+		return nil, err
+	}
+	ri.Keyring = keyring
+
+	if len(ri.ArchKeyring) != 0 {
+		archKeyring := make(map[string][]string, len(ri.ArchKeyring))
+		for arch, keys := range ri.ArchKeyring {
+			redacted, err := redactKeys(keys)
+			if err != nil {
+				return nil, err
+			}
+			archKeyring[arch] = redacted
 		}
-		ri.Keyring[idx] = parsed.Redacted()
+		ri.ArchKeyring = archKeyring
 	}
 
 	return ri, nil
 }
 
+// redactKeys returns the key URLs of keys with their passwords redacted.
+func redactKeys(keys []string) ([]string, error) {
+	if keys == nil {
+		return nil, nil
+	}
+	redacted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parsed, err := url.Parse(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key URL: %w", err)
+		}
+		redacted = append(redacted, parsed.Redacted())
+	}
+	return redacted, nil
+}
+
 type ImageEntrypoint struct {
 	// Optional: The type of entrypoint. Only "service-bundle" is supported.
 	Type string `json:"type,omitempty"`
 	// Required: The command of the entrypoint
+	//
+	// This is split into arguments like a shell splits words, but without
+	// expansions.
 	Command string `json:"command,omitempty"`
+	// Optional: The command of the entrypoint in exec form
+	//
+	// Each item is one argument, used as it is. This cannot be combined
+	// with command.
+	Exec []string `json:"exec,omitempty"`
 	// Optional: The shell fragment of the entrypoint command
 	ShellFragment string `json:"shell-fragment,omitempty" yaml:"shell-fragment"`
 
@@ -181,8 +312,14 @@ type ImageConfiguration struct {
 	Entrypoint ImageEntrypoint `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
 	// Optional: The command of the container image
 	//
-	// These are the additional arguments to pass to the entrypoint.
+	// These are the additional arguments to pass to the entrypoint, split
+	// like a shell splits words, but without expansions.
 	Cmd string `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+	// Optional: The command of the container image in exec form
+	//
+	// Each item is one argument, used as it is. This cannot be combined
+	// with cmd.
+	CmdExec []string `json:"cmd-exec,omitempty" yaml:"cmd-exec,omitempty"`
 	// Optional: The stop signal used to suspend the execution of the containers process
 	StopSignal string `json:"stop-signal,omitempty" yaml:"stop-signal,omitempty"`
 	// Optional: The working directory of the container
@@ -192,18 +329,40 @@ type ImageConfiguration struct {
 	// Optional: List of CPU architectures to build the container image for
 	//
 	// The list of supported architectures is: 386, amd64, arm64, arm/v6, arm/v7, ppc64le, riscv64, s390x, loong64
+	//
+	// [host] builds for the architecture apko runs on.
 	Archs []Architecture `json:"archs,omitempty" yaml:"archs,omitempty"`
 	// Optional: Environment variables to set in the container image
 	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// Optional: Paths to local files of environment variables in dotenv format
+	//
+	// Variables set in environment take precedence over those from the
+	// files, and later files take precedence over earlier ones.
+	EnvironmentFiles []string `json:"environment-file,omitempty" yaml:"environment-file,omitempty"`
 	// Optional: List of paths mutations
 	Paths []PathMutation `json:"paths,omitempty" yaml:"paths,omitempty"`
 	// Optional: The link to version control system for this container's source code
 	VCSUrl string `json:"vcs-url,omitempty" yaml:"vcs-url,omitempty"`
 	// Optional: Annotations to apply to the images manifests
 	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
-	// Optional: Path to a local file containing additional image configuration
+	// Optional: Labels to set in the image config
+	//
+	// Values are Go templates evaluated once the packages are installed,
+	// with .Packages mapping each installed package to its version,
+	// .Repositories, .Arch and, when building from a lock file, .LockDigest.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Optional: How long after its creation time the image should be rebuilt,
+	// as a number of days such as 30d or a duration such as 12h
+	//
+	// The time is recorded in the dev.chainguard.apko.rebuild-after
+	// annotation of the images and index.
+	RebuildAfter string `json:"rebuild-after,omitempty" yaml:"rebuild-after,omitempty"`
+	// Optional: Path to a local file containing additional image configuration,
+	// or the http(s) URL or oci:// reference of a remote one
 	//
-	// The included configuration is deep merged with the parent configuration
+	// The included configuration is deep merged with the parent configuration.
+	// A URL must be pinned by appending "@sha256:<hex>", the digest of the
+	// configuration, and an OCI reference by the digest of its manifest.
 	//
 	// Deprecated: This will be removed in a future release.
 	Include string `json:"include,omitempty" yaml:"include,omitempty"`
@@ -219,6 +378,23 @@ type ImageConfiguration struct {
 
 	// Optional: Configuration to control layering of the OCI image.
 	Layering *Layering `json:"layering,omitempty" yaml:"layering,omitempty"`
+
+	// Optional: Interpreter bytecode to precompile at build time, so the
+	// image does not compile it on first start.
+	Bytecode *Bytecode `json:"bytecode,omitempty" yaml:"bytecode,omitempty"`
+
+	// Optional: Runtime caches to generate from the installed files, in
+	// place of the package triggers that would build them.
+	//
+	// This can contain: fontconfig, glib-schemas
+	RuntimeCaches []string `json:"runtime-caches,omitempty" yaml:"runtime-caches,omitempty"`
+
+	// Optional: Checks on the finished image that fail the build when they
+	// do not hold.
+	Asserts []Assertion `json:"asserts,omitempty" yaml:"asserts,omitempty"`
+
+	// Optional: The SBOMs to generate for the image.
+	SBOM *ImageSBOM `json:"sbom,omitempty" yaml:"sbom,omitempty"`
 }
 
 // Architecture represents a CPU architecture for the container image.
@@ -250,7 +426,8 @@ var (
 )
 
 // AllArchs contains the standard set of supported architectures, which are
-// used by `apko publish` when no architectures are specified.
+// built when no architectures are specified, unless DefaultArchs says
+// otherwise.
 var AllArchs = []Architecture{
 	_386,
 	amd64,
@@ -263,6 +440,68 @@ var AllArchs = []Architecture{
 	s390x,
 }
 
+// DefaultArchsEnv names the environment variable that sets the architectures
+// built when neither the configuration nor the command line names any, as a
+// comma separated list such as "amd64,arm64", "host" or "all".
+const DefaultArchsEnv = "APKO_DEFAULT_ARCHS"
+
+// DefaultArchs returns the architectures to build when neither the
+// configuration nor the command line names any: those in $APKO_DEFAULT_ARCHS,
+// else the default-archs of apko/config.yaml in the user's configuration
+// directory, else AllArchs. This lets developers build for their host only
+// while CI builds every architecture, without editing image configurations.
+func DefaultArchs() ([]Architecture, error) {
+	var in []string
+	if v := os.Getenv(DefaultArchsEnv); v != "" {
+		for _, a := range strings.Split(v, ",") {
+			in = append(in, strings.TrimSpace(a))
+		}
+	} else if dir, err := os.UserConfigDir(); err == nil {
+		path := filepath.Join(dir, "apko", "config.yaml")
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		var cfg struct {
+			DefaultArchs []string `yaml:"default-archs"`
+		}
+		if err := yaml.Unmarshal(b, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		in = cfg.DefaultArchs
+	}
+	if len(in) == 0 {
+		return AllArchs, nil
+	}
+
+	archs := ParseArchitectures(in)
+	for _, a := range archs {
+		if !slices.Contains(AllArchs, a) {
+			return nil, fmt.Errorf("unknown default architecture %q, must be one of %v, host or all", a, AllArchs)
+		}
+	}
+	return archs, nil
+}
+
+// ResolveArchs sets the architectures of ic to archs, typically from the
+// command line, when there are any, else keeps those of the configuration,
+// else sets them to DefaultArchs.
+func (ic *ImageConfiguration) ResolveArchs(archs []Architecture) error {
+	switch {
+	case len(archs) != 0:
+		ic.Archs = archs
+	case len(ic.Archs) != 0:
+		// Keep those of the configuration.
+	default:
+		defaults, err := DefaultArchs()
+		if err != nil {
+			return err
+		}
+		ic.Archs = defaults
+	}
+	return nil
+}
+
 // ToAPK returns the apk-style equivalent string for the Architecture.
 func (a Architecture) ToAPK() string {
 	switch a := ParseArchitecture(a.String()); a {
@@ -401,19 +640,18 @@ func ParseArchitecture(s string) Architecture {
 // the equivalent slice of Architectures.
 //
 // apk-style arch strings (e.g., "x86_64") are converted to the OCI-style
-// equivalent ("amd64"). Values are deduped, and the resulting slice is sorted
-// for reproducibility.
+// equivalent ("amd64"), and "host" to the architecture apko runs on. Values
+// are deduped, and the resulting slice is sorted for reproducibility.
 func ParseArchitectures(in []string) []Architecture {
 	if len(in) == 1 && in[0] == "all" {
 		return AllArchs
 	}
 
-	if len(in) == 1 && in[0] == "host" {
-		in[0] = runtime.GOARCH
-	}
-
 	uniq := map[Architecture]struct{}{}
 	for _, s := range in {
+		if s == "host" {
+			s = runtime.GOARCH
+		}
 		a := ParseArchitecture(s)
 		uniq[a] = struct{}{}
 	}
@@ -432,7 +670,36 @@ type SBOM struct {
 	Digest v1.Hash
 }
 
+type ImageSBOM struct {
+	// Optional: The SBOM formats to write, unless others are given on the
+	// command line.
+	//
+	// This can contain: spdx, cyclonedx
+	Formats []string `json:"formats,omitempty" yaml:"formats,omitempty"`
+}
+
+type Bytecode struct {
+	// Python precompiles the modules of CPython and its packages to .pyc
+	// files with hash-based invalidation. It needs a python3 of the same
+	// version as the image's on the host.
+	Python bool `json:"python,omitempty" yaml:"python,omitempty"`
+}
+
 type Layering struct {
 	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
 	Budget   int    `json:"budget,omitempty" yaml:"budget,omitempty"`
+	// Optional: Layers defined by paths in the final filesystem rather than
+	// by package. They hold every matching file, whichever package owns it,
+	// and do not count against the budget.
+	Layers []PathLayer `json:"layers,omitempty" yaml:"layers,omitempty"`
+}
+
+type PathLayer struct {
+	// The name of the layer, recorded in the dev.chainguard.apko.layer.name
+	// annotation on its descriptor
+	Name string `json:"name" yaml:"name"`
+	// Patterns of the paths in the layer, in which "**" matches any number
+	// of directories. A pattern matching a directory also matches its
+	// contents. A file matching several layers goes in the first.
+	Paths []string `json:"paths" yaml:"paths"`
 }
@@ -16,15 +16,19 @@ package cpio
 
 import (
 	"archive/tar"
-	"bytes"
 	"fmt"
 	"io"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/u-root/u-root/pkg/cpio"
 )
 
+// FromLayer converts an image layer to a newc CPIO archive written to dest.
 func FromLayer(layer v1.Layer, dest io.Writer) error {
+	targets, err := linkTargets(layer)
+	if err != nil {
+		return err
+	}
+
 	// Open the filesystem layer to walk through the file.
 	u, err := layer.Uncompressed()
 	if err != nil {
@@ -33,7 +37,7 @@ func FromLayer(layer v1.Layer, dest io.Writer) error {
 	defer u.Close()
 	tarReader := tar.NewReader(u)
 
-	w := cpio.NewDedupWriter(cpio.Newc.Writer(dest))
+	w := NewWriter(dest, targets)
 
 	// Iterate through the tar archive entries
 	for {
@@ -42,56 +46,43 @@ func FromLayer(layer v1.Layer, dest io.Writer) error {
 			break // End of archive
 		}
 		if err != nil {
-			fmt.Println("Error reading tar entry:", err)
-			return err
+			return fmt.Errorf("reading tar entry: %w", err)
 		}
 
-		// Determine CPIO file mode based on TAR typeflag
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.Directory(header.Name, uint64(header.Mode)),
-			}); err != nil {
-				return err
-			}
-
-		case tar.TypeSymlink:
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.Symlink(header.Name, header.Linkname),
-			}); err != nil {
-				return err
-			}
-
-		case tar.TypeReg:
-			var original bytes.Buffer
-			// TODO(mattmoor): Do something better here, but unfortunately the
-			// cpio stuff wants a seekable reader, so coming from a tar reader
-			// I'm not sure how much leeway we have to do something better
-			// than buffering.
-			//nolint:gosec
-			if _, err := io.Copy(&original, tarReader); err != nil {
-				fmt.Println("Error reading file content:", err)
-				return err
-			}
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+		//nolint:gosec
+		if _, err := io.Copy(w, tarReader); err != nil {
+			return fmt.Errorf("reading contents of %s: %w", header.Name, err)
+		}
+	}
 
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.StaticFile(header.Name, original.String(), uint64(header.Mode)),
-			}); err != nil {
-				return err
-			}
+	return w.Close()
+}
 
-		case tar.TypeChar:
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.CharDev(header.Name, uint64(header.Mode), uint64(header.Devmajor), uint64(header.Devminor)),
-			}); err != nil {
-				return err
-			}
+// linkTargets returns the files the hard links in layer link to, reading
+// through the layer once ahead of converting it, so that only their contents
+// have to be kept.
+func linkTargets(layer v1.Layer) ([]string, error) {
+	u, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer u.Close()
+	tarReader := tar.NewReader(u)
 
-		default:
-			fmt.Printf("Unsupported TAR typeflag: %c for %s\n", header.Typeflag, header.Name)
-			continue // Skip unsupported types
+	var targets []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return targets, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar entry: %w", err)
+		}
+		if header.Typeflag == tar.TypeLink {
+			targets = append(targets, header.Linkname)
 		}
 	}
-
-	return w.WriteRecord(cpio.TrailerRecord)
 }
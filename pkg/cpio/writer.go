@@ -0,0 +1,165 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cpio
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+// Writer writes a newc CPIO archive from tar headers and file contents, so
+// that it can stand in for a *tar.Writer.
+type Writer struct {
+	w cpio.RecordWriter
+
+	// The regular file being written, whose record is written once its
+	// contents are complete.
+	pending *tar.Header
+	buf     bytes.Buffer
+	closed  bool
+
+	// The contents of the hard link targets written so far, by normalized
+	// name, so that hard links to them can be written as copies. Those of
+	// other files are not kept, as an image can be large.
+	files map[string]*string
+}
+
+// NewWriter returns a Writer that writes to dest. linkTargets names the
+// files that the hard links to be written link to, e.g. the Linkname of
+// every tar.TypeLink header of a tarball, whose contents are kept to write
+// the links as copies.
+func NewWriter(dest io.Writer, linkTargets []string) *Writer {
+	files := make(map[string]*string, len(linkTargets))
+	for _, name := range linkTargets {
+		files[cpio.Normalize(name)] = nil
+	}
+	return &Writer{
+		w:     cpio.NewDedupWriter(cpio.Newc.Writer(dest)),
+		files: files,
+	}
+}
+
+// WriteHeader begins a new entry. Hard links are written as copies of the
+// file they link to, which must already have been written and be one of the
+// link targets of the Writer. Entries of types CPIO cannot represent are an
+// error.
+func (w *Writer) WriteHeader(header *tar.Header) error {
+	if w.closed {
+		return errors.New("cpio: write after close")
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	var rec cpio.Record
+	switch header.Typeflag {
+	case tar.TypeDir:
+		rec = cpio.Directory(header.Name, uint64(header.Mode))
+
+	case tar.TypeSymlink:
+		rec = cpio.Symlink(header.Name, header.Linkname)
+
+	case tar.TypeReg:
+		// The record needs a seekable reader, so the contents are
+		// buffered until the next entry.
+		w.pending = header
+		return nil
+
+	case tar.TypeLink:
+		contents, ok := w.files[cpio.Normalize(header.Linkname)]
+		if !ok {
+			return fmt.Errorf("cpio: hard link %s to %s: target is not a link target of the writer", header.Name, header.Linkname)
+		}
+		if contents == nil {
+			return fmt.Errorf("cpio: hard link %s to %s: target is not a regular file earlier in the archive", header.Name, header.Linkname)
+		}
+		rec = w.file(header.Name, *contents, header.Mode)
+
+	case tar.TypeChar:
+		rec = cpio.CharDev(header.Name, uint64(header.Mode), uint64(header.Devmajor), uint64(header.Devminor))
+
+	case tar.TypeBlock:
+		rec = cpio.Record{
+			Info: cpio.Info{
+				Name:   header.Name,
+				Mode:   cpio.S_IFBLK | uint64(header.Mode),
+				Rmajor: uint64(header.Devmajor),
+				Rminor: uint64(header.Devminor),
+			},
+		}
+
+	case tar.TypeFifo:
+		rec = cpio.Record{
+			Info: cpio.Info{
+				Name: header.Name,
+				Mode: cpio.S_IFIFO | uint64(header.Mode),
+			},
+		}
+
+	case tar.TypeXGlobalHeader:
+		// PAX global headers carry no file.
+		return nil
+
+	default:
+		return fmt.Errorf("cpio: unsupported tar entry type %q for %s", header.Typeflag, header.Name)
+	}
+	return cpio.WriteRecordsAndDirs(w.w, []cpio.Record{rec})
+}
+
+// Write writes to the contents of the current regular file. Writes to
+// entries of other types are discarded.
+func (w *Writer) Write(b []byte) (int, error) {
+	if w.pending == nil {
+		return len(b), nil
+	}
+	return w.buf.Write(b)
+}
+
+// Close writes the last entry and the archive trailer. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.closed = true
+	return w.w.WriteRecord(cpio.TrailerRecord)
+}
+
+func (w *Writer) flush() error {
+	if w.pending == nil {
+		return nil
+	}
+	header := w.pending
+	w.pending = nil
+	defer w.buf.Reset()
+	return cpio.WriteRecordsAndDirs(w.w, []cpio.Record{
+		w.file(header.Name, w.buf.String(), header.Mode),
+	})
+}
+
+func (w *Writer) file(name, contents string, mode int64) cpio.Record {
+	if _, ok := w.files[cpio.Normalize(name)]; ok {
+		w.files[cpio.Normalize(name)] = &contents
+	}
+	return cpio.StaticFile(name, contents, uint64(mode))
+}
@@ -0,0 +1,130 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cpio
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/require"
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, nil)
+
+	for _, e := range []struct {
+		hdr      tar.Header
+		contents string
+	}{
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "etc", Mode: 0o755}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "etc/motd", Mode: 0o644, Size: 5}, contents: "hello"},
+		{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "motd", Linkname: "etc/motd"}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "etc/issue", Mode: 0o600, Size: 3}, contents: "hi\n"},
+	} {
+		require.NoError(t, w.WriteHeader(&e.hdr))
+		_, err := io.WriteString(w, e.contents)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.Error(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "late"}))
+
+	recs, err := cpio.ReadAllRecords(cpio.Newc.Reader(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, err)
+
+	got := map[string]string{}
+	for _, r := range recs {
+		var contents []byte
+		if r.ReaderAt != nil {
+			contents, err = io.ReadAll(io.NewSectionReader(r.ReaderAt, 0, int64(r.FileSize)))
+			require.NoError(t, err)
+		}
+		got[r.Name] = string(contents)
+		if r.Name == "etc/issue" {
+			require.Equal(t, uint64(0o600), r.Mode&0o777)
+		}
+	}
+	require.Equal(t, "hello", got["etc/motd"])
+	require.Equal(t, "hi\n", got["etc/issue"])
+	require.Equal(t, "etc/motd", got["motd"])
+	require.Contains(t, got, "etc")
+}
+
+func TestWriterHardLink(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []string{"/bin/busybox", "bin/missing"})
+
+	require.NoError(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "bin/busybox", Mode: 0o755, Size: 2}))
+	_, err := io.WriteString(w, "bb")
+	require.NoError(t, err)
+	require.NoError(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "bin/true", Mode: 0o755, Size: 4}))
+	_, err = io.WriteString(w, "true")
+	require.NoError(t, err)
+	require.NoError(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "bin/sh", Linkname: "bin/busybox", Mode: 0o755}))
+	require.Error(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "bin/ls", Linkname: "bin/missing"}))
+
+	// Only the contents of link targets are kept.
+	require.ErrorContains(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "bin/false", Linkname: "bin/true"}), "not a link target")
+	require.NotContains(t, w.files, "bin/true")
+	require.Error(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeGNUSparse, Name: "sparse"}))
+	require.NoError(t, w.Close())
+
+	recs, err := cpio.ReadAllRecords(cpio.Newc.Reader(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, err)
+	var found bool
+	for _, r := range recs {
+		if r.Name != "bin/sh" {
+			continue
+		}
+		found = true
+		contents, err := io.ReadAll(io.NewSectionReader(r.ReaderAt, 0, int64(r.FileSize)))
+		require.NoError(t, err)
+		require.Equal(t, "bb", string(contents))
+		require.Equal(t, uint64(cpio.S_IFREG|0o755), r.Mode)
+	}
+	require.True(t, found)
+}
+
+func TestFromLayerHardLink(t *testing.T) {
+	var tb bytes.Buffer
+	tw := tar.NewWriter(&tb)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "bin/busybox", Mode: 0o755, Size: 2}))
+	_, err := io.WriteString(tw, "bb")
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "bin/sh", Linkname: "bin/busybox", Mode: 0o755}))
+	require.NoError(t, tw.Close())
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(tb.Bytes())), nil
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, FromLayer(layer, &buf))
+	recs, err := cpio.ReadAllRecords(cpio.Newc.Reader(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, err)
+	got := map[string]string{}
+	for _, r := range recs {
+		if r.ReaderAt != nil {
+			contents, err := io.ReadAll(io.NewSectionReader(r.ReaderAt, 0, int64(r.FileSize)))
+			require.NoError(t, err)
+			got[r.Name] = string(contents)
+		}
+	}
+	require.Equal(t, "bb", got["bin/sh"])
+}
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
+	"time"
 
 	"chainguard.dev/apko/pkg/build/types"
 )
@@ -21,6 +24,10 @@ type Config struct {
 	Name string `json:"name,omitempty"`
 	// This checksum also covers included files and command-line settings that influence the artifacts resolution.
 	DeepChecksum string `json:"checksum,omitempty"`
+	// PinnedAt is when the packages were resolved, for lock files that a
+	// build records its packages in to build from again while they are
+	// recent.
+	PinnedAt *time.Time `json:"pinned_at,omitempty"`
 }
 
 type LockContents struct {
@@ -59,6 +66,9 @@ type LockRepo struct {
 type LockKeyring struct {
 	Name string `json:"name"`
 	URL  string `json:"url"`
+	// Architecture is set on keys that only verify the repositories of
+	// one architecture.
+	Architecture string `json:"architecture,omitempty"`
 }
 
 func FromFile(lockFile string) (Lock, error) {
@@ -71,6 +81,27 @@ func FromFile(lockFile string) (Lock, error) {
 	return lock, err
 }
 
+// ArchFile returns the path of the lock file that locks arch on its own next
+// to lockFile, such as apko.lock.amd64.json for apko.lock.json, or
+// apko.lock.arm-v7.json for arm/v7.
+func ArchFile(lockFile string, arch types.Architecture) string {
+	ext := filepath.Ext(lockFile)
+	return strings.TrimSuffix(lockFile, ext) + "." + strings.ReplaceAll(arch.String(), "/", "-") + ext
+}
+
+// ForArch returns the lock file to build arch from: the one locking it on its
+// own next to lockFile if it exists, and lockFile otherwise. Locking each
+// architecture separately keeps one whose packages resolve differently from
+// the others from changing their locks.
+func ForArch(lockFile string, arch types.Architecture) string {
+	if p := ArchFile(lockFile, arch); p != lockFile {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return lockFile
+}
+
 func (lock Lock) SaveToFile(lockFile string) error {
 	jsonb, err := json.MarshalIndent(lock, "", "  ")
 	if err != nil {
@@ -84,8 +115,16 @@ func (lock Lock) SaveToFile(lockFile string) error {
 }
 
 // Arch2LockedPackages returns map: for each arch -> list of {package_name}={version} in archs.
+// An arch the lock has no packages for has no entry, unless the lock has no
+// packages at all, as for images built only from paths and accounts, when
+// every arch gets an empty list.
 func (lock Lock) Arch2LockedPackages(archs []types.Architecture) map[string][]string {
 	wantedPackages := make(map[string][]string, len(archs))
+	if len(lock.Contents.Packages) == 0 {
+		for _, arch := range archs {
+			wantedPackages[arch.String()] = []string{}
+		}
+	}
 	for _, p := range lock.Contents.Packages {
 		arch := types.ParseArchitecture(p.Architecture)
 		if slices.Contains(archs, arch) {
@@ -15,6 +15,7 @@
 package lock
 
 import (
+	"path/filepath"
 	"testing"
 
 	"chainguard.dev/apko/pkg/build/types"
@@ -39,4 +40,49 @@ func TestArch2LockedPackages(t *testing.T) {
 	if got, want := len(l.Arch2LockedPackages(archs)), 1; got != want {
 		t.Errorf("wanted %d arch, got %d", want, got)
 	}
+
+	// An arch that is not locked is not built empty.
+	archs = []types.Architecture{types.ParseArchitecture("arm64"), types.ParseArchitecture("riscv64")}
+	if _, ok := l.Arch2LockedPackages(archs)["riscv64"]; ok {
+		t.Errorf("wanted no entry for riscv64, which is not locked")
+	}
+}
+
+func TestArch2LockedPackagesEmpty(t *testing.T) {
+	l := Lock{}
+
+	archs := []types.Architecture{types.ParseArchitecture("amd64"), types.ParseArchitecture("arm64")}
+	got := l.Arch2LockedPackages(archs)
+	if len(got) != len(archs) {
+		t.Fatalf("wanted %d archs, got %d", len(archs), len(got))
+	}
+	for _, arch := range archs {
+		if pkgs, ok := got[arch.String()]; !ok || len(pkgs) != 0 {
+			t.Errorf("wanted empty package list for %s, got %v", arch, pkgs)
+		}
+	}
+}
+
+func TestForArch(t *testing.T) {
+	dir := t.TempDir()
+	lockFile := filepath.Join(dir, "apko.lock.json")
+	amd64 := types.ParseArchitecture("amd64")
+	armv7 := types.ParseArchitecture("arm/v7")
+
+	if got, want := ArchFile(lockFile, armv7), filepath.Join(dir, "apko.lock.arm-v7.json"); got != want {
+		t.Errorf("ArchFile() = %s, wanted %s", got, want)
+	}
+
+	if got := ForArch(lockFile, amd64); got != lockFile {
+		t.Errorf("ForArch() = %s without an arch lock file, wanted %s", got, lockFile)
+	}
+	if err := (Lock{Version: "v1"}).SaveToFile(ArchFile(lockFile, amd64)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ForArch(lockFile, amd64), ArchFile(lockFile, amd64); got != want {
+		t.Errorf("ForArch() = %s, wanted %s", got, want)
+	}
+	if got := ForArch(lockFile, armv7); got != lockFile {
+		t.Errorf("ForArch() = %s for another arch, wanted %s", got, lockFile)
+	}
 }
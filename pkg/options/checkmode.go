@@ -0,0 +1,38 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import "fmt"
+
+// CheckMode is what a check of the image does with what it finds.
+type CheckMode string
+
+const (
+	// CheckOff skips the check.
+	CheckOff CheckMode = ""
+	// CheckWarn logs what the check finds.
+	CheckWarn CheckMode = "warn"
+	// CheckFail fails the build on what the check finds.
+	CheckFail CheckMode = "fail"
+)
+
+// Validate returns an error if m is not one of the check modes.
+func (m CheckMode) Validate() error {
+	switch m {
+	case CheckOff, CheckWarn, CheckFail:
+		return nil
+	}
+	return fmt.Errorf("unknown check mode %q, must be %q or %q", string(m), CheckWarn, CheckFail)
+}
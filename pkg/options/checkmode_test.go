@@ -0,0 +1,28 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckModeValidate(t *testing.T) {
+	for _, m := range []CheckMode{CheckOff, CheckWarn, CheckFail} {
+		require.NoError(t, m.Validate(), m)
+	}
+	require.EqualError(t, CheckMode("warning").Validate(), `unknown check mode "warning", must be "warn" or "fail"`)
+}
@@ -0,0 +1,108 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Now returns the current time, from Clock if it is set.
+func (o *Options) Now() time.Time {
+	if o.Clock != nil {
+		return o.Clock()
+	}
+	return time.Now()
+}
+
+// randMu serializes the reads from Rand: the builds of every architecture
+// share it, while it need not be safe for concurrent use.
+var randMu sync.Mutex
+
+// lockedReader reads from r under randMu.
+type lockedReader struct{ r io.Reader }
+
+func (l lockedReader) Read(p []byte) (int, error) {
+	randMu.Lock()
+	defer randMu.Unlock()
+	return l.r.Read(p)
+}
+
+// Random returns the source of randomness of the build: Rand if it is set,
+// and crypto/rand otherwise. It is safe for concurrent use.
+func (o *Options) Random() io.Reader {
+	if o.Rand != nil {
+		return lockedReader{o.Rand}
+	}
+	return rand.Reader
+}
+
+// MkdirTemp creates a new temporary directory in dir, as os.MkdirTemp does,
+// but naming it from Rand when it is set.
+func (o *Options) MkdirTemp(dir, pattern string) (string, error) {
+	if o.Rand == nil {
+		return os.MkdirTemp(dir, pattern)
+	}
+	return o.createTemp(dir, pattern, func(name string) error {
+		return os.Mkdir(name, 0o700)
+	})
+}
+
+// CreateTemp creates a new temporary file in dir, as os.CreateTemp does,
+// but naming it from Rand when it is set.
+func (o *Options) CreateTemp(dir, pattern string) (*os.File, error) {
+	if o.Rand == nil {
+		return os.CreateTemp(dir, pattern)
+	}
+	var f *os.File
+	if _, err := o.createTemp(dir, pattern, func(name string) (err error) {
+		f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// createTemp calls create with names made from pattern, its last "*"
+// replaced by random hex digits, until one does not exist yet.
+func (o *Options) createTemp(dir, pattern string, create func(string) error) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for range 10000 {
+		var b [4]byte
+		if _, err := io.ReadFull(o.Random(), b[:]); err != nil {
+			return "", err
+		}
+		name := filepath.Join(dir, prefix+hex.EncodeToString(b[:])+suffix)
+		if err := create(name); !errors.Is(err, fs.ErrExist) {
+			return name, err
+		}
+	}
+	return "", &fs.PathError{Op: "createtemp", Path: filepath.Join(dir, pattern), Err: fs.ErrExist}
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockAndRand(t *testing.T) {
+	o := Options{}
+	require.WithinDuration(t, time.Now(), o.Now(), time.Minute)
+
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	o.Clock = func() time.Time { return at }
+	require.Equal(t, at, o.Now())
+
+	dir := t.TempDir()
+	o.Rand = bytes.NewReader([]byte{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77})
+
+	tmp, err := o.MkdirTemp(dir, "apko-temp-*")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "apko-temp-deadbeef"), tmp)
+	fi, err := os.Stat(tmp)
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+
+	// The same name is drawn again, but exists, so the next one is used.
+	f, err := o.CreateTemp(dir, "apko-temp-*")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, filepath.Join(dir, "apko-temp-00112233"), f.Name())
+
+	f, err = o.CreateTemp(dir, "layer-*.tar.gz")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, filepath.Join(dir, "layer-44556677.tar.gz"), f.Name())
+
+	// The randomness ran out.
+	_, err = o.MkdirTemp(dir, "apko-temp-*")
+	require.Error(t, err)
+}
+
+func TestRandomConcurrent(t *testing.T) {
+	// A bytes.Reader is not safe for concurrent use, but Random is.
+	const readers, reads = 8, 100
+	o := Options{Rand: bytes.NewReader(make([]byte, readers*reads*4))}
+	var wg sync.WaitGroup
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range reads {
+				var b [4]byte
+				_, err := io.ReadFull(o.Random(), b[:])
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err := o.Random().Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
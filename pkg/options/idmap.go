@@ -0,0 +1,77 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDMapping shifts Size IDs starting at ContainerID so that they start at
+// HostID instead, in the same way as a line of a user namespace's uid_map.
+type IDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// ParseIDMapping parses a mapping in "container:host:size" form.
+func ParseIDMapping(s string) (IDMapping, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return IDMapping{}, fmt.Errorf("invalid id mapping %q, expected container:host:size", s)
+	}
+	var ids [3]uint32
+	for i, p := range parts {
+		id, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return IDMapping{}, fmt.Errorf("invalid id mapping %q: %w", s, err)
+		}
+		ids[i] = uint32(id)
+	}
+	if ids[2] == 0 {
+		return IDMapping{}, fmt.Errorf("invalid id mapping %q: size must be positive", s)
+	}
+	return IDMapping{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+// IDMap remaps the ownership of files as they are written to image layers.
+// It does not affect /etc/passwd or /etc/group in the image.
+type IDMap struct {
+	UIDs []IDMapping `json:"uids,omitempty"`
+	GIDs []IDMapping `json:"gids,omitempty"`
+}
+
+// UID returns the mapped owner for uid.
+func (m IDMap) UID(uid int) int {
+	return mapID(m.UIDs, uid)
+}
+
+// GID returns the mapped group for gid.
+func (m IDMap) GID(gid int) int {
+	return mapID(m.GIDs, gid)
+}
+
+// mapID applies the first mapping whose range contains id. IDs that no
+// mapping covers are returned unchanged.
+func mapID(mappings []IDMapping, id int) int {
+	for _, m := range mappings {
+		if id >= int(m.ContainerID) && id < int(m.ContainerID)+int(m.Size) {
+			return int(m.HostID) + id - int(m.ContainerID)
+		}
+	}
+	return id
+}
@@ -0,0 +1,47 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIDMapping(t *testing.T) {
+	m, err := ParseIDMapping("0:100000:65536")
+	require.NoError(t, err)
+	require.Equal(t, IDMapping{ContainerID: 0, HostID: 100000, Size: 65536}, m)
+
+	for _, bad := range []string{"", "0:100000", "0:100000:0", "a:b:c", "0:1:2:3"} {
+		_, err := ParseIDMapping(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestIDMap(t *testing.T) {
+	m := IDMap{
+		UIDs: []IDMapping{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 65532, HostID: 200000, Size: 1},
+		},
+	}
+	require.Equal(t, 100000, m.UID(0))
+	require.Equal(t, 100999, m.UID(999))
+	require.Equal(t, 1000, m.UID(1000))
+	require.Equal(t, 200000, m.UID(65532))
+	// No GID mappings leaves groups alone.
+	require.Equal(t, 65532, m.GID(65532))
+}
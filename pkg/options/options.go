@@ -16,12 +16,16 @@ package options
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"runtime"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/time/rate"
+
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/build/types"
@@ -57,6 +61,160 @@ type Options struct {
 	IncludePaths            []string           `json:"includePaths,omitempty"`
 	IgnoreSignatures        bool               `json:"ignoreSignatures,omitempty"`
 	Transport               http.RoundTripper  `json:"-"`
+	IDMap                   IDMap              `json:"idMap,omitempty"`
+	// LayerCompression selects the layer compression format (default gzip).
+	LayerCompression string `json:"layerCompression,omitempty"`
+	// Compressor selects the layer compressor implementation (default pgzip).
+	Compressor string `json:"compressor,omitempty"`
+	// CompressionLevel is passed to the compressor; zero means its default.
+	CompressionLevel int `json:"compressionLevel,omitempty"`
+	// CompressionThreads caps how many cores pgzip and zstd compress each
+	// layer on; zero means min(GOMAXPROCS, 8). Layers are compressed
+	// GOMAXPROCS/CompressionThreads at a time.
+	CompressionThreads int `json:"compressionThreads,omitempty"`
+	// BestEffortArchs skips architectures whose packages cannot be resolved
+	// instead of failing the whole multi-arch build.
+	BestEffortArchs bool `json:"bestEffortArchs,omitempty"`
+	// BuildReportPath, when set, is where a JSON summary of each
+	// architecture's outcome is written.
+	BuildReportPath string `json:"buildReportPath,omitempty"`
+	// DefaultsReportPath, when set, is where a JSON description of the
+	// defaults applied to the configuration is written.
+	DefaultsReportPath string `json:"defaultsReportPath,omitempty"`
+	// LimitRate caps the combined package download rate in bytes per
+	// second; zero means unlimited.
+	LimitRate int64 `json:"limitRate,omitempty"`
+	// RateLimiter enforces LimitRate. It is shared by every architecture.
+	RateLimiter *rate.Limiter `json:"-"`
+	// Backoff, if set, has every architecture pause together the requests
+	// to a host that rate limits them.
+	Backoff *apk.Backoff `json:"-"`
+	// Dial controls how repositories are connected to, e.g. only over IPv6.
+	Dial apk.DialOptions `json:"dial,omitempty"`
+	// CATrust is where the CA certificates repositories are verified
+	// against come from: "system", "bundled" or the path of a PEM file.
+	CATrust string `json:"caTrust,omitempty"`
+	// FetchRetry controls how repository fetches that fail transiently are
+	// retried.
+	FetchRetry apk.RetryPolicy `json:"fetchRetry,omitempty"`
+	// ChecksumDB, when set, is the URL of a checksum database that every
+	// installed package is cross-checked against.
+	ChecksumDB string `json:"checksumDB,omitempty"`
+	// ChecksumDBKey is the note verifier key the tree heads of ChecksumDB
+	// are signed with.
+	ChecksumDBKey string `json:"checksumDBKey,omitempty"`
+	// InputPolicy, when set, is the path to a policy saying whose in-toto
+	// attestations the configuration, lock file and packages must carry, as
+	// trusted keys and thresholds.
+	InputPolicy string `json:"inputPolicy,omitempty"`
+	// BuildDateFromGit derives SourceDateEpoch from the last git commit
+	// that touched ImageConfigFile, unless a build date is set explicitly.
+	BuildDateFromGit bool `json:"buildDateFromGit,omitempty"`
+	// FileTimestamp, when set, is the modification time given to every
+	// entry of the image layers: an RFC 3339 date, seconds since the epoch,
+	// "build-date" or "newest-package".
+	FileTimestamp string `json:"fileTimestamp,omitempty"`
+	// CreatedTimestamp, when set, is the time the images and their index
+	// are recorded as created at, in the same forms as FileTimestamp,
+	// instead of the build date epoch.
+	CreatedTimestamp string `json:"createdTimestamp,omitempty"`
+	// CacheNamespace isolates the package and layer caches of this build
+	// from builds in other namespaces that share CacheDir.
+	CacheNamespace string `json:"cacheNamespace,omitempty"`
+	// LowerCacheDir is a read-only package cache consulted on cache misses.
+	LowerCacheDir string `json:"lowerCacheDir,omitempty"`
+	// CacheStore, if set, records the metadata of the package cache.
+	CacheStore apk.KVStore `json:"-"`
+	// InputAnnotations records the apko version and the config and lock
+	// file digests in the image annotations.
+	InputAnnotations bool `json:"inputAnnotations,omitempty"`
+	// BuilderID is a URI identifying the builder running apko. It is
+	// recorded in the SBOMs and, with InputAnnotations, on the image.
+	BuilderID string `json:"builderID,omitempty"`
+	// BuilderVersion is the version of the builder named by BuilderID.
+	BuilderVersion string `json:"builderVersion,omitempty"`
+	// LockDrift, when building from a lock file, compares the locked
+	// packages with the current repository indexes: "warn" reports any
+	// drift, "fail" also fails on packages whose checksum changed upstream.
+	LockDrift CheckMode `json:"lockDrift,omitempty"`
+	// BaseImageKey, when set, is the path to a cosign public key that the
+	// base image and the keys of the keyring in OCI registries must be
+	// signed with.
+	BaseImageKey string `json:"baseImageKey,omitempty"`
+	// BaseImageIdentity and BaseImageIssuer, when set, are the identity and
+	// OIDC issuer of the Fulcio certificate that the base image and the
+	// keys of the keyring in OCI registries must be signed keyless with.
+	BaseImageIdentity string `json:"baseImageIdentity,omitempty"`
+	BaseImageIssuer   string `json:"baseImageIssuer,omitempty"`
+	// FulcioRoots is the path to the PEM encoded certificates of the Fulcio
+	// certificate authority that keyless signatures are checked against.
+	FulcioRoots string `json:"fulcioRoots,omitempty"`
+	// RekorKey is the path to the public key of the Rekor log that keyless
+	// signatures must be recorded in.
+	RekorKey string `json:"rekorKey,omitempty"`
+	// PinFile, when not building from a lock file, is where the packages
+	// resolved for the build are recorded, as a lock file.
+	PinFile string `json:"pinFile,omitempty"`
+	// PinMaxAge, when positive, builds from PinFile instead of resolving
+	// the packages again while it is younger than PinMaxAge and was
+	// recorded for the same configuration.
+	PinMaxAge time.Duration `json:"pinMaxAge,omitempty"`
+	// ELFDeps checks that the libraries the ELF files in the image need
+	// are in it: "warn" reports those that are not, "fail" fails the build.
+	ELFDeps CheckMode `json:"elfDeps,omitempty"`
+	// RequireStatic fails the build if any ELF file in the image is
+	// dynamically linked.
+	RequireStatic bool `json:"requireStatic,omitempty"`
+	// SymlinkCheck reports symlinks in the image that point outside it or
+	// to nothing: "warn" logs them, "fail" fails the build.
+	SymlinkCheck CheckMode `json:"symlinkCheck,omitempty"`
+	// SymlinkAllow are patterns of the symlinks SymlinkCheck ignores.
+	SymlinkAllow []string `json:"symlinkAllow,omitempty"`
+	// PermissionCheck reports setuid and setgid files and world-writable
+	// paths in the image: "warn" logs them, "fail" also fails the build.
+	PermissionCheck CheckMode `json:"permissionCheck,omitempty"`
+	// PermissionAllow are patterns of the paths PermissionCheck ignores.
+	PermissionAllow []string `json:"permissionAllow,omitempty"`
+	// StrictPaths fails the build when a path mutation copies something
+	// that would make the build nondeterministic, such as a symlink to the
+	// host.
+	StrictPaths bool `json:"strictPaths,omitempty"`
+	// LayerCache, when set, is an OCI repository that compressed layers
+	// are fetched from and pushed to, to share them between builders.
+	LayerCache string `json:"layerCache,omitempty"`
+	// LayerCacheOptions are used to access the LayerCache repository.
+	LayerCacheOptions []remote.Option `json:"-"`
+	// LayerCacheReadOnly only fetches layers from the LayerCache, without
+	// pushing the layers compressed here to it.
+	LayerCacheReadOnly bool `json:"layerCacheReadOnly,omitempty"`
+	// RemoteWorkers maps architectures to the URL of an apko worker that
+	// builds their images, e.g. natively rather than under emulation.
+	RemoteWorkers map[types.Architecture]string `json:"remoteWorkers,omitempty"`
+	// Concurrency caps how many architectures of a multi-arch build are
+	// built at once; zero means all of them.
+	Concurrency int `json:"concurrency,omitempty"`
+	// SplitDebugPath, when set, is where the debug info stripped from the
+	// ELF files of the image is written, as an OCI image layout.
+	SplitDebugPath string `json:"splitDebugPath,omitempty"`
+	// ProvenancePath, when set, is where the SLSA provenance of the image
+	// index is written, as an in-toto statement.
+	ProvenancePath string `json:"provenancePath,omitempty"`
+	// ProvenanceKey, when set, is the path to a PEM private key that the
+	// provenance is signed with, making it a DSSE envelope.
+	ProvenanceKey string `json:"provenanceKey,omitempty"`
+	// ContainerdNamespace, when set, is the namespace of the containerd
+	// listening at ContainerdAddress that the built image is loaded into.
+	ContainerdNamespace string `json:"containerdNamespace,omitempty"`
+	// ContainerdAddress is the socket of the containerd ContainerdNamespace
+	// is in.
+	ContainerdAddress string `json:"containerdAddress,omitempty"`
+	// Clock, when set, replaces the system clock wherever the build reads
+	// the current time. See Now.
+	Clock func() time.Time `json:"-"`
+	// Rand, when set, is the source of the randomness the build uses, such
+	// as for temporary file names and signatures. It need not be safe for
+	// concurrent use, as it is read through Random.
+	Rand io.Reader `json:"-"`
 }
 
 type Auth struct{ User, Pass string }
@@ -75,7 +233,7 @@ func (o *Options) TempDir() string {
 		return o.TempDirPath
 	}
 
-	path, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
+	path, err := o.MkdirTemp(os.TempDir(), "apko-temp-*")
 	if err != nil {
 		log.Fatalf("creating tempdir: %v", err)
 	}
@@ -0,0 +1,198 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// Kinds of changes between two images.
+const (
+	Added      = "added"
+	Removed    = "removed"
+	Upgraded   = "upgraded"
+	Downgraded = "downgraded"
+	Modified   = "modified"
+)
+
+// Diff is what changed from one image to another.
+type Diff struct {
+	Packages []PackageChange `json:"packages,omitempty"`
+	Files    []FileChange    `json:"files,omitempty"`
+	// OldSize and NewSize are the compressed sizes of the layers.
+	OldSize int64 `json:"oldSize"`
+	NewSize int64 `json:"newSize"`
+	// OldFilesSize and NewFilesSize are the sizes of the regular files.
+	OldFilesSize int64 `json:"oldFilesSize"`
+	NewFilesSize int64 `json:"newFilesSize"`
+}
+
+// PackageChange is a package added, removed, upgraded or downgraded.
+type PackageChange struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// OldVersion is empty for added packages, NewVersion for removed ones.
+	OldVersion string `json:"oldVersion,omitempty"`
+	NewVersion string `json:"newVersion,omitempty"`
+}
+
+// FileChange is a file added, removed or modified.
+type FileChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Old is nil for added files, New for removed ones.
+	Old *File `json:"old,omitempty"`
+	New *File `json:"new,omitempty"`
+}
+
+// Compare returns what changed from one image to another.
+func Compare(from, to *Image) (*Diff, error) {
+	d := &Diff{}
+
+	oldPkgs, err := from.Packages()
+	if err != nil {
+		return nil, err
+	}
+	newPkgs, err := to.Packages()
+	if err != nil {
+		return nil, err
+	}
+	d.Packages = comparePackages(oldPkgs, newPkgs)
+
+	oldFiles, err := from.Files()
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := to.Files()
+	if err != nil {
+		return nil, err
+	}
+	d.Files = compareFiles(oldFiles, newFiles)
+	d.OldFilesSize, d.NewFilesSize = filesSize(oldFiles), filesSize(newFiles)
+
+	if d.OldSize, err = layersSize(from); err != nil {
+		return nil, err
+	}
+	if d.NewSize, err = layersSize(to); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func comparePackages(from, to []*apk.InstalledPackage) []PackageChange {
+	versions := make(map[string]string, len(from))
+	for _, p := range from {
+		versions[p.Name] = p.Version
+	}
+
+	var changes []PackageChange
+	for _, p := range to {
+		was, ok := versions[p.Name]
+		delete(versions, p.Name)
+		switch {
+		case !ok:
+			changes = append(changes, PackageChange{Name: p.Name, Kind: Added, NewVersion: p.Version})
+		case was != p.Version:
+			kind := Upgraded
+			if compareVersions(was, p.Version) > 0 {
+				kind = Downgraded
+			}
+			changes = append(changes, PackageChange{Name: p.Name, Kind: kind, OldVersion: was, NewVersion: p.Version})
+		}
+	}
+	for name, was := range versions {
+		changes = append(changes, PackageChange{Name: name, Kind: Removed, OldVersion: was})
+	}
+	slices.SortFunc(changes, func(a, b PackageChange) int { return strings.Compare(a.Name, b.Name) })
+	return changes
+}
+
+// compareVersions compares apk versions, falling back to comparing them as
+// strings if either does not parse.
+func compareVersions(a, b string) int {
+	va, err := apk.ParseVersion(a)
+	if err != nil {
+		return strings.Compare(a, b)
+	}
+	vb, err := apk.ParseVersion(b)
+	if err != nil {
+		return strings.Compare(a, b)
+	}
+	return apk.CompareVersions(va, vb)
+}
+
+// compareFiles compares files sorted by path, as Files returns them.
+func compareFiles(from, to []File) []FileChange {
+	var changes []FileChange
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		var c int
+		switch {
+		case i == len(from):
+			c = 1
+		case j == len(to):
+			c = -1
+		default:
+			c = cmp.Compare(from[i].Path, to[j].Path)
+		}
+		switch {
+		case c < 0:
+			changes = append(changes, FileChange{Path: from[i].Path, Kind: Removed, Old: &from[i]})
+			i++
+		case c > 0:
+			changes = append(changes, FileChange{Path: to[j].Path, Kind: Added, New: &to[j]})
+			j++
+		default:
+			if !sameFile(from[i], to[j]) {
+				changes = append(changes, FileChange{Path: from[i].Path, Kind: Modified, Old: &from[i], New: &to[j]})
+			}
+			i++
+			j++
+		}
+	}
+	return changes
+}
+
+// sameFile compares everything but the layer files come from.
+func sameFile(a, b File) bool {
+	a.Layer, b.Layer = 0, 0
+	return a == b
+}
+
+func filesSize(files []File) int64 {
+	var n int64
+	for _, f := range files {
+		if f.Mode.IsRegular() {
+			n += f.Size
+		}
+	}
+	return n
+}
+
+func layersSize(img *Image) (int64, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, l := range layers {
+		n += l.Size
+	}
+	return n, nil
+}
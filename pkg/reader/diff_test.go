@@ -0,0 +1,70 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	newer := `P:busybox
+V:1.37.0-r1
+A:x86_64
+
+P:glibc
+V:2.40-r0
+A:x86_64
+
+`
+	from := FromImage(testImage(t, "amd64"))
+	img, err := mutate.AppendLayers(empty.Image, testLayer(t,
+		entry{name: "etc", dir: true},
+		entry{name: "etc/issue", contents: "arm64"},
+		entry{name: "usr/lib/apk/db/installed", contents: newer},
+		entry{name: "usr/lib/libc.so.6", contents: "elf"},
+	))
+	require.NoError(t, err)
+	to := FromImage(img)
+
+	d, err := Compare(from, to)
+	require.NoError(t, err)
+
+	require.Equal(t, []PackageChange{
+		{Name: "busybox", Kind: Upgraded, OldVersion: "1.37.0-r0", NewVersion: "1.37.0-r1"},
+		{Name: "ca-certificates-bundle", Kind: Removed, OldVersion: "20241121-r1"},
+		{Name: "glibc", Kind: Added, NewVersion: "2.40-r0"},
+	}, d.Packages)
+
+	var changes []string
+	for _, f := range d.Files {
+		changes = append(changes, f.Kind+" "+f.Path)
+	}
+	require.Equal(t, []string{
+		Modified + " etc/issue",
+		Modified + " usr/lib/apk/db/installed",
+		Added + " usr/lib/libc.so.6",
+		Removed + " var/lib/db/sbom/busybox-1.37.0-r0.spdx.json",
+	}, changes)
+
+	require.Equal(t, int64(len("amd64")+len(installed)+len(`{"spdxVersion":"SPDX-2.3"}`)), d.OldFilesSize)
+	require.Equal(t, int64(len("arm64")+len(newer)+len("elf")), d.NewFilesSize)
+	require.Positive(t, d.OldSize)
+
+	require.Equal(t, Downgraded, comparePackages(to.packages, from.packages)[0].Kind)
+}
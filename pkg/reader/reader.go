@@ -0,0 +1,383 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reader opens images built by apko and exposes what is in them:
+// the installed packages, the files, the layers and the SBOMs embedded by
+// the packages. It is the inverse of build, for tools auditing images.
+package reader
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	ocitypes "github.com/google/go-containerregistry/pkg/v1/types"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+const (
+	installedPath = "usr/lib/apk/db/installed"
+	// sbomDir is where packages built by melange put their SBOMs.
+	sbomDir = "var/lib/db/sbom"
+)
+
+// File is an entry of the image filesystem.
+type File struct {
+	// Path is relative to the root of the image, without a leading slash.
+	Path     string
+	Mode     fs.FileMode
+	Size     int64
+	Linkname string
+	UID, GID int
+	// Digest is the sha256 of the contents of regular files.
+	Digest string
+	// Layer is the index of the layer the entry comes from.
+	Layer int
+}
+
+// Layer describes one of the layers of an image.
+type Layer struct {
+	Digest      v1.Hash
+	DiffID      v1.Hash
+	Size        int64
+	MediaType   ocitypes.MediaType
+	Annotations map[string]string
+	// Files are the entries of the layer, in order, including those that
+	// later layers replace or remove.
+	Files []File
+}
+
+// Image is an image opened for reading. Its layers are read once, the first
+// time their contents are needed.
+type Image struct {
+	img v1.Image
+
+	once     sync.Once
+	err      error
+	layers   []Layer
+	files    []File
+	packages []*apk.InstalledPackage
+	sboms    map[string][]byte
+}
+
+// FromImage returns an Image reading img.
+func FromImage(img v1.Image) *Image {
+	return &Image{img: img}
+}
+
+// FromIndex returns an Image reading the image of arch in idx.
+func FromIndex(idx v1.ImageIndex, arch types.Architecture) (*Image, error) {
+	img, err := imageForArch(idx, arch)
+	if err != nil {
+		return nil, err
+	}
+	return FromImage(img), nil
+}
+
+// Open opens the image at ref, which is a tarball written by apko build, an
+// OCI layout directory, or a remote reference. When ref is a multi-arch
+// image, arch selects the image of that architecture.
+func Open(ref string, arch types.Architecture, ropt ...remote.Option) (*Image, error) {
+	if fi, err := os.Stat(ref); err == nil {
+		if fi.IsDir() {
+			idx, err := layout.ImageIndexFromPath(ref)
+			if err != nil {
+				return nil, fmt.Errorf("reading OCI layout %s: %w", ref, err)
+			}
+			return FromIndex(idx, arch)
+		}
+		img, err := tarballImage(ref, arch)
+		if err != nil {
+			return nil, err
+		}
+		return FromImage(img), nil
+	}
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parsing reference %s: %w", ref, err)
+	}
+	desc, err := remote.Get(r, ropt...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", ref, err)
+	}
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		return FromIndex(idx, arch)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	return FromImage(img), nil
+}
+
+// imageForArch returns the image of arch in idx, looking into the index
+// nested in it, as in the OCI layouts apko writes.
+func imageForArch(idx v1.ImageIndex, arch types.Architecture) (v1.Image, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	want := arch.ToOCIPlatform()
+	for _, m := range manifest.Manifests {
+		switch {
+		case m.MediaType.IsIndex():
+			nested, err := idx.ImageIndex(m.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if img, err := imageForArch(nested, arch); err == nil {
+				return img, nil
+			}
+		case m.MediaType.IsImage():
+			if m.Platform != nil && m.Platform.Architecture == want.Architecture && m.Platform.Variant == want.Variant {
+				return idx.Image(m.Digest)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no image for %s", arch)
+}
+
+// tarballImage returns the image of arch in the tarball at path. apko build
+// tags the image of each architecture with the architecture as a suffix.
+func tarballImage(path string, arch types.Architecture) (v1.Image, error) {
+	opener := func() (io.ReadCloser, error) { return os.Open(path) }
+	manifest, err := tarball.LoadManifest(opener)
+	if err != nil {
+		return nil, fmt.Errorf("reading tarball %s: %w", path, err)
+	}
+	if len(manifest) == 1 {
+		return tarball.Image(opener, nil)
+	}
+	suffix := "-" + strings.ReplaceAll(arch.ToOCIPlatform().Architecture, "/", "_")
+	for _, d := range manifest {
+		for _, t := range d.RepoTags {
+			if !strings.HasSuffix(t, suffix) {
+				continue
+			}
+			tag, err := name.NewTag(t)
+			if err != nil {
+				return nil, err
+			}
+			return tarball.Image(opener, &tag)
+		}
+	}
+	return nil, fmt.Errorf("no image for %s in %s", arch, path)
+}
+
+// V1Image returns the underlying image.
+func (i *Image) V1Image() v1.Image {
+	return i.img
+}
+
+// Config returns the image config.
+func (i *Image) Config() (*v1.ConfigFile, error) {
+	return i.img.ConfigFile()
+}
+
+// Layers returns the layers of the image, from the bottom up.
+func (i *Image) Layers() ([]Layer, error) {
+	i.once.Do(i.read)
+	return i.layers, i.err
+}
+
+// Files returns the entries of the image filesystem, as the runtime sees
+// them once the layers are stacked, sorted by path.
+func (i *Image) Files() ([]File, error) {
+	i.once.Do(i.read)
+	return i.files, i.err
+}
+
+// Packages returns the packages installed in the image, as recorded in its
+// apk database.
+func (i *Image) Packages() ([]*apk.InstalledPackage, error) {
+	i.once.Do(i.read)
+	return i.packages, i.err
+}
+
+// SBOMs returns the SBOMs the installed packages embed in the image, keyed
+// by their path.
+func (i *Image) SBOMs() (map[string][]byte, error) {
+	i.once.Do(i.read)
+	return i.sboms, i.err
+}
+
+// read walks the layers once, stacking their files.
+func (i *Image) read() {
+	i.err = i.readLayers()
+}
+
+func (i *Image) readLayers() error {
+	layers, err := i.img.Layers()
+	if err != nil {
+		return err
+	}
+	var manifest *v1.Manifest
+	if m, err := i.img.Manifest(); err == nil {
+		manifest = m
+	}
+
+	stacked := map[string]File{}
+	contents := map[string][]byte{}
+	for n, l := range layers {
+		info, err := describeLayer(l)
+		if err != nil {
+			return fmt.Errorf("layer %d: %w", n, err)
+		}
+		if manifest != nil && n < len(manifest.Layers) {
+			info.Annotations = manifest.Layers[n].Annotations
+		}
+		if err := readLayer(l, n, &info, stacked, contents); err != nil {
+			return fmt.Errorf("reading layer %d: %w", n, err)
+		}
+		i.layers = append(i.layers, info)
+	}
+
+	i.files = make([]File, 0, len(stacked))
+	for _, f := range stacked {
+		i.files = append(i.files, f)
+	}
+	slices.SortFunc(i.files, func(a, b File) int { return strings.Compare(a.Path, b.Path) })
+
+	i.sboms = map[string][]byte{}
+	for p, b := range contents {
+		if _, ok := stacked[p]; !ok {
+			continue
+		}
+		if p == installedPath {
+			pkgs, err := apk.ParseInstalled(bytes.NewReader(b))
+			if err != nil {
+				return fmt.Errorf("parsing %s: %w", installedPath, err)
+			}
+			i.packages = pkgs
+			continue
+		}
+		i.sboms[p] = b
+	}
+	return nil
+}
+
+func describeLayer(l v1.Layer) (Layer, error) {
+	var info Layer
+	var err error
+	if info.Digest, err = l.Digest(); err != nil {
+		return info, err
+	}
+	if info.DiffID, err = l.DiffID(); err != nil {
+		return info, err
+	}
+	if info.Size, err = l.Size(); err != nil {
+		return info, err
+	}
+	if info.MediaType, err = l.MediaType(); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// readLayer adds the entries of layer n to info and stacks them on those of
+// the layers below, applying whiteouts. The contents of the apk database
+// and of SBOMs are kept.
+func readLayer(l v1.Layer, n int, info *Layer, stacked map[string]File, contents map[string][]byte) error {
+	rc, err := l.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		p := strings.TrimSuffix(strings.TrimPrefix(path.Clean("/"+hdr.Name), "/"), "/")
+		dir, base := path.Split(p)
+		if base == ".wh..wh..opq" {
+			removeUnder(stacked, strings.TrimSuffix(dir, "/"), n)
+			continue
+		}
+		if name, ok := strings.CutPrefix(base, ".wh."); ok {
+			removed := path.Join(dir, name)
+			delete(stacked, removed)
+			removeUnder(stacked, removed, n+1)
+			continue
+		}
+
+		f := File{
+			Path:     p,
+			Mode:     hdr.FileInfo().Mode(),
+			Size:     hdr.Size,
+			Linkname: hdr.Linkname,
+			UID:      hdr.Uid,
+			GID:      hdr.Gid,
+			Layer:    n,
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			var r io.Reader = tr
+			var buf bytes.Buffer
+			if p == installedPath || path.Dir(p) == sbomDir {
+				r = io.TeeReader(tr, &buf)
+			}
+			if _, err := io.Copy(h, r); err != nil {
+				return err
+			}
+			f.Digest = hex.EncodeToString(h.Sum(nil))
+			if buf.Len() != 0 {
+				contents[p] = buf.Bytes()
+			}
+		}
+		info.Files = append(info.Files, f)
+		stacked[p] = f
+	}
+}
+
+// removeUnder removes the entries below dir that come from layers before
+// layer n.
+func removeUnder(stacked map[string]File, dir string, n int) {
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+	for p, f := range stacked {
+		if strings.HasPrefix(p, prefix) && p != dir && f.Layer < n {
+			delete(stacked, p)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reader
+
+import (
+	"archive/tar"
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+const installed = `P:busybox
+V:1.37.0-r0
+A:x86_64
+F:bin
+R:busybox
+
+P:ca-certificates-bundle
+V:20241121-r1
+A:x86_64
+
+`
+
+type entry struct {
+	name, contents string
+	dir            bool
+}
+
+func testLayer(t *testing.T, entries ...entry) v1.Layer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.contents)), Typeflag: tar.TypeReg}
+		if e.dir {
+			hdr = &tar.Header{Name: e.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	l, err := tarball.LayerFromReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	return l
+}
+
+func testImage(t *testing.T, arch string) v1.Image {
+	img, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{Architecture: arch, OS: "linux"})
+	require.NoError(t, err)
+	img, err = mutate.AppendLayers(img,
+		testLayer(t,
+			entry{name: "etc", dir: true},
+			entry{name: "etc/motd", contents: "welcome"},
+			entry{name: "usr/lib/apk/db/installed", contents: installed},
+			entry{name: "var/lib/db/sbom/busybox-1.37.0-r0.spdx.json", contents: `{"spdxVersion":"SPDX-2.3"}`},
+		),
+		testLayer(t,
+			entry{name: "etc/.wh.motd"},
+			entry{name: "etc/issue", contents: arch},
+		),
+	)
+	require.NoError(t, err)
+	return img
+}
+
+func TestImage(t *testing.T) {
+	img := FromImage(testImage(t, "amd64"))
+
+	pkgs, err := img.Packages()
+	require.NoError(t, err)
+	require.Len(t, pkgs, 2)
+	require.Equal(t, "busybox", pkgs[0].Name)
+	require.Equal(t, "1.37.0-r0", pkgs[0].Version)
+
+	files, err := img.Files()
+	require.NoError(t, err)
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	require.Equal(t, []string{
+		"etc",
+		"etc/issue",
+		"usr/lib/apk/db/installed",
+		"var/lib/db/sbom/busybox-1.37.0-r0.spdx.json",
+	}, paths)
+	require.Equal(t, 1, files[1].Layer)
+
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 2)
+	require.Len(t, layers[0].Files, 4)
+	require.Equal(t, "etc/motd", layers[0].Files[1].Path)
+
+	sboms, err := img.SBOMs()
+	require.NoError(t, err)
+	require.Contains(t, sboms, "var/lib/db/sbom/busybox-1.37.0-r0.spdx.json")
+}
+
+func TestOpen(t *testing.T) {
+	amd64, arm64 := testImage(t, "amd64"), testImage(t, "arm64")
+
+	// A tarball as written by apko build, with one tag per architecture.
+	tb := filepath.Join(t.TempDir(), "image.tar")
+	tagged := map[name.Tag]v1.Image{}
+	for arch, img := range map[string]v1.Image{"amd64": amd64, "arm64": arm64} {
+		tag, err := name.NewTag("example.com/image:latest-" + arch)
+		require.NoError(t, err)
+		tagged[tag] = img
+	}
+	require.NoError(t, tarball.MultiWriteToFile(tb, tagged))
+
+	// An OCI layout, with the index nested as apko build writes it.
+	dir := t.TempDir()
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+	_, err := layout.Write(dir, mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: idx}))
+	require.NoError(t, err)
+
+	for _, ref := range []string{tb, dir} {
+		img, err := Open(ref, types.ParseArchitecture("arm64"))
+		require.NoError(t, err)
+		cfg, err := img.Config()
+		require.NoError(t, err)
+		require.Equal(t, "arm64", cfg.Architecture)
+	}
+
+	_, err = Open(dir, types.ParseArchitecture("riscv64"))
+	require.ErrorContains(t, err, "no image for riscv64")
+}
@@ -0,0 +1,128 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package requestid tags the HTTP requests of a build with an ID, so that
+// they can be found in the access logs of the repositories and registries
+// they go to, and joined with the logs of the build.
+package requestid
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultHeader is the header the ID is sent in unless configured otherwise.
+const DefaultHeader = "X-Request-ID"
+
+// Config says which ID to send with requests, and in which header.
+type Config struct {
+	// ID is sent with every request. Nothing is sent when it is empty.
+	ID string
+	// Header is the header ID is sent in, DefaultHeader if empty.
+	Header string
+	// Hosts maps hostnames to the header to send ID in for requests to
+	// them instead. An empty header sends no ID to the host.
+	Hosts map[string]string
+}
+
+// New returns a random ID read from r, such as the Random of the build
+// options.
+func New(r io.Reader) (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", fmt.Errorf("generating request ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ParseHeaders parses header settings in the form of the --request-id-header
+// flag: "Header" sets the header for all hosts, and "host=Header" the one
+// for requests to host.
+func ParseHeaders(specs []string) (string, map[string]string, error) {
+	var header string
+	hosts := map[string]string{}
+	for _, s := range specs {
+		host, h, ok := strings.Cut(s, "=")
+		if !ok {
+			if header != "" {
+				return "", nil, fmt.Errorf("request ID header set twice: %q and %q", header, s)
+			}
+			header = s
+			continue
+		}
+		if host == "" {
+			return "", nil, fmt.Errorf("request ID header %q has no host", s)
+		}
+		hosts[host] = h
+	}
+	return header, hosts, nil
+}
+
+// header returns the header to send the ID in to host, if any.
+func (c Config) header(host string) string {
+	if c.ID == "" {
+		return ""
+	}
+	if h, ok := c.Hosts[host]; ok {
+		return h
+	}
+	if c.Header == "" {
+		return DefaultHeader
+	}
+	return c.Header
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx in which requests are tagged as c says,
+// by transports from NewTransport.
+func WithContext(ctx context.Context, c Config) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the Config of ctx, if it has one.
+func FromContext(ctx context.Context) (Config, bool) {
+	c, ok := ctx.Value(contextKey{}).(Config)
+	return c, ok
+}
+
+type transport struct {
+	inner    http.RoundTripper
+	fallback Config
+}
+
+// NewTransport returns a transport that sends the ID of the Config in the
+// context of each request, or else of fallback, with it.
+func NewTransport(inner http.RoundTripper, fallback Config) http.RoundTripper {
+	return &transport{inner: inner, fallback: fallback}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, ok := FromContext(req.Context())
+	if !ok {
+		c = t.fallback
+	}
+	h := c.header(req.URL.Hostname())
+	if h == "" || req.Header.Get(h) != "" {
+		return t.inner.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it is given.
+	req = req.Clone(req.Context())
+	req.Header.Set(h, c.ID)
+	return t.inner.RoundTrip(req)
+}
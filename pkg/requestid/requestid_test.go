@@ -0,0 +1,120 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestTransport(t *testing.T) {
+	var got http.Header
+	inner := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Header
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	hosts := map[string]string{"packages.example.com": "X-Trace", "quiet.example.com": ""}
+	fallback := Config{ID: "fallback", Hosts: hosts}
+	tr := NewTransport(inner, fallback)
+
+	for _, tc := range []struct {
+		name   string
+		ctx    context.Context
+		url    string
+		header string
+		want   string
+	}{
+		{"fallback", context.Background(), "https://example.com/", DefaultHeader, "fallback"},
+		{"fallback host", context.Background(), "https://packages.example.com:8443/", "X-Trace", "fallback"},
+		{"context", WithContext(context.Background(), Config{ID: "build", Header: "X-Build"}), "https://example.com/", "X-Build", "build"},
+		{"context host", WithContext(context.Background(), Config{ID: "build", Header: "X-Build", Hosts: hosts}), "https://packages.example.com/", "X-Trace", "build"},
+		{"no host header", context.Background(), "https://quiet.example.com/", "", ""},
+		{"disabled", WithContext(context.Background(), Config{}), "https://example.com/", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(tc.ctx, http.MethodGet, tc.url, nil)
+			require.NoError(t, err)
+			resp, err := tr.RoundTrip(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			require.Empty(t, req.Header, "request was modified")
+			if tc.header == "" {
+				require.Empty(t, got)
+				return
+			}
+			require.Len(t, got, 1)
+			require.Equal(t, tc.want, got.Get(tc.header))
+		})
+	}
+}
+
+func TestTransportKeepsHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get(DefaultHeader)))
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: NewTransport(http.DefaultTransport, Config{ID: "fallback"})}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set(DefaultHeader, "caller")
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "caller", string(b))
+}
+
+func TestParseHeaders(t *testing.T) {
+	header, hosts, err := ParseHeaders([]string{"X-Trace", "cgr.dev=X-Cgr", "quiet.example.com="})
+	require.NoError(t, err)
+	require.Equal(t, "X-Trace", header)
+	require.Equal(t, map[string]string{"cgr.dev": "X-Cgr", "quiet.example.com": ""}, hosts)
+
+	_, _, err = ParseHeaders([]string{"X-One", "X-Two"})
+	require.Error(t, err)
+	_, _, err = ParseHeaders([]string{"=X-Trace"})
+	require.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	a, err := New(rand.Reader)
+	require.NoError(t, err)
+	b, err := New(rand.Reader)
+	require.NoError(t, err)
+	require.Len(t, a, 32)
+	require.NotEqual(t, a, b)
+
+	fixed, err := New(bytes.NewReader(make([]byte, 16)))
+	require.NoError(t, err)
+	require.Equal(t, "00000000000000000000000000000000", fixed)
+
+	_, err = New(bytes.NewReader(nil))
+	require.Error(t, err)
+}
@@ -0,0 +1,365 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cyclonedx generates CycloneDX 1.5 SBOMs in JSON.
+package cyclonedx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/uuid"
+	purl "github.com/package-url/packageurl-go"
+	"sigs.k8s.io/release-utils/version"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/sbom/options"
+)
+
+const (
+	specVersion = "1.5"
+	// filteredProperty marks packages of which only some files were
+	// installed, as the SPDX generator does with a comment.
+	filteredProperty = "dev.chainguard.apko:filtered"
+	// layerSourceAnnotation mirrors build.LayerSourceAnnotation, which
+	// can't be imported from here.
+	layerSourceAnnotation = "dev.chainguard.apko.layer.source"
+)
+
+type CycloneDX struct {
+	fs apkfs.FullFS
+}
+
+func New(fs apkfs.FullFS) CycloneDX {
+	return CycloneDX{fs}
+}
+
+func (cx *CycloneDX) Key() string {
+	return "cyclonedx"
+}
+
+func (cx *CycloneDX) Ext() string {
+	return "cdx.json"
+}
+
+// Generate writes a CycloneDX SBOM of the image in path. The image is the
+// metadata component, and depends on its operating system, its layers and
+// every installed package.
+func (cx *CycloneDX) Generate(_ context.Context, opts *options.Options, path string) error {
+	image := imageComponent(opts)
+	doc := newDocument(opts, image)
+
+	osComponent := Component{
+		BOMRef:      "os:" + opts.OS.ID,
+		Type:        "operating-system",
+		Name:        opts.OS.ID,
+		Version:     opts.OS.Version,
+		Description: "Operating System",
+		Supplier:    supplier(opts),
+	}
+	doc.Components = append(doc.Components, osComponent)
+	dependsOn := []string{osComponent.BOMRef}
+
+	for _, layer := range opts.ImageInfo.Layers {
+		if layer.Digest == (v1.Hash{}) {
+			continue
+		}
+		description := "apko operating system layer"
+		if source, ok := layer.Annotations[layerSourceAnnotation]; ok {
+			description = "layer added to the apko build from " + source
+		}
+		c := Component{
+			Type:        "container",
+			Name:        layer.Digest.String(),
+			Version:     opts.OS.Version,
+			Description: description,
+			Supplier:    supplier(opts),
+			Hashes:      []Hash{{Algorithm: "SHA-256", Content: layer.Digest.Hex}},
+			PURL: purl.NewPackageURL(
+				purl.TypeOCI, "", opts.ImagePurlName(), layer.Digest.String(), nil, "",
+			).String() + "?" + opts.LayerPurlQualifiers(layer).String(),
+		}
+		c.BOMRef = c.PURL
+		doc.Components = append(doc.Components, c)
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+
+	seen := map[string]struct{}{}
+	for _, pkg := range opts.Packages {
+		c := packageComponent(opts, pkg)
+		if _, ok := seen[c.BOMRef]; ok {
+			continue
+		}
+		seen[c.BOMRef] = struct{}{}
+		doc.Components = append(doc.Components, c)
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+
+	doc.Dependencies = []Dependency{{Ref: image.BOMRef, DependsOn: dependsOn}}
+
+	if err := renderDoc(doc, path); err != nil {
+		return fmt.Errorf("rendering document: %w", err)
+	}
+	return nil
+}
+
+// GenerateIndex writes a CycloneDX SBOM of an image index in path, listing
+// the image of each architecture as a component of it.
+func (cx *CycloneDX) GenerateIndex(opts *options.Options, path string) error {
+	if len(opts.ImageInfo.Images) == 0 {
+		return errors.New("unable to render index sbom, no architecture images found")
+	}
+
+	digest := opts.ImageInfo.IndexDigest.String()
+	index := Component{
+		Type:        "container",
+		Name:        opts.IndexPurlName(),
+		Version:     digest,
+		Description: "Multi-arch image index",
+		Supplier:    supplier(opts),
+		Hashes:      []Hash{{Algorithm: "SHA-256", Content: opts.ImageInfo.IndexDigest.Hex}},
+		PURL: purl.NewPackageURL(
+			purl.TypeOCI, "", opts.IndexPurlName(), digest, nil, "",
+		).String() + "?" + opts.IndexPurlQualifiers().String(),
+		ExternalReferences: vcsReferences(opts),
+	}
+	index.BOMRef = index.PURL
+	doc := newDocument(opts, index)
+
+	dependsOn := make([]string, 0, len(opts.ImageInfo.Images))
+	for i, info := range opts.ImageInfo.Images {
+		c := Component{
+			Type:     "container",
+			Name:     opts.ImagePurlName(),
+			Version:  info.Digest.String(),
+			Supplier: supplier(opts),
+			Hashes:   []Hash{{Algorithm: "SHA-256", Content: info.Digest.Hex}},
+			PURL: purl.NewPackageURL(
+				purl.TypeOCI, "", opts.ImagePurlName(), info.Digest.String(), nil, "",
+			).String() + "?" + opts.ArchImagePurlQualifiers(&opts.ImageInfo.Images[i]).String(),
+		}
+		c.BOMRef = c.PURL
+		if info.SBOMDigest != "" {
+			c.Properties = append(c.Properties, Property{Name: "dev.chainguard.apko:sbom-sha256", Value: info.SBOMDigest})
+		}
+		doc.Components = append(doc.Components, c)
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+	doc.Dependencies = []Dependency{{Ref: index.BOMRef, DependsOn: dependsOn}}
+
+	if err := renderDoc(doc, path); err != nil {
+		return fmt.Errorf("rendering document: %w", err)
+	}
+	return nil
+}
+
+// newDocument returns a document describing subject, with a serial number
+// derived from it so that the same image always has the same SBOM.
+func newDocument(opts *options.Options, subject Component) *Document {
+	tools := []Component{{
+		Type:    "application",
+		Name:    "apko",
+		Version: version.GetVersionInfo().GitVersion,
+	}}
+	if b := opts.Builder; b.ID != "" {
+		tools = append(tools, Component{Type: "application", Name: b.ID, Version: b.Version})
+	}
+	return &Document{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  specVersion,
+		SerialNumber: uuid.NewSHA1(uuid.NameSpaceURL, []byte(subject.BOMRef)).URN(),
+		Version:      1,
+		Metadata: Metadata{
+			Timestamp: opts.ImageInfo.SourceDateEpoch.UTC().Format(time.RFC3339),
+			Tools:     Tools{Components: tools},
+			Component: subject,
+			Supplier:  supplier(opts),
+		},
+		Components: []Component{},
+	}
+}
+
+func imageComponent(opts *options.Options) Component {
+	digest := opts.ImageInfo.ImageDigest
+	c := Component{
+		Type:               "container",
+		Name:               opts.ImagePurlName(),
+		Version:            digest,
+		Description:        "apko container image",
+		Supplier:           supplier(opts),
+		ExternalReferences: vcsReferences(opts),
+	}
+	if digest == "" {
+		// Without a digest, as when only a layer is built, there is no
+		// image to refer to.
+		c.BOMRef = "image"
+		return c
+	}
+	c.Hashes = []Hash{{Algorithm: "SHA-256", Content: strings.TrimPrefix(digest, "sha256:")}}
+	c.PURL = purl.NewPackageURL(
+		purl.TypeOCI, "", opts.ImagePurlName(), digest, nil, "",
+	).String() + "?" + opts.ImagePurlQualifiers().String()
+	c.BOMRef = c.PURL
+	return c
+}
+
+func packageComponent(opts *options.Options, pkg *apk.InstalledPackage) Component {
+	qualifiers := map[string]string{}
+	if pkg.Arch != "" {
+		qualifiers["arch"] = pkg.Arch
+	}
+	if opts.OS.ID != "" && opts.OS.Version != "" {
+		qualifiers["distro"] = opts.OS.ID + "-" + opts.OS.Version
+	}
+	c := Component{
+		Type:        "library",
+		Name:        pkg.Name,
+		Version:     pkg.Version,
+		Description: pkg.Description,
+		Supplier:    supplier(opts),
+		PURL: purl.NewPackageURL(
+			purl.TypeApk, opts.OS.ID, pkg.Name, pkg.Version, purl.QualifiersFromMap(qualifiers), "",
+		).String(),
+	}
+	c.BOMRef = c.PURL
+	if pkg.License != "" {
+		c.Licenses = []License{{Expression: pkg.License}}
+	}
+	if pkg.URL != "" {
+		c.ExternalReferences = []ExternalReference{{Type: "website", URL: pkg.URL}}
+	}
+	if pkg.Origin != "" && pkg.Origin != pkg.Name {
+		c.Properties = append(c.Properties, Property{Name: "dev.chainguard.apko:origin", Value: pkg.Origin})
+	}
+	if f, ok := opts.FileFilters[pkg.Name]; ok {
+		c.Properties = append(c.Properties, Property{Name: filteredProperty, Value: filterValue(f)})
+	}
+	return c
+}
+
+func filterValue(f types.PackageFilter) string {
+	var parts []string
+	if len(f.Include) != 0 {
+		parts = append(parts, "include "+strings.Join(f.Include, ", "))
+	}
+	if len(f.Exclude) != 0 {
+		parts = append(parts, "exclude "+strings.Join(f.Exclude, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func vcsReferences(opts *options.Options) []ExternalReference {
+	if opts.ImageInfo.VCSUrl == "" {
+		return nil
+	}
+	return []ExternalReference{{Type: "vcs", URL: opts.ImageInfo.VCSUrl}}
+}
+
+func supplier(opts *options.Options) *Organization {
+	if opts.OS.Name == "" {
+		return nil
+	}
+	return &Organization{Name: opts.OS.Name}
+}
+
+// renderDoc marshals a document to json and writes it to disk
+func renderDoc(doc *Document, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("opening SBOM path %s for writing: %w", path, err)
+	}
+	defer out.Close()
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(true)
+
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encoding cyclonedx sbom: %w", err)
+	}
+	return nil
+}
+
+type Document struct {
+	BOMFormat    string       `json:"bomFormat"`
+	SpecVersion  string       `json:"specVersion"`
+	SerialNumber string       `json:"serialNumber,omitempty"`
+	Version      int          `json:"version"`
+	Metadata     Metadata     `json:"metadata"`
+	Components   []Component  `json:"components"`
+	Dependencies []Dependency `json:"dependencies,omitempty"`
+}
+
+type Metadata struct {
+	Timestamp string        `json:"timestamp,omitempty"`
+	Tools     Tools         `json:"tools"`
+	Component Component     `json:"component"`
+	Supplier  *Organization `json:"supplier,omitempty"`
+}
+
+type Tools struct {
+	Components []Component `json:"components"`
+}
+
+type Component struct {
+	BOMRef             string              `json:"bom-ref,omitempty"`
+	Type               string              `json:"type"`
+	Name               string              `json:"name"`
+	Version            string              `json:"version,omitempty"`
+	Description        string              `json:"description,omitempty"`
+	Supplier           *Organization       `json:"supplier,omitempty"`
+	Hashes             []Hash              `json:"hashes,omitempty"`
+	Licenses           []License           `json:"licenses,omitempty"`
+	PURL               string              `json:"purl,omitempty"`
+	ExternalReferences []ExternalReference `json:"externalReferences,omitempty"`
+	Properties         []Property          `json:"properties,omitempty"`
+}
+
+type Organization struct {
+	Name string `json:"name"`
+}
+
+type Hash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+// License is a license choice. apk packages declare SPDX license
+// expressions, so they are recorded as such rather than as single IDs.
+type License struct {
+	Expression string `json:"expression"`
+}
+
+type ExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Dependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cyclonedx
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/sbom/options"
+)
+
+var testOpts = &options.Options{
+	ImageInfo: options.ImageInfo{
+		ImageDigest: "sha256:4c7e3cbc7ba65d1b2a1d3e2f1e1a0a3c7d9a7e5d8e5b1bb8a7f2c0d6c1f0e9a8",
+		Layers: []v1.Descriptor{{
+			Digest: v1.Hash{Algorithm: "sha256", Hex: "9a2f0a6e44f3c54b4d2b16e0bc1f1f9d4a0fbc0d1c7a1f6c0b0e4b1c2f4a5d6e"},
+		}},
+		Arch:            types.ParseArchitecture("amd64"),
+		SourceDateEpoch: time.Unix(1700000000, 0),
+	},
+	OS: options.OSInfo{
+		Name:    "Wolfi",
+		ID:      "wolfi",
+		Version: "20230201",
+	},
+	Packages: []*apk.InstalledPackage{{
+		Package: apk.Package{
+			Name:        "musl",
+			Version:     "1.2.2-r7",
+			Arch:        "x86_64",
+			Description: "the musl c library (libc) implementation",
+			License:     "MIT",
+			Origin:      "musl",
+			URL:         "https://musl.libc.org/",
+		},
+	}, {
+		Package: apk.Package{
+			Name:    "libcrypto3",
+			Version: "3.1.4-r0",
+			Arch:    "x86_64",
+			License: "Apache-2.0",
+			Origin:  "openssl",
+		},
+	}},
+	FileFilters: map[string]types.PackageFilter{
+		"musl": {Exclude: []string{"usr/share/**"}},
+	},
+}
+
+func generate(t *testing.T, opts *options.Options) Document {
+	t.Helper()
+	cx := New(apkfs.NewMemFS())
+	path := filepath.Join(t.TempDir(), "sbom."+cx.Ext())
+	require.NoError(t, cx.Generate(t.Context(), opts, path))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var doc Document
+	require.NoError(t, json.Unmarshal(b, &doc))
+	return doc
+}
+
+func TestGenerate(t *testing.T) {
+	doc := generate(t, testOpts)
+
+	require.Equal(t, "CycloneDX", doc.BOMFormat)
+	require.Equal(t, "1.5", doc.SpecVersion)
+	require.Equal(t, "2023-11-14T22:13:20Z", doc.Metadata.Timestamp)
+	require.Equal(t, "container", doc.Metadata.Component.Type)
+	require.Equal(t, "pkg:oci/image@sha256%3A4c7e3cbc7ba65d1b2a1d3e2f1e1a0a3c7d9a7e5d8e5b1bb8a7f2c0d6c1f0e9a8?arch=amd64&os=linux", doc.Metadata.Component.PURL)
+
+	// The serial number only depends on the image.
+	require.Equal(t, doc.SerialNumber, generate(t, testOpts).SerialNumber)
+
+	byName := map[string]Component{}
+	for _, c := range doc.Components {
+		byName[c.Name] = c
+	}
+	require.Equal(t, "operating-system", byName["wolfi"].Type)
+	require.Equal(t, "container", byName["sha256:9a2f0a6e44f3c54b4d2b16e0bc1f1f9d4a0fbc0d1c7a1f6c0b0e4b1c2f4a5d6e"].Type)
+
+	musl := byName["musl"]
+	require.Equal(t, "library", musl.Type)
+	require.Equal(t, "pkg:apk/wolfi/musl@1.2.2-r7?arch=x86_64&distro=wolfi-20230201", musl.PURL)
+	require.Equal(t, []License{{Expression: "MIT"}}, musl.Licenses)
+	require.Equal(t, []ExternalReference{{Type: "website", URL: "https://musl.libc.org/"}}, musl.ExternalReferences)
+	require.Equal(t, []Property{{Name: filteredProperty, Value: "exclude usr/share/**"}}, musl.Properties)
+
+	require.Equal(t, []Property{{Name: "dev.chainguard.apko:origin", Value: "openssl"}}, byName["libcrypto3"].Properties)
+
+	require.Len(t, doc.Dependencies, 1)
+	require.Equal(t, doc.Metadata.Component.BOMRef, doc.Dependencies[0].Ref)
+	require.Len(t, doc.Dependencies[0].DependsOn, len(doc.Components))
+}
+
+func TestGenerateIndex(t *testing.T) {
+	digest := v1.Hash{Algorithm: "sha256", Hex: "1111111111111111111111111111111111111111111111111111111111111111"}
+	opts := &options.Options{
+		ImageInfo: options.ImageInfo{
+			IndexDigest: v1.Hash{Algorithm: "sha256", Hex: "2222222222222222222222222222222222222222222222222222222222222222"},
+			Images: []options.ArchImageInfo{{
+				Digest: digest,
+				Arch:   types.ParseArchitecture("arm64"),
+			}},
+			VCSUrl: "https://github.com/chainguard-dev/apko@0123456789abcdef",
+		},
+	}
+
+	cx := New(nil)
+	path := filepath.Join(t.TempDir(), "sbom-index."+cx.Ext())
+	require.NoError(t, cx.GenerateIndex(opts, path))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var doc Document
+	require.NoError(t, json.Unmarshal(b, &doc))
+
+	require.Equal(t, "index", doc.Metadata.Component.Name)
+	require.Equal(t, []ExternalReference{{Type: "vcs", URL: opts.ImageInfo.VCSUrl}}, doc.Metadata.Component.ExternalReferences)
+	require.Len(t, doc.Components, 1)
+	require.Equal(t, digest.String(), doc.Components[0].Version)
+	require.Equal(t, []Dependency{{Ref: doc.Metadata.Component.BOMRef, DependsOn: []string{doc.Components[0].BOMRef}}}, doc.Dependencies)
+
+	require.Error(t, cx.GenerateIndex(&options.Options{}, path))
+}
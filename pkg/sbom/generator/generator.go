@@ -16,13 +16,19 @@ package generator
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 
+	"chainguard.dev/apko/pkg/sbom/generator/cyclonedx"
 	"chainguard.dev/apko/pkg/sbom/generator/spdx"
 	"chainguard.dev/apko/pkg/sbom/options"
 )
 
+// Generator writes an SBOM in one format. Generate is given the packages,
+// layers and image metadata of one image in opts and writes its SBOM to the
+// given path; GenerateIndex does the same for an image index.
 type Generator interface {
 	Key() string
 	Ext() string
@@ -30,11 +36,88 @@ type Generator interface {
 	GenerateIndex(*options.Options, string) error
 }
 
+// Preparer is implemented by generators that can do part of their work
+// before the image is assembled. Prepare is given the packages and OS of the
+// image in opts, but not its layers or digest; Generate is later called with
+// the same opts once those are known.
+type Preparer interface {
+	Prepare(ctx context.Context, opts *options.Options) error
+}
+
+// Factory creates a Generator that reads the image filesystem fsys. fsys is
+// nil when the generator is only used for index SBOMs.
+type Factory func(fsys apkfs.FullFS) Generator
+
+// Enricher amends the SBOM inputs before any generator sees them, e.g. to
+// add supplier information to packages or to drop internal ones.
+type Enricher interface {
+	Enrich(ctx context.Context, opts *options.Options) error
+}
+
+var (
+	registryMu sync.RWMutex
+	factories  = map[string]Factory{}
+	enrichers  []Enricher
+)
+
+// Register makes an SBOM format available under key, so it can be requested
+// like the built-in formats (e.g. with --sbom-formats). It panics if key is
+// already taken, in the manner of database/sql.Register.
+func Register(key string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if f == nil {
+		panic("sbom generator: Register factory is nil")
+	}
+	if _, dup := factories[key]; dup || key == spdxKey || key == cyclonedxKey {
+		panic(fmt.Sprintf("sbom generator: Register called twice for format %q", key))
+	}
+	factories[key] = f
+}
+
+// RegisterEnricher adds an Enricher that runs, in registration order, before
+// every SBOM is generated.
+func RegisterEnricher(e Enricher) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	enrichers = append(enrichers, e)
+}
+
+// Enrich runs the registered enrichers on opts.
+func Enrich(ctx context.Context, opts *options.Options) error {
+	registryMu.RLock()
+	es := enrichers
+	registryMu.RUnlock()
+
+	for _, e := range es {
+		if err := e.Enrich(ctx, opts); err != nil {
+			return fmt.Errorf("enriching sbom: %w", err)
+		}
+	}
+	return nil
+}
+
+const (
+	spdxKey      = "spdx"
+	cyclonedxKey = "cyclonedx"
+)
+
 func Generators(fsys apkfs.FullFS) map[string]Generator {
 	generators := map[string]Generator{}
 
 	sx := spdx.New(fsys)
 	generators[sx.Key()] = &sx
 
+	cx := cyclonedx.New(fsys)
+	generators[cx.Key()] = &cx
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for key, f := range factories {
+		generators[key] = f(fsys)
+	}
+
 	return generators
 }
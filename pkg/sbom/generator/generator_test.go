@@ -0,0 +1,60 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/sbom/options"
+)
+
+type fakeGenerator struct{}
+
+func (fakeGenerator) Key() string { return "fake" }
+func (fakeGenerator) Ext() string { return "fake.json" }
+func (fakeGenerator) Generate(context.Context, *options.Options, string) error {
+	return nil
+}
+func (fakeGenerator) GenerateIndex(*options.Options, string) error { return nil }
+
+type renameOS string
+
+func (r renameOS) Enrich(_ context.Context, opts *options.Options) error {
+	opts.OS.Name = string(r)
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("fake", func(apkfs.FullFS) Generator { return fakeGenerator{} })
+
+	gens := Generators(nil)
+	require.Contains(t, gens, "spdx")
+	require.Contains(t, gens, "fake")
+
+	require.Panics(t, func() { Register("fake", func(apkfs.FullFS) Generator { return fakeGenerator{} }) })
+	require.Panics(t, func() { Register("spdx", func(apkfs.FullFS) Generator { return fakeGenerator{} }) })
+}
+
+func TestEnrich(t *testing.T) {
+	RegisterEnricher(renameOS("Example OS"))
+
+	opts := &options.Options{}
+	require.NoError(t, Enrich(context.Background(), opts))
+	require.Equal(t, "Example OS", opts.OS.Name)
+}
@@ -21,17 +21,21 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/chainguard-dev/clog"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	purl "github.com/package-url/packageurl-go"
+	"golang.org/x/sync/errgroup"
 	"sigs.k8s.io/release-utils/version"
 
 	"chainguard.dev/apko/pkg/apk/apk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/sbom/options"
 )
 
@@ -43,14 +47,28 @@ const (
 	ExtRefPackageManager = "PACKAGE-MANAGER"
 	ExtRefTypePurl       = "purl"
 	apkSBOMdir           = "/var/lib/db/sbom"
+	// layerSourceAnnotation mirrors build.LayerSourceAnnotation, which
+	// can't be imported from here.
+	layerSourceAnnotation = "dev.chainguard.apko.layer.source"
 )
 
 type SPDX struct {
 	fs apkfs.FullFS
+
+	// internal holds the package SBOMs parsed by Prepare, by path.
+	internal *sync.Map
+}
+
+// internalSBOM is a package SBOM parsed by Prepare, along with the supplier
+// its missing fields were filled in with.
+type internalSBOM struct {
+	supplier string
+	doc      *Document
+	err      error
 }
 
 func New(fs apkfs.FullFS) SPDX {
-	return SPDX{fs}
+	return SPDX{fs: fs, internal: &sync.Map{}}
 }
 
 func (sx *SPDX) Key() string {
@@ -94,11 +112,8 @@ func (sx *SPDX) Generate(ctx context.Context, opts *options.Options, path string
 		Name:    documentName,
 		Version: "SPDX-2.3",
 		CreationInfo: CreationInfo{
-			Created: opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
-			Creators: []string{
-				fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion),
-				"Organization: Chainguard, Inc",
-			},
+			Created:            opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
+			Creators:           creators(opts),
 			LicenseListVersion: "3.16",
 		},
 		DataLicense:    "CC0-1.0",
@@ -208,7 +223,7 @@ func (sx *SPDX) ProcessInternalApkSBOM(opts *options.Options, doc *Document, ipk
 		return nil
 	}
 
-	apkSBOMDoc, err := sx.ParseInternalSBOM(opts, path)
+	apkSBOMDoc, err := sx.parsedInternalSBOM(opts, path)
 	if err != nil {
 		// TODO: Log error parsing apk SBOM
 		return nil
@@ -244,6 +259,16 @@ func (sx *SPDX) ProcessInternalApkSBOM(opts *options.Options, doc *Document, ipk
 		return fmt.Errorf("copying element: %w", err)
 	}
 
+	// The package's own SBOM describes all of its files; say which were
+	// left out of the image.
+	if f, ok := opts.FileFilters[ipkg.Name]; ok {
+		for i := range doc.Packages {
+			if _, ok := targetElementIDs[doc.Packages[i].ID]; ok {
+				doc.Packages[i].Comment = filterComment(f)
+			}
+		}
+	}
+
 	if err := mergeLicensingInfos(apkSBOMDoc, doc); err != nil {
 		return fmt.Errorf("merging LicensingInfos: %w", err)
 	}
@@ -319,6 +344,48 @@ func mergeLicensingInfos(sourceDoc, targetDoc *Document) error {
 	return nil
 }
 
+// Prepare parses the SBOMs the packages in opts ship, concurrently, so that
+// Generate does not have to. It needs neither the image layers nor its
+// digest, so it can run while the layers are still being compressed. Errors
+// are left for Generate to report.
+func (sx *SPDX) Prepare(ctx context.Context, opts *options.Options) error {
+	if sx.internal == nil {
+		sx.internal = &sync.Map{}
+	}
+	sup := supplier(opts)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for _, pkg := range opts.Packages {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			path, err := locateApkSBOM(sx.fs, pkg)
+			if err != nil || path == "" {
+				return nil
+			}
+			doc, err := sx.ParseInternalSBOM(opts, path)
+			sx.internal.Store(path, internalSBOM{supplier: sup, doc: doc, err: err})
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// parsedInternalSBOM returns the package SBOM at path as parsed by Prepare,
+// or parses it if Prepare did not, or did so for a different supplier.
+func (sx *SPDX) parsedInternalSBOM(opts *options.Options, path string) (*Document, error) {
+	if sx.internal != nil {
+		if v, ok := sx.internal.Load(path); ok {
+			if p := v.(internalSBOM); p.supplier == supplier(opts) {
+				return p.doc, p.err
+			}
+		}
+	}
+	return sx.ParseInternalSBOM(opts, path)
+}
+
 // ParseInternalSBOM opens an SBOM inside apks and
 func (sx *SPDX) ParseInternalSBOM(opts *options.Options, path string) (*Document, error) {
 	internalSBOM := &Document{}
@@ -414,12 +481,21 @@ func (sx *SPDX) layerPackage(opts *options.Options, layer v1.Descriptor) *Packag
 	layerPackageName := hashToString(layer.Digest)
 	mainPkgID := stringToIdentifier(layerPackageName)
 
+	// Layers the build was handed rather than built carry their source.
+	description := "apko operating system layer"
+	sourceInfo := ""
+	if source, ok := layer.Annotations[layerSourceAnnotation]; ok {
+		description = "layer added to the apko build"
+		sourceInfo = "added from " + source
+	}
+
 	return &Package{
 		ID:               fmt.Sprintf("SPDXRef-Package-%s", mainPkgID),
 		Name:             layerPackageName,
 		Version:          opts.OS.Version,
 		FilesAnalyzed:    false,
-		Description:      "apko operating system layer",
+		Description:      description,
+		SourceInfo:       sourceInfo,
 		DownloadLocation: NOASSERTION,
 		Originator:       "",
 		Supplier:         supplier(opts),
@@ -463,6 +539,22 @@ type LicensingInfo struct {
 	ExtractedText string `json:"extractedText"`
 }
 
+// creators lists apko and, if one is configured, the builder that ran it.
+func creators(opts *options.Options) []string {
+	c := []string{
+		fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion),
+		"Organization: Chainguard, Inc",
+	}
+	if b := opts.Builder; b.ID != "" {
+		if b.Version != "" {
+			c = append(c, fmt.Sprintf("Tool: %s (%s)", b.ID, b.Version))
+		} else {
+			c = append(c, "Tool: "+b.ID)
+		}
+	}
+	return c
+}
+
 type CreationInfo struct {
 	Created            string   `json:"created"` // Date
 	Creators           []string `json:"creators"`
@@ -499,6 +591,19 @@ type Package struct {
 	Checksums        []Checksum               `json:"checksums,omitempty"`
 	ExternalRefs     []ExternalRef            `json:"externalRefs,omitempty"`
 	VerificationCode *PackageVerificationCode `json:"packageVerificationCode,omitempty"`
+	Comment          string                   `json:"comment,omitempty"`
+}
+
+// filterComment describes the files of a package that were not installed.
+func filterComment(f types.PackageFilter) string {
+	var parts []string
+	if len(f.Include) != 0 {
+		parts = append(parts, "only files matching "+strings.Join(f.Include, ", ")+" installed")
+	}
+	if len(f.Exclude) != 0 {
+		parts = append(parts, "files matching "+strings.Join(f.Exclude, ", ")+" not installed")
+	}
+	return "Filtered by apko: " + strings.Join(parts, "; ")
 }
 
 type PackageVerificationCode struct {
@@ -535,11 +640,8 @@ func (sx *SPDX) GenerateIndex(opts *options.Options, path string) error {
 		Name:    documentName,
 		Version: "SPDX-2.3",
 		CreationInfo: CreationInfo{
-			Created: opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
-			Creators: []string{
-				fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion),
-				"Organization: Chainguard, Inc",
-			},
+			Created:            opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
+			Creators:           creators(opts),
 			LicenseListVersion: "3.16",
 		},
 		DataLicense:   "CC0-1.0",
@@ -239,6 +239,25 @@ func TestSPDX_Generate(t *testing.T) {
 					ids[p.ID] = struct{}{}
 				}
 			})
+
+			t.Run("prepared", func(t *testing.T) {
+				sx := New(fsys)
+				require.NoError(t, sx.Prepare(t.Context(), tt.opts))
+
+				// Generate must use what Prepare parsed, not the files.
+				for _, apkPkg := range tt.opts.Packages {
+					apkSBOMName := fmt.Sprintf("%s-%s.spdx.json", apkPkg.Name, apkPkg.Version)
+					require.NoError(t, fsys.WriteFile(path.Join(sbomDir, apkSBOMName), []byte("{"), 0644))
+				}
+
+				preparedPath := filepath.Join(t.TempDir(), imageSBOMName)
+				require.NoError(t, sx.Generate(t.Context(), tt.opts, preparedPath))
+				prepared, err := os.ReadFile(preparedPath)
+				require.NoError(t, err)
+				if diff := cmp.Diff(expected, prepared); diff != "" {
+					t.Errorf("Unexpected prepared image SBOM (-want, +got): \n%s", diff)
+				}
+			})
 		})
 	}
 }
@@ -338,3 +357,18 @@ func TestSourcePackage(t *testing.T) {
 	require.Equal(t, imagePackage.ID, doc.Relationships[0].Element)
 	require.Equal(t, doc.Packages[0].ID, doc.Relationships[0].Related)
 }
+
+func TestGenerateBuilder(t *testing.T) {
+	opts := *testOpts
+	opts.Builder = options.Builder{ID: "https://builder.example.com/apko", Version: "v1.2.3"}
+
+	sx := New(apkfs.NewMemFS())
+	path := filepath.Join(t.TempDir(), "sbom.spdx.json")
+	require.NoError(t, sx.Generate(t.Context(), &opts, path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	doc := &Document{}
+	require.NoError(t, json.Unmarshal(data, doc))
+	require.Contains(t, doc.CreationInfo.Creators, "Tool: https://builder.example.com/apko (v1.2.3)")
+}
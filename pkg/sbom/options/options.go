@@ -53,6 +53,22 @@ type Options struct {
 
 	// Packages is a list of packages which will be listed in the SBOM
 	Packages []*apk.InstalledPackage
+
+	// Builder identifies the service or machine that ran apko, if set
+	Builder Builder
+
+	// FileFilters are the filters applied to the files of packages, keyed
+	// by package name
+	FileFilters map[string]types.PackageFilter
+}
+
+// Builder is the identity of whatever ran the build, recorded alongside the
+// apko version so downstream policy can tell build environments apart.
+type Builder struct {
+	// ID is a URI naming the builder.
+	ID string
+	// Version is the builder's own version, if any.
+	Version string
 }
 
 type PurlQualifiers map[string]string
@@ -33,8 +33,6 @@ import (
 	"sync"
 	"time"
 
-	"golang.org/x/sys/unix"
-
 	"chainguard.dev/apko/pkg/apk/apk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 )
@@ -593,9 +591,9 @@ func (m *memFS) Mknod(path string, mode uint32, dev int) error {
 	}
 	anode.children[base] = &node{
 		name:      base,
-		mode:      fs.FileMode(mode) | os.ModeCharDevice | os.ModeDevice,
-		major:     unix.Major(uint64(dev)),
-		minor:     unix.Minor(uint64(dev)),
+		mode:      apkfs.MknodMode(mode),
+		major:     apkfs.Major(uint64(dev)),
+		minor:     apkfs.Minor(uint64(dev)),
 		xattrs:    map[string][]byte{},
 		hardlinks: map[string]*tar.Header{},
 		modTime:   anode.modTime,
@@ -617,10 +615,10 @@ func (m *memFS) Readnod(path string) (dev int, err error) {
 	if !ok {
 		return 0, fs.ErrNotExist
 	}
-	if anode.mode&os.ModeDevice != os.ModeDevice || anode.mode&os.ModeCharDevice != os.ModeCharDevice {
+	if anode.mode&(os.ModeDevice|os.ModeNamedPipe) == 0 {
 		return 0, fmt.Errorf("not a device")
 	}
-	return int(unix.Mkdev(anode.major, anode.minor)), nil
+	return int(apkfs.Mkdev(anode.major, anode.minor)), nil
 }
 
 func (m *memFS) Chmod(path string, perm fs.FileMode) error {
@@ -15,11 +15,14 @@
 package vcs
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -81,6 +84,46 @@ func ProbeDirFromPath(startingPath string) (string, error) {
 	return ProbeDirForVCSUrl(startingPath, toplevelDir)
 }
 
+// LastCommitTime returns the committer time of the most recent commit that
+// touched the file at path, which must be inside a Git repository.
+func LastCommitTime(path string) (time.Time, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot dereference relative path %s: %w", path, err)
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return time.Time{}, fmt.Errorf("resolving %s: %w", path, err)
+	}
+
+	repo, err := OpenRepository(filepath.Dir(abs), "/")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("opening git repository: %w", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("opening worktree: %w", err)
+	}
+	rel, err := filepath.Rel(wt.Filesystem.Root(), abs)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rel = filepath.ToSlash(rel)
+
+	iter, err := repo.Log(&git.LogOptions{FileName: &rel})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading history of %s: %w", rel, err)
+	}
+	defer iter.Close()
+
+	c, err := iter.Next()
+	if errors.Is(err, io.EOF) {
+		return time.Time{}, fmt.Errorf("%s has not been committed", rel)
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("reading history of %s: %w", rel, err)
+	}
+	return c.Committer.When.UTC(), nil
+}
+
 func getRemoteURL(repo *git.Repository, remoteName string) (string, error) {
 	if remoteName == "" {
 		remoteName = defaultRemoteName
@@ -20,7 +20,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/require"
 	"sigs.k8s.io/release-utils/tar"
 )
@@ -226,3 +229,34 @@ func TestGetRemoteURL(t *testing.T) {
 		require.Equal(t, tc.URL, url)
 	}
 }
+
+func TestLastCommitTime(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(name string, when time.Time) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(when.String()), 0o644))
+		_, err := wt.Add(name)
+		require.NoError(t, err)
+		sig := &object.Signature{Name: "test", Email: "test@example.com", When: when}
+		_, err = wt.Commit("update "+name, &git.CommitOptions{Author: sig, Committer: sig})
+		require.NoError(t, err)
+	}
+
+	configTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	commit("apko.yaml", configTime)
+	// A later commit that doesn't touch the config must not move its date.
+	commit("README.md", configTime.Add(time.Hour))
+
+	got, err := LastCommitTime(filepath.Join(dir, "apko.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, configTime, got)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new.yaml"), nil, 0o644))
+	_, err = LastCommitTime(filepath.Join(dir, "new.yaml"))
+	require.ErrorContains(t, err, "has not been committed")
+}